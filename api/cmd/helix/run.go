@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,6 +11,7 @@ import (
 
 	"github.com/helixml/helix/api/pkg/system"
 	"github.com/helixml/helix/api/pkg/types"
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
@@ -61,7 +61,10 @@ func newRunCmd() *cobra.Command {
 }
 
 func runCLI(cmd *cobra.Command, options *RunOptions) error {
-	system.SetupLogging()
+	err := system.SetupLogging(getDefaultLoggingOptions())
+	if err != nil {
+		return err
+	}
 
 	// Cleanup manager ensures that resources are freed before exiting:
 	cm := system.NewCleanupManager()
@@ -141,9 +144,9 @@ func runCLI(cmd *cobra.Command, options *RunOptions) error {
 		}
 		wtr, ok := rr["cli-"+id]
 		if ok {
-			log.Printf("Progress: %+v%%", wtr.Progress)
+			log.Info().Msgf("Progress: %+v%%", wtr.Progress)
 			if len(wtr.Files) > 0 {
-				log.Printf("File has been written: %s", wtr.Files[0][len("/app/sd-scripts/./output_images/"):])
+				log.Info().Msgf("File has been written: %s", wtr.Files[0][len("/app/sd-scripts/./output_images/"):])
 				return nil
 			}
 		}
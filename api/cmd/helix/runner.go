@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"time"
 
 	"github.com/helixml/helix/api/pkg/config"
@@ -41,8 +42,26 @@ func NewRunnerOptions() *RunnerOptions {
 			FilterModelName:              getDefaultServeOptionString("FILTER_MODEL_NAME", ""),
 			FilterMode:                   getDefaultServeOptionString("FILTER_MODE", ""),
 			AllowMultipleCopies:          getDefaultServeOptionBool("ALLOW_MULTIPLE_COPIES", false),
-			MaxModelInstances:            getDefaultServeOptionInt("MAX_MODEL_INSTANCES", 0),
+			MaxInstances:                 getDefaultServeOptionInt("MAX_MODEL_INSTANCES", 0),
+			StderrBufferSize:             getDefaultServeOptionInt("STDERR_BUFFER_SIZE", runner.DefaultStderrBufferSize),
+			SessionLogDir:                getDefaultServeOptionString("SESSION_LOG_DIR", filepath.Join(os.TempDir(), "helix", "session-logs")),
+			SessionLogMaxSize:            getDefaultServeOptionInt("SESSION_LOG_MAX_SIZE", 10*1024*1024),
+			SessionLogMaxBackups:         getDefaultServeOptionInt("SESSION_LOG_MAX_BACKUPS", 2),
+			SessionLogRetentionHours:     getDefaultServeOptionInt("SESSION_LOG_RETENTION_HOURS", 72),
+			WarmPool:                     getDefaultServeOptionMap("WARM_POOL", map[string]string{}),
+			ResponseBufferSize:           getDefaultServeOptionInt("RESPONSE_BUFFER_SIZE", runner.DefaultResponseBufferSize),
+			ResponseDropPolicy:           getDefaultServeOptionString("RESPONSE_DROP_POLICY", string(runner.ResponseDropPolicyDropOldest)),
+			SessionAssignmentsFile:       getDefaultServeOptionString("SESSION_ASSIGNMENTS_FILE", filepath.Join(os.TempDir(), "helix", "runner-sessions.json")),
+			MaxSessionFileSize:           int64(getDefaultServeOptionInt("MAX_SESSION_FILE_SIZE", 0)),
+			Cordoned:                     getDefaultServeOptionBool("CORDONED", false),
+			MaxConcurrentPrepares:        getDefaultServeOptionInt("MAX_CONCURRENT_PREPARES", 0),
+			RequestTimeoutSeconds:        getDefaultServeOptionInt("REQUEST_TIMEOUT_SECONDS", 60),
+			BackoffInitialMilliseconds:   getDefaultServeOptionInt("BACKOFF_INITIAL_MILLISECONDS", 1000),
+			BackoffMaxSeconds:            getDefaultServeOptionInt("BACKOFF_MAX_SECONDS", 60),
+			RecordPrompts:                getDefaultServeOptionBool("RECORD_PROMPTS", false),
+			Logging:                      getDefaultLoggingOptions(),
 			CacheDir:                     getDefaultServeOptionString("CACHE_DIR", "/root/.cache/huggingface"), // TODO: change to maybe just /data
+			ResultsFolders:               getDefaultServeOptionStringArray("RESULTS_FOLDERS", runner.DefaultResultsFolders()),
 		},
 		Janitor: janitor.JanitorOptions{
 			SentryDSNApi: getDefaultServeOptionString("SENTRY_DSN_API", ""),
@@ -151,8 +170,93 @@ func newRunnerCmd() *cobra.Command {
 	)
 
 	runnerCmd.PersistentFlags().IntVar(
-		&allOptions.Runner.MaxModelInstances, "max-model-instances", allOptions.Runner.MaxModelInstances,
-		`How many instances of a model can we run at the same time?`,
+		&allOptions.Runner.MaxInstances, "max-model-instances", allOptions.Runner.MaxInstances,
+		`The most model instances we run at the same time whatever memory is free (0 for no limit).`,
+	)
+
+	runnerCmd.PersistentFlags().IntVar(
+		&allOptions.Runner.MaxConcurrentPrepares, "max-concurrent-prepares", allOptions.Runner.MaxConcurrentPrepares,
+		`The most sessions that download their files at the same time, the rest wait their turn (0 for no limit).`,
+	)
+
+	runnerCmd.PersistentFlags().IntVar(
+		&allOptions.Runner.RequestTimeoutSeconds, "request-timeout-seconds", allOptions.Runner.RequestTimeoutSeconds,
+		`Give up on a request to the api after this many seconds, or a file transfer after this many seconds without any progress (0 for no limit).`,
+	)
+
+	runnerCmd.PersistentFlags().IntVar(
+		&allOptions.Runner.BackoffInitialMilliseconds, "backoff-initial-milliseconds", allOptions.Runner.BackoffInitialMilliseconds,
+		`How long to wait before trying the api again when it can't be reached, doubled (with jitter) for every failure in a row (0 to not back off).`,
+	)
+
+	runnerCmd.PersistentFlags().IntVar(
+		&allOptions.Runner.BackoffMaxSeconds, "backoff-max-seconds", allOptions.Runner.BackoffMaxSeconds,
+		`The longest wait between attempts to reach the api.`,
+	)
+
+	runnerCmd.PersistentFlags().BoolVar(
+		&allOptions.Runner.RecordPrompts, "record-prompts", allOptions.Runner.RecordPrompts,
+		`Send the prompt each model was given back to the api to keep on the interaction (only a hash of it for sensitive sessions).`,
+	)
+
+	runnerCmd.PersistentFlags().BoolVar(
+		&allOptions.Runner.Cordoned, "cordoned", allOptions.Runner.Cordoned,
+		`Start without taking new sessions - POST to /api/v1/cordon and /api/v1/uncordon on the runner to drain it and resume.`,
+	)
+
+	runnerCmd.PersistentFlags().IntVar(
+		&allOptions.Runner.StderrBufferSize, "stderr-buffer-size", allOptions.Runner.StderrBufferSize,
+		`How many bytes of a model process's stderr to keep and report to the api if it crashes.`,
+	)
+
+	runnerCmd.PersistentFlags().StringVar(
+		&allOptions.Runner.SessionLogDir, "session-log-dir", allOptions.Runner.SessionLogDir,
+		`Where to write the model process output for each session (empty to disable).`,
+	)
+
+	runnerCmd.PersistentFlags().IntVar(
+		&allOptions.Runner.SessionLogMaxSize, "session-log-max-size", allOptions.Runner.SessionLogMaxSize,
+		`How many bytes a session log can grow to before it is rotated.`,
+	)
+
+	runnerCmd.PersistentFlags().IntVar(
+		&allOptions.Runner.SessionLogMaxBackups, "session-log-max-backups", allOptions.Runner.SessionLogMaxBackups,
+		`How many rotated logs to keep for each session.`,
+	)
+
+	runnerCmd.PersistentFlags().IntVar(
+		&allOptions.Runner.SessionLogRetentionHours, "session-log-retention-hours", allOptions.Runner.SessionLogRetentionHours,
+		`Delete session logs that have not been written to for this many hours.`,
+	)
+
+	runnerCmd.PersistentFlags().StringToStringVar(
+		&allOptions.Runner.WarmPool, "warm-pool", allOptions.Runner.WarmPool,
+		`How many idle instances of each model to keep loaded e.g. mistral:7b-instruct=1`,
+	)
+
+	runnerCmd.PersistentFlags().IntVar(
+		&allOptions.Runner.ResponseBufferSize, "response-buffer-size", allOptions.Runner.ResponseBufferSize,
+		`How many model responses can be waiting to be sent to the api.`,
+	)
+
+	runnerCmd.PersistentFlags().StringVar(
+		&allOptions.Runner.ResponseDropPolicy, "response-drop-policy", allOptions.Runner.ResponseDropPolicy,
+		`What to do when the response buffer is full: drop-oldest (streaming updates) or block.`,
+	)
+
+	runnerCmd.PersistentFlags().StringVar(
+		&allOptions.Runner.SessionAssignmentsFile, "session-assignments-file", allOptions.Runner.SessionAssignmentsFile,
+		`Where to keep track of the sessions this runner is working on so they can be requeued if it restarts (empty to turn off).`,
+	)
+
+	runnerCmd.PersistentFlags().StringSliceVar(
+		&allOptions.Runner.ResultsFolders, "results-folders", allOptions.Runner.ResultsFolders,
+		`The folders the model processes write their results to, files from anywhere else are never uploaded.`,
+	)
+
+	runnerCmd.PersistentFlags().Int64Var(
+		&allOptions.Runner.MaxSessionFileSize, "max-session-file-size", allOptions.Runner.MaxSessionFileSize,
+		`Refuse sessions whose files add up to more than this many bytes before downloading them (0 for no limit).`,
 	)
 
 	runnerCmd.PersistentFlags().StringVar(
@@ -169,6 +273,15 @@ func newRunnerCmd() *cobra.Command {
 		`The sentry DSN.`,
 	)
 
+	runnerCmd.PersistentFlags().StringVar(
+		&allOptions.Runner.Logging.Level, "log-level", allOptions.Runner.Logging.Level,
+		`The log level: trace, debug, info, warn, error or none.`,
+	)
+	runnerCmd.PersistentFlags().StringVar(
+		(*string)(&allOptions.Runner.Logging.Format), "log-format", string(allOptions.Runner.Logging.Format),
+		`The log format: console or json.`,
+	)
+
 	return runnerCmd
 }
 
@@ -229,13 +342,16 @@ var WarmupSession_Model_SDXL = types.Session{
 }
 
 func runnerCLI(cmd *cobra.Command, options *RunnerOptions) error {
-	system.SetupLogging()
+	err := system.SetupLogging(options.Runner.Logging)
+	if err != nil {
+		return err
+	}
 
 	if options.Runner.ApiToken == "" {
 		return fmt.Errorf("api token is required")
 	}
 
-	_, err := types.ValidateModelName(options.Runner.FilterModelName, true)
+	_, err = types.ValidateModelName(options.Runner.FilterModelName, true)
 	if err != nil {
 		return err
 	}
@@ -271,6 +387,15 @@ func runnerCLI(cmd *cobra.Command, options *RunnerOptions) error {
 	model.API_HOST = options.Runner.ApiHost
 	model.API_TOKEN = options.Runner.ApiToken
 
+	// the mock model processes hand back the fixtures as their results
+	if options.Runner.MockRunner {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		options.Runner.ResultsFolders = append(options.Runner.ResultsFolders, filepath.Join(wd, "runner", "fixtures"))
+	}
+
 	useWarmupSessions := []types.Session{}
 	if !options.Runner.MockRunner {
 		// Axolotl runtime warmup
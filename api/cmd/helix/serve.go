@@ -7,14 +7,19 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/helixml/helix/api/pkg/auth"
 	"github.com/helixml/helix/api/pkg/config"
 	"github.com/helixml/helix/api/pkg/controller"
+	"github.com/helixml/helix/api/pkg/dataprep/qapairs"
 	"github.com/helixml/helix/api/pkg/dataprep/text"
 	"github.com/helixml/helix/api/pkg/filestore"
 	"github.com/helixml/helix/api/pkg/janitor"
+	"github.com/helixml/helix/api/pkg/model"
 	"github.com/helixml/helix/api/pkg/notification"
+	"github.com/helixml/helix/api/pkg/openai"
 	"github.com/helixml/helix/api/pkg/server"
 	"github.com/helixml/helix/api/pkg/store"
 	"github.com/helixml/helix/api/pkg/stripe"
@@ -34,6 +39,7 @@ type ServeOptions struct {
 	StoreOptions        store.StoreOptions
 	ServerOptions       server.ServerOptions
 	StripeOptions       stripe.StripeOptions
+	ModelAliases        map[string]types.ModelName
 
 	Cfg *config.ServerConfig
 
@@ -54,14 +60,45 @@ func NewServeOptions() (*ServeOptions, error) {
 
 	filestoreSignSecret := getDefaultServeOptionString("FILESTORE_PRESIGN_SECRET", system.GenerateUUID())
 
+	// JSON of model name to USD per million input and output tokens, merged over the defaults
+	dataPrepPrices, err := text.ParsePriceTable(getDefaultServeOptionString("DATA_PREP_TEXT_PRICES", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON of alias to full model name e.g. {"default": "llama3:instruct"}, merged over the defaults
+	modelAliases, err := model.ParseModelAliases(getDefaultServeOptionString("MODEL_ALIASES", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	// a yaml file with the same layout as the baked-in qapairs prompt suite
+	dataPrepConfig, err := qapairs.LoadConfig(getDefaultServeOptionString("DATA_PREP_TEXT_CONFIG_FILE", ""))
+	if err != nil {
+		return nil, err
+	}
+
 	return &ServeOptions{
+		ModelAliases: modelAliases,
 		DataPrepTextOptions: text.DataPrepTextOptions{
 			// for concurrency of requests to openAI - look in the dataprep module
 			Module:       text.DataPrepModule(getDefaultServeOptionString("DATA_PREP_TEXT_MODULE", string(text.DataPrepModule_Dynamic))),
 			OverflowSize: getDefaultServeOptionInt("DATA_PREP_TEXT_OVERFLOW_SIZE", 256),
+			Overlap:      getDefaultServeOptionInt("DATA_PREP_TEXT_OVERLAP", 0),
 			// we are exceeding openAI window size at > 30 questions
 			QuestionsPerChunk: getDefaultServeOptionInt("DATA_PREP_TEXT_QUESTIONS_PER_CHUNK", 30),
 			Temperature:       getDefaultServeOptionFloat("DATA_PREP_TEXT_TEMPERATURE", 0.5),
+			Prices:            dataPrepPrices,
+			// point the dynamic module at any OpenAI compatible API e.g. a local vLLM or ollama
+			APIURL: getDefaultServeOptionString("DATA_PREP_TEXT_API_URL", ""),
+			APIKey: getDefaultServeOptionString("DATA_PREP_TEXT_API_KEY", ""),
+			Model:  getDefaultServeOptionString("DATA_PREP_TEXT_MODEL", ""),
+			Config: dataPrepConfig,
+			// empty values = the config's default target and all of its prompts
+			Target:  getDefaultServeOptionString("DATA_PREP_TEXT_TARGET", ""),
+			Prompts: getDefaultServeOptionStringArray("DATA_PREP_TEXT_PROMPTS", []string{}),
+			// hash, none or full - full is only for debugging documents that aren't sensitive
+			LogContent: qapairs.LogContent(getDefaultServeOptionString("DATA_PREP_TEXT_LOG_CONTENT", string(qapairs.LogContentHash))),
 		},
 		ControllerOptions: controller.ControllerOptions{
 			Config:                       &serverConfig,
@@ -69,8 +106,19 @@ func NewServeOptions() (*ServeOptions, error) {
 			FilePrefixGlobal:             getDefaultServeOptionString("FILE_PREFIX_GLOBAL", "dev"),
 			FilePrefixUser:               getDefaultServeOptionString("FILE_PREFIX_USER", "users/{{.Owner}}"),
 			FilePrefixResults:            getDefaultServeOptionString("FILE_PREFIX_RESULTS", "results"),
-			TextExtractionURL:            getDefaultServeOptionString("TEXT_EXTRACTION_URL", "http://unstructured:5000/api/v1/extract"),
+			TextExtractionURL:            getDefaultServeOptionString("TEXT_EXTRACTION_URL", ""),
 			SchedulingDecisionBufferSize: getDefaultServeOptionInt("SCHEDULING_DECISION_BUFFER_SIZE", 10),
+			MaxInteractionRetries:        getDefaultServeOptionInt("MAX_INTERACTION_RETRIES", 0),
+			PartialResultInterval:        time.Second * time.Duration(getDefaultServeOptionInt("PARTIAL_RESULT_INTERVAL_SECONDS", 5)),
+			RunnerInterruptTimeout:       time.Second * time.Duration(getDefaultServeOptionInt("RUNNER_INTERRUPT_TIMEOUT_SECONDS", 600)),
+			SessionPriorityBoost:         time.Second * time.Duration(getDefaultServeOptionInt("SESSION_PRIORITY_BOOST_SECONDS", 300)),
+			MaxSessionFileSize:           int64(getDefaultServeOptionInt("MAX_SESSION_FILE_SIZE", 0)),
+			MaxSessionFileSizeSubscribed: int64(getDefaultServeOptionInt("MAX_SESSION_FILE_SIZE_SUBSCRIBED", 0)),
+			// share links are turned off unless this is set, it has to be the same
+			// on every replica and across restarts for the links to keep working
+			ShareLinkSecret: getDefaultServeOptionString("SHARE_LINK_SECRET", ""),
+			// zero means an owner type can have any number of sessions running or queued
+			SessionQuotas: getDefaultSessionQuotas(),
 		},
 		FilestoreOptions: filestore.FileStoreOptions{
 			Type:         filestore.FileStoreType(getDefaultServeOptionString("FILESTORE_TYPE", "fs")),
@@ -78,6 +126,14 @@ func NewServeOptions() (*ServeOptions, error) {
 			GCSKeyBase64: getDefaultServeOptionString("FILESTORE_GCS_KEY_BASE64", ""),
 			GCSKeyFile:   getDefaultServeOptionString("FILESTORE_GCS_KEY_FILE", ""),
 			GCSBucket:    getDefaultServeOptionString("FILESTORE_GCS_BUCKET", ""),
+			S3Bucket:     getDefaultServeOptionString("FILESTORE_S3_BUCKET", ""),
+			S3Region:     getDefaultServeOptionString("FILESTORE_S3_REGION", "us-east-1"),
+			S3Prefix:     getDefaultServeOptionString("FILESTORE_S3_PREFIX", ""),
+			S3Endpoint:   getDefaultServeOptionString("FILESTORE_S3_ENDPOINT", ""),
+			// fall back to the standard AWS variables
+			S3AccessKeyID:     getDefaultServeOptionString("FILESTORE_S3_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID")),
+			S3SecretAccessKey: getDefaultServeOptionString("FILESTORE_S3_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+			S3PresignExpiry:   time.Second * time.Duration(getDefaultServeOptionInt("FILESTORE_S3_PRESIGN_EXPIRY_SECONDS", 900)),
 		},
 		StoreOptions: store.StoreOptions{
 			Host:        getDefaultServeOptionString("POSTGRES_HOST", ""),
@@ -98,6 +154,12 @@ func NewServeOptions() (*ServeOptions, error) {
 			RunnerToken: getDefaultServeOptionString("RUNNER_TOKEN", ""),
 			AdminIDs:    getDefaultServeOptionStringArray("ADMIN_USER_IDS", []string{}),
 			EvalUserID:  getDefaultServeOptionString("EVAL_USER_ID", ""),
+			// leave empty to allow any origin
+			AllowedOrigins: getDefaultServeOptionStringArray("ALLOWED_ORIGINS", []string{}),
+			// a zero rate means that owner type is not rate limited
+			RateLimits:        getDefaultRateLimits(),
+			IdempotencyKeyTTL: time.Hour * time.Duration(getDefaultServeOptionInt("IDEMPOTENCY_KEY_TTL_HOURS", 24)),
+			Logging:           getDefaultLoggingOptions(),
 		},
 		JanitorOptions: janitor.JanitorOptions{
 			SentryDSNApi:            serverConfig.Janitor.SentryDsnAPI,
@@ -117,6 +179,45 @@ func NewServeOptions() (*ServeOptions, error) {
 	}, nil
 }
 
+// SESSION_QUOTAS has the quota for each owner type, SESSION_QUOTA_USER
+// still sets the one for users
+func getDefaultSessionQuotas() map[types.OwnerType]int {
+	quotas := getDefaultServeOptionOwnerTypeInts("SESSION_QUOTAS", map[types.OwnerType]int{})
+	quotas[types.OwnerTypeUser] = getDefaultServeOptionInt("SESSION_QUOTA_USER", quotas[types.OwnerTypeUser])
+	return quotas
+}
+
+// the burst for owner types that have a rate but no burst set
+const defaultRateLimitBurst = 10
+
+// RATE_LIMITS_REQUESTS_PER_MINUTE and RATE_LIMITS_BURST have the limits for
+// each owner type, RATE_LIMIT_USER_REQUESTS_PER_MINUTE and
+// RATE_LIMIT_USER_BURST still set the ones for users
+func getDefaultRateLimits() map[types.OwnerType]server.RateLimitOptions {
+	requestsPerMinute := getDefaultServeOptionOwnerTypeInts("RATE_LIMITS_REQUESTS_PER_MINUTE", map[types.OwnerType]int{})
+	burst := getDefaultServeOptionOwnerTypeInts("RATE_LIMITS_BURST", map[types.OwnerType]int{})
+
+	userBurst, ok := burst[types.OwnerTypeUser]
+	if !ok {
+		userBurst = defaultRateLimitBurst
+	}
+	requestsPerMinute[types.OwnerTypeUser] = getDefaultServeOptionInt("RATE_LIMIT_USER_REQUESTS_PER_MINUTE", requestsPerMinute[types.OwnerTypeUser])
+	burst[types.OwnerTypeUser] = getDefaultServeOptionInt("RATE_LIMIT_USER_BURST", userBurst)
+
+	limits := map[types.OwnerType]server.RateLimitOptions{}
+	for ownerType, rate := range requestsPerMinute {
+		ownerBurst, ok := burst[ownerType]
+		if !ok {
+			ownerBurst = defaultRateLimitBurst
+		}
+		limits[ownerType] = server.RateLimitOptions{
+			RequestsPerMinute: rate,
+			Burst:             ownerBurst,
+		}
+	}
+	return limits
+}
+
 func newServeCmd() *cobra.Command {
 	allOptions, err := NewServeOptions()
 	if err != nil {
@@ -145,6 +246,21 @@ func newServeCmd() *cobra.Command {
 		`The overflow size for the text data prep`,
 	)
 
+	serveCmd.PersistentFlags().IntVar(
+		&allOptions.DataPrepTextOptions.Overlap, "dataprep-overlap", allOptions.DataPrepTextOptions.Overlap,
+		`How many characters each text chunk repeats from the end of the previous one (must be less than the chunk size)`,
+	)
+
+	serveCmd.PersistentFlags().StringVar(
+		&allOptions.DataPrepTextOptions.APIURL, "dataprep-api-url", allOptions.DataPrepTextOptions.APIURL,
+		`An OpenAI compatible API for the dynamic data prep module to use instead of its default target`,
+	)
+
+	serveCmd.PersistentFlags().StringVar(
+		&allOptions.DataPrepTextOptions.Model, "dataprep-model", allOptions.DataPrepTextOptions.Model,
+		`The model to use with the data prep API URL`,
+	)
+
 	serveCmd.PersistentFlags().IntVar(
 		&allOptions.DataPrepTextOptions.QuestionsPerChunk, "dataprep-questions-per-chunk", allOptions.DataPrepTextOptions.QuestionsPerChunk,
 		`The questions per chunk for the text data prep`,
@@ -155,6 +271,11 @@ func newServeCmd() *cobra.Command {
 		`The temperature for the text data prep prompt`,
 	)
 
+	serveCmd.PersistentFlags().StringVar(
+		(*string)(&allOptions.DataPrepTextOptions.LogContent), "dataprep-log-content", string(allOptions.DataPrepTextOptions.LogContent),
+		`How much of the document chunks and answers data prep logs: hash, none or full (sessions marked sensitive are never logged in full)`,
+	)
+
 	// ControllerOptions
 	serveCmd.PersistentFlags().StringVar(
 		&allOptions.ControllerOptions.FilePrefixGlobal, "file-prefix-global", allOptions.ControllerOptions.FilePrefixGlobal,
@@ -174,11 +295,41 @@ func newServeCmd() *cobra.Command {
 		`How many scheduling decisions to buffer before we start dropping them.`,
 	)
 
+	serveCmd.PersistentFlags().IntVar(
+		&allOptions.ControllerOptions.MaxInteractionRetries, "max-interaction-retries", allOptions.ControllerOptions.MaxInteractionRetries,
+		`How many times to re-queue an interaction that errored on a runner before failing it.`,
+	)
+
+	serveCmd.PersistentFlags().DurationVar(
+		&allOptions.ControllerOptions.PartialResultInterval, "partial-result-interval", allOptions.ControllerOptions.PartialResultInterval,
+		`How often the text streamed so far is saved so it survives a runner dying part way through (0 to turn off).`,
+	)
+
+	serveCmd.PersistentFlags().DurationVar(
+		&allOptions.ControllerOptions.RunnerInterruptTimeout, "runner-interrupt-timeout", allOptions.ControllerOptions.RunnerInterruptTimeout,
+		`How long a runner can stop reporting before the sessions it was part way through are interrupted (0 to leave them for the runner).`,
+	)
+
+	serveCmd.PersistentFlags().DurationVar(
+		&allOptions.ControllerOptions.SessionPriorityBoost, "session-priority-boost", allOptions.ControllerOptions.SessionPriorityBoost,
+		`How far ahead of other sessions priority sessions are put in the queue.`,
+	)
+
+	serveCmd.PersistentFlags().Int64Var(
+		&allOptions.ControllerOptions.MaxSessionFileSize, "max-session-file-size", allOptions.ControllerOptions.MaxSessionFileSize,
+		`The most the files uploaded to a session can add up to in bytes (0 for no limit).`,
+	)
+
+	serveCmd.PersistentFlags().Int64Var(
+		&allOptions.ControllerOptions.MaxSessionFileSizeSubscribed, "max-session-file-size-subscribed", allOptions.ControllerOptions.MaxSessionFileSizeSubscribed,
+		`The session file size limit for users with a subscription (0 to use max-session-file-size).`,
+	)
+
 	// FileStoreOptions
 	var filestoreType string
 	serveCmd.PersistentFlags().StringVar(
 		&filestoreType, "filestore-type", string(allOptions.FilestoreOptions.Type),
-		`What type of filestore should we use (fs | gcs).`,
+		`What type of filestore should we use (fs | gcs | s3).`,
 	)
 	allOptions.FilestoreOptions.Type = filestore.FileStoreType(filestoreType)
 
@@ -202,6 +353,31 @@ func newServeCmd() *cobra.Command {
 		`The bucket we are storing things in GCS.`,
 	)
 
+	serveCmd.PersistentFlags().StringVar(
+		&allOptions.FilestoreOptions.S3Bucket, "filestore-s3-bucket", allOptions.FilestoreOptions.S3Bucket,
+		`The bucket we are storing things in S3.`,
+	)
+
+	serveCmd.PersistentFlags().StringVar(
+		&allOptions.FilestoreOptions.S3Region, "filestore-s3-region", allOptions.FilestoreOptions.S3Region,
+		`The region the S3 bucket is in.`,
+	)
+
+	serveCmd.PersistentFlags().StringVar(
+		&allOptions.FilestoreOptions.S3Prefix, "filestore-s3-prefix", allOptions.FilestoreOptions.S3Prefix,
+		`The key prefix everything is stored under in the S3 bucket.`,
+	)
+
+	serveCmd.PersistentFlags().StringVar(
+		&allOptions.FilestoreOptions.S3Endpoint, "filestore-s3-endpoint", allOptions.FilestoreOptions.S3Endpoint,
+		`The endpoint of an S3 compatible store (e.g. minio) - leave empty for AWS.`,
+	)
+
+	serveCmd.PersistentFlags().DurationVar(
+		&allOptions.FilestoreOptions.S3PresignExpiry, "filestore-s3-presign-expiry", allOptions.FilestoreOptions.S3PresignExpiry,
+		`How long the S3 urls handed to runners for direct uploads and downloads are valid for.`,
+	)
+
 	// StoreOptions
 	serveCmd.PersistentFlags().StringVar(
 		&allOptions.StoreOptions.Host, "postgres-host", allOptions.StoreOptions.Host,
@@ -257,6 +433,22 @@ func newServeCmd() *cobra.Command {
 		&allOptions.ServerOptions.AdminIDs, "admin-ids", allOptions.ServerOptions.AdminIDs,
 		`Keycloak admin IDs`,
 	)
+	serveCmd.PersistentFlags().StringArrayVar(
+		&allOptions.ServerOptions.AllowedOrigins, "allowed-origins", allOptions.ServerOptions.AllowedOrigins,
+		`The origins allowed to make cross origin requests, if empty any origin is allowed.`,
+	)
+	serveCmd.PersistentFlags().DurationVar(
+		&allOptions.ServerOptions.IdempotencyKeyTTL, "idempotency-key-ttl", allOptions.ServerOptions.IdempotencyKeyTTL,
+		`How long a retry with the same Idempotency-Key returns what was created the first time.`,
+	)
+	serveCmd.PersistentFlags().StringVar(
+		&allOptions.ServerOptions.Logging.Level, "log-level", allOptions.ServerOptions.Logging.Level,
+		`The log level: trace, debug, info, warn, error or none.`,
+	)
+	serveCmd.PersistentFlags().StringVar(
+		(*string)(&allOptions.ServerOptions.Logging.Format), "log-format", string(allOptions.ServerOptions.Logging.Format),
+		`The log format: console or json.`,
+	)
 
 	// JanitorOptions
 	serveCmd.PersistentFlags().StringVar(
@@ -303,6 +495,22 @@ func newServeCmd() *cobra.Command {
 	return serveCmd
 }
 
+// an openai compatible client and the model to ask for a feature that runs
+// on the given provider - nil (which turns the feature off) if it is
+// disabled or the provider has not been set up, feature is what we use it
+// for so we can say what is turned off
+func getFeatureLLM(cfg *config.ServerConfig, enabled bool, provider config.Provider, model string, feature string) (openai.Client, string) {
+	if !enabled {
+		return nil, ""
+	}
+	llm, err := cfg.ResolveLLM(provider, model)
+	if err != nil {
+		log.Warn().Msgf("%s is disabled, %s", feature, err.Error())
+		return nil, ""
+	}
+	return openai.New(llm.APIKey, llm.BaseURL), llm.Model
+}
+
 func getFilestore(ctx context.Context, options *ServeOptions) (filestore.FileStore, error) {
 	var store filestore.FileStore
 	if options.ServerOptions.URL == "" {
@@ -353,6 +561,12 @@ func getFilestore(ctx context.Context, options *ServeOptions) (filestore.FileSto
 			return nil, err
 		}
 		store = gcs
+	} else if options.FilestoreOptions.Type == filestore.FileStoreTypeS3 {
+		s3, err := filestore.NewS3Storage(options.FilestoreOptions)
+		if err != nil {
+			return nil, err
+		}
+		store = s3
 	} else {
 		return nil, fmt.Errorf("unknown filestore type: %s", options.FilestoreOptions.Type)
 	}
@@ -366,15 +580,18 @@ func getFilestore(ctx context.Context, options *ServeOptions) (filestore.FileSto
 }
 
 func serve(cmd *cobra.Command, options *ServeOptions) error {
-	system.SetupLogging()
+	err := system.SetupLogging(options.ServerOptions.Logging)
+	if err != nil {
+		return err
+	}
 
 	// Cleanup manager ensures that resources are freed before exiting:
 	cm := system.NewCleanupManager()
 	defer cm.Cleanup(cmd.Context())
 	ctx := cmd.Context()
 
-	// Context ensures main goroutine waits until killed with ctrl+c:
-	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
+	// Context ensures main goroutine waits until killed with ctrl+c or SIGTERM:
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	fs, err := getFilestore(ctx, options)
@@ -391,6 +608,23 @@ func serve(cmd *cobra.Command, options *ServeOptions) error {
 		return fmt.Errorf("runner token is required")
 	}
 
+	if options.ControllerOptions.ShareLinkSecret == "" {
+		log.Warn().Msg("SHARE_LINK_SECRET is not set, session share links are disabled")
+	}
+
+	options.DataPrepTextOptions.LogContent, err = qapairs.ValidateLogContent(string(options.DataPrepTextOptions.LogContent), true)
+	if err != nil {
+		return err
+	}
+
+	// fail now rather than on the first fine tune if a prompt or target name is wrong
+	if options.DataPrepTextOptions.Module == text.DataPrepModule_Dynamic {
+		_, err = text.NewDynamicDataPrepFromOptions(options.DataPrepTextOptions)
+		if err != nil {
+			return fmt.Errorf("invalid data prep config: %w", err)
+		}
+	}
+
 	keycloakAuthenticator, err := auth.NewKeycloakAuthenticator(options.KeycloakCfg)
 	if err != nil {
 		return fmt.Errorf("failed to create keycloak authenticator: %v", err)
@@ -408,6 +642,8 @@ func serve(cmd *cobra.Command, options *ServeOptions) error {
 		return err
 	}
 
+	model.SetModelAliases(options.ModelAliases)
+
 	planner, err := tools.NewChainStrategy(options.Cfg)
 	if err != nil {
 		return fmt.Errorf("failed to create tools planner: %v", err)
@@ -420,6 +656,12 @@ func serve(cmd *cobra.Command, options *ServeOptions) error {
 	options.ControllerOptions.Janitor = janitor
 	options.ControllerOptions.Notifier = notifier
 	options.ControllerOptions.Planner = planner
+	options.ControllerOptions.SessionNamingClient, options.ControllerOptions.SessionNamingModel = getFeatureLLM(
+		options.Cfg, options.Cfg.SessionNaming.Enabled, options.Cfg.SessionNaming.Provider, options.Cfg.SessionNaming.Model, "session naming",
+	)
+	options.ControllerOptions.EvalClient, options.ControllerOptions.EvalModel = getFeatureLLM(
+		options.Cfg, options.Cfg.Evals.Enabled, options.Cfg.Evals.Provider, options.Cfg.Evals.Model, "automatic evals",
+	)
 
 	// a text.DataPrepText factory that runs jobs on ourselves
 	// dogfood nom nom nom
@@ -431,12 +673,14 @@ func serve(cmd *cobra.Command, options *ServeOptions) error {
 		var questionGenerator text.DataPrepTextQuestionGenerator
 		var err error
 
+		dataPrepOptions := options.DataPrepTextOptions.ForSession(session)
+
 		// if we are using openai then let's do that
 		// otherwise - we use our own mistral plugin
 		if options.DataPrepTextOptions.Module == text.DataPrepModule_HelixMistral {
 			// we give the mistal data prep module a way to run and read sessions
 			questionGenerator, err = text.NewDataPrepTextHelixMistral(
-				options.DataPrepTextOptions,
+				dataPrepOptions,
 				session,
 				func(req types.CreateSessionRequest) (*types.Session, error) {
 					return appController.CreateSession(types.RequestContext{}, req)
@@ -449,15 +693,25 @@ func serve(cmd *cobra.Command, options *ServeOptions) error {
 				return nil, nil, err
 			}
 		} else if options.DataPrepTextOptions.Module == text.DataPrepModule_Dynamic {
-			// empty values = use defaults
-			questionGenerator = text.NewDynamicDataPrep("", []string{})
+			questionGenerator, err = text.NewDynamicDataPrepFromOptions(dataPrepOptions)
+			if err != nil {
+				return nil, nil, err
+			}
 		} else {
 			return nil, nil, fmt.Errorf("unknown data prep module: %s", options.DataPrepTextOptions.Module)
 		}
 
+		chunkSize, err := questionGenerator.GetChunkSize()
+		if err != nil {
+			return nil, nil, err
+		}
+
 		splitter, err := text.NewDataPrepSplitter(text.DataPrepTextSplitterOptions{
-			ChunkSize: questionGenerator.GetChunkSize(),
+			ChunkSize: chunkSize,
 			Overflow:  options.DataPrepTextOptions.OverflowSize,
+			Overlap:   options.DataPrepTextOptions.Overlap,
+			// the estimate's made up session has no metadata to ask
+			SpanDocuments: session != nil && session.Metadata.SpanDocuments,
 		})
 
 		if err != nil {
@@ -500,13 +754,19 @@ func serve(cmd *cobra.Command, options *ServeOptions) error {
 
 	log.Info().Msgf("Helix server listening on %s:%d", options.ServerOptions.Host, options.ServerOptions.Port)
 
+	errCh := make(chan error, 1)
 	go func() {
-		err := server.ListenAndServe(ctx, cm)
+		errCh <- server.ListenAndServe(ctx, cm)
+	}()
+
+	select {
+	case err := <-errCh:
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("api server failed: %w", err)
 		}
-	}()
+	case <-ctx.Done():
+		// the cleanup manager waits for the server to drain
+	}
 
-	<-ctx.Done()
 	return nil
 }
@@ -5,6 +5,8 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
@@ -86,6 +88,32 @@ func getDefaultServeOptionMap(envName string, defaultValue map[string]string) ma
 	return defaultValue
 }
 
+// comma separated owner_type=number pairs e.g. RATE_LIMITS_BURST="user=10,org=50"
+func getDefaultServeOptionOwnerTypeInts(envName string, defaultValue map[types.OwnerType]int) map[types.OwnerType]int {
+	values := getDefaultServeOptionMap(envName, nil)
+	if values == nil {
+		return defaultValue
+	}
+	data := make(map[types.OwnerType]int)
+	for ownerType, value := range values {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			log.Warn().Msgf("invalid number for %s in %s: %s", ownerType, envName, value)
+			continue
+		}
+		data[types.OwnerType(ownerType)] = i
+	}
+	return data
+}
+
+// LOG_LEVEL and LOG_FORMAT, info on the console if they aren't set
+func getDefaultLoggingOptions() system.LoggingOptions {
+	return system.LoggingOptions{
+		Level:  getDefaultServeOptionString("LOG_LEVEL", "info"),
+		Format: system.LogFormat(getDefaultServeOptionString("LOG_FORMAT", string(system.LogFormatConsole))),
+	}
+}
+
 func FatalErrorHandler(cmd *cobra.Command, msg string, code int) {
 	if len(msg) > 0 {
 		// add newline if needed
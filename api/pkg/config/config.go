@@ -1,14 +1,22 @@
 package config
 
-import "github.com/kelseyhightower/envconfig"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kelseyhightower/envconfig"
+)
 
 type ServerConfig struct {
 	Providers     Providers
+	Utility       Utility
 	Tools         Tools
 	Keycloak      Keycloak
 	Notifications Notifications
 	Janitor       Janitor
 	Stripe        Stripe
+	SessionNaming SessionNaming
+	Evals         Evals
 }
 
 func LoadServerConfig() (ServerConfig, error) {
@@ -41,12 +49,82 @@ type Provider string
 const (
 	ProviderOpenAI     Provider = "openai"
 	ProviderTogetherAI Provider = "togetherai"
+	// whatever the utility LLM is set up to use
+	ProviderUtility Provider = "utility"
 )
 
+// Utility is the LLM that the features helix runs for itself (planning tool
+// calls, naming sessions, judging evals) share unless they pick a provider
+// of their own. Setting the base URL points it at any openai compatible
+// server instead of one of the providers so on-prem installs can run
+// without OpenAI. The features use its model unless they name their own
+type Utility struct {
+	Provider Provider `envconfig:"UTILITY_LLM_PROVIDER" default:"togetherai"`
+	BaseURL  string   `envconfig:"UTILITY_LLM_BASE_URL"`
+	APIKey   string   `envconfig:"UTILITY_LLM_API_KEY"`
+	Model    string   `envconfig:"UTILITY_LLM_MODEL" default:"mistralai/Mixtral-8x7B-Instruct-v0.1"`
+}
+
+// returned by ResolveLLM when the feature names a provider we do not know
+var ErrUnknownProvider = errors.New("unknown provider")
+
+// where a feature sends its LLM calls
+type LLMEndpoint struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+}
+
+// work out the endpoint and model for a feature that uses the given provider
+// and model - an empty model means the utility LLM's model
+func (c *ServerConfig) ResolveLLM(provider Provider, model string) (LLMEndpoint, error) {
+	if model == "" {
+		model = c.Utility.Model
+	}
+
+	if provider == ProviderUtility {
+		if c.Utility.BaseURL != "" {
+			return LLMEndpoint{BaseURL: c.Utility.BaseURL, APIKey: c.Utility.APIKey, Model: model}, nil
+		}
+		provider = c.Utility.Provider
+	}
+
+	switch provider {
+	case ProviderOpenAI:
+		if c.Providers.OpenAI.APIKey == "" {
+			return LLMEndpoint{}, fmt.Errorf("OpenAI API key (OPENAI_API_KEY) is required")
+		}
+		return LLMEndpoint{BaseURL: c.Providers.OpenAI.BaseURL, APIKey: c.Providers.OpenAI.APIKey, Model: model}, nil
+	case ProviderTogetherAI:
+		if c.Providers.TogetherAI.APIKey == "" {
+			return LLMEndpoint{}, fmt.Errorf("TogetherAI API key (TOGETHER_API_KEY) is required")
+		}
+		return LLMEndpoint{BaseURL: c.Providers.TogetherAI.BaseURL, APIKey: c.Providers.TogetherAI.APIKey, Model: model}, nil
+	default:
+		return LLMEndpoint{}, fmt.Errorf("%w: %s", ErrUnknownProvider, provider)
+	}
+}
+
 type Tools struct {
 	Enabled  bool     `envconfig:"TOOLS_ENABLED" default:"true"` // Enable/disable tools for the server
-	Provider Provider `envconfig:"TOOLS_PROVIDER" default:"togetherai"`
-	Model    string   `envconfig:"TOOLS_MODEL" default:"mistralai/Mixtral-8x7B-Instruct-v0.1"` // gpt-4-1106-preview
+	Provider Provider `envconfig:"TOOLS_PROVIDER" default:"utility"`
+	Model    string   `envconfig:"TOOLS_MODEL"` // the utility LLM's model if empty
+}
+
+// SessionNaming is used to title new sessions from their first question and
+// answer - a small model is plenty for this
+type SessionNaming struct {
+	Enabled  bool     `envconfig:"SESSION_NAMING_ENABLED" default:"true"`
+	Provider Provider `envconfig:"SESSION_NAMING_PROVIDER" default:"utility"`
+	Model    string   `envconfig:"SESSION_NAMING_MODEL" default:"mistralai/Mistral-7B-Instruct-v0.2"`
+}
+
+// Evals is the LLM judge that scores session answers against the original
+// user prompts of an eval run
+type Evals struct {
+	Enabled  bool     `envconfig:"EVALS_ENABLED" default:"true"`
+	Provider Provider `envconfig:"EVALS_PROVIDER" default:"utility"`
+	Model    string   `envconfig:"EVALS_MODEL"`
 }
 
 // Keycloak is used for authentication. You can find keycloak documentation
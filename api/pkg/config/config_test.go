@@ -0,0 +1,81 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveLLM(t *testing.T) {
+	cfg := ServerConfig{
+		Providers: Providers{
+			OpenAI:     OpenAI{APIKey: "openai-key", BaseURL: "https://api.openai.com/v1"},
+			TogetherAI: TogetherAI{APIKey: "together-key", BaseURL: "https://api.together.xyz/v1"},
+		},
+		Utility: Utility{Provider: ProviderTogetherAI, Model: "utility-model"},
+	}
+
+	// the utility LLM's provider and model
+	llm, err := cfg.ResolveLLM(ProviderUtility, "")
+	require.NoError(t, err)
+	assert.Equal(t, LLMEndpoint{BaseURL: "https://api.together.xyz/v1", APIKey: "together-key", Model: "utility-model"}, llm)
+
+	// a feature can ask for its own model on the same endpoint
+	llm, err = cfg.ResolveLLM(ProviderUtility, "small-model")
+	require.NoError(t, err)
+	assert.Equal(t, LLMEndpoint{BaseURL: "https://api.together.xyz/v1", APIKey: "together-key", Model: "small-model"}, llm)
+
+	// or a provider of its own
+	llm, err = cfg.ResolveLLM(ProviderOpenAI, "gpt-4")
+	require.NoError(t, err)
+	assert.Equal(t, LLMEndpoint{BaseURL: "https://api.openai.com/v1", APIKey: "openai-key", Model: "gpt-4"}, llm)
+
+	// a local server doesn't need a key
+	cfg.Utility.BaseURL = "http://localhost:11434/v1"
+	llm, err = cfg.ResolveLLM(ProviderUtility, "")
+	require.NoError(t, err)
+	assert.Equal(t, LLMEndpoint{BaseURL: "http://localhost:11434/v1", Model: "utility-model"}, llm)
+}
+
+func TestResolveLLM_NotConfigured(t *testing.T) {
+	cfg := ServerConfig{Utility: Utility{Provider: ProviderOpenAI}}
+
+	_, err := cfg.ResolveLLM(ProviderUtility, "")
+	assert.ErrorContains(t, err, "OPENAI_API_KEY")
+
+	_, err = cfg.ResolveLLM(ProviderTogetherAI, "")
+	assert.ErrorContains(t, err, "TOGETHER_API_KEY")
+
+	_, err = cfg.ResolveLLM(Provider("other"), "")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+
+	// the utility LLM can't point at itself
+	cfg.Utility.Provider = ProviderUtility
+	_, err = cfg.ResolveLLM(ProviderUtility, "")
+	assert.ErrorContains(t, err, "unknown provider")
+}
+
+func TestLoadServerConfig_FeaturesUseUtilityLLM(t *testing.T) {
+	t.Setenv("UTILITY_LLM_BASE_URL", "http://localhost:11434/v1")
+	t.Setenv("UTILITY_LLM_MODEL", "llama3:instruct")
+	t.Setenv("EVALS_MODEL", "llama3:70b")
+
+	cfg, err := LoadServerConfig()
+	require.NoError(t, err)
+
+	// naming keeps its own small model on the utility endpoint
+	naming, err := cfg.ResolveLLM(cfg.SessionNaming.Provider, cfg.SessionNaming.Model)
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:11434/v1", naming.BaseURL)
+	assert.Equal(t, "mistralai/Mistral-7B-Instruct-v0.2", naming.Model)
+
+	tools, err := cfg.ResolveLLM(cfg.Tools.Provider, cfg.Tools.Model)
+	require.NoError(t, err)
+	assert.Equal(t, "llama3:instruct", tools.Model)
+
+	evals, err := cfg.ResolveLLM(cfg.Evals.Provider, cfg.Evals.Model)
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:11434/v1", evals.BaseURL)
+	assert.Equal(t, "llama3:70b", evals.Model)
+}
@@ -30,6 +30,9 @@ type Runtimes struct {
 		Enabled      bool          `envconfig:"RUNTIME_AXOLOTL_ENABLED" default:"true"`
 		WarmupModels []string      `envconfig:"RUNTIME_AXOLOTL_WARMUP_MODELS" default:"mistralai/Mistral-7B-Instruct-v0.1,stabilityai/stable-diffusion-xl-base-1.0"`
 		InstanceTTL  time.Duration `envconfig:"RUNTIME_AXOLOTL_INSTANCE_TTL" default:"60s"`
+		// if the python process is working on a session but hasn't written
+		// anything for this long then it's wedged and we kill it (0 disables)
+		LivenessTimeout time.Duration `envconfig:"RUNTIME_AXOLOTL_LIVENESS_TIMEOUT" default:"10m"`
 	}
 	Ollama struct {
 		Enabled      bool     `envconfig:"RUNTIME_OLLAMA_ENABLED" default:"true"`
@@ -37,5 +40,8 @@ type Runtimes struct {
 		// Ollama instance can be kept for much longer as it automatically unloads
 		// the model from memory when it's not used
 		InstanceTTL time.Duration `envconfig:"RUNTIME_OLLAMA_INSTANCE_TTL" default:"60s"`
+		// how many inference sessions an instance runs at the same time
+		// ollama batches them so it's cheaper than starting more instances
+		MaxConcurrentSessions int `envconfig:"RUNTIME_OLLAMA_MAX_CONCURRENT_SESSIONS" default:"1"`
 	}
 }
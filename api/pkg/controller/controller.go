@@ -13,6 +13,7 @@ import (
 	"github.com/helixml/helix/api/pkg/janitor"
 	"github.com/helixml/helix/api/pkg/model"
 	"github.com/helixml/helix/api/pkg/notification"
+	"github.com/helixml/helix/api/pkg/openai"
 	"github.com/helixml/helix/api/pkg/store"
 	"github.com/helixml/helix/api/pkg/tools"
 	"github.com/helixml/helix/api/pkg/types"
@@ -43,12 +44,56 @@ type ControllerOptions struct {
 	FilePrefixResults string
 
 	// the URL we post documents to so we can get the text back from them
+	// if this is empty we extract the text from PDF, DOCX and HTML files ourselves
 	TextExtractionURL string
 
 	// how many scheduler decisions to buffer before we start dropping them
 	SchedulingDecisionBufferSize int
 
+	// how much of a head start priority sessions get in the queue, a session
+	// that has been waiting longer than this will still run first
+	SessionPriorityBoost time.Duration
+
+	// how many times we put an interaction back on the queue when the runner
+	// reports an error for it before we give up and mark it as errored
+	MaxInteractionRetries int
+
+	// the most a session's files can add up to in bytes, zero means no
+	// limit - subscribers get MaxSessionFileSizeSubscribed if it is set
+	// and a user's own MaxSessionFileSize overrides both
+	MaxSessionFileSize           int64
+	MaxSessionFileSizeSubscribed int64
+
+	// signs the tokens of session share links, they stop working if it
+	// changes
+	ShareLinkSecret string
+
 	Notifier notification.Notifier
+
+	// titles new sessions once their first question has been answered,
+	// session naming is off if this is nil
+	SessionNamingClient openai.Client
+	SessionNamingModel  string
+
+	// the judge that scores sessions for automatic evals,
+	// automatic evals are off if this is nil
+	EvalClient openai.Client
+	EvalModel  string
+
+	// the most sessions an owner of each type can have running or queued
+	// at once, zero means no limit for that type and a nil map turns
+	// quotas off - an owner's MaxConcurrentSessions overrides these
+	SessionQuotas map[types.OwnerType]int
+
+	// how often the text runners have streamed so far is saved to the store
+	// so it isn't lost if the runner dies part way through, zero turns it off
+	PartialResultInterval time.Duration
+
+	// how long a runner can go without reporting before the sessions it had
+	// streamed part of an answer for are interrupted, the runner is dropped
+	// from the scheduler well before then - zero leaves them for the runner
+	// to hand back when it restarts
+	RunnerInterruptTimeout time.Duration
 }
 
 type Controller struct {
@@ -78,6 +123,53 @@ type Controller struct {
 
 	// the current buffer of scheduling decisions
 	schedulingDecisions []*types.GlobalSchedulingDecision
+
+	// why each queued session has been passed over by the runners
+	sessionSchedulingDecisions map[string][]*types.GlobalSchedulingDecision
+	// guards both lots of scheduling decisions, they are recorded once the
+	// queue is unlocked so runners polling don't hold each other up
+	schedulingDecisionsMtx sync.Mutex
+
+	// how many interactions have completed or errored, for metrics
+	sessionOutcomes sessionOutcomeCounter
+	stageDurations  stageDurationRecorder
+
+	// the sessions running or queued for each owner
+	sessionQuotas sessionQuotaTracker
+
+	// cancelled sessions a runner had already picked up, passed back to the
+	// runners when they report their state so they stop generating
+	stoppedSessions stoppedSessionTracker
+
+	// the last streamed response we passed on for each session
+	streamOrder streamOrder
+
+	// what has been streamed for each running session, saved every
+	// PartialResultInterval
+	partialResults partialResults
+
+	// held while a session's interactions are read and written back
+	sessionLocks sessionLocks
+
+	// runners that have stopped reporting, kept until their sessions are
+	// interrupted in case they come back
+	lostRunners lostRunners
+
+	// which of the jobs the runners report we have written to the store
+	jobHistory jobHistorySync
+
+	// how long the latest jobs took so we can tell users how long they'll wait
+	jobDurations jobDurationHistory
+
+	// tool action runs waiting to be counted in the store
+	toolUsage chan toolUsage
+
+	// queue position updates waiting to be sent to the users
+	queuePositionEvents chan *types.WebsocketEvent
+
+	// the data prep runs going on in this process by session ID
+	dataPrepRuns    map[string]*dataPrepRun
+	dataPrepRunsMtx sync.Mutex
 }
 
 func NewController(
@@ -93,9 +185,6 @@ func NewController(
 	if options.Filestore == nil {
 		return nil, fmt.Errorf("filestore is required")
 	}
-	if options.TextExtractionURL == "" {
-		return nil, fmt.Errorf("text extraction URL is required")
-	}
 	if options.Janitor == nil {
 		return nil, fmt.Errorf("janitor is required")
 	}
@@ -114,6 +203,9 @@ func NewController(
 		models:                         models,
 		activeRunners:                  xsync.NewMapOf[string, *types.RunnerState](),
 		schedulingDecisions:            []*types.GlobalSchedulingDecision{},
+		sessionSchedulingDecisions:     map[string][]*types.GlobalSchedulingDecision{},
+		toolUsage:                      make(chan toolUsage, toolUsageBufferSize),
+		queuePositionEvents:            make(chan *types.WebsocketEvent, queuePositionEventBufferSize),
 	}
 	return controller, nil
 }
@@ -132,6 +224,18 @@ func (c *Controller) Initialize() error {
 			case event := <-c.RunnerWebsocketEventChanReader:
 				log.Trace().Msgf("Runner websocket event: %+v", *event)
 
+				if event.WorkerTaskResponse != nil && !c.streamOrder.accept(event.WorkerTaskResponse) {
+					continue
+				}
+				if event.WorkerTaskResponse != nil {
+					c.partialResults.add(event.WorkerTaskResponse)
+				}
+
+				event, ok := streamDeltaEvent(event)
+				if !ok {
+					continue
+				}
+
 				err := c.BroadcastWebsocketEvent(context.Background(), event)
 				if err != nil {
 					log.Error().Msgf("Error handling runner websocket event: %s", err.Error())
@@ -140,6 +244,18 @@ func (c *Controller) Initialize() error {
 		}
 	}()
 
+	// tool usage is counted in the background so running an action
+	// doesn't wait on the database
+	go c.writeToolUsage(c.Ctx)
+
+	// users are told their place in the queue in the background so
+	// whatever moved the queue doesn't wait on the websockets
+	go c.publishQueuePositions(c.Ctx)
+
+	if c.Options.PartialResultInterval > 0 {
+		go c.persistPartialResultsLoop(c.Ctx, c.Options.PartialResultInterval)
+	}
+
 	// load the session queue from the database to survive restarts
 	err := c.loadSessionQueues(c.Ctx)
 	if err != nil {
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"path"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
 
@@ -152,25 +151,42 @@ func (c *Controller) convertDocumentsToText(session *types.Session) (*types.Sess
 				}
 			}
 
-			// for local development - the file server hostname will not resolve
-			// from inside the unstructured container
-			fileURL = strings.Replace(fileURL, "http://localhost", "http://api", 1)
-
-			res, err := system.PostRequest[convertDocumentsToChunksRequest, convertDocumentsToChunksResponse](
-				system.ClientOptions{},
-				c.Options.TextExtractionURL,
-				convertDocumentsToChunksRequest{
-					URL: fileURL,
-				},
-			)
-			if err != nil {
-				return err
+			extractedText := ""
+			if c.Options.TextExtractionURL == "" && originalFile != file {
+				// we only extract text from files we already have
+				return fmt.Errorf("extracting text from %s needs the text extraction service (TEXT_EXTRACTION_URL)", path.Base(file))
+			} else if c.Options.TextExtractionURL == "" {
+				// no unstructured service so we extract what we can ourselves
+				content, err := getFileContent(c.Ctx, c.Options.Filestore, file)
+				if err != nil {
+					return err
+				}
+				extractedText, err = text.ExtractText(file, "", []byte(content))
+				if err != nil {
+					return err
+				}
+			} else {
+				// for local development - the file server hostname will not resolve
+				// from inside the unstructured container
+				fileURL = strings.Replace(fileURL, "http://localhost", "http://api", 1)
+
+				res, err := system.PostRequest[convertDocumentsToChunksRequest, convertDocumentsToChunksResponse](
+					system.ClientOptions{},
+					c.Options.TextExtractionURL,
+					convertDocumentsToChunksRequest{
+						URL: fileURL,
+					},
+				)
+				if err != nil {
+					return err
+				}
+				extractedText = res.Text
 			}
 
 			atomic.AddInt64(&completedCounter, 1)
 			newFilepath := strings.TrimSuffix(file, path.Ext(file)) + ".txt"
 
-			_, err = c.Options.Filestore.UploadFile(c.Ctx, newFilepath, strings.NewReader(res.Text))
+			_, err = c.Options.Filestore.UploadFile(c.Ctx, newFilepath, strings.NewReader(extractedText))
 			if err != nil {
 				return err
 			}
@@ -271,7 +287,9 @@ func (c *Controller) getChunksToProcess(session *types.Session, dataprep text.Da
 	return chunksToProcess, nil
 }
 
-func (c *Controller) convertChunksToQuestions(session *types.Session) (*types.Session, int, error) {
+// cancelling the context stops the chunks that are converting and the ones
+// that have yet to start - the questions already saved are left alone
+func (c *Controller) convertChunksToQuestions(ctx context.Context, session *types.Session) (*types.Session, int, error) {
 	userInteraction, err := data.GetUserInteraction(session)
 	if err != nil {
 		return nil, 0, err
@@ -304,66 +322,49 @@ func (c *Controller) convertChunksToQuestions(session *types.Session) (*types.Se
 	session = c.WriteInteraction(session, systemInteraction)
 	c.BroadcastProgress(session, 1, initialMessage)
 
-	var completedCounter int64
-	var errorCounter int64
-	var outerError error
-
-	// we use this to only append questions to one file at a time
-	var writeUpdatesMutex sync.Mutex
+	completedCounter := 0
+	errorCounter := 0
 
 	runningFileList := copyFileList(userInteraction.Files)
-	outerError = system.ForEachConcurrently[*text.DataPrepTextSplitterChunk](
-		chunksToProcess,
-		dataprep.GetConcurrency(),
-		func(chunk *text.DataPrepTextSplitterChunk, i int) error {
-			log.Info().Msgf("🔵 question conversion start %d of %d", i+1, len(chunksToProcess))
-			questions, convertError := dataprep.ConvertChunk(chunk.Text, chunk.Index, chunk.DocumentID, chunk.DocumentGroupID, chunk.PromptName)
-
-			// if this is set then we have a non GPT error and should just stop what we are doing
-			if outerError != nil {
-				return nil
-			}
 
-			// write the updates inside a mutex so we don't get a race
-			err = func() error {
-				writeUpdatesMutex.Lock()
-				defer writeUpdatesMutex.Unlock()
-
-				if convertError == nil {
-					// if there is no JSONL file - make it appear
-					if !hasQuestionsFile(userInteraction, chunk.Filename) {
-						runningFileList = injectFileToList(runningFileList, chunk.Filename, getQuestionsFilename(chunk.Filename))
-						userInteraction.Files = runningFileList
-
-						// we want to write an empty file to the filestore here
-						// because then appendQuestionsToFile doesn't need to deal with making it
-						_, err = c.Options.Filestore.UploadFile(c.Ctx, getQuestionsFilename(chunk.Filename), strings.NewReader(""))
-						if err != nil {
-							log.Error().Msgf("error uploading file: %s", err.Error())
-							return err
-						}
-					}
-					innerErr := appendQuestionsToFile(c.Ctx, c.Options.Filestore, getQuestionsFilename(chunk.Filename), questions)
-					if innerErr != nil {
-						log.Error().Msgf("error adding questions to file: %s", innerErr.Error())
-						return innerErr
+	// the handler is only ever called for one chunk at a time so we can
+	// append questions to the files and update the interactions without a lock
+	_, err = text.ConvertChunks(
+		ctx,
+		dataprep,
+		chunksToProcess,
+		func(result *text.DataPrepTextChunkResult, i int) error {
+			chunk := result.Chunk
+			convertError := result.Error
+
+			if convertError == nil {
+				// if there is no JSONL file - make it appear
+				if !hasQuestionsFile(userInteraction, chunk.Filename) {
+					runningFileList = injectFileToList(runningFileList, chunk.Filename, getQuestionsFilename(chunk.Filename))
+					userInteraction.Files = runningFileList
+
+					// we want to write an empty file to the filestore here
+					// because then appendQuestionsToFile doesn't need to deal with making it
+					_, err := c.Options.Filestore.UploadFile(c.Ctx, getQuestionsFilename(chunk.Filename), strings.NewReader(""))
+					if err != nil {
+						log.Error().Msgf("error uploading file: %s", err.Error())
+						return err
 					}
-					atomic.AddInt64(&completedCounter, 1)
-				} else {
-					atomic.AddInt64(&errorCounter, 1)
 				}
-
-				// this marks the QA chunk as "done" - even with an error
-				// we then give the user the choice to try again, abort or ignore the errors
-				systemInteraction = updateProcessedQAChunk(systemInteraction, chunk.Filename, chunk.Index, chunk.PromptName, len(questions), convertError)
-
-				return nil
-			}()
-
-			if err != nil {
-				return err
+				err := appendQuestionsToFile(c.Ctx, c.Options.Filestore, getQuestionsFilename(chunk.Filename), result.Questions)
+				if err != nil {
+					log.Error().Msgf("error adding questions to file: %s", err.Error())
+					return err
+				}
+				completedCounter++
+			} else {
+				errorCounter++
 			}
 
+			// this marks the QA chunk as "done" - even with an error
+			// we then give the user the choice to try again, abort or ignore the errors
+			systemInteraction = updateProcessedQAChunk(systemInteraction, chunk, len(result.Questions), convertError)
+
 			session = c.WriteInteraction(session, userInteraction)
 
 			percentConverted := int((float64(completedCounter) + float64(errorCounter)) / float64(len(chunksToProcess)) * 100)
@@ -373,10 +374,22 @@ func (c *Controller) convertChunksToQuestions(session *types.Session) (*types.Se
 			systemInteraction.Progress = percentConverted
 			session = c.WriteInteraction(session, systemInteraction)
 
+			processedChunk := types.DataPrepChunk{
+				Index:           chunk.Index,
+				PromptName:      chunk.PromptName,
+				QuestionCount:   len(result.Questions),
+				DocumentID:      chunk.DocumentID,
+				SpansDocumentID: chunk.SpansDocumentID,
+			}
+			if convertError != nil {
+				processedChunk.Error = convertError.Error()
+			}
+			c.BroadcastDataPrepChunk(session, systemInteraction, path.Base(chunk.Filename), processedChunk)
+
 			if convertError != nil {
 				log.Error().Msgf("🔴 question conversion error %s", convertError.Error())
 			} else {
-				log.Info().Msgf("🟢 question conversion complete %d of %d", i+1, len(chunksToProcess))
+				log.Debug().Msgf("🟢 question conversion complete %d of %d", i+1, len(chunksToProcess))
 			}
 
 			return nil
@@ -385,8 +398,8 @@ func (c *Controller) convertChunksToQuestions(session *types.Session) (*types.Se
 
 	// if this error is hit - it means something has actually gone wrong rather than a data prep error
 	// we catch the data prep errors and present them to the user once all processing is done
-	if outerError != nil {
-		return nil, 0, outerError
+	if err != nil {
+		return nil, 0, err
 	}
 
 	finishedMessage := fmt.Sprintf("converted %d text chunks", len(chunksToProcess))
@@ -420,6 +433,61 @@ func (c *Controller) convertChunksToQuestions(session *types.Session) (*types.Se
 	return session, len(chunksToProcess), nil
 }
 
+// split the texts the same way a finetune would and ask the question generator
+// what converting them would cost - nothing is saved or sent to the model
+func (c *Controller) EstimateDataPrepCost(ctx types.RequestContext, req types.DataPrepCostEstimateRequest) (*types.DataPrepCostEstimate, error) {
+	session := &types.Session{
+		ID:        system.GenerateSessionID(),
+		Owner:     ctx.Owner,
+		OwnerType: ctx.OwnerType,
+		Metadata: types.SessionMetadata{
+			DataPrepChunkSize: req.ChunkSize,
+		},
+	}
+
+	dataprep, splitter, err := c.Options.DataPrepTextFactory(session)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, content := range req.Texts {
+		_, err := splitter.AddDocument(fmt.Sprintf("document-%d.txt", i), content, session.ID, session)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	chunks := []string{}
+	for _, chunk := range splitter.Chunks {
+		chunks = append(chunks, chunk.Text)
+	}
+
+	estimate, err := dataprep.EstimateCost(chunks)
+	if err != nil {
+		return nil, err
+	}
+	return &estimate, nil
+}
+
+// check the data prep chunk size and concurrency a fine tune asks for (zero
+// for the defaults) with the data prep module before the session is created
+// rather than have it fail once data prep starts
+func (c *Controller) ValidateDataPrepSettings(chunkSize, concurrency int) error {
+	if chunkSize < 0 || concurrency < 0 {
+		return fmt.Errorf("data prep chunk size and concurrency must be more than zero")
+	}
+	if chunkSize == 0 && concurrency == 0 {
+		return nil
+	}
+	_, _, err := c.Options.DataPrepTextFactory(&types.Session{
+		Metadata: types.SessionMetadata{
+			DataPrepChunkSize:   chunkSize,
+			DataPrepConcurrency: concurrency,
+		},
+	})
+	return err
+}
+
 func (c *Controller) convertChunksToQuestionsErrorCount(session *types.Session) (int, error) {
 	systemInteraction, err := data.GetSystemInteraction(session)
 	if err != nil {
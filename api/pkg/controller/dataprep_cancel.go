@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"path"
+	"time"
+
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+// the error a text fine tune is left with when its data prep is cancelled
+var ErrDataPrepCancelled = errors.New("data prep cancelled")
+
+// there is no data prep going on for the session - it has finished, has not
+// started or the session is not a text fine tune
+var ErrNoDataPrep = errors.New("the session is not preparing data")
+
+// a data prep run going on in this process
+type dataPrepRun struct {
+	// whose session it is so an owner's runs can be found without loading them
+	owner  sessionQuotaOwner
+	cancel context.CancelFunc
+	// closed once the run has stopped writing to the session
+	done chan struct{}
+}
+
+// register a data prep run for the session, the context is cancelled by
+// CancelDataPrep and finish must be called once the run stops
+func (c *Controller) startDataPrepRun(session *types.Session) (context.Context, func()) {
+	sessionID := session.ID
+	ctx, cancel := context.WithCancel(c.Ctx)
+	run := &dataPrepRun{
+		owner:  sessionQuotaOwner{OwnerType: session.OwnerType, Owner: session.Owner},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	c.dataPrepRunsMtx.Lock()
+	if c.dataPrepRuns == nil {
+		c.dataPrepRuns = map[string]*dataPrepRun{}
+	}
+	c.dataPrepRuns[sessionID] = run
+	c.dataPrepRunsMtx.Unlock()
+
+	return ctx, func() {
+		c.dataPrepRunsMtx.Lock()
+		// a retry may have replaced us
+		if c.dataPrepRuns[sessionID] == run {
+			delete(c.dataPrepRuns, sessionID)
+		}
+		c.dataPrepRunsMtx.Unlock()
+		cancel()
+		close(run.done)
+	}
+}
+
+// cancel the session's data prep run if there is one and wait for it to stop
+func (c *Controller) stopDataPrepRun(ctx context.Context, sessionID string) error {
+	c.dataPrepRunsMtx.Lock()
+	run := c.dataPrepRuns[sessionID]
+	c.dataPrepRunsMtx.Unlock()
+
+	if run == nil {
+		return nil
+	}
+
+	run.cancel()
+	select {
+	case <-run.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func isPreparingData(session *types.Session, systemInteraction *types.Interaction) bool {
+	if session.Type != types.SessionTypeText || session.Mode != types.SessionModeFinetune || systemInteraction.Finished {
+		return false
+	}
+	return systemInteraction.DataPrepStage == types.TextDataPrepStageExtractText ||
+		systemInteraction.DataPrepStage == types.TextDataPrepStageGenerateQuestions
+}
+
+// stop the data prep of a text fine tune - the chunks being converted are
+// cancelled and the rest never start so nothing more is sent to the model
+// the questions generated so far are deleted and the interaction errors
+// unless keepQuestions is set, then it moves on to editing the questions
+func (c *Controller) CancelDataPrep(ctx context.Context, session *types.Session, keepQuestions bool) (*types.Session, error) {
+	systemInteraction, err := data.GetSystemInteraction(session)
+	if err != nil {
+		return nil, err
+	}
+	if !isPreparingData(session, systemInteraction) {
+		return nil, ErrNoDataPrep
+	}
+
+	err = c.stopDataPrepRun(ctx, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the run has been writing to the session up until now
+	session, err = c.Options.Store.GetSession(ctx, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	systemInteraction, err = data.GetSystemInteraction(session)
+	if err != nil {
+		return nil, err
+	}
+	// it finished before we stopped it
+	if !isPreparingData(session, systemInteraction) {
+		return nil, ErrNoDataPrep
+	}
+
+	userInteraction, err := data.GetUserInteraction(session)
+	if err != nil {
+		return nil, err
+	}
+
+	questionsKept := false
+	if keepQuestions {
+		for _, file := range userInteraction.Files {
+			if path.Base(file) == types.TEXT_DATA_PREP_QUESTIONS_FILE {
+				questionsKept = true
+			}
+		}
+	}
+
+	if questionsKept {
+		systemInteraction.Status = "data prep cancelled, the questions generated so far were kept"
+		systemInteraction.Progress = 0
+		systemInteraction.State = types.InteractionStateEditing
+		systemInteraction.DataPrepStage = types.TextDataPrepStageEditQuestions
+	} else {
+		files := []string{}
+		for _, file := range userInteraction.Files {
+			if path.Base(file) != types.TEXT_DATA_PREP_QUESTIONS_FILE {
+				files = append(files, file)
+				continue
+			}
+			err = c.Options.Filestore.Delete(ctx, file)
+			if err != nil {
+				log.Error().Msgf("error deleting questions file %s: %s", file, err.Error())
+			}
+		}
+		userInteraction.Files = files
+		userInteraction.Finished = true
+		userInteraction.State = types.InteractionStateComplete
+
+		systemInteraction.DataPrepChunks = map[string][]types.DataPrepChunk{}
+		systemInteraction.Status = ErrDataPrepCancelled.Error()
+		systemInteraction.Progress = 0
+		systemInteraction.State = types.InteractionStateError
+		systemInteraction.Error = ErrDataPrepCancelled.Error()
+		systemInteraction.Completed = time.Now()
+		systemInteraction.Finished = true
+	}
+
+	// the interactions are the ones in the session so this saves both
+	c.WriteSession(session)
+
+	log.Info().
+		Str("session_id", session.ID).
+		Bool("questions_kept", questionsKept).
+		Msg("data prep cancelled")
+
+	return session, nil
+}
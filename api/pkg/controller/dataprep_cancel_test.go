@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/filestore"
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newDataPrepSession(stage types.TextDataPrepStage) *types.Session {
+	return &types.Session{
+		ID:   "session-1",
+		Type: types.SessionTypeText,
+		Mode: types.SessionModeFinetune,
+		Interactions: []*types.Interaction{
+			{
+				ID:      "user-1",
+				Creator: types.CreatorTypeUser,
+				Files:   []string{"sessions/session-1/doc.txt", "sessions/session-1/" + types.TEXT_DATA_PREP_QUESTIONS_FILE},
+			},
+			{
+				ID:            "system-1",
+				Creator:       types.CreatorTypeSystem,
+				State:         types.InteractionStateWaiting,
+				DataPrepStage: stage,
+				DataPrepChunks: map[string][]types.DataPrepChunk{
+					"doc.txt": {{Index: 0, QuestionCount: 5}},
+				},
+			},
+		},
+	}
+}
+
+// a controller whose store hands back the session and keeps whatever is
+// written to it
+func newDataPrepCancelController(t *testing.T, session *types.Session) (*Controller, filestore.FileStore, *types.Session) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	saved := &types.Session{}
+
+	mockStore.EXPECT().GetSession(gomock.Any(), session.ID).Return(session, nil).AnyTimes()
+	mockStore.EXPECT().UpdateSession(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, session types.Session) (*types.Session, error) {
+		*saved = session
+		return &session, nil
+	}).AnyTimes()
+
+	fs := filestore.NewFileSystemStorage(t.TempDir(), "", "")
+	_, err := fs.UploadFile(context.Background(), "sessions/session-1/"+types.TEXT_DATA_PREP_QUESTIONS_FILE, strings.NewReader("{}"))
+	require.NoError(t, err)
+
+	return &Controller{
+		Ctx:                          context.Background(),
+		Options:                      ControllerOptions{Store: mockStore, Filestore: fs},
+		UserWebsocketEventChanWriter: make(chan *types.WebsocketEvent, 10),
+	}, fs, saved
+}
+
+func TestCancelDataPrep(t *testing.T) {
+	session := newDataPrepSession(types.TextDataPrepStageGenerateQuestions)
+	c, fs, saved := newDataPrepCancelController(t, session)
+
+	// a run that only stops when it is cancelled
+	ctx, finish := c.startDataPrepRun(session)
+	stopped := false
+	go func() {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		stopped = true
+		finish()
+	}()
+
+	cancelled, err := c.CancelDataPrep(context.Background(), session, false)
+	require.NoError(t, err)
+	assert.True(t, stopped)
+
+	systemInteraction := cancelled.Interactions[1]
+	assert.Equal(t, types.InteractionStateError, systemInteraction.State)
+	assert.Equal(t, ErrDataPrepCancelled.Error(), systemInteraction.Error)
+	assert.True(t, systemInteraction.Finished)
+	assert.Empty(t, systemInteraction.DataPrepChunks)
+	assert.Equal(t, []string{"sessions/session-1/doc.txt"}, cancelled.Interactions[0].Files)
+	assert.Equal(t, cancelled.ID, saved.ID)
+
+	_, err = fs.Get(context.Background(), "sessions/session-1/"+types.TEXT_DATA_PREP_QUESTIONS_FILE)
+	assert.Error(t, err)
+}
+
+func TestCancelDataPrep_KeepQuestions(t *testing.T) {
+	session := newDataPrepSession(types.TextDataPrepStageGenerateQuestions)
+	c, fs, _ := newDataPrepCancelController(t, session)
+
+	cancelled, err := c.CancelDataPrep(context.Background(), session, true)
+	require.NoError(t, err)
+
+	systemInteraction := cancelled.Interactions[1]
+	assert.Equal(t, types.InteractionStateEditing, systemInteraction.State)
+	assert.Equal(t, types.TextDataPrepStageEditQuestions, systemInteraction.DataPrepStage)
+	assert.Len(t, systemInteraction.DataPrepChunks["doc.txt"], 1)
+	assert.Len(t, cancelled.Interactions[0].Files, 2)
+
+	_, err = fs.Get(context.Background(), "sessions/session-1/"+types.TEXT_DATA_PREP_QUESTIONS_FILE)
+	assert.NoError(t, err)
+}
+
+func TestCancelDataPrep_NotPreparing(t *testing.T) {
+	for _, stage := range []types.TextDataPrepStage{types.TextDataPrepStageEditQuestions, types.TextDataPrepStageFineTune} {
+		t.Run(string(stage), func(t *testing.T) {
+			session := newDataPrepSession(stage)
+			c, _, _ := newDataPrepCancelController(t, session)
+
+			_, err := c.CancelDataPrep(context.Background(), session, false)
+			assert.ErrorIs(t, err, ErrNoDataPrep)
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	openai "github.com/lukemarsden/go-openai2"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+const (
+	evalJudgeTimeout = 2 * time.Minute
+	// keeps long conversations within the judge's context window
+	evalJudgeMaxMessageLength = 4000
+)
+
+// there is no judge model configured so we can't score sessions automatically
+var ErrEvalsNotEnabled = errors.New("automatic evals are not enabled")
+
+const evalJudgePrompt = `You are grading the answers of an AI assistant.
+You are given the prompts the user originally asked and the conversation the assistant had with the user.
+Score how well the assistant's answers address the original prompts from 0.0 (not at all) to 1.0 (perfectly).
+Reply with only a JSON object like {"score": 0.5, "reason": "one or two sentences explaining the score"}.`
+
+// set one of the eval scores of a session - the score has already been
+// checked with types.ValidateEvalScore
+func (c *Controller) ScoreSession(ctx context.Context, session *types.Session, scoreType types.EvalScoreType, score types.SessionEvalScore) (*types.Session, error) {
+	switch scoreType {
+	case types.EvalScoreTypeUser:
+		session.Metadata.EvalUserScore = score.Score
+		session.Metadata.EvalUserReason = score.Reason
+	case types.EvalScoreTypeManual:
+		session.Metadata.EvalManualScore = score.Score
+		session.Metadata.EvalManualReason = score.Reason
+	case types.EvalScoreTypeAutomatic:
+		session.Metadata.EvalAutomaticScore = score.Score
+		session.Metadata.EvalAutomaticReason = score.Reason
+	default:
+		return nil, fmt.Errorf("unknown eval score type: %s", scoreType)
+	}
+
+	session.Updated = time.Now()
+	return c.Options.Store.UpdateSession(ctx, *session)
+}
+
+// ask the judge model how well the session answered the original user
+// prompts of the eval and save what it said as the automatic score
+func (c *Controller) AutomaticallyScoreSession(ctx context.Context, session *types.Session) (*types.Session, error) {
+	if c.Options.EvalClient == nil {
+		return nil, ErrEvalsNotEnabled
+	}
+	if len(session.Metadata.EvalOriginalUserPrompts) == 0 {
+		return nil, fmt.Errorf("session %s has no original user prompts to score against", session.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, evalJudgeTimeout)
+	defer cancel()
+
+	resp, err := c.Options.EvalClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: c.Options.EvalModel,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: evalJudgePrompt,
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: evalJudgeQuestion(session),
+			},
+		},
+		Temperature: 0,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get eval judge response: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("eval judge returned no choices")
+	}
+
+	score, err := parseEvalJudgement(resp.Choices[0].Message.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ScoreSession(ctx, session, types.EvalScoreTypeAutomatic, *score)
+}
+
+func evalJudgeQuestion(session *types.Session) string {
+	var b strings.Builder
+	b.WriteString("Original prompts:\n")
+	for _, prompt := range session.Metadata.EvalOriginalUserPrompts {
+		fmt.Fprintf(&b, "- %s\n", truncateRunes(prompt, evalJudgeMaxMessageLength))
+	}
+	b.WriteString("\nConversation:\n")
+	for _, interaction := range session.Interactions {
+		if interaction.Message == "" {
+			continue
+		}
+		role := "Assistant"
+		if interaction.Creator == types.CreatorTypeUser {
+			role = "User"
+		}
+		fmt.Fprintf(&b, "%s: %s\n\n", role, truncateRunes(interaction.Message, evalJudgeMaxMessageLength))
+	}
+	return b.String()
+}
+
+// the judge is asked for bare JSON but models like to wrap it in prose or
+// code fences so we pick out the outermost object
+func parseEvalJudgement(content string) (*types.SessionEvalScore, error) {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start == -1 || end < start {
+		return nil, fmt.Errorf("eval judge did not return a JSON object: %q", content)
+	}
+
+	var judgement struct {
+		Score  json.Number `json:"score"`
+		Reason string      `json:"reason"`
+	}
+	err := json.Unmarshal([]byte(content[start:end+1]), &judgement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse eval judge response: %w", err)
+	}
+
+	score := judgement.Score.String()
+	if score == "" {
+		return nil, fmt.Errorf("eval judge did not return a score: %q", content)
+	}
+	err = types.ValidateEvalScore(score)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.SessionEvalScore{
+		Score:  score,
+		Reason: strings.TrimSpace(judgement.Reason),
+	}, nil
+}
+
+func (c *Controller) GetEvalRunStats(ctx context.Context, evalRunID string) (*types.EvalRunStats, error) {
+	sessions, err := c.Options.Store.ListEvalRunSessions(ctx, evalRunID)
+	if err != nil {
+		return nil, err
+	}
+	return getEvalRunStats(evalRunID, sessions), nil
+}
+
+func getEvalRunStats(evalRunID string, sessions []*types.Session) *types.EvalRunStats {
+	userScores := []string{}
+	manualScores := []string{}
+	automaticScores := []string{}
+	for _, session := range sessions {
+		userScores = append(userScores, session.Metadata.EvalUserScore)
+		manualScores = append(manualScores, session.Metadata.EvalManualScore)
+		automaticScores = append(automaticScores, session.Metadata.EvalAutomaticScore)
+	}
+	return &types.EvalRunStats{
+		EvalRunID: evalRunID,
+		Sessions:  len(sessions),
+		User:      getEvalScoreStats(userScores),
+		Manual:    getEvalScoreStats(manualScores),
+		Automatic: getEvalScoreStats(automaticScores),
+	}
+}
+
+// unrated sessions ("") are left out - as are any scores that were written
+// before we validated them
+func getEvalScoreStats(scores []string) types.EvalScoreStats {
+	stats := types.EvalScoreStats{
+		Distribution: map[string]int{},
+	}
+	total := 0.0
+	for _, score := range scores {
+		if score == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(score, 64)
+		if err != nil || math.IsNaN(value) {
+			continue
+		}
+		stats.Count++
+		total += value
+		stats.Distribution[strconv.FormatFloat(math.Round(value*10)/10, 'f', 1, 64)]++
+	}
+	if stats.Count > 0 {
+		stats.Mean = total / float64(stats.Count)
+	}
+	return stats
+}
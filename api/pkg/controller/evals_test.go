@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestGetEvalRunStats(t *testing.T) {
+	sessions := []*types.Session{
+		{Metadata: types.SessionMetadata{EvalUserScore: "1.0", EvalManualScore: "0.5", EvalAutomaticScore: "0.75"}},
+		{Metadata: types.SessionMetadata{EvalUserScore: "0.0", EvalAutomaticScore: "0.72"}},
+		// unrated is not the same as a zero score
+		{Metadata: types.SessionMetadata{EvalUserScore: ""}},
+		{Metadata: types.SessionMetadata{EvalUserScore: "1"}},
+	}
+
+	stats := getEvalRunStats("run-1", sessions)
+	assert.Equal(t, "run-1", stats.EvalRunID)
+	assert.Equal(t, 4, stats.Sessions)
+
+	assert.Equal(t, 3, stats.User.Count)
+	assert.InDelta(t, 2.0/3.0, stats.User.Mean, 0.0001)
+	assert.Equal(t, map[string]int{"0.0": 1, "1.0": 2}, stats.User.Distribution)
+
+	assert.Equal(t, types.EvalScoreStats{Count: 1, Mean: 0.5, Distribution: map[string]int{"0.5": 1}}, stats.Manual)
+
+	assert.Equal(t, 2, stats.Automatic.Count)
+	assert.InDelta(t, 0.735, stats.Automatic.Mean, 0.0001)
+	assert.Equal(t, map[string]int{"0.7": 1, "0.8": 1}, stats.Automatic.Distribution)
+}
+
+func TestParseEvalJudgement(t *testing.T) {
+	score, err := parseEvalJudgement("Sure!\n```json\n{\"score\": 0.8, \"reason\": \" Covers both prompts. \"}\n```")
+	require.NoError(t, err)
+	assert.Equal(t, &types.SessionEvalScore{Score: "0.8", Reason: "Covers both prompts."}, score)
+
+	score, err = parseEvalJudgement(`{"score": "1.0", "reason": "perfect"}`)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", score.Score)
+
+	for _, content := range []string{
+		"I would give it 0.8",
+		`{"reason": "no score"}`,
+		`{"score": 8, "reason": "out of ten"}`,
+		`{"score": "good"}`,
+	} {
+		_, err = parseEvalJudgement(content)
+		assert.Error(t, err, content)
+	}
+}
+
+func TestAutomaticallyScoreSession(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	judge := &fakeNamingClient{title: `{"score": 0.9, "reason": "answers the question"}`}
+	c := &Controller{
+		Options: ControllerOptions{
+			Store:      mockStore,
+			EvalClient: judge,
+			EvalModel:  "judge-model",
+		},
+	}
+
+	session := &types.Session{
+		ID: "session-1",
+		Interactions: []*types.Interaction{
+			{Creator: types.CreatorTypeUser, Message: "what is the capital of France?"},
+			{Creator: types.CreatorTypeSystem, Message: "Paris"},
+		},
+		Metadata: types.SessionMetadata{
+			EvalRunId:               "run-1",
+			EvalOriginalUserPrompts: []string{"what is the capital of France?"},
+		},
+	}
+
+	mockStore.EXPECT().UpdateSession(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, session types.Session) (*types.Session, error) {
+		return &session, nil
+	})
+
+	updated, err := c.AutomaticallyScoreSession(context.Background(), session)
+	require.NoError(t, err)
+	assert.Equal(t, "0.9", updated.Metadata.EvalAutomaticScore)
+	assert.Equal(t, "answers the question", updated.Metadata.EvalAutomaticReason)
+	assert.Equal(t, "run-1", updated.Metadata.EvalRunId)
+
+	require.Len(t, judge.requests, 1)
+	assert.Equal(t, "judge-model", judge.requests[0].Model)
+	assert.Contains(t, judge.requests[0].Messages[1].Content, "Assistant: Paris")
+
+	c.Options.EvalClient = nil
+	_, err = c.AutomaticallyScoreSession(context.Background(), session)
+	assert.ErrorIs(t, err, ErrEvalsNotEnabled)
+}
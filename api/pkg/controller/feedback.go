@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// the longest comment we keep with a rating
+const maxFeedbackCommentLength = 4000
+
+// set (or clear with an empty rating) the user's feedback on one of the
+// assistant's finished answers - the rating has already been checked with
+// types.ValidateFeedbackRating
+func (c *Controller) SetInteractionFeedback(ctx types.RequestContext, session *types.Session, interactionID string, req types.InteractionFeedbackRequest) (*types.Session, error) {
+	interaction, err := data.GetInteraction(session, interactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Rating == "" {
+		interaction.Feedback = nil
+	} else {
+		interaction.Feedback = &types.InteractionFeedback{
+			Rating:  req.Rating,
+			Comment: truncateRunes(strings.TrimSpace(req.Comment), maxFeedbackCommentLength),
+			Rater:   ctx.Owner,
+			Created: time.Now(),
+		}
+	}
+
+	session.Updated = time.Now()
+	return c.Options.Store.UpdateSession(ctx.Ctx, *session)
+}
+
+func (c *Controller) GetFeedbackStats(ctx context.Context, query store.ListFeedbackSessionsQuery) (*types.FeedbackStats, error) {
+	sessions, err := c.Options.Store.ListFeedbackSessions(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return getFeedbackStats(sessions, query.After, query.Before), nil
+}
+
+func (c *Controller) GetSessionFeedbackStats(session *types.Session) *types.SessionFeedbackStats {
+	return &types.SessionFeedbackStats{
+		SessionID:      session.ID,
+		ModelName:      session.ModelName,
+		FeedbackCounts: getFeedbackCounts(session, time.Time{}, time.Time{}),
+	}
+}
+
+// the store can only narrow the sessions down by when they were updated so
+// the feedback is checked against the window here
+func getFeedbackStats(sessions []*types.Session, after, before time.Time) *types.FeedbackStats {
+	stats := &types.FeedbackStats{
+		Models: map[types.ModelName]types.FeedbackCounts{},
+	}
+	for _, session := range sessions {
+		counts := getFeedbackCounts(session, after, before)
+		if counts.Up+counts.Down == 0 {
+			continue
+		}
+		stats.Sessions++
+		stats.Total = addFeedbackCounts(stats.Total, counts)
+		stats.Models[session.ModelName] = addFeedbackCounts(stats.Models[session.ModelName], counts)
+	}
+	return stats
+}
+
+func getFeedbackCounts(session *types.Session, after, before time.Time) types.FeedbackCounts {
+	counts := types.FeedbackCounts{}
+	for _, interaction := range session.Interactions {
+		feedback := interaction.Feedback
+		if feedback == nil {
+			continue
+		}
+		if (!after.IsZero() && feedback.Created.Before(after)) || (!before.IsZero() && feedback.Created.After(before)) {
+			continue
+		}
+		switch feedback.Rating {
+		case types.FeedbackRatingUp:
+			counts.Up++
+		case types.FeedbackRatingDown:
+			counts.Down++
+		default:
+			continue
+		}
+		if feedback.Comment != "" {
+			counts.Comments++
+		}
+	}
+	return counts
+}
+
+func addFeedbackCounts(a, b types.FeedbackCounts) types.FeedbackCounts {
+	return types.FeedbackCounts{
+		Up:       a.Up + b.Up,
+		Down:     a.Down + b.Down,
+		Comments: a.Comments + b.Comments,
+	}
+}
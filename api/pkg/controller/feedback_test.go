@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newFeedbackInteraction(rating types.FeedbackRating, comment string, created time.Time) *types.Interaction {
+	return &types.Interaction{
+		Creator:  types.CreatorTypeSystem,
+		Finished: true,
+		Feedback: &types.InteractionFeedback{Rating: rating, Comment: comment, Created: created},
+	}
+}
+
+func TestGetFeedbackStats(t *testing.T) {
+	now := time.Now()
+	sessions := []*types.Session{
+		{
+			ModelName: types.Model_Ollama_Llama3_8b,
+			Interactions: []*types.Interaction{
+				{Creator: types.CreatorTypeUser},
+				newFeedbackInteraction(types.FeedbackRatingUp, "", now),
+				{Creator: types.CreatorTypeUser},
+				newFeedbackInteraction(types.FeedbackRatingDown, "made that up", now),
+			},
+		},
+		{
+			ModelName: types.Model_Ollama_Llama3_8b,
+			Interactions: []*types.Interaction{
+				newFeedbackInteraction(types.FeedbackRatingDown, "", now),
+				// given before the window
+				newFeedbackInteraction(types.FeedbackRatingUp, "", now.Add(-2*time.Hour)),
+			},
+		},
+		{
+			ModelName: types.Model_Ollama_Mistral7b,
+			Interactions: []*types.Interaction{
+				newFeedbackInteraction(types.FeedbackRatingUp, "great", now),
+			},
+		},
+		// nothing in the window so it's not counted
+		{
+			ModelName: types.Model_Ollama_Mistral7b,
+			Interactions: []*types.Interaction{
+				newFeedbackInteraction(types.FeedbackRatingUp, "", now.Add(-3*time.Hour)),
+			},
+		},
+	}
+
+	stats := getFeedbackStats(sessions, now.Add(-time.Hour), time.Time{})
+	assert.Equal(t, &types.FeedbackStats{
+		Sessions: 3,
+		Total:    types.FeedbackCounts{Up: 2, Down: 2, Comments: 2},
+		Models: map[types.ModelName]types.FeedbackCounts{
+			types.Model_Ollama_Llama3_8b: {Up: 1, Down: 2, Comments: 1},
+			types.Model_Ollama_Mistral7b: {Up: 1, Comments: 1},
+		},
+	}, stats)
+}
+
+func TestGetSessionFeedbackStats(t *testing.T) {
+	session := &types.Session{
+		ID:        "session-1",
+		ModelName: types.Model_Ollama_Mistral7b,
+		Interactions: []*types.Interaction{
+			{Creator: types.CreatorTypeUser},
+			newFeedbackInteraction(types.FeedbackRatingUp, "", time.Now().Add(-24*time.Hour)),
+			{Creator: types.CreatorTypeUser},
+			{Creator: types.CreatorTypeSystem, Finished: true},
+		},
+	}
+
+	c := &Controller{}
+	assert.Equal(t, &types.SessionFeedbackStats{
+		SessionID:      "session-1",
+		ModelName:      types.Model_Ollama_Mistral7b,
+		FeedbackCounts: types.FeedbackCounts{Up: 1},
+	}, c.GetSessionFeedbackStats(session))
+}
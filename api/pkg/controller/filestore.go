@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"embed"
 	"encoding/json"
+	"errors"
 	"io"
 	"path/filepath"
 	"text/template"
@@ -207,3 +208,55 @@ func (c *Controller) FilestoreDelete(ctx types.OwnerContext, path string) error
 	}
 	return c.Options.Filestore.Delete(c.Ctx, filePath)
 }
+
+// the filestore can't hand out urls so runners have to go through the api
+var ErrFilestorePresignNotSupported = errors.New("filestore does not support presigned urls")
+
+func (c *Controller) presignedFilestore() (filestore.PresignedFileStore, error) {
+	presigned, ok := c.Options.Filestore.(filestore.PresignedFileStore)
+	if !ok {
+		return nil, ErrFilestorePresignNotSupported
+	}
+	return presigned, nil
+}
+
+// urls to download the file at path or every file under it if it's a folder
+func (c *Controller) FilestorePresignDownload(ctx types.OwnerContext, path string) (*types.RunnerFileTransfers, error) {
+	presigned, err := c.presignedFilestore()
+	if err != nil {
+		return nil, err
+	}
+	filePath, err := c.ensureFilestoreUserPath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	urls, err := presigned.PresignDownload(c.Ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	transfers := &types.RunnerFileTransfers{Path: filePath, Files: []*types.RunnerFileTransfer{}}
+	for name, url := range urls {
+		transfers.Files = append(transfers.Files, &types.RunnerFileTransfer{Name: name, URL: url})
+	}
+	return transfers, nil
+}
+
+// fills in where each of the files should be uploaded to in the folder at path
+func (c *Controller) FilestorePresignUpload(ctx types.OwnerContext, path string, files []*types.RunnerFileTransfer) (*types.RunnerFileTransfers, error) {
+	presigned, err := c.presignedFilestore()
+	if err != nil {
+		return nil, err
+	}
+	folderPath, err := c.ensureFilestoreUserPath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		file.Path = filepath.Join(folderPath, file.Name)
+		file.URL, file.Headers, err = presigned.PresignUpload(c.Ctx, file.Path, file.Checksum)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &types.RunnerFileTransfers{Path: folderPath, Files: files}, nil
+}
@@ -2,7 +2,6 @@ package controller
 
 import (
 	"context"
-	"errors"
 	"time"
 
 	"github.com/helixml/helix/api/pkg/store"
@@ -26,15 +25,24 @@ func (c *Controller) GetStatus(ctx types.RequestContext) (types.UserStatus, erro
 	}, nil
 }
 
-func (c *Controller) CreateAPIKey(ctx types.RequestContext, name string) (string, error) {
-	apiKey, err := c.Options.Store.CreateAPIKey(ctx.Ctx, store.OwnerQuery{
+// the owner's own limit, then the subscriber limit if they have a
+// subscription and then the server's limit
+func (c *Controller) GetMaxSessionFileSize(config types.UserConfig) int64 {
+	if config.MaxSessionFileSize > 0 {
+		return config.MaxSessionFileSize
+	}
+	if config.StripeSubscriptionActive && c.Options.MaxSessionFileSizeSubscribed > 0 {
+		return c.Options.MaxSessionFileSizeSubscribed
+	}
+	return c.Options.MaxSessionFileSize
+}
+
+// the returned key has the secret filled in, it can't be got again
+func (c *Controller) CreateAPIKey(ctx types.RequestContext, name string) (*types.ApiKey, error) {
+	return c.Options.Store.CreateAPIKey(ctx.Ctx, store.OwnerQuery{
 		Owner:     ctx.Owner,
 		OwnerType: ctx.OwnerType,
 	}, name)
-	if err != nil {
-		return "", err
-	}
-	return apiKey, nil
 }
 
 func (c *Controller) GetAPIKeys(ctx types.RequestContext) ([]*types.ApiKey, error) {
@@ -45,33 +53,20 @@ func (c *Controller) GetAPIKeys(ctx types.RequestContext) ([]*types.ApiKey, erro
 	if err != nil {
 		return nil, err
 	}
+	// we used to make a "default" key here for owners without one but its
+	// secret could never be shown now keys are hashed, so they make their own
 	if apiKeys == nil {
-		_, err := c.CreateAPIKey(ctx, "default")
-		if err != nil {
-			return nil, err
-		}
-		return c.GetAPIKeys(ctx)
+		return []*types.ApiKey{}, nil
 	}
 	return apiKeys, nil
 }
 
-func (c *Controller) DeleteAPIKey(ctx types.RequestContext, apiKey string) error {
-	fetchedApiKey, err := c.Options.Store.CheckAPIKey(ctx.Ctx, apiKey)
-	if err != nil {
-		return err
-	}
-	if fetchedApiKey == nil {
-		return errors.New("no such key")
-	}
-	// only the owner of an api key can delete it
-	if fetchedApiKey.Owner != ctx.Owner || fetchedApiKey.OwnerType != ctx.OwnerType {
-		return errors.New("unauthorized")
-	}
-	err = c.Options.Store.DeleteAPIKey(ctx.Ctx, *fetchedApiKey)
-	if err != nil {
-		return err
-	}
-	return nil
+// only the owner's own keys can be deleted, anyone else's name is not found
+func (c *Controller) DeleteAPIKey(ctx types.RequestContext, name string) error {
+	return c.Options.Store.DeleteAPIKey(ctx.Ctx, store.OwnerQuery{
+		Owner:     ctx.Owner,
+		OwnerType: ctx.OwnerType,
+	}, name)
 }
 
 func (c *Controller) CheckAPIKey(ctx context.Context, apiKey string) (*types.ApiKey, error) {
@@ -83,19 +78,36 @@ func (c *Controller) CheckAPIKey(ctx context.Context, apiKey string) (*types.Api
 }
 
 func (c *Controller) cleanOldRunnerMetrics(ctx context.Context) error {
-	deleteIDs := []string{}
+	deleted := []*types.RunnerState{}
 	c.activeRunners.Range(func(i string, metrics *types.RunnerState) bool {
 		// any runner that has not reported within the last minute
 		// should be removed
 		if time.Since(metrics.Created) > (time.Minute * 1) {
-			deleteIDs = append(deleteIDs, i)
+			deleted = append(deleted, metrics)
 		}
 		return true
 	})
 
-	// Perform the deletion logic using the deleteIDs slice
-	for _, id := range deleteIDs {
-		c.activeRunners.Delete(id)
+	// Perform the deletion logic using the deleted slice
+	for _, runner := range deleted {
+		c.activeRunners.Delete(runner.ID)
+		c.jobHistory.forget(runner.ID)
+		c.lostRunners.add(runner)
+	}
+
+	// a runner that is restarting or has a slow connection gets a while to
+	// come back before we give up on the sessions it was running
+	gone := deleted
+	if c.Options.RunnerInterruptTimeout > 0 {
+		gone = c.lostRunners.expired(c.Options.RunnerInterruptTimeout)
+		for _, runner := range gone {
+			c.interruptRunnerSessions(ctx, runner)
+		}
+	}
+
+	// what is left of the fleet might not be able to run everything waiting
+	if len(gone) > 0 {
+		c.errorUnservableQueuedSessions()
 	}
 
 	return nil
@@ -103,35 +115,46 @@ func (c *Controller) cleanOldRunnerMetrics(ctx context.Context) error {
 
 func (c *Controller) AddRunnerMetrics(ctx context.Context, metrics *types.RunnerState) (*types.RunnerState, error) {
 	c.activeRunners.Store(metrics.ID, metrics)
-	return metrics, nil
+	c.lostRunners.remove(metrics.ID)
+	c.syncJobHistory(ctx, metrics)
+
+	stopSessions := c.getStopSessions(metrics)
+	if len(stopSessions) == 0 {
+		return metrics, nil
+	}
+	// a copy so the stored state doesn't keep the list
+	response := *metrics
+	response.StopSessions = stopSessions
+	return &response, nil
 }
 
 func (c *Controller) GetDashboardData(ctx context.Context) (*types.DashboardData, error) {
-	runners := []*types.RunnerState{}
-	c.activeRunners.Range(func(i string, metrics *types.RunnerState) bool {
-		runners = append(runners, metrics)
-		return true
-	})
+	runners := c.getRunners()
+	c.sessionQueueMtx.Lock()
+	sessionQueue := c.sessionSummaryQueue
+	c.sessionQueueMtx.Unlock()
+	c.schedulingDecisionsMtx.Lock()
+	schedulingDecisions := c.schedulingDecisions
+	sessionSchedulingDecisions := make(map[string][]*types.GlobalSchedulingDecision, len(c.sessionSchedulingDecisions))
+	for sessionID, decisions := range c.sessionSchedulingDecisions {
+		sessionSchedulingDecisions[sessionID] = decisions
+	}
+	c.schedulingDecisionsMtx.Unlock()
 	return &types.DashboardData{
-		SessionQueue:              c.sessionSummaryQueue,
-		Runners:                   runners,
-		GlobalSchedulingDecisions: c.schedulingDecisions,
+		SessionQueue:               sessionQueue,
+		Runners:                    runners,
+		GlobalSchedulingDecisions:  schedulingDecisions,
+		SessionSchedulingDecisions: sessionSchedulingDecisions,
 	}, nil
 }
 
 func (c *Controller) updateSubscriptionUser(userID string, stripeCustomerID string, stripeSubscriptionID string, active bool) error {
-	existingUser, err := c.Options.Store.GetUserMeta(context.Background(), userID)
-	if err != nil || existingUser != nil {
-		existingUser = &types.UserMeta{
-			ID: userID,
-			Config: types.UserConfig{
-				StripeCustomerID:     stripeCustomerID,
-				StripeSubscriptionID: stripeSubscriptionID,
-			},
-		}
-	}
+	// keep the rest of the user config (e.g. webhooks) intact
+	existingUser := c.getUserMetaOrDefault(context.Background(), userID)
+	existingUser.Config.StripeCustomerID = stripeCustomerID
+	existingUser.Config.StripeSubscriptionID = stripeSubscriptionID
 	existingUser.Config.StripeSubscriptionActive = active
-	_, err = c.Options.Store.EnsureUserMeta(context.Background(), *existingUser)
+	_, err := c.Options.Store.EnsureUserMeta(context.Background(), *existingUser)
 	return err
 }
 
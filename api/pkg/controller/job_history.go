@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+	"sync"
+
+	"github.com/helixml/helix/api/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+// runners report their last few jobs with every state update, we remember
+// what we last wrote for each one so the store is only written when a job
+// is new or has finished
+type jobHistorySync struct {
+	mu sync.Mutex
+	// runner id -> job -> the state we wrote
+	synced map[string]map[jobHistoryKey]types.InteractionState
+}
+
+type jobHistoryKey struct {
+	modelInstanceID string
+	interactionID   string
+}
+
+// the jobs in the runner's state that the store doesn't have yet, jobs the
+// runner no longer reports are forgotten
+func (s *jobHistorySync) pending(state *types.RunnerState) []*types.ModelInstanceJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.synced == nil {
+		s.synced = map[string]map[jobHistoryKey]types.InteractionState{}
+	}
+	previous := s.synced[state.ID]
+	current := map[jobHistoryKey]types.InteractionState{}
+
+	jobs := []*types.ModelInstanceJob{}
+	for _, instance := range state.ModelInstances {
+		for _, summary := range instance.JobHistory {
+			if summary.InteractionID == "" {
+				continue
+			}
+			key := jobHistoryKey{modelInstanceID: instance.ID, interactionID: summary.InteractionID}
+			written, ok := previous[key]
+			if ok {
+				current[key] = written
+			}
+			if ok && written == summary.State {
+				continue
+			}
+			jobs = append(jobs, newModelInstanceJob(state.ID, instance.ID, summary))
+		}
+	}
+
+	s.synced[state.ID] = current
+	return jobs
+}
+
+func (s *jobHistorySync) markSynced(job *types.ModelInstanceJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runner, ok := s.synced[job.RunnerID]
+	if !ok {
+		return
+	}
+	key := jobHistoryKey{modelInstanceID: job.ModelInstanceID, interactionID: job.InteractionID}
+	runner[key] = job.State
+}
+
+// the runner is gone so there is nothing more to hear about its jobs
+func (s *jobHistorySync) forget(runnerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.synced, runnerID)
+}
+
+func newModelInstanceJob(runnerID, modelInstanceID string, summary *types.SessionSummary) *types.ModelInstanceJob {
+	started := summary.Scheduled
+	if started.IsZero() {
+		started = summary.Created
+	}
+	return &types.ModelInstanceJob{
+		RunnerID:        runnerID,
+		ModelInstanceID: modelInstanceID,
+		InteractionID:   summary.InteractionID,
+		SessionID:       summary.SessionID,
+		ModelName:       summary.ModelName,
+		Mode:            summary.Mode,
+		Started:         started,
+		Completed:       summary.Completed,
+		State:           summary.State,
+		Error:           summary.Error,
+	}
+}
+
+// write the new and newly finished jobs from the runner's state to the store,
+// anything that fails is tried again with the runner's next update
+func (c *Controller) syncJobHistory(ctx context.Context, state *types.RunnerState) {
+	for _, job := range c.jobHistory.pending(state) {
+		err := c.Options.Store.UpsertModelInstanceJob(ctx, job)
+		if err != nil {
+			log.Error().Err(err).
+				Str("runner_id", job.RunnerID).
+				Str("interaction_id", job.InteractionID).
+				Msg("failed to store model instance job")
+			continue
+		}
+		c.jobHistory.markSynced(job)
+	}
+}
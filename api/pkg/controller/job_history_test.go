@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestSyncJobHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	c := &Controller{Options: ControllerOptions{Store: mockStore}}
+
+	scheduled := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	runnerState := func(jobs ...*types.SessionSummary) *types.RunnerState {
+		return &types.RunnerState{
+			ID: "runner-1",
+			ModelInstances: []*types.ModelInstanceState{
+				{ID: "instance-1", JobHistory: jobs},
+			},
+		}
+	}
+	job := func(id string, state types.InteractionState) *types.SessionSummary {
+		return &types.SessionSummary{
+			SessionID:     "session-" + id,
+			InteractionID: "interaction-" + id,
+			ModelName:     types.Model_Ollama_Llama3_8b,
+			Mode:          types.SessionModeInference,
+			Scheduled:     scheduled,
+			State:         state,
+		}
+	}
+
+	written := []*types.ModelInstanceJob{}
+	failNext := false
+	mockStore.EXPECT().UpsertModelInstanceJob(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, job *types.ModelInstanceJob) error {
+		if failNext {
+			failNext = false
+			return errors.New("database is down")
+		}
+		written = append(written, job)
+		return nil
+	}).AnyTimes()
+
+	c.syncJobHistory(context.Background(), runnerState(job("a", types.InteractionStateWaiting)))
+	require.Len(t, written, 1)
+	assert.Equal(t, &types.ModelInstanceJob{
+		RunnerID:        "runner-1",
+		ModelInstanceID: "instance-1",
+		InteractionID:   "interaction-a",
+		SessionID:       "session-a",
+		ModelName:       types.Model_Ollama_Llama3_8b,
+		Mode:            types.SessionModeInference,
+		Started:         scheduled,
+		State:           types.InteractionStateWaiting,
+	}, written[0])
+
+	// nothing has changed so nothing is written
+	c.syncJobHistory(context.Background(), runnerState(job("a", types.InteractionStateWaiting)))
+	assert.Len(t, written, 1)
+
+	// a failed write is tried again with the next report
+	failNext = true
+	c.syncJobHistory(context.Background(), runnerState(job("b", types.InteractionStateWaiting), job("a", types.InteractionStateComplete)))
+	require.Len(t, written, 2)
+	assert.Equal(t, "interaction-a", written[1].InteractionID)
+	assert.Equal(t, types.InteractionStateComplete, written[1].State)
+
+	c.syncJobHistory(context.Background(), runnerState(job("b", types.InteractionStateWaiting), job("a", types.InteractionStateComplete)))
+	require.Len(t, written, 3)
+	assert.Equal(t, "interaction-b", written[2].InteractionID)
+
+	// a job that has dropped out of the runner's history is forgotten
+	c.syncJobHistory(context.Background(), runnerState(job("b", types.InteractionStateWaiting)))
+	assert.Len(t, written, 3)
+	c.jobHistory.mu.Lock()
+	assert.Len(t, c.jobHistory.synced["runner-1"], 1)
+	c.jobHistory.mu.Unlock()
+
+	c.jobHistory.forget("runner-1")
+	c.syncJobHistory(context.Background(), runnerState(job("b", types.InteractionStateWaiting)))
+	assert.Len(t, written, 4)
+}
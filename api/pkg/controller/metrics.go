@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/helixml/helix/api/pkg/data"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+type sessionOutcomeKey struct {
+	ModelName types.ModelName
+	Mode      types.SessionMode
+	State     types.InteractionState
+}
+
+// counts how many interactions have finished (either complete or error)
+// for each model and mode since the api server started
+type sessionOutcomeCounter struct {
+	mtx    sync.Mutex
+	counts map[sessionOutcomeKey]uint64
+}
+
+func (c *sessionOutcomeCounter) inc(key sessionOutcomeKey) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.counts == nil {
+		c.counts = map[sessionOutcomeKey]uint64{}
+	}
+	c.counts[key]++
+}
+
+func (c *sessionOutcomeCounter) list() []*types.SessionOutcomeCount {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	result := []*types.SessionOutcomeCount{}
+	for key, count := range c.counts {
+		result = append(result, &types.SessionOutcomeCount{
+			ModelName: key.ModelName,
+			Mode:      key.Mode,
+			State:     key.State,
+			Count:     count,
+		})
+	}
+
+	// keep the output stable between scrapes
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ModelName != result[j].ModelName {
+			return result[i].ModelName < result[j].ModelName
+		}
+		if result[i].Mode != result[j].Mode {
+			return result[i].Mode < result[j].Mode
+		}
+		return result[i].State < result[j].State
+	})
+
+	return result
+}
+
+// the upper bounds (in seconds) of the stage duration buckets - first token
+// latency is in the low seconds and a model load or generation can take
+// minutes
+var stageDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+type stageDurationKey struct {
+	ModelName types.ModelName
+	Mode      types.SessionMode
+	Stage     types.InteractionStage
+}
+
+type stageDurationHistogram struct {
+	// the count for each of stageDurationBuckets (not cumulative)
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// histograms of how long completed interactions spent in each stage for
+// each model and mode since the api server started
+type stageDurationRecorder struct {
+	mtx        sync.Mutex
+	histograms map[stageDurationKey]*stageDurationHistogram
+}
+
+func (r *stageDurationRecorder) observe(key stageDurationKey, duration time.Duration) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.histograms == nil {
+		r.histograms = map[stageDurationKey]*stageDurationHistogram{}
+	}
+	histogram, ok := r.histograms[key]
+	if !ok {
+		histogram = &stageDurationHistogram{buckets: make([]uint64, len(stageDurationBuckets))}
+		r.histograms[key] = histogram
+	}
+
+	seconds := duration.Seconds()
+	for i, upperBound := range stageDurationBuckets {
+		if seconds <= upperBound {
+			histogram.buckets[i]++
+			break
+		}
+	}
+	histogram.sum += seconds
+	histogram.count++
+}
+
+func (r *stageDurationRecorder) list() []*types.StageDurationCount {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	result := []*types.StageDurationCount{}
+	for key, histogram := range r.histograms {
+		buckets := make([]types.HistogramBucket, len(stageDurationBuckets))
+		cumulative := uint64(0)
+		for i, upperBound := range stageDurationBuckets {
+			cumulative += histogram.buckets[i]
+			buckets[i] = types.HistogramBucket{UpperBound: upperBound, Count: cumulative}
+		}
+		result = append(result, &types.StageDurationCount{
+			ModelName: key.ModelName,
+			Mode:      key.Mode,
+			Stage:     key.Stage,
+			Buckets:   buckets,
+			Sum:       histogram.sum,
+			Count:     histogram.count,
+		})
+	}
+
+	// keep the output stable between scrapes
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ModelName != result[j].ModelName {
+			return result[i].ModelName < result[j].ModelName
+		}
+		if result[i].Mode != result[j].Mode {
+			return result[i].Mode < result[j].Mode
+		}
+		return result[i].Stage < result[j].Stage
+	})
+
+	return result
+}
+
+func (c *Controller) recordStageDurations(session *types.Session) {
+	systemInteraction, err := data.GetSystemInteraction(session)
+	if err != nil {
+		return
+	}
+	for stage, duration := range systemInteraction.StageDurations() {
+		c.stageDurations.observe(stageDurationKey{
+			ModelName: session.ModelName,
+			Mode:      session.Mode,
+			Stage:     stage,
+		}, duration)
+	}
+}
+
+func (c *Controller) recordSessionOutcome(session *types.Session, state types.InteractionState) {
+	c.sessionOutcomes.inc(sessionOutcomeKey{
+		ModelName: session.ModelName,
+		Mode:      session.Mode,
+		State:     state,
+	})
+}
+
+// a point in time snapshot of the numbers we export to prometheus
+func (c *Controller) GetMetrics() *types.ControllerMetrics {
+	runners := []*types.RunnerState{}
+	c.activeRunners.Range(func(i string, metrics *types.RunnerState) bool {
+		runners = append(runners, metrics)
+		return true
+	})
+	sort.Slice(runners, func(i, j int) bool {
+		return runners[i].ID < runners[j].ID
+	})
+
+	c.sessionQueueMtx.Lock()
+	queueDepth := len(c.sessionQueue)
+	c.sessionQueueMtx.Unlock()
+
+	return &types.ControllerMetrics{
+		Runners:         runners,
+		QueueDepth:      queueDepth,
+		SessionOutcomes: c.sessionOutcomes.list(),
+		StageDurations:  c.stageDurations.list(),
+	}
+}
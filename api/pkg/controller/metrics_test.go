@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestStageDurationRecorder(t *testing.T) {
+	recorder := stageDurationRecorder{}
+
+	key := stageDurationKey{
+		ModelName: types.Model_Axolotl_Mistral7b,
+		Mode:      types.SessionModeInference,
+		Stage:     types.InteractionStageQueue,
+	}
+	recorder.observe(key, 200*time.Millisecond)
+	recorder.observe(key, 3*time.Second)
+	// longer than the biggest bucket only shows up in the count
+	recorder.observe(key, time.Hour)
+
+	stages := recorder.list()
+	require.Len(t, stages, 1)
+
+	stage := stages[0]
+	assert.Equal(t, types.InteractionStageQueue, stage.Stage)
+	assert.Equal(t, uint64(3), stage.Count)
+	assert.InDelta(t, 3603.2, stage.Sum, 0.001)
+
+	counts := map[float64]uint64{}
+	for _, bucket := range stage.Buckets {
+		counts[bucket.UpperBound] = bucket.Count
+	}
+	assert.Equal(t, uint64(0), counts[0.1])
+	assert.Equal(t, uint64(1), counts[0.25])
+	assert.Equal(t, uint64(1), counts[2.5])
+	assert.Equal(t, uint64(2), counts[5])
+	assert.Equal(t, uint64(2), counts[600])
+}
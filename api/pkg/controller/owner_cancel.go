@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+// how long we keep telling runners to stop a cancelled session, by then it
+// has either stopped or the runner has moved on
+const stoppedSessionTTL = 10 * time.Minute
+
+// cancelled sessions that a runner had already picked up - they are handed
+// back to the runners when they report their state so the one running it
+// can stop generating rather than carry on for nobody
+type stoppedSessionTracker struct {
+	mtx      sync.Mutex
+	sessions map[string]time.Time
+}
+
+func (t *stoppedSessionTracker) add(sessionID string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.sessions == nil {
+		t.sessions = map[string]time.Time{}
+	}
+	t.sessions[sessionID] = time.Now()
+}
+
+// the ones of the given sessions that should be stopped
+func (t *stoppedSessionTracker) filter(sessionIDs []string) []string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for sessionID, stopped := range t.sessions {
+		if time.Since(stopped) > stoppedSessionTTL {
+			delete(t.sessions, sessionID)
+		}
+	}
+
+	stop := []string{}
+	for _, sessionID := range sessionIDs {
+		if _, ok := t.sessions[sessionID]; ok {
+			stop = append(stop, sessionID)
+		}
+	}
+	return stop
+}
+
+// the cancelled sessions the runner says it's working on
+func (c *Controller) getStopSessions(state *types.RunnerState) []string {
+	sessionIDs := []string{}
+	for _, modelInstance := range state.ModelInstances {
+		if modelInstance.CurrentSession != nil {
+			sessionIDs = append(sessionIDs, modelInstance.CurrentSession.SessionID)
+		}
+		for _, session := range modelInstance.ActiveSessions {
+			sessionIDs = append(sessionIDs, session.SessionID)
+		}
+	}
+	return c.stoppedSessions.filter(sessionIDs)
+}
+
+// every session of the owner that might be queued or running - we can't
+// query the store for these as a session that is running isn't marked as
+// such, so this is what the queue, the quotas, the data prep runs and the
+// runners know about
+func (c *Controller) getOwnerSessionIDs(ownerType types.OwnerType, owner string) []string {
+	sessionIDs := map[string]bool{}
+
+	c.sessionQueueMtx.Lock()
+	for _, session := range c.sessionQueue {
+		if session.Owner == owner && session.OwnerType == ownerType {
+			sessionIDs[session.ID] = true
+		}
+	}
+	c.sessionQueueMtx.Unlock()
+
+	c.sessionQuotas.mtx.Lock()
+	for sessionID, sessionOwner := range c.sessionQuotas.sessions {
+		if sessionOwner.Owner == owner && sessionOwner.OwnerType == ownerType {
+			sessionIDs[sessionID] = true
+		}
+	}
+	c.sessionQuotas.mtx.Unlock()
+
+	c.dataPrepRunsMtx.Lock()
+	for sessionID, run := range c.dataPrepRuns {
+		if run.owner.Owner == owner && run.owner.OwnerType == ownerType {
+			sessionIDs[sessionID] = true
+		}
+	}
+	c.dataPrepRunsMtx.Unlock()
+
+	// the owner type isn't in the summaries, it's checked once we load them
+	for _, runner := range c.getRunners() {
+		for _, modelInstance := range runner.ModelInstances {
+			summaries := append([]*types.SessionSummary{modelInstance.CurrentSession}, modelInstance.ActiveSessions...)
+			for _, summary := range summaries {
+				if summary != nil && summary.Owner == owner {
+					sessionIDs[summary.SessionID] = true
+				}
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(sessionIDs))
+	for sessionID := range sessionIDs {
+		ids = append(ids, sessionID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// CancelOwnerSessions cancels everything the owner has queued or running,
+// for stopping an owner that is flooding us in an incident - the runners
+// are told to stop the sessions they have already picked up
+func (c *Controller) CancelOwnerSessions(ctx context.Context, ownerType types.OwnerType, owner string) (*types.OwnerCancelResult, error) {
+	result := &types.OwnerCancelResult{
+		OwnerType: ownerType,
+		Owner:     owner,
+	}
+
+	for _, sessionID := range c.getOwnerSessionIDs(ownerType, owner) {
+		session, err := c.Options.Store.GetSession(ctx, sessionID)
+		if errors.Is(err, store.ErrNotFound) || (err == nil && session == nil) {
+			// deleted while it was running
+			c.removeSessionFromQueue(sessionID)
+			c.releaseSessionQuota(sessionID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if session.Owner != owner || session.OwnerType != ownerType {
+			continue
+		}
+
+		systemInteraction, err := data.GetSystemInteraction(session)
+		if err != nil {
+			return nil, err
+		}
+		// editing questions is waiting on the user, not using any compute
+		if systemInteraction.Finished || systemInteraction.State == types.InteractionStateEditing {
+			continue
+		}
+
+		if isPreparingData(session, systemInteraction) {
+			_, err = c.CancelDataPrep(ctx, session, false)
+			if errors.Is(err, ErrNoDataPrep) {
+				// it finished before we stopped it
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			c.releaseSessionQuota(session.ID)
+			result.PreparingData++
+			continue
+		}
+
+		cancelled, queued, err := c.cancelSession(ctx, session.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !cancelled {
+			continue
+		}
+		if queued {
+			result.Queued++
+		} else {
+			result.Running++
+		}
+	}
+
+	log.Info().
+		Str("owner", owner).
+		Str("owner_type", string(ownerType)).
+		Int("queued", result.Queued).
+		Int("preparing_data", result.PreparingData).
+		Int("running", result.Running).
+		Msg("owner sessions cancelled")
+
+	return result, nil
+}
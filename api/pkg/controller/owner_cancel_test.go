@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/filestore"
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newOwnerSession(id, owner string, finished bool) *types.Session {
+	return &types.Session{
+		ID:        id,
+		Owner:     owner,
+		OwnerType: types.OwnerTypeUser,
+		Mode:      types.SessionModeInference,
+		Type:      types.SessionTypeText,
+		Interactions: []*types.Interaction{
+			{ID: id + "-user", Creator: types.CreatorTypeUser, Finished: true},
+			{ID: id + "-system", Creator: types.CreatorTypeSystem, State: types.InteractionStateWaiting, Finished: finished},
+		},
+	}
+}
+
+func TestCancelOwnerSessions(t *testing.T) {
+	queued := newOwnerSession("session-queued", "alice", false)
+	running := newOwnerSession("session-running", "alice", false)
+	finished := newOwnerSession("session-finished", "alice", true)
+	other := newOwnerSession("session-other", "bob", false)
+	preparing := newDataPrepSession(types.TextDataPrepStageGenerateQuestions)
+	preparing.Owner = "alice"
+	preparing.OwnerType = types.OwnerTypeUser
+
+	sessions := map[string]*types.Session{}
+	for _, session := range []*types.Session{queued, running, finished, other, preparing} {
+		sessions[session.ID] = session
+	}
+
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	mockStore.EXPECT().GetSession(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, id string) (*types.Session, error) {
+		return sessions[id], nil
+	}).AnyTimes()
+	mockStore.EXPECT().UpdateSession(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, session types.Session) (*types.Session, error) {
+		sessions[session.ID] = &session
+		return &session, nil
+	}).AnyTimes()
+
+	c := &Controller{
+		Ctx:                          context.Background(),
+		Options:                      ControllerOptions{Store: mockStore, Filestore: filestore.NewFileSystemStorage(t.TempDir(), "", "")},
+		UserWebsocketEventChanWriter: make(chan *types.WebsocketEvent, 100),
+		activeRunners:                xsync.NewMapOf[string, *types.RunnerState](),
+	}
+	for _, session := range []*types.Session{queued, other} {
+		c.sessionQueue = append(c.sessionQueue, session)
+		c.sessionSummaryQueue = append(c.sessionSummaryQueue, &types.SessionSummary{SessionID: session.ID, Owner: session.Owner})
+	}
+	for _, session := range []*types.Session{queued, running, other, preparing} {
+		_, ok := c.sessionQuotas.acquire(session.ID, sessionQuotaOwner{OwnerType: session.OwnerType, Owner: session.Owner}, 0)
+		require.True(t, ok)
+	}
+	runnerState := &types.RunnerState{
+		ID: "runner-1",
+		ModelInstances: []*types.ModelInstanceState{
+			{
+				ID:             "instance-1",
+				CurrentSession: &types.SessionSummary{SessionID: running.ID, Owner: "alice"},
+				ActiveSessions: []*types.SessionSummary{{SessionID: finished.ID, Owner: "alice"}},
+			},
+		},
+	}
+	c.activeRunners.Store(runnerState.ID, runnerState)
+
+	result, err := c.CancelOwnerSessions(context.Background(), types.OwnerTypeUser, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, &types.OwnerCancelResult{
+		OwnerType:     types.OwnerTypeUser,
+		Owner:         "alice",
+		Queued:        1,
+		PreparingData: 1,
+		Running:       1,
+	}, result)
+
+	for _, id := range []string{queued.ID, running.ID} {
+		assert.Equal(t, ErrSessionCancelled.Error(), sessions[id].Interactions[1].Error, id)
+	}
+	assert.Equal(t, ErrDataPrepCancelled.Error(), sessions[preparing.ID].Interactions[1].Error)
+	assert.False(t, sessions[other.ID].Interactions[1].Finished)
+	assert.Empty(t, sessions[finished.ID].Interactions[1].Error)
+
+	// bob is still queued and counted, alice has nothing left
+	require.Len(t, c.sessionQueue, 1)
+	assert.Equal(t, other.ID, c.sessionQueue[0].ID)
+	assert.Equal(t, 0, c.sessionQuotas.count(sessionQuotaOwner{OwnerType: types.OwnerTypeUser, Owner: "alice"}))
+	assert.Equal(t, 1, c.sessionQuotas.count(sessionQuotaOwner{OwnerType: types.OwnerTypeUser, Owner: "bob"}))
+
+	// the runner is told to stop the one it's running when it next reports
+	response, err := c.AddRunnerMetrics(context.Background(), runnerState)
+	require.NoError(t, err)
+	assert.Equal(t, []string{running.ID}, response.StopSessions)
+	stored, _ := c.activeRunners.Load(runnerState.ID)
+	assert.Empty(t, stored.StopSessions)
+
+	// and nothing is left to cancel
+	result, err = c.CancelOwnerSessions(context.Background(), types.OwnerTypeUser, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Queued+result.PreparingData+result.Running)
+}
+
+func TestGetOwnerSessionIDs_DataPrepRuns(t *testing.T) {
+	alice := newOwnerSession("session-alice", "alice", false)
+	bob := newOwnerSession("session-bob", "bob", false)
+
+	// other owners' runs are left out without going to the store
+	c := &Controller{Ctx: context.Background(), activeRunners: xsync.NewMapOf[string, *types.RunnerState]()}
+	for _, session := range []*types.Session{alice, bob} {
+		_, finish := c.startDataPrepRun(session)
+		defer finish()
+	}
+
+	assert.Equal(t, []string{alice.ID}, c.getOwnerSessionIDs(types.OwnerTypeUser, "alice"))
+}
+
+func TestStoppedSessionTracker_Expires(t *testing.T) {
+	tracker := stoppedSessionTracker{}
+	tracker.add("session-1")
+	tracker.add("session-2")
+	assert.Equal(t, []string{"session-2"}, tracker.filter([]string{"session-2", "session-3"}))
+
+	tracker.sessions["session-2"] = tracker.sessions["session-2"].Add(-stoppedSessionTTL - 1)
+	assert.Equal(t, []string{"session-1"}, tracker.filter([]string{"session-1", "session-2"}))
+	assert.Len(t, tracker.sessions, 1)
+}
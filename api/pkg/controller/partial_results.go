@@ -0,0 +1,320 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+// what a runner has streamed so far for the interaction it is running
+type partialResult struct {
+	interactionID string
+	attempt       string
+	message       strings.Builder
+	progress      int
+	// changed since it was last written to the store
+	dirty bool
+}
+
+// streamed text and progress only go from the runner websocket to the
+// browser, we keep what each session has been sent so it can be saved every
+// so often and a runner dying part way through doesn't lose it all
+type partialResults struct {
+	mu       sync.Mutex
+	sessions map[string]*partialResult
+}
+
+type partialResultUpdate struct {
+	sessionID     string
+	interactionID string
+	attempt       string
+	message       string
+	progress      int
+}
+
+func (p *partialResults) add(res *types.RunnerTaskResponse) {
+	if res.Type != types.WorkerTaskResponseTypeStream && res.Type != types.WorkerTaskResponseTypeProgress {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.sessions == nil {
+		p.sessions = map[string]*partialResult{}
+	}
+	partial, ok := p.sessions[res.SessionID]
+	// a new attempt (or interaction) starts again from nothing
+	if !ok || partial.attempt != res.Attempt ||
+		(res.InteractionID != "" && partial.interactionID != "" && partial.interactionID != res.InteractionID) {
+		partial = &partialResult{attempt: res.Attempt}
+		p.sessions[res.SessionID] = partial
+	}
+	if res.InteractionID != "" {
+		partial.interactionID = res.InteractionID
+	}
+
+	if res.Type == types.WorkerTaskResponseTypeStream && res.Message != "" {
+		partial.message.WriteString(res.Message)
+		partial.dirty = true
+	}
+	if res.Progress != 0 && res.Progress != partial.progress {
+		partial.progress = res.Progress
+		partial.dirty = true
+	}
+}
+
+// the sessions that have changed since they were last written, they aren't
+// returned again until something new comes in
+func (p *partialResults) pending() []*partialResultUpdate {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	updates := []*partialResultUpdate{}
+	for sessionID, partial := range p.sessions {
+		if !partial.dirty {
+			continue
+		}
+		partial.dirty = false
+		updates = append(updates, partial.update(sessionID))
+	}
+	return updates
+}
+
+func (p *partialResults) get(sessionID string) (*partialResultUpdate, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	partial, ok := p.sessions[sessionID]
+	if !ok {
+		return nil, false
+	}
+	return partial.update(sessionID), true
+}
+
+// the session's result is in (or it has been given up on)
+func (p *partialResults) forget(sessionID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.sessions, sessionID)
+}
+
+func (r *partialResult) update(sessionID string) *partialResultUpdate {
+	return &partialResultUpdate{
+		sessionID:     sessionID,
+		interactionID: r.interactionID,
+		attempt:       r.attempt,
+		message:       r.message.String(),
+		progress:      r.progress,
+	}
+}
+
+// whether the partial output belongs to the interaction the session is
+// waiting on - not one that has finished or an attempt we've since retried
+func (u *partialResultUpdate) appliesTo(interaction *types.Interaction) bool {
+	if interaction.Finished {
+		return false
+	}
+	if u.interactionID != "" && u.interactionID != interaction.ID {
+		return false
+	}
+	for _, attempt := range interaction.RetriedAttempts {
+		if attempt == u.attempt {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *partialResultUpdate) apply(interaction *types.Interaction) {
+	if u.message != "" {
+		interaction.Message = u.message
+	}
+	if u.progress != 0 {
+		interaction.Progress = u.progress
+	}
+}
+
+// write the streamed output of running sessions to the store every interval
+// until the context is done
+func (c *Controller) persistPartialResultsLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.persistPartialResults(ctx)
+		}
+	}
+}
+
+func (c *Controller) persistPartialResults(ctx context.Context) {
+	for _, update := range c.partialResults.pending() {
+		err := c.persistPartialResult(ctx, update)
+		if err != nil {
+			log.Error().Err(err).
+				Str("session_id", update.sessionID).
+				Msg("failed to save partial result")
+		}
+	}
+}
+
+// this isn't broadcast, the browser already has the text from the stream
+func (c *Controller) persistPartialResult(ctx context.Context, update *partialResultUpdate) error {
+	unlock := c.sessionLocks.lock(update.sessionID)
+	defer unlock()
+
+	session, err := c.Options.Store.GetSession(ctx, update.sessionID)
+	if err != nil {
+		return err
+	}
+
+	systemInteraction, err := data.GetSystemInteraction(session)
+	if err != nil {
+		return err
+	}
+	if !update.appliesTo(systemInteraction) {
+		return nil
+	}
+
+	session, err = data.UpdateSystemInteraction(session, func(interaction *types.Interaction) (*types.Interaction, error) {
+		update.apply(interaction)
+		return interaction, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Options.Store.UpdateSession(ctx, *session)
+	return err
+}
+
+// the runner has stopped reporting, the sessions it was part way through
+// are interrupted with what they had streamed so they aren't left waiting
+// forever - they can be run again with RestartSession
+func (c *Controller) interruptRunnerSessions(ctx context.Context, runner *types.RunnerState) {
+	sessionIDs := map[string]bool{}
+	for _, instance := range runner.ModelInstances {
+		for _, summary := range instance.ActiveSessions {
+			sessionIDs[summary.SessionID] = true
+		}
+		if instance.CurrentSession != nil {
+			sessionIDs[instance.CurrentSession.SessionID] = true
+		}
+	}
+
+	for sessionID := range sessionIDs {
+		err := c.interruptSession(ctx, sessionID, runner.ID)
+		if err != nil {
+			log.Error().Err(err).
+				Str("session_id", sessionID).
+				Str("runner_id", runner.ID).
+				Msg("failed to interrupt session")
+		}
+	}
+}
+
+// the last state of each runner that stopped reporting
+type lostRunners struct {
+	mu      sync.Mutex
+	runners map[string]*types.RunnerState
+}
+
+func (l *lostRunners) add(runner *types.RunnerState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.runners == nil {
+		l.runners = map[string]*types.RunnerState{}
+	}
+	l.runners[runner.ID] = runner
+}
+
+func (l *lostRunners) remove(runnerID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.runners, runnerID)
+}
+
+// the runners that last reported more than timeout ago, they are forgotten
+// so they are only returned once
+func (l *lostRunners) expired(timeout time.Duration) []*types.RunnerState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	expired := []*types.RunnerState{}
+	for runnerID, runner := range l.runners {
+		if time.Since(runner.Created) > timeout {
+			expired = append(expired, runner)
+			delete(l.runners, runnerID)
+		}
+	}
+	return expired
+}
+
+// sessions that hadn't streamed anything yet are left for the runner to
+// give back if it restarts
+func (c *Controller) interruptSession(ctx context.Context, sessionID, runnerID string) error {
+	update, ok := c.partialResults.get(sessionID)
+	if !ok || update.message == "" {
+		return nil
+	}
+
+	unlock := c.sessionLocks.lock(sessionID)
+	defer unlock()
+
+	session, err := c.Options.Store.GetSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	systemInteraction, err := data.GetSystemInteraction(session)
+	if err != nil {
+		return err
+	}
+	if !update.appliesTo(systemInteraction) {
+		return nil
+	}
+
+	reason := fmt.Sprintf("runner %s stopped responding part way through the answer", runnerID)
+	session, err = data.UpdateSystemInteraction(session, func(interaction *types.Interaction) (*types.Interaction, error) {
+		update.apply(interaction)
+		interaction.Finished = true
+		interaction.Completed = time.Now()
+		interaction.State = types.InteractionStateInterrupted
+		interaction.Error = reason
+		return interaction, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.WriteSession(session)
+	if session.Mode == types.SessionModeInference {
+		systemInteraction, err := data.GetSystemInteraction(session)
+		if err == nil {
+			c.BroadcastStreamDone(session, systemInteraction)
+		}
+	}
+
+	c.partialResults.forget(sessionID)
+	c.streamOrder.forget(sessionID)
+	c.releaseSessionQuota(sessionID)
+	c.recordSessionOutcome(session, types.InteractionStateInterrupted)
+	c.notifySessionWebhook(session, types.InteractionStateInterrupted, reason)
+
+	log.Warn().
+		Str("session_id", sessionID).
+		Str("runner_id", runnerID).
+		Msg("interrupted session after partial output")
+
+	return nil
+}
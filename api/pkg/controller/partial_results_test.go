@@ -0,0 +1,235 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func streamChunk(attempt, message string) *types.RunnerTaskResponse {
+	return &types.RunnerTaskResponse{
+		Type:      types.WorkerTaskResponseTypeStream,
+		SessionID: "session-1",
+		Attempt:   attempt,
+		Message:   message,
+	}
+}
+
+func TestPartialResults(t *testing.T) {
+	partials := &partialResults{}
+
+	partials.add(streamChunk("attempt-1", "The sea "))
+	partials.add(streamChunk("attempt-1", "is deep"))
+	// results aren't partial
+	partials.add(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: "session-1", Message: "The sea is deep."})
+
+	updates := partials.pending()
+	require.Len(t, updates, 1)
+	assert.Equal(t, "The sea is deep", updates[0].message)
+
+	// nothing new has come in
+	assert.Empty(t, partials.pending())
+
+	// a retried attempt starts from scratch
+	partials.add(streamChunk("attempt-2", "Oceans"))
+	updates = partials.pending()
+	require.Len(t, updates, 1)
+	assert.Equal(t, "Oceans", updates[0].message)
+	assert.Equal(t, "attempt-2", updates[0].attempt)
+
+	partials.forget("session-1")
+	_, ok := partials.get("session-1")
+	assert.False(t, ok)
+}
+
+func newPartialResultSession(systemInteraction *types.Interaction) *types.Session {
+	return &types.Session{
+		ID:        "session-1",
+		Owner:     "user-1",
+		Mode:      types.SessionModeInference,
+		ModelName: types.Model_Ollama_Llama3_8b,
+		Interactions: []*types.Interaction{
+			{ID: "user-interaction", Creator: types.CreatorTypeUser, Message: "tell me about the sea", Finished: true},
+			systemInteraction,
+		},
+	}
+}
+
+func TestPersistPartialResults(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	c := &Controller{Options: ControllerOptions{Store: mockStore}}
+
+	systemInteraction := &types.Interaction{ID: "system-interaction", Creator: types.CreatorTypeSystem, State: types.InteractionStateWaiting}
+	mockStore.EXPECT().GetSession(gomock.Any(), "session-1").Return(newPartialResultSession(systemInteraction), nil)
+	mockStore.EXPECT().UpdateSession(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, session types.Session) (*types.Session, error) {
+		interaction := session.Interactions[1]
+		assert.Equal(t, "The sea is deep", interaction.Message)
+		assert.False(t, interaction.Finished)
+		return &session, nil
+	})
+
+	c.partialResults.add(streamChunk("attempt-1", "The sea is deep"))
+	c.persistPartialResults(context.Background())
+
+	// the result beat us to it so there's nothing to save
+	finished := &types.Interaction{ID: "system-interaction", Creator: types.CreatorTypeSystem, State: types.InteractionStateComplete, Finished: true, Message: "The sea is deep."}
+	mockStore.EXPECT().GetSession(gomock.Any(), "session-1").Return(newPartialResultSession(finished), nil)
+
+	c.partialResults.add(streamChunk("attempt-1", "."))
+	c.persistPartialResults(context.Background())
+}
+
+func TestInterruptRunnerSessions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	c := &Controller{
+		Options:                      ControllerOptions{Store: mockStore},
+		UserWebsocketEventChanWriter: make(chan *types.WebsocketEvent, 10),
+	}
+
+	runner := &types.RunnerState{
+		ID: "runner-1",
+		ModelInstances: []*types.ModelInstanceState{{
+			ActiveSessions: []*types.SessionSummary{{SessionID: "session-1"}, {SessionID: "session-2"}},
+		}},
+	}
+
+	// session-2 hadn't streamed anything so it is left alone
+	c.partialResults.add(streamChunk("attempt-1", "The sea is"))
+
+	systemInteraction := &types.Interaction{ID: "system-interaction", Creator: types.CreatorTypeSystem, State: types.InteractionStateWaiting}
+	mockStore.EXPECT().GetSession(gomock.Any(), "session-1").Return(newPartialResultSession(systemInteraction), nil)
+
+	var written *types.Interaction
+	mockStore.EXPECT().UpdateSession(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, session types.Session) (*types.Session, error) {
+		written = session.Interactions[1]
+		return &session, nil
+	})
+
+	// the webhook is looked up in the background
+	webhookChecked := make(chan struct{})
+	mockStore.EXPECT().GetUserMeta(gomock.Any(), "user-1").DoAndReturn(func(ctx context.Context, id string) (*types.UserMeta, error) {
+		close(webhookChecked)
+		return &types.UserMeta{ID: id}, nil
+	})
+
+	c.interruptRunnerSessions(context.Background(), runner)
+
+	require.NotNil(t, written)
+	assert.Equal(t, types.InteractionStateInterrupted, written.State)
+	assert.True(t, written.Finished)
+	assert.Equal(t, "The sea is", written.Message)
+	assert.Contains(t, written.Error, "runner-1")
+
+	_, ok := c.partialResults.get("session-1")
+	assert.False(t, ok)
+
+	select {
+	case <-webhookChecked:
+	case <-time.After(time.Second):
+		t.Fatal("webhook was not checked")
+	}
+}
+
+func TestCleanOldRunnerMetrics_WaitsBeforeInterrupting(t *testing.T) {
+	c := &Controller{
+		Options:       ControllerOptions{RunnerInterruptTimeout: 10 * time.Minute},
+		activeRunners: xsync.NewMapOf[string, *types.RunnerState](),
+	}
+	c.partialResults.add(streamChunk("attempt-1", "The sea is"))
+
+	silentRunner := func(silentFor time.Duration) *types.RunnerState {
+		return &types.RunnerState{
+			ID:      "runner-1",
+			Created: time.Now().Add(-silentFor),
+			ModelInstances: []*types.ModelInstanceState{{
+				ActiveSessions: []*types.SessionSummary{{SessionID: "session-1"}},
+			}},
+		}
+	}
+
+	// the runner is no longer scheduled on but its session is left alone
+	c.activeRunners.Store("runner-1", silentRunner(2*time.Minute))
+	require.NoError(t, c.cleanOldRunnerMetrics(context.Background()))
+	_, ok := c.activeRunners.Load("runner-1")
+	assert.False(t, ok)
+	_, ok = c.partialResults.get("session-1")
+	assert.True(t, ok)
+
+	// it came back so it won't be interrupted later on
+	_, err := c.AddRunnerMetrics(context.Background(), &types.RunnerState{ID: "runner-1", Created: time.Now()})
+	require.NoError(t, err)
+	assert.Empty(t, c.lostRunners.expired(0))
+
+	// gone for longer than the timeout, session-1 is interrupted which
+	// needs the store so the mock would fail the test - the runner is
+	// only handed back once
+	c.lostRunners.add(silentRunner(time.Hour))
+	runners := c.lostRunners.expired(c.Options.RunnerInterruptTimeout)
+	require.Len(t, runners, 1)
+	assert.Equal(t, "runner-1", runners[0].ID)
+	assert.Empty(t, c.lostRunners.expired(c.Options.RunnerInterruptTimeout))
+}
+
+func TestCancelSession_ForgetsPartialResults(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	c := &Controller{
+		Options:                      ControllerOptions{Store: mockStore},
+		UserWebsocketEventChanWriter: make(chan *types.WebsocketEvent, 10),
+		activeRunners:                xsync.NewMapOf[string, *types.RunnerState](),
+	}
+	c.partialResults.add(streamChunk("attempt-1", "The sea is"))
+	c.streamOrder.accept(streamChunk("attempt-1", "The sea is"))
+
+	systemInteraction := &types.Interaction{ID: "system-interaction", Creator: types.CreatorTypeSystem, State: types.InteractionStateWaiting}
+	mockStore.EXPECT().GetSession(gomock.Any(), "session-1").Return(newPartialResultSession(systemInteraction), nil)
+	mockStore.EXPECT().UpdateSession(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, session types.Session) (*types.Session, error) {
+		return &session, nil
+	})
+
+	require.NoError(t, c.CancelSession(context.Background(), "session-1"))
+
+	_, ok := c.partialResults.get("session-1")
+	assert.False(t, ok)
+	assert.Empty(t, c.partialResults.pending())
+}
+
+func TestSessionLocks(t *testing.T) {
+	locks := &sessionLocks{}
+
+	unlock := locks.lock("session-1")
+	locked := make(chan struct{})
+	go func() {
+		unlockAgain := locks.lock("session-1")
+		close(locked)
+		unlockAgain()
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("session was locked twice")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// other sessions aren't held up
+	locks.lock("session-2")()
+
+	unlock()
+	<-locked
+
+	assert.Eventually(t, func() bool {
+		locks.mu.Lock()
+		defer locks.mu.Unlock()
+		return len(locks.locks) == 0
+	}, time.Second, time.Millisecond)
+}
@@ -5,6 +5,7 @@ package controller
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/helixml/helix/api/pkg/data"
@@ -13,78 +14,157 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// how many scheduling decisions we keep for each queued session
+const sessionSchedulingDecisionBufferSize = 10
+
+// a scheduling decision we still have to record - we only note what we saw
+// while the queue is locked and build the decision once it's been released
+type pendingSchedulingDecision struct {
+	session       *types.Session
+	queuePosition int
+	reason        types.SchedulingDecisionReason
+	message       string
+	created       time.Time
+}
+
 // this function expects the sessionQueueMtx to be locked when it is run
-func (c *Controller) getMatchingSessionFilterIndex(ctx context.Context, filter types.SessionFilter) int {
+// every session we look at before finding a match gets a pending scheduling
+// decision saying why this runner didn't pick it up
+func (c *Controller) getMatchingSessionFilterIndex(ctx context.Context, filter types.SessionFilter, runnerID string) (int, []pendingSchedulingDecision) {
+	skipped := []pendingSchedulingDecision{}
 	for i, session := range c.sessionQueue {
-		// include sessions that are older than filter.Older
-		// so - filter out ones that are too new
-		if filter.Older != types.Duration(0) {
-			now := time.Now()
-			tooNewThreshold := now.Add(-time.Duration(filter.Older))
-			if session.Updated.After(tooNewThreshold) { // too new
-				log.Trace().Msgf(
-					"skipping session %s because it is too new (session created at %s which is after threshold %s)",
-					session.ID, session.Created, tooNewThreshold,
-				)
-				continue
-			}
-		}
-
-		if filter.Mode != "" && session.Mode != filter.Mode {
+		reason, message := c.checkSessionFilter(filter, session)
+		if reason != types.SchedulingDecisionReasonAssigned {
+			log.Trace().Msgf("skipping session %s for runner %s: %s", session.ID, runnerID, message)
+			skipped = append(skipped, pendingSchedulingDecision{
+				session:       session,
+				queuePosition: i,
+				reason:        reason,
+				message:       message,
+				created:       time.Now(),
+			})
 			continue
 		}
-		if filter.Type != "" && session.Type != filter.Type {
-			continue
+
+		// if we've made it this far we've got a session!
+		return i, skipped
+	}
+
+	return -1, skipped
+}
+
+// work out if the given session can be handed to a runner asking with the
+// given filter - if not we return the reason why and a human readable message
+func (c *Controller) checkSessionFilter(filter types.SessionFilter, session *types.Session) (types.SchedulingDecisionReason, string) {
+	// include sessions that are older than filter.Older
+	// so - filter out ones that are too new
+	if filter.Older != types.Duration(0) {
+		now := time.Now()
+		tooNewThreshold := now.Add(-time.Duration(filter.Older))
+		if session.Updated.After(tooNewThreshold) { // too new
+			// the message stays the same each time so repeat decisions are
+			// counted together, the threshold is kept on the decision itself
+			return types.SchedulingDecisionReasonTooRecent, "session was updated too recently"
 		}
-		if filter.ModelName != "" && session.ModelName != filter.ModelName {
-			continue
+	}
+
+	if filter.Mode != "" && session.Mode != filter.Mode {
+		return types.SchedulingDecisionReasonRejectedByFilter, fmt.Sprintf("mode %s does not match filter %s", session.Mode, filter.Mode)
+	}
+	if filter.Type != "" && session.Type != filter.Type {
+		return types.SchedulingDecisionReasonRejectedByFilter, fmt.Sprintf("type %s does not match filter %s", session.Type, filter.Type)
+	}
+	if filter.ModelName != "" && session.ModelName != filter.ModelName {
+		return types.SchedulingDecisionReasonRejectedByFilter, fmt.Sprintf("model %s does not match filter %s", session.ModelName, filter.ModelName)
+	}
+
+	if filter.LoraDir == types.LORA_DIR_NONE {
+		// the filter is NONE - we cannot have a finetune file
+		if session.LoraDir != "" {
+			return types.SchedulingDecisionReasonRejectedByFilter, "session has a lora dir but the filter asked for none"
+		}
+	} else if filter.LoraDir != "" {
+		// the filter is a SPECIFIC file - we must have that file
+		if session.LoraDir != filter.LoraDir {
+			return types.SchedulingDecisionReasonRejectedByFilter, fmt.Sprintf("lora dir %s does not match filter %s", session.LoraDir, filter.LoraDir)
 		}
+	} else if filter.LoraDir == "" {
+		// the filter is ANY file - so anything goes
+	}
 
-		if filter.LoraDir == types.LORA_DIR_NONE {
-			// the filter is NONE - we cannot have a finetune file
-			if session.LoraDir != "" {
-				continue
-			}
-		} else if filter.LoraDir != "" {
-			// the filter is a SPECIFIC file - we must have that file
-			if session.LoraDir != filter.LoraDir {
-				continue
-			}
-		} else if filter.LoraDir == "" {
-			// the filter is ANY file - so anything goes
-		}
-
-		// we are asking for sessions that will fit in an amount of RAM
-		// so we need to ask the associated model instance what the memory
-		// requirements are for this session
-		if filter.Memory > 0 {
-			model, ok := c.models[session.ModelName]
-			if !ok {
-				continue
-			}
-			if model.GetMemoryRequirements(session.Mode) > filter.Memory {
-				continue
-			}
-		}
-
-		// look to see if we have any rejection matches that we should not include
-		reject := false
-		for _, rejectEntry := range filter.Reject {
-			if rejectEntry.ModelName == session.ModelName && rejectEntry.Mode == session.Mode &&
-				((rejectEntry.LoraDir == types.LORA_DIR_NONE && session.LoraDir == "") ||
-					(rejectEntry.LoraDir != "" && rejectEntry.LoraDir == session.LoraDir)) {
-				reject = true
-			}
-		}
-		if reject {
-			continue
+	// a model process has the env of the session it was started for so it
+	// can only run sessions with the same env
+	sessionEnv := session.Metadata.Env.Fingerprint()
+	if filter.Env == types.SESSION_ENV_NONE {
+		if sessionEnv != "" {
+			return types.SchedulingDecisionReasonRejectedByFilter, "session has env vars but the filter asked for none"
 		}
+	} else if filter.Env != "" && sessionEnv != filter.Env {
+		return types.SchedulingDecisionReasonRejectedByFilter, fmt.Sprintf("env %s does not match filter %s", sessionEnv, filter.Env)
+	}
 
-		// if we've made it this far we've got a session!
-		return i
+	// we are asking for sessions that will fit in an amount of RAM
+	// so we need to ask the associated model instance what the memory
+	// requirements are for this session
+	// a runner with no free memory never gets a session, even one for a model
+	// that claims to need nothing
+	if filter.Memory != nil {
+		model, ok := c.models[session.ModelName]
+		if !ok {
+			return types.SchedulingDecisionReasonRejectedByFilter, fmt.Sprintf("unknown model %s", session.ModelName)
+		}
+		requiredMemory := model.GetMemoryRequirements(session.Mode)
+		if *filter.Memory == 0 || requiredMemory > *filter.Memory {
+			return types.SchedulingDecisionReasonInsufficientMemory, fmt.Sprintf(
+				"needs %.2fGiB but the runner has %.2fGiB free", gib(requiredMemory), gib(*filter.Memory),
+			)
+		}
+	}
+
+	// look to see if we have any rejection matches that we should not include
+	for _, rejectEntry := range filter.Reject {
+		if rejectEntryMatches(rejectEntry, session) {
+			return types.SchedulingDecisionReasonRejectedByFilter, fmt.Sprintf(
+				"rejected by the runner (%s %s %s)", rejectEntry.ModelName, rejectEntry.Mode, rejectEntry.LoraDir,
+			)
+		}
 	}
 
-	return -1
+	return types.SchedulingDecisionReasonAssigned, ""
+}
+
+// a runner rejects what it is already running so a reject entry only
+// matches a session with the same model, mode, lora dir and env - a runner
+// fine tuning a model still takes inference sessions for it, and a session
+// with env vars the running process wasn't started with needs a process of
+// its own
+func rejectEntryMatches(rejectEntry types.SessionFilterModel, session *types.Session) bool {
+	return rejectEntry.ModelName == session.ModelName &&
+		rejectEntry.Mode == session.Mode &&
+		baseModelLoraDir(rejectEntry.LoraDir) == baseModelLoraDir(session.LoraDir) &&
+		rejectEntryEnvMatches(rejectEntry.Env, session.Metadata.Env.Fingerprint())
+}
+
+// the same rules as the env in a session filter: nothing means the process
+// doesn't use env vars so it would take any session, none means it was
+// started without them
+func rejectEntryEnvMatches(rejectEnv string, sessionEnv string) bool {
+	switch rejectEnv {
+	case "":
+		return true
+	case types.SESSION_ENV_NONE:
+		return sessionEnv == ""
+	default:
+		return rejectEnv == sessionEnv
+	}
+}
+
+// runners report the base model as either an empty lora dir or none
+func baseModelLoraDir(loraDir string) string {
+	if loraDir == types.LORA_DIR_NONE {
+		return ""
+	}
+	return loraDir
 }
 
 // load the session queues from the database in case of restart
@@ -133,17 +213,80 @@ func (c *Controller) loadSessionQueues(ctx context.Context) error {
 		sessionSummaryQueue = append(sessionSummaryQueue, summary)
 	}
 
-	// now we have the queue in oldest first order
-	c.sessionQueue = sessionQueue
-	c.sessionSummaryQueue = sessionSummaryQueue
+	// now we have the queue in oldest first order, put priority sessions
+	// in their place ahead of the others
+	indexes := make([]int, len(sessionQueue))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	// sessions with the same effective queue time go in the order they were
+	// created and then by ID so a restart always rebuilds the same queue
+	sort.SliceStable(indexes, func(i, j int) bool {
+		sessionI, sessionJ := sessionQueue[indexes[i]], sessionQueue[indexes[j]]
+		queueTimeI, queueTimeJ := c.getEffectiveQueueTime(sessionI), c.getEffectiveQueueTime(sessionJ)
+		if !queueTimeI.Equal(queueTimeJ) {
+			return queueTimeI.Before(queueTimeJ)
+		}
+		if !sessionI.Created.Equal(sessionJ.Created) {
+			return sessionI.Created.Before(sessionJ.Created)
+		}
+		return sessionI.ID < sessionJ.ID
+	})
+
+	c.sessionQueue = make([]*types.Session, len(indexes))
+	c.sessionSummaryQueue = make([]*types.SessionSummary, len(indexes))
+	for i, index := range indexes {
+		c.sessionQueue[i] = sessionQueue[index]
+		c.sessionSummaryQueue[i] = sessionSummaryQueue[index]
+		// the sessions were let in before the restart so they count against
+		// the quota even if that puts the owner over it
+		session := sessionQueue[index]
+		c.sessionQuotas.acquire(session.ID, sessionQuotaOwner{OwnerType: session.OwnerType, Owner: session.Owner}, 0)
+	}
+	// nobody is listening for the events yet
+	c.updateQueuePositions()
 	return nil
 }
 
+// the time we order the queue by - this is when the latest interaction was
+// created but priority sessions are treated as if they have been waiting
+// SessionPriorityBoost longer than they have
+// this means a new priority session jumps ahead of newer sessions but
+// a session that has been waiting for longer than the boost still wins
+// so nothing gets starved
+func (c *Controller) getEffectiveQueueTime(session *types.Session) time.Time {
+	queueTime := session.Updated
+	systemInteraction, err := data.GetSystemInteraction(session)
+	if err == nil && !systemInteraction.Created.IsZero() {
+		queueTime = systemInteraction.Created
+	}
+
+	if session.Metadata.Priority {
+		queueTime = queueTime.Add(-c.Options.SessionPriorityBoost)
+	}
+
+	return queueTime
+}
+
 func (c *Controller) ShiftSessionQueue(ctx context.Context, filter types.SessionFilter, runnerID string) (*types.Session, error) {
-	c.sessionQueueMtx.Lock()
-	defer c.sessionQueueMtx.Unlock()
+	// a cordoned runner is draining so it doesn't get any new work
+	if c.runnerCordoned(runnerID) {
+		return nil, nil
+	}
 
-	sessionIndex := c.getMatchingSessionFilterIndex(ctx, filter)
+	c.sessionQueueMtx.Lock()
+	events := []*types.WebsocketEvent{}
+	var (
+		skipped  []pendingSchedulingDecision
+		assigned *types.GlobalSchedulingDecision
+	)
+	defer func() {
+		c.sessionQueueMtx.Unlock()
+		c.recordSchedulingDecisions(filter, runnerID, skipped, assigned)
+		c.broadcastQueuePositions(events)
+	}()
+
+	sessionIndex, skipped := c.getMatchingSessionFilterIndex(ctx, filter, runnerID)
 
 	if sessionIndex >= 0 {
 		session := c.sessionQueue[sessionIndex]
@@ -155,6 +298,7 @@ func (c *Controller) ShiftSessionQueue(ctx context.Context, filter types.Session
 
 		c.sessionQueue = append(c.sessionQueue[:sessionIndex], c.sessionQueue[sessionIndex+1:]...)
 		c.sessionSummaryQueue = append(c.sessionSummaryQueue[:sessionIndex], c.sessionSummaryQueue[sessionIndex+1:]...)
+		events = c.updateQueuePositions()
 
 		if len(session.Interactions) == 0 {
 			return nil, fmt.Errorf("no interactions found")
@@ -162,6 +306,7 @@ func (c *Controller) ShiftSessionQueue(ctx context.Context, filter types.Session
 
 		session, err := data.UpdateSystemInteraction(session, func(targetInteraction *types.Interaction) (*types.Interaction, error) {
 			targetInteraction.Scheduled = time.Now()
+			targetInteraction.Runner = runnerID
 			return targetInteraction, nil
 		})
 
@@ -169,7 +314,7 @@ func (c *Controller) ShiftSessionQueue(ctx context.Context, filter types.Session
 			return nil, err
 		}
 
-		c.addSchedulingDecision(filter, runnerID, session)
+		assigned = c.newSchedulingDecision(filter, runnerID, session, sessionIndex, types.SchedulingDecisionReasonAssigned, "", time.Now())
 		c.WriteSession(session)
 		return session, nil
 	}
@@ -177,20 +322,75 @@ func (c *Controller) ShiftSessionQueue(ctx context.Context, filter types.Session
 	return nil, nil
 }
 
-func (c *Controller) addSchedulingDecision(filter types.SessionFilter, runnerID string, session *types.Session) {
+func (c *Controller) newSchedulingDecision(
+	filter types.SessionFilter,
+	runnerID string,
+	session *types.Session,
+	queuePosition int,
+	reason types.SchedulingDecisionReason,
+	message string,
+	created time.Time,
+) *types.GlobalSchedulingDecision {
+	interactionID := ""
+	retryCount := 0
 	systemInteraction, err := data.GetSystemInteraction(session)
-	if err != nil {
-		log.Error().Msgf("error adding scheduling decision: %s", err)
-		return
+	if err == nil {
+		interactionID = systemInteraction.ID
+		retryCount = getInteractionRetryCount(systemInteraction)
 	}
 	decision := &types.GlobalSchedulingDecision{
-		Created:       time.Now(),
+		Created:       created,
 		RunnerID:      runnerID,
 		SessionID:     session.ID,
-		InteractionID: systemInteraction.ID,
+		InteractionID: interactionID,
 		Filter:        filter,
 		ModelName:     session.ModelName,
 		Mode:          session.Mode,
+		Reason:        reason,
+		Message:       message,
+		Count:         1,
+		RetryCount:    retryCount,
+		Priority:      session.Metadata.Priority,
+		QueuePosition: queuePosition,
+		QueueTime:     c.getEffectiveQueueTime(session),
+	}
+	if reason == types.SchedulingDecisionReasonTooRecent {
+		decision.UpdatedBefore = created.Add(-time.Duration(filter.Older))
+	}
+	return decision
+}
+
+// build the decisions for a runner asking for work once the queue has been
+// unlocked - the session it was given (if any) has left the queue so we
+// don't need to know why it was waiting anymore
+func (c *Controller) recordSchedulingDecisions(
+	filter types.SessionFilter,
+	runnerID string,
+	skipped []pendingSchedulingDecision,
+	assigned *types.GlobalSchedulingDecision,
+) {
+	decisions := make([]*types.GlobalSchedulingDecision, 0, len(skipped))
+	for _, pending := range skipped {
+		decisions = append(decisions, c.newSchedulingDecision(filter, runnerID, pending.session, pending.queuePosition, pending.reason, pending.message, pending.created))
+	}
+
+	c.schedulingDecisionsMtx.Lock()
+	defer c.schedulingDecisionsMtx.Unlock()
+	for _, decision := range decisions {
+		c.addSessionSchedulingDecision(decision)
+	}
+	if assigned != nil {
+		c.addSchedulingDecision(assigned)
+		delete(c.sessionSchedulingDecisions, assigned.SessionID)
+	}
+}
+
+// the global buffer only has the sessions that were handed to a runner
+// this function expects the schedulingDecisionsMtx to be locked when it is run
+func (c *Controller) addSchedulingDecision(decision *types.GlobalSchedulingDecision) {
+	if decision.InteractionID == "" {
+		log.Error().Msgf("error adding scheduling decision: no system interaction found for session %s", decision.SessionID)
+		return
 	}
 
 	c.schedulingDecisions = append([]*types.GlobalSchedulingDecision{decision}, c.schedulingDecisions...)
@@ -199,3 +399,160 @@ func (c *Controller) addSchedulingDecision(filter types.SessionFilter, runnerID
 		c.schedulingDecisions = c.schedulingDecisions[:len(c.schedulingDecisions)-1]
 	}
 }
+
+// keep a short history of why a queued session was passed over
+// runners poll all the time so if we already have a decision for the same
+// runner, reason and message we bump it's count and move it to the front
+// rather than filling the buffer with copies
+// this function expects the schedulingDecisionsMtx to be locked when it is run
+func (c *Controller) addSessionSchedulingDecision(decision *types.GlobalSchedulingDecision) {
+	existing := c.sessionSchedulingDecisions[decision.SessionID]
+	decisions := []*types.GlobalSchedulingDecision{decision}
+	for _, previous := range existing {
+		if previous.RunnerID == decision.RunnerID && previous.Reason == decision.Reason && previous.Message == decision.Message {
+			decision.Count = previous.Count + 1
+			continue
+		}
+		decisions = append(decisions, previous)
+	}
+
+	if len(decisions) > sessionSchedulingDecisionBufferSize {
+		decisions = decisions[:sessionSchedulingDecisionBufferSize]
+	}
+
+	// we replace the slice rather than edit it so the dashboard
+	// can hold onto the old one while we carry on
+	c.sessionSchedulingDecisions[decision.SessionID] = decisions
+}
+
+func (c *Controller) forgetSessionSchedulingDecisions(sessionID string) {
+	c.schedulingDecisionsMtx.Lock()
+	defer c.schedulingDecisionsMtx.Unlock()
+	delete(c.sessionSchedulingDecisions, sessionID)
+}
+
+// make sure at least one of the runners we know about has enough memory to run
+// the session - otherwise it would sit in the queue forever
+// this is done when a session is first queued, not when it goes back on
+// the queue, and again for everything waiting when runners go away for good
+// (see errorUnservableQueuedSessions) - we work it out from the current
+// runner fleet each time so once a bigger runner connects the same model can
+// be queued
+// if no runners have reported yet we can't tell so we let the session through
+func (c *Controller) checkRunnerCapacity(session *types.Session) error {
+	model, ok := c.models[session.ModelName]
+	if !ok {
+		return nil
+	}
+
+	requiredMemory := model.GetMemoryRequirements(session.Mode)
+
+	runners := c.getRunners()
+	maxRunnerMemory := uint64(0)
+	for _, runner := range runners {
+		if runner.TotalMemory > maxRunnerMemory {
+			maxRunnerMemory = runner.TotalMemory
+		}
+	}
+
+	if len(runners) == 0 || requiredMemory <= maxRunnerMemory {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"no runner can serve this model: %s (%s) needs %.2fGiB of GPU memory but the largest connected runner has %.2fGiB",
+		session.ModelName, session.Mode, gib(requiredMemory), gib(maxRunnerMemory),
+	)
+}
+
+// a session that was let in while a bigger runner was connected would wait
+// forever once that runner has gone, so when runners leave we check what is
+// waiting against the runners that are left and error what none can run
+func (c *Controller) errorUnservableQueuedSessions() {
+	type unservableSession struct {
+		session *types.Session
+		err     error
+	}
+	unservable := []unservableSession{}
+
+	c.sessionQueueMtx.Lock()
+	for _, session := range c.sessionQueue {
+		err := c.checkRunnerCapacity(session)
+		if err != nil {
+			unservable = append(unservable, unservableSession{session: session, err: err})
+		}
+	}
+	c.sessionQueueMtx.Unlock()
+
+	for _, queued := range unservable {
+		// a runner may have picked it up since we looked
+		if !c.removeSessionFromQueue(queued.session.ID) {
+			continue
+		}
+		log.Warn().Msgf("erroring queued session %s: %s", queued.session.ID, queued.err.Error())
+		c.ErrorSession(queued.session, queued.err)
+	}
+}
+
+// the runners that have reported in the order we look at them - the most
+// free memory first and then by ID so the same fleet always comes back the
+// same way whatever order the map hands them back in
+func (c *Controller) getRunners() []*types.RunnerState {
+	runners := []*types.RunnerState{}
+	c.activeRunners.Range(func(i string, runner *types.RunnerState) bool {
+		runners = append(runners, runner)
+		return true
+	})
+	sort.Slice(runners, func(i, j int) bool {
+		if runners[i].FreeMemory != runners[j].FreeMemory {
+			return runners[i].FreeMemory > runners[j].FreeMemory
+		}
+		return runners[i].ID < runners[j].ID
+	})
+	return runners
+}
+
+// we go by the state the runner last reported
+func (c *Controller) runnerCordoned(runnerID string) bool {
+	runner, ok := c.activeRunners.Load(runnerID)
+	return ok && runner.Cordoned
+}
+
+// the runners that can be handed new sessions
+func (c *Controller) getSchedulableRunners() []*types.RunnerState {
+	runners := []*types.RunnerState{}
+	for _, runner := range c.getRunners() {
+		if !runner.Cordoned {
+			runners = append(runners, runner)
+		}
+	}
+	return runners
+}
+
+func gib(bytes uint64) float64 {
+	return float64(bytes) / 1024 / 1024 / 1024
+}
+
+// take a session off the queue (if it's on there) without it going to a runner
+// returns true if the session was waiting in the queue
+func (c *Controller) removeSessionFromQueue(sessionID string) bool {
+	c.sessionQueueMtx.Lock()
+	events := []*types.WebsocketEvent{}
+	defer func() {
+		c.sessionQueueMtx.Unlock()
+		c.broadcastQueuePositions(events)
+	}()
+
+	for i, session := range c.sessionQueue {
+		if session.ID != sessionID {
+			continue
+		}
+		c.sessionQueue = append(c.sessionQueue[:i], c.sessionQueue[i+1:]...)
+		c.sessionSummaryQueue = append(c.sessionSummaryQueue[:i], c.sessionSummaryQueue[i+1:]...)
+		c.forgetSessionSchedulingDecisions(sessionID)
+		events = c.updateQueuePositions()
+		return true
+	}
+
+	return false
+}
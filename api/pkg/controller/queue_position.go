@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// how many queue position events can wait to be sent to the users
+const queuePositionEventBufferSize = 1000
+
+// how many of the latest jobs for each model and mode we average to guess
+// how long the next one will take
+const jobDurationHistorySize = 20
+
+type jobDurationKey struct {
+	ModelName types.ModelName
+	Mode      types.SessionMode
+}
+
+// how long runners took (from being handed the session to sending the
+// result) for the latest jobs of each model and mode
+type jobDurationHistory struct {
+	mtx       sync.Mutex
+	durations map[jobDurationKey][]time.Duration
+}
+
+func (h *jobDurationHistory) add(key jobDurationKey, duration time.Duration) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.durations == nil {
+		h.durations = map[jobDurationKey][]time.Duration{}
+	}
+	durations := append(h.durations[key], duration)
+	if len(durations) > jobDurationHistorySize {
+		durations = durations[len(durations)-jobDurationHistorySize:]
+	}
+	h.durations[key] = durations
+}
+
+// the average of the latest jobs - false if we have never timed one
+func (h *jobDurationHistory) average(key jobDurationKey) (time.Duration, bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	durations := h.durations[key]
+	if len(durations) == 0 {
+		return 0, false
+	}
+	total := time.Duration(0)
+	for _, duration := range durations {
+		total += duration
+	}
+	return total / time.Duration(len(durations)), true
+}
+
+func (c *Controller) recordJobDuration(session *types.Session) {
+	systemInteraction, err := data.GetSystemInteraction(session)
+	if err != nil || systemInteraction.Scheduled.IsZero() || !systemInteraction.Completed.After(systemInteraction.Scheduled) {
+		return
+	}
+	c.jobDurations.add(jobDurationKey{
+		ModelName: session.ModelName,
+		Mode:      session.Mode,
+	}, systemInteraction.Completed.Sub(systemInteraction.Scheduled))
+}
+
+// give every queued summary its position and ETA and return the events to
+// tell the users whose sessions have moved
+// the ETA is the time for the jobs ahead plus this one split across the
+// runners that aren't cordoned - jobs ahead for a model we have never timed
+// are guessed to take as long as this one
+// we replace the summary slice rather than edit it so the dashboard can
+// hold onto the old one while we carry on
+// this function expects the sessionQueueMtx to be locked when it is run
+func (c *Controller) updateQueuePositions() []*types.WebsocketEvent {
+	runners := len(c.getSchedulableRunners())
+	if runners == 0 {
+		runners = 1
+	}
+
+	events := []*types.WebsocketEvent{}
+	summaries := make([]*types.SessionSummary, len(c.sessionSummaryQueue))
+	timedAhead := time.Duration(0)
+	untimedAhead := 0
+	for i, summary := range c.sessionSummaryQueue {
+		position := i + 1
+		eta := time.Duration(0)
+		average, ok := c.jobDurations.average(jobDurationKey{ModelName: summary.ModelName, Mode: summary.Mode})
+		if ok {
+			eta = ((timedAhead + time.Duration(untimedAhead+1)*average) / time.Duration(runners)).Round(time.Second)
+			timedAhead += average
+		} else {
+			untimedAhead++
+		}
+
+		summaries[i] = summary
+		if summary.QueuePosition == position && summary.QueueETA == types.Duration(eta) {
+			continue
+		}
+		updated := *summary
+		updated.QueuePosition = position
+		updated.QueueETA = types.Duration(eta)
+		summaries[i] = &updated
+
+		events = append(events, &types.WebsocketEvent{
+			Type:      types.WebsocketEventQueuePosition,
+			SessionID: summary.SessionID,
+			Owner:     summary.Owner,
+			QueuePosition: &types.QueuePositionUpdate{
+				InteractionID: summary.InteractionID,
+				Position:      position,
+				ETA:           types.Duration(eta),
+			},
+		})
+	}
+
+	c.sessionSummaryQueue = summaries
+	return events
+}
+
+// queue the position events to be sent in the background so a request that
+// moved the queue doesn't wait for every user to be told - if the publisher
+// has fallen too far behind the event is dropped, the next move sends the
+// latest position anyway
+func (c *Controller) broadcastQueuePositions(events []*types.WebsocketEvent) {
+	for _, event := range events {
+		select {
+		case c.queuePositionEvents <- event:
+		default:
+			log.Warn().Msgf("dropping queue position event for session %s, the publisher is behind", event.SessionID)
+		}
+	}
+}
+
+// this should be run in a go-routine
+func (c *Controller) publishQueuePositions(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-c.queuePositionEvents:
+			select {
+			case <-ctx.Done():
+				return
+			case c.UserWebsocketEventChanWriter <- event:
+			}
+		}
+	}
+}
+
+// fill in the queue position and ETA of any of the summaries that are queued
+func (c *Controller) AddQueuePositions(summaries []*types.SessionSummary) {
+	c.sessionQueueMtx.Lock()
+	queued := make(map[string]*types.SessionSummary, len(c.sessionSummaryQueue))
+	for _, summary := range c.sessionSummaryQueue {
+		queued[summary.SessionID] = summary
+	}
+	c.sessionQueueMtx.Unlock()
+
+	for _, summary := range summaries {
+		if queuedSummary, ok := queued[summary.SessionID]; ok {
+			summary.QueuePosition = queuedSummary.QueuePosition
+			summary.QueueETA = queuedSummary.QueueETA
+		}
+	}
+}
@@ -0,0 +1,565 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/janitor"
+	"github.com/helixml/helix/api/pkg/model"
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestAddSessionToQueue_PriorityWithAging(t *testing.T) {
+	now := time.Now()
+	newSession := func(id string, queued time.Time, priority bool) *types.Session {
+		return &types.Session{
+			ID:       id,
+			Mode:     types.SessionModeInference,
+			Metadata: types.SessionMetadata{Priority: priority},
+			Interactions: []*types.Interaction{
+				{ID: id + "-user", Creator: types.CreatorTypeUser},
+				{ID: id + "-system", Creator: types.CreatorTypeSystem, Created: queued},
+			},
+		}
+	}
+
+	c := &Controller{
+		Options:       ControllerOptions{SessionPriorityBoost: 5 * time.Minute, SchedulingDecisionBufferSize: 10},
+		activeRunners: xsync.NewMapOf[string, *types.RunnerState](),
+	}
+
+	c.AddSessionToQueue(newSession("waited-long", now.Add(-10*time.Minute), false))
+	c.AddSessionToQueue(newSession("waited-short", now.Add(-time.Minute), false))
+	// a new priority session jumps the short wait but not the long one
+	c.AddSessionToQueue(newSession("priority", now, true))
+
+	ids := []string{}
+	for _, session := range c.sessionQueue {
+		ids = append(ids, session.ID)
+	}
+	assert.Equal(t, []string{"waited-long", "priority", "waited-short"}, ids)
+
+	// the decision shows where the session was and the time it was ordered by
+	c.sessionQueue = c.sessionQueue[1:]
+	c.sessionSummaryQueue = c.sessionSummaryQueue[1:]
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	mockStore.EXPECT().UpdateSession(gomock.Any(), gomock.Any()).Return(nil, nil)
+	c.Options.Store = mockStore
+	c.UserWebsocketEventChanWriter = make(chan *types.WebsocketEvent, 10)
+
+	next, err := c.ShiftSessionQueue(context.Background(), types.SessionFilter{}, "runner-1")
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	assert.Equal(t, "priority", next.ID)
+	require.Len(t, c.schedulingDecisions, 1)
+	assert.True(t, c.schedulingDecisions[0].Priority)
+	assert.Equal(t, 0, c.schedulingDecisions[0].QueuePosition)
+	assert.Equal(t, now.Add(-5*time.Minute), c.schedulingDecisions[0].QueueTime)
+}
+
+func TestCheckRunnerCapacity(t *testing.T) {
+	models, err := model.GetModels()
+	require.NoError(t, err)
+	c := &Controller{models: models, activeRunners: xsync.NewMapOf[string, *types.RunnerState]()}
+
+	session := &types.Session{ID: "session-1", ModelName: types.Model_Axolotl_Mistral7b, Mode: types.SessionModeFinetune}
+	required := models[session.ModelName].GetMemoryRequirements(session.Mode)
+
+	// no runners yet so we can't tell
+	assert.NoError(t, c.checkRunnerCapacity(session))
+
+	c.activeRunners.Store("runner-1", &types.RunnerState{ID: "runner-1", TotalMemory: required - 1})
+	assert.ErrorContains(t, c.checkRunnerCapacity(session), "no runner can serve this model")
+
+	// a bigger runner connecting lets the model in again
+	c.activeRunners.Store("runner-2", &types.RunnerState{ID: "runner-2", TotalMemory: required})
+	assert.NoError(t, c.checkRunnerCapacity(session))
+}
+
+func TestErrorUnservableQueuedSessions(t *testing.T) {
+	models, err := model.GetModels()
+	require.NoError(t, err)
+
+	newQueuedSession := func(id string, modelName types.ModelName, mode types.SessionMode) *types.Session {
+		return &types.Session{
+			ID:        id,
+			ModelName: modelName,
+			Mode:      mode,
+			Interactions: []*types.Interaction{
+				{ID: id + "-user", Creator: types.CreatorTypeUser},
+				{ID: id + "-system", Creator: types.CreatorTypeSystem},
+			},
+		}
+	}
+	big := newQueuedSession("big", types.Model_Axolotl_Mistral7b, types.SessionModeFinetune)
+	small := newQueuedSession("small", types.Model_Ollama_Mistral7b, types.SessionModeInference)
+
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	var errored *types.Session
+	mockStore.EXPECT().UpdateSession(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, session types.Session) (*types.Session, error) {
+		errored = &session
+		return &session, nil
+	})
+	// the webhook is looked up in the background
+	webhookChecked := make(chan struct{})
+	mockStore.EXPECT().GetUserMeta(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, id string) (*types.UserMeta, error) {
+		close(webhookChecked)
+		return &types.UserMeta{ID: id}, nil
+	})
+
+	c := &Controller{
+		Options:                      ControllerOptions{Store: mockStore, Janitor: janitor.NewJanitor(janitor.JanitorOptions{})},
+		models:                       models,
+		activeRunners:                xsync.NewMapOf[string, *types.RunnerState](),
+		UserWebsocketEventChanWriter: make(chan *types.WebsocketEvent, 10),
+	}
+	c.requeueSession(big)
+	c.requeueSession(small)
+
+	// the runner that could fine tune has gone and this one only fits mistral inference
+	c.activeRunners.Store("runner-1", &types.RunnerState{
+		ID:          "runner-1",
+		TotalMemory: models[small.ModelName].GetMemoryRequirements(small.Mode),
+	})
+	c.errorUnservableQueuedSessions()
+
+	require.Len(t, c.sessionQueue, 1)
+	assert.Equal(t, small.ID, c.sessionQueue[0].ID)
+
+	require.NotNil(t, errored)
+	assert.Equal(t, big.ID, errored.ID)
+	assert.Contains(t, errored.Interactions[1].Error, "no runner can serve this model")
+	select {
+	case <-webhookChecked:
+	case <-time.After(time.Second):
+		t.Fatal("webhook was not checked")
+	}
+}
+
+func TestRetryInteraction_SkipsCapacityCheck(t *testing.T) {
+	models, err := model.GetModels()
+	require.NoError(t, err)
+
+	c := newRetryTestController(t, 1)
+	c.models = models
+	// the runners that are left are too small but the session was let in
+	// when it was first queued
+	c.activeRunners.Store("runner-1", &types.RunnerState{ID: "runner-1", TotalMemory: 1})
+
+	session := newRetrySession()
+	session.ModelName = types.Model_Axolotl_Mistral7b
+	retried, err := c.retryInteraction(context.Background(), session, &types.RunnerTaskResponse{
+		SessionID: session.ID,
+		Error:     "CUDA out of memory",
+	})
+	require.NoError(t, err)
+	assert.True(t, retried)
+	require.Len(t, c.sessionQueue, 1)
+	assert.Equal(t, session.ID, c.sessionQueue[0].ID)
+}
+
+func TestReleaseSession_SkipsCapacityCheck(t *testing.T) {
+	models, err := model.GetModels()
+	require.NoError(t, err)
+
+	session := &types.Session{
+		ID:        "session-1",
+		ModelName: types.Model_Axolotl_Mistral7b,
+		Mode:      types.SessionModeFinetune,
+		Interactions: []*types.Interaction{
+			{ID: "session-1-user", Creator: types.CreatorTypeUser},
+			{ID: "session-1-system", Creator: types.CreatorTypeSystem},
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	mockStore.EXPECT().GetSession(gomock.Any(), session.ID).Return(session, nil)
+
+	c := &Controller{
+		Options:       ControllerOptions{Store: mockStore},
+		models:        models,
+		activeRunners: xsync.NewMapOf[string, *types.RunnerState](),
+	}
+	// the runners that are left are too small but the session was let in
+	// when it was first queued
+	c.activeRunners.Store("runner-1", &types.RunnerState{ID: "runner-1", TotalMemory: 1})
+
+	requeued, err := c.ReleaseSession(context.Background(), session.ID, "session-1-system")
+	require.NoError(t, err)
+	assert.True(t, requeued)
+	require.Len(t, c.sessionQueue, 1)
+	assert.Equal(t, session.ID, c.sessionQueue[0].ID)
+}
+
+func TestShiftSessionQueue_TooRecentDecisionsCounted(t *testing.T) {
+	session := &types.Session{
+		ID:      "session-1",
+		Mode:    types.SessionModeInference,
+		Updated: time.Now(),
+		Interactions: []*types.Interaction{
+			{ID: "session-1-system", Creator: types.CreatorTypeSystem},
+		},
+	}
+	c := &Controller{
+		activeRunners:              xsync.NewMapOf[string, *types.RunnerState](),
+		sessionQueue:               []*types.Session{session},
+		sessionSummaryQueue:        []*types.SessionSummary{{SessionID: session.ID}},
+		sessionSchedulingDecisions: map[string][]*types.GlobalSchedulingDecision{},
+	}
+
+	filter := types.SessionFilter{Older: types.Duration(time.Minute)}
+	for i := 0; i < 2; i++ {
+		next, err := c.ShiftSessionQueue(context.Background(), filter, "runner-1")
+		require.NoError(t, err)
+		assert.Nil(t, next)
+	}
+
+	// the same decision each time so it's counted rather than repeated
+	decisions := c.sessionSchedulingDecisions[session.ID]
+	require.Len(t, decisions, 1)
+	assert.Equal(t, types.SchedulingDecisionReasonTooRecent, decisions[0].Reason)
+	assert.Equal(t, 2, decisions[0].Count)
+	assert.Equal(t, decisions[0].Created.Add(-time.Minute), decisions[0].UpdatedBefore)
+}
+
+func TestGetRunners(t *testing.T) {
+	c := &Controller{activeRunners: xsync.NewMapOf[string, *types.RunnerState]()}
+	for _, runner := range []*types.RunnerState{
+		{ID: "runner-d", FreeMemory: 8},
+		{ID: "runner-c", FreeMemory: 24},
+		{ID: "runner-a", FreeMemory: 8},
+		{ID: "runner-b", FreeMemory: 24},
+	} {
+		c.activeRunners.Store(runner.ID, runner)
+	}
+
+	// the most free memory first then by ID, every time
+	for i := 0; i < 10; i++ {
+		ids := []string{}
+		for _, runner := range c.getRunners() {
+			ids = append(ids, runner.ID)
+		}
+		assert.Equal(t, []string{"runner-b", "runner-c", "runner-a", "runner-d"}, ids)
+	}
+}
+
+func TestLoadSessionQueues_TiesInOrder(t *testing.T) {
+	queued := time.Now().Add(-time.Minute)
+	newQueuedSession := func(id string, created time.Time) *types.Session {
+		return &types.Session{
+			ID:      id,
+			Mode:    types.SessionModeInference,
+			Created: created,
+			Updated: queued,
+			Interactions: []*types.Interaction{
+				{ID: id + "-system", Creator: types.CreatorTypeSystem},
+				{ID: id + "-user", Creator: types.CreatorTypeUser},
+			},
+		}
+	}
+
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	// newest first like the store hands them back
+	mockStore.EXPECT().GetSessions(gomock.Any(), gomock.Any()).Return([]*types.Session{
+		newQueuedSession("session-b", queued),
+		newQueuedSession("session-c", queued.Add(-time.Second)),
+		newQueuedSession("session-a", queued),
+	}, nil)
+
+	c := &Controller{Options: ControllerOptions{Store: mockStore}, activeRunners: xsync.NewMapOf[string, *types.RunnerState]()}
+	require.NoError(t, c.loadSessionQueues(context.Background()))
+
+	ids := []string{}
+	for _, session := range c.sessionQueue {
+		ids = append(ids, session.ID)
+	}
+	assert.Equal(t, []string{"session-c", "session-a", "session-b"}, ids)
+}
+
+func TestJobDurationHistory(t *testing.T) {
+	history := jobDurationHistory{}
+	key := jobDurationKey{ModelName: types.Model_Ollama_Llama3_8b, Mode: types.SessionModeInference}
+
+	_, ok := history.average(key)
+	assert.False(t, ok)
+
+	// only the latest jobs count
+	history.add(key, time.Hour)
+	for i := 0; i < jobDurationHistorySize; i++ {
+		history.add(key, 10*time.Second)
+	}
+	average, ok := history.average(key)
+	require.True(t, ok)
+	assert.Equal(t, 10*time.Second, average)
+}
+
+func TestUpdateQueuePositions(t *testing.T) {
+	timed := jobDurationKey{ModelName: types.Model_Ollama_Llama3_8b, Mode: types.SessionModeInference}
+	c := &Controller{
+		activeRunners:                xsync.NewMapOf[string, *types.RunnerState](),
+		UserWebsocketEventChanWriter: make(chan *types.WebsocketEvent),
+		queuePositionEvents:          make(chan *types.WebsocketEvent, 10),
+	}
+	c.activeRunners.Store("runner-1", &types.RunnerState{ID: "runner-1"})
+	c.activeRunners.Store("runner-2", &types.RunnerState{ID: "runner-2"})
+	c.jobDurations.add(timed, 20*time.Second)
+
+	for _, id := range []string{"session-1", "session-2", "session-3"} {
+		modelName := timed.ModelName
+		if id == "session-2" {
+			// never timed
+			modelName = types.Model_Axolotl_Mistral7b
+		}
+		c.sessionQueue = append(c.sessionQueue, &types.Session{ID: id})
+		c.sessionSummaryQueue = append(c.sessionSummaryQueue, &types.SessionSummary{
+			SessionID:     id,
+			InteractionID: id + "-system",
+			Owner:         "owner-1",
+			ModelName:     modelName,
+			Mode:          types.SessionModeInference,
+		})
+	}
+	dashboardQueue := c.sessionSummaryQueue
+
+	events := c.updateQueuePositions()
+	require.Len(t, events, 3)
+	assert.Equal(t, types.WebsocketEventQueuePosition, events[0].Type)
+	assert.Equal(t, "session-1", events[0].SessionID)
+	assert.Equal(t, &types.QueuePositionUpdate{InteractionID: "session-1-system", Position: 1, ETA: types.Duration(10 * time.Second)}, events[0].QueuePosition)
+
+	// session 2 is left without an ETA and is guessed to take as long as
+	// session 3 when working out session 3's
+	assert.Equal(t, 2, c.sessionSummaryQueue[1].QueuePosition)
+	assert.Equal(t, types.Duration(0), c.sessionSummaryQueue[1].QueueETA)
+	assert.Equal(t, 3, c.sessionSummaryQueue[2].QueuePosition)
+	assert.Equal(t, types.Duration(30*time.Second), c.sessionSummaryQueue[2].QueueETA)
+
+	// what the dashboard already has is left alone
+	assert.Equal(t, 0, dashboardQueue[0].QueuePosition)
+
+	// nothing has moved
+	assert.Empty(t, c.updateQueuePositions())
+
+	// nobody is reading the websocket events yet but taking the session off
+	// the queue doesn't wait for them to be sent
+	require.True(t, c.removeSessionFromQueue("session-1"))
+	require.Len(t, c.queuePositionEvents, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.publishQueuePositions(ctx)
+	event := <-c.UserWebsocketEventChanWriter
+	assert.Equal(t, "session-2", event.SessionID)
+	assert.Equal(t, 1, event.QueuePosition.Position)
+	event = <-c.UserWebsocketEventChanWriter
+	assert.Equal(t, "session-3", event.SessionID)
+	assert.Equal(t, &types.QueuePositionUpdate{InteractionID: "session-3-system", Position: 2, ETA: types.Duration(20 * time.Second)}, event.QueuePosition)
+
+	summaries := []*types.SessionSummary{{SessionID: "session-3"}, {SessionID: "session-4"}}
+	c.AddQueuePositions(summaries)
+	assert.Equal(t, 2, summaries[0].QueuePosition)
+	assert.Equal(t, types.Duration(20*time.Second), summaries[0].QueueETA)
+	assert.Equal(t, 0, summaries[1].QueuePosition)
+}
+
+func TestCheckSessionFilter_Memory(t *testing.T) {
+	models, err := model.GetModels()
+	require.NoError(t, err)
+	c := &Controller{models: models}
+
+	session := &types.Session{
+		ID:        "session-1",
+		ModelName: types.Model_Ollama_Mistral7b,
+		Mode:      types.SessionModeInference,
+	}
+	required := models[session.ModelName].GetMemoryRequirements(session.Mode)
+	memory := func(bytes uint64) *uint64 {
+		return &bytes
+	}
+
+	// no memory asked for means no memory check
+	reason, _ := c.checkSessionFilter(types.SessionFilter{}, session)
+	assert.Equal(t, types.SchedulingDecisionReasonAssigned, reason)
+
+	reason, _ = c.checkSessionFilter(types.SessionFilter{Memory: memory(required)}, session)
+	assert.Equal(t, types.SchedulingDecisionReasonAssigned, reason)
+
+	reason, _ = c.checkSessionFilter(types.SessionFilter{Memory: memory(required - 1)}, session)
+	assert.Equal(t, types.SchedulingDecisionReasonInsufficientMemory, reason)
+
+	// a runner with nothing free never gets a session
+	reason, _ = c.checkSessionFilter(types.SessionFilter{Memory: memory(0)}, session)
+	assert.Equal(t, types.SchedulingDecisionReasonInsufficientMemory, reason)
+}
+
+func TestCheckSessionFilter_Reject(t *testing.T) {
+	c := &Controller{}
+	modelName := types.Model_Axolotl_Mistral7b
+
+	// the runner is fine tuning the model
+	filter := types.SessionFilter{
+		Reject: []types.SessionFilterModel{
+			{ModelName: modelName, Mode: types.SessionModeFinetune, LoraDir: types.LORA_DIR_NONE},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		session *types.Session
+		want    types.SchedulingDecisionReason
+	}{
+		{
+			name:    "another fine tune of the model",
+			session: &types.Session{ModelName: modelName, Mode: types.SessionModeFinetune},
+			want:    types.SchedulingDecisionReasonRejectedByFilter,
+		},
+		{
+			name:    "inference on the model",
+			session: &types.Session{ModelName: modelName, Mode: types.SessionModeInference},
+			want:    types.SchedulingDecisionReasonAssigned,
+		},
+		{
+			name:    "inference on a fine tuned version of the model",
+			session: &types.Session{ModelName: modelName, Mode: types.SessionModeInference, LoraDir: "/lora/session-1"},
+			want:    types.SchedulingDecisionReasonAssigned,
+		},
+		{
+			name:    "fine tune of another model",
+			session: &types.Session{ModelName: types.Model_Ollama_Mistral7b, Mode: types.SessionModeFinetune},
+			want:    types.SchedulingDecisionReasonAssigned,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, _ := c.checkSessionFilter(filter, tt.session)
+			assert.Equal(t, tt.want, reason)
+		})
+	}
+
+	// an empty lora dir is the base model too
+	filter.Reject = []types.SessionFilterModel{
+		{ModelName: types.Model_Ollama_Mistral7b, Mode: types.SessionModeInference},
+		{ModelName: modelName, Mode: types.SessionModeInference, LoraDir: "/lora/session-1"},
+	}
+	reason, _ := c.checkSessionFilter(filter, &types.Session{ModelName: types.Model_Ollama_Mistral7b, Mode: types.SessionModeInference})
+	assert.Equal(t, types.SchedulingDecisionReasonRejectedByFilter, reason)
+
+	reason, _ = c.checkSessionFilter(filter, &types.Session{ModelName: modelName, Mode: types.SessionModeInference, LoraDir: "/lora/session-1"})
+	assert.Equal(t, types.SchedulingDecisionReasonRejectedByFilter, reason)
+
+	reason, _ = c.checkSessionFilter(filter, &types.Session{ModelName: modelName, Mode: types.SessionModeInference, LoraDir: "/lora/session-2"})
+	assert.Equal(t, types.SchedulingDecisionReasonAssigned, reason)
+
+	reason, _ = c.checkSessionFilter(filter, &types.Session{ModelName: modelName, Mode: types.SessionModeInference})
+	assert.Equal(t, types.SchedulingDecisionReasonAssigned, reason)
+}
+
+func TestShiftSessionQueue_CordonedRunner(t *testing.T) {
+	session := &types.Session{
+		ID:   "session-1",
+		Mode: types.SessionModeInference,
+		Interactions: []*types.Interaction{
+			{ID: "session-1-system", Creator: types.CreatorTypeSystem},
+		},
+	}
+	c := &Controller{
+		activeRunners:       xsync.NewMapOf[string, *types.RunnerState](),
+		sessionQueue:        []*types.Session{session},
+		sessionSummaryQueue: []*types.SessionSummary{{SessionID: session.ID}},
+	}
+	c.activeRunners.Store("runner-1", &types.RunnerState{ID: "runner-1", Cordoned: true})
+	c.activeRunners.Store("runner-2", &types.RunnerState{ID: "runner-2"})
+
+	next, err := c.ShiftSessionQueue(context.Background(), types.SessionFilter{}, "runner-1")
+	require.NoError(t, err)
+	assert.Nil(t, next)
+	assert.Len(t, c.sessionQueue, 1)
+
+	// the cordoned runner doesn't count towards the queue ETA either
+	runners := c.getSchedulableRunners()
+	require.Len(t, runners, 1)
+	assert.Equal(t, "runner-2", runners[0].ID)
+}
+
+func TestCheckSessionFilter_Env(t *testing.T) {
+	c := &Controller{}
+	modelName := types.Model_Axolotl_Mistral7b
+	env := types.SessionEnv{"HELIX_SESSION_SEED": "42"}
+
+	plain := &types.Session{ModelName: modelName, Mode: types.SessionModeInference}
+	withEnv := &types.Session{ModelName: modelName, Mode: types.SessionModeInference, Metadata: types.SessionMetadata{Env: env}}
+	otherEnv := &types.Session{ModelName: modelName, Mode: types.SessionModeInference, Metadata: types.SessionMetadata{Env: types.SessionEnv{"HELIX_SESSION_SEED": "43"}}}
+
+	tests := []struct {
+		name    string
+		filter  types.SessionFilter
+		session *types.Session
+		want    types.SchedulingDecisionReason
+	}{
+		{name: "any env", filter: types.SessionFilter{}, session: withEnv, want: types.SchedulingDecisionReasonAssigned},
+		{name: "no env asked for none", filter: types.SessionFilter{Env: types.SESSION_ENV_NONE}, session: plain, want: types.SchedulingDecisionReasonAssigned},
+		{name: "env asked for none", filter: types.SessionFilter{Env: types.SESSION_ENV_NONE}, session: withEnv, want: types.SchedulingDecisionReasonRejectedByFilter},
+		{name: "same env", filter: types.SessionFilter{Env: env.Fingerprint()}, session: withEnv, want: types.SchedulingDecisionReasonAssigned},
+		{name: "other env", filter: types.SessionFilter{Env: env.Fingerprint()}, session: otherEnv, want: types.SchedulingDecisionReasonRejectedByFilter},
+		{name: "no env asked for env", filter: types.SessionFilter{Env: env.Fingerprint()}, session: plain, want: types.SchedulingDecisionReasonRejectedByFilter},
+		{
+			// the running model process can't take it so the runner has to start another
+			name: "env session not rejected by process without env",
+			filter: types.SessionFilter{Reject: []types.SessionFilterModel{
+				{ModelName: modelName, Mode: types.SessionModeInference, LoraDir: types.LORA_DIR_NONE, Env: types.SESSION_ENV_NONE},
+			}},
+			session: withEnv,
+			want:    types.SchedulingDecisionReasonAssigned,
+		},
+		{
+			name: "env session rejected by process with the same env",
+			filter: types.SessionFilter{Reject: []types.SessionFilterModel{
+				{ModelName: modelName, Mode: types.SessionModeInference, LoraDir: types.LORA_DIR_NONE, Env: env.Fingerprint()},
+			}},
+			session: withEnv,
+			want:    types.SchedulingDecisionReasonRejectedByFilter,
+		},
+		{
+			name: "env session not rejected by process with another env",
+			filter: types.SessionFilter{Reject: []types.SessionFilterModel{
+				{ModelName: modelName, Mode: types.SessionModeInference, LoraDir: types.LORA_DIR_NONE, Env: env.Fingerprint()},
+			}},
+			session: otherEnv,
+			want:    types.SchedulingDecisionReasonAssigned,
+		},
+		{
+			name: "plain session not rejected by process with env",
+			filter: types.SessionFilter{Reject: []types.SessionFilterModel{
+				{ModelName: modelName, Mode: types.SessionModeInference, LoraDir: types.LORA_DIR_NONE, Env: env.Fingerprint()},
+			}},
+			session: plain,
+			want:    types.SchedulingDecisionReasonAssigned,
+		},
+		{
+			// e.g. ollama, which would run it with any env
+			name: "env session rejected by process that ignores env",
+			filter: types.SessionFilter{Reject: []types.SessionFilterModel{
+				{ModelName: modelName, Mode: types.SessionModeInference, LoraDir: types.LORA_DIR_NONE},
+			}},
+			session: withEnv,
+			want:    types.SchedulingDecisionReasonRejectedByFilter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, _ := c.checkSessionFilter(tt.filter, tt.session)
+			assert.Equal(t, tt.want, reason)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"sync"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// whether a result from a runner should be applied to the system
+// interaction - a result for an interaction that has already finished is
+// a duplicate (the runner retried the request) and so is anything from an
+// attempt we have retried or for an earlier interaction, runners that don't
+// number their responses get them all applied like before
+func acceptTaskResponse(interaction *types.Interaction, res *types.RunnerTaskResponse) bool {
+	if res.Sequence == 0 {
+		return true
+	}
+	if interaction.Finished {
+		return false
+	}
+	if res.InteractionID != "" && res.InteractionID != interaction.ID {
+		return false
+	}
+	for _, attempt := range interaction.RetriedAttempts {
+		if attempt == res.Attempt {
+			return false
+		}
+	}
+	return true
+}
+
+type streamPosition struct {
+	attempt  string
+	sequence uint64
+}
+
+// streamed chunks and progress updates go straight from the runner
+// websocket to the browser, we drop any that arrive after a later one of
+// the same attempt so the text isn't jumbled
+type streamOrder struct {
+	mtx      sync.Mutex
+	sessions map[string]streamPosition
+}
+
+func (o *streamOrder) accept(res *types.RunnerTaskResponse) bool {
+	if res.Sequence == 0 {
+		return true
+	}
+
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	last, ok := o.sessions[res.SessionID]
+	if ok && last.attempt == res.Attempt && res.Sequence <= last.sequence {
+		return false
+	}
+
+	if o.sessions == nil {
+		o.sessions = map[string]streamPosition{}
+	}
+	o.sessions[res.SessionID] = streamPosition{attempt: res.Attempt, sequence: res.Sequence}
+	return true
+}
+
+// the session's result is in so there is nothing more to stream
+func (o *streamOrder) forget(sessionID string) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	delete(o.sessions, sessionID)
+}
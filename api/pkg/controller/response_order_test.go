@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestAcceptTaskResponse(t *testing.T) {
+	interaction := &types.Interaction{ID: "interaction-1", RetriedAttempts: []string{"attempt-1"}}
+	result := func(attempt string, sequence uint64) *types.RunnerTaskResponse {
+		return &types.RunnerTaskResponse{
+			Type:          types.WorkerTaskResponseTypeResult,
+			InteractionID: "interaction-1",
+			Attempt:       attempt,
+			Sequence:      sequence,
+		}
+	}
+
+	assert.True(t, acceptTaskResponse(interaction, result("attempt-2", 5)))
+	// a late result from the attempt that was retried
+	assert.False(t, acceptTaskResponse(interaction, result("attempt-1", 3)))
+	// a result for an interaction the session has moved on from
+	assert.False(t, acceptTaskResponse(interaction, &types.RunnerTaskResponse{InteractionID: "interaction-0", Attempt: "attempt-0", Sequence: 9}))
+
+	interaction.Finished = true
+	// the same result sent again
+	assert.False(t, acceptTaskResponse(interaction, result("attempt-2", 5)))
+	// runners that don't number their responses get them all applied
+	assert.True(t, acceptTaskResponse(interaction, &types.RunnerTaskResponse{InteractionID: "interaction-1"}))
+}
+
+func TestStreamOrder(t *testing.T) {
+	order := streamOrder{}
+	chunk := func(attempt string, sequence uint64) *types.RunnerTaskResponse {
+		return &types.RunnerTaskResponse{
+			Type:      types.WorkerTaskResponseTypeStream,
+			SessionID: "session-1",
+			Attempt:   attempt,
+			Sequence:  sequence,
+		}
+	}
+
+	assert.True(t, order.accept(chunk("attempt-1", 1)))
+	assert.True(t, order.accept(chunk("attempt-1", 3)))
+	// overtaken by a later chunk
+	assert.False(t, order.accept(chunk("attempt-1", 2)))
+	// sent twice
+	assert.False(t, order.accept(chunk("attempt-1", 3)))
+	// a new attempt starts numbering again
+	assert.True(t, order.accept(chunk("attempt-2", 1)))
+	assert.True(t, order.accept(&types.RunnerTaskResponse{SessionID: "session-1"}))
+
+	order.forget("session-1")
+	assert.True(t, order.accept(chunk("attempt-2", 1)))
+}
@@ -0,0 +1,45 @@
+package controller
+
+import "sync"
+
+// serialises the read-modify-write of a session's interactions between
+// the runner's result and anything else that writes them in the background
+// (e.g. saving the partial output) so neither overwrites the other
+type sessionLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sessionLock
+}
+
+type sessionLock struct {
+	sync.Mutex
+	refs int
+}
+
+// the returned func unlocks the session, locks nobody holds or waits for
+// are thrown away
+func (l *sessionLocks) lock(sessionID string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*sessionLock{}
+	}
+	lock, ok := l.locks[sessionID]
+	if !ok {
+		lock = &sessionLock{}
+		l.locks[sessionID] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, sessionID)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	openai "github.com/lukemarsden/go-openai2"
+	"github.com/rs/zerolog/log"
+
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+const (
+	sessionNamingTimeout = 30 * time.Second
+	// we only need the gist of the conversation for a title
+	sessionNamingMaxMessageLength = 2000
+	sessionNameMaxLength          = 60
+)
+
+const sessionNamingPrompt = `You write short titles for conversations between a user and an AI assistant.
+Reply with only the title: at most six words, no quotes and no full stop at the end.`
+
+// the user hasn't named the session themselves and we haven't either
+func hasDefaultSessionName(session *types.Session) bool {
+	return session.Name == "" || system.IsAmusingName(session.Name)
+}
+
+// once the first question in a text session has been answered we ask the
+// naming model for a title in the background - this is best effort so if
+// anything goes wrong the session keeps the name it has
+func (c *Controller) maybeNameSession(session *types.Session) {
+	if c.Options.SessionNamingClient == nil {
+		return
+	}
+	if session.Mode != types.SessionModeInference || session.Type != types.SessionTypeText || !hasDefaultSessionName(session) {
+		return
+	}
+	userInteractions := data.FilterUserInteractions(session.Interactions)
+	if len(userInteractions) != 1 {
+		return
+	}
+	systemInteraction, err := data.GetSystemInteraction(session)
+	if err != nil || systemInteraction.Message == "" {
+		return
+	}
+
+	question := userInteractions[0].Message
+	answer := systemInteraction.Message
+	go func() {
+		err := c.nameSession(session.ID, question, answer)
+		if err != nil {
+			log.Warn().Err(err).Str("session_id", session.ID).Msg("failed to name session")
+		}
+	}()
+}
+
+func (c *Controller) nameSession(sessionID string, question string, answer string) error {
+	ctx := c.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, sessionNamingTimeout)
+	defer cancel()
+
+	resp, err := c.Options.SessionNamingClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: c.Options.SessionNamingModel,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: sessionNamingPrompt,
+			},
+			{
+				Role: openai.ChatMessageRoleUser,
+				Content: fmt.Sprintf("User: %s\n\nAssistant: %s",
+					truncateRunes(question, sessionNamingMaxMessageLength),
+					truncateRunes(answer, sessionNamingMaxMessageLength),
+				),
+			},
+		},
+		MaxTokens:   20,
+		Temperature: 0.2,
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.Choices) == 0 {
+		return fmt.Errorf("naming model returned no choices")
+	}
+
+	name := cleanSessionName(resp.Choices[0].Message.Content)
+	if name == "" {
+		return fmt.Errorf("naming model returned an empty title")
+	}
+
+	// the user might have renamed it while we were waiting
+	session, err := c.Options.Store.GetSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if !hasDefaultSessionName(session) {
+		return nil
+	}
+
+	session, err = c.Options.Store.UpdateSessionMeta(ctx, types.SessionMetaUpdate{
+		ID:   sessionID,
+		Name: name,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.UserWebsocketEventChanWriter <- &types.WebsocketEvent{
+		Type:      types.WebsocketEventSessionUpdate,
+		SessionID: session.ID,
+		Owner:     session.Owner,
+		Session:   session,
+	}
+	return nil
+}
+
+// models like to decorate their answers so we keep the first line without
+// any quotes, labels or trailing punctuation
+func cleanSessionName(name string) string {
+	name, _, _ = strings.Cut(strings.TrimSpace(name), "\n")
+	name = strings.TrimSpace(name)
+	if label, rest, found := strings.Cut(name, ":"); found && strings.EqualFold(strings.TrimSpace(label), "title") {
+		name = rest
+	}
+	name = strings.Trim(name, " \t\"'`*#")
+	name = strings.TrimRight(name, ".!")
+	return strings.TrimSpace(truncateRunes(name, sessionNameMaxLength))
+}
+
+func truncateRunes(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
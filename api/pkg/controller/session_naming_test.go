@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	openai "github.com/lukemarsden/go-openai2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+type fakeNamingClient struct {
+	title    string
+	requests []openai.ChatCompletionRequest
+}
+
+func (f *fakeNamingClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	f.requests = append(f.requests, request)
+	return openai.ChatCompletionResponse{
+		Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: f.title}}},
+	}, nil
+}
+
+func newNamingController(t *testing.T, title string) (*Controller, *store.MockStore, *fakeNamingClient) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	client := &fakeNamingClient{title: title}
+	return &Controller{
+		Options: ControllerOptions{
+			Store:               mockStore,
+			SessionNamingClient: client,
+			SessionNamingModel:  "small-model",
+		},
+		UserWebsocketEventChanWriter: make(chan *types.WebsocketEvent, 1),
+	}, mockStore, client
+}
+
+func TestNameSession(t *testing.T) {
+	c, mockStore, client := newNamingController(t, `Title: "Baking Sourdough Bread."`)
+
+	mockStore.EXPECT().GetSession(gomock.Any(), "session-1").Return(&types.Session{ID: "session-1", Name: "magical-chat-123"}, nil)
+	mockStore.EXPECT().UpdateSessionMeta(gomock.Any(), types.SessionMetaUpdate{ID: "session-1", Name: "Baking Sourdough Bread"}).
+		Return(&types.Session{ID: "session-1", Owner: "user-1", Name: "Baking Sourdough Bread"}, nil)
+
+	require.NoError(t, c.nameSession("session-1", "how do I make sourdough?", "first you need a starter..."))
+
+	require.Len(t, client.requests, 1)
+	assert.Equal(t, "small-model", client.requests[0].Model)
+	assert.Contains(t, client.requests[0].Messages[1].Content, "how do I make sourdough?")
+
+	event := <-c.UserWebsocketEventChanWriter
+	assert.Equal(t, types.WebsocketEventSessionUpdate, event.Type)
+	assert.Equal(t, "user-1", event.Owner)
+	assert.Equal(t, "Baking Sourdough Bread", event.Session.Name)
+}
+
+func TestNameSession_RenamedMeanwhile(t *testing.T) {
+	c, mockStore, _ := newNamingController(t, "Baking Sourdough Bread")
+
+	mockStore.EXPECT().GetSession(gomock.Any(), "session-1").Return(&types.Session{ID: "session-1", Name: "my bread"}, nil)
+
+	require.NoError(t, c.nameSession("session-1", "how do I make sourdough?", "first you need a starter..."))
+	assert.Empty(t, c.UserWebsocketEventChanWriter)
+}
+
+func TestNameSession_EmptyTitle(t *testing.T) {
+	c, _, _ := newNamingController(t, "  \"\"  ")
+	assert.Error(t, c.nameSession("session-1", "hi", "hello"))
+}
+
+func TestMaybeNameSession_Skips(t *testing.T) {
+	c, _, client := newNamingController(t, "title")
+
+	exchange := func(name string, questions int) *types.Session {
+		session := &types.Session{ID: "session-1", Name: name, Mode: types.SessionModeInference, Type: types.SessionTypeText}
+		for i := 0; i < questions; i++ {
+			session.Interactions = append(session.Interactions,
+				&types.Interaction{Creator: types.CreatorTypeUser, Message: "question"},
+				&types.Interaction{Creator: types.CreatorTypeSystem, Message: "answer"},
+			)
+		}
+		return session
+	}
+
+	// named by the user
+	c.maybeNameSession(exchange("my session", 1))
+	// not the first exchange
+	c.maybeNameSession(exchange("magical-chat-123", 2))
+	// nothing to name it from
+	c.maybeNameSession(exchange("magical-chat-123", 0))
+
+	assert.Empty(t, client.requests)
+}
+
+func TestCleanSessionName(t *testing.T) {
+	assert.Equal(t, "Baking Sourdough Bread", cleanSessionName("Baking Sourdough Bread"))
+	assert.Equal(t, "Baking Sourdough Bread", cleanSessionName("  **\"Baking Sourdough Bread.\"**\nHope that helps!"))
+	assert.Equal(t, "Baking Sourdough Bread", cleanSessionName("title: Baking Sourdough Bread"))
+	assert.Equal(t, "Ratio: Flour To Water", cleanSessionName("Ratio: Flour To Water"))
+	assert.Len(t, []rune(cleanSessionName(strings.Repeat("é", 100))), sessionNameMaxLength)
+}
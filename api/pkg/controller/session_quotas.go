@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// the owner has as many sessions running or queued as their quota allows
+type SessionQuotaError struct {
+	Usage types.SessionQuotaUsage
+}
+
+func (e *SessionQuotaError) Error() string {
+	return fmt.Sprintf("session quota exceeded, %d of %d sessions are already running or queued", e.Usage.Active, e.Usage.Limit)
+}
+
+type sessionQuotaOwner struct {
+	OwnerType types.OwnerType
+	Owner     string
+}
+
+// the sessions that count against their owner's quota from when they are
+// created until their interaction completes, errors or is cancelled - this
+// is only kept in memory like the queue and is seeded from the queue when
+// that is loaded on a restart
+type sessionQuotaTracker struct {
+	mtx      sync.Mutex
+	sessions map[string]sessionQuotaOwner
+}
+
+func (t *sessionQuotaTracker) countLocked(owner sessionQuotaOwner) int {
+	count := 0
+	for _, sessionOwner := range t.sessions {
+		if sessionOwner == owner {
+			count++
+		}
+	}
+	return count
+}
+
+func (t *sessionQuotaTracker) count(owner sessionQuotaOwner) int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.countLocked(owner)
+}
+
+// count the session against its owner unless that takes them over limit,
+// zero means no limit - the usage is what it was before the session
+func (t *sessionQuotaTracker) acquire(sessionID string, owner sessionQuotaOwner, limit int) (types.SessionQuotaUsage, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	usage := types.SessionQuotaUsage{
+		OwnerType: owner.OwnerType,
+		Owner:     owner.Owner,
+		Active:    t.countLocked(owner),
+		Limit:     limit,
+	}
+
+	// a session that is already counted (e.g. one being continued while
+	// it's still busy) doesn't use any more of the quota
+	if _, ok := t.sessions[sessionID]; !ok && limit > 0 && usage.Active >= limit {
+		return usage, false
+	}
+
+	if t.sessions == nil {
+		t.sessions = map[string]sessionQuotaOwner{}
+	}
+	t.sessions[sessionID] = owner
+	return usage, true
+}
+
+func (t *sessionQuotaTracker) release(sessionID string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	delete(t.sessions, sessionID)
+}
+
+// the most sessions the owner can have running or queued at once, zero
+// means no limit - the owner's own limit overrides the one for their owner
+// type and admins are never limited
+func (c *Controller) getSessionQuotaLimit(ctx types.RequestContext, ownerType types.OwnerType, owner string) (int, error) {
+	if ctx.Admin || c.Options.SessionQuotas == nil {
+		return 0, nil
+	}
+
+	status, err := c.GetStatus(types.RequestContext{Ctx: ctx.Ctx, Owner: owner, OwnerType: ownerType})
+	if err != nil {
+		return 0, err
+	}
+	if status.Config.MaxConcurrentSessions > 0 {
+		return status.Config.MaxConcurrentSessions, nil
+	}
+
+	return c.Options.SessionQuotas[ownerType], nil
+}
+
+// GetSessionQuotaUsage is how many sessions the owner of the request has
+// running or queued and how many they are allowed
+func (c *Controller) GetSessionQuotaUsage(ctx types.RequestContext) (*types.SessionQuotaUsage, error) {
+	limit, err := c.getSessionQuotaLimit(ctx, ctx.OwnerType, ctx.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := sessionQuotaOwner{OwnerType: ctx.OwnerType, Owner: ctx.Owner}
+	return &types.SessionQuotaUsage{
+		OwnerType: ctx.OwnerType,
+		Owner:     ctx.Owner,
+		Active:    c.sessionQuotas.count(owner),
+		Limit:     limit,
+	}, nil
+}
+
+// count a session that is about to start against its owner's quota,
+// returns a *SessionQuotaError if they have no room for it
+func (c *Controller) acquireSessionQuota(ctx types.RequestContext, session *types.Session) error {
+	limit, err := c.getSessionQuotaLimit(ctx, session.OwnerType, session.Owner)
+	if err != nil {
+		return fmt.Errorf("failed to get session quota: %w", err)
+	}
+
+	usage, ok := c.sessionQuotas.acquire(session.ID, sessionQuotaOwner{OwnerType: session.OwnerType, Owner: session.Owner}, limit)
+	if !ok {
+		return &SessionQuotaError{Usage: usage}
+	}
+	return nil
+}
+
+// the session's interaction has finished one way or another so it no longer
+// counts against the quota
+func (c *Controller) releaseSessionQuota(sessionID string) {
+	c.sessionQuotas.release(sessionID)
+}
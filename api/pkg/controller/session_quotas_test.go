@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newSessionQuotaTestController(t *testing.T, userConfigs map[string]types.UserConfig) *Controller {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	mockStore.EXPECT().GetUserMeta(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, id string) (*types.UserMeta, error) {
+		return &types.UserMeta{ID: id, Config: userConfigs[id]}, nil
+	}).AnyTimes()
+
+	return &Controller{Options: ControllerOptions{
+		Store:         mockStore,
+		SessionQuotas: map[types.OwnerType]int{types.OwnerTypeUser: 2},
+	}}
+}
+
+func quotaSession(id, owner string) *types.Session {
+	return &types.Session{ID: id, Owner: owner, OwnerType: types.OwnerTypeUser}
+}
+
+func TestSessionQuota(t *testing.T) {
+	c := newSessionQuotaTestController(t, nil)
+	userContext := types.RequestContext{Ctx: context.Background(), Owner: "user-1", OwnerType: types.OwnerTypeUser}
+
+	require.NoError(t, c.acquireSessionQuota(userContext, quotaSession("session-1", "user-1")))
+	require.NoError(t, c.acquireSessionQuota(userContext, quotaSession("session-2", "user-1")))
+	// continuing a session that is still counted doesn't need more room
+	require.NoError(t, c.acquireSessionQuota(userContext, quotaSession("session-2", "user-1")))
+
+	err := c.acquireSessionQuota(userContext, quotaSession("session-3", "user-1"))
+	var quotaErr *SessionQuotaError
+	require.True(t, errors.As(err, &quotaErr))
+	assert.Equal(t, 2, quotaErr.Usage.Active)
+	assert.Equal(t, 2, quotaErr.Usage.Limit)
+
+	// other owners have their own quota
+	otherContext := types.RequestContext{Ctx: context.Background(), Owner: "user-2", OwnerType: types.OwnerTypeUser}
+	require.NoError(t, c.acquireSessionQuota(otherContext, quotaSession("session-4", "user-2")))
+
+	c.releaseSessionQuota("session-1")
+	require.NoError(t, c.acquireSessionQuota(userContext, quotaSession("session-3", "user-1")))
+
+	usage, err := c.GetSessionQuotaUsage(userContext)
+	require.NoError(t, err)
+	assert.Equal(t, &types.SessionQuotaUsage{OwnerType: types.OwnerTypeUser, Owner: "user-1", Active: 2, Limit: 2}, usage)
+}
+
+func TestSessionQuota_OwnerOverrideAndAdmin(t *testing.T) {
+	c := newSessionQuotaTestController(t, map[string]types.UserConfig{
+		"big-user": {MaxConcurrentSessions: 3},
+	})
+
+	userContext := types.RequestContext{Ctx: context.Background(), Owner: "big-user", OwnerType: types.OwnerTypeUser}
+	for _, id := range []string{"session-1", "session-2", "session-3"} {
+		require.NoError(t, c.acquireSessionQuota(userContext, quotaSession(id, "big-user")))
+	}
+	assert.Error(t, c.acquireSessionQuota(userContext, quotaSession("session-4", "big-user")))
+
+	adminContext := types.RequestContext{Ctx: context.Background(), Owner: "big-user", OwnerType: types.OwnerTypeUser, Admin: true}
+	require.NoError(t, c.acquireSessionQuota(adminContext, quotaSession("session-4", "big-user")))
+
+	usage, err := c.GetSessionQuotaUsage(adminContext)
+	require.NoError(t, err)
+	assert.Equal(t, 4, usage.Active)
+	assert.Equal(t, 0, usage.Limit)
+}
+
+func TestSessionQuota_Off(t *testing.T) {
+	// without quotas configured we never look the owner up
+	c := &Controller{}
+	userContext := types.RequestContext{Ctx: context.Background(), Owner: "user-1", OwnerType: types.OwnerTypeUser}
+	for _, id := range []string{"session-1", "session-2", "session-3"} {
+		require.NoError(t, c.acquireSessionQuota(userContext, quotaSession(id, "user-1")))
+	}
+}
+
+func TestSessionQuota_ReleasedOnDelete(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	mockStore.EXPECT().DeleteSession(gomock.Any(), "session-1").Return(quotaSession("session-1", "user-1"), nil)
+
+	c := &Controller{Options: ControllerOptions{Store: mockStore}, activeRunners: xsync.NewMapOf[string, *types.RunnerState]()}
+	userContext := types.RequestContext{Ctx: context.Background(), Owner: "user-1", OwnerType: types.OwnerTypeUser}
+	require.NoError(t, c.acquireSessionQuota(userContext, quotaSession("session-1", "user-1")))
+	c.sessionQueue = []*types.Session{quotaSession("session-1", "user-1")}
+	c.sessionSummaryQueue = []*types.SessionSummary{{SessionID: "session-1"}}
+
+	_, err := c.DeleteSession(context.Background(), "session-1")
+	require.NoError(t, err)
+
+	usage, err := c.GetSessionQuotaUsage(userContext)
+	require.NoError(t, err)
+	assert.Equal(t, 0, usage.Active)
+	assert.Empty(t, c.sessionQueue)
+}
+
+func TestSessionQuota_SeededFromRestoredQueue(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	queuedSession := func(id string) *types.Session {
+		session := quotaSession(id, "user-1")
+		session.Mode = types.SessionModeInference
+		session.Interactions = []*types.Interaction{
+			{ID: id + "-system", Creator: types.CreatorTypeSystem},
+			{ID: id + "-user", Creator: types.CreatorTypeUser},
+		}
+		return session
+	}
+	mockStore.EXPECT().GetSessions(gomock.Any(), gomock.Any()).Return([]*types.Session{
+		queuedSession("session-1"),
+		queuedSession("session-2"),
+		queuedSession("session-3"),
+	}, nil)
+
+	c := &Controller{Options: ControllerOptions{Store: mockStore}, activeRunners: xsync.NewMapOf[string, *types.RunnerState]()}
+	require.NoError(t, c.loadSessionQueues(context.Background()))
+
+	usage, err := c.GetSessionQuotaUsage(types.RequestContext{Ctx: context.Background(), Owner: "user-1", OwnerType: types.OwnerTypeUser})
+	require.NoError(t, err)
+	assert.Equal(t, 3, usage.Active)
+}
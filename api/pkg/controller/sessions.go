@@ -5,9 +5,11 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,6 +17,7 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/dataprep/qapairs"
 	"github.com/helixml/helix/api/pkg/notification"
 	"github.com/helixml/helix/api/pkg/store"
 	"github.com/helixml/helix/api/pkg/system"
@@ -25,6 +28,11 @@ import (
 const DEBUG = true
 
 func (c *Controller) CreateSession(ctx types.RequestContext, req types.CreateSessionRequest) (*types.Session, error) {
+	requestID := system.GetRequestID(ctx.Ctx)
+	for _, userInteraction := range req.UserInteractions {
+		userInteraction.RequestID = requestID
+	}
+
 	systemInteraction := &types.Interaction{
 		ID:             system.GenerateUUID(),
 		Created:        time.Now(),
@@ -59,13 +67,28 @@ func (c *Controller) CreateSession(ctx types.RequestContext, req types.CreateSes
 			},
 			Priority:                req.Priority,
 			ManuallyReviewQuestions: req.ManuallyReviewQuestions,
+			Sensitive:               req.Sensitive,
+			SpanDocuments:           req.SpanDocuments,
+			DataPrepChunkSize:       req.DataPrepChunkSize,
+			DataPrepConcurrency:     req.DataPrepConcurrency,
 			HelixVersion:            data.GetHelixVersion(),
+			Sampling:                req.Sampling,
+			MaxFileSize:             req.MaxFileSize,
+			Env:                     req.Env,
+			EvalRunId:               req.EvalRunID,
+			EvalOriginalUserPrompts: req.EvalOriginalUserPrompts,
 		},
 	}
 
+	err := c.acquireSessionQuota(ctx, &newSession)
+	if err != nil {
+		return nil, err
+	}
+
 	// create session in database
 	sessionData, err := c.Options.Store.CreateSession(ctx.Ctx, newSession)
 	if err != nil {
+		c.releaseSessionQuota(newSession.ID)
 		return nil, err
 	}
 
@@ -95,6 +118,8 @@ func (c *Controller) UpdateSession(ctx types.RequestContext, req types.UpdateSes
 		return nil, fmt.Errorf("failed to get session %s: %w", req.SessionID, err)
 	}
 
+	req.UserInteraction.RequestID = system.GetRequestID(ctx.Ctx)
+
 	systemInteraction := &types.Interaction{
 		ID:       system.GenerateUUID(),
 		Created:  time.Now(),
@@ -113,8 +138,14 @@ func (c *Controller) UpdateSession(ctx types.RequestContext, req types.UpdateSes
 
 	log.Debug().Msgf("🟢 update session: %+v", session)
 
+	err = c.acquireSessionQuota(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+
 	sessionData, err := c.Options.Store.UpdateSession(ctx.Ctx, *session)
 	if err != nil {
+		c.releaseSessionQuota(session.ID)
 		return nil, err
 	}
 
@@ -128,21 +159,28 @@ func (c *Controller) UpdateSession(ctx types.RequestContext, req types.UpdateSes
 	return sessionData, nil
 }
 
-func (c *Controller) RestartSession(session *types.Session) (*types.Session, error) {
-	// let's see if this session is currently active as far as runners are aware
-	activeSessions := map[string]bool{}
+// the session is being worked on by a model instance as far as the runners
+// are aware
+func (c *Controller) isSessionActive(sessionID string) bool {
+	active := false
 	c.activeRunners.Range(func(i string, metrics *types.RunnerState) bool {
 		for _, modelInstance := range metrics.ModelInstances {
-			if modelInstance.CurrentSession == nil {
-				continue
+			for _, summary := range modelInstance.ActiveSessions {
+				if summary.SessionID == sessionID {
+					active = true
+				}
+			}
+			if modelInstance.CurrentSession != nil && modelInstance.CurrentSession.SessionID == sessionID {
+				active = true
 			}
-			activeSessions[modelInstance.CurrentSession.SessionID] = true
 		}
-		return true
+		return !active
 	})
+	return active
+}
 
-	_, ok := activeSessions[session.ID]
-	if ok {
+func (c *Controller) RestartSession(session *types.Session) (*types.Session, error) {
+	if c.isSessionActive(session.ID) {
 		return nil, fmt.Errorf("session is currently active")
 	}
 
@@ -191,6 +229,76 @@ func (c *Controller) RestartSession(session *types.Session) (*types.Session, err
 	return session, nil
 }
 
+// the session has an interaction that is queued or being answered so it
+// can't be edited until that is done
+var ErrSessionBusy = errors.New("session is still generating a response")
+
+// change an earlier user message, throw away everything after it and ask the
+// model to answer again - the runner builds the prompt from the edited
+// interactions. The conversation as it was can be kept in a new session
+// that points back at this one.
+func (c *Controller) EditInteraction(ctx types.RequestContext, session *types.Session, interactionID string, req types.EditInteractionRequest) (*types.Session, error) {
+	unlock := c.sessionLocks.lock(session.ID)
+	defer unlock()
+
+	// a runner's result could have been written since we were handed it
+	session, err := c.Options.Store.GetSession(ctx.Ctx, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	lastInteraction, err := data.GetLastSystemInteraction(session.Interactions)
+	if err == nil && lastInteraction.State == types.InteractionStateWaiting {
+		return nil, ErrSessionBusy
+	}
+	if c.isSessionActive(session.ID) {
+		return nil, ErrSessionBusy
+	}
+
+	editedSession, err := data.EditUserInteraction(session, interactionID, req.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	userInteraction, err := data.GetInteraction(editedSession, interactionID)
+	if err != nil {
+		return nil, err
+	}
+	userInteraction.RequestID = system.GetRequestID(ctx.Ctx)
+
+	if req.KeepHistory && len(session.Interactions) > 0 {
+		// the files are not copied, the filestore is immutable so the copy
+		// can keep pointing at the files of this session
+		history, err := data.CloneSession(*session, session.Interactions[len(session.Interactions)-1].ID, types.OwnerContext{
+			Owner:     session.Owner,
+			OwnerType: session.OwnerType,
+		})
+		if err != nil {
+			return nil, err
+		}
+		history.Name = session.Name
+
+		_, err = c.Options.Store.CreateSession(ctx.Ctx, *history)
+		if err != nil {
+			return nil, fmt.Errorf("failed to keep the history of session %s: %w", session.ID, err)
+		}
+	}
+
+	sessionData, err := c.Options.Store.UpdateSession(ctx.Ctx, *editedSession)
+	if err != nil {
+		return nil, err
+	}
+
+	go c.SessionRunner(sessionData)
+
+	err = c.Options.Janitor.WriteSessionEvent(types.SessionEventTypeUpdated, ctx, sessionData)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionData, nil
+}
+
 func (c *Controller) AddDocumentsToSession(ctx context.Context, session *types.Session, userInteraction *types.Interaction) (*types.Session, error) {
 	// the system interaction is the task we will run on a GPU and update in place
 	systemInteraction := &types.Interaction{
@@ -234,9 +342,15 @@ func (c *Controller) UpdateSessionMetadata(ctx context.Context, session *types.S
 	return &sessionData.Metadata, nil
 }
 
-func (c *Controller) AddDocumentsToInteraction(ctx context.Context, session *types.Session, newFiles []string) (*types.Session, error) {
+func (c *Controller) AddDocumentsToInteraction(ctx context.Context, session *types.Session, newFiles []string, fileSizes map[string]int64) (*types.Session, error) {
 	session, err := data.UpdateUserInteraction(session, func(userInteraction *types.Interaction) (*types.Interaction, error) {
 		userInteraction.Files = append(userInteraction.Files, newFiles...)
+		if len(fileSizes) > 0 && userInteraction.FileSizes == nil {
+			userInteraction.FileSizes = map[string]int64{}
+		}
+		for path, size := range fileSizes {
+			userInteraction.FileSizes[path] = size
+		}
 		return userInteraction, nil
 	})
 	if err != nil {
@@ -267,6 +381,11 @@ func (c *Controller) SessionRunner(sessionData *types.Session) {
 	// e.g. in the case of text fine tuning data prep - we need an LLM to convert
 	// text into q&a pairs and we want to use our own mistral inference
 	preparedSession, err := c.PrepareSession(sessionData)
+	// whoever cancelled it has already updated the session
+	if errors.Is(err, ErrDataPrepCancelled) {
+		log.Info().Msgf("data prep cancelled: %s", sessionData.ID)
+		return
+	}
 	if err != nil {
 		log.Error().Msgf("error preparing session: %s", err.Error())
 		c.ErrorSession(sessionData, err)
@@ -326,11 +445,21 @@ func (c *Controller) PrepareSession(session *types.Session) (*types.Session, err
 	// here we need to turn all of the uploaded files into text files
 	// so we ping our handy python server that will do that for us
 	if session.Type == types.SessionTypeText && session.Mode == types.SessionModeFinetune {
+		// CancelDataPrep stops everything from here on
+		ctx, finish := c.startDataPrepRun(session)
+		defer finish()
+
 		session, convertedTextDocuments, err := c.convertDocumentsToText(session)
 		if err != nil {
 			return nil, err
 		}
-		session, questionChunksGenerated, err := c.convertChunksToQuestions(session)
+		if ctx.Err() != nil {
+			return nil, ErrDataPrepCancelled
+		}
+		session, questionChunksGenerated, err := c.convertChunksToQuestions(ctx, session)
+		if ctx.Err() != nil {
+			return nil, ErrDataPrepCancelled
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -356,7 +485,11 @@ func (c *Controller) PrepareSession(session *types.Session) (*types.Session, err
 			return nil, nil
 		}
 
-		// otherwise lets kick off the fine tune
+		// otherwise lets kick off the fine tune - unless it was cancelled
+		// just as the questions were finished
+		if ctx.Err() != nil {
+			return nil, ErrDataPrepCancelled
+		}
 		c.BeginFineTune(session)
 		return nil, nil
 	}
@@ -372,10 +505,7 @@ func (c *Controller) checkForActions(session *types.Session) (*types.Session, er
 
 	ctx := context.Background()
 
-	tools, err := c.Options.Store.ListTools(ctx, &store.ListToolsQuery{
-		Owner:     session.Owner,
-		OwnerType: session.OwnerType,
-	})
+	tools, err := c.getSessionTools(ctx, session)
 	if err != nil {
 		return nil, err
 	}
@@ -387,7 +517,8 @@ func (c *Controller) checkForActions(session *types.Session) (*types.Session, er
 
 	userInteraction, err := data.GetLastUserInteraction(session.Interactions)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get last user interaction: %w", err)
+		// a session started with only a system prompt has nothing to act on
+		return session, nil
 	}
 
 	history := data.GetLastInteractions(session, actionContextHistorySize)
@@ -436,6 +567,24 @@ func (c *Controller) checkForActions(session *types.Session) (*types.Session, er
 	return session, nil
 }
 
+// if tools have been bound to the session then we only use those
+// otherwise every tool the owner has is available
+func (c *Controller) getSessionTools(ctx context.Context, session *types.Session) ([]*types.Tool, error) {
+	tools, err := c.Options.Store.ListSessionTools(ctx, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tools) > 0 {
+		return tools, nil
+	}
+
+	return c.Options.Store.ListTools(ctx, &store.ListToolsQuery{
+		Owner:     session.Owner,
+		OwnerType: session.OwnerType,
+	})
+}
+
 func (c *Controller) BeginFineTune(session *types.Session) error {
 	session, err := data.UpdateSystemInteraction(session, func(systemInteraction *types.Interaction) (*types.Interaction, error) {
 		systemInteraction.Finished = false
@@ -468,7 +617,7 @@ func (c *Controller) WriteSession(session *types.Session) {
 
 	_, err := c.Options.Store.UpdateSession(context.Background(), *session)
 	if err != nil {
-		log.Printf("Error adding message: %s", err)
+		log.Error().Err(err).Str("session_id", session.ID).Msg("failed to update session")
 	}
 
 	event := &types.WebsocketEvent{
@@ -520,7 +669,77 @@ func (c *Controller) BroadcastProgress(
 	c.UserWebsocketEventChanWriter <- ev
 }
 
+// tell the UI about one data prep chunk as soon as it is done so it can turn
+// green (or red with the error) without waiting for the whole run
+func (c *Controller) BroadcastDataPrepChunk(
+	session *types.Session,
+	interaction *types.Interaction,
+	filename string,
+	chunk types.DataPrepChunk,
+) {
+	ev := &types.WebsocketEvent{
+		Type:      types.WebsocketEventDataPrepChunk,
+		SessionID: session.ID,
+		Owner:     session.Owner,
+		DataPrepChunk: &types.DataPrepChunkUpdate{
+			InteractionID: interaction.ID,
+			Filename:      filename,
+			Stage:         interaction.DataPrepStage,
+			Chunk:         chunk,
+			Progress:      interaction.Progress,
+			Status:        interaction.Status,
+		},
+	}
+	c.UserWebsocketEventChanWriter <- ev
+}
+
+// streamed text from a runner goes to the browser as just the new text
+// rather than the whole task response, the complete interaction follows in
+// the last delta once the result is in so the runner's done chunk is dropped
+// everything else is passed on as it is
+func streamDeltaEvent(event *types.WebsocketEvent) (*types.WebsocketEvent, bool) {
+	res := event.WorkerTaskResponse
+	if event.Type != types.WebsocketEventWorkerTaskResponse || res == nil || res.Type != types.WorkerTaskResponseTypeStream {
+		return event, true
+	}
+	if res.Message == "" {
+		return nil, false
+	}
+	return &types.WebsocketEvent{
+		Type:      types.WebsocketEventStreamDelta,
+		SessionID: event.SessionID,
+		Owner:     event.Owner,
+		StreamDelta: &types.StreamDelta{
+			InteractionID: res.InteractionID,
+			Delta:         res.Message,
+		},
+	}, true
+}
+
+// the last stream delta of an interaction with all of it in
+func (c *Controller) BroadcastStreamDone(session *types.Session, interaction *types.Interaction) {
+	ev := &types.WebsocketEvent{
+		Type:      types.WebsocketEventStreamDelta,
+		SessionID: session.ID,
+		Owner:     session.Owner,
+		StreamDelta: &types.StreamDelta{
+			InteractionID: interaction.ID,
+			Done:          true,
+			Interaction:   interaction,
+		},
+	}
+	c.UserWebsocketEventChanWriter <- ev
+}
+
 func (c *Controller) ErrorSession(session *types.Session, sessionErr error) {
+	unlock := c.sessionLocks.lock(session.ID)
+	defer unlock()
+
+	c.errorSession(session, sessionErr)
+}
+
+// the session lock must be held
+func (c *Controller) errorSession(session *types.Session, sessionErr error) {
 	session, err := data.UpdateUserInteraction(session, func(userInteraction *types.Interaction) (*types.Interaction, error) {
 		userInteraction.Finished = true
 		userInteraction.State = types.InteractionStateComplete
@@ -540,15 +759,140 @@ func (c *Controller) ErrorSession(session *types.Session, sessionErr error) {
 		return
 	}
 	c.WriteSession(session)
+	c.partialResults.forget(session.ID)
+	c.streamOrder.forget(session.ID)
+	c.releaseSessionQuota(session.ID)
+	c.recordSessionOutcome(session, types.InteractionStateError)
+	c.notifySessionWebhook(session, types.InteractionStateError, sessionErr.Error())
 	c.Options.Janitor.WriteSessionError(session, sessionErr)
 }
 
-// add the given session onto the end of the queue
-// unless it's already waiting and present in the queue
-// in which case let's replace it at it's current position
+// the error a system interaction is left with when the client that started
+// it went away before it finished
+var ErrSessionCancelled = errors.New("session cancelled by the client")
+
+func isCancelledInteraction(interaction *types.Interaction) bool {
+	return interaction.State == types.InteractionStateError && interaction.Error == ErrSessionCancelled.Error()
+}
+
+// stop working on the current interaction of a session because nobody is
+// waiting for the answer any more - if it's still queued it never reaches a
+// runner, a runner that has already picked it up is told to stop the next
+// time it reports its state and whatever it sends back is dropped
+func (c *Controller) CancelSession(ctx context.Context, sessionID string) error {
+	_, _, err := c.cancelSession(ctx, sessionID)
+	return err
+}
+
+// cancelled is false if the interaction finished before we got to it,
+// queued is whether it was still waiting for a runner
+func (c *Controller) cancelSession(ctx context.Context, sessionID string) (bool, bool, error) {
+	queued := c.removeSessionFromQueue(sessionID)
+
+	unlock := c.sessionLocks.lock(sessionID)
+	defer unlock()
+
+	session, err := c.Options.Store.GetSession(ctx, sessionID)
+	if err != nil {
+		return false, queued, err
+	}
+	if session == nil {
+		return false, queued, fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	systemInteraction, err := data.GetSystemInteraction(session)
+	if err != nil {
+		return false, queued, err
+	}
+
+	// it finished before we got here
+	if systemInteraction.Finished {
+		return false, queued, nil
+	}
+
+	session, err = data.UpdateSystemInteraction(session, func(systemInteraction *types.Interaction) (*types.Interaction, error) {
+		systemInteraction.State = types.InteractionStateError
+		systemInteraction.Completed = time.Now()
+		systemInteraction.Error = ErrSessionCancelled.Error()
+		systemInteraction.Finished = true
+		return systemInteraction, nil
+	})
+	if err != nil {
+		return false, queued, err
+	}
+
+	log.Info().
+		Str("session_id", sessionID).
+		Bool("queued", queued).
+		Msg("session cancelled")
+
+	if !queued {
+		c.stoppedSessions.add(sessionID)
+	}
+	c.WriteSession(session)
+	c.partialResults.forget(session.ID)
+	c.streamOrder.forget(session.ID)
+	c.releaseSessionQuota(session.ID)
+
+	return true, queued, nil
+}
+
+// put a session a runner was handed back on the queue so another runner
+// (or the same one once it's back) can pick it up - returns false if the
+// session has finished or moved on to another interaction since then
+func (c *Controller) ReleaseSession(ctx context.Context, sessionID, interactionID string) (bool, error) {
+	session, err := c.Options.Store.GetSession(ctx, sessionID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if session == nil {
+		return false, nil
+	}
+
+	systemInteraction, err := data.GetSystemInteraction(session)
+	if err != nil {
+		return false, err
+	}
+
+	if systemInteraction.Finished || (interactionID != "" && systemInteraction.ID != interactionID) {
+		return false, nil
+	}
+
+	log.Info().
+		Str("session_id", sessionID).
+		Str("interaction_id", systemInteraction.ID).
+		Msg("runner released session, requeuing")
+
+	// the session was let in when it was first queued
+	c.requeueSession(session)
+
+	return true, nil
+}
+
+// add the given session into the queue based on it's effective queue time
+// (see getEffectiveQueueTime) unless it's already waiting and present in the
+// queue in which case let's replace it at it's current position
 // we mark the session as "preparing" here to give text fine tuning
 // a chance to sort itself out in the background
 func (c *Controller) AddSessionToQueue(session *types.Session) {
+	err := c.checkRunnerCapacity(session)
+	if err != nil {
+		log.Warn().Msgf("not queuing session %s: %s", session.ID, err.Error())
+		c.ErrorSession(session, err)
+		return
+	}
+
+	c.requeueSession(session)
+}
+
+// like AddSessionToQueue for a session that has already been let in (a
+// retry or a session a runner handed back) so there's no need to check the
+// runners could run it again - this doesn't take the session lock so it can
+// be used while holding it
+func (c *Controller) requeueSession(session *types.Session) {
 	sessionSummary, err := data.GetSessionSummary(session)
 	if err != nil {
 		log.Error().Msgf("error getting session summary: %s", err.Error())
@@ -556,16 +900,16 @@ func (c *Controller) AddSessionToQueue(session *types.Session) {
 	}
 
 	c.sessionQueueMtx.Lock()
-	defer c.sessionQueueMtx.Unlock()
 
 	existing := false
 	newQueue := []*types.Session{}
 	newSummaryQueue := []*types.SessionSummary{}
 
-	// what is the latest priority session in the queue?
-	// if we are a priority session then we will get put after that one
-	// if there are no priority sessions in the queue and we are - then we go first
-	lastPriorityIndex := -1
+	// the queue is kept sorted by effective queue time so we go in just
+	// before the first session that should run after us - sessions with the
+	// same effective queue time stay in the order they arrived
+	queueTime := c.getEffectiveQueueTime(session)
+	insertIndex := len(c.sessionQueue)
 	for i, existingSession := range c.sessionQueue {
 		if existingSession.ID == session.ID {
 			// the session we are updating is already in the queue!
@@ -578,40 +922,71 @@ func (c *Controller) AddSessionToQueue(session *types.Session) {
 			newQueue = append(newQueue, c.sessionQueue[i])
 			newSummaryQueue = append(newSummaryQueue, c.sessionSummaryQueue[i])
 		}
-		if existingSession.Metadata.Priority {
-			lastPriorityIndex = i
+		if insertIndex == len(c.sessionQueue) && c.getEffectiveQueueTime(existingSession).After(queueTime) {
+			insertIndex = i
 		}
 	}
 	if !existing {
-		if session.Metadata.Priority {
-			if lastPriorityIndex == -1 {
-				// prepend the session to the start of the queue
-				newQueue = append([]*types.Session{session}, newQueue...)
-				newSummaryQueue = append([]*types.SessionSummary{sessionSummary}, newSummaryQueue...)
-			} else {
-				// insert the session into newQueue just after the lastPriorityIndex
-				newQueue = append(newQueue[:lastPriorityIndex+1], append([]*types.Session{session}, newQueue[lastPriorityIndex+1:]...)...)
-				newSummaryQueue = append(newSummaryQueue[:lastPriorityIndex+1], append([]*types.SessionSummary{sessionSummary}, newSummaryQueue[lastPriorityIndex+1:]...)...)
-			}
-		} else {
-			// we did not find the session already in the queue
-			newQueue = append(newQueue, session)
-			newSummaryQueue = append(newSummaryQueue, sessionSummary)
-		}
+		newQueue = append(newQueue[:insertIndex], append([]*types.Session{session}, newQueue[insertIndex:]...)...)
+		newSummaryQueue = append(newSummaryQueue[:insertIndex], append([]*types.SessionSummary{sessionSummary}, newSummaryQueue[insertIndex:]...)...)
 	}
 
 	c.sessionQueue = newQueue
 	c.sessionSummaryQueue = newSummaryQueue
+	events := c.updateQueuePositions()
+	c.sessionQueueMtx.Unlock()
+
+	c.broadcastQueuePositions(events)
 }
 
 func (c *Controller) HandleRunnerResponse(ctx context.Context, taskResponse *types.RunnerTaskResponse) (*types.RunnerTaskResponse, error) {
+	unlock := c.sessionLocks.lock(taskResponse.SessionID)
+	defer unlock()
+
+	// whatever happens to the result the streamed output is no longer partial
+	if taskResponse.Type == types.WorkerTaskResponseTypeResult {
+		c.partialResults.forget(taskResponse.SessionID)
+	}
+
 	session, err := c.Options.Store.GetSession(ctx, taskResponse.SessionID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && session == nil) {
+		// deleted while it was running
+		c.releaseSessionQuota(taskResponse.SessionID)
+		return nil, fmt.Errorf("session not found: %s", taskResponse.SessionID)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if session == nil {
-		return nil, fmt.Errorf("session not found: %s", taskResponse.SessionID)
+	// the client has gone so don't let a late response bring it back to life
+	systemInteraction, err := data.GetSystemInteraction(session)
+	if err == nil && isCancelledInteraction(systemInteraction) {
+		log.Ctx(ctx).Debug().
+			Str("session_id", session.ID).
+			Msg("dropping response for cancelled session")
+		return taskResponse, nil
+	}
+
+	// the runner's http client retries so we can see the same result twice
+	if err == nil && !acceptTaskResponse(systemInteraction, taskResponse) {
+		log.Ctx(ctx).Debug().
+			Str("session_id", session.ID).
+			Str("attempt", taskResponse.Attempt).
+			Uint64("sequence", taskResponse.Sequence).
+			Msg("dropping duplicate runner response")
+		return taskResponse, nil
+	}
+
+	// errors can be transient (e.g. the GPU ran out of memory) so we give
+	// the interaction another go on the queue before failing it
+	if taskResponse.Type == types.WorkerTaskResponseTypeResult && taskResponse.Error != "" {
+		retried, err := c.retryInteraction(ctx, session, taskResponse)
+		if err != nil {
+			return nil, err
+		}
+		if retried {
+			return taskResponse, nil
+		}
 	}
 
 	session, err = data.UpdateSystemInteraction(session, func(targetInteraction *types.Interaction) (*types.Interaction, error) {
@@ -646,6 +1021,33 @@ func (c *Controller) HandleRunnerResponse(ctx context.Context, taskResponse *typ
 
 		if taskResponse.Error != "" {
 			targetInteraction.Error = taskResponse.Error
+			targetInteraction.ErrorDetail = taskResponse.ErrorDetail
+			targetInteraction.ErrorCode = taskResponse.ErrorCode
+		}
+
+		if taskResponse.LogFile != "" {
+			targetInteraction.LogFile = taskResponse.LogFile
+		}
+
+		if taskResponse.Type == types.WorkerTaskResponseTypeResult && taskResponse.Usage != nil {
+			targetInteraction.Usage = *taskResponse.Usage
+		}
+
+		if taskResponse.Type == types.WorkerTaskResponseTypeResult && taskResponse.Timings != nil {
+			targetInteraction.Timings = *taskResponse.Timings
+		}
+
+		if taskResponse.Type == types.WorkerTaskResponseTypeResult && taskResponse.Prompt != "" {
+			targetInteraction.Prompt = recordedPrompt(session, taskResponse.Prompt)
+		}
+
+		if taskResponse.Type == types.WorkerTaskResponseTypeResult && len(taskResponse.Checksums) > 0 {
+			if targetInteraction.Metadata == nil {
+				targetInteraction.Metadata = map[string]string{}
+			}
+			for filePath, checksum := range taskResponse.Checksums {
+				targetInteraction.Metadata[interactionMetadataChecksumPrefix+filePath] = checksum
+			}
 		}
 
 		if taskResponse.Type == types.WorkerTaskResponseTypeResult && session.Mode == types.SessionModeFinetune && taskResponse.LoraDir != "" {
@@ -678,6 +1080,28 @@ func (c *Controller) HandleRunnerResponse(ctx context.Context, taskResponse *typ
 	}
 	c.WriteSession(session)
 
+	if taskResponse.Type == types.WorkerTaskResponseTypeResult && session.Mode == types.SessionModeInference {
+		systemInteraction, err := data.GetSystemInteraction(session)
+		if err == nil {
+			c.BroadcastStreamDone(session, systemInteraction)
+		}
+	}
+
+	if taskResponse.Type == types.WorkerTaskResponseTypeResult {
+		c.streamOrder.forget(session.ID)
+		c.releaseSessionQuota(session.ID)
+		if taskResponse.Error != "" {
+			c.recordSessionOutcome(session, types.InteractionStateError)
+			c.notifySessionWebhook(session, types.InteractionStateError, taskResponse.Error)
+		} else {
+			c.recordSessionOutcome(session, types.InteractionStateComplete)
+			c.recordJobDuration(session)
+			c.recordStageDurations(session)
+			c.notifySessionWebhook(session, types.InteractionStateComplete, "")
+			c.maybeNameSession(session)
+		}
+	}
+
 	if taskResponse.Error != "" {
 		c.Options.Janitor.WriteSessionError(session, fmt.Errorf(taskResponse.Error))
 	}
@@ -685,10 +1109,102 @@ func (c *Controller) HandleRunnerResponse(ctx context.Context, taskResponse *typ
 	return taskResponse, nil
 }
 
+// the prompt a runner sent back as we keep it on the interaction, the same
+// redaction as the data prep logs applies so a sensitive session only gets
+// enough to tell its prompts apart
+func recordedPrompt(session *types.Session, prompt string) string {
+	if session.Metadata.Sensitive {
+		return qapairs.LogContentHash.Redact(prompt)
+	}
+	return prompt
+}
+
+// the interaction metadata keys we use to keep track of retries
+const (
+	interactionMetadataRetryCount = "retry_count"
+	interactionMetadataRetryError = "retry_error"
+	// so we can see a retry was down to running out of memory
+	interactionMetadataRetryErrorCode = "retry_error_code"
+)
+
+// the sha256 of each result file is kept in the interaction metadata under
+// this prefix followed by the file's filestore path
+const interactionMetadataChecksumPrefix = "sha256:"
+
+func getInteractionRetryCount(interaction *types.Interaction) int {
+	retries, err := strconv.Atoi(interaction.Metadata[interactionMetadataRetryCount])
+	if err != nil {
+		return 0
+	}
+	return retries
+}
+
+// if the system interaction has retries left then reset it and put the session
+// back on the queue so any eligible runner can pick it up again
+// returns false if there are no retries left and the error should stand
+func (c *Controller) retryInteraction(ctx context.Context, session *types.Session, taskResponse *types.RunnerTaskResponse) (bool, error) {
+	if c.Options.MaxInteractionRetries <= 0 {
+		return false, nil
+	}
+
+	systemInteraction, err := data.GetSystemInteraction(session)
+	if err != nil {
+		return false, err
+	}
+
+	retries := getInteractionRetryCount(systemInteraction)
+	if retries >= c.Options.MaxInteractionRetries {
+		return false, nil
+	}
+
+	session, err = data.UpdateSystemInteraction(session, func(targetInteraction *types.Interaction) (*types.Interaction, error) {
+		if targetInteraction.Metadata == nil {
+			targetInteraction.Metadata = map[string]string{}
+		}
+		targetInteraction.Metadata[interactionMetadataRetryCount] = strconv.Itoa(retries + 1)
+		targetInteraction.Metadata[interactionMetadataRetryError] = taskResponse.Error
+		if taskResponse.ErrorCode != types.RunnerErrorCodeNone {
+			targetInteraction.Metadata[interactionMetadataRetryErrorCode] = string(taskResponse.ErrorCode)
+		}
+		if taskResponse.Attempt != "" {
+			targetInteraction.RetriedAttempts = append(targetInteraction.RetriedAttempts, taskResponse.Attempt)
+		}
+
+		// reset anything the failed attempt streamed back to us
+		targetInteraction.Message = ""
+		targetInteraction.Error = ""
+		targetInteraction.ErrorDetail = ""
+		targetInteraction.ErrorCode = types.RunnerErrorCodeNone
+		targetInteraction.Usage = types.Usage{}
+		targetInteraction.Finished = false
+		targetInteraction.State = types.InteractionStateWaiting
+		targetInteraction.Status = fmt.Sprintf("retrying (attempt %d of %d)...", retries+1, c.Options.MaxInteractionRetries)
+
+		return targetInteraction, nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	log.Ctx(ctx).Warn().
+		Str("session_id", session.ID).
+		Int("retry", retries+1).
+		Int("max_retries", c.Options.MaxInteractionRetries).
+		Str("error", taskResponse.Error).
+		Msg("runner reported an error, re-queuing interaction")
+
+	c.WriteSession(session)
+	c.requeueSession(session)
+
+	return true, nil
+}
+
 type CloneUntilInteractionRequest struct {
 	InteractionID string
 	Mode          types.CloneInteractionMode
 	CopyAllFiles  bool
+	// start the new session off unshared even if the old one was shared
+	Private bool
 }
 
 // the user interaction is the thing we are cloning
@@ -708,6 +1224,10 @@ func (c *Controller) CloneUntilInteraction(
 		return nil, err
 	}
 
+	if req.Private {
+		newSession.Metadata.Shared = false
+	}
+
 	// for anything other than 'all' mode - we should revert the type to the original type
 	// this is for when we are editing a finetune session that has since become an inference session
 	// but now we are going back into finetine land by editing the interaction (somehow)
@@ -931,6 +1451,200 @@ func (c *Controller) CloneUntilInteraction(
 	return createdSession, nil
 }
 
+// DeleteSession deletes the session and takes it off the queue so it
+// doesn't go on using its owner's quota
+func (c *Controller) DeleteSession(ctx context.Context, sessionID string) (*types.Session, error) {
+	deleted, err := c.Options.Store.DeleteSession(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	c.forgetDeletedSession(sessionID)
+	return deleted, nil
+}
+
+// a deleted session can't be run so whatever the controller holds for it
+// in memory can go
+func (c *Controller) forgetDeletedSession(sessionID string) {
+	c.removeSessionFromQueue(sessionID)
+	c.releaseSessionQuota(sessionID)
+}
+
+// how many sessions we load at a time when going through all of an owner's
+const deleteSessionsPageSize = 100
+
+// delete a batch of the caller's sessions - we carry on if one of them fails
+// and report what happened to each one in the response
+func (c *Controller) DeleteSessions(ctx types.RequestContext, req types.SessionsDeleteRequest) (*types.SessionsDeleteResponse, error) {
+	res := &types.SessionsDeleteResponse{
+		Failed: map[string]string{},
+	}
+
+	deleted := map[string]*types.Session{}
+	// returns false if the session is still there
+	deleteSession := func(session *types.Session) bool {
+		if !matchesSessionsDeleteFilter(session, req) {
+			res.NotMatched++
+			return false
+		}
+		_, err := c.Options.Store.DeleteSession(ctx.Ctx, session.ID)
+		if err != nil {
+			log.Error().Msgf("error deleting session %s: %s", session.ID, err.Error())
+			res.Failed[session.ID] = err.Error()
+			return false
+		}
+		c.forgetDeletedSession(session.ID)
+		deleted[session.ID] = session
+		res.Deleted++
+		return true
+	}
+
+	if len(req.IDs) > 0 {
+		for _, id := range req.IDs {
+			if _, ok := deleted[id]; ok {
+				continue
+			}
+			session, err := c.Options.Store.GetSession(ctx.Ctx, id)
+			if err != nil || session == nil {
+				res.NotFound++
+				continue
+			}
+			if session.Owner != ctx.Owner || session.OwnerType != ctx.OwnerType {
+				res.NotOwned++
+				continue
+			}
+			deleteSession(session)
+		}
+	} else {
+		// deleted sessions drop out of the listing so we only move past
+		// the ones we leave behind
+		offset := 0
+		for {
+			page, err := c.Options.Store.GetSessions(ctx.Ctx, store.GetSessionsQuery{
+				Owner:     ctx.Owner,
+				OwnerType: ctx.OwnerType,
+				Offset:    offset,
+				Limit:     deleteSessionsPageSize,
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, session := range page {
+				if !deleteSession(session) {
+					offset++
+				}
+			}
+			if len(page) < deleteSessionsPageSize {
+				break
+			}
+		}
+	}
+
+	if len(deleted) == 0 {
+		return res, nil
+	}
+
+	remaining, err := c.getSessionsWithLoraDirs(ctx)
+	if err != nil {
+		// we can't tell which files are still used so we leave them all
+		log.Error().Msgf("error listing sessions to clean up files for: %s", err.Error())
+		return res, nil
+	}
+
+	go c.deleteSessionFiles(deleted, remaining)
+
+	return res, nil
+}
+
+// the owner's sessions that might be using the files of another session,
+// paged so we only hold onto the ones that matter
+func (c *Controller) getSessionsWithLoraDirs(ctx types.RequestContext) ([]*types.Session, error) {
+	sessions := []*types.Session{}
+	for offset := 0; ; offset += deleteSessionsPageSize {
+		page, err := c.Options.Store.GetSessions(ctx.Ctx, store.GetSessionsQuery{
+			Owner:     ctx.Owner,
+			OwnerType: ctx.OwnerType,
+			Offset:    offset,
+			Limit:     deleteSessionsPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, session := range page {
+			if hasLoraDir(session) {
+				sessions = append(sessions, session)
+			}
+		}
+		if len(page) < deleteSessionsPageSize {
+			return sessions, nil
+		}
+	}
+}
+
+func hasLoraDir(session *types.Session) bool {
+	if session.LoraDir != "" {
+		return true
+	}
+	for _, interaction := range session.Interactions {
+		if interaction.LoraDir != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesSessionsDeleteFilter(session *types.Session, req types.SessionsDeleteRequest) bool {
+	if req.Mode != "" && session.Mode != req.Mode {
+		return false
+	}
+	if req.OlderThan > 0 && session.Updated.After(time.Now().Add(-time.Duration(req.OlderThan))) {
+		return false
+	}
+	return true
+}
+
+// clean up the filestore folders of deleted sessions in the background
+// sessions cloned within the same account point at the files of the session
+// they were cloned from so we leave a folder alone if anything still uses it
+func (c *Controller) deleteSessionFiles(deleted map[string]*types.Session, remaining []*types.Session) {
+	for _, session := range deleted {
+		sessionPath, err := c.GetFilestoreSessionPath(data.OwnerContext(session.Owner), session.ID)
+		if err != nil {
+			log.Error().Msgf("error getting filestore path for session %s: %s", session.ID, err.Error())
+			continue
+		}
+
+		if isFilestorePathInUse(sessionPath, remaining) {
+			log.Debug().Msgf("not deleting files for session %s as they are used by another session", session.ID)
+			continue
+		}
+
+		err = c.Options.Filestore.Delete(c.Ctx, sessionPath)
+		if err != nil {
+			log.Error().Msgf("error deleting files for session %s: %s", session.ID, err.Error())
+		}
+	}
+}
+
+func isFilestorePathInUse(folder string, sessions []*types.Session) bool {
+	prefix := strings.TrimSuffix(folder, "/") + "/"
+	for _, session := range sessions {
+		if strings.HasPrefix(session.LoraDir, prefix) {
+			return true
+		}
+		for _, interaction := range session.Interactions {
+			if strings.HasPrefix(interaction.LoraDir, prefix) {
+				return true
+			}
+			for _, file := range interaction.Files {
+				if strings.HasPrefix(file, prefix) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 // return the contents of a filestore text file
 // you must have already applied the users sub-path before calling this
 func (c *Controller) FilestoreReadTextFile(filepath string) (string, error) {
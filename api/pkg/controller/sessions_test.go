@@ -0,0 +1,244 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/filestore"
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestStreamDeltaEvent(t *testing.T) {
+	streamEvent := func(res *types.RunnerTaskResponse) *types.WebsocketEvent {
+		return &types.WebsocketEvent{
+			Type:               types.WebsocketEventWorkerTaskResponse,
+			SessionID:          "session-1",
+			Owner:              "owner-1",
+			WorkerTaskResponse: res,
+		}
+	}
+
+	event, ok := streamDeltaEvent(streamEvent(&types.RunnerTaskResponse{
+		Type:          types.WorkerTaskResponseTypeStream,
+		InteractionID: "system-1",
+		Message:       "hel",
+	}))
+	assert.True(t, ok)
+	assert.Equal(t, &types.WebsocketEvent{
+		Type:        types.WebsocketEventStreamDelta,
+		SessionID:   "session-1",
+		Owner:       "owner-1",
+		StreamDelta: &types.StreamDelta{InteractionID: "system-1", Delta: "hel"},
+	}, event)
+
+	// the complete interaction is sent once the result is in
+	_, ok = streamDeltaEvent(streamEvent(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeStream, Done: true}))
+	assert.False(t, ok)
+
+	progress := streamEvent(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeProgress, Progress: 50})
+	event, ok = streamDeltaEvent(progress)
+	assert.True(t, ok)
+	assert.Same(t, progress, event)
+}
+
+func TestRecordedPrompt(t *testing.T) {
+	session := &types.Session{}
+	assert.Equal(t, "[INST] hello [/INST]", recordedPrompt(session, "[INST] hello [/INST]"))
+
+	session.Metadata.Sensitive = true
+	recorded := recordedPrompt(session, "[INST] hello [/INST]")
+	assert.NotContains(t, recorded, "hello")
+	assert.Contains(t, recorded, "20 chars")
+}
+
+func newRetryTestController(t *testing.T, maxRetries int) *Controller {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	mockStore.EXPECT().UpdateSession(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, session types.Session) (*types.Session, error) {
+		return &session, nil
+	}).AnyTimes()
+
+	return &Controller{
+		Options: ControllerOptions{
+			Store:                        mockStore,
+			MaxInteractionRetries:        maxRetries,
+			SchedulingDecisionBufferSize: 10,
+		},
+		UserWebsocketEventChanWriter: make(chan *types.WebsocketEvent, 100),
+		activeRunners:                xsync.NewMapOf[string, *types.RunnerState](),
+	}
+}
+
+func newRetrySession() *types.Session {
+	return &types.Session{
+		ID:   "session-1",
+		Mode: types.SessionModeInference,
+		Interactions: []*types.Interaction{
+			{ID: "session-1-user", Creator: types.CreatorTypeUser, Message: "hello"},
+			{ID: "session-1-system", Creator: types.CreatorTypeSystem, Message: "partial answer", State: types.InteractionStateWaiting},
+		},
+	}
+}
+
+func TestRetryInteraction(t *testing.T) {
+	c := newRetryTestController(t, 1)
+	session := newRetrySession()
+
+	retried, err := c.retryInteraction(context.Background(), session, &types.RunnerTaskResponse{
+		SessionID: session.ID,
+		Error:     "CUDA out of memory",
+		Attempt:   "attempt-1",
+	})
+	require.NoError(t, err)
+	assert.True(t, retried)
+
+	// the interaction is reset and back on the queue
+	systemInteraction, err := data.GetSystemInteraction(session)
+	require.NoError(t, err)
+	assert.Equal(t, 1, getInteractionRetryCount(systemInteraction))
+	assert.Equal(t, "CUDA out of memory", systemInteraction.Metadata[interactionMetadataRetryError])
+	assert.Equal(t, []string{"attempt-1"}, systemInteraction.RetriedAttempts)
+	assert.Equal(t, "", systemInteraction.Message)
+	assert.Equal(t, types.InteractionStateWaiting, systemInteraction.State)
+	require.Len(t, c.sessionQueue, 1)
+
+	// the retry shows up in the scheduling decision
+	next, err := c.ShiftSessionQueue(context.Background(), types.SessionFilter{}, "runner-1")
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	require.Len(t, c.schedulingDecisions, 1)
+	assert.Equal(t, 1, c.schedulingDecisions[0].RetryCount)
+
+	// no retries left so the error stands
+	retried, err = c.retryInteraction(context.Background(), session, &types.RunnerTaskResponse{
+		SessionID: session.ID,
+		Error:     "CUDA out of memory",
+	})
+	require.NoError(t, err)
+	assert.False(t, retried)
+	assert.Empty(t, c.sessionQueue)
+}
+
+func TestRetryInteraction_Off(t *testing.T) {
+	c := newRetryTestController(t, 0)
+
+	retried, err := c.retryInteraction(context.Background(), newRetrySession(), &types.RunnerTaskResponse{
+		SessionID: "session-1",
+		Error:     "CUDA out of memory",
+	})
+	require.NoError(t, err)
+	assert.False(t, retried)
+	assert.Empty(t, c.sessionQueue)
+}
+
+// sessions kept newest first like the store does, deleting one takes it
+// out of the next page
+type fakeSessionsStore struct {
+	sessions []*types.Session
+}
+
+func (f *fakeSessionsStore) getSessions(ctx context.Context, query store.GetSessionsQuery) ([]*types.Session, error) {
+	if query.Offset >= len(f.sessions) {
+		return []*types.Session{}, nil
+	}
+	end := len(f.sessions)
+	if query.Limit > 0 && query.Offset+query.Limit < end {
+		end = query.Offset + query.Limit
+	}
+	return append([]*types.Session{}, f.sessions[query.Offset:end]...), nil
+}
+
+func (f *fakeSessionsStore) deleteSession(ctx context.Context, id string) (*types.Session, error) {
+	for i, session := range f.sessions {
+		if session.ID == id {
+			f.sessions = append(f.sessions[:i], f.sessions[i+1:]...)
+			return session, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+// records the session folders the controller cleans up
+type deletedFoldersStore struct {
+	filestore.FileStore
+	deleted chan string
+}
+
+func (f *deletedFoldersStore) Delete(ctx context.Context, path string) error {
+	f.deleted <- path
+	return nil
+}
+
+func TestDeleteSessions_Paged(t *testing.T) {
+	fake := &fakeSessionsStore{}
+	total := deleteSessionsPageSize*2 + 10
+	for i := 0; i < total; i++ {
+		mode := types.SessionModeInference
+		if i%3 == 0 {
+			mode = types.SessionModeFinetune
+		}
+		fake.sessions = append(fake.sessions, &types.Session{
+			ID:        fmt.Sprintf("session-%d", i),
+			Owner:     "user-1",
+			OwnerType: types.OwnerTypeUser,
+			Mode:      mode,
+		})
+	}
+	queued := fake.sessions[1]
+
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	mockStore.EXPECT().GetSessions(gomock.Any(), gomock.Any()).DoAndReturn(fake.getSessions).AnyTimes()
+	mockStore.EXPECT().DeleteSession(gomock.Any(), gomock.Any()).DoAndReturn(fake.deleteSession).AnyTimes()
+
+	filestore := &deletedFoldersStore{deleted: make(chan string, total)}
+	c := &Controller{
+		Ctx:           context.Background(),
+		Options:       ControllerOptions{Store: mockStore, Filestore: filestore},
+		activeRunners: xsync.NewMapOf[string, *types.RunnerState](),
+	}
+	c.requeueSession(&types.Session{
+		ID:   queued.ID,
+		Mode: queued.Mode,
+		Interactions: []*types.Interaction{
+			{ID: "user", Creator: types.CreatorTypeUser},
+			{ID: "system", Creator: types.CreatorTypeSystem},
+		},
+	})
+	require.Len(t, c.sessionQueue, 1)
+
+	res, err := c.DeleteSessions(types.RequestContext{Ctx: context.Background(), Owner: "user-1", OwnerType: types.OwnerTypeUser}, types.SessionsDeleteRequest{
+		Mode: types.SessionModeInference,
+	})
+	require.NoError(t, err)
+
+	// every inference session goes, across all the pages
+	finetunes := (total + 2) / 3
+	assert.Equal(t, total-finetunes, res.Deleted)
+	assert.Equal(t, finetunes, res.NotMatched)
+	assert.Len(t, fake.sessions, finetunes)
+	for _, session := range fake.sessions {
+		assert.Equal(t, types.SessionModeFinetune, session.Mode)
+	}
+
+	// and the deleted sessions are taken off the queue
+	assert.Empty(t, c.sessionQueue)
+
+	// their files are cleaned up in the background
+	for i := 0; i < res.Deleted; i++ {
+		select {
+		case <-filestore.deleted:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d session folders were deleted", i, res.Deleted)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+const (
+	defaultShareLinkExpiry = 24 * time.Hour
+	MaxShareLinkExpiry     = 30 * 24 * time.Hour
+)
+
+// the token is expired, revoked, signed with another secret or has been
+// tampered with - whoever is holding it gets the same answer for all of them
+var ErrInvalidShareLink = errors.New("invalid or expired share link")
+
+// there is no SHARE_LINK_SECRET so we can't sign links
+var ErrShareLinksDisabled = errors.New("share links are not enabled")
+
+// make a link that lets anyone read the session until it expires
+func (c *Controller) CreateSessionShareLink(ctx context.Context, session *types.Session, expiresIn time.Duration) (*types.SessionShareLink, error) {
+	if c.Options.ShareLinkSecret == "" {
+		return nil, ErrShareLinksDisabled
+	}
+	if expiresIn <= 0 {
+		expiresIn = defaultShareLinkExpiry
+	}
+	if expiresIn > MaxShareLinkExpiry {
+		expiresIn = MaxShareLinkExpiry
+	}
+
+	link, err := c.Options.Store.CreateSessionShareLink(ctx, &types.SessionShareLink{
+		ID:        system.GenerateUUID(),
+		SessionID: session.ID,
+		Owner:     session.Owner,
+		OwnerType: session.OwnerType,
+		// the token only has second precision
+		Expires: time.Now().Add(expiresIn).Truncate(time.Second),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	link.Token = c.shareLinkToken(link)
+	return link, nil
+}
+
+func (c *Controller) ListSessionShareLinks(ctx context.Context, session *types.Session) ([]*types.SessionShareLink, error) {
+	links, err := c.Options.Store.ListSessionShareLinks(ctx, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		link.Token = c.shareLinkToken(link)
+	}
+	return links, nil
+}
+
+// revoking a link deletes it so its token stops working straight away
+func (c *Controller) RevokeSessionShareLink(ctx context.Context, session *types.Session, linkID string) (*types.SessionShareLink, error) {
+	link, err := c.Options.Store.GetSessionShareLink(ctx, linkID)
+	if err != nil {
+		return nil, err
+	}
+	if link.SessionID != session.ID {
+		return nil, store.ErrNotFound
+	}
+	err = c.Options.Store.DeleteSessionShareLink(ctx, link.ID)
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// the session a share link token is for - the link must still exist and the
+// token must not have expired or been changed
+func (c *Controller) GetSharedSession(ctx context.Context, token string) (*types.Session, error) {
+	if c.Options.ShareLinkSecret == "" {
+		return nil, ErrInvalidShareLink
+	}
+
+	linkID, expires, err := parseShareLinkToken(token)
+	if err != nil {
+		return nil, ErrInvalidShareLink
+	}
+	if time.Now().Unix() > expires {
+		return nil, ErrInvalidShareLink
+	}
+
+	link, err := c.Options.Store.GetSessionShareLink(ctx, linkID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrInvalidShareLink
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the expiry is part of what is signed so it can't be pushed back
+	if !hmac.Equal([]byte(token), []byte(c.shareLinkToken(link))) {
+		return nil, ErrInvalidShareLink
+	}
+
+	session, err := c.Options.Store.GetSession(ctx, link.SessionID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrInvalidShareLink
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// <link id>.<expiry unix time>.<hmac of the link id, session id and expiry>
+func (c *Controller) shareLinkToken(link *types.SessionShareLink) string {
+	expires := link.Expires.Unix()
+	mac := hmac.New(sha256.New, []byte(c.Options.ShareLinkSecret))
+	fmt.Fprintf(mac, "%s:%s:%d", link.ID, link.SessionID, expires)
+	return fmt.Sprintf("%s.%d.%s", link.ID, expires, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func parseShareLinkToken(token string) (string, int64, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return "", 0, fmt.Errorf("malformed share link token")
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("malformed share link token: %w", err)
+	}
+	return parts[0], expires, nil
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// a controller whose store keeps share links in a map so that revoking one
+// is visible to the next lookup
+func newShareLinkTestController(t *testing.T, session *types.Session) (*Controller, map[string]*types.SessionShareLink) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	links := map[string]*types.SessionShareLink{}
+
+	mockStore.EXPECT().CreateSessionShareLink(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, link *types.SessionShareLink) (*types.SessionShareLink, error) {
+		stored := *link
+		links[link.ID] = &stored
+		return link, nil
+	}).AnyTimes()
+	mockStore.EXPECT().GetSessionShareLink(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, id string) (*types.SessionShareLink, error) {
+		link, ok := links[id]
+		if !ok {
+			return nil, store.ErrNotFound
+		}
+		stored := *link
+		return &stored, nil
+	}).AnyTimes()
+	mockStore.EXPECT().DeleteSessionShareLink(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, id string) error {
+		delete(links, id)
+		return nil
+	}).AnyTimes()
+	mockStore.EXPECT().GetSession(gomock.Any(), session.ID).Return(session, nil).AnyTimes()
+
+	return &Controller{Options: ControllerOptions{Store: mockStore, ShareLinkSecret: "secret"}}, links
+}
+
+func TestSessionShareLink(t *testing.T) {
+	session := &types.Session{ID: "session-1", Owner: "owner-1", OwnerType: types.OwnerTypeUser}
+	c, _ := newShareLinkTestController(t, session)
+
+	link, err := c.CreateSessionShareLink(context.Background(), session, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, link.SessionID)
+	assert.Equal(t, session.Owner, link.Owner)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), link.Expires, 2*time.Second)
+	assert.True(t, strings.HasPrefix(link.Token, link.ID+"."))
+
+	shared, err := c.GetSharedSession(context.Background(), link.Token)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, shared.ID)
+
+	_, err = c.RevokeSessionShareLink(context.Background(), session, link.ID)
+	require.NoError(t, err)
+
+	_, err = c.GetSharedSession(context.Background(), link.Token)
+	assert.ErrorIs(t, err, ErrInvalidShareLink)
+}
+
+func TestSessionShareLink_DefaultExpiry(t *testing.T) {
+	session := &types.Session{ID: "session-1", Owner: "owner-1"}
+	c, _ := newShareLinkTestController(t, session)
+
+	link, err := c.CreateSessionShareLink(context.Background(), session, 0)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), link.Expires, 2*time.Second)
+}
+
+func TestGetSharedSession_Invalid(t *testing.T) {
+	session := &types.Session{ID: "session-1", Owner: "owner-1"}
+	c, links := newShareLinkTestController(t, session)
+
+	link, err := c.CreateSessionShareLink(context.Background(), session, time.Hour)
+	require.NoError(t, err)
+	parts := strings.Split(link.Token, ".")
+
+	// the link says it expired a minute ago
+	expired := &types.SessionShareLink{ID: "expired", SessionID: session.ID, Expires: time.Now().Add(-time.Minute).Truncate(time.Second)}
+	links[expired.ID] = expired
+
+	otherSecret := &Controller{Options: ControllerOptions{ShareLinkSecret: "other"}}
+
+	for name, token := range map[string]string{
+		"empty":            "",
+		"garbage":          "not-a-token",
+		"expired":          c.shareLinkToken(expired),
+		"pushed_back":      fmt.Sprintf("%s.%d.%s", parts[0], time.Now().Add(48*time.Hour).Unix(), parts[2]),
+		"bad_signature":    fmt.Sprintf("%s.%s.%s", parts[0], parts[1], strings.Repeat("0", len(parts[2]))),
+		"unknown_link":     fmt.Sprintf("%s.%s.%s", "other-link", parts[1], parts[2]),
+		"other_secret":     otherSecret.shareLinkToken(links[link.ID]),
+		"bad_expiry":       fmt.Sprintf("%s.%s.%s", parts[0], "soon", parts[2]),
+		"extra_token_part": link.Token + ".extra",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := c.GetSharedSession(context.Background(), token)
+			assert.ErrorIs(t, err, ErrInvalidShareLink)
+		})
+	}
+}
+
+func TestSessionShareLink_Disabled(t *testing.T) {
+	session := &types.Session{ID: "session-1", Owner: "owner-1"}
+	c := &Controller{}
+
+	_, err := c.CreateSessionShareLink(context.Background(), session, time.Hour)
+	assert.ErrorIs(t, err, ErrShareLinksDisabled)
+
+	_, err = c.GetSharedSession(context.Background(), "link-1.123.abc")
+	assert.ErrorIs(t, err, ErrInvalidShareLink)
+}
+
+func TestRevokeSessionShareLink_OtherSession(t *testing.T) {
+	session := &types.Session{ID: "session-1", Owner: "owner-1"}
+	c, _ := newShareLinkTestController(t, session)
+
+	link, err := c.CreateSessionShareLink(context.Background(), session, time.Hour)
+	require.NoError(t, err)
+
+	_, err = c.RevokeSessionShareLink(context.Background(), &types.Session{ID: "session-2"}, link.ID)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	// still works
+	_, err = c.GetSharedSession(context.Background(), link.Token)
+	require.NoError(t, err)
+}
@@ -26,6 +26,12 @@ func (c *Controller) runActionInteraction(ctx context.Context, session *types.Se
 		return nil, fmt.Errorf("failed to get tool %s: %w", toolID, err)
 	}
 
+	// the tool ID comes from the interaction so make sure we never call
+	// someone else's API (or send them this user's message)
+	if tool.Owner != session.Owner || tool.OwnerType != session.OwnerType {
+		return nil, fmt.Errorf("tool %s does not belong to the owner of session %s", toolID, session.ID)
+	}
+
 	userInteraction, err := data.GetLastUserInteraction(session.Interactions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get last user interaction: %w", err)
@@ -41,7 +47,13 @@ func (c *Controller) runActionInteraction(ctx context.Context, session *types.Se
 	}
 
 	resp, err := c.Options.Planner.RunAction(ctx, tool, history, userInteraction.Message, action)
+	c.recordToolUsage(tool.ID, action)
 	if err != nil {
+		// the session is errored with this interaction so keep what we
+		// sent upstream on it
+		if resp != nil {
+			systemInteraction.ToolRequest = resp.Request
+		}
 		return nil, fmt.Errorf("failed to perform action: %w", err)
 	}
 
@@ -52,6 +64,7 @@ func (c *Controller) runActionInteraction(ctx context.Context, session *types.Se
 		systemInteraction.Metadata["error"] = resp.Error
 		systemInteraction.Metadata["tool_id"] = toolID
 		systemInteraction.Metadata["tool_action"] = action
+		systemInteraction.ToolRequest = resp.Request
 		systemInteraction.State = types.InteractionStateComplete
 
 		return systemInteraction, nil
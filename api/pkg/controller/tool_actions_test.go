@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/tools"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// a planner whose actions always fail after the request was sent
+type failingPlanner struct {
+	tools.Planner
+	request *types.ToolActionRequest
+}
+
+func (p *failingPlanner) RunAction(ctx context.Context, tool *types.Tool, history []*types.Interaction, currentMessage, action string) (*tools.RunActionResponse, error) {
+	err := fmt.Errorf("failed to make api call")
+	return &tools.RunActionResponse{Error: err.Error(), Request: p.request}, err
+}
+
+func TestRunActionInteraction_FailedActionKeepsRequest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	request := &types.ToolActionRequest{Method: "GET", URL: "https://example.com/pets?appid=REDACTED"}
+	c := &Controller{
+		Options: ControllerOptions{Store: mockStore, Planner: &failingPlanner{request: request}},
+	}
+
+	mockStore.EXPECT().GetTool(gomock.Any(), "tool_1").Return(&types.Tool{
+		ID:        "tool_1",
+		Owner:     "user_1",
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	systemInteraction := &types.Interaction{
+		ID:       "system_1",
+		Creator:  types.CreatorTypeSystem,
+		Metadata: map[string]string{"tool_action": "listPets", "tool_id": "tool_1"},
+	}
+	session := &types.Session{
+		ID:        "session_1",
+		Owner:     "user_1",
+		OwnerType: types.OwnerTypeUser,
+		Interactions: []*types.Interaction{
+			{ID: "user_1", Creator: types.CreatorTypeUser, Message: "list my pets"},
+			systemInteraction,
+		},
+	}
+
+	_, err := c.runActionInteraction(context.Background(), session, systemInteraction)
+	assert.ErrorContains(t, err, "failed to perform action")
+	assert.Equal(t, request, systemInteraction.ToolRequest)
+}
+
+func TestRunActionInteraction_OtherOwnersTool(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	c := &Controller{Options: ControllerOptions{Store: mockStore}}
+
+	// same owner ID, different owner type
+	mockStore.EXPECT().GetTool(gomock.Any(), "tool_1").Return(&types.Tool{
+		ID:        "tool_1",
+		Owner:     "user_1",
+		OwnerType: types.OwnerType("org"),
+	}, nil)
+
+	session := &types.Session{
+		ID:        "session_1",
+		Owner:     "user_1",
+		OwnerType: types.OwnerTypeUser,
+	}
+	systemInteraction := &types.Interaction{
+		Creator:  types.CreatorTypeSystem,
+		Metadata: map[string]string{"tool_action": "listPets", "tool_id": "tool_1"},
+	}
+
+	_, err := c.runActionInteraction(context.Background(), session, systemInteraction)
+	assert.ErrorContains(t, err, "does not belong to the owner of session session_1")
+}
@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// how many tool usage counts can wait to be written before we start
+// dropping them
+const toolUsageBufferSize = 1000
+
+type toolUsage struct {
+	toolID string
+	action string
+	used   time.Time
+}
+
+// count a run of a tool's action without making the user wait for the
+// database - if the writer has fallen too far behind the count is dropped
+func (c *Controller) recordToolUsage(toolID, action string) {
+	select {
+	case c.toolUsage <- toolUsage{toolID: toolID, action: action, used: time.Now()}:
+	default:
+		log.Warn().Msgf("dropping usage of tool %s action %s, the writer is behind", toolID, action)
+	}
+}
+
+// this should be run in a go-routine
+func (c *Controller) writeToolUsage(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case usage := <-c.toolUsage:
+			err := c.Options.Store.IncrementToolUsage(ctx, usage.toolID, usage.action, usage.used)
+			if err != nil {
+				log.Error().Err(err).Msgf("failed to record usage of tool %s action %s", usage.toolID, usage.action)
+			}
+		}
+	}
+}
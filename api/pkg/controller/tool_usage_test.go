@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/helixml/helix/api/pkg/store"
+)
+
+func TestWriteToolUsage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	c := &Controller{Options: ControllerOptions{Store: mockStore}, toolUsage: make(chan toolUsage, 1)}
+
+	written := make(chan string, 1)
+	mockStore.EXPECT().IncrementToolUsage(gomock.Any(), "tool_1", "listPets", gomock.Any()).DoAndReturn(
+		func(ctx context.Context, toolID, action string, used time.Time) error {
+			written <- action
+			return nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.writeToolUsage(ctx)
+
+	c.recordToolUsage("tool_1", "listPets")
+
+	select {
+	case action := <-written:
+		assert.Equal(t, "listPets", action)
+	case <-time.After(5 * time.Second):
+		t.Fatal("tool usage was not written")
+	}
+}
+
+func TestRecordToolUsage_WriterBehind(t *testing.T) {
+	c := &Controller{toolUsage: make(chan toolUsage, 1)}
+
+	// nothing is reading so the second one is dropped rather than blocking
+	c.recordToolUsage("tool_1", "listPets")
+	c.recordToolUsage("tool_1", "getPet")
+
+	assert.Len(t, c.toolUsage, 1)
+	assert.Equal(t, "listPets", (<-c.toolUsage).action)
+}
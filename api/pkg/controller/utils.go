@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	stdlog "log"
 	"mime/multipart"
@@ -14,6 +13,7 @@ import (
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/helixml/helix/api/pkg/dataprep/text"
 	"github.com/helixml/helix/api/pkg/filestore"
 	"github.com/helixml/helix/api/pkg/types"
 	"github.com/rs/zerolog/log"
@@ -33,11 +33,6 @@ func isOlderThan24Hours(t time.Time) bool {
 	return t.Before(compareTime)
 }
 
-func dumpObject(data interface{}) {
-	bytes, _ := json.MarshalIndent(data, "", "    ")
-	fmt.Printf("%s\n", string(bytes))
-}
-
 func createMultipartRequest(uri string, fieldName string, fileName string, fileReader io.Reader) (*retryablehttp.Request, error) {
 	// Create a buffer to write our multipart form to
 	var requestBody bytes.Buffer
@@ -163,17 +158,20 @@ func getQAChunkErrors(
 
 func updateProcessedQAChunk(
 	interaction *types.Interaction,
-	filename string,
-	chunkIndex int,
-	promptName string,
+	qaChunk *text.DataPrepTextSplitterChunk,
 	questionCount int,
 	err error,
 ) *types.Interaction {
-	useFilename := path.Base(filename)
+	useFilename := path.Base(qaChunk.Filename)
+	chunkIndex := qaChunk.Index
+	promptName := qaChunk.PromptName
 	if hasProcessedQAChunk(interaction, useFilename, chunkIndex, promptName) {
 		return interaction
 	}
 	allChunks := interaction.DataPrepChunks
+	if allChunks == nil {
+		allChunks = map[string][]types.DataPrepChunk{}
+	}
 	chunks, ok := allChunks[useFilename]
 	if !ok {
 		chunks = []types.DataPrepChunk{}
@@ -185,18 +183,23 @@ func updateProcessedQAChunk(
 	for _, existingChunk := range chunks {
 		if existingChunk.Index == chunkIndex && existingChunk.PromptName == promptName {
 			chunkExists = true
+			existingChunk := existingChunk
 			chunk = &existingChunk
 		}
 	}
 
 	if chunk == nil {
 		chunk = &types.DataPrepChunk{
-			Index:         chunkIndex,
-			QuestionCount: questionCount,
-			PromptName:    promptName,
+			Index:      chunkIndex,
+			PromptName: promptName,
 		}
 	}
 
+	// a retried chunk gets the count from this attempt
+	chunk.QuestionCount = questionCount
+	chunk.DocumentID = qaChunk.DocumentID
+	chunk.SpansDocumentID = qaChunk.SpansDocumentID
+
 	if err != nil {
 		chunk.Error = err.Error()
 	} else {
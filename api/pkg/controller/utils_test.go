@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/helixml/helix/api/pkg/dataprep/text"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func qaChunk(index int) *text.DataPrepTextSplitterChunk {
+	return &text.DataPrepTextSplitterChunk{Filename: "docs/a.txt", Index: index, PromptName: "simple-quiz", DocumentID: "doc-a"}
+}
+
+func TestUpdateProcessedQAChunk(t *testing.T) {
+	interaction := &types.Interaction{}
+
+	interaction = updateProcessedQAChunk(interaction, qaChunk(0), 5, nil)
+	interaction = updateProcessedQAChunk(interaction, qaChunk(1), 0, fmt.Errorf("model timed out"))
+	interaction = updateProcessedQAChunk(interaction, qaChunk(2), 3, nil)
+
+	assert.Equal(t, []types.DataPrepChunk{
+		{Index: 0, PromptName: "simple-quiz", QuestionCount: 5, DocumentID: "doc-a"},
+		{Index: 1, PromptName: "simple-quiz", Error: "model timed out", DocumentID: "doc-a"},
+		{Index: 2, PromptName: "simple-quiz", QuestionCount: 3, DocumentID: "doc-a"},
+	}, interaction.DataPrepChunks["a.txt"])
+	assert.Equal(t, 1, getQAChunkErrors(interaction))
+
+	// retrying the failed chunk updates it in place and leaves the others alone
+	interaction = updateProcessedQAChunk(interaction, qaChunk(1), 4, nil)
+
+	assert.Equal(t, []types.DataPrepChunk{
+		{Index: 0, PromptName: "simple-quiz", QuestionCount: 5, DocumentID: "doc-a"},
+		{Index: 1, PromptName: "simple-quiz", QuestionCount: 4, DocumentID: "doc-a"},
+		{Index: 2, PromptName: "simple-quiz", QuestionCount: 3, DocumentID: "doc-a"},
+	}, interaction.DataPrepChunks["a.txt"])
+	assert.Equal(t, 0, getQAChunkErrors(interaction))
+}
+
+func TestUpdateProcessedQAChunk_SpanningChunk(t *testing.T) {
+	interaction := &types.Interaction{}
+
+	span := &text.DataPrepTextSplitterChunk{Filename: "docs/part2.md", Index: 3, PromptName: "simple-quiz", DocumentID: "doc-2", SpansDocumentID: "doc-1"}
+	interaction = updateProcessedQAChunk(interaction, span, 6, nil)
+
+	// counted against the later document's file with both documents on it
+	assert.Equal(t, []types.DataPrepChunk{
+		{Index: 3, PromptName: "simple-quiz", QuestionCount: 6, DocumentID: "doc-2", SpansDocumentID: "doc-1"},
+	}, interaction.DataPrepChunks["part2.md"])
+}
+
+func TestGetMaxSessionFileSize(t *testing.T) {
+	c := &Controller{Options: ControllerOptions{MaxSessionFileSize: 100, MaxSessionFileSizeSubscribed: 1000}}
+
+	assert.Equal(t, int64(100), c.GetMaxSessionFileSize(types.UserConfig{}))
+	assert.Equal(t, int64(1000), c.GetMaxSessionFileSize(types.UserConfig{StripeSubscriptionActive: true}))
+	assert.Equal(t, int64(50), c.GetMaxSessionFileSize(types.UserConfig{StripeSubscriptionActive: true, MaxSessionFileSize: 50}))
+
+	// subscribers get the default limit when there isn't one for them
+	c.Options.MaxSessionFileSizeSubscribed = 0
+	assert.Equal(t, int64(100), c.GetMaxSessionFileSize(types.UserConfig{StripeSubscriptionActive: true}))
+}
@@ -0,0 +1,263 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+const (
+	// "sha256=" followed by the hex HMAC-SHA256 of the body keyed with the owner's webhook secret
+	WebhookSignatureHeader = "X-Helix-Signature"
+	WebhookEventHeader     = "X-Helix-Event"
+
+	webhookMaxAttempts    = 5
+	webhookInitialBackoff = time.Second
+	webhookTimeout        = 10 * time.Second
+	// we don't care what the receiver says back, only its status
+	maxWebhookResponseSize = 64 << 10
+)
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func signWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *Controller) getUserMetaOrDefault(ctx context.Context, owner string) *types.UserMeta {
+	userMeta, err := c.Options.Store.GetUserMeta(ctx, owner)
+	if err != nil || userMeta == nil {
+		return &types.UserMeta{
+			ID:     owner,
+			Config: types.UserConfig{},
+		}
+	}
+	return userMeta
+}
+
+// the secret is created the first time we need it so that anyone who
+// only uses per-session webhook URLs still gets signed payloads
+func (c *Controller) ensureWebhookSecret(ctx context.Context, userMeta *types.UserMeta) (*types.UserMeta, error) {
+	if userMeta.Config.WebhookSecret != "" {
+		return userMeta, nil
+	}
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	userMeta.Config.WebhookSecret = secret
+	return c.Options.Store.EnsureUserMeta(ctx, *userMeta)
+}
+
+// reading the config never writes it, the secret is empty until the owner
+// sets a URL or the first webhook is sent
+func (c *Controller) GetWebhookConfig(ctx types.RequestContext) (*types.WebhookConfig, error) {
+	userMeta := c.getUserMetaOrDefault(ctx.Ctx, ctx.Owner)
+	return &types.WebhookConfig{
+		URL:    userMeta.Config.WebhookURL,
+		Secret: userMeta.Config.WebhookSecret,
+	}, nil
+}
+
+func (c *Controller) UpdateWebhookConfig(ctx types.RequestContext, update types.WebhookConfigUpdate) (*types.WebhookConfig, error) {
+	userMeta := c.getUserMetaOrDefault(ctx.Ctx, ctx.Owner)
+	userMeta.Config.WebhookURL = update.URL
+
+	if userMeta.Config.WebhookSecret == "" || update.RotateSecret {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			return nil, err
+		}
+		userMeta.Config.WebhookSecret = secret
+	}
+
+	userMeta, err := c.Options.Store.EnsureUserMeta(ctx.Ctx, *userMeta)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.WebhookConfig{
+		URL:    userMeta.Config.WebhookURL,
+		Secret: userMeta.Config.WebhookSecret,
+	}, nil
+}
+
+// tell the owner's webhook (or the session's own one) that the session has
+// completed or errored - this never blocks the session, failed deliveries
+// are retried in the background and then logged
+func (c *Controller) notifySessionWebhook(session *types.Session, state types.InteractionState, sessionErr string) {
+	ctx := c.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// the caller carries on with the session so we work from a copy
+	copied := *session
+	session = &copied
+
+	go func() {
+		userMeta := c.getUserMetaOrDefault(ctx, session.Owner)
+
+		webhookURL := session.Metadata.WebhookURL
+		if webhookURL == "" {
+			webhookURL = userMeta.Config.WebhookURL
+		}
+		if webhookURL == "" {
+			return
+		}
+
+		userMeta, err := c.ensureWebhookSecret(ctx, userMeta)
+		if err != nil {
+			log.Error().Err(err).Str("session_id", session.ID).Msg("error creating webhook secret")
+			return
+		}
+
+		event := types.SessionWebhookEventCompleted
+		if state == types.InteractionStateError || state == types.InteractionStateInterrupted {
+			event = types.SessionWebhookEventErrored
+		}
+
+		body, err := json.Marshal(&types.SessionWebhookPayload{
+			Event:     event,
+			SessionID: session.ID,
+			Owner:     session.Owner,
+			Mode:      session.Mode,
+			Type:      session.Type,
+			ModelName: session.ModelName,
+			Status:    state,
+			Error:     sessionErr,
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			log.Error().Err(err).Str("session_id", session.ID).Msg("error encoding webhook payload")
+			return
+		}
+
+		err = deliverWebhook(ctx, newWebhookClient(), webhookURL, userMeta.Config.WebhookSecret, event, body, webhookMaxAttempts, webhookInitialBackoff)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("session_id", session.ID).
+				Str("owner", session.Owner).
+				Str("url", webhookURL).
+				Msg("giving up delivering session webhook")
+		}
+	}()
+}
+
+// a client that won't connect to our own network whatever the webhook's
+// host name resolves to - redirects are dialled the same way so can't be
+// used to get around it either
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || types.IsInternalIP(ip) {
+				return fmt.Errorf("webhook address %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would connect for us so would skip the check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: transport,
+	}
+}
+
+// POST the body to the URL, retrying with exponential backoff until we get
+// a 2xx or run out of attempts
+func deliverWebhook(
+	ctx context.Context,
+	client *http.Client,
+	webhookURL string,
+	secret string,
+	event types.SessionWebhookEvent,
+	body []byte,
+	maxAttempts int,
+	backoff time.Duration,
+) error {
+	signature := signWebhookPayload(secret, body)
+
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		lastErr = postWebhook(ctx, client, webhookURL, signature, event, body)
+		if lastErr == nil {
+			return nil
+		}
+		log.Debug().Err(lastErr).Int("attempt", attempt).Str("url", webhookURL).Msg("webhook delivery failed")
+	}
+
+	return fmt.Errorf("failed after %d attempts: %w", maxAttempts, lastErr)
+}
+
+func postWebhook(
+	ctx context.Context,
+	client *http.Client,
+	webhookURL string,
+	signature string,
+	event types.SessionWebhookEvent,
+	body []byte,
+) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, string(event))
+	req.Header.Set(WebhookSignatureHeader, signature)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused, but only so much of it
+	// as the receiver could otherwise keep us reading until the timeout
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxWebhookResponseSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestDeliverWebhook_Signed(t *testing.T) {
+	body := []byte(`{"event":"session.completed"}`)
+
+	var gotSignature, gotEvent string
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSignature = r.Header.Get(WebhookSignatureHeader)
+		gotEvent = r.Header.Get(WebhookEventHeader)
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	err := deliverWebhook(context.Background(), server.Client(), server.URL, "secret", types.SessionWebhookEventCompleted, body, 3, time.Millisecond)
+	require.NoError(t, err)
+
+	assert.Equal(t, body, gotBody)
+	assert.Equal(t, string(types.SessionWebhookEventCompleted), gotEvent)
+	assert.Equal(t, signWebhookPayload("secret", body), gotSignature)
+	assert.NotEqual(t, signWebhookPayload("other-secret", body), gotSignature)
+}
+
+func TestDeliverWebhook_RetriesUntilSuccess(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	err := deliverWebhook(context.Background(), server.Client(), server.URL, "secret", types.SessionWebhookEventErrored, []byte(`{}`), 5, time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestDeliverWebhook_GivesUp(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	err := deliverWebhook(context.Background(), server.Client(), server.URL, "secret", types.SessionWebhookEventErrored, []byte(`{}`), 3, time.Millisecond)
+	require.Error(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestDeliverWebhook_EndlessResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		chunk := make([]byte, 1024)
+		for {
+			_, err := w.Write(chunk)
+			if err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	// we stop reading after maxWebhookResponseSize rather than at the timeout
+	done := make(chan error, 1)
+	go func() {
+		done <- deliverWebhook(context.Background(), server.Client(), server.URL, "secret", types.SessionWebhookEventCompleted, []byte(`{}`), 1, time.Millisecond)
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("still reading the webhook response")
+	}
+}
+
+func TestNewWebhookClient_RefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// the test server is on loopback which is where webhooks must never go
+	err := postWebhook(context.Background(), newWebhookClient(), server.URL, "sha256=", types.SessionWebhookEventCompleted, []byte(`{}`))
+	assert.ErrorContains(t, err, "is not allowed")
+}
+
+func TestGetWebhookConfig_DoesNotCreateSecret(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	// no EnsureUserMeta, reading the config doesn't write it
+	mockStore.EXPECT().GetUserMeta(gomock.Any(), "user-1").Return(&types.UserMeta{ID: "user-1"}, nil)
+	c := &Controller{Options: ControllerOptions{Store: mockStore}}
+
+	config, err := c.GetWebhookConfig(types.RequestContext{Ctx: context.Background(), Owner: "user-1"})
+	require.NoError(t, err)
+	assert.Empty(t, config.Secret)
+}
@@ -0,0 +1,33 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/inhies/go-bytesize"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+var ErrDatasetTooLarge = errors.New("dataset exceeds limit")
+
+// the total size of the files uploaded to the session - files that were
+// uploaded before we recorded their sizes don't count towards it
+func GetSessionFileSize(interactions []*types.Interaction) int64 {
+	var total int64
+	for _, interaction := range interactions {
+		for _, size := range interaction.FileSizes {
+			total += size
+		}
+	}
+	return total
+}
+
+// a limit of zero means there isn't one
+func CheckSessionFileSize(size int64, limit int64) error {
+	if limit <= 0 || size <= limit {
+		return nil
+	}
+	return fmt.Errorf("%w: the session files total %s (%d bytes) but the limit is %s",
+		ErrDatasetTooLarge, bytesize.New(float64(size)), size, bytesize.New(float64(limit)))
+}
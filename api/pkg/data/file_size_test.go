@@ -0,0 +1,30 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestGetSessionFileSize(t *testing.T) {
+	interactions := []*types.Interaction{
+		{Files: []string{"a.txt", "b.txt"}, FileSizes: map[string]int64{"a.txt": 100, "b.txt": 50}},
+		// uploaded before sizes were recorded
+		{Files: []string{"c.txt"}},
+		{Files: []string{"d.txt"}, FileSizes: map[string]int64{"d.txt": 25}},
+	}
+	assert.Equal(t, int64(175), GetSessionFileSize(interactions))
+	assert.Equal(t, int64(0), GetSessionFileSize(nil))
+}
+
+func TestCheckSessionFileSize(t *testing.T) {
+	assert.NoError(t, CheckSessionFileSize(100, 0))
+	assert.NoError(t, CheckSessionFileSize(100, 100))
+
+	err := CheckSessionFileSize(3*1024*1024*1024, 1024*1024*1024)
+	require.ErrorIs(t, err, ErrDatasetTooLarge)
+	assert.Equal(t, "dataset exceeds limit: the session files total 3.00GB (3221225472 bytes) but the limit is 1.00GB", err.Error())
+}
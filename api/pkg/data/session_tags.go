@@ -0,0 +1,62 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+const (
+	MaxSessionTagLength = 64
+	MaxSessionTags      = 20
+)
+
+// trim and lowercase the tags so "Project X" and "project x " end up the
+// same, empty tags and duplicates are dropped and the order is kept
+func NormalizeSessionTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > MaxSessionTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, MaxSessionTagLength)
+		}
+		// tags are filtered on as a comma separated list
+		if strings.Contains(tag, ",") {
+			return nil, fmt.Errorf("tag %q cannot contain a comma", tag)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized, nil
+}
+
+// the session's tags with the new ones on the end
+func AddSessionTags(session *types.Session, tags []string) ([]string, error) {
+	merged, err := NormalizeSessionTags(append(append([]string{}, session.Tags...), tags...))
+	if err != nil {
+		return nil, err
+	}
+	if len(merged) > MaxSessionTags {
+		return nil, fmt.Errorf("a session can have at most %d tags", MaxSessionTags)
+	}
+	return merged, nil
+}
+
+// the session's tags without the given one, which is normalized first so it
+// can be removed however it was typed
+func RemoveSessionTag(session *types.Session, tag string) []string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	remaining := []string{}
+	for _, existing := range session.Tags {
+		if existing != tag {
+			remaining = append(remaining, existing)
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,50 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestNormalizeSessionTags(t *testing.T) {
+	tags, err := NormalizeSessionTags([]string{" Project X ", "project x", "", "  ", "Research"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"project x", "research"}, tags)
+
+	tags, err = NormalizeSessionTags(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, tags)
+
+	_, err = NormalizeSessionTags([]string{strings.Repeat("a", MaxSessionTagLength+1)})
+	assert.Error(t, err)
+
+	_, err = NormalizeSessionTags([]string{"a,b"})
+	assert.Error(t, err)
+}
+
+func TestAddSessionTags(t *testing.T) {
+	session := &types.Session{Tags: types.SessionTags{"research"}}
+
+	tags, err := AddSessionTags(session, []string{"Research", "Project X"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"research", "project x"}, tags)
+
+	var many []string
+	for i := 0; i < MaxSessionTags; i++ {
+		many = append(many, fmt.Sprintf("tag-%d", i))
+	}
+	_, err = AddSessionTags(session, many)
+	assert.Error(t, err)
+}
+
+func TestRemoveSessionTag(t *testing.T) {
+	session := &types.Session{Tags: types.SessionTags{"research", "project x"}}
+
+	assert.Equal(t, []string{"research"}, RemoveSessionTag(session, " Project X"))
+	assert.Equal(t, []string{"research", "project x"}, RemoveSessionTag(session, "missing"))
+}
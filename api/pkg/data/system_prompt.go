@@ -0,0 +1,28 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// resolve the template variables in a system prompt - referring to a
+// variable we don't know about is an error rather than an empty string
+// so a typo doesn't quietly end up in front of the model
+func RenderSystemPrompt(prompt string, values types.SystemPromptValues) (string, error) {
+	if !strings.Contains(prompt, "{{") {
+		return prompt, nil
+	}
+	tmpl, err := template.New("system_prompt").Option("missingkey=error").Parse(prompt)
+	if err != nil {
+		return "", fmt.Errorf("invalid system prompt template: %w", err)
+	}
+	var rendered strings.Builder
+	err = tmpl.Execute(&rendered, values)
+	if err != nil {
+		return "", fmt.Errorf("invalid system prompt template: %w", err)
+	}
+	return rendered.String(), nil
+}
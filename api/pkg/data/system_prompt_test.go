@@ -0,0 +1,39 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestRenderSystemPrompt(t *testing.T) {
+	now := time.Date(2024, 3, 14, 9, 30, 0, 0, time.UTC)
+	values := types.SystemPromptValues{
+		User: types.SystemPromptUser{ID: "user-1", Email: "foo@email.com", FullName: "Foo Bar"},
+		Now:  now,
+		Date: now.Format("2006-01-02"),
+		Session: types.SystemPromptSession{
+			ID:        "session-1",
+			ModelName: types.Model_Ollama_Mistral7b,
+		},
+	}
+
+	rendered, err := RenderSystemPrompt(`You are talking to {{ .User.FullName }} on {{ .Date }} ({{ .Now.Format "Monday" }}) using {{ .Session.ModelName }}`, values)
+	require.NoError(t, err)
+	assert.Equal(t, "You are talking to Foo Bar on 2024-03-14 (Thursday) using mistral:7b-instruct", rendered)
+
+	// no template so nothing to do
+	rendered, err = RenderSystemPrompt("You are a helpful assistant.", values)
+	require.NoError(t, err)
+	assert.Equal(t, "You are a helpful assistant.", rendered)
+
+	_, err = RenderSystemPrompt("Hello {{ .User.Nickname }}", values)
+	assert.Error(t, err)
+
+	_, err = RenderSystemPrompt("Hello {{ .User.FullName", values)
+	assert.Error(t, err)
+}
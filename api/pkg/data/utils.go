@@ -104,6 +104,25 @@ func FilterUserInteractions(interactions []*types.Interaction) []*types.Interact
 	return filtered
 }
 
+// the interactions the given runner was handed within the time window
+// (a zero after or before means no bound)
+func FilterRunnerInteractions(interactions []*types.Interaction, runnerID string, after, before time.Time) []*types.Interaction {
+	filtered := []*types.Interaction{}
+	for _, interaction := range interactions {
+		if interaction.Runner != runnerID {
+			continue
+		}
+		if !after.IsZero() && interaction.Scheduled.Before(after) {
+			continue
+		}
+		if !before.IsZero() && interaction.Scheduled.After(before) {
+			continue
+		}
+		filtered = append(filtered, interaction)
+	}
+	return filtered
+}
+
 func FilterSystemInteractions(interactions []types.Interaction) []types.Interaction {
 	filtered := []types.Interaction{}
 	for _, interaction := range interactions {
@@ -145,6 +164,50 @@ func CopyInteractionsUntil(interactions []*types.Interaction, id string) []*type
 	return copied
 }
 
+// change the message of a user interaction and drop every interaction after
+// it - a new system interaction is added for the model to answer the edited
+// message, the interactions of the session passed in are left alone
+func EditUserInteraction(session *types.Session, interactionID string, message string) (*types.Session, error) {
+	interaction, err := GetInteraction(session, interactionID)
+	if err != nil {
+		return nil, err
+	}
+	if interaction.Creator != types.CreatorTypeUser {
+		return nil, fmt.Errorf("interaction %s is not a user interaction", interactionID)
+	}
+	if interaction.Mode != types.SessionModeInference {
+		return nil, fmt.Errorf("interaction %s is not an inference interaction", interactionID)
+	}
+
+	now := time.Now()
+
+	editedInteraction := *interaction
+	editedInteraction.Message = message
+	editedInteraction.Updated = now
+
+	interactions := CopyInteractionsUntil(session.Interactions, interactionID)
+	interactions[len(interactions)-1] = &editedInteraction
+
+	systemInteraction := &types.Interaction{
+		ID:       system.GenerateUUID(),
+		Created:  now,
+		Updated:  now,
+		Creator:  types.CreatorTypeSystem,
+		Mode:     types.SessionModeInference,
+		Message:  "",
+		Files:    []string{},
+		State:    types.InteractionStateWaiting,
+		Finished: false,
+		Metadata: map[string]string{},
+	}
+
+	edited := *session
+	edited.Interactions = append(interactions, systemInteraction)
+	edited.Updated = now
+
+	return &edited, nil
+}
+
 // update the most recent system interaction
 
 type InteractionUpdater func(*types.Interaction) (*types.Interaction, error)
@@ -209,12 +272,17 @@ func GetSessionSummary(session *types.Session) (*types.SessionSummary, error) {
 		return nil, err
 	}
 	userInteraction, err := GetUserInteraction(session)
-	if err != nil {
+	// inference sessions can be started with only a system prompt
+	if err != nil && (session.Mode != types.SessionModeInference || session.Metadata.SystemPrompt == "") {
 		return nil, err
 	}
 	summary := ""
 	if session.Mode == types.SessionModeInference {
-		summary = userInteraction.Message
+		if userInteraction != nil {
+			summary = userInteraction.Message
+		} else {
+			summary = session.Metadata.SystemPrompt
+		}
 	} else if session.Mode == types.SessionModeFinetune {
 		summary = fmt.Sprintf("fine tuning on %d files", len(userInteraction.Files))
 	} else {
@@ -235,9 +303,22 @@ func GetSessionSummary(session *types.Session) (*types.SessionSummary, error) {
 		Completed:     systemInteraction.Completed,
 		Summary:       summary,
 		Priority:      session.Metadata.Priority,
+		Usage:         GetSessionUsage(session),
+		Tags:          session.Tags,
 	}, nil
 }
 
+// add up the token usage of every interaction in the session
+func GetSessionUsage(session *types.Session) types.Usage {
+	usage := types.Usage{}
+	for _, interaction := range session.Interactions {
+		usage.PromptTokens += interaction.Usage.PromptTokens
+		usage.CompletionTokens += interaction.Usage.CompletionTokens
+		usage.TotalTokens += interaction.Usage.TotalTokens
+	}
+	return usage
+}
+
 func GetHelixVersion() string {
 	helixVersion := "<unknown>"
 	info, ok := debug.ReadBuildInfo()
@@ -282,6 +363,74 @@ func CloneSession(
 	return &session, nil
 }
 
+// the interactions that make up the conversation - fine tuning interactions
+// are about uploading data rather than chatting so they are left out
+func getConversationInteractions(session *types.Session) []*types.Interaction {
+	interactions := []*types.Interaction{}
+	for _, interaction := range session.Interactions {
+		if interaction.Mode == types.SessionModeFinetune || interaction.Message == "" {
+			continue
+		}
+		interactions = append(interactions, interaction)
+	}
+	return interactions
+}
+
+// user interactions are from the user and everything else is the model
+// talking - this matches what we send to the model at inference time
+func getConversationRole(creator types.CreatorType) string {
+	if creator == types.CreatorTypeUser {
+		return "user"
+	}
+	return "assistant"
+}
+
+func SessionToOpenAIExport(session *types.Session) *types.OpenAISessionExport {
+	messages := []types.OpenAIMessage{}
+	if session.Metadata.SystemPrompt != "" {
+		messages = append(messages, types.OpenAIMessage{
+			Role:    "system",
+			Content: session.Metadata.SystemPrompt,
+		})
+	}
+	for _, interaction := range getConversationInteractions(session) {
+		messages = append(messages, types.OpenAIMessage{
+			Role:    getConversationRole(interaction.Creator),
+			Content: interaction.Message,
+		})
+	}
+	return &types.OpenAISessionExport{
+		SessionID: session.ID,
+		Name:      session.Name,
+		Model:     string(session.ModelName),
+		Messages:  messages,
+	}
+}
+
+// the same from: human/gpt shape we use for fine tuning data
+func SessionToShareGPTExport(session *types.Session) *types.DataPrepTextQuestion {
+	conversations := []types.DataPrepTextQuestionPart{}
+	if session.Metadata.SystemPrompt != "" {
+		conversations = append(conversations, types.DataPrepTextQuestionPart{
+			From:  "system",
+			Value: session.Metadata.SystemPrompt,
+		})
+	}
+	for _, interaction := range getConversationInteractions(session) {
+		from := "gpt"
+		if interaction.Creator == types.CreatorTypeUser {
+			from = "human"
+		}
+		conversations = append(conversations, types.DataPrepTextQuestionPart{
+			From:  from,
+			Value: interaction.Message,
+		})
+	}
+	return &types.DataPrepTextQuestion{
+		Conversations: conversations,
+	}
+}
+
 func OwnerContextFromRequestContext(ctx types.RequestContext) types.OwnerContext {
 	return types.OwnerContext{
 		Owner:     ctx.Owner,
@@ -104,3 +104,154 @@ func TestGetLastInteractions(t *testing.T) {
 		})
 	}
 }
+
+func TestCloneSession(t *testing.T) {
+	oldSession := types.Session{
+		ID:    "old",
+		Owner: "alice",
+		Metadata: types.SessionMetadata{
+			Shared: true,
+		},
+		Interactions: []*types.Interaction{
+			{ID: "1"},
+			{ID: "2"},
+			{ID: "3"},
+		},
+	}
+
+	session, err := CloneSession(oldSession, "2", OwnerContext("bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.ID == oldSession.ID {
+		t.Errorf("expected a new session ID")
+	}
+	if session.Owner != "bob" {
+		t.Errorf("expected clone to be owned by bob, got %s", session.Owner)
+	}
+	// it's up to the caller whether the clone stays shared
+	if !session.Metadata.Shared {
+		t.Errorf("expected clone to keep the shared flag")
+	}
+	if len(session.Interactions) != 2 || session.Interactions[1].ID != "2" {
+		t.Errorf("expected interactions up to 2, got %d", len(session.Interactions))
+	}
+
+	wantOrigin := types.SessionOrigin{
+		Type:                types.SessionOriginTypeCloned,
+		ClonedSessionID:     "old",
+		ClonedInteractionID: "2",
+	}
+	if !reflect.DeepEqual(session.Metadata.Origin, wantOrigin) {
+		t.Errorf("origin = %+v, want %+v", session.Metadata.Origin, wantOrigin)
+	}
+	if !oldSession.Metadata.Shared {
+		t.Errorf("expected the original session to still be shared")
+	}
+}
+
+func TestSessionExport(t *testing.T) {
+	session := &types.Session{
+		ID:        "session",
+		Name:      "chat",
+		ModelName: types.Model_Ollama_Mistral7b,
+		LoraDir:   "/internal/lora",
+		Metadata: types.SessionMetadata{
+			SystemPrompt: "be nice",
+		},
+		Interactions: []*types.Interaction{
+			{ID: "1", Creator: types.CreatorTypeUser, Mode: types.SessionModeFinetune, Message: "some files"},
+			{ID: "2", Creator: types.CreatorTypeUser, Mode: types.SessionModeInference, Message: "hello"},
+			{ID: "3", Creator: types.CreatorTypeSystem, Mode: types.SessionModeInference, Message: "hi there"},
+		},
+	}
+
+	wantOpenAI := &types.OpenAISessionExport{
+		SessionID: "session",
+		Name:      "chat",
+		Model:     string(types.Model_Ollama_Mistral7b),
+		Messages: []types.OpenAIMessage{
+			{Role: "system", Content: "be nice"},
+			{Role: "user", Content: "hello"},
+			{Role: "assistant", Content: "hi there"},
+		},
+	}
+	if got := SessionToOpenAIExport(session); !reflect.DeepEqual(got, wantOpenAI) {
+		t.Errorf("SessionToOpenAIExport() = %+v, want %+v", got, wantOpenAI)
+	}
+
+	wantShareGPT := &types.DataPrepTextQuestion{
+		Conversations: []types.DataPrepTextQuestionPart{
+			{From: "system", Value: "be nice"},
+			{From: "human", Value: "hello"},
+			{From: "gpt", Value: "hi there"},
+		},
+	}
+	if got := SessionToShareGPTExport(session); !reflect.DeepEqual(got, wantShareGPT) {
+		t.Errorf("SessionToShareGPTExport() = %+v, want %+v", got, wantShareGPT)
+	}
+}
+
+func TestEditUserInteraction(t *testing.T) {
+	session := &types.Session{
+		ID: "session",
+		Interactions: []*types.Interaction{
+			{ID: "1", Creator: types.CreatorTypeUser, Mode: types.SessionModeInference, Message: "hello"},
+			{ID: "2", Creator: types.CreatorTypeSystem, Mode: types.SessionModeInference, Message: "hi there", State: types.InteractionStateComplete},
+			{ID: "3", Creator: types.CreatorTypeUser, Mode: types.SessionModeInference, Message: "what is 2 + 2?"},
+			{ID: "4", Creator: types.CreatorTypeSystem, Mode: types.SessionModeInference, Message: "5", State: types.InteractionStateComplete},
+		},
+	}
+
+	edited, err := EditUserInteraction(session, "1", "good morning")
+	if err != nil {
+		t.Fatalf("EditUserInteraction() error = %v", err)
+	}
+	if len(edited.Interactions) != 2 {
+		t.Fatalf("EditUserInteraction() has %d interactions, want 2", len(edited.Interactions))
+	}
+	if edited.Interactions[0].ID != "1" || edited.Interactions[0].Message != "good morning" {
+		t.Errorf("EditUserInteraction() user interaction = %+v", edited.Interactions[0])
+	}
+	systemInteraction := edited.Interactions[1]
+	if systemInteraction.Creator != types.CreatorTypeSystem || systemInteraction.State != types.InteractionStateWaiting || systemInteraction.Message != "" {
+		t.Errorf("EditUserInteraction() system interaction = %+v", systemInteraction)
+	}
+
+	// the original conversation is untouched so it can be kept
+	if len(session.Interactions) != 4 || session.Interactions[0].Message != "hello" {
+		t.Errorf("EditUserInteraction() changed the original session: %+v", session.Interactions[0])
+	}
+
+	if _, err := EditUserInteraction(session, "2", "hello"); err == nil {
+		t.Errorf("EditUserInteraction() of a system interaction should fail")
+	}
+	if _, err := EditUserInteraction(session, "5", "hello"); err == nil {
+		t.Errorf("EditUserInteraction() of a missing interaction should fail")
+	}
+}
+
+func TestGetSessionSummary_SystemPromptOnly(t *testing.T) {
+	session := &types.Session{
+		ID:   "session",
+		Mode: types.SessionModeInference,
+		Interactions: []*types.Interaction{
+			{ID: "1", Creator: types.CreatorTypeSystem, Mode: types.SessionModeInference, State: types.InteractionStateWaiting},
+		},
+		Metadata: types.SessionMetadata{SystemPrompt: "greet the user"},
+	}
+
+	summary, err := GetSessionSummary(session)
+	if err != nil {
+		t.Fatalf("GetSessionSummary() error = %v", err)
+	}
+	if summary.Summary != "greet the user" || summary.InteractionID != "1" {
+		t.Errorf("GetSessionSummary() = %+v", summary)
+	}
+
+	session.Metadata.SystemPrompt = ""
+	if _, err := GetSessionSummary(session); err == nil {
+		t.Errorf("GetSessionSummary() of a session with no messages should fail")
+	}
+}
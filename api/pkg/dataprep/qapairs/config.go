@@ -0,0 +1,171 @@
+package qapairs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the baked in prompt suite and targets
+func DefaultConfig() (*Config, error) {
+	var config Config
+	err := yaml.Unmarshal([]byte(qapairConfig), &config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the built in qapair config: %w", err)
+	}
+	return &config, nil
+}
+
+// read a prompt suite in the same format as qapair_config.yaml - an empty
+// path gives the built in config
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		return DefaultConfig()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading qapair config %s: %w", path, err)
+	}
+	return ParseConfig(data)
+}
+
+// parse a custom prompt suite and lay it over the built in one - the prompts
+// and targets replace the built in ones if there are any and anything else
+// left out keeps its built in value
+func ParseConfig(data []byte) (*Config, error) {
+	config, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var custom Config
+	err = yaml.Unmarshal(data, &custom)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing qapair config: %w", err)
+	}
+
+	if len(custom.Prompts) > 0 {
+		config.Prompts = custom.Prompts
+	}
+	if len(custom.Targets) > 0 {
+		config.Targets = custom.Targets
+		// the built in default is unlikely to be one of the new targets
+		config.DefaultTarget = ""
+		if len(custom.Targets) == 1 {
+			config.DefaultTarget = custom.Targets[0].Name
+		}
+	}
+	if custom.DefaultTarget != "" {
+		config.DefaultTarget = custom.DefaultTarget
+	}
+	if len(custom.Texts) > 0 {
+		config.Texts = custom.Texts
+	}
+	if custom.Concurrency > 0 {
+		config.Concurrency = custom.Concurrency
+	}
+	if custom.ChunkSize > 0 {
+		config.ChunkSize = custom.ChunkSize
+	}
+	if custom.NumQuestions > 0 {
+		config.NumQuestions = custom.NumQuestions
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// every prompt and target needs a unique name, the prompt templates have to
+// parse and the default target has to be one of the targets
+func (config *Config) Validate() error {
+	prompts := map[string]bool{}
+	for i, prompt := range config.Prompts {
+		if prompt.Name == "" {
+			return fmt.Errorf("qapair prompt %d has no name", i+1)
+		}
+		if prompts[prompt.Name] {
+			return fmt.Errorf("there is more than one qapair prompt called %s", prompt.Name)
+		}
+		prompts[prompt.Name] = true
+		if prompt.User == "" {
+			return fmt.Errorf("qapair prompt %s has no user prompt", prompt.Name)
+		}
+		_, err := template.New("systemPrompt").Parse(prompt.System)
+		if err != nil {
+			return fmt.Errorf("qapair prompt %s has an invalid system prompt: %w", prompt.Name, err)
+		}
+		_, err = template.New("userPrompt").Parse(prompt.User)
+		if err != nil {
+			return fmt.Errorf("qapair prompt %s has an invalid user prompt: %w", prompt.Name, err)
+		}
+	}
+
+	targets := map[string]bool{}
+	for i, target := range config.Targets {
+		if target.Name == "" {
+			return fmt.Errorf("qapair target %d has no name", i+1)
+		}
+		if targets[target.Name] {
+			return fmt.Errorf("there is more than one qapair target called %s", target.Name)
+		}
+		targets[target.Name] = true
+		if target.ApiUrl == "" || target.Model == "" {
+			return fmt.Errorf("qapair target %s needs an api_url and a model", target.Name)
+		}
+		if target.ContextLength < 0 {
+			return fmt.Errorf("qapair target %s has a negative context_length", target.Name)
+		}
+	}
+
+	if config.DefaultTarget != "" && !targets[config.DefaultTarget] {
+		return fmt.Errorf("the default qapair target %s is not one of the targets: %s", config.DefaultTarget, strings.Join(config.TargetNames(), ", "))
+	}
+
+	if config.Concurrency <= 0 || config.ChunkSize <= 0 || config.NumQuestions <= 0 {
+		return fmt.Errorf("qapair concurrency, chunk_size and num_questions must be more than zero")
+	}
+
+	return nil
+}
+
+func (config *Config) PromptNames() []string {
+	names := []string{}
+	for _, prompt := range config.Prompts {
+		names = append(names, prompt.Name)
+	}
+	return names
+}
+
+func (config *Config) TargetNames() []string {
+	names := []string{}
+	for _, target := range config.Targets {
+		names = append(names, target.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (config *Config) FindPrompt(name string) (Prompt, error) {
+	for _, prompt := range config.Prompts {
+		if prompt.Name == name {
+			return prompt, nil
+		}
+	}
+	return Prompt{}, fmt.Errorf("unknown qapair prompt %q, the prompts are: %s", name, strings.Join(config.PromptNames(), ", "))
+}
+
+func (config *Config) FindTarget(name string) (Target, error) {
+	for _, target := range config.Targets {
+		if target.Name == name {
+			return target, nil
+		}
+	}
+	return Target{}, fmt.Errorf("unknown qapair target %q, the targets are: %s", name, strings.Join(config.TargetNames(), ", "))
+}
@@ -0,0 +1,87 @@
+package qapairs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultConfig(t *testing.T) {
+	config, err := DefaultConfig()
+	require.NoError(t, err)
+	require.NoError(t, config.Validate())
+	assert.Equal(t, "together-mixtral", config.DefaultTarget)
+	assert.Contains(t, config.PromptNames(), "simple-quiz")
+}
+
+func TestLoadConfig(t *testing.T) {
+	config, err := LoadConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, "together-mixtral", config.DefaultTarget)
+
+	path := filepath.Join(t.TempDir(), "qapairs.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+num_questions: 5
+prompts:
+  - name: faq
+    system: Write an FAQ.
+    user: "{{.DocumentChunk}}"
+targets:
+  - name: local
+    api_url: http://localhost:11434/v1
+    model: llama3
+`), 0644))
+
+	config, err = LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"faq"}, config.PromptNames())
+	assert.Equal(t, []string{"local"}, config.TargetNames())
+	// the only target is the default one
+	assert.Equal(t, "local", config.DefaultTarget)
+	assert.Equal(t, 5, config.NumQuestions)
+	// left out so it keeps the built in value
+	assert.Equal(t, 16384, config.ChunkSize)
+
+	_, err = LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestParseConfig_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"not_yaml":       "prompts: [",
+		"duplicate":      "prompts: [{name: a, user: x}, {name: a, user: y}]",
+		"no_name":        "prompts: [{user: x}]",
+		"no_user":        "prompts: [{name: a, system: x}]",
+		"bad_template":   "prompts: [{name: a, user: '{{.DocumentChunk'}]",
+		"target_no_url":  "targets: [{name: a, model: m}]",
+		"target_context": "targets: [{name: a, api_url: u, model: m, context_length: -1}]",
+		"unknown_target": "default_target: nope",
+		"no_default":     "targets: [{name: a, api_url: u, model: m}, {name: b, api_url: u, model: m}]\ndefault_target: c",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestConfig_FindPrompt(t *testing.T) {
+	config, err := DefaultConfig()
+	require.NoError(t, err)
+
+	prompt, err := config.FindPrompt("simple-quiz")
+	require.NoError(t, err)
+	assert.Equal(t, "simple-quiz", prompt.Name)
+
+	// a typo lists what there is
+	_, err = config.FindPrompt("simple-quizz")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "simple-quiz,")
+
+	_, err = config.FindTarget("together-mixtrall")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "together-mixtral")
+}
@@ -0,0 +1,50 @@
+package qapairs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// how much of the documents and the model's answers go into the logs (and
+// the runs/ files) - the timings and token counts are always logged
+type LogContent string
+
+const (
+	// a hash and the length, enough to tell chunks apart - the default
+	LogContentHash LogContent = "hash"
+	// only the length
+	LogContentNone LogContent = "none"
+	// everything, for debugging data prep on documents that aren't sensitive
+	LogContentFull LogContent = "full"
+)
+
+func ValidateLogContent(logContent string, acceptEmpty bool) (LogContent, error) {
+	switch logContent {
+	case string(LogContentHash):
+		return LogContentHash, nil
+	case string(LogContentNone):
+		return LogContentNone, nil
+	case string(LogContentFull):
+		return LogContentFull, nil
+	default:
+		if acceptEmpty && logContent == "" {
+			return LogContentHash, nil
+		}
+		return "", fmt.Errorf("invalid data prep log content '%s', must be %s, %s or %s", logContent, LogContentHash, LogContentNone, LogContentFull)
+	}
+}
+
+// what of the content can be logged, anything but full (including empty)
+// keeps the content itself out
+func (l LogContent) Redact(content string) string {
+	switch l {
+	case LogContentFull:
+		return content
+	case LogContentNone:
+		return fmt.Sprintf("[redacted, %d chars]", len(content))
+	default:
+		sum := sha256.Sum256([]byte(content))
+		return fmt.Sprintf("[sha256:%s, %d chars]", hex.EncodeToString(sum[:])[:12], len(content))
+	}
+}
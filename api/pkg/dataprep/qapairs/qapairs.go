@@ -6,7 +6,7 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
 	"strings"
 	"text/template"
@@ -14,6 +14,7 @@ import (
 
 	"github.com/helixml/helix/api/pkg/types"
 	openai "github.com/lukemarsden/go-openai2"
+	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
@@ -25,6 +26,23 @@ type Target struct {
 	ApiUrl       string `yaml:"api_url"`
 	Model        string `yaml:"model"`
 	TokenFromEnv string `yaml:"token_from_env"`
+	// set directly for targets that don't come from the yaml config e.g. a
+	// self hosted server, empty means we send no Authorization header
+	Token string `yaml:"-"`
+	// how many tokens the model takes in and gives back in one request, a
+	// chunk and the questions it generates have to fit - zero if we don't
+	// know so any chunk size goes
+	ContextLength int `yaml:"context_length"`
+}
+
+func (target Target) GetToken() string {
+	if target.Token != "" {
+		return target.Token
+	}
+	if target.TokenFromEnv == "" {
+		return ""
+	}
+	return os.Getenv(target.TokenFromEnv)
 }
 
 type Prompt struct {
@@ -53,33 +71,26 @@ type Log struct {
 }
 
 type Config struct {
-	Prompts      []Prompt `yaml:"prompts"`
-	Targets      []Target `yaml:"targets"`
-	Texts        []Text   `yaml:"texts"`
-	Concurrency  int      `yaml:"concurrency"`
-	ChunkSize    int      `yaml:"chunk_size"`
-	NumQuestions int      `yaml:"num_questions"`
+	Prompts []Prompt `yaml:"prompts"`
+	Targets []Target `yaml:"targets"`
+	// the target data prep uses when it isn't told which one
+	DefaultTarget string `yaml:"default_target"`
+	Texts         []Text `yaml:"texts"`
+	Concurrency   int    `yaml:"concurrency"`
+	ChunkSize     int    `yaml:"chunk_size"`
+	NumQuestions  int    `yaml:"num_questions"`
 }
 
-// TODO: maybe optimize (or at least factor!) to not read the yaml on every call
-
 func AllPrompts() ([]string, error) {
-	var config Config
-	err := yaml.Unmarshal([]byte(qapairConfig), &config)
+	config, err := DefaultConfig()
 	if err != nil {
 		return nil, err
 	}
-
-	var result []string
-	for _, prompt := range config.Prompts {
-		result = append(result, prompt.Name)
-	}
-	return result, nil
+	return config.PromptNames(), nil
 }
 
 func GetNumQuestions() (int, error) {
-	var config Config
-	err := yaml.Unmarshal([]byte(qapairConfig), &config)
+	config, err := DefaultConfig()
 	if err != nil {
 		return 0, err
 	}
@@ -87,8 +98,7 @@ func GetNumQuestions() (int, error) {
 }
 
 func GetConcurrency() (int, error) {
-	var config Config
-	err := yaml.Unmarshal([]byte(qapairConfig), &config)
+	config, err := DefaultConfig()
 	if err != nil {
 		return 0, err
 	}
@@ -96,8 +106,7 @@ func GetConcurrency() (int, error) {
 }
 
 func GetChunkSize() (int, error) {
-	var config Config
-	err := yaml.Unmarshal([]byte(qapairConfig), &config)
+	config, err := DefaultConfig()
 	if err != nil {
 		return 0, err
 	}
@@ -105,43 +114,25 @@ func GetChunkSize() (int, error) {
 }
 
 func FindPrompt(name string) (Prompt, error) {
-	var config Config
-	err := yaml.Unmarshal([]byte(qapairConfig), &config)
+	config, err := DefaultConfig()
 	if err != nil {
-		log.Fatal(err)
+		return Prompt{}, err
 	}
-
-	for _, prompt := range config.Prompts {
-		if prompt.Name == name {
-			return prompt, nil
-		}
-	}
-
-	return Prompt{}, fmt.Errorf("could not find prompt with name %s", name)
+	return config.FindPrompt(name)
 }
 
 func FindTarget(name string) (Target, error) {
-	var config Config
-	err := yaml.Unmarshal([]byte(qapairConfig), &config)
+	config, err := DefaultConfig()
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	for _, target := range config.Targets {
-		if target.Name == name {
-			return target, nil
-		}
+		return Target{}, err
 	}
-
-	log.Fatalf("Could not find target with name %s", name)
-	return Target{}, fmt.Errorf("could not find target with name %s", name)
+	return config.FindTarget(name)
 }
 
 func Run(targetFilter, promptFilter, textFilter []string) {
-	var config Config
-	err := yaml.Unmarshal([]byte(qapairConfig), &config)
+	config, err := DefaultConfig()
 	if err != nil {
-		fmt.Println("Error:", err)
+		log.Error().Err(err).Msg("failed to load qapairs config")
 		return
 	}
 
@@ -195,14 +186,15 @@ func Run(targetFilter, promptFilter, textFilter []string) {
 		for _, prompt := range filteredPrompts {
 			for _, text := range filteredTexts {
 				fmt.Printf("Running helix qapairs --target=\"%s\" --prompt=\"%s\" --text=\"%s\"\n", target.Name, prompt.Name, text.Name)
-				resp, err := Query(target, prompt, text, "", "", 0)
+				// this is for working on the prompts against the example texts
+				resp, err := Query(context.Background(), target, prompt, text, "", "", 0, LogContentFull)
 				if err != nil {
-					fmt.Println("Error:", err)
+					log.Error().Err(err).Msg("failed to get qapairs")
 					return
 				}
 				bs, err := yaml.Marshal(resp)
 				if err != nil {
-					fmt.Println("Error:", err)
+					log.Error().Err(err).Msg("failed to marshal qapairs")
 					return
 				}
 				fmt.Println(string(bs))
@@ -218,7 +210,31 @@ type TemplateData struct {
 	DocumentChunk   string
 }
 
-func Query(target Target, prompt Prompt, text Text, documentID, documentGroupID string, numQuestions int) ([]types.DataPrepTextQuestionRaw, error) {
+// fill in the system and user templates of the prompt with the chunk we are sending
+func RenderPrompt(prompt Prompt, tmplData TemplateData) (string, string, error) {
+	tmpl := template.Must(template.New("systemPrompt").Parse(prompt.System))
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, tmplData)
+	if err != nil {
+		return "", "", err
+	}
+
+	systemPrompt := buf.String()
+
+	tmpl = template.Must(template.New("userPrompt").Parse(prompt.User))
+	var buf2 bytes.Buffer
+	err = tmpl.Execute(&buf2, tmplData)
+	if err != nil {
+		return "", "", err
+	}
+
+	return systemPrompt, buf2.String(), nil
+}
+
+// cancelling the context stops the request to the target and is the only
+// error from the model that is returned rather than logged - logContent says
+// how much of the text and the answer can be logged and kept in runs/
+func Query(ctx context.Context, target Target, prompt Prompt, text Text, documentID, documentGroupID string, numQuestions int, logContent LogContent) ([]types.DataPrepTextQuestionRaw, error) {
 	// Perform the query for the given target and prompt
 
 	var contents string
@@ -245,48 +261,39 @@ func Query(target Target, prompt Prompt, text Text, documentID, documentGroupID
 		}
 	}
 
-	tmplData := TemplateData{
+	systemPrompt, userPrompt, err := RenderPrompt(prompt, TemplateData{
 		NumQuestions:    numQuestions,
 		DocumentID:      documentID,
 		DocumentGroupID: documentGroupID,
 		DocumentChunk:   contents,
-	}
-
-	tmpl := template.Must(template.New("systemPrompt").Parse(prompt.System))
-	var buf bytes.Buffer
-	err = tmpl.Execute(&buf, tmplData)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	systemPrompt := buf.String()
-
-	tmpl = template.Must(template.New("userPrompt").Parse(prompt.User))
-	var buf2 bytes.Buffer
-	err = tmpl.Execute(&buf2, tmplData)
-	if err != nil {
-		return nil, err
-	}
-
-	userPrompt := buf2.String()
-
 	startTime := time.Now()
 	debug := fmt.Sprintf("prompt %s", prompt.Name)
 	// try not enforcing json schema initially, only retry if we fail to parse
-	resp, err := chatWithModel(target.ApiUrl, os.Getenv(target.TokenFromEnv), target.Model, systemPrompt, userPrompt, debug, nil)
+	resp, err := chatWithModel(ctx, target.ApiUrl, target.GetToken(), target.Model, systemPrompt, userPrompt, debug, nil, logContent)
 	if err != nil {
-		log.Printf("ChatCompletion error non-JSON mode, trying again (%s): %v\n", debug, err)
-		resp, err = chatWithModel(target.ApiUrl, os.Getenv(target.TokenFromEnv), target.Model, systemPrompt, userPrompt, debug, prompt.JsonSchema)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Warn().Err(err).Msgf("ChatCompletion error non-JSON mode, trying again (%s)", debug)
+		resp, err = chatWithModel(ctx, target.ApiUrl, target.GetToken(), target.Model, systemPrompt, userPrompt, debug, prompt.JsonSchema, logContent)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if err != nil {
-			log.Printf("ChatCompletion error JSON mode, giving up, but not propagating the error further for now. (%s): %v\n", debug, err)
+			log.Error().Err(err).Msgf("ChatCompletion error JSON mode, giving up, but not propagating the error further for now. (%s)", debug)
 			latency := time.Since(startTime).Milliseconds()
-			log.Printf("Took: %.2f seconds. FAILED", float32(latency)/1000)
+			log.Debug().Msgf("Took: %.2f seconds. FAILED", float32(latency)/1000)
 			return []types.DataPrepTextQuestionRaw{}, nil
 		}
 	}
 	latency := time.Since(startTime).Milliseconds()
 
-	log.Printf("Took: %.2f seconds", float32(latency)/1000)
+	log.Debug().Msgf("Took: %.2f seconds", float32(latency)/1000)
 
 	err = os.MkdirAll("runs", os.ModePerm)
 	if err != nil {
@@ -305,10 +312,10 @@ func Query(target Target, prompt Prompt, text Text, documentID, documentGroupID
 		Date:      time.Now().String(),
 		ApiUrl:    target.ApiUrl,
 		Model:     target.Model,
-		System:    systemPrompt,
-		User:      userPrompt,
-		Text:      contents,
-		Result:    string(respBytes),
+		System:    logContent.Redact(systemPrompt),
+		User:      logContent.Redact(userPrompt),
+		Text:      logContent.Redact(contents),
+		Result:    logContent.Redact(string(respBytes)),
 		LatencyMs: latency,
 	}
 
@@ -333,9 +340,22 @@ func loadFile(filePath string) (string, error) {
 	return string(content), nil
 }
 
-func chatWithModel(apiUrl, token, model, system, user, debug string, jsonSchema map[string]interface{}) ([]types.DataPrepTextQuestionRaw, error) {
+// the openai client always sends "Authorization: Bearer <token>" which some
+// self hosted servers reject when the token is empty so we take it off
+type noAuthTransport struct{}
+
+func (noAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Del("Authorization")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func chatWithModel(ctx context.Context, apiUrl, token, model, system, user, debug string, jsonSchema map[string]interface{}, logContent LogContent) ([]types.DataPrepTextQuestionRaw, error) {
 	cfg := openai.DefaultConfig(token)
 	cfg.BaseURL = apiUrl
+	if token == "" {
+		cfg.HTTPClient = &http.Client{Transport: noAuthTransport{}}
+	}
 	client := openai.NewClientWithConfig(cfg)
 
 	req := openai.ChatCompletionRequest{
@@ -359,15 +379,18 @@ func chatWithModel(apiUrl, token, model, system, user, debug string, jsonSchema
 		}
 	}
 
-	resp, err := client.CreateChatCompletion(context.Background(), req)
+	resp, err := client.CreateChatCompletion(ctx, req)
 	if err != nil {
-		fmt.Printf("ChatCompletion error (%s): %v\n", debug, err)
+		log.Debug().Err(err).Msgf("ChatCompletion error (%s)", debug)
 		return nil, err
 	}
 
 	answer := resp.Choices[0].Message.Content
 
-	log.Printf("Raw response (%s) to %s json=%t: %s\n", resp.ID, debug, jsonSchema != nil, answer)
+	log.Debug().
+		Int("prompt_tokens", resp.Usage.PromptTokens).
+		Int("completion_tokens", resp.Usage.CompletionTokens).
+		Msgf("Raw response (%s) to %s json=%t: %s", resp.ID, debug, jsonSchema != nil, logContent.Redact(answer))
 
 	if jsonSchema == nil {
 		if strings.Contains(answer, "```json") {
@@ -383,7 +406,12 @@ func chatWithModel(apiUrl, token, model, system, user, debug string, jsonSchema
 		answer = strings.Replace(answer, "\\", "", -1)
 	}
 
-	return TryVariousJSONFormats(answer, fmt.Sprintf("%s respID=%s", debug, resp.ID))
+	questions, err := TryVariousJSONFormats(answer, fmt.Sprintf("%s respID=%s", debug, resp.ID))
+	// the error has the whole answer in it and it ends up in the logs
+	if err != nil && logContent != LogContentFull {
+		return nil, fmt.Errorf("error parsing JSON (%s respID=%s): %s", debug, resp.ID, logContent.Redact(answer))
+	}
+	return questions, err
 }
 
 // for prompt engineering purposes, the LLMs output various formats. Try all of them:
@@ -0,0 +1,107 @@
+package qapairs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testChatAnswer = `[{"question": "What is it?", "answer": "A test."}]`
+
+func newTestChatServer(t *testing.T, authHeaders *[]string, answer string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*authHeaders = append(*authHeaders, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": "chatcmpl-1",
+			"choices": []map[string]interface{}{
+				{
+					"index": 0,
+					"message": map[string]interface{}{
+						"role":    "assistant",
+						"content": answer,
+					},
+				},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestChatWithModel_NoToken(t *testing.T) {
+	authHeaders := []string{}
+	server := newTestChatServer(t, &authHeaders, testChatAnswer)
+
+	pairs, err := chatWithModel(context.Background(), server.URL+"/v1", "", "local-model", "system", "user", "test", nil, LogContentHash)
+	require.NoError(t, err)
+	require.Len(t, pairs, 1)
+	assert.Equal(t, "What is it?", pairs[0].Question)
+	assert.Equal(t, []string{""}, authHeaders)
+}
+
+func TestChatWithModel_Token(t *testing.T) {
+	authHeaders := []string{}
+	server := newTestChatServer(t, &authHeaders, testChatAnswer)
+
+	_, err := chatWithModel(context.Background(), server.URL+"/v1", "secret", "local-model", "system", "user", "test", nil, LogContentHash)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Bearer secret"}, authHeaders)
+}
+
+func TestTarget_GetToken(t *testing.T) {
+	t.Setenv("QAPAIRS_TEST_TOKEN", "from-env")
+
+	assert.Equal(t, "from-env", Target{TokenFromEnv: "QAPAIRS_TEST_TOKEN"}.GetToken())
+	assert.Equal(t, "direct", Target{Token: "direct", TokenFromEnv: "QAPAIRS_TEST_TOKEN"}.GetToken())
+	assert.Equal(t, "", Target{}.GetToken())
+}
+
+func TestChatWithModel_ParseErrorRedacted(t *testing.T) {
+	authHeaders := []string{}
+	server := newTestChatServer(t, &authHeaders, "the patient's diagnosis is confidential")
+
+	_, err := chatWithModel(context.Background(), server.URL+"/v1", "", "local-model", "system", "user", "test", nil, LogContentHash)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "diagnosis")
+
+	_, err = chatWithModel(context.Background(), server.URL+"/v1", "", "local-model", "system", "user", "test", nil, LogContentFull)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "diagnosis")
+}
+
+func TestLogContent_Redact(t *testing.T) {
+	content := "the patient's diagnosis is confidential"
+
+	assert.Equal(t, content, LogContentFull.Redact(content))
+	assert.Equal(t, "[redacted, 39 chars]", LogContentNone.Redact(content))
+
+	hashed := LogContentHash.Redact(content)
+	assert.NotContains(t, hashed, "diagnosis")
+	assert.Contains(t, hashed, "39 chars")
+	// the same chunk always hashes the same so it can be followed through the logs
+	assert.Equal(t, hashed, LogContentHash.Redact(content))
+	assert.NotEqual(t, hashed, LogContentHash.Redact("something else"))
+	// unset is the same as hash
+	assert.Equal(t, hashed, LogContent("").Redact(content))
+}
+
+func TestValidateLogContent(t *testing.T) {
+	logContent, err := ValidateLogContent("", true)
+	require.NoError(t, err)
+	assert.Equal(t, LogContentHash, logContent)
+
+	logContent, err = ValidateLogContent("full", false)
+	require.NoError(t, err)
+	assert.Equal(t, LogContentFull, logContent)
+
+	_, err = ValidateLogContent("", false)
+	assert.Error(t, err)
+	_, err = ValidateLogContent("everything", true)
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+package text
+
+import (
+	"context"
+	"sync"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// what came back from converting a single chunk - if the conversion failed
+// then Error is set and there are no questions
+type DataPrepTextChunkResult struct {
+	Chunk     *DataPrepTextSplitterChunk
+	Questions []types.DataPrepTextQuestion
+	Error     error
+}
+
+// called as each chunk finishes (never more than one at a time) so the caller
+// can save questions and report progress - returning an error stops the run
+type DataPrepTextChunkResultHandler func(result *DataPrepTextChunkResult, index int) error
+
+// runs ConvertChunk for every chunk through a pool of GetConcurrency() workers
+// the results are in the same order as the chunks and a chunk that fails to
+// convert does not stop the others, its error is kept on its result instead
+// the only errors returned are from the context or the handler and in that
+// case we stop handing out chunks straight away and the ones in flight are
+// cancelled - their results are left out and never reach the handler
+func ConvertChunks(
+	ctx context.Context,
+	generator DataPrepTextQuestionGenerator,
+	chunks []*DataPrepTextSplitterChunk,
+	handler DataPrepTextChunkResultHandler,
+) ([]*DataPrepTextChunkResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency, err := generator.GetConcurrency()
+	if err != nil {
+		return nil, err
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]*DataPrepTextChunkResult, len(chunks))
+
+	var handlerMtx sync.Mutex
+	var handlerErr error
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				chunk := chunks[i]
+				questions, err := generator.ConvertChunk(ctx, chunk.Text, chunk.Index, chunk.QuestionDocumentID(), chunk.DocumentGroupID, chunk.PromptName)
+				if ctx.Err() != nil {
+					continue
+				}
+				result := &DataPrepTextChunkResult{
+					Chunk:     chunk,
+					Questions: questions,
+					Error:     err,
+				}
+				results[i] = result
+
+				if handler == nil {
+					continue
+				}
+
+				handlerMtx.Lock()
+				// the run may have been cancelled while we waited for the lock
+				if handlerErr == nil && ctx.Err() == nil {
+					handlerErr = handler(result, i)
+					if handlerErr != nil {
+						cancel()
+					}
+				}
+				handlerMtx.Unlock()
+			}
+		}()
+	}
+
+schedule:
+	for i := range chunks {
+		select {
+		case <-ctx.Done():
+			break schedule
+		case work <- i:
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if handlerErr != nil {
+		return results, handlerErr
+	}
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+	return results, nil
+}
@@ -0,0 +1,157 @@
+package text
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+type testQuestionGenerator struct {
+	concurrency int
+	running     int32
+	maxRunning  int32
+	failIndex   int
+	delay       time.Duration
+}
+
+func (g *testQuestionGenerator) ExpandChunks(chunks []*DataPrepTextSplitterChunk) ([]*DataPrepTextSplitterChunk, error) {
+	return chunks, nil
+}
+
+func (g *testQuestionGenerator) ConvertChunk(ctx context.Context, chunk string, index int, documentID, documentGroupID, promptName string) ([]types.DataPrepTextQuestion, error) {
+	running := atomic.AddInt32(&g.running, 1)
+	defer atomic.AddInt32(&g.running, -1)
+	for {
+		max := atomic.LoadInt32(&g.maxRunning)
+		if running <= max || atomic.CompareAndSwapInt32(&g.maxRunning, max, running) {
+			break
+		}
+	}
+	// finish later chunks first so the results come back out of order
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(time.Duration(10-index%10)*time.Millisecond + g.delay):
+	}
+	if index == g.failIndex {
+		return nil, fmt.Errorf("chunk %d failed", index)
+	}
+	return []types.DataPrepTextQuestion{{
+		Conversations: []types.DataPrepTextQuestionPart{{From: "human", Value: chunk}},
+	}}, nil
+}
+
+func (g *testQuestionGenerator) GetConcurrency() (int, error) {
+	return g.concurrency, nil
+}
+
+func (g *testQuestionGenerator) GetChunkSize() (int, error) {
+	return 100, nil
+}
+
+func (g *testQuestionGenerator) EstimateCost(chunks []string) (types.DataPrepCostEstimate, error) {
+	return types.DataPrepCostEstimate{}, nil
+}
+
+func testChunks(count int) []*DataPrepTextSplitterChunk {
+	chunks := []*DataPrepTextSplitterChunk{}
+	for i := 0; i < count; i++ {
+		chunks = append(chunks, &DataPrepTextSplitterChunk{
+			Filename: "file.txt",
+			Index:    i,
+			Text:     fmt.Sprintf("chunk %d", i),
+		})
+	}
+	return chunks
+}
+
+func TestConvertChunks(t *testing.T) {
+	generator := &testQuestionGenerator{concurrency: 3, failIndex: 4}
+	chunks := testChunks(12)
+
+	handled := 0
+	results, err := ConvertChunks(context.Background(), generator, chunks, func(result *DataPrepTextChunkResult, index int) error {
+		handled++
+		assert.Equal(t, chunks[index], result.Chunk)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, len(chunks), handled)
+	assert.LessOrEqual(t, atomic.LoadInt32(&generator.maxRunning), int32(3))
+	require.Len(t, results, len(chunks))
+	for i, result := range results {
+		assert.Equal(t, chunks[i], result.Chunk)
+		if i == 4 {
+			assert.Error(t, result.Error)
+			assert.Empty(t, result.Questions)
+			continue
+		}
+		assert.NoError(t, result.Error)
+		assert.Equal(t, chunks[i].Text, result.Questions[0].Conversations[0].Value)
+	}
+}
+
+func TestConvertChunks_HandlerErrorStops(t *testing.T) {
+	generator := &testQuestionGenerator{concurrency: 2, failIndex: -1}
+	chunks := testChunks(20)
+
+	handled := 0
+	_, err := ConvertChunks(context.Background(), generator, chunks, func(result *DataPrepTextChunkResult, index int) error {
+		handled++
+		return fmt.Errorf("cannot save questions")
+	})
+	require.EqualError(t, err, "cannot save questions")
+	assert.Equal(t, 1, handled)
+}
+
+func TestConvertChunks_Cancelled(t *testing.T) {
+	generator := &testQuestionGenerator{concurrency: 1, failIndex: -1}
+	chunks := testChunks(20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results, err := ConvertChunks(ctx, generator, chunks, func(result *DataPrepTextChunkResult, index int) error {
+		if index == 1 {
+			cancel()
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+
+	converted := 0
+	for _, result := range results {
+		if result != nil {
+			converted++
+		}
+	}
+	assert.Less(t, converted, len(chunks))
+}
+
+func TestConvertChunks_CancelInFlight(t *testing.T) {
+	// long enough that the chunks are still converting when we cancel
+	generator := &testQuestionGenerator{concurrency: 4, failIndex: -1, delay: time.Minute}
+	chunks := testChunks(20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	handled := 0
+	start := time.Now()
+	results, err := ConvertChunks(ctx, generator, chunks, func(result *DataPrepTextChunkResult, index int) error {
+		handled++
+		return nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 10*time.Second)
+	assert.Equal(t, 0, handled)
+	for _, result := range results {
+		assert.Nil(t, result)
+	}
+}
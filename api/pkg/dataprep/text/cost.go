@@ -0,0 +1,64 @@
+package text
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// we don't have the tokenizer for every model we might target so we use the
+// usual rule of thumb of around four characters per token
+const charactersPerToken = 4
+
+// roughly how long a generated question and answer pair is
+const tokensPerQuestion = 80
+
+// USD per million tokens
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// keyed by the model name the generator sends requests to
+type PriceTable map[string]ModelPrice
+
+func DefaultPriceTable() PriceTable {
+	return PriceTable{
+		"mistralai/Mixtral-8x7B-Instruct-v0.1": {Input: 0.6, Output: 0.6},
+		// runs on our own runners
+		string(types.Model_Axolotl_Mistral7b): {Input: 0, Output: 0},
+	}
+}
+
+// parse a JSON object of prices e.g. {"model": {"input": 0.5, "output": 1.5}}
+// and lay it over the defaults - an empty string gives the defaults
+func ParsePriceTable(overrides string) (PriceTable, error) {
+	prices := DefaultPriceTable()
+	if overrides == "" {
+		return prices, nil
+	}
+	parsed := PriceTable{}
+	err := json.Unmarshal([]byte(overrides), &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing data prep price table: %s", err.Error())
+	}
+	for model, price := range parsed {
+		prices[model] = price
+	}
+	return prices, nil
+}
+
+func EstimateTokens(text string) int {
+	return (len(text) + charactersPerToken - 1) / charactersPerToken
+}
+
+// work out the dollar figure for the token counts already on the estimate
+func (prices PriceTable) applyTo(estimate *types.DataPrepCostEstimate) {
+	price, ok := prices[estimate.Model]
+	if !ok {
+		return
+	}
+	estimate.Priced = true
+	estimate.CostUSD = (float64(estimate.InputTokens)*price.Input + float64(estimate.OutputTokens)*price.Output) / 1_000_000
+}
@@ -0,0 +1,57 @@
+package text
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestParsePriceTable(t *testing.T) {
+	prices, err := ParsePriceTable(`{"mistralai/Mixtral-8x7B-Instruct-v0.1": {"input": 1, "output": 2}, "other": {"input": 3}}`)
+	require.NoError(t, err)
+
+	assert.Equal(t, ModelPrice{Input: 1, Output: 2}, prices["mistralai/Mixtral-8x7B-Instruct-v0.1"])
+	assert.Equal(t, ModelPrice{Input: 3}, prices["other"])
+	assert.Contains(t, prices, string(types.Model_Axolotl_Mistral7b))
+
+	_, err = ParsePriceTable(`not json`)
+	assert.Error(t, err)
+}
+
+func TestDynamicDataPrep_EstimateCost(t *testing.T) {
+	generator, err := NewDynamicDataPrep("", []string{})
+	require.NoError(t, err)
+	generator.Prices = PriceTable{
+		"mistralai/Mixtral-8x7B-Instruct-v0.1": {Input: 1_000_000, Output: 2_000_000},
+	}
+
+	estimate, err := generator.EstimateCost([]string{"first chunk", "second chunk"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "mistralai/Mixtral-8x7B-Instruct-v0.1", estimate.Model)
+	assert.Equal(t, 2, estimate.Chunks)
+	assert.Equal(t, 2*len(generator.Prompts), estimate.Requests)
+	assert.Greater(t, estimate.InputTokens, 0)
+	assert.Greater(t, estimate.OutputTokens, 0)
+	assert.True(t, estimate.Priced)
+	assert.Equal(t, float64(estimate.InputTokens+2*estimate.OutputTokens), estimate.CostUSD)
+}
+
+func TestDataPrepTextHelixMistral_EstimateCost_Unpriced(t *testing.T) {
+	generator, err := NewDataPrepTextHelixMistral(DataPrepTextOptions{
+		QuestionsPerChunk: 10,
+		Prices:            PriceTable{},
+	}, nil, nil, nil)
+	require.NoError(t, err)
+
+	estimate, err := generator.EstimateCost([]string{"a chunk"})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, estimate.Requests)
+	assert.Equal(t, 10*tokensPerQuestion, estimate.OutputTokens)
+	assert.False(t, estimate.Priced)
+	assert.Zero(t, estimate.CostUSD)
+}
@@ -1,7 +1,9 @@
 package text
 
 import (
+	"context"
 	"fmt"
+	"net/url"
 	"strings"
 
 	"github.com/helixml/helix/api/pkg/dataprep/qapairs"
@@ -9,32 +11,181 @@ import (
 )
 
 // Wrapper around qapairs.Query that implements DataPrepTextQuestionGenerator.
-// Dynamically generates qapairs based on (baked-in) yaml configuration of
-// a suite of named qapair prompts and named target APIs.
+// Dynamically generates qapairs based on yaml configuration of a suite of
+// named qapair prompts and named target APIs - baked-in unless Config says
+// otherwise.
 
 type DynamicDataPrep struct {
 	Target  string
 	Prompts []string
+	// the prompt suite and targets, nil means the baked-in qapairs config
+	Config *qapairs.Config
+	// used by EstimateCost, nil means DefaultPriceTable
+	Prices PriceTable
+	// send the prompts here instead of the named Target - this is how we
+	// point data prep at a self hosted OpenAI compatible server
+	CustomTarget *qapairs.Target
+	// how much of the chunks and answers go into the logs
+	LogContent qapairs.LogContent
+	// zero for the chunk_size and concurrency in the config
+	ChunkSize   int
+	Concurrency int
 }
 
-func NewDynamicDataPrep(target string, prompts []string) *DynamicDataPrep {
-	if target == "" && len(prompts) == 0 {
-		allPrompts, err := qapairs.AllPrompts()
-		if err != nil {
-			panic(err)
-		}
+// the target described by the APIURL, APIKey and Model options - nil if
+// there is no APIURL so the default target is used
+func CustomTargetFromOptions(options DataPrepTextOptions) (*qapairs.Target, error) {
+	if options.APIURL == "" {
+		return nil, nil
+	}
+	if options.Model == "" {
+		return nil, fmt.Errorf("a model is required when using a custom data prep API URL")
+	}
+	parsedURL, err := url.Parse(options.APIURL)
+	if err != nil || parsedURL.Scheme == "" || parsedURL.Host == "" {
+		return nil, fmt.Errorf("invalid data prep API URL: %s", options.APIURL)
+	}
+	return &qapairs.Target{
+		Name:   "custom",
+		ApiUrl: options.APIURL,
+		Model:  options.Model,
+		Token:  options.APIKey,
+	}, nil
+}
 
-		// use sensible defaults
-		return &DynamicDataPrep{
-			Target:  "together-mixtral",
-			Prompts: allPrompts,
-		}
+func (d *DynamicDataPrep) getConfig() (*qapairs.Config, error) {
+	if d.Config != nil {
+		return d.Config, nil
 	}
+	return qapairs.DefaultConfig()
+}
 
-	return &DynamicDataPrep{
+func (d *DynamicDataPrep) getTarget() (qapairs.Target, error) {
+	if d.CustomTarget != nil {
+		return *d.CustomTarget, nil
+	}
+	config, err := d.getConfig()
+	if err != nil {
+		return qapairs.Target{}, err
+	}
+	return config.FindTarget(d.Target)
+}
+
+func NewDynamicDataPrep(target string, prompts []string) (*DynamicDataPrep, error) {
+	return NewDynamicDataPrepWithConfig(nil, target, prompts)
+}
+
+// an empty target is the config's default target and no prompts means all
+// of them - a nil config is the baked-in one
+func NewDynamicDataPrepWithConfig(config *qapairs.Config, target string, prompts []string) (*DynamicDataPrep, error) {
+	d := &DynamicDataPrep{
+		Config:  config,
 		Target:  target,
 		Prompts: prompts,
 	}
+	config, err := d.getConfig()
+	if err != nil {
+		return nil, err
+	}
+	if d.Target == "" {
+		d.Target = config.DefaultTarget
+	}
+	if len(d.Prompts) == 0 {
+		d.Prompts = config.PromptNames()
+	}
+	return d, nil
+}
+
+// the dynamic module as configured by the DataPrepTextOptions, checked so
+// that a typo in a prompt or target name (or a chunk size the target can't
+// take) fails before any data prep starts
+func NewDynamicDataPrepFromOptions(options DataPrepTextOptions) (*DynamicDataPrep, error) {
+	err := validateChunkOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := NewDynamicDataPrepWithConfig(options.Config, options.Target, options.Prompts)
+	if err != nil {
+		return nil, err
+	}
+	d.Prices = options.Prices
+	d.LogContent = options.LogContent
+	d.ChunkSize = options.ChunkSize
+	d.Concurrency = options.Concurrency
+
+	// a self hosted OpenAI compatible server if one is configured
+	customTarget, err := CustomTargetFromOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	d.CustomTarget = customTarget
+
+	err = d.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// every prompt and the target (unless there is a custom one) must be in the
+// config and a chunk with its questions has to fit in the target's context
+func (d *DynamicDataPrep) Validate() error {
+	config, err := d.getConfig()
+	if err != nil {
+		return err
+	}
+	if len(d.Prompts) == 0 {
+		return fmt.Errorf("no data prep prompts to run")
+	}
+	for _, promptName := range d.Prompts {
+		_, err := config.FindPrompt(promptName)
+		if err != nil {
+			return err
+		}
+	}
+	if d.CustomTarget == nil && d.Target == "" {
+		return fmt.Errorf("no data prep target, the targets are: %s", strings.Join(config.TargetNames(), ", "))
+	}
+	target, err := d.getTarget()
+	if err != nil {
+		return err
+	}
+	if d.ChunkSize < 0 || d.Concurrency < 0 {
+		return fmt.Errorf("data prep chunk size and concurrency must be more than zero")
+	}
+	return d.validateChunkSize(config, target)
+}
+
+// the longest of the prompts with a whole chunk in it and the questions
+// coming back have to fit in the target's context, if we know how big it is
+func (d *DynamicDataPrep) validateChunkSize(config *qapairs.Config, target qapairs.Target) error {
+	if target.ContextLength <= 0 {
+		return nil
+	}
+	chunkSize, err := d.GetChunkSize()
+	if err != nil {
+		return err
+	}
+	for _, promptName := range d.Prompts {
+		prompt, err := config.FindPrompt(promptName)
+		if err != nil {
+			return err
+		}
+		systemPrompt, userPrompt, err := qapairs.RenderPrompt(prompt, qapairs.TemplateData{
+			NumQuestions: config.NumQuestions,
+		})
+		if err != nil {
+			return err
+		}
+		tokens := EstimateTokens(systemPrompt) + EstimateTokens(userPrompt) +
+			(chunkSize+charactersPerToken-1)/charactersPerToken +
+			config.NumQuestions*tokensPerQuestion
+		if tokens > target.ContextLength {
+			return fmt.Errorf("data prep chunk size %d is too big for %s, the %s prompt would use about %d tokens and the model takes %d", chunkSize, target.Model, promptName, tokens, target.ContextLength)
+		}
+	}
+	return nil
 }
 
 func (d *DynamicDataPrep) ExpandChunks(chunks []*DataPrepTextSplitterChunk) (
@@ -52,13 +203,17 @@ func (d *DynamicDataPrep) ExpandChunks(chunks []*DataPrepTextSplitterChunk) (
 }
 
 func (d *DynamicDataPrep) ConvertChunk(
-	chunk string, index int, documentID, documentGroupID, promptName string,
+	ctx context.Context, chunk string, index int, documentID, documentGroupID, promptName string,
 ) ([]types.DataPrepTextQuestion, error) {
-	prompt, err := qapairs.FindPrompt(promptName)
+	config, err := d.getConfig()
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := config.FindPrompt(promptName)
 	if err != nil {
 		return nil, err
 	}
-	target, err := qapairs.FindTarget(d.Target)
+	target, err := d.getTarget()
 	if err != nil {
 		return nil, err
 	}
@@ -66,7 +221,7 @@ func (d *DynamicDataPrep) ConvertChunk(
 		Name:     "user-provided",
 		Contents: chunk,
 	}
-	resRaw, err := qapairs.Query(target, prompt, text, documentID, documentGroupID, 0)
+	resRaw, err := qapairs.Query(ctx, target, prompt, text, documentID, documentGroupID, config.NumQuestions, d.LogContent)
 	if err != nil {
 		return nil, err
 	}
@@ -93,20 +248,70 @@ func (d *DynamicDataPrep) ConvertChunk(
 	return res, nil
 }
 
-func (d *DynamicDataPrep) GetConcurrency() int {
-	concurrency, err := qapairs.GetConcurrency()
+func (d *DynamicDataPrep) GetConcurrency() (int, error) {
+	if d.Concurrency > 0 {
+		return d.Concurrency, nil
+	}
+	config, err := d.getConfig()
+	if err != nil {
+		return 0, err
+	}
+	return config.Concurrency, nil
+}
+
+func (d *DynamicDataPrep) GetChunkSize() (int, error) {
+	if d.ChunkSize > 0 {
+		return d.ChunkSize, nil
+	}
+	config, err := d.getConfig()
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
-	return concurrency
+	return config.ChunkSize, nil
 }
 
-func (d *DynamicDataPrep) GetChunkSize() int {
-	chunkSize, err := qapairs.GetChunkSize()
+// every chunk is sent once per prompt so the estimate is the rendered
+// prompts plus the questions we ask for coming back
+func (d *DynamicDataPrep) EstimateCost(chunks []string) (types.DataPrepCostEstimate, error) {
+	target, err := d.getTarget()
+	if err != nil {
+		return types.DataPrepCostEstimate{}, err
+	}
+	config, err := d.getConfig()
 	if err != nil {
-		panic(err)
+		return types.DataPrepCostEstimate{}, err
+	}
+	numQuestions := config.NumQuestions
+
+	estimate := types.DataPrepCostEstimate{
+		Model:  target.Model,
+		Chunks: len(chunks),
+	}
+	for _, promptName := range d.Prompts {
+		prompt, err := config.FindPrompt(promptName)
+		if err != nil {
+			return types.DataPrepCostEstimate{}, err
+		}
+		for _, chunk := range chunks {
+			systemPrompt, userPrompt, err := qapairs.RenderPrompt(prompt, qapairs.TemplateData{
+				NumQuestions:  numQuestions,
+				DocumentChunk: chunk,
+			})
+			if err != nil {
+				return types.DataPrepCostEstimate{}, err
+			}
+			estimate.Requests++
+			estimate.InputTokens += EstimateTokens(systemPrompt) + EstimateTokens(userPrompt)
+			estimate.OutputTokens += numQuestions * tokensPerQuestion
+		}
+	}
+
+	prices := d.Prices
+	if prices == nil {
+		prices = DefaultPriceTable()
 	}
-	return chunkSize
+	prices.applyTo(&estimate)
+	return estimate, nil
 }
 
 // Compile-time interface check:
@@ -0,0 +1,160 @@
+package text
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/dataprep/qapairs"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestCustomTargetFromOptions(t *testing.T) {
+	target, err := CustomTargetFromOptions(DataPrepTextOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, target)
+
+	_, err = CustomTargetFromOptions(DataPrepTextOptions{APIURL: "http://localhost:8000/v1"})
+	assert.Error(t, err)
+
+	target, err = CustomTargetFromOptions(DataPrepTextOptions{APIURL: "http://localhost:8000/v1", Model: "mistral"})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8000/v1", target.ApiUrl)
+	assert.Equal(t, "", target.GetToken())
+
+	// a model we have no price for is still estimated, just not priced
+	generator, err := NewDynamicDataPrep("", []string{})
+	require.NoError(t, err)
+	generator.CustomTarget = target
+	estimate, err := generator.EstimateCost([]string{"chunk"})
+	require.NoError(t, err)
+	assert.Equal(t, "mistral", estimate.Model)
+	assert.False(t, estimate.Priced)
+}
+
+func TestDynamicDataPrep_Validate(t *testing.T) {
+	config, err := qapairs.ParseConfig([]byte(`
+chunk_size: 1000
+prompts:
+  - name: faq
+    user: "{{.DocumentChunk}}"
+targets:
+  - name: local
+    api_url: http://localhost:11434/v1
+    model: llama3
+`))
+	require.NoError(t, err)
+
+	// the defaults come from the config
+	generator, err := NewDynamicDataPrepWithConfig(config, "", nil)
+	require.NoError(t, err)
+	require.NoError(t, generator.Validate())
+	assert.Equal(t, "local", generator.Target)
+	assert.Equal(t, []string{"faq"}, generator.Prompts)
+	chunkSize, err := generator.GetChunkSize()
+	require.NoError(t, err)
+	assert.Equal(t, 1000, chunkSize)
+
+	generator, err = NewDynamicDataPrepWithConfig(config, "together-mixtral", nil)
+	require.NoError(t, err)
+	assert.Error(t, generator.Validate())
+	generator, err = NewDynamicDataPrepWithConfig(config, "", []string{"faq", "simple-quiz"})
+	require.NoError(t, err)
+	assert.Error(t, generator.Validate())
+
+	// a custom target doesn't need to be in the config
+	_, err = NewDynamicDataPrepFromOptions(DataPrepTextOptions{
+		Config: config,
+		Target: "unknown",
+		APIURL: "http://localhost:8000/v1",
+		Model:  "mistral",
+	})
+	require.NoError(t, err)
+
+	_, err = NewDynamicDataPrepFromOptions(DataPrepTextOptions{Prompts: []string{"simple-quizz"}})
+	assert.Error(t, err)
+}
+
+func TestDataPrepTextOptions_ForSession(t *testing.T) {
+	options := DataPrepTextOptions{LogContent: qapairs.LogContentFull}
+	sensitive := &types.Session{Metadata: types.SessionMetadata{Sensitive: true}}
+
+	assert.Equal(t, qapairs.LogContentFull, options.ForSession(&types.Session{}).LogContent)
+	assert.Equal(t, qapairs.LogContentHash, options.ForSession(sensitive).LogContent)
+	// the server wide options are left alone
+	assert.Equal(t, qapairs.LogContentFull, options.LogContent)
+
+	// already redacted more than a hash
+	options.LogContent = qapairs.LogContentNone
+	assert.Equal(t, qapairs.LogContentNone, options.ForSession(sensitive).LogContent)
+
+	generator, err := NewDynamicDataPrepFromOptions(options.ForSession(sensitive))
+	require.NoError(t, err)
+	assert.Equal(t, qapairs.LogContentNone, generator.LogContent)
+}
+
+func TestDynamicDataPrep_ChunkOptions(t *testing.T) {
+	config, err := qapairs.ParseConfig([]byte(`
+chunk_size: 1000
+concurrency: 10
+num_questions: 5
+prompts:
+  - name: faq
+    user: "{{.DocumentChunk}}"
+targets:
+  - name: local
+    api_url: http://localhost:11434/v1
+    model: llama3
+    context_length: 2000
+`))
+	require.NoError(t, err)
+
+	// the config's unless the session says otherwise
+	options := DataPrepTextOptions{Config: config}
+	generator, err := NewDynamicDataPrepFromOptions(options)
+	require.NoError(t, err)
+	concurrency, err := generator.GetConcurrency()
+	require.NoError(t, err)
+	assert.Equal(t, 10, concurrency)
+
+	session := &types.Session{Metadata: types.SessionMetadata{DataPrepChunkSize: 4000, DataPrepConcurrency: 3}}
+	generator, err = NewDynamicDataPrepFromOptions(options.ForSession(session))
+	require.NoError(t, err)
+	chunkSize, err := generator.GetChunkSize()
+	require.NoError(t, err)
+	assert.Equal(t, 4000, chunkSize)
+	concurrency, err = generator.GetConcurrency()
+	require.NoError(t, err)
+	assert.Equal(t, 3, concurrency)
+
+	// a chunk and its questions have to fit in the model's context
+	session.Metadata.DataPrepChunkSize = 8000
+	_, err = NewDynamicDataPrepFromOptions(options.ForSession(session))
+	assert.Error(t, err)
+
+	session.Metadata.DataPrepChunkSize = 0
+	session.Metadata.DataPrepConcurrency = -1
+	_, err = NewDynamicDataPrepFromOptions(options.ForSession(session))
+	assert.Error(t, err)
+}
+
+func TestDataPrepTextHelixMistral_ChunkOptions(t *testing.T) {
+	generator, err := NewDataPrepTextHelixMistral(DataPrepTextOptions{}, nil, nil, nil)
+	require.NoError(t, err)
+	chunkSize, err := generator.GetChunkSize()
+	require.NoError(t, err)
+	assert.Equal(t, HELIX_MISTRAL_CHUNK_SIZE, chunkSize)
+
+	generator, err = NewDataPrepTextHelixMistral(DataPrepTextOptions{ChunkSize: 1024, Concurrency: 2}, nil, nil, nil)
+	require.NoError(t, err)
+	chunkSize, err = generator.GetChunkSize()
+	require.NoError(t, err)
+	assert.Equal(t, 1024, chunkSize)
+	concurrency, err := generator.GetConcurrency()
+	require.NoError(t, err)
+	assert.Equal(t, 2, concurrency)
+
+	_, err = NewDataPrepTextHelixMistral(DataPrepTextOptions{ChunkSize: HELIX_MISTRAL_CHUNK_SIZE + 1}, nil, nil, nil)
+	assert.Error(t, err)
+}
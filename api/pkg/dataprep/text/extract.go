@@ -0,0 +1,414 @@
+package text
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type DocumentType string
+
+const (
+	DocumentType_Text DocumentType = "text"
+	DocumentType_PDF  DocumentType = "pdf"
+	DocumentType_DOCX DocumentType = "docx"
+	DocumentType_HTML DocumentType = "html"
+)
+
+const docxMimeType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+var ErrUnsupportedDocumentType = errors.New("unsupported document type")
+
+// work out what kind of document we have from the mime type we were given,
+// falling back to the file extension and then sniffing the content
+func DetectDocumentType(filename, mimeType string, content []byte) (DocumentType, error) {
+	if mimeType != "" {
+		mediaType, _, err := mime.ParseMediaType(mimeType)
+		if err == nil {
+			mimeType = mediaType
+		}
+	}
+
+	switch mimeType {
+	case "text/plain", "text/markdown", "text/x-markdown":
+		return DocumentType_Text, nil
+	case "application/pdf":
+		return DocumentType_PDF, nil
+	case docxMimeType:
+		return DocumentType_DOCX, nil
+	case "text/html", "application/xhtml+xml":
+		return DocumentType_HTML, nil
+	case "", "application/octet-stream":
+		// not told - try the extension and then the content
+	default:
+		return "", fmt.Errorf("%w %q for %s", ErrUnsupportedDocumentType, mimeType, filename)
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".txt", ".md":
+		return DocumentType_Text, nil
+	case ".pdf":
+		return DocumentType_PDF, nil
+	case ".docx":
+		return DocumentType_DOCX, nil
+	case ".html", ".htm":
+		return DocumentType_HTML, nil
+	}
+
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(content))
+	switch sniffed {
+	case "application/pdf":
+		return DocumentType_PDF, nil
+	case "text/html":
+		return DocumentType_HTML, nil
+	case "text/plain":
+		return DocumentType_Text, nil
+	}
+
+	return "", fmt.Errorf("%w for %s", ErrUnsupportedDocumentType, filename)
+}
+
+// turn a document into plain text with a blank line between paragraphs
+// text and markdown are returned exactly as they are
+func ExtractText(filename, mimeType string, content []byte) (string, error) {
+	documentType, err := DetectDocumentType(filename, mimeType, content)
+	if err != nil {
+		return "", err
+	}
+
+	var text string
+	switch documentType {
+	case DocumentType_Text:
+		return string(content), nil
+	case DocumentType_PDF:
+		text, err = extractPDFText(content)
+	case DocumentType_DOCX:
+		text, err = extractDOCXText(content)
+	case DocumentType_HTML:
+		text, err = extractHTMLText(content)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error extracting text from %s: %s", filename, err.Error())
+	}
+	if strings.TrimSpace(text) == "" {
+		return "", fmt.Errorf("no text found in %s", filename)
+	}
+	return text, nil
+}
+
+func joinParagraphs(paragraphs []string) string {
+	result := []string{}
+	for _, paragraph := range paragraphs {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph != "" {
+			result = append(result, paragraph)
+		}
+	}
+	return strings.Join(result, "\n\n")
+}
+
+// DOCX is a zip with the body in word/document.xml, each <w:p> is a
+// paragraph and the text lives in its <w:t> runs
+func extractDOCXText(content []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", err
+	}
+
+	var documentFile *zip.File
+	for _, file := range archive.File {
+		if file.Name == "word/document.xml" {
+			documentFile = file
+			break
+		}
+	}
+	if documentFile == nil {
+		return "", fmt.Errorf("word/document.xml not found")
+	}
+
+	reader, err := documentFile.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	paragraphs := []string{}
+	var current strings.Builder
+	inText := false
+
+	decoder := xml.NewDecoder(reader)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				current.WriteString("\t")
+			case "br", "cr":
+				current.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				paragraphs = append(paragraphs, current.String())
+				current.Reset()
+			}
+		case xml.CharData:
+			if inText {
+				current.Write(t)
+			}
+		}
+	}
+	paragraphs = append(paragraphs, current.String())
+
+	return joinParagraphs(paragraphs), nil
+}
+
+var whitespaceRegexp = regexp.MustCompile(`[ \t\r\n]+`)
+
+var htmlBlockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Dd: true, atom.Div: true, atom.Dl: true, atom.Dt: true, atom.Figcaption: true,
+	atom.Figure: true, atom.Footer: true, atom.Form: true, atom.H1: true, atom.H2: true,
+	atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true, atom.Header: true,
+	atom.Hr: true, atom.Li: true, atom.Main: true, atom.Nav: true, atom.Ol: true,
+	atom.P: true, atom.Pre: true, atom.Section: true, atom.Table: true, atom.Td: true,
+	atom.Th: true, atom.Tr: true, atom.Ul: true,
+}
+
+// nothing in these is part of the page's text
+var htmlSkippedElements = map[atom.Atom]bool{
+	atom.Head: true, atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+}
+
+func extractHTMLText(content []byte) (string, error) {
+	tokenizer := html.NewTokenizer(bytes.NewReader(content))
+
+	paragraphs := []string{}
+	var current strings.Builder
+	skipDepth := 0
+
+	endParagraph := func() {
+		paragraphs = append(paragraphs, current.String())
+		current.Reset()
+	}
+
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if tokenizer.Err() == io.EOF {
+				break
+			}
+			return "", tokenizer.Err()
+		}
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch {
+			case htmlSkippedElements[token.DataAtom]:
+				if tokenType == html.StartTagToken {
+					skipDepth++
+				}
+			case token.DataAtom == atom.Br:
+				current.WriteString("\n")
+			case htmlBlockElements[token.DataAtom]:
+				endParagraph()
+			}
+		case html.EndTagToken:
+			switch {
+			case htmlSkippedElements[token.DataAtom]:
+				if skipDepth > 0 {
+					skipDepth--
+				}
+			case htmlBlockElements[token.DataAtom]:
+				endParagraph()
+			}
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			// collapse the source formatting, line breaks only come from <br>
+			current.WriteString(whitespaceRegexp.ReplaceAllString(token.Data, " "))
+		}
+	}
+	endParagraph()
+
+	// tidy up the spaces left between words and around the <br> line breaks
+	for i, paragraph := range paragraphs {
+		lines := strings.Split(paragraph, "\n")
+		for j, line := range lines {
+			lines[j] = strings.TrimSpace(whitespaceRegexp.ReplaceAllString(line, " "))
+		}
+		paragraphs[i] = strings.Join(lines, "\n")
+	}
+
+	return joinParagraphs(paragraphs), nil
+}
+
+// reads the text showing operators from each page - this works for most
+// generated documents but not for scanned pages (those need OCR or the
+// unstructured service)
+func extractPDFText(content []byte) (string, error) {
+	reader, err := pdf.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", err
+	}
+
+	extractor := &pdfTextExtractor{}
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		err = extractor.page(page)
+		if err != nil {
+			return "", fmt.Errorf("page %d: %w", i, err)
+		}
+		extractor.endParagraph()
+	}
+	return joinParagraphs(extractor.paragraphs), nil
+}
+
+// the pdf package takes care of the file structure, streams and font
+// encodings - we only have to work out where the lines and paragraphs break
+type pdfTextExtractor struct {
+	paragraphs []string
+	paragraph  []string
+	line       strings.Builder
+	// the smallest distance we have seen between lines so we can spot the
+	// bigger jumps between paragraphs
+	lineGap float64
+}
+
+func (extractor *pdfTextExtractor) write(text string) {
+	for _, c := range text {
+		if c == '\n' || c == '\r' {
+			extractor.endLine()
+		} else if c == '\t' || (c >= ' ' && c != 0x7f && c != unicode.ReplacementChar) {
+			extractor.line.WriteRune(c)
+		}
+	}
+}
+
+func (extractor *pdfTextExtractor) endLine() {
+	line := strings.TrimSpace(extractor.line.String())
+	extractor.line.Reset()
+	if line != "" {
+		extractor.paragraph = append(extractor.paragraph, line)
+	}
+}
+
+func (extractor *pdfTextExtractor) endParagraph() {
+	extractor.endLine()
+	if len(extractor.paragraph) > 0 {
+		extractor.paragraphs = append(extractor.paragraphs, strings.Join(extractor.paragraph, "\n"))
+		extractor.paragraph = nil
+	}
+}
+
+// the text cursor moved down by this much
+func (extractor *pdfTextExtractor) moveDown(distance float64) {
+	if distance <= 0 {
+		extractor.endLine()
+		return
+	}
+	if extractor.lineGap == 0 || distance < extractor.lineGap {
+		extractor.lineGap = distance
+	}
+	if distance > extractor.lineGap*1.5 {
+		extractor.endParagraph()
+	} else {
+		extractor.endLine()
+	}
+}
+
+// the pdf package panics on content it can't make sense of
+func (extractor *pdfTextExtractor) page(page pdf.Page) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid page content: %v", r)
+		}
+	}()
+
+	fonts := map[string]pdf.TextEncoding{}
+	for _, name := range page.Fonts() {
+		fonts[name] = page.Font(name).Encoder()
+	}
+	var encoding pdf.TextEncoding
+
+	decode := func(value pdf.Value) string {
+		if encoding == nil {
+			return value.RawString()
+		}
+		return encoding.Decode(value.RawString())
+	}
+
+	pdf.Interpret(page.V.Key("Contents"), func(stack *pdf.Stack, operator string) {
+		args := make([]pdf.Value, stack.Len())
+		for i := len(args) - 1; i >= 0; i-- {
+			args[i] = stack.Pop()
+		}
+
+		switch operator {
+		case "Tf":
+			if len(args) == 2 {
+				encoding = fonts[args[0].Name()]
+			}
+		case "Tj":
+			if len(args) == 1 {
+				extractor.write(decode(args[0]))
+			}
+		case "'", "\"":
+			extractor.endLine()
+			if len(args) > 0 {
+				extractor.write(decode(args[len(args)-1]))
+			}
+		case "TJ":
+			if len(args) != 1 {
+				return
+			}
+			for i := 0; i < args[0].Len(); i++ {
+				item := args[0].Index(i)
+				switch item.Kind() {
+				case pdf.String:
+					extractor.write(decode(item))
+				case pdf.Integer, pdf.Real:
+					// a big negative kern is how a lot of generators write a space
+					if item.Float64() < -200 {
+						extractor.write(" ")
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(args) == 2 && args[1].Float64() != 0 {
+				extractor.moveDown(-args[1].Float64())
+			}
+		case "T*", "Tm", "ET":
+			extractor.endLine()
+		}
+	})
+	return nil
+}
@@ -0,0 +1,113 @@
+package text
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDOCX(t *testing.T, body string) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	writer, err := archive.Create("word/document.xml")
+	require.NoError(t, err)
+	_, err = writer.Write([]byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` + body + `</w:body></w:document>`))
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+	return buf.Bytes()
+}
+
+func testPDF(t *testing.T, content string) []byte {
+	var stream bytes.Buffer
+	writer := zlib.NewWriter(&stream)
+	_, err := writer.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>",
+		fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", stream.Len(), stream.String()),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := []int{}
+	for i, object := range objects {
+		offsets = append(offsets, pdf.Len())
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return pdf.Bytes()
+}
+
+func TestExtractText_PlainText(t *testing.T) {
+	content := "# Title\n\nsome *markdown*   with  spacing\n"
+	text, err := ExtractText("notes.md", "", []byte(content))
+	require.NoError(t, err)
+	assert.Equal(t, content, text)
+
+	text, err = ExtractText("notes", "text/plain; charset=utf-8", []byte(content))
+	require.NoError(t, err)
+	assert.Equal(t, content, text)
+}
+
+func TestExtractText_DOCX(t *testing.T) {
+	docx := testDOCX(t, `<w:p><w:r><w:t>First </w:t></w:r><w:r><w:t>paragraph.</w:t></w:r></w:p>`+
+		`<w:p><w:r><w:t>Second</w:t><w:tab/><w:t>paragraph.</w:t></w:r></w:p>`)
+
+	text, err := ExtractText("report.docx", "", docx)
+	require.NoError(t, err)
+	assert.Equal(t, "First paragraph.\n\nSecond\tparagraph.", text)
+}
+
+func TestExtractText_HTML(t *testing.T) {
+	html := `<!DOCTYPE html>
+<html><head><title>Ignored</title><style>p { color: red; }</style></head>
+<body>
+  <script>if (a < b) { alert("no"); }</script>
+  <h1>Heading</h1>
+  <p>Some   text
+     over two lines &amp; an entity.</p>
+  <p>Line one<br>Line two
+  <ul><li>Item</li></ul>
+</body></html>`
+
+	text, err := ExtractText("page", "text/html", []byte(html))
+	require.NoError(t, err)
+	assert.Equal(t, "Heading\n\nSome text over two lines & an entity.\n\nLine one\nLine two\n\nItem", text)
+}
+
+func TestExtractText_PDF(t *testing.T) {
+	pdf := testPDF(t, `BT /F1 12 Tf 72 720 Td (Hello \(PDF\) world.) Tj 0 -14 Td [(Second) -250 (line)] TJ 0 -40 Td <4e657720706172616772617068> Tj ET`)
+
+	text, err := ExtractText("paper.pdf", "application/pdf", pdf)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello (PDF) world.\nSecond line\n\nNew paragraph", text)
+
+	// sniffed from the content when we have nothing else to go on
+	text, err = ExtractText("upload", "", pdf)
+	require.NoError(t, err)
+	assert.Contains(t, text, "Hello (PDF) world.")
+}
+
+func TestExtractText_Unsupported(t *testing.T) {
+	_, err := ExtractText("image.png", "image/png", []byte{0x89, 'P', 'N', 'G'})
+	assert.ErrorIs(t, err, ErrUnsupportedDocumentType)
+
+	_, err = ExtractText("archive.zip", "", []byte("PK\x03\x04"))
+	assert.ErrorIs(t, err, ErrUnsupportedDocumentType)
+}
@@ -1,6 +1,7 @@
 package text
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -32,6 +33,14 @@ func NewDataPrepTextHelixMistral(
 	createFn DataPrepTextHelixMistralSessionCreate,
 	getFn DataPrepTextHelixMistralSessionGet,
 ) (*DataPrepTextHelixMistral, error) {
+	err := validateChunkOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	// the prompt and the questions only just fit the model's context as it is
+	if options.ChunkSize > HELIX_MISTRAL_CHUNK_SIZE {
+		return nil, fmt.Errorf("data prep chunk size %d is more than the %d the helix mistral module can take", options.ChunkSize, HELIX_MISTRAL_CHUNK_SIZE)
+	}
 	return &DataPrepTextHelixMistral{
 		Options:  options,
 		session:  session,
@@ -40,12 +49,18 @@ func NewDataPrepTextHelixMistral(
 	}, nil
 }
 
-func (helixMistral *DataPrepTextHelixMistral) GetConcurrency() int {
-	return HELIX_MISTRAL_CONCURRENCY
+func (helixMistral *DataPrepTextHelixMistral) GetConcurrency() (int, error) {
+	if helixMistral.Options.Concurrency > 0 {
+		return helixMistral.Options.Concurrency, nil
+	}
+	return HELIX_MISTRAL_CONCURRENCY, nil
 }
 
-func (helixMistral *DataPrepTextHelixMistral) GetChunkSize() int {
-	return HELIX_MISTRAL_CHUNK_SIZE
+func (helixMistral *DataPrepTextHelixMistral) GetChunkSize() (int, error) {
+	if helixMistral.Options.ChunkSize > 0 {
+		return helixMistral.Options.ChunkSize, nil
+	}
+	return HELIX_MISTRAL_CHUNK_SIZE, nil
 }
 
 func (helixMistral *DataPrepTextHelixMistral) ExpandChunks(chunks []*DataPrepTextSplitterChunk) ([]*DataPrepTextSplitterChunk, error) {
@@ -53,9 +68,8 @@ func (helixMistral *DataPrepTextHelixMistral) ExpandChunks(chunks []*DataPrepTex
 	return chunks, nil
 }
 
-// TODO: getting a consistent output format that we can parse reliably is really hard
-func (helixMistral *DataPrepTextHelixMistral) ConvertChunk(chunk string, index int, documentID, documentGroupID, promptName string) ([]types.DataPrepTextQuestion, error) {
-	prompt := fmt.Sprintf(`
+func (helixMistral *DataPrepTextHelixMistral) getPrompt(chunk string) string {
+	return fmt.Sprintf(`
 You are a Teacher/ Professor. Your task is to setup a quiz/examination.
 Using the provided context, formulate exactly %d question and answer pairs that captures an important fact from the context.
 You MUST obey the following criteria:
@@ -81,9 +95,33 @@ Do not number the questions or answers.
 %s
 
 	`, helixMistral.Options.QuestionsPerChunk, helixMistral.Options.QuestionsPerChunk, chunk)
+}
+
+func (helixMistral *DataPrepTextHelixMistral) EstimateCost(chunks []string) (types.DataPrepCostEstimate, error) {
+	estimate := types.DataPrepCostEstimate{
+		Model:  string(types.Model_Axolotl_Mistral7b),
+		Chunks: len(chunks),
+	}
+	for _, chunk := range chunks {
+		estimate.Requests++
+		estimate.InputTokens += EstimateTokens(helixMistral.getPrompt(chunk))
+		estimate.OutputTokens += helixMistral.Options.QuestionsPerChunk * tokensPerQuestion
+	}
+
+	prices := helixMistral.Options.Prices
+	if prices == nil {
+		prices = DefaultPriceTable()
+	}
+	prices.applyTo(&estimate)
+	return estimate, nil
+}
+
+// TODO: getting a consistent output format that we can parse reliably is really hard
+func (helixMistral *DataPrepTextHelixMistral) ConvertChunk(ctx context.Context, chunk string, index int, documentID, documentGroupID, promptName string) ([]types.DataPrepTextQuestion, error) {
+	prompt := helixMistral.getPrompt(chunk)
 
 	log.Debug().
-		Msgf("🔴 Mistral Question: %s", prompt)
+		Msgf("🔴 Mistral Question: %s", helixMistral.Options.LogContent.Redact(prompt))
 
 	session, err := helixMistral.createFn(types.CreateSessionRequest{
 		SessionID:     system.GenerateUUID(),
@@ -117,6 +155,8 @@ Do not number the questions or answers.
 
 	sanity := 0
 	result := ""
+	startTime := time.Now()
+	var usage types.Usage
 
 	for {
 		session, err = helixMistral.getFn(session.ID)
@@ -127,6 +167,7 @@ Do not number the questions or answers.
 		lastInteraction := session.Interactions[len(session.Interactions)-1]
 		if lastInteraction.Finished {
 			result = lastInteraction.Message
+			usage = lastInteraction.Usage
 			break
 		}
 
@@ -134,13 +175,20 @@ Do not number the questions or answers.
 		if sanity > 100000 {
 			return nil, fmt.Errorf("sanity check failed after %d iterations", sanity)
 		}
-		time.Sleep(1 * time.Second)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(1 * time.Second):
+		}
 	}
 
 	var res []types.DataPrepTextQuestion
 
 	log.Debug().
-		Msgf("🔴 Mistral Answer: %+v", result)
+		Dur("took", time.Since(startTime)).
+		Int("prompt_tokens", usage.PromptTokens).
+		Int("completion_tokens", usage.CompletionTokens).
+		Msgf("🔴 Mistral Answer: %s", helixMistral.Options.LogContent.Redact(result))
 
 	// r := csv.NewReader(strings.NewReader(lastInteraction.Message))
 	// // var conversations []DataPrepTextConversation
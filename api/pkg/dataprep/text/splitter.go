@@ -15,23 +15,53 @@ type DataPrepTextSplitterChunk struct {
 	Text            string
 	DocumentID      string
 	DocumentGroupID string
+	// only set on the chunks SpanDocuments adds - the chunk belongs to
+	// DocumentID (the later document) and starts with the end of this one
+	SpansDocumentID string
 	// some qapair generators create a chunk to process _per prompt_ from a
 	// suite of prompts, this is where they store which prompt this chunk will
 	// be processed by
 	PromptName string
 }
 
+// the document id the questions from this chunk are tagged with, both
+// documents for a chunk that spans two
+func (chunk *DataPrepTextSplitterChunk) QuestionDocumentID() string {
+	if chunk.SpansDocumentID == "" {
+		return chunk.DocumentID
+	}
+	return chunk.SpansDocumentID + "," + chunk.DocumentID
+}
+
 type DataPrepTextSplitterOptions struct {
 	ChunkSize int
 	Overflow  int
+	// if set each chunk starts with the last Overlap characters of the one
+	// before it and Overflow is ignored
+	Overlap int
+	// add a chunk across the join between each document and the one added
+	// after it (half the end of one and half the start of the other) so
+	// there are questions about where one file carries on into the next,
+	// e.g. a manual split across many files
+	SpanDocuments bool
 }
 
 type DataPrepTextSplitter struct {
 	Options DataPrepTextSplitterOptions
 	Chunks  []*DataPrepTextSplitterChunk
+
+	// the end of the last document added, for SpanDocuments
+	lastDocumentID   string
+	lastDocumentTail string
 }
 
 func NewDataPrepSplitter(options DataPrepTextSplitterOptions) (*DataPrepTextSplitter, error) {
+	if options.Overlap < 0 {
+		return nil, fmt.Errorf("overlap cannot be negative")
+	}
+	if options.Overlap > 0 && options.Overlap >= options.ChunkSize {
+		return nil, fmt.Errorf("overlap (%d) must be less than the chunk size (%d)", options.Overlap, options.ChunkSize)
+	}
 	return &DataPrepTextSplitter{
 		Options: options,
 		Chunks:  []*DataPrepTextSplitterChunk{},
@@ -43,7 +73,13 @@ func (splitter *DataPrepTextSplitter) AddDocument(filename, content, documentGro
 	hash := sha256.Sum256([]byte(content))
 	hashString := hex.EncodeToString(hash[:])
 
-	parts, err := chunkWithOverflow(content, splitter.Options.ChunkSize, splitter.Options.Overflow)
+	var parts []string
+	var err error
+	if splitter.Options.Overlap > 0 {
+		parts, err = chunkWithOverlap(content, splitter.Options.ChunkSize, splitter.Options.Overlap)
+	} else {
+		parts, err = chunkWithOverflow(content, splitter.Options.ChunkSize, splitter.Options.Overflow)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -59,6 +95,23 @@ func (splitter *DataPrepTextSplitter) AddDocument(filename, content, documentGro
 			DocumentGroupID: documentGroupID,
 		})
 	}
+	if splitter.Options.SpanDocuments && strings.TrimSpace(content) != "" {
+		spanSize := splitter.Options.ChunkSize / 2
+		if splitter.lastDocumentTail != "" {
+			// numbered after the document's own chunks so it has its own
+			// place in the data prep progress
+			splitter.Chunks = append(splitter.Chunks, &DataPrepTextSplitterChunk{
+				Filename:        filename,
+				Index:           len(parts),
+				Text:            splitter.lastDocumentTail + "\n\n" + headOf(content, spanSize),
+				DocumentID:      documentID,
+				DocumentGroupID: documentGroupID,
+				SpansDocumentID: splitter.lastDocumentID,
+			})
+		}
+		splitter.lastDocumentID = documentID
+		splitter.lastDocumentTail = tailOf(content, spanSize)
+	}
 	newMeta := session.Metadata
 	newMeta.DocumentGroupID = documentGroupID
 	if newMeta.DocumentIDs == nil {
@@ -68,6 +121,30 @@ func (splitter *DataPrepTextSplitter) AddDocument(filename, content, documentGro
 	return &newMeta, nil
 }
 
+// the start of str up to size characters, cut at a space if there is one
+func headOf(str string, size int) string {
+	if len(str) <= size {
+		return str
+	}
+	head := str[:size]
+	if lastSpace := strings.LastIndex(head, " "); lastSpace > 0 {
+		head = head[:lastSpace]
+	}
+	return head
+}
+
+// the end of str up to size characters, starting on a word if there is one
+func tailOf(str string, size int) string {
+	if len(str) <= size {
+		return str
+	}
+	tail := str[len(str)-size:]
+	if firstSpace := strings.Index(tail, " "); firstSpace != -1 && firstSpace+1 < len(tail) {
+		tail = tail[firstSpace+1:]
+	}
+	return tail
+}
+
 func chunkWithOverflow(str string, maxChunkSize, overflowSize int) ([]string, error) {
 	if maxChunkSize <= 0 {
 		return nil, fmt.Errorf("maxChunkSize must be positive")
@@ -120,3 +197,50 @@ func chunkWithOverflow(str string, maxChunkSize, overflowSize int) ([]string, er
 
 	return result, nil
 }
+
+// a sliding window - every chunk is at most maxChunkSize long and starts with
+// the last overlapSize characters of the previous chunk, we break on spaces
+// where we can so words are not cut in half
+func chunkWithOverlap(str string, maxChunkSize, overlapSize int) ([]string, error) {
+	if maxChunkSize <= 0 {
+		return nil, fmt.Errorf("maxChunkSize must be positive")
+	}
+	if overlapSize < 0 {
+		return nil, fmt.Errorf("overlapSize cannot be negative")
+	}
+	if overlapSize >= maxChunkSize {
+		return nil, fmt.Errorf("overlapSize must be less than maxChunkSize")
+	}
+
+	var result []string
+	start := 0
+
+	for start < len(str) {
+		end := start + maxChunkSize
+		if end >= len(str) {
+			// the last chunk goes in however short it is
+			result = append(result, str[start:])
+			break
+		}
+
+		// only look for a space past the overlap so the next chunk always moves forward
+		lastSpace := strings.LastIndex(str[start+overlapSize:end], " ")
+		if lastSpace != -1 {
+			end = start + overlapSize + lastSpace + 1
+		}
+
+		result = append(result, str[start:end])
+
+		next := end - overlapSize
+		// start the overlap on a word if there is one to start on
+		if overlapSize > 0 && str[next-1] != ' ' {
+			firstSpace := strings.Index(str[next:end], " ")
+			if firstSpace != -1 && next+firstSpace+1 < end {
+				next = next + firstSpace + 1
+			}
+		}
+		start = next
+	}
+
+	return result, nil
+}
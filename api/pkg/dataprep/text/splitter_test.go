@@ -0,0 +1,104 @@
+package text
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestChunkWithOverlap(t *testing.T) {
+	chunks, err := chunkWithOverlap("aaaa bbbb cccc dddd eeee", 10, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aaaa bbbb ", "bbbb cccc ", "cccc dddd ", "dddd eeee"}, chunks)
+
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk), 10)
+	}
+}
+
+func TestChunkWithOverlap_NoSpaces(t *testing.T) {
+	chunks, err := chunkWithOverlap("abcdefghijklm", 5, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abcde", "defgh", "ghijk", "jklm"}, chunks)
+}
+
+func TestChunkWithOverlap_ShortLastChunk(t *testing.T) {
+	// the last chunk is shorter than the overlap but still comes out
+	chunks, err := chunkWithOverlap("abcdefghijk", 10, 8)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abcdefghij", "cdefghijk"}, chunks)
+
+	chunks, err = chunkWithOverlap("abc", 10, 8)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abc"}, chunks)
+}
+
+func TestChunkWithOverlap_ZeroMatchesNoOverflow(t *testing.T) {
+	text := strings.Repeat("the quick brown fox jumps over the lazy dog ", 20)
+
+	overlapping, err := chunkWithOverlap(text, 64, 0)
+	require.NoError(t, err)
+	overflowing, err := chunkWithOverflow(text, 64, 0)
+	require.NoError(t, err)
+
+	assert.Equal(t, overflowing, overlapping)
+	assert.Equal(t, text, strings.Join(overlapping, ""))
+}
+
+func TestNewDataPrepSplitter_ValidatesOverlap(t *testing.T) {
+	_, err := NewDataPrepSplitter(DataPrepTextSplitterOptions{ChunkSize: 100, Overlap: 100})
+	assert.Error(t, err)
+
+	_, err = NewDataPrepSplitter(DataPrepTextSplitterOptions{ChunkSize: 100, Overlap: -1})
+	assert.Error(t, err)
+
+	_, err = NewDataPrepSplitter(DataPrepTextSplitterOptions{ChunkSize: 100, Overlap: 20})
+	assert.NoError(t, err)
+}
+
+func TestAddDocument_SpanDocuments(t *testing.T) {
+	splitter, err := NewDataPrepSplitter(DataPrepTextSplitterOptions{ChunkSize: 20, SpanDocuments: true})
+	require.NoError(t, err)
+	session := &types.Session{}
+
+	meta, err := splitter.AddDocument("part1.md", "one two three four five six", "group-id-12345", session)
+	require.NoError(t, err)
+	session.Metadata = *meta
+	meta, err = splitter.AddDocument("part2.md", "seven eight nine ten eleven", "group-id-12345", session)
+	require.NoError(t, err)
+
+	// two chunks for each document and one across the join
+	require.Len(t, splitter.Chunks, 5)
+	first, second := meta.DocumentIDs["part1.md"], meta.DocumentIDs["part2.md"]
+
+	span := splitter.Chunks[4]
+	assert.Equal(t, "part2.md", span.Filename)
+	assert.Equal(t, 2, span.Index)
+	assert.Equal(t, "five six\n\nseven", span.Text)
+	assert.Equal(t, second, span.DocumentID)
+	assert.Equal(t, first, span.SpansDocumentID)
+	assert.Equal(t, first+","+second, span.QuestionDocumentID())
+
+	// the documents' own chunks are only tagged with their document
+	for _, chunk := range splitter.Chunks[:4] {
+		assert.Empty(t, chunk.SpansDocumentID)
+		assert.Equal(t, meta.DocumentIDs[chunk.Filename], chunk.QuestionDocumentID())
+	}
+}
+
+func TestAddDocument_NoSpanByDefault(t *testing.T) {
+	splitter, err := NewDataPrepSplitter(DataPrepTextSplitterOptions{ChunkSize: 20})
+	require.NoError(t, err)
+	session := &types.Session{}
+
+	_, err = splitter.AddDocument("part1.md", "one two three four five six", "group-id-12345", session)
+	require.NoError(t, err)
+	_, err = splitter.AddDocument("part2.md", "seven eight nine ten eleven", "group-id-12345", session)
+	require.NoError(t, err)
+
+	assert.Len(t, splitter.Chunks, 4)
+}
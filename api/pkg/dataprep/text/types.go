@@ -1,8 +1,10 @@
 package text
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/helixml/helix/api/pkg/dataprep/qapairs"
 	"github.com/helixml/helix/api/pkg/types"
 )
 
@@ -43,11 +45,70 @@ type DataPrepTextOptions struct {
 	OverflowSize      int
 	QuestionsPerChunk int
 	Temperature       float32
+	// what we charge per model when estimating the cost of a run
+	Prices PriceTable
+	// characters each chunk repeats from the end of the previous one - zero
+	// means we fall back to OverflowSize
+	Overlap int
+
+	// an OpenAI compatible API (e.g. a local vLLM or ollama server) for the
+	// dynamic module to use instead of its default target, the key is optional
+	APIURL string
+	APIKey string
+	Model  string
+
+	// the dynamic module's prompt suite and targets, nil for the baked-in
+	// ones - with the target and prompts to use from it, empty for the
+	// default target and every prompt
+	Config  *qapairs.Config
+	Target  string
+	Prompts []string
+
+	// how much of the chunks and the model's answers is logged, empty is
+	// the same as hash - sensitive sessions never log them in full
+	LogContent qapairs.LogContent
+
+	// the characters in each chunk and how many chunks are converted at
+	// once, zero for the module's defaults (the qapair config for dynamic)
+	// - set from the session so each data prep run can be tuned
+	ChunkSize   int
+	Concurrency int
+}
+
+// the options for preparing one session's documents
+func (options DataPrepTextOptions) ForSession(session *types.Session) DataPrepTextOptions {
+	if session == nil {
+		return options
+	}
+	if session.Metadata.Sensitive && options.LogContent == qapairs.LogContentFull {
+		options.LogContent = qapairs.LogContentHash
+	}
+	if session.Metadata.DataPrepChunkSize != 0 {
+		options.ChunkSize = session.Metadata.DataPrepChunkSize
+	}
+	if session.Metadata.DataPrepConcurrency != 0 {
+		options.Concurrency = session.Metadata.DataPrepConcurrency
+	}
+	return options
+}
+
+// a chunk size or concurrency that was asked for has to be positive, zero
+// means it wasn't
+func validateChunkOptions(options DataPrepTextOptions) error {
+	if options.ChunkSize < 0 {
+		return fmt.Errorf("data prep chunk size must be more than zero")
+	}
+	if options.Concurrency < 0 {
+		return fmt.Errorf("data prep concurrency must be more than zero")
+	}
+	return nil
 }
 
 type DataPrepTextQuestionGenerator interface {
 	ExpandChunks(chunks []*DataPrepTextSplitterChunk) ([]*DataPrepTextSplitterChunk, error)
-	ConvertChunk(chunk string, index int, documentID, documentGroupID, promptName string) ([]types.DataPrepTextQuestion, error)
-	GetConcurrency() int
-	GetChunkSize() int
+	ConvertChunk(ctx context.Context, chunk string, index int, documentID, documentGroupID, promptName string) ([]types.DataPrepTextQuestion, error)
+	GetConcurrency() (int, error)
+	GetChunkSize() (int, error)
+	// how many tokens converting these chunks will use and what that costs
+	EstimateCost(chunks []string) (types.DataPrepCostEstimate, error)
 }
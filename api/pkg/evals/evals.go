@@ -1,7 +1,7 @@
 package evals
 
-import "log"
+import "github.com/rs/zerolog/log"
 
 func Run() {
-	log.Printf("hello from evals")
+	log.Info().Msg("hello from evals")
 }
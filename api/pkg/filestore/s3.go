@@ -0,0 +1,495 @@
+package filestore
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// keeps files in an S3 bucket - this also works against anything
+// compatible with it (e.g. minio) by setting the endpoint
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+	// everything we store goes under this key prefix in the bucket
+	prefix string
+	// if set we use path style urls against this rather than AWS
+	endpoint      *url.URL
+	region        string
+	presignExpiry time.Duration
+}
+
+func NewS3Storage(options FileStoreOptions) (*S3Storage, error) {
+	if options.S3Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+	if options.S3Region == "" {
+		return nil, fmt.Errorf("s3 region is required")
+	}
+	if options.S3AccessKeyID == "" || options.S3SecretAccessKey == "" {
+		return nil, fmt.Errorf("s3 access key id and secret access key are required")
+	}
+
+	clientOptions := &minio.Options{
+		Creds:  credentials.NewStaticV4(options.S3AccessKeyID, options.S3SecretAccessKey, ""),
+		Secure: true,
+		Region: options.S3Region,
+	}
+	host := "s3.amazonaws.com"
+
+	var endpoint *url.URL
+	if options.S3Endpoint != "" {
+		parsed, err := url.Parse(strings.TrimSuffix(options.S3Endpoint, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid s3 endpoint %s: %w", options.S3Endpoint, err)
+		}
+		if parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") {
+			return nil, fmt.Errorf("invalid s3 endpoint %s: expected a scheme and host like http://minio:9000", options.S3Endpoint)
+		}
+		endpoint = parsed
+		host = parsed.Host
+		clientOptions.Secure = parsed.Scheme == "https"
+		clientOptions.BucketLookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(host, clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+
+	presignExpiry := options.S3PresignExpiry
+	if presignExpiry <= 0 {
+		presignExpiry = 15 * time.Minute
+	}
+
+	return &S3Storage{
+		client:        client,
+		bucket:        options.S3Bucket,
+		prefix:        strings.Trim(options.S3Prefix, "/"),
+		endpoint:      endpoint,
+		region:        options.S3Region,
+		presignExpiry: presignExpiry,
+	}, nil
+}
+
+func isS3NotFound(err error) bool {
+	return minio.ToErrorResponse(err).StatusCode == http.StatusNotFound
+}
+
+// the bucket key for a path in the filestore
+func (s *S3Storage) key(filePath string) string {
+	return strings.Trim(path.Join(s.prefix, filePath), "/")
+}
+
+// the filestore path for a bucket key
+func (s *S3Storage) path(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return strings.TrimPrefix(key, s.prefix+"/")
+}
+
+func (s *S3Storage) objectURL(key string) *url.URL {
+	if s.endpoint != nil {
+		return &url.URL{Scheme: s.endpoint.Scheme, Host: s.endpoint.Host, Path: "/" + path.Join(s.bucket, key)}
+	}
+	return &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", s.bucket, s.region), Path: "/" + key}
+}
+
+// everything under prefix, or just what is directly in it (with the
+// folders below it as keys ending in /) if recursive is false
+func (s *S3Storage) list(ctx context.Context, prefix string, recursive bool) ([]minio.ObjectInfo, error) {
+	objects := []minio.ObjectInfo{}
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: recursive,
+	}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// every object under a folder apart from the empty folder markers
+func (s *S3Storage) listFolder(ctx context.Context, filePath string) ([]minio.ObjectInfo, error) {
+	result, err := s.list(ctx, s.key(filePath)+"/", true)
+	if err != nil {
+		return nil, err
+	}
+	objects := []minio.ObjectInfo{}
+	for _, object := range result {
+		if !strings.HasSuffix(object.Key, "/") {
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+// the object if path is a file or nil if it is not
+func (s *S3Storage) head(ctx context.Context, filePath string) (*minio.ObjectInfo, error) {
+	object, err := s.client.StatObject(ctx, s.bucket, s.key(filePath), minio.StatObjectOptions{})
+	if isS3NotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &object, nil
+}
+
+func (s *S3Storage) fileItem(object minio.ObjectInfo) FileStoreItem {
+	return FileStoreItem{
+		Name:    path.Base(object.Key),
+		Path:    s.path(object.Key),
+		URL:     s.objectURL(object.Key).String(),
+		Created: object.LastModified.Unix(),
+		Size:    object.Size,
+	}
+}
+
+func (s *S3Storage) folderItem(key string) FileStoreItem {
+	key = strings.TrimSuffix(key, "/")
+	return FileStoreItem{
+		Directory: true,
+		Name:      path.Base(key),
+		Path:      s.path(key),
+		URL:       s.objectURL(key).String(),
+	}
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]FileStoreItem, error) {
+	folderKey := s.key(prefix)
+	if folderKey != "" {
+		folderKey += "/"
+	}
+	objects, err := s.list(ctx, folderKey, false)
+	if err != nil {
+		return nil, fmt.Errorf("error listing s3 objects: %w", err)
+	}
+
+	items := []FileStoreItem{}
+	for _, object := range objects {
+		switch {
+		// the marker for the folder we are listing
+		case object.Key == folderKey:
+		case strings.HasSuffix(object.Key, "/"):
+			items = append(items, s.folderItem(object.Key))
+		default:
+			items = append(items, s.fileItem(object))
+		}
+	}
+	return items, nil
+}
+
+// there are no real folders in S3 so if there is no object at the path we
+// look for anything under it
+func (s *S3Storage) Get(ctx context.Context, filePath string) (FileStoreItem, error) {
+	object, err := s.head(ctx, filePath)
+	if err != nil {
+		return FileStoreItem{}, fmt.Errorf("error fetching s3 object: %w", err)
+	}
+	if object != nil {
+		return s.fileItem(*object), nil
+	}
+
+	objects, err := s.list(ctx, s.key(filePath)+"/", false)
+	if err != nil {
+		return FileStoreItem{}, fmt.Errorf("error fetching s3 object: %w", err)
+	}
+	if len(objects) == 0 {
+		return FileStoreItem{}, fmt.Errorf("s3 object not found: %s", filePath)
+	}
+	return s.folderItem(s.key(filePath)), nil
+}
+
+func (s *S3Storage) presignGet(ctx context.Context, key string) (string, error) {
+	signedURL, err := s.client.PresignedGetObject(ctx, s.bucket, key, s.presignExpiry, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign s3 object: %w", err)
+	}
+	return signedURL.String(), nil
+}
+
+func (s *S3Storage) SignedURL(ctx context.Context, filePath string) (string, error) {
+	return s.presignGet(ctx, s.key(filePath))
+}
+
+func (s *S3Storage) CreateFolder(ctx context.Context, filePath string) (FileStoreItem, error) {
+	_, err := s.client.PutObject(ctx, s.bucket, s.key(filePath)+"/", strings.NewReader(""), 0, minio.PutObjectOptions{})
+	if err != nil {
+		return FileStoreItem{}, fmt.Errorf("failed to create s3 folder: %w", err)
+	}
+	return s.folderItem(s.key(filePath)), nil
+}
+
+func (s *S3Storage) DownloadFile(ctx context.Context, filePath string) (io.Reader, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, s.key(filePath), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download s3 object: %w", err)
+	}
+	// the object is only fetched once it is read so check it is there
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, fmt.Errorf("failed to download s3 object: %w", err)
+	}
+	return object, nil
+}
+
+func (s *S3Storage) put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{})
+	return err
+}
+
+func (s *S3Storage) UploadFile(ctx context.Context, filePath string, r io.Reader) (FileStoreItem, error) {
+	sized, size, cleanup, err := sizedReader(r)
+	if err != nil {
+		return FileStoreItem{}, err
+	}
+	defer cleanup()
+
+	if err := s.put(ctx, s.key(filePath), sized, size); err != nil {
+		return FileStoreItem{}, fmt.Errorf("failed to upload s3 object: %w", err)
+	}
+	return s.Get(ctx, filePath)
+}
+
+// the client buffers uploads of unknown size in very large parts so we
+// find out the size first - if we can't seek we copy it to a temp file
+func sizedReader(r io.Reader) (io.Reader, int64, func(), error) {
+	if seeker, ok := r.(io.Seeker); ok {
+		current, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			end, err := seeker.Seek(0, io.SeekEnd)
+			if err != nil {
+				return nil, 0, nil, err
+			}
+			if _, err := seeker.Seek(current, io.SeekStart); err != nil {
+				return nil, 0, nil, err
+			}
+			return r, end - current, func() {}, nil
+		}
+	}
+
+	tmpFile, err := os.CreateTemp("", "helix-s3-upload")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup := func() {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+	}
+	size, err := io.Copy(tmpFile, r)
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return tmpFile, size, cleanup, nil
+}
+
+// the tar is streamed as we download each object so big folders are never
+// held in memory
+func (s *S3Storage) DownloadFolder(ctx context.Context, filePath string) (io.Reader, error) {
+	objects, err := s.listFolder(ctx, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error listing s3 folder: %w", err)
+	}
+
+	folderKey := s.key(filePath) + "/"
+	reader, writer := io.Pipe()
+	go func() {
+		tarWriter := tar.NewWriter(writer)
+		err := func() error {
+			for _, object := range objects {
+				content, err := s.client.GetObject(ctx, s.bucket, object.Key, minio.GetObjectOptions{})
+				if err != nil {
+					return err
+				}
+				err = tarWriter.WriteHeader(&tar.Header{
+					Name:    strings.TrimPrefix(object.Key, folderKey),
+					Mode:    0644,
+					Size:    object.Size,
+					ModTime: object.LastModified,
+				})
+				if err == nil {
+					_, err = io.Copy(tarWriter, content)
+				}
+				content.Close()
+				if err != nil {
+					return err
+				}
+			}
+			return tarWriter.Close()
+		}()
+		writer.CloseWithError(err)
+	}()
+
+	return reader, nil
+}
+
+func (s *S3Storage) UploadFolder(ctx context.Context, filePath string, r io.Reader) error {
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar header: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := s.put(ctx, s.key(path.Join(filePath, header.Name)), tarReader, header.Size); err != nil {
+			return fmt.Errorf("failed to upload s3 object: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *S3Storage) copy(ctx context.Context, fromKey string, toKey string) error {
+	_, err := s.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: toKey},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: fromKey},
+	)
+	return err
+}
+
+func (s *S3Storage) delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+// the keys that make up the file or folder at path
+func (s *S3Storage) keys(ctx context.Context, filePath string) ([]string, error) {
+	object, err := s.head(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if object != nil {
+		return []string{object.Key}, nil
+	}
+	objects, err := s.list(ctx, s.key(filePath)+"/", true)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	return keys, nil
+}
+
+func (s *S3Storage) Rename(ctx context.Context, filePath string, newPath string) (FileStoreItem, error) {
+	keys, err := s.keys(ctx, filePath)
+	if err != nil {
+		return FileStoreItem{}, fmt.Errorf("error fetching s3 objects to rename: %w", err)
+	}
+	if len(keys) == 0 {
+		return FileStoreItem{}, fmt.Errorf("s3 object not found: %s", filePath)
+	}
+	fromKey := s.key(filePath)
+	toKey := s.key(newPath)
+	for _, key := range keys {
+		if err := s.copy(ctx, key, toKey+strings.TrimPrefix(key, fromKey)); err != nil {
+			return FileStoreItem{}, fmt.Errorf("error copying s3 object during rename: %w", err)
+		}
+		if err := s.delete(ctx, key); err != nil {
+			return FileStoreItem{}, fmt.Errorf("error deleting original s3 object after rename: %w", err)
+		}
+	}
+	return s.Get(ctx, newPath)
+}
+
+func (s *S3Storage) Delete(ctx context.Context, filePath string) error {
+	keys, err := s.keys(ctx, filePath)
+	if err != nil {
+		return fmt.Errorf("error fetching s3 objects to delete: %w", err)
+	}
+	for _, key := range keys {
+		if err := s.delete(ctx, key); err != nil {
+			return fmt.Errorf("error deleting s3 object: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *S3Storage) CopyFile(ctx context.Context, fromPath string, toPath string) error {
+	if err := s.copy(ctx, s.key(fromPath), s.key(toPath)); err != nil {
+		return fmt.Errorf("failed to copy s3 object: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Storage) PresignDownload(ctx context.Context, filePath string) (map[string]string, error) {
+	object, err := s.head(ctx, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching s3 object: %w", err)
+	}
+	if object != nil {
+		url, err := s.SignedURL(ctx, filePath)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{path.Base(filePath): url}, nil
+	}
+
+	objects, err := s.listFolder(ctx, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error listing s3 folder: %w", err)
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("s3 object not found: %s", filePath)
+	}
+	folderKey := s.key(filePath) + "/"
+	urls := map[string]string{}
+	for _, object := range objects {
+		url, err := s.presignGet(ctx, object.Key)
+		if err != nil {
+			return nil, err
+		}
+		urls[strings.TrimPrefix(object.Key, folderKey)] = url
+	}
+	return urls, nil
+}
+
+// S3 rejects the upload if the content doesn't match the checksum
+func (s *S3Storage) PresignUpload(ctx context.Context, filePath string, checksum string) (string, map[string]string, error) {
+	headers := http.Header{}
+	if checksum != "" {
+		sum, err := hex.DecodeString(checksum)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid checksum %s: %w", checksum, err)
+		}
+		headers.Set("X-Amz-Checksum-Sha256", base64.StdEncoding.EncodeToString(sum))
+	}
+	signedURL, err := s.client.PresignHeader(ctx, http.MethodPut, s.bucket, s.key(filePath), s.presignExpiry, nil, headers)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to presign s3 upload: %w", err)
+	}
+
+	required := map[string]string{}
+	for name := range headers {
+		required[name] = headers.Get(name)
+	}
+	return signedURL.String(), required, nil
+}
+
+// Compile-time interface check:
+var _ PresignedFileStore = (*S3Storage)(nil)
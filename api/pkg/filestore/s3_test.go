@@ -0,0 +1,238 @@
+package filestore
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// just enough of the S3 api to exercise S3Storage with path style urls
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+type fakeS3Object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+type fakeS3Prefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type fakeS3ListResult struct {
+	XMLName        xml.Name       `xml:"ListBucketResult"`
+	Contents       []fakeS3Object `xml:"Contents"`
+	CommonPrefixes []fakeS3Prefix `xml:"CommonPrefixes"`
+}
+
+func (f *fakeS3) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256") {
+		res.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/bucket"), "/")
+	switch {
+	case req.Method == http.MethodGet && req.URL.Query().Get("list-type") == "2":
+		f.list(res, req.URL.Query().Get("prefix"), req.URL.Query().Get("delimiter"))
+	case req.Method == http.MethodPut && req.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := url.PathUnescape(req.Header.Get("X-Amz-Copy-Source"))
+		f.objects[key] = f.objects[strings.TrimPrefix(strings.TrimPrefix(source, "/"), "bucket/")]
+		res.Write([]byte("<CopyObjectResult></CopyObjectResult>"))
+	case req.Method == http.MethodPut:
+		content, _ := io.ReadAll(req.Body)
+		if strings.HasPrefix(req.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			content = decodeAWSChunked(content)
+		}
+		f.objects[key] = content
+	case req.Method == http.MethodDelete:
+		delete(f.objects, key)
+		res.WriteHeader(http.StatusNoContent)
+	default:
+		content, ok := f.objects[key]
+		if !ok {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		res.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		http.ServeContent(res, req, key, time.Time{}, bytes.NewReader(content))
+	}
+}
+
+// the client signs each chunk of uploads sent over http as
+// <size in hex>;chunk-signature=<signature>\r\n<data>\r\n
+func decodeAWSChunked(body []byte) []byte {
+	content := []byte{}
+	for len(body) > 0 {
+		header, rest, _ := bytes.Cut(body, []byte("\r\n"))
+		sizeHex, _, _ := strings.Cut(string(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size == 0 || int64(len(rest)) < size {
+			break
+		}
+		content = append(content, rest[:size]...)
+		body = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+	return content
+}
+
+func (f *fakeS3) list(res http.ResponseWriter, prefix string, delimiter string) {
+	keys := []string{}
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := fakeS3ListResult{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if delimiter != "" && strings.Contains(rest, delimiter) {
+			commonPrefix := prefix + rest[:strings.Index(rest, delimiter)+1]
+			if !seen[commonPrefix] {
+				seen[commonPrefix] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, fakeS3Prefix{commonPrefix})
+			}
+			continue
+		}
+		result.Contents = append(result.Contents, fakeS3Object{Key: key, Size: int64(len(f.objects[key])), LastModified: time.Now()})
+	}
+	content, _ := xml.Marshal(result)
+	res.Write(content)
+}
+
+func newTestS3Storage(t *testing.T) (*S3Storage, *fakeS3) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	s3, err := NewS3Storage(FileStoreOptions{
+		S3Bucket:          "bucket",
+		S3Region:          "eu-west-1",
+		S3Prefix:          "/helix/",
+		S3Endpoint:        server.URL,
+		S3AccessKeyID:     "key",
+		S3SecretAccessKey: "secret",
+	})
+	require.NoError(t, err)
+	return s3, fake
+}
+
+func TestS3Storage_Files(t *testing.T) {
+	ctx := context.Background()
+	s3, fake := newTestS3Storage(t)
+
+	_, err := s3.CreateFolder(ctx, "dev/users/a")
+	require.NoError(t, err)
+
+	// not something we can seek so it gets spooled to find the size
+	item, err := s3.UploadFile(ctx, "dev/users/a/sessions/1/input.txt", io.MultiReader(strings.NewReader("hello")))
+	require.NoError(t, err)
+	assert.Equal(t, "dev/users/a/sessions/1/input.txt", item.Path)
+	assert.Equal(t, int64(5), item.Size)
+	assert.Equal(t, []byte("hello"), fake.objects["helix/dev/users/a/sessions/1/input.txt"])
+
+	items, err := s3.List(ctx, "dev/users/a")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.True(t, items[0].Directory)
+	assert.Equal(t, "dev/users/a/sessions", items[0].Path)
+
+	folder, err := s3.Get(ctx, "dev/users/a/sessions/1")
+	require.NoError(t, err)
+	assert.True(t, folder.Directory)
+
+	_, err = s3.Get(ctx, "dev/users/a/sessions/2")
+	assert.Error(t, err)
+
+	_, err = s3.Rename(ctx, "dev/users/a/sessions/1", "dev/users/a/sessions/2")
+	require.NoError(t, err)
+	reader, err := s3.DownloadFile(ctx, "dev/users/a/sessions/2/input.txt")
+	require.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+
+	require.NoError(t, s3.Delete(ctx, "dev/users/a/sessions/2"))
+	_, err = s3.Get(ctx, "dev/users/a/sessions/2")
+	assert.Error(t, err)
+}
+
+func TestS3Storage_Folders(t *testing.T) {
+	ctx := context.Background()
+	s3, _ := newTestS3Storage(t)
+
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for name, content := range map[string]string{"adapter_config.json": "{}", "weights/adapter_model.bin": "weights"} {
+		require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tarWriter.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tarWriter.Close())
+
+	require.NoError(t, s3.UploadFolder(ctx, "sessions/1/lora", &buf))
+
+	reader, err := s3.DownloadFolder(ctx, "sessions/1/lora")
+	require.NoError(t, err)
+	files := map[string]string{}
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tarReader)
+		require.NoError(t, err)
+		files[header.Name] = string(content)
+	}
+	assert.Equal(t, map[string]string{"adapter_config.json": "{}", "weights/adapter_model.bin": "weights"}, files)
+
+	urls, err := s3.PresignDownload(ctx, "sessions/1/lora")
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	assert.Contains(t, urls["weights/adapter_model.bin"], "/bucket/helix/sessions/1/lora/weights/adapter_model.bin?")
+	assert.Contains(t, urls["weights/adapter_model.bin"], "X-Amz-Expires=900")
+
+	urls, err = s3.PresignDownload(ctx, "sessions/1/lora/adapter_config.json")
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+	assert.Contains(t, urls, "adapter_config.json")
+}
+
+func TestS3Storage_PresignUpload(t *testing.T) {
+	s3, _ := newTestS3Storage(t)
+
+	url, headers, err := s3.PresignUpload(context.Background(), "sessions/1/results/out.txt", "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+	require.NoError(t, err)
+	assert.Contains(t, url, "/bucket/helix/sessions/1/results/out.txt?")
+	assert.Contains(t, url, "X-Amz-SignedHeaders=host%3Bx-amz-checksum-sha256")
+	assert.Equal(t, map[string]string{"X-Amz-Checksum-Sha256": "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="}, headers)
+
+	_, _, err = s3.PresignUpload(context.Background(), "sessions/1/results/out.txt", "not hex")
+	assert.Error(t, err)
+}
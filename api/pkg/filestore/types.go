@@ -3,6 +3,7 @@ package filestore
 import (
 	"context"
 	"io"
+	"time"
 )
 
 type FileStoreType string
@@ -10,6 +11,7 @@ type FileStoreType string
 const (
 	FileStoreTypeLocalFS  FileStoreType = "fs"
 	FileStoreTypeLocalGCS FileStoreType = "gcs"
+	FileStoreTypeS3       FileStoreType = "s3"
 )
 
 type FileStoreOptions struct {
@@ -18,6 +20,16 @@ type FileStoreOptions struct {
 	GCSKeyBase64 string
 	GCSKeyFile   string
 	GCSBucket    string
+	S3Bucket     string
+	S3Region     string
+	// a key prefix in the bucket so it can be shared with other things
+	S3Prefix string
+	// for S3 compatible stores like minio - leave empty for AWS
+	S3Endpoint        string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	// how long the urls we hand out to runners are valid for
+	S3PresignExpiry time.Duration
 }
 
 type FileStoreItem struct {
@@ -65,3 +77,15 @@ type FileStore interface {
 	Delete(ctx context.Context, path string) error
 	CopyFile(ctx context.Context, from string, to string) error
 }
+
+// a filestore that can hand out short lived urls so runners can move big
+// files straight to and from it rather than through the api
+type PresignedFileStore interface {
+	FileStore
+	// urls to GET the file at path or every file under it if it's a folder
+	// keyed by the path relative to the folder (or the file name)
+	PresignDownload(ctx context.Context, path string) (map[string]string, error)
+	// a url to PUT the file at path along with headers that must be sent
+	// with it - if a (hex sha256) checksum is given the upload must match it
+	PresignUpload(ctx context.Context, path string, checksum string) (string, map[string]string, error)
+}
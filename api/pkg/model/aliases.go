@@ -0,0 +1,105 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// the alias used when a request doesn't name a model
+const DefaultModelAlias = "default"
+
+// short names users can ask for instead of the full model name - operators
+// can point them somewhere else (especially "default") without clients
+// having to change
+func DefaultModelAliases() map[string]types.ModelName {
+	return map[string]types.ModelName{
+		DefaultModelAlias: types.Model_Axolotl_Mistral7b,
+		"mistral-7b":      types.Model_Axolotl_Mistral7b,
+		"sdxl":            types.Model_Axolotl_SDXL,
+		"gemma-7b":        types.Model_Ollama_Gemma7b,
+		"llama3-8b":       types.Model_Ollama_Llama3_8b,
+		"phi3":            types.Model_Ollama_Phi3,
+	}
+}
+
+// returned (wrapped with the valid names) when a request asks for a model
+// that is neither a model name nor an alias
+var ErrUnknownModel = errors.New("unknown model")
+
+var (
+	modelAliasesMu sync.RWMutex
+	modelAliases   = DefaultModelAliases()
+)
+
+// parse a JSON object of aliases e.g. {"default": "llama3:instruct"} and lay
+// it over the defaults - an empty string gives the defaults
+func ParseModelAliases(overrides string) (map[string]types.ModelName, error) {
+	aliases := DefaultModelAliases()
+	if overrides == "" {
+		return aliases, nil
+	}
+	parsed := map[string]string{}
+	err := json.Unmarshal([]byte(overrides), &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing model aliases: %s", err.Error())
+	}
+	models, err := GetModels()
+	if err != nil {
+		return nil, err
+	}
+	for alias, modelName := range parsed {
+		if _, ok := models[types.ModelName(alias)]; ok {
+			return nil, fmt.Errorf("model alias %q is already the name of a model", alias)
+		}
+		if _, ok := models[types.ModelName(modelName)]; !ok {
+			return nil, fmt.Errorf("model alias %q points at unknown model %q, valid models are: %s", alias, modelName, strings.Join(validModelNames(), ", "))
+		}
+		aliases[alias] = types.ModelName(modelName)
+	}
+	return aliases, nil
+}
+
+// replace the aliases ResolveModelName and GetModel know about
+func SetModelAliases(aliases map[string]types.ModelName) {
+	modelAliasesMu.Lock()
+	defer modelAliasesMu.Unlock()
+	modelAliases = aliases
+}
+
+func modelAliasNames() []string {
+	modelAliasesMu.RLock()
+	defer modelAliasesMu.RUnlock()
+	names := []string{}
+	for alias := range modelAliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// turn a full model name or an alias into the full model name - full names
+// always win so an alias can't hide a model
+func ResolveModelName(name string) (types.ModelName, error) {
+	models, err := GetModels()
+	if err != nil {
+		return types.Model_None, err
+	}
+	if _, ok := models[types.ModelName(name)]; ok {
+		return types.ModelName(name), nil
+	}
+
+	modelAliasesMu.RLock()
+	modelName, ok := modelAliases[name]
+	modelAliasesMu.RUnlock()
+	if ok {
+		return modelName, nil
+	}
+
+	return types.Model_None, fmt.Errorf("%w %q, valid models are: %s, valid aliases are: %s", ErrUnknownModel, name, strings.Join(validModelNames(), ", "), strings.Join(modelAliasNames(), ", "))
+}
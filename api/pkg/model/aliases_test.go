@@ -0,0 +1,78 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func Test_ResolveModelName(t *testing.T) {
+	modelName, err := ResolveModelName("mistral-7b")
+	require.NoError(t, err)
+	assert.Equal(t, types.Model_Axolotl_Mistral7b, modelName)
+
+	modelName, err = ResolveModelName(DefaultModelAlias)
+	require.NoError(t, err)
+	assert.Equal(t, types.Model_Axolotl_Mistral7b, modelName)
+
+	// full names keep working
+	modelName, err = ResolveModelName(string(types.Model_Ollama_Phi3))
+	require.NoError(t, err)
+	assert.Equal(t, types.Model_Ollama_Phi3, modelName)
+
+	_, err = ResolveModelName("gpt-4")
+	assert.ErrorContains(t, err, `unknown model "gpt-4", valid models are: `)
+	assert.ErrorContains(t, err, string(types.Model_Ollama_Phi3))
+	assert.ErrorContains(t, err, "valid aliases are: default, gemma-7b, llama3-8b, mistral-7b, phi3, sdxl")
+}
+
+func Test_GetModel_Alias(t *testing.T) {
+	model, err := GetModel("phi3")
+	require.NoError(t, err)
+	assert.IsType(t, &OllamaPhi3MiniInstruct{}, model)
+
+	_, err = GetModel("gpt-4")
+	assert.ErrorContains(t, err, `unknown model "gpt-4"`)
+}
+
+func Test_ParseModelAliases(t *testing.T) {
+	aliases, err := ParseModelAliases("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultModelAliases(), aliases)
+
+	aliases, err = ParseModelAliases(`{"default": "llama3:instruct", "fast": "phi3:instruct"}`)
+	require.NoError(t, err)
+	assert.Equal(t, types.Model_Ollama_Llama3_8b, aliases[DefaultModelAlias])
+	assert.Equal(t, types.Model_Ollama_Phi3, aliases["fast"])
+	assert.Equal(t, types.Model_Axolotl_SDXL, aliases["sdxl"])
+
+	_, err = ParseModelAliases(`{"fast": "gpt-4"}`)
+	assert.ErrorContains(t, err, `model alias "fast" points at unknown model "gpt-4"`)
+
+	_, err = ParseModelAliases(`{"phi3:instruct": "llama3:instruct"}`)
+	assert.EqualError(t, err, `model alias "phi3:instruct" is already the name of a model`)
+
+	_, err = ParseModelAliases(`not json`)
+	assert.ErrorContains(t, err, "error parsing model aliases")
+}
+
+func Test_SetModelAliases(t *testing.T) {
+	aliases, err := ParseModelAliases(`{"default": "llama3:instruct"}`)
+	require.NoError(t, err)
+	SetModelAliases(aliases)
+	t.Cleanup(func() {
+		SetModelAliases(DefaultModelAliases())
+	})
+
+	// the operator moved the default without clients changing
+	modelName, err := ResolveModelName(DefaultModelAlias)
+	require.NoError(t, err)
+	assert.Equal(t, types.Model_Ollama_Llama3_8b, modelName)
+
+	assert.NoError(t, ValidateSessionRequest(types.SessionModeInference, types.SessionTypeText, DefaultModelAlias))
+	err = ValidateSessionRequest(types.SessionModeFinetune, types.SessionTypeText, DefaultModelAlias)
+	assert.EqualError(t, err, `model "llama3:instruct" does not support finetune, it supports: inference, action`)
+}
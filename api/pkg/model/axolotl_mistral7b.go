@@ -79,15 +79,17 @@ func formatPrompt(session *types.Session) string {
 	return strings.Join(messages, "\n") + "\n"
 }
 
-func (l *Mistral7bInstruct01) GetTextStreams(mode types.SessionMode, eventHandler WorkerEventHandler) (*TextStream, *TextStream, error) {
+func (l *Mistral7bInstruct01) GetTextStreams(mode types.SessionMode, eventHandler WorkerEventHandler, options TextStreamOptions) (*TextStream, *TextStream, error) {
 	if mode == types.SessionModeInference {
 		// this understands the context of each word and keeps state
 		// to manage the session output window and emit events
 		// via the event handler
 		chunker := newMistral7bInferenceChunker(eventHandler, mistral7bInferenceChunkerOptions{
 			// no buffering - send every single word
-			bufferSize: 0,
-			mistral:    l,
+			bufferSize:    0,
+			mistral:       l,
+			stopSequences: options.StopSequences,
+			onStop:        options.OnStop,
 		})
 
 		// this will get called for each word
@@ -193,6 +195,7 @@ func (l *Mistral7bInstruct01) getMockCommand(ctx context.Context, sessionFilter
 		fmt.Sprintf("APP_FOLDER=%s", path.Clean(path.Join(wd, "..", "axolotl"))),
 		fmt.Sprintf("HELIX_NEXT_TASK_URL=%s", config.NextTaskURL),
 		fmt.Sprintf("HELIX_INITIAL_SESSION_URL=%s", config.InitialSessionURL),
+		fmt.Sprintf("HELIX_STOP_TASK_URL=%s", config.StopTaskURL),
 		fmt.Sprintf("HELIX_MOCK_ERROR=%s", config.MockRunnerError),
 		fmt.Sprintf("HELIX_MOCK_DELAY=%d", config.MockRunnerDelay),
 	}
@@ -235,6 +238,7 @@ func (l *Mistral7bInstruct01) GetCommand(ctx context.Context, sessionFilter type
 		fmt.Sprintf("APP_FOLDER=%s", path.Clean(path.Join(wd, "..", "axolotl"))),
 		fmt.Sprintf("HELIX_NEXT_TASK_URL=%s", config.NextTaskURL),
 		fmt.Sprintf("HELIX_INITIAL_SESSION_URL=%s", config.InitialSessionURL),
+		fmt.Sprintf("HELIX_STOP_TASK_URL=%s", config.StopTaskURL),
 	}
 	if os.Getenv("CUDA_VISIBLE_DEVICES") != "" {
 		cmd.Env = append(cmd.Env, fmt.Sprintf(
@@ -251,6 +255,10 @@ type mistral7bInferenceChunkerOptions struct {
 	bufferSize int
 	// need to access turns: how many user requests (used to identify boundary between input and output)
 	mistral *Mistral7bInstruct01
+	// the stop sequences for a session (can be nil)
+	stopSequences func(sessionID string) []string
+	// called when we cut a session short at a stop sequence (can be nil)
+	onStop func(sessionID string)
 }
 
 type mistral7bInferenceChunker struct {
@@ -262,8 +270,19 @@ type mistral7bInferenceChunker struct {
 	// so we can submit a complete result when we are complete with a single session
 	bufferSession string
 	// this means "have we seen the [/INST] so are now into the answer?"
-	active       bool
+	active bool
+	// the token usage if the inference script reported it for this session
+	usage        *types.Usage
 	eventHandler WorkerEventHandler
+	// the stop sequences for the current session
+	stops []string
+	// how much of bufferSession has been let through to the stream - we hold
+	// back anything that could be the start of a stop sequence until the
+	// next word tells us whether it is or not
+	released int
+	// we hit a stop sequence and have already sent the result so we ignore
+	// the rest of the output until the session ends
+	stopped bool
 }
 
 func newMistral7bInferenceChunker(eventHandler WorkerEventHandler, options mistral7bInferenceChunkerOptions) *mistral7bInferenceChunker {
@@ -278,13 +297,66 @@ func newMistral7bInferenceChunker(eventHandler WorkerEventHandler, options mistr
 }
 
 func (chunker *mistral7bInferenceChunker) addBuffer(word string) {
-	chunker.bufferStream += word + " "
 	chunker.bufferSession += word + " "
+
+	// a stop sequence can't start in text we have already released
+	// otherwise it would have been held back
+	if index := indexStopSequence(chunker.bufferSession[chunker.released:], chunker.stops); index >= 0 {
+		chunker.bufferSession = chunker.bufferSession[:chunker.released+index]
+		chunker.bufferStream += chunker.bufferSession[chunker.released:]
+		chunker.stop()
+		return
+	}
+
+	release := len(chunker.bufferSession) - stopSequencePrefixLength(chunker.bufferSession, chunker.stops)
+	chunker.bufferStream += chunker.bufferSession[chunker.released:release]
+	chunker.released = release
 	if len(chunker.bufferStream) > chunker.options.bufferSize {
 		chunker.emitStream()
 	}
 }
 
+// finish the session at a stop sequence without waiting for the process
+func (chunker *mistral7bInferenceChunker) stop() {
+	log.Debug().Str("session_id", chunker.sessionID).Msg("👉 stop sequence")
+	if chunker.bufferStream != "" {
+		chunker.emitStream()
+	}
+	chunker.emitStreamDone()
+	chunker.emitResult()
+	chunker.stopped = true
+	if chunker.options.onStop != nil {
+		chunker.options.onStop(chunker.sessionID)
+	}
+}
+
+// the index of the earliest stop sequence in text or -1
+func indexStopSequence(text string, stops []string) int {
+	found := -1
+	for _, stop := range stops {
+		index := strings.Index(text, stop)
+		if index >= 0 && (found < 0 || index < found) {
+			found = index
+		}
+	}
+	return found
+}
+
+// the length of the longest end of text that is the start of a stop sequence
+// i.e. the stop sequence might be split across this word and the next
+func stopSequencePrefixLength(text string, stops []string) int {
+	longest := 0
+	for _, stop := range stops {
+		for length := len(stop) - 1; length > longest; length-- {
+			if strings.HasSuffix(text, stop[:length]) {
+				longest = length
+				break
+			}
+		}
+	}
+	return longest
+}
+
 func (chunker *mistral7bInferenceChunker) emitStream() {
 	chunker.eventHandler(&types.RunnerTaskResponse{
 		Type:      types.WorkerTaskResponseTypeStream,
@@ -308,6 +380,7 @@ func (chunker *mistral7bInferenceChunker) emitResult() {
 		Type:      types.WorkerTaskResponseTypeResult,
 		SessionID: chunker.sessionID,
 		Message:   chunker.bufferSession,
+		Usage:     chunker.usage,
 	})
 	chunker.bufferSession = ""
 }
@@ -326,8 +399,33 @@ func (chunker *mistral7bInferenceChunker) write(word string) error {
 		}
 		chunker.sessionID = parts[1]
 		chunker.active = true
+		if chunker.options.stopSequences != nil {
+			chunker.stops = chunker.options.stopSequences(chunker.sessionID)
+		}
+	} else if strings.HasPrefix(word, "[SESSION_USAGE]") {
+		// [SESSION_USAGE]prompt_tokens=12,completion_tokens=34
+		usage, err := parseSessionUsage(strings.TrimPrefix(strings.TrimSpace(word), "[SESSION_USAGE]"))
+		if err != nil {
+			// not knowing the usage shouldn't fail the whole session
+			log.Warn().Err(err).Str("session_id", chunker.sessionID).Msg("error parsing session usage")
+			return nil
+		}
+		chunker.usage = usage
 	} else if strings.HasPrefix(word, "[SESSION_END]") {
 		log.Debug().Msg("👉 case 2")
+		// we already sent the result when we hit the stop sequence
+		if chunker.stopped {
+			chunker.reset()
+			return nil
+		}
+
+		// let through anything we were holding back
+		// in case it was the start of a stop sequence
+		if chunker.released < len(chunker.bufferSession) {
+			chunker.bufferStream += chunker.bufferSession[chunker.released:]
+			chunker.emitStream()
+		}
+
 		// Signal that we are done with this session for
 		// any streaming clients
 		chunker.emitStreamDone()
@@ -338,7 +436,7 @@ func (chunker *mistral7bInferenceChunker) write(word string) error {
 		chunker.reset()
 	} else if chunker.sessionID != "" {
 		log.Debug().Msg("👉 case 3")
-		if chunker.active {
+		if chunker.active && !chunker.stopped {
 			if strings.HasSuffix(word, "</s>\n") {
 				word = strings.Replace(word, "</s>", "", 1)
 			}
@@ -354,6 +452,38 @@ func (chunker *mistral7bInferenceChunker) reset() {
 	chunker.bufferStream = ""
 	chunker.bufferSession = ""
 	chunker.active = false
+	chunker.usage = nil
+	chunker.stops = nil
+	chunker.released = 0
+	chunker.stopped = false
+}
+
+// parse the comma separated key=value pairs the inference script prints
+// if it knows how many tokens it used e.g. prompt_tokens=12,completion_tokens=34
+func parseSessionUsage(line string) (*types.Usage, error) {
+	usage := &types.Usage{}
+	for _, pair := range strings.Split(line, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid session usage line: %s", line)
+		}
+		value, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid session usage line: %s", line)
+		}
+		switch parts[0] {
+		case "prompt_tokens":
+			usage.PromptTokens = value
+		case "completion_tokens":
+			usage.CompletionTokens = value
+		case "total_tokens":
+			usage.TotalTokens = value
+		}
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	return usage, nil
 }
 
 type mistral7bFinetuneChunkerOptions struct {
@@ -112,3 +112,111 @@ a8
 		})
 	}
 }
+
+func Test_mistral7bInferenceChunker_Usage(t *testing.T) {
+	var results []*types.RunnerTaskResponse
+	chunker := newMistral7bInferenceChunker(func(res *types.RunnerTaskResponse) {
+		if res.Type == types.WorkerTaskResponseTypeResult {
+			results = append(results, res)
+		}
+	}, mistral7bInferenceChunkerOptions{})
+
+	for _, word := range []string{
+		"[SESSION_START]session_id=1", "hello", "[SESSION_USAGE]prompt_tokens=12,completion_tokens=34", "[SESSION_END]session_id=1",
+		"[SESSION_START]session_id=2", "hello", "[SESSION_END]session_id=2",
+		"[SESSION_START]session_id=3", "hello", "[SESSION_USAGE]prompt_tokens=lots", "[SESSION_END]session_id=3",
+	} {
+		err := chunker.write(word)
+		assert.NoError(t, err)
+	}
+
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, &types.Usage{PromptTokens: 12, CompletionTokens: 34, TotalTokens: 46}, results[0].Usage)
+		assert.Equal(t, "hello ", results[0].Message)
+		// no usage reported means we don't guess
+		assert.Nil(t, results[1].Usage)
+		// a usage line we can't parse still gives the session a result
+		assert.Nil(t, results[2].Usage)
+		assert.Equal(t, "hello ", results[2].Message)
+	}
+}
+
+func Test_mistral7bInferenceChunker_StopSequences(t *testing.T) {
+	var streamed string
+	var results []*types.RunnerTaskResponse
+	var stopped []string
+	chunker := newMistral7bInferenceChunker(func(res *types.RunnerTaskResponse) {
+		if res.Type == types.WorkerTaskResponseTypeResult {
+			results = append(results, res)
+			return
+		}
+		streamed += res.Message
+	}, mistral7bInferenceChunkerOptions{
+		stopSequences: func(sessionID string) []string {
+			if sessionID == "1" {
+				return []string{"two three", "END"}
+			}
+			return nil
+		},
+		onStop: func(sessionID string) {
+			stopped = append(stopped, sessionID)
+		},
+	})
+
+	for _, word := range []string{
+		// the stop sequence is split across words
+		"[SESSION_START]session_id=1", "one", "two", "three", "four", "[SESSION_USAGE]prompt_tokens=1,completion_tokens=4", "[SESSION_END]session_id=1",
+		// no stop sequences so "two" isn't held back
+		"[SESSION_START]session_id=2", "one", "two", "[SESSION_END]session_id=2",
+	} {
+		err := chunker.write(word)
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"1"}, stopped)
+	// nothing past the stop sequence made it out
+	assert.Equal(t, "one one two ", streamed)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, "1", results[0].SessionID)
+		assert.Equal(t, "one ", results[0].Message)
+		assert.Equal(t, "2", results[1].SessionID)
+		assert.Equal(t, "one two ", results[1].Message)
+	}
+}
+
+func Test_mistral7bInferenceChunker_StopSequencePrefixReleased(t *testing.T) {
+	var streamed []string
+	var results []*types.RunnerTaskResponse
+	chunker := newMistral7bInferenceChunker(func(res *types.RunnerTaskResponse) {
+		if res.Type == types.WorkerTaskResponseTypeResult {
+			results = append(results, res)
+			return
+		}
+		streamed = append(streamed, res.Message)
+	}, mistral7bInferenceChunkerOptions{
+		stopSequences: func(sessionID string) []string {
+			return []string{"two three"}
+		},
+	})
+
+	for _, word := range []string{"[SESSION_START]session_id=1", "one", "two", "[SESSION_END]session_id=1"} {
+		err := chunker.write(word)
+		assert.NoError(t, err)
+	}
+
+	// "two " was held back in case "three" came next but the session ended
+	assert.Equal(t, []string{"one ", "two ", ""}, streamed)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "one two ", results[0].Message)
+	}
+}
+
+func Test_stopSequencePrefixLength(t *testing.T) {
+	stops := []string{"###", "<|end|>"}
+	assert.Equal(t, 0, stopSequencePrefixLength("hello ", stops))
+	assert.Equal(t, 2, stopSequencePrefixLength("hello ##", stops))
+	assert.Equal(t, 3, stopSequencePrefixLength("hello <|e", stops))
+	// a whole stop sequence isn't a prefix - it gets found instead
+	assert.Equal(t, 0, stopSequencePrefixLength("hello", []string{"o"}))
+	assert.Equal(t, 0, stopSequencePrefixLength("hello ##", nil))
+}
@@ -55,7 +55,7 @@ func (l *CogSDXL) GetTask(session *types.Session, fileManager ModelSessionFileMa
 	return task, nil
 }
 
-func (l *CogSDXL) GetTextStreams(mode types.SessionMode, eventHandler WorkerEventHandler) (*TextStream, *TextStream, error) {
+func (l *CogSDXL) GetTextStreams(mode types.SessionMode, eventHandler WorkerEventHandler, options TextStreamOptions) (*TextStream, *TextStream, error) {
 	progressActivationWord := ""
 	if mode == types.SessionModeFinetune {
 		progressActivationWord = ":step:"
@@ -219,8 +219,13 @@ type CogSDXLChunkerOptions struct {
 type CogSDXLChunker struct {
 	sessionID      string
 	progressActive bool
-	options        CogSDXLChunkerOptions
-	eventHandler   WorkerEventHandler
+	// inference reports its steps with [SESSION_PROGRESS] which covers the
+	// refiner too, once we see that the tqdm bars are ignored as they
+	// start again from zero for each pipeline
+	stepProgress bool
+	progress     int
+	options      CogSDXLChunkerOptions
+	eventHandler WorkerEventHandler
 }
 
 func newCogSDXLChunker(eventHandler WorkerEventHandler, options CogSDXLChunkerOptions) *CogSDXLChunker {
@@ -233,6 +238,7 @@ func newCogSDXLChunker(eventHandler WorkerEventHandler, options CogSDXLChunkerOp
 }
 
 func (chunker *CogSDXLChunker) emitProgress(progress int) {
+	chunker.progress = progress
 	chunker.eventHandler(&types.RunnerTaskResponse{
 		Type:      types.WorkerTaskResponseTypeProgress,
 		SessionID: chunker.sessionID,
@@ -240,6 +246,17 @@ func (chunker *CogSDXLChunker) emitProgress(progress int) {
 	})
 }
 
+// an image part way through generating, the runner uploads it before it
+// goes on to the browser
+func (chunker *CogSDXLChunker) emitPreview(file string) {
+	chunker.eventHandler(&types.RunnerTaskResponse{
+		Type:      types.WorkerTaskResponseTypeProgress,
+		SessionID: chunker.sessionID,
+		Progress:  chunker.progress,
+		Files:     []string{file},
+	})
+}
+
 func (chunker *CogSDXLChunker) emitResult(files []string) {
 	chunker.eventHandler(&types.RunnerTaskResponse{
 		Type:      types.WorkerTaskResponseTypeResult,
@@ -283,7 +300,25 @@ func (chunker *CogSDXLChunker) write(word string) error {
 		parts := strings.Split(word, "=")
 		chunker.emitLora(parts[1])
 		chunker.reset()
-	} else if chunker.sessionID != "" {
+	} else if strings.HasPrefix(word, "[SESSION_PROGRESS]") {
+		// e.g. [SESSION_PROGRESS]step=12/65
+		if chunker.sessionID == "" {
+			return nil
+		}
+		progress, err := parseStepProgress(strings.TrimPrefix(word, "[SESSION_PROGRESS]step="))
+		if err != nil {
+			return err
+		}
+		chunker.stepProgress = true
+		chunker.emitProgress(progress)
+	} else if strings.HasPrefix(word, "[SESSION_PREVIEW]") {
+		// e.g. [SESSION_PREVIEW]image=/tmp/helix-previews/preview_7d11a9ef-a192-426c-bc8e-6bd2c6364b46_010.png
+		parts := strings.SplitN(word, "=", 2)
+		if chunker.sessionID == "" || len(parts) < 2 || parts[1] == "" {
+			return nil
+		}
+		chunker.emitPreview(parts[1])
+	} else if chunker.sessionID != "" && !chunker.stepProgress {
 		if chunker.options.progressActivationWord != "" && !chunker.progressActive && word == chunker.options.progressActivationWord {
 			chunker.progressActive = true
 		}
@@ -304,6 +339,25 @@ func (chunker *CogSDXLChunker) write(word string) error {
 func (chunker *CogSDXLChunker) reset() {
 	chunker.sessionID = ""
 	chunker.progressActive = false
+	chunker.stepProgress = false
+	chunker.progress = 0
+}
+
+// "12/65" as a percentage
+func parseStepProgress(steps string) (int, error) {
+	parts := strings.Split(steps, "/")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid step progress: %s", steps)
+	}
+	step, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid step progress: %s", steps)
+	}
+	total, err := strconv.Atoi(parts[1])
+	if err != nil || total <= 0 || step < 0 || step > total {
+		return 0, fmt.Errorf("invalid step progress: %s", steps)
+	}
+	return step * 100 / total, nil
 }
 
 // Compile-time interface check:
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/helixml/helix/api/pkg/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_cogSDXLChunker_StepProgress(t *testing.T) {
+	var responses []*types.RunnerTaskResponse
+	chunker := newCogSDXLChunker(func(res *types.RunnerTaskResponse) {
+		responses = append(responses, res)
+	}, CogSDXLChunkerOptions{})
+
+	for _, word := range []string{
+		// progress from before the session started is not ours
+		"[SESSION_PROGRESS]step=1/65",
+		"[SESSION_START]session_id=1",
+		"[SESSION_PROGRESS]step=13/65",
+		// the refiner's tqdm bar starts again from zero
+		"2%|",
+		"[SESSION_PREVIEW]image=/tmp/helix-previews/preview_1_020.png",
+		"[SESSION_PROGRESS]step=65/65",
+		`[SESSION_END_IMAGES]images=["/tmp/out-0.png"]`,
+	} {
+		require.NoError(t, chunker.write(word))
+	}
+
+	require.Len(t, responses, 4)
+	assert.Equal(t, &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeProgress, SessionID: "1", Progress: 20}, responses[0])
+	assert.Equal(t, &types.RunnerTaskResponse{
+		Type:      types.WorkerTaskResponseTypeProgress,
+		SessionID: "1",
+		Progress:  20,
+		Files:     []string{"/tmp/helix-previews/preview_1_020.png"},
+	}, responses[1])
+	assert.Equal(t, 100, responses[2].Progress)
+	assert.Equal(t, types.WorkerTaskResponseTypeResult, responses[3].Type)
+	assert.Equal(t, []string{"/tmp/out-0.png"}, responses[3].Files)
+
+	// the next session goes back to following tqdm until it reports steps
+	responses = nil
+	require.NoError(t, chunker.write("[SESSION_START]session_id=2"))
+	require.NoError(t, chunker.write("40%|"))
+	require.Len(t, responses, 1)
+	assert.Equal(t, 40, responses[0].Progress)
+
+	assert.Error(t, chunker.write("[SESSION_PROGRESS]step=70/65"))
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"sort"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// none of the models we run understand images yet, the ollama runtime
+// passes them on so a vision model only needs adding here
+var visionModels = map[types.ModelName]bool{}
+
+func modelCapabilities(modelName types.ModelName, model Model) types.ModelCapabilities {
+	return types.ModelCapabilities{
+		// text models send their answer as it is generated, images arrive
+		// all at once
+		Streaming: model.GetType() == types.SessionTypeText,
+		Vision:    visionModels[modelName],
+		Finetune:  SupportsSessionMode(modelName, types.SessionModeFinetune),
+	}
+}
+
+// alias names for each model they currently point at
+func modelAliasesByModel() map[types.ModelName][]string {
+	modelAliasesMu.RLock()
+	defer modelAliasesMu.RUnlock()
+	byModel := map[types.ModelName][]string{}
+	for alias, modelName := range modelAliases {
+		byModel[modelName] = append(byModel[modelName], alias)
+	}
+	for _, aliases := range byModel {
+		sort.Strings(aliases)
+	}
+	return byModel
+}
+
+// the models sessions can be started with, sorted by name - an empty
+// capability lists them all, otherwise only the models that have it
+func ListModels(capability types.ModelCapability) ([]*types.ModelInfo, error) {
+	models, err := GetModels()
+	if err != nil {
+		return nil, err
+	}
+	aliases := modelAliasesByModel()
+
+	infos := []*types.ModelInfo{}
+	for modelName, model := range models {
+		capabilities := modelCapabilities(modelName, model)
+		if capability != "" && !capabilities.Has(capability) {
+			continue
+		}
+
+		modes := SupportedSessionModes(modelName)
+		memory := map[types.SessionMode]uint64{}
+		for _, mode := range modes {
+			memory[mode] = model.GetMemoryRequirements(mode)
+		}
+
+		modelAliases := aliases[modelName]
+		if modelAliases == nil {
+			modelAliases = []string{}
+		}
+
+		infos = append(infos, &types.ModelInfo{
+			Name:               modelName,
+			Type:               model.GetType(),
+			Runtime:            modelName.InferenceRuntime(),
+			Aliases:            modelAliases,
+			Modes:              modes,
+			MemoryRequirements: memory,
+			Capabilities:       capabilities,
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	return infos, nil
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func Test_ListModels(t *testing.T) {
+	models, err := ListModels("")
+	require.NoError(t, err)
+
+	all, err := GetModels()
+	require.NoError(t, err)
+	require.Len(t, models, len(all))
+
+	byName := map[types.ModelName]*types.ModelInfo{}
+	for _, info := range models {
+		byName[info.Name] = info
+	}
+
+	mistral := byName[types.Model_Axolotl_Mistral7b]
+	require.NotNil(t, mistral)
+	assert.Equal(t, []string{DefaultModelAlias, "mistral-7b"}, mistral.Aliases)
+	assert.Equal(t, types.ModelCapabilities{Streaming: true, Finetune: true}, mistral.Capabilities)
+	assert.Equal(t, MB*6440, mistral.MemoryRequirements[types.SessionModeInference])
+
+	sdxl := byName[types.Model_Axolotl_SDXL]
+	require.NotNil(t, sdxl)
+	assert.Equal(t, types.SessionTypeImage, sdxl.Type)
+	assert.False(t, sdxl.Capabilities.Streaming)
+
+	// ollama can't fine tune so there's no memory requirement for it
+	phi3 := byName[types.Model_Ollama_Phi3]
+	require.NotNil(t, phi3)
+	assert.False(t, phi3.Capabilities.Finetune)
+	assert.NotContains(t, phi3.MemoryRequirements, types.SessionModeFinetune)
+	assert.Equal(t, types.InferenceRuntimeOllama, phi3.Runtime)
+}
+
+func Test_ListModels_Capability(t *testing.T) {
+	models, err := ListModels(types.ModelCapabilityFinetune)
+	require.NoError(t, err)
+
+	names := []types.ModelName{}
+	for _, info := range models {
+		names = append(names, info.Name)
+	}
+	assert.Equal(t, []types.ModelName{types.Model_Axolotl_Mistral7b, types.Model_Axolotl_SDXL}, names)
+}
+
+func Test_ListModels_Aliases(t *testing.T) {
+	defer SetModelAliases(DefaultModelAliases())
+	SetModelAliases(map[string]types.ModelName{
+		DefaultModelAlias: types.Model_Ollama_Llama3_8b,
+		"fast":            types.Model_Ollama_Llama3_8b,
+	})
+
+	models, err := ListModels("")
+	require.NoError(t, err)
+
+	for _, info := range models {
+		if info.Name == types.Model_Ollama_Llama3_8b {
+			assert.Equal(t, []string{DefaultModelAlias, "fast"}, info.Aliases)
+		} else {
+			assert.Empty(t, info.Aliases, info.Name)
+		}
+	}
+}
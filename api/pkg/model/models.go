@@ -6,8 +6,14 @@ import (
 	"github.com/helixml/helix/api/pkg/types"
 )
 
+// the model name can be the full name or one of the aliases
 func GetModel(modelName types.ModelName) (Model, error) {
-	switch modelName {
+	resolved, err := ResolveModelName(string(modelName))
+	if err != nil {
+		return nil, err
+	}
+
+	switch resolved {
 	case types.Model_Axolotl_Mistral7b:
 		return &Mistral7bInstruct01{}, nil
 	case types.Model_Axolotl_SDXL:
@@ -16,6 +22,10 @@ func GetModel(modelName types.ModelName) (Model, error) {
 		return &OllamaMistral7bInstruct01{}, nil
 	case types.Model_Ollama_Gemma7b:
 		return &OllamaGemma7bInstruct01{}, nil
+	case types.Model_Ollama_Llama3_8b:
+		return &OllamaLlama3_8bInstruct{}, nil
+	case types.Model_Ollama_Phi3:
+		return &OllamaPhi3MiniInstruct{}, nil
 	default:
 		return nil, fmt.Errorf("no model for model name %s", modelName)
 	}
@@ -32,6 +42,8 @@ func GetModels() (map[types.ModelName]Model, error) {
 	// Ollama
 	models[types.Model_Ollama_Mistral7b] = &OllamaMistral7bInstruct01{}
 	models[types.Model_Ollama_Gemma7b] = &OllamaGemma7bInstruct01{}
+	models[types.Model_Ollama_Llama3_8b] = &OllamaLlama3_8bInstruct{}
+	models[types.Model_Ollama_Phi3] = &OllamaPhi3MiniInstruct{}
 	return models, nil
 }
 
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func Test_GetModel_Ollama(t *testing.T) {
+	for _, modelName := range []types.ModelName{
+		types.Model_Ollama_Mistral7b,
+		types.Model_Ollama_Gemma7b,
+		types.Model_Ollama_Llama3_8b,
+		types.Model_Ollama_Phi3,
+	} {
+		model, err := GetModel(modelName)
+		require.NoError(t, err, modelName)
+		assert.Equal(t, types.SessionTypeText, model.GetType())
+		assert.Equal(t, types.InferenceRuntimeOllama, modelName.InferenceRuntime())
+
+		models, err := GetModels()
+		require.NoError(t, err)
+		assert.Contains(t, models, modelName)
+
+		validated, err := types.ValidateModelName(string(modelName), false)
+		require.NoError(t, err)
+		assert.Equal(t, modelName, validated)
+	}
+}
+
+func Test_GetLowestMemoryRequirement(t *testing.T) {
+	lowest, err := GetLowestMemoryRequirement()
+	require.NoError(t, err)
+
+	phi3 := &OllamaPhi3MiniInstruct{}
+	assert.Equal(t, phi3.GetMemoryRequirements(types.SessionModeInference), lowest)
+}
+
+func Test_getGenericTask_SystemPromptOnly(t *testing.T) {
+	temperature := float32(0.5)
+	session := &types.Session{
+		Mode: types.SessionModeInference,
+		Interactions: []*types.Interaction{
+			{ID: "system", Creator: types.CreatorTypeSystem, State: types.InteractionStateWaiting},
+		},
+		Metadata: types.SessionMetadata{
+			SystemPrompt: "You are a pirate, greet the user.",
+			Sampling:     types.SamplingParams{Temperature: &temperature},
+		},
+	}
+
+	task, err := getGenericTask(session)
+	require.NoError(t, err)
+	assert.Equal(t, "", task.Prompt)
+	assert.Equal(t, &temperature, task.Temperature)
+
+	// without a system prompt there is nothing for the model to go on
+	session.Metadata.SystemPrompt = ""
+	_, err = getGenericTask(session)
+	assert.Error(t, err)
+
+	session.Interactions = append([]*types.Interaction{
+		{ID: "user", Creator: types.CreatorTypeUser, Message: "ahoy"},
+	}, session.Interactions...)
+	task, err = getGenericTask(session)
+	require.NoError(t, err)
+	assert.Equal(t, "ahoy", task.Prompt)
+}
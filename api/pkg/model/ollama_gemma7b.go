@@ -34,7 +34,7 @@ func (i *OllamaGemma7bInstruct01) GetCommand(ctx context.Context, sessionFilter
 }
 
 // TODO: remove
-func (i *OllamaGemma7bInstruct01) GetTextStreams(mode types.SessionMode, eventHandler WorkerEventHandler) (*TextStream, *TextStream, error) {
+func (i *OllamaGemma7bInstruct01) GetTextStreams(mode types.SessionMode, eventHandler WorkerEventHandler, options TextStreamOptions) (*TextStream, *TextStream, error) {
 	return nil, nil, fmt.Errorf("not implemented")
 }
 
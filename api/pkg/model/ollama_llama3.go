@@ -0,0 +1,44 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+type OllamaLlama3_8bInstruct struct{}
+
+func (i *OllamaLlama3_8bInstruct) GetMemoryRequirements(mode types.SessionMode) uint64 {
+	return MB * 6390
+}
+
+func (i *OllamaLlama3_8bInstruct) GetType() types.SessionType {
+	return types.SessionTypeText
+}
+
+// TODO: remove
+func (i *OllamaLlama3_8bInstruct) GetTask(session *types.Session, fileManager ModelSessionFileManager) (*types.RunnerTask, error) {
+	task, err := getGenericTask(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+// TODO: remove
+func (i *OllamaLlama3_8bInstruct) GetCommand(ctx context.Context, sessionFilter types.SessionFilter, config types.RunnerProcessConfig) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// TODO: remove
+func (i *OllamaLlama3_8bInstruct) GetTextStreams(mode types.SessionMode, eventHandler WorkerEventHandler, options TextStreamOptions) (*TextStream, *TextStream, error) {
+	return nil, nil, fmt.Errorf("not implemented")
+}
+
+// TODO: remove
+func (i *OllamaLlama3_8bInstruct) PrepareFiles(session *types.Session, isInitialSession bool, fileManager ModelSessionFileManager) (*types.Session, error) {
+	return nil, fmt.Errorf("not implemented")
+}
@@ -32,7 +32,7 @@ func (i *OllamaMistral7bInstruct01) GetCommand(ctx context.Context, sessionFilte
 	return nil, fmt.Errorf("not implemented")
 }
 
-func (i *OllamaMistral7bInstruct01) GetTextStreams(mode types.SessionMode, eventHandler WorkerEventHandler) (*TextStream, *TextStream, error) {
+func (i *OllamaMistral7bInstruct01) GetTextStreams(mode types.SessionMode, eventHandler WorkerEventHandler, options TextStreamOptions) (*TextStream, *TextStream, error) {
 	return nil, nil, fmt.Errorf("not implemented")
 }
 
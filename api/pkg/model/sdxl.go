@@ -42,7 +42,7 @@ func (l *SDXL) GetTask(session *types.Session, fileManager ModelSessionFileManag
 	return task, nil
 }
 
-func (l *SDXL) GetTextStreams(mode types.SessionMode, eventHandler WorkerEventHandler) (*TextStream, *TextStream, error) {
+func (l *SDXL) GetTextStreams(mode types.SessionMode, eventHandler WorkerEventHandler, options TextStreamOptions) (*TextStream, *TextStream, error) {
 	progressActivationWord := ""
 	if mode == types.SessionModeFinetune {
 		progressActivationWord = "steps:"
@@ -9,6 +9,15 @@ import (
 
 type WorkerEventHandler func(res *types.RunnerTaskResponse)
 
+// lets the runner tell a text stream about the session it is parsing
+type TextStreamOptions struct {
+	// the sequences that end a session's output early
+	StopSequences func(sessionID string) []string
+	// called once a session's output has been cut short at a stop sequence
+	// so the process can be told to stop generating for it
+	OnStop func(sessionID string)
+}
+
 type TextStreamType string
 
 const (
@@ -37,7 +46,8 @@ type Model interface {
 	// but it's really up to the model to decide how to parse the output
 	// the eventHandler is the function that is wired up to the runner controller
 	// and will update the api with changes to the given session
-	GetTextStreams(mode types.SessionMode, eventHandler WorkerEventHandler) (*TextStream, *TextStream, error)
+	// the options let the stream ask the runner about the session it is parsing
+	GetTextStreams(mode types.SessionMode, eventHandler WorkerEventHandler, options TextStreamOptions) (*TextStream, *TextStream, error)
 
 	// before we run a session, do we need to download files in preparation
 	// for it?
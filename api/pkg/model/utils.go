@@ -21,16 +21,24 @@ func getGenericTask(session *types.Session) (*types.RunnerTask, error) {
 		return nil, fmt.Errorf("session has no messages")
 	}
 	lastInteraction, err := data.GetUserInteraction(session)
-	if err != nil {
-		return nil, err
-	}
-	if lastInteraction == nil {
+	if err != nil || lastInteraction == nil {
+		// a session with just a system prompt is how a prompt is tried out,
+		// the model answers as if it was starting the conversation
+		if session.Mode == types.SessionModeInference && session.Metadata.SystemPrompt != "" {
+			return &types.RunnerTask{
+				LoraDir:        session.LoraDir,
+				SamplingParams: session.Metadata.Sampling,
+			}, nil
+		}
 		return nil, fmt.Errorf("session has no user messages")
 	}
 	if session.Mode == types.SessionModeInference {
 		return &types.RunnerTask{
-			Prompt:  lastInteraction.Message,
-			LoraDir: session.LoraDir,
+			Prompt:         lastInteraction.Message,
+			Images:         lastInteraction.Images,
+			RequestID:      lastInteraction.RequestID,
+			LoraDir:        session.LoraDir,
+			SamplingParams: session.Metadata.Sampling,
 		}, nil
 	} else if session.Mode == types.SessionModeFinetune {
 		if len(lastInteraction.Files) == 0 {
@@ -42,6 +50,7 @@ func getGenericTask(session *types.Session) (*types.RunnerTask, error) {
 		// and pass it into the python job as the input dir
 		return &types.RunnerTask{
 			DatasetDir: path.Dir(lastInteraction.Files[0]),
+			RequestID:  lastInteraction.RequestID,
 		}, nil
 	} else {
 		return nil, fmt.Errorf("invalid session mode")
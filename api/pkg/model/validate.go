@@ -0,0 +1,91 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+var validSessionModes = []types.SessionMode{
+	types.SessionModeInference,
+	types.SessionModeFinetune,
+	types.SessionModeAction,
+}
+
+var validSessionTypes = []types.SessionType{
+	types.SessionTypeText,
+	types.SessionTypeImage,
+}
+
+// the session modes a model can be used for - ollama only serves models so
+// only the axolotl models can be fine tuned
+func SupportedSessionModes(modelName types.ModelName) []types.SessionMode {
+	if modelName.InferenceRuntime() == types.InferenceRuntimeOllama {
+		return []types.SessionMode{types.SessionModeInference, types.SessionModeAction}
+	}
+	return validSessionModes
+}
+
+func SupportsSessionMode(modelName types.ModelName, mode types.SessionMode) bool {
+	for _, supported := range SupportedSessionModes(modelName) {
+		if supported == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func validModelNames() []string {
+	models, _ := GetModels()
+	names := []string{}
+	for name := range models {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func joinValues[T ~string](values []T) string {
+	result := []string{}
+	for _, value := range values {
+		result = append(result, string(value))
+	}
+	return strings.Join(result, ", ")
+}
+
+// check the mode, type and model of a new session before we queue it so
+// the user gets a clear error instead of a runner failing later on - the
+// model can be an alias
+func ValidateSessionRequest(mode types.SessionMode, sessionType types.SessionType, modelName types.ModelName) error {
+	_, err := types.ValidateSessionMode(string(mode), false)
+	if err != nil {
+		return fmt.Errorf("invalid session mode %q, valid modes are: %s", mode, joinValues(validSessionModes))
+	}
+
+	_, err = types.ValidateSessionType(string(sessionType), false)
+	if err != nil {
+		return fmt.Errorf("invalid session type %q, valid types are: %s", sessionType, joinValues(validSessionTypes))
+	}
+
+	modelName, err = ResolveModelName(string(modelName))
+	if err != nil {
+		return err
+	}
+
+	model, err := GetModel(modelName)
+	if err != nil {
+		return err
+	}
+
+	if model.GetType() != sessionType {
+		return fmt.Errorf("model %q is a %s model and cannot be used for a %s session", modelName, model.GetType(), sessionType)
+	}
+
+	if !SupportsSessionMode(modelName, mode) {
+		return fmt.Errorf("model %q does not support %s, it supports: %s", modelName, mode, joinValues(SupportedSessionModes(modelName)))
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func Test_ValidateSessionRequest(t *testing.T) {
+	assert.NoError(t, ValidateSessionRequest(types.SessionModeInference, types.SessionTypeText, types.Model_Ollama_Llama3_8b))
+	assert.NoError(t, ValidateSessionRequest(types.SessionModeFinetune, types.SessionTypeText, types.Model_Axolotl_Mistral7b))
+	assert.NoError(t, ValidateSessionRequest(types.SessionModeFinetune, types.SessionTypeImage, types.Model_Axolotl_SDXL))
+
+	err := ValidateSessionRequest("chat", types.SessionTypeText, types.Model_Axolotl_Mistral7b)
+	assert.EqualError(t, err, `invalid session mode "chat", valid modes are: inference, finetune, action`)
+
+	err = ValidateSessionRequest(types.SessionModeInference, "video", types.Model_Axolotl_Mistral7b)
+	assert.EqualError(t, err, `invalid session type "video", valid types are: text, image`)
+
+	err = ValidateSessionRequest(types.SessionModeInference, types.SessionTypeText, "gpt-4")
+	assert.ErrorContains(t, err, `unknown model "gpt-4", valid models are: `)
+	assert.ErrorContains(t, err, string(types.Model_Ollama_Phi3))
+
+	err = ValidateSessionRequest(types.SessionModeInference, types.SessionTypeText, types.Model_Axolotl_SDXL)
+	assert.EqualError(t, err, `model "stabilityai/stable-diffusion-xl-base-1.0" is a image model and cannot be used for a text session`)
+
+	err = ValidateSessionRequest(types.SessionModeFinetune, types.SessionTypeText, types.Model_Ollama_Mistral7b)
+	assert.EqualError(t, err, `model "mistral:7b-instruct" does not support finetune, it supports: inference, action`)
+}
@@ -64,3 +64,7 @@ func (n *Nats) Subscribe(
 func (n *Nats) Publish(ctx context.Context, topic string, payload []byte) error {
 	return n.conn.Publish(topic, payload)
 }
+
+func (n *Nats) Flush(ctx context.Context) error {
+	return n.conn.FlushWithContext(ctx)
+}
@@ -12,6 +12,8 @@ type Publisher interface {
 type PubSub interface {
 	Publisher
 	Subscribe(ctx context.Context, topic string, handler func(payload []byte) error) (Subscription, error)
+	// Flush waits until everything published so far has reached the broker.
+	Flush(ctx context.Context) error
 }
 
 type Subscription interface {
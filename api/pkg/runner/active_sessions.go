@@ -0,0 +1,70 @@
+package runner
+
+import (
+	"sync"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// the sessions a model instance is running at the same time keyed by
+// session ID so that each task response can be matched up with its session
+type activeSessions struct {
+	mu       sync.RWMutex
+	sessions map[string]*types.Session
+	// the order the sessions were added so the oldest comes first
+	order []string
+}
+
+func newActiveSessions() *activeSessions {
+	return &activeSessions{
+		sessions: map[string]*types.Session{},
+	}
+}
+
+func (a *activeSessions) add(session *types.Session) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.sessions[session.ID]; !ok {
+		a.order = append(a.order, session.ID)
+	}
+	a.sessions[session.ID] = session
+}
+
+func (a *activeSessions) remove(sessionID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.sessions[sessionID]; !ok {
+		return
+	}
+	delete(a.sessions, sessionID)
+	for index, id := range a.order {
+		if id == sessionID {
+			a.order = append(a.order[:index], a.order[index+1:]...)
+			break
+		}
+	}
+}
+
+// returns nil if the session is not running on this instance
+func (a *activeSessions) get(sessionID string) *types.Session {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.sessions[sessionID]
+}
+
+func (a *activeSessions) count() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.sessions)
+}
+
+// the running sessions, oldest first
+func (a *activeSessions) list() []*types.Session {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	sessions := make([]*types.Session, 0, len(a.order))
+	for _, id := range a.order {
+		sessions = append(sessions, a.sessions[id])
+	}
+	return sessions
+}
@@ -2,12 +2,13 @@ package runner
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
-	"path"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -45,6 +46,10 @@ type AxolotlModelInstance struct {
 	// i.e. once the session has prepared - we can read the next session
 	// and know what the Lora file is
 	initialSessionURL string
+	// the python process asks this if it should stop generating
+	// for the current session
+	// e.g. http://localhost:8080/api/v1/worker/task/:instanceid/stop
+	stopTaskURL string
 
 	// we write responses to this function and they will be sent to the api
 	responseHandler func(res *types.RunnerTaskResponse) error
@@ -52,6 +57,9 @@ type AxolotlModelInstance struct {
 	// we create a cancel context for the running process
 	// which is derived from the main runner context
 	ctx context.Context
+	// cancels ctx which kills the process and any requests to the api
+	// (e.g. downloading a session's files) that are still going
+	cancel context.CancelFunc
 
 	// the command we are currently executing
 	currentCommand *exec.Cmd
@@ -61,6 +69,11 @@ type AxolotlModelInstance struct {
 	// trying to start this model's python process
 	initialSession *types.Session
 
+	// the runner's http handlers, the process output and the process exit
+	// all get at the sessions, activity, history and log path below from
+	// their own goroutines so they must hold mu to do so
+	mu sync.Mutex
+
 	// the session currently running on this model
 	currentSession *types.Session
 
@@ -82,8 +95,36 @@ type AxolotlModelInstance struct {
 	// the file handler we use to download and upload session files
 	fileHandler *FileHandler
 
-	// a history of the session IDs
-	jobHistory []*types.SessionSummary
+	// the last few jobs this instance has run and how they ended
+	jobHistory *jobHistory
+
+	// when the python process last wrote any output or sent a response
+	// used to spot a process that is stuck on a session
+	activity *activityWriter
+
+	// writes the process output to the current session's log file
+	// nil if we are not keeping session logs
+	sessionLog *sessionLogWriter
+	// the log file of the session we are running
+	sessionLogPath string
+
+	// responses wait here to be sent so a slow api doesn't hold up the
+	// process output - nil until the process is started
+	responses *responseQueue
+
+	// the session whose output hit a stop sequence - the python process
+	// polls for this so it can stop generating
+	stoppedSessionMu sync.Mutex
+	stoppedSessionID string
+
+	// when the model got to each stage of the current session
+	timings *sessionTimings
+	// the prompt of the current session if we're recording them
+	prompts *sessionPrompts
+
+	// shared with the runner's other instances, held while a session's
+	// files download
+	prepareLimiter *prepareLimiter
 }
 
 func (i *AxolotlModelInstance) ID() string {
@@ -95,6 +136,13 @@ func (i *AxolotlModelInstance) Filter() types.SessionFilter {
 }
 
 func (i *AxolotlModelInstance) Stale() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.isStale()
+}
+
+// i.mu must be held
+func (i *AxolotlModelInstance) isStale() bool {
 	return time.Since(i.lastActivity) > i.runnerOptions.Config.Runtimes.Axolotl.InstanceTTL
 }
 
@@ -103,17 +151,48 @@ func (i *AxolotlModelInstance) Model() model.Model {
 }
 
 func (i *AxolotlModelInstance) NextSession() *types.Session {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	return i.nextSession
 }
 
-func (i *AxolotlModelInstance) SetNextSession(session *types.Session) {
-	i.nextSession = session
+func (i *AxolotlModelInstance) TakeNextSession() *types.Session {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	session := i.nextSession
+	i.nextSession = nil
+	return session
 }
 
 func (i *AxolotlModelInstance) GetQueuedSession() *types.Session {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	return i.queuedSession
 }
 
+func (i *AxolotlModelInstance) hasCurrentSession() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.currentSession != nil
+}
+
+// clear the current session and return what it was so that only one of
+// the result, the process exiting and the liveness check gets to finish it
+func (i *AxolotlModelInstance) takeCurrentSession() *types.Session {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	session := i.currentSession
+	i.currentSession = nil
+	return session
+}
+
+func (i *AxolotlModelInstance) setQueuedSession(queuedSession *types.Session, nextSession *types.Session) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.queuedSession = queuedSession
+	i.nextSession = nextSession
+}
+
 func (i *AxolotlModelInstance) Done() <-chan bool {
 	return i.finishChan
 }
@@ -134,6 +213,13 @@ type ModelInstanceConfig struct {
 	GetNextSession func() (*types.Session, error)
 
 	RunnerOptions RunnerOptions
+
+	// where to write the output of the model process for each session
+	// (nil if we are not keeping session logs)
+	SessionLogs *SessionLogs
+
+	// taken while the session's files download (nil means no limit)
+	PrepareLimiter *prepareLimiter
 }
 
 func NewAxolotlModelInstance(ctx context.Context, cfg *ModelInstanceConfig) (*AxolotlModelInstance, error) {
@@ -152,32 +238,55 @@ func NewAxolotlModelInstance(ctx context.Context, cfg *ModelInstanceConfig) (*Ax
 		useLoraDir = types.LORA_DIR_NONE
 	}
 
+	// same for the env, the process only ever has the initial session's env
+	useEnv := cfg.InitialSession.Metadata.Env.Fingerprint()
+	if useEnv == "" {
+		useEnv = types.SESSION_ENV_NONE
+	}
+
 	httpClientOptions := system.ClientOptions{
 		Host:  cfg.RunnerOptions.ApiHost,
 		Token: cfg.RunnerOptions.ApiToken,
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+
 	modelInstance := &AxolotlModelInstance{
 		id:                id,
 		ctx:               ctx,
+		cancel:            cancel,
 		finishChan:        make(chan bool),
 		model:             aiModel,
 		responseHandler:   cfg.ResponseHandler,
 		nextTaskURL:       fmt.Sprintf("%s/%s", cfg.NextTaskURL, id),
 		initialSessionURL: fmt.Sprintf("%s/%s", cfg.InitialSessionURL, id),
+		stopTaskURL:       fmt.Sprintf("%s/%s/stop", cfg.NextTaskURL, id),
 		initialSession:    cfg.InitialSession,
 		filter: types.SessionFilter{
 			ModelName: cfg.InitialSession.ModelName,
 			Mode:      cfg.InitialSession.Mode,
 			LoraDir:   useLoraDir,
+			Env:       useEnv,
 			Type:      cfg.InitialSession.Type,
 		},
 		runnerOptions:     cfg.RunnerOptions,
 		httpClientOptions: httpClientOptions,
-		jobHistory:        []*types.SessionSummary{},
+		jobHistory:        newJobHistory(cfg.RunnerOptions.JobHistoryBufferSize),
+		activity:          newActivityWriter(),
+		timings:           newSessionTimings(),
+		prompts:           newSessionPrompts(cfg.RunnerOptions),
 	}
 
-	fileHandler := NewFileHandler(cfg.RunnerOptions.ID, httpClientOptions, modelInstance.taskResponseHandler)
+	if cfg.SessionLogs != nil {
+		modelInstance.sessionLog = cfg.SessionLogs.NewWriter()
+	}
+
+	modelInstance.prepareLimiter = cfg.PrepareLimiter
+	if modelInstance.prepareLimiter == nil {
+		modelInstance.prepareLimiter = newPrepareLimiter(0)
+	}
+
+	fileHandler := NewFileHandler(cfg.RunnerOptions.ID, httpClientOptions, modelInstance.taskResponseHandler, cfg.RunnerOptions.ResultsFolders, requestTimeout(cfg.RunnerOptions))
 	modelInstance.fileHandler = fileHandler
 
 	return modelInstance, nil
@@ -195,13 +304,14 @@ func NewAxolotlModelInstance(ctx context.Context, cfg *ModelInstanceConfig) (*Ax
 
 func (i *AxolotlModelInstance) getSessionFileHander(session *types.Session) *SessionFileHandler {
 	return &SessionFileHandler{
-		folder:    path.Join(os.TempDir(), "helix", "downloads", session.ID),
+		folder:    sessionDownloadFolder(session.ID),
 		sessionID: session.ID,
-		downloadFile: func(sessionID string, remotePath string, localPath string) error {
-			return i.fileHandler.downloadFile(sessionID, remotePath, localPath)
+		ctx:       i.ctx,
+		downloadFile: func(ctx context.Context, sessionID string, remotePath string, localPath string) error {
+			return i.fileHandler.downloadFile(ctx, sessionID, remotePath, localPath)
 		},
-		downloadFolder: func(sessionID string, remotePath string, localPath string) error {
-			return i.fileHandler.downloadFolder(sessionID, remotePath, localPath)
+		downloadFolder: func(ctx context.Context, sessionID string, remotePath string, localPath string) error {
+			return i.fileHandler.downloadFolder(ctx, sessionID, remotePath, localPath)
 		},
 	}
 }
@@ -209,32 +319,60 @@ func (i *AxolotlModelInstance) getSessionFileHander(session *types.Session) *Ses
 // this is the loading of a session onto a running model instance
 // it also returns the task that will be fed down into the python code to execute
 func (i *AxolotlModelInstance) AssignSessionTask(ctx context.Context, session *types.Session) (*types.RunnerTask, error) {
+	i.mu.Lock()
 	// mark the instance as active so it doesn't get cleaned up
 	i.lastActivity = time.Now()
 	i.currentSession = session
+	i.startSessionLog(session)
+	i.mu.Unlock()
+
+	i.activity.touch()
+	i.setStoppedSession("")
+	i.timings.start(session.ID)
 
 	task, err := i.model.GetTask(session, i.getSessionFileHander(session))
 	if err != nil {
-		log.Error().Msgf("error getting task: %s", err.Error())
+		log.Error().Str("request_id", getSessionRequestID(session)).Msgf("error getting task: %s", err.Error())
 		return nil, err
 	}
 	task.SessionID = session.ID
+	i.prompts.record(session.ID, task.Prompt)
+	log.Info().
+		Str("session_id", session.ID).
+		Str("request_id", task.RequestID).
+		Msg("🟢 assigned session task")
 	return task, nil
 }
 
 // to queue a session means to put it into a buffer and wait for the Python process to boot up and then "pull" it
 func (i *AxolotlModelInstance) QueueSession(session *types.Session, isInitialSession bool) {
-	i.queuedSession = session
-	i.nextSession = nil
+	i.setQueuedSession(session, nil)
 
 	log.Debug().
 		Msgf("🔵 runner prepare session: %s", session.ID)
 
+	// fail before we download anything rather than halfway through
+	// when the disk fills up
+	err := checkSessionFileSize(session, i.runnerOptions.MaxSessionFileSize)
+	if err != nil {
+		log.Error().Msgf("error preparing session: %s", err.Error())
+		i.setQueuedSession(nil, nil)
+		i.errorSession(session, err)
+		return
+	}
+
+	release, err := i.prepareLimiter.acquire(i.ctx)
+	if err != nil {
+		log.Error().Msgf("error waiting to prepare session: %s", err.Error())
+		i.setQueuedSession(nil, nil)
+		i.errorSession(session, err)
+		return
+	}
 	preparedSession, err := i.model.PrepareFiles(session, isInitialSession, i.getSessionFileHander(session))
+	release()
 	if err != nil {
 		log.Error().Msgf("error preparing session: %s", err.Error())
-		i.queuedSession = nil
-		i.nextSession = nil
+		i.setQueuedSession(nil, nil)
 		i.errorSession(session, err)
 		return
 	}
@@ -243,8 +381,7 @@ func (i *AxolotlModelInstance) QueueSession(session *types.Session, isInitialSes
 
 	if err != nil {
 		log.Error().Msgf("error preparing session: %s", err.Error())
-		i.queuedSession = nil
-		i.nextSession = nil
+		i.setQueuedSession(nil, nil)
 		i.errorSession(session, err)
 		return
 	}
@@ -252,8 +389,7 @@ func (i *AxolotlModelInstance) QueueSession(session *types.Session, isInitialSes
 	log.Debug().
 		Msgf("🔵 runner assign next session: %s", preparedSession.ID)
 
-	i.queuedSession = nil
-	i.nextSession = preparedSession
+	i.setQueuedSession(nil, preparedSession)
 }
 
 /*
@@ -267,15 +403,21 @@ func (i *AxolotlModelInstance) QueueSession(session *types.Session, isInitialSes
 */
 
 func (i *AxolotlModelInstance) errorSession(session *types.Session, err error) {
-	apiUpdateErr := i.responseHandler(&types.RunnerTaskResponse{
-		Type:      types.WorkerTaskResponseTypeResult,
-		SessionID: session.ID,
-		Error:     err.Error(),
-	})
-
-	if apiUpdateErr != nil {
-		log.Error().Msgf("Error reporting error to api: %v\n", apiUpdateErr.Error())
+	i.mu.Lock()
+	logFile := i.sessionLogPath
+	i.mu.Unlock()
+
+	res := &types.RunnerTaskResponse{
+		Type:        types.WorkerTaskResponseTypeResult,
+		SessionID:   session.ID,
+		Error:       err.Error(),
+		ErrorDetail: getErrorDetail(err),
+		ErrorCode:   getErrorCode(err),
+		LogFile:     logFile,
 	}
+	i.timings.stamp(res)
+	i.prompts.attach(res)
+	i.sendResponse(res)
 }
 
 /*
@@ -290,45 +432,138 @@ func (i *AxolotlModelInstance) errorSession(session *types.Session, err error) {
 
 // we call this function from the text processors
 func (i *AxolotlModelInstance) taskResponseHandler(taskResponse *types.RunnerTaskResponse) {
+	if !i.claimTaskResponse(taskResponse) {
+		return
+	}
+	i.activity.touch()
+	// before any upload so that isn't counted as generating
+	i.timings.stamp(taskResponse)
+	i.prompts.attach(taskResponse)
+
+	// the python process tells us what went wrong but not what sort of
+	// failure it was
+	if taskResponse.Error != "" && taskResponse.ErrorCode == types.RunnerErrorCodeNone {
+		taskResponse.ErrorCode = getErrorCode(errors.New(taskResponse.Error))
+	}
+
+	// previews of the image being generated come with the progress, the
+	// browser can only show them once they are in the filestore
+	if taskResponse.Type == types.WorkerTaskResponseTypeProgress && len(taskResponse.Files) > 0 {
+		uploadedResponse, err := i.fileHandler.uploadPreviewResponse(i.ctx, taskResponse)
+		if err != nil {
+			// the progress is still worth sending without it
+			log.Warn().Err(err).Str("session_id", taskResponse.SessionID).Msg("error uploading preview")
+			taskResponse.Files = nil
+		} else {
+			taskResponse = uploadedResponse
+		}
+	}
+
+	// if it's the final result then we need to upload the files first
+	if taskResponse.Type == types.WorkerTaskResponseTypeResult {
+		uploadedResponse, err := i.fileHandler.uploadWorkerResponse(i.ctx, taskResponse)
+		if err != nil {
+			log.Error().Msgf("error uploading task result files: %s", err.Error())
+			// error the interaction rather than leave it waiting forever
+			// or hand back files that didn't make it to the api intact
+			uploadedResponse = &types.RunnerTaskResponse{
+				Type:          types.WorkerTaskResponseTypeResult,
+				SessionID:     taskResponse.SessionID,
+				InteractionID: taskResponse.InteractionID,
+				Owner:         taskResponse.Owner,
+				Error:         fmt.Sprintf("error uploading result files: %s", err.Error()),
+				ErrorCode:     types.RunnerErrorCodeModelError,
+			}
+		}
+		taskResponse = uploadedResponse
+	}
+
+	i.sendResponse(taskResponse)
+}
+
+// fill in the response from the current session - a result is the end of
+// the session so we let go of it here rather than after the upload, that
+// way the process exiting or being killed during the upload can't error it
+// as well and we can't clear a session the process has moved on to
+func (i *AxolotlModelInstance) claimTaskResponse(taskResponse *types.RunnerTaskResponse) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
 	if i.currentSession == nil {
 		log.Error().Msgf("no current session")
-		return
+		return false
 	}
 	if i.currentSession.ID != taskResponse.SessionID {
 		log.Error().Msgf("current session ID mis-match: current=%s vs event=%s", i.currentSession.ID, taskResponse.SessionID)
-		return
+		return false
 	}
 
-	var err error
-
 	systemInteraction, err := data.GetSystemInteraction(i.currentSession)
 	if err != nil {
 		log.Error().Msgf("error getting system interaction: %s", err.Error())
-		return
+		return false
 	}
 
 	taskResponse.InteractionID = systemInteraction.ID
 	taskResponse.Owner = i.currentSession.Owner
+	taskResponse.LogFile = i.sessionLogPath
 	i.lastActivity = time.Now()
 
-	// if it's the final result then we need to upload the files first
 	if taskResponse.Type == types.WorkerTaskResponseTypeResult {
-		taskResponse, err = i.fileHandler.uploadWorkerResponse(taskResponse)
-		if err != nil {
-			log.Error().Msgf("error uploading task result files: %s", err.Error())
-			i.currentSession = nil
-			return
-		}
-
 		i.currentSession = nil
+		i.startSessionLog(nil)
 	}
+	return true
+}
+
+// the stop sequences of the session the text stream is parsing
+func (i *AxolotlModelInstance) stopSequences(sessionID string) []string {
+	i.mu.Lock()
+	session := i.currentSession
+	i.mu.Unlock()
+	if session == nil || session.ID != sessionID {
+		return nil
+	}
+	return session.Metadata.Sampling.Stop
+}
+
+func (i *AxolotlModelInstance) setStoppedSession(sessionID string) {
+	i.stoppedSessionMu.Lock()
+	defer i.stoppedSessionMu.Unlock()
+	i.stoppedSessionID = sessionID
+}
+
+// have the python process stop generating for the session if it's the one
+// it's working on
+func (i *AxolotlModelInstance) StopSession(sessionID string) bool {
+	i.mu.Lock()
+	current := i.currentSession != nil && i.currentSession.ID == sessionID
+	i.mu.Unlock()
+	if !current {
+		return false
+	}
+	i.setStoppedSession(sessionID)
+	return true
+}
+
+// the session the python process should stop generating for (if any)
+func (i *AxolotlModelInstance) StoppedSession() *types.RunnerTaskStop {
+	i.stoppedSessionMu.Lock()
+	defer i.stoppedSessionMu.Unlock()
+	return &types.RunnerTaskStop{SessionID: i.stoppedSessionID}
+}
 
-	// this will emit to the controller handler
-	// i.e. the function defined in createModelInstance
-	err = i.responseHandler(taskResponse)
+// this will emit to the controller handler
+// i.e. the function defined in createModelInstance
+func (i *AxolotlModelInstance) sendResponse(taskResponse *types.RunnerTaskResponse) {
+	i.jobHistory.finish(taskResponse)
+	if i.responses != nil {
+		i.responses.push(taskResponse)
+		return
+	}
+	err := i.responseHandler(taskResponse)
 	if err != nil {
 		log.Error().Msgf("error writing event: %s", err.Error())
-		return
 	}
 }
 
@@ -339,6 +574,7 @@ func (i *AxolotlModelInstance) Start(session *types.Session) error {
 		InstanceID:        i.id,
 		NextTaskURL:       i.nextTaskURL,
 		InitialSessionURL: i.initialSessionURL,
+		StopTaskURL:       i.stopTaskURL,
 		MockRunner:        i.runnerOptions.MockRunner,
 		MockRunnerError:   i.runnerOptions.MockRunnerError,
 		MockRunnerDelay:   i.runnerOptions.MockRunnerDelay,
@@ -349,10 +585,8 @@ func (i *AxolotlModelInstance) Start(session *types.Session) error {
 	if cmd == nil {
 		return fmt.Errorf("no command to run")
 	}
-	log.Debug().Msgf("🔵 runner start process: %s %+v %+v", session.ID, cmd.Args, cmd.Env)
-
-	log.Info().
-		Msgf("🟢 run model instance: %s, %+v, %s", cmd.Dir, cmd.Args, cmd.Env)
+	cmd.Env = addSessionEnv(cmd.Env, session.Metadata.Env)
+	log.Debug().Msgf("🔵 runner start process: %s %+v %+v", session.ID, cmd.Args, redactSessionEnv(cmd.Env))
 
 	sessionCopy := *session
 	for i, itx := range sessionCopy.Interactions {
@@ -361,11 +595,17 @@ func (i *AxolotlModelInstance) Start(session *types.Session) error {
 		}
 	}
 
-	log.Info().
+	log.Debug().
 		Msgf("🟢 initial session: %s, %+v", session.ID, sessionCopy)
 
 	i.currentCommand = cmd
 
+	dropPolicy, err := ValidateResponseDropPolicy(i.runnerOptions.ResponseDropPolicy)
+	if err != nil {
+		return err
+	}
+	i.responses = newResponseQueue(i.runnerOptions.ResponseBufferSize, dropPolicy, i.responseHandler)
+
 	// Create pipes for stdout and stderr
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
@@ -376,12 +616,17 @@ func (i *AxolotlModelInstance) Start(session *types.Session) error {
 		return err
 	}
 
-	// this buffer is so we can keep the last 10kb of stderr so if
+	// this buffer is so we can keep the end of stderr so if
 	// there is an error we can send it to the api
-	stderrBuf := system.NewLimitedBuffer(1024 * 10)
+	stderrBuf := newStderrBuffer(i.runnerOptions)
+
+	stdoutWriters := []io.Writer{os.Stdout, i.activity}
+	stderrWriters := []io.Writer{os.Stderr, stderrBuf, i.activity}
 
-	stdoutWriters := []io.Writer{os.Stdout}
-	stderrWriters := []io.Writer{os.Stderr, stderrBuf}
+	if i.sessionLog != nil {
+		stdoutWriters = append(stdoutWriters, i.sessionLog)
+		stderrWriters = append(stderrWriters, i.sessionLog)
+	}
 
 	// create the model textsream
 	// this is responsible for chunking stdout into session outputs
@@ -394,7 +639,10 @@ func (i *AxolotlModelInstance) Start(session *types.Session) error {
 	// in all cases - each model get's to decide what formatting
 	// it's Python needs to use so that these text streams will
 	// parse correctly
-	stdout, stderr, err := i.model.GetTextStreams(session.Mode, i.taskResponseHandler)
+	stdout, stderr, err := i.model.GetTextStreams(session.Mode, i.taskResponseHandler, model.TextStreamOptions{
+		StopSequences: i.stopSequences,
+		OnStop:        i.setStoppedSession,
+	})
 	if err != nil {
 		return err
 	}
@@ -432,9 +680,23 @@ func (i *AxolotlModelInstance) Start(session *types.Session) error {
 		return err
 	}
 
+	livenessCtx, stopLiveness := context.WithCancel(i.ctx)
+	go watchLiveness(
+		livenessCtx,
+		i.activity,
+		i.runnerOptions.Config.Runtimes.Axolotl.LivenessTimeout,
+		i.hasCurrentSession,
+		i.killHungProcess,
+	)
+
 	go func(cmd *exec.Cmd) {
 		// Signal the runner to drop the model instance
 		defer close(i.finishChan)
+		// and stop anything it was still doing
+		defer i.cancel()
+		defer stopLiveness()
+		// send whatever is left (including any error below) and stop
+		defer i.responses.close()
 
 		if err = cmd.Wait(); err != nil {
 			log.Error().Msgf("Command ended with an error: %v\n", err.Error())
@@ -444,9 +706,11 @@ func (i *AxolotlModelInstance) Start(session *types.Session) error {
 			// that this interaction has it's Error field set
 
 			errstr := string(stderrBuf.Bytes())
-			if i.currentSession != nil {
-				i.errorSession(i.currentSession, fmt.Errorf("%s from cmd - %s", err.Error(), errstr))
+			if session := i.takeCurrentSession(); session != nil {
+				i.errorSession(session, &processExitError{Err: err, Stderr: errstr})
 			}
+			// make sure the error has reached the api before we might exit below
+			i.responses.close()
 
 			if strings.Contains(errstr, "(core dumped)") {
 				log.Error().Msg("detected coredump, exiting and hoping we get restarted - see https://github.com/helixml/helix/issues/123")
@@ -470,7 +734,46 @@ func (i *AxolotlModelInstance) Start(session *types.Session) error {
 	return nil
 }
 
+// point the process output at the log file for this session
+// (or stop logging if session is nil) - i.mu must be held
+func (i *AxolotlModelInstance) startSessionLog(session *types.Session) {
+	if i.sessionLog == nil {
+		return
+	}
+	sessionID := ""
+	if session != nil {
+		sessionID = session.ID
+	}
+	path, err := i.sessionLog.SetSession(sessionID)
+	if err != nil {
+		log.Error().Msgf("error opening session log: %s", err.Error())
+	}
+	i.sessionLogPath = path
+}
+
+// the python process has gone quiet in the middle of a session (e.g. a
+// deadlock or a stuck CUDA call) so error the session and kill the process
+// which closes finishChan and lets the runner drop this instance
+func (i *AxolotlModelInstance) killHungProcess(silence time.Duration) {
+	// clear it first so the process exiting doesn't error it again
+	session := i.takeCurrentSession()
+	log.Error().
+		Str("instance_id", i.id).
+		Dur("silence", silence).
+		Msg("model process is not responding, killing it")
+
+	if session != nil {
+		i.errorSession(session, fmt.Errorf("model process produced no output for %s and was killed", silence.Round(time.Second)))
+	}
+
+	if err := i.Stop(); err != nil {
+		log.Error().Msgf("error killing hung model process: %s", err.Error())
+	}
+}
+
 func (i *AxolotlModelInstance) Stop() error {
+	// whether or not the process has started, stop any downloads for it
+	i.cancel()
 	if i.currentCommand == nil {
 		return fmt.Errorf("no process to stop")
 	}
@@ -489,12 +792,7 @@ func (i *AxolotlModelInstance) addJobToHistory(session *types.Session) error {
 		return err
 	}
 
-	// put the job at the start of the array
-	i.jobHistory = append([]*types.SessionSummary{summary}, i.jobHistory...)
-	if len(i.jobHistory) > i.runnerOptions.JobHistoryBufferSize {
-		i.jobHistory = i.jobHistory[:len(i.jobHistory)-1]
-	}
-
+	i.jobHistory.add(summary)
 	return nil
 }
 
@@ -502,6 +800,8 @@ func (i *AxolotlModelInstance) GetState() (*types.ModelInstanceState, error) {
 	if i.initialSession == nil {
 		return nil, fmt.Errorf("no initial session")
 	}
+
+	i.mu.Lock()
 	currentSession := i.currentSession
 	if currentSession == nil {
 		currentSession = i.queuedSession
@@ -511,6 +811,9 @@ func (i *AxolotlModelInstance) GetState() (*types.ModelInstanceState, error) {
 	if currentSession == nil {
 		currentSession = i.nextSession
 	}
+	lastActivity := i.lastActivity
+	stale := i.isStale()
+	i.mu.Unlock()
 
 	var sessionSummary *types.SessionSummary
 	var err error
@@ -529,10 +832,10 @@ func (i *AxolotlModelInstance) GetState() (*types.ModelInstanceState, error) {
 		LoraDir:          i.initialSession.LoraDir,
 		InitialSessionID: i.initialSession.ID,
 		CurrentSession:   sessionSummary,
-		JobHistory:       i.jobHistory,
+		JobHistory:       i.jobHistory.list(),
 		Timeout:          int(i.runnerOptions.Config.Runtimes.Axolotl.InstanceTTL.Seconds()),
-		LastActivity:     int(i.lastActivity.Unix()),
-		Stale:            i.Stale(),
+		LastActivity:     int(lastActivity.Unix()),
+		Stale:            stale,
 		MemoryUsage:      i.model.GetMemoryRequirements(i.initialSession.Mode),
 	}, nil
 }
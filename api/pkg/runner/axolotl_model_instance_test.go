@@ -0,0 +1,229 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/config"
+	"github.com/helixml/helix/api/pkg/model"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// a model that doesn't need files or a process so we can drive the
+// instance through the session lifecycle by hand
+type fakeAxolotlModel struct{}
+
+func (m *fakeAxolotlModel) GetMemoryRequirements(mode types.SessionMode) uint64 {
+	return 0
+}
+
+func (m *fakeAxolotlModel) GetType() types.SessionType {
+	return types.SessionTypeText
+}
+
+func (m *fakeAxolotlModel) GetCommand(ctx context.Context, sessionFilter types.SessionFilter, config types.RunnerProcessConfig) (*exec.Cmd, error) {
+	return nil, errors.New("no process")
+}
+
+func (m *fakeAxolotlModel) GetTextStreams(mode types.SessionMode, eventHandler model.WorkerEventHandler, options model.TextStreamOptions) (*model.TextStream, *model.TextStream, error) {
+	return nil, nil, nil
+}
+
+func (m *fakeAxolotlModel) PrepareFiles(session *types.Session, isInitialSession bool, fileManager model.ModelSessionFileManager) (*types.Session, error) {
+	return session, nil
+}
+
+func (m *fakeAxolotlModel) GetTask(session *types.Session, fileManager model.ModelSessionFileManager) (*types.RunnerTask, error) {
+	return &types.RunnerTask{Prompt: "hello"}, nil
+}
+
+func newTestAxolotlSession(id string) *types.Session {
+	session := newTestOllamaSession(id)
+	session.Mode = types.SessionModeInference
+	session.Type = types.SessionTypeText
+	return session
+}
+
+type recordedResponses struct {
+	mu        sync.Mutex
+	responses []*types.RunnerTaskResponse
+}
+
+func (r *recordedResponses) handle(res *types.RunnerTaskResponse) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, res)
+	return nil
+}
+
+func (r *recordedResponses) results(sessionID string) []*types.RunnerTaskResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	results := []*types.RunnerTaskResponse{}
+	for _, res := range r.responses {
+		if res.SessionID == sessionID && res.Type == types.WorkerTaskResponseTypeResult {
+			results = append(results, res)
+		}
+	}
+	return results
+}
+
+func newTestAxolotlModelInstance(responses *recordedResponses) *AxolotlModelInstance {
+	runnerConfig := &config.RunnerConfig{}
+	runnerConfig.Runtimes.Axolotl.InstanceTTL = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &AxolotlModelInstance{
+		id:              "instance",
+		ctx:             ctx,
+		cancel:          cancel,
+		model:           &fakeAxolotlModel{},
+		initialSession:  newTestAxolotlSession("initial"),
+		runnerOptions:   RunnerOptions{Config: runnerConfig, JobHistoryBufferSize: 5},
+		responseHandler: responses.handle,
+		fileHandler:     &FileHandler{},
+		activity:        newActivityWriter(),
+		timings:         newSessionTimings(),
+		jobHistory:      newJobHistory(5),
+		prepareLimiter:  newPrepareLimiter(0),
+	}
+}
+
+// meant to be run with -race - the runner's http handlers read the state
+// while sessions are queued, handed to the process and answered
+func TestAxolotlModelInstance_ConcurrentLifecycle(t *testing.T) {
+	responses := &recordedResponses{}
+	instance := newTestAxolotlModelInstance(responses)
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				_, err := instance.GetState()
+				assert.NoError(t, err)
+				instance.Stale()
+				instance.NextSession()
+				instance.GetQueuedSession()
+				instance.hasCurrentSession()
+				instance.stopSequences("session-0")
+			}
+		}()
+	}
+
+	const sessions = 20
+	for n := 0; n < sessions; n++ {
+		session := newTestAxolotlSession(fmt.Sprintf("session-%d", n))
+		go instance.QueueSession(session, false)
+
+		// what popNextTask does when the process asks for work
+		var next *types.Session
+		require.Eventually(t, func() bool {
+			next = instance.TakeNextSession()
+			return next != nil
+		}, time.Second, time.Millisecond)
+		require.Equal(t, session.ID, next.ID)
+
+		_, err := instance.AssignSessionTask(context.Background(), next)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			instance.taskResponseHandler(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeStream, SessionID: session.ID, Message: "hel"})
+		}()
+		go func() {
+			defer wg.Done()
+			instance.taskResponseHandler(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeStream, SessionID: session.ID, Message: "lo"})
+		}()
+		wg.Wait()
+
+		instance.taskResponseHandler(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: session.ID, Message: "hello"})
+		require.Len(t, responses.results(session.ID), 1)
+	}
+
+	close(done)
+	readers.Wait()
+
+	assert.False(t, instance.hasCurrentSession())
+	state, err := instance.GetState()
+	require.NoError(t, err)
+	assert.Len(t, state.JobHistory, 5)
+	assert.Equal(t, fmt.Sprintf("session-%d", sessions-1), state.JobHistory[0].SessionID)
+}
+
+// the process exiting (or being killed) at the same time as it sends the
+// result must only finish the session once
+func TestAxolotlModelInstance_ResultRacesProcessExit(t *testing.T) {
+	for n := 0; n < 50; n++ {
+		responses := &recordedResponses{}
+		instance := newTestAxolotlModelInstance(responses)
+		session := newTestAxolotlSession("session")
+
+		_, err := instance.AssignSessionTask(context.Background(), session)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			instance.taskResponseHandler(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: session.ID, Message: "hello"})
+		}()
+		go func() {
+			defer wg.Done()
+			if current := instance.takeCurrentSession(); current != nil {
+				instance.errorSession(current, errors.New("process exited"))
+			}
+		}()
+		wg.Wait()
+
+		assert.Len(t, responses.results(session.ID), 1)
+		assert.False(t, instance.hasCurrentSession())
+	}
+}
+
+func TestAxolotlModelInstance_TakeNextSession(t *testing.T) {
+	instance := newTestAxolotlModelInstance(&recordedResponses{})
+	session := newTestAxolotlSession("session")
+
+	instance.QueueSession(session, false)
+	assert.Equal(t, session, instance.NextSession())
+	assert.Nil(t, instance.GetQueuedSession())
+
+	assert.Equal(t, session, instance.TakeNextSession())
+	assert.Nil(t, instance.TakeNextSession())
+}
+
+func TestAxolotlModelInstance_StopSession(t *testing.T) {
+	instance := newTestAxolotlModelInstance(&recordedResponses{})
+	session := newTestAxolotlSession("session")
+
+	// nothing to stop until the process has the session
+	assert.False(t, instance.StopSession(session.ID))
+
+	_, err := instance.AssignSessionTask(context.Background(), session)
+	require.NoError(t, err)
+
+	assert.False(t, instance.StopSession("another-session"))
+	assert.Empty(t, instance.StoppedSession().SessionID)
+
+	assert.True(t, instance.StopSession(session.ID))
+	assert.Equal(t, session.ID, instance.StoppedSession().SessionID)
+}
@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// how long the task and report state loops wait after failing to reach the
+// api - the wait doubles with every failure up to max, and half of it is
+// random so a fleet of runners doesn't come back at the api all at once
+type backoff struct {
+	initial  time.Duration
+	max      time.Duration
+	failures int
+}
+
+func newBackoff(options RunnerOptions) *backoff {
+	return &backoff{
+		initial: time.Duration(options.BackoffInitialMilliseconds) * time.Millisecond,
+		max:     time.Duration(options.BackoffMaxSeconds) * time.Second,
+	}
+}
+
+// records a failure and returns how long to wait before the next attempt,
+// never less than delay (the loop's usual wait)
+func (b *backoff) next(delay time.Duration) time.Duration {
+	b.failures++
+	if b.initial <= 0 {
+		return delay
+	}
+	wait := b.initial
+	for i := 1; i < b.failures && (b.max <= 0 || wait < b.max); i++ {
+		wait *= 2
+	}
+	if b.max > 0 && wait > b.max {
+		wait = b.max
+	}
+	wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	if wait < delay {
+		return delay
+	}
+	return wait
+}
+
+// returns how many attempts failed before this one worked
+func (b *backoff) reset() int {
+	failures := b.failures
+	b.failures = 0
+	return failures
+}
+
+// the api is down or restarting rather than it not liking what we asked -
+// the connection failed, timed out or kept getting 5xx until the retrying
+// http client gave up
+func apiUnavailable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	return strings.Contains(err.Error(), "giving up after")
+}
@@ -0,0 +1,65 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(RunnerOptions{BackoffInitialMilliseconds: 100, BackoffMaxSeconds: 1})
+
+	// each wait is between half and all of 100ms, 200ms, 400ms, 800ms, 1s, 1s
+	for _, wait := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		wait *= time.Millisecond
+		next := b.next(0)
+		assert.GreaterOrEqual(t, next, wait/2)
+		assert.LessOrEqual(t, next, wait)
+	}
+
+	assert.Equal(t, 6, b.reset())
+	assert.Equal(t, 0, b.reset())
+	assert.LessOrEqual(t, b.next(0), 100*time.Millisecond)
+}
+
+func TestBackoff_NeverShorterThanTheLoopDelay(t *testing.T) {
+	b := newBackoff(RunnerOptions{BackoffInitialMilliseconds: 10, BackoffMaxSeconds: 1})
+	assert.Equal(t, time.Second, b.next(time.Second))
+
+	off := newBackoff(RunnerOptions{})
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 100*time.Millisecond, off.next(100*time.Millisecond))
+	}
+	assert.Equal(t, 3, off.reset())
+}
+
+func TestApiUnavailable(t *testing.T) {
+	// nothing listening
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	_, err := http.Get(server.URL)
+	require.Error(t, err)
+	assert.True(t, apiUnavailable(err))
+
+	assert.True(t, apiUnavailable(fmt.Errorf("asking for a session: %w", context.DeadlineExceeded)))
+	assert.True(t, apiUnavailable(errors.New("GET http://api/api/v1/runner/runner-1/nextsession giving up after 6 attempt(s)")))
+
+	assert.False(t, apiUnavailable(errors.New("error response from server: 400 Bad Request")))
+	assert.False(t, apiUnavailable(errors.New("not enough memory to run the model")))
+}
+
+func TestReportStateNow(t *testing.T) {
+	r := &Runner{reportStateSignal: make(chan struct{}, 1)}
+
+	// doesn't block when the loop hasn't picked up the last one yet
+	r.reportStateNow()
+	r.reportStateNow()
+	assert.Len(t, r.reportStateSignal, 1)
+}
@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"runtime/debug"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
@@ -30,6 +32,10 @@ type RunnerOptions struct {
 
 	CacheDir string
 
+	// the model processes write the files and lora dirs they hand back
+	// somewhere in these folders, we won't upload anything from elsewhere
+	ResultsFolders []string
+
 	Config *config.RunnerConfig
 
 	// these URLs will have the instance ID appended by the model instance
@@ -83,9 +89,74 @@ type RunnerOptions struct {
 	// how many seconds to delay the mock runner
 	MockRunnerDelay int
 
-	// development settings
-	// never run more than this number of model instances
-	MaxModelInstances int
+	// never run more than this many model instances at once, however much
+	// memory is free, to spare the disk and PCIe bandwidth that loading
+	// models uses (zero means no limit)
+	MaxInstances int
+
+	// how many bytes from the end of a model process's stderr we keep so
+	// we can send it to the api if the process dies - bump this for models
+	// that are noisy enough to push the real error out of the buffer
+	StderrBufferSize int
+
+	// the output of each session's model process is written to a log file
+	// in this directory (leave empty to turn it off)
+	SessionLogDir string
+	// session logs bigger than this many bytes are rotated
+	SessionLogMaxSize int
+	// how many rotated logs to keep for each session
+	SessionLogMaxBackups int
+	// delete session logs that haven't been written to for this many hours
+	SessionLogRetentionHours int
+
+	// keep this many idle instances of these models loaded (model name => count)
+	// so sessions for them don't have to wait for the model to boot
+	WarmPool map[string]string
+
+	// how many responses from a model process can be waiting to be sent
+	// to the api before ResponseDropPolicy kicks in
+	ResponseBufferSize int
+	// "drop-oldest" throws away streaming updates when the buffer is full
+	// and "block" waits (which stalls the model process output)
+	ResponseDropPolicy string
+
+	// the sessions we are preparing or running are written to this file so
+	// we can give them back to the api if we restart (leave empty to turn it off)
+	SessionAssignmentsFile string
+
+	// refuse sessions whose files add up to more than this many bytes
+	// before we start downloading them so one huge dataset can't fill the
+	// disk, zero means only the session's own limit applies
+	MaxSessionFileSize int64
+
+	// start without asking the api for new sessions - used to keep a runner
+	// drained across a restart until it is uncordoned
+	Cordoned bool
+
+	// how many sessions can download their files at once across all model
+	// instances, the rest queue up (zero means no limit) - this only holds
+	// back preparing sessions, not how many instances run
+	MaxConcurrentPrepares int
+
+	// give up on a request to the api (asking for the next session, getting
+	// upload and download urls) if it takes longer than this many seconds -
+	// file transfers can take as long as they need but are given up on if
+	// they go this long without moving any bytes (zero for no limit)
+	RequestTimeoutSeconds int
+
+	// when the task or report state loop can't reach the api it waits this
+	// many milliseconds before trying again, doubling each time it fails
+	// again up to BackoffMaxSeconds (zero initial turns the backoff off)
+	BackoffInitialMilliseconds int
+	BackoffMaxSeconds          int
+
+	// send the prompt each model was given back with the result so the api
+	// keeps it on the interaction - they can be big and hold whatever the
+	// users put in so this is off unless we're debugging outputs
+	RecordPrompts bool
+
+	// the level and format of the runner's logs
+	Logging system.LoggingOptions
 }
 
 type Runner struct {
@@ -120,6 +191,27 @@ type Runner struct {
 
 	warmupSessions     []types.Session
 	warmupSessionMutex sync.Mutex
+
+	// nil if we are not keeping per session logs
+	sessionLogs *SessionLogs
+
+	warmPool warmPool
+
+	// the sessions we have been handed but not sent a result for yet
+	sessionAssignments *sessionAssignments
+
+	// when cordoned we finish what we have but don't take any new sessions
+	cordoned atomic.Bool
+
+	// numbers the responses we send to the api
+	responseSequences *responseSequences
+
+	// shared by the model instances so only so many sessions download
+	// their files at once
+	prepareLimiter *prepareLimiter
+
+	// wakes the report state loop up early, see reportStateNow
+	reportStateSignal chan struct{}
 }
 
 func NewRunner(
@@ -153,6 +245,16 @@ func NewRunner(
 	if err != nil {
 		return nil, err
 	}
+	pool, err := parseWarmPool(options)
+	if err != nil {
+		return nil, err
+	}
+	if options.ResponseDropPolicy == "" {
+		options.ResponseDropPolicy = string(ResponseDropPolicyDropOldest)
+	}
+	if _, err := ValidateResponseDropPolicy(options.ResponseDropPolicy); err != nil {
+		return nil, err
+	}
 	runner := &Runner{
 		Ctx:                     ctx,
 		Options:                 options,
@@ -166,7 +268,31 @@ func NewRunner(
 		websocketEventChannel: make(chan *types.WebsocketEvent),
 		schedulingDecisions:   []string{},
 		warmupSessions:        warmupSessions,
+		warmPool:              pool,
+		responseSequences:     newResponseSequences(),
+		prepareLimiter:        newPrepareLimiter(options.MaxConcurrentPrepares),
+		reportStateSignal:     make(chan struct{}, 1),
+	}
+
+	runner.cordoned.Store(options.Cordoned)
+
+	runner.sessionAssignments, err = newSessionAssignments(options.SessionAssignmentsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.SessionLogDir != "" {
+		runner.sessionLogs, err = NewSessionLogs(
+			options.SessionLogDir,
+			int64(options.SessionLogMaxSize),
+			options.SessionLogMaxBackups,
+			time.Hour*time.Duration(options.SessionLogRetentionHours),
+		)
+		if err != nil {
+			return nil, err
+		}
 	}
+
 	return runner, nil
 }
 
@@ -178,7 +304,7 @@ func (r *Runner) Initialize(ctx context.Context) error {
 		return err
 	}
 
-	fmt.Println("Connecting to controlplane", system.WSURL(r.httpClientOptions, system.GetApiPath("/ws/runner")))
+	log.Info().Str("url", parsedURL.String()).Msg("connecting to controlplane")
 
 	queryParams := url.Values{}
 	queryParams.Add("runnerid", r.Options.ID)
@@ -198,24 +324,50 @@ func (r *Runner) Initialize(ctx context.Context) error {
 func (r *Runner) StartLooping() {
 	go r.startTaskLoop()
 	go r.startReportStateLoop()
+	go r.startSessionLogCleanupLoop()
 }
 
 func (r *Runner) startTaskLoop() {
+	taskDelay := time.Millisecond * time.Duration(r.Options.GetTaskDelayMilliseconds)
+	retry := newBackoff(r.Options)
+	delay := taskDelay
 	for {
 		select {
 		case <-r.Ctx.Done():
 			return
-		case <-time.After(time.Millisecond * time.Duration(r.Options.GetTaskDelayMilliseconds)):
+		case <-time.After(delay):
 			err := r.taskLoop(r.Ctx)
 			if err != nil {
+				if apiUnavailable(err) {
+					delay = retry.next(taskDelay)
+					log.Debug().Msgf("api unavailable in task loop, trying again in %s: %s", delay, err.Error())
+					continue
+				}
+				delay = taskDelay
 				log.Error().Msgf("error in task loop: %s", err.Error())
 				debug.PrintStack()
+				continue
+			}
+			delay = taskDelay
+			if failures := retry.reset(); failures > 0 {
+				log.Info().Msgf("🟢 task loop reconnected to the api after %d failed attempts", failures)
+				// the report state loop could be waiting out a long backoff
+				r.reportStateNow()
 			}
 		}
 	}
 }
 
 func (r *Runner) taskLoop(ctx context.Context) error {
+	// give back anything we were working on before we restarted
+	// before we ask for more work
+	r.releaseOrphanedSessions()
+
+	// a cordoned runner doesn't start anything new, not even warm instances
+	if r.Cordoned() || r.atInstanceLimit() {
+		return nil
+	}
+
 	session, err := r.getNextWarmupSession()
 	if err != nil {
 		return err
@@ -230,40 +382,83 @@ func (r *Runner) taskLoop(ctx context.Context) error {
 		}
 	}
 
-	if session != nil {
-		// if we need to kill any stale sessions, do it now
+	if session == nil {
+		// nothing to do right now so top up the warm pool
+		return r.fillWarmPool(ctx)
+	}
 
-		// check for running model instances that have not seen a job in a while
-		// and kill them if they are over the timeout AND the session requires it
+	// if we need to kill any stale sessions, do it now
 
-		// TODO: get the timeout to be configurable from the api and so dynamic
-		// based on load
-		err = r.checkForStaleModelInstances(ctx, session)
-		if err != nil {
-			return err
-		}
+	// check for running model instances that have not seen a job in a while
+	// and kill them if they are over the timeout AND the session requires it
 
-		log.Debug().
-			Msgf("🔵 runner start model instance")
-		err = r.createModelInstance(ctx, session)
+	// TODO: get the timeout to be configurable from the api and so dynamic
+	// based on load
+	err = r.checkForStaleModelInstances(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().
+		Msgf("🔵 runner start model instance")
+	return r.createModelInstance(ctx, session)
+}
+
+func (r *Runner) startReportStateLoop() {
+	reportDelay := time.Second * time.Duration(r.Options.ReporStateDelaySeconds)
+	retry := newBackoff(r.Options)
+	delay := reportDelay
+	for {
+		select {
+		case <-r.Ctx.Done():
+			return
+		case <-r.reportStateSignal:
+		case <-time.After(delay):
+		}
+		err := r.reportStateLoop(r.Ctx)
 		if err != nil {
-			return err
+			if apiUnavailable(err) {
+				delay = retry.next(reportDelay)
+				log.Debug().Msgf("api unavailable in report state loop, trying again in %s: %s", delay, err.Error())
+				continue
+			}
+			delay = reportDelay
+			log.Error().Msgf("error in report state loop: %s", err.Error())
+			debug.PrintStack()
+			continue
+		}
+		delay = reportDelay
+		if failures := retry.reset(); failures > 0 {
+			log.Info().Msgf("🟢 report state loop reconnected to the api after %d failed attempts", failures)
 		}
 	}
+}
 
-	return nil
+// have the report state loop send our state now rather than when it next
+// gets round to it
+func (r *Runner) reportStateNow() {
+	select {
+	case r.reportStateSignal <- struct{}{}:
+	default:
+	}
 }
 
-func (r *Runner) startReportStateLoop() {
+// delete the session logs that are past the retention period
+func (r *Runner) startSessionLogCleanupLoop() {
+	if r.sessionLogs == nil {
+		return
+	}
 	for {
 		select {
 		case <-r.Ctx.Done():
 			return
-		case <-time.After(time.Second * time.Duration(r.Options.ReporStateDelaySeconds)):
-			err := r.reportStateLoop(r.Ctx)
+		case <-time.After(time.Hour):
+			removed, err := r.sessionLogs.Cleanup(time.Now())
 			if err != nil {
-				log.Error().Msgf("error in report state loop: %s", err.Error())
-				debug.PrintStack()
+				log.Error().Msgf("error cleaning up session logs: %s", err.Error())
+			}
+			if removed > 0 {
+				log.Info().Msgf("🟠 removed %d old session log files", removed)
 			}
 		}
 	}
@@ -275,7 +470,7 @@ func (r *Runner) reportStateLoop(ctx context.Context) error {
 		return err
 	}
 	log.Trace().Msgf("🟠 Sending runner state %s %+v", r.Options.ID, state)
-	_, err = system.PostRequest[*types.RunnerState, *types.RunnerState](
+	response, err := system.PostRequest[*types.RunnerState, *types.RunnerState](
 		r.httpClientOptions,
 		system.GetApiPath(fmt.Sprintf("/runner/%s/state", r.Options.ID)),
 		state,
@@ -283,6 +478,11 @@ func (r *Runner) reportStateLoop(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	if response != nil {
+		for _, sessionID := range response.StopSessions {
+			r.stopSession(sessionID)
+		}
+	}
 	return nil
 }
 
@@ -297,24 +497,15 @@ func (r *Runner) checkForStaleModelInstances(ctx context.Context, newSession *ty
 	// sort by memory usage
 	// kill as few of them as possible to free up newSession much memory
 
-	var (
-		allModels []ModelInstance
-		stales    []ModelInstance
-	)
-
-	r.activeModelInstances.Range(func(key string, activeModelInstance ModelInstance) bool {
-		allModels = append(allModels, activeModelInstance)
-
+	// by memory usage ascending then ID so the same instances are always
+	// killed in the same order
+	allModels := r.sortedModelInstances()
+	stales := []ModelInstance{}
+	for _, activeModelInstance := range allModels {
 		if activeModelInstance.Stale() {
 			stales = append(stales, activeModelInstance)
 		}
-		return true
-	})
-
-	// sort by memory usage ascending
-	sort.Slice(stales, func(i, j int) bool {
-		return stales[i].Model().GetMemoryRequirements(stales[i].Filter().Mode) < stales[j].Model().GetMemoryRequirements(stales[j].Filter().Mode)
-	})
+	}
 
 	// calculate mem required by new session
 	modelInstance, err := NewAxolotlModelInstance(
@@ -335,25 +526,28 @@ func (r *Runner) checkForStaleModelInstances(ctx context.Context, newSession *ty
 
 	// we don't need to free as much memory as we already have free
 	currentlyAvailableMemory := r.getFreeMemory()
-	if currentlyAvailableMemory < 0 {
-		currentlyAvailableMemory = 0
-	}
 
 	// for this session
 	newSessionMemory := modelInstance.model.GetMemoryRequirements(newSession.Mode)
 	// this can go negative, so it needs to be a signed integer!
 	requiredMemoryFreed := int64(newSessionMemory) - int64(currentlyAvailableMemory)
 
-	if requiredMemoryFreed <= 0 {
+	// the new session needs an instance slot as well as the memory
+	requiredInstancesFreed := 0
+	if r.Options.MaxInstances > 0 {
+		requiredInstancesFreed = r.activeModelInstances.Size() - r.Options.MaxInstances + 1
+	}
+
+	if requiredMemoryFreed <= 0 && requiredInstancesFreed <= 0 {
 		r.addSchedulingDecision("Didn't need to kill any stale sessions because required memory <= 0")
 		return nil
 	}
 
 	for _, m := range stales {
-		if requiredMemoryFreed > 0 {
+		if requiredMemoryFreed > 0 || requiredInstancesFreed > 0 {
 			r.addSchedulingDecision(fmt.Sprintf(
-				"Killing stale model instance %s (%.2fGiB) to make room for %.2fGiB model, requiredMemoryFreed=%.2fGiB, currentlyAvailableMemory=%.2fGiB",
-				m.ID(), GiB(int64(m.Model().GetMemoryRequirements(m.Filter().Mode))), GiB(int64(newSessionMemory)), GiB(requiredMemoryFreed), GiB(int64(currentlyAvailableMemory))),
+				"Killing stale model instance %s (%.2fGiB) to make room for %.2fGiB model, requiredMemoryFreed=%.2fGiB, currentlyAvailableMemory=%.2fGiB, requiredInstancesFreed=%d",
+				m.ID(), GiB(int64(m.Model().GetMemoryRequirements(m.Filter().Mode))), GiB(int64(newSessionMemory)), GiB(requiredMemoryFreed), GiB(int64(currentlyAvailableMemory)), requiredInstancesFreed),
 			)
 			log.Info().Msgf("Killing stale model instance %s", m.ID())
 			err := m.Stop()
@@ -362,6 +556,7 @@ func (r *Runner) checkForStaleModelInstances(ctx context.Context, newSession *ty
 			}
 			r.activeModelInstances.Delete(m.ID())
 			requiredMemoryFreed -= int64(m.Model().GetMemoryRequirements(m.Filter().Mode))
+			requiredInstancesFreed--
 		} else {
 			r.addSchedulingDecision(fmt.Sprintf("Cleared up enough model memory, overshot by %.2f GiB", GiB(requiredMemoryFreed)))
 			log.Info().Msgf("cleared up enough model memory, overshot by %.2f GiB", GiB(requiredMemoryFreed))
@@ -375,9 +570,53 @@ func (r *Runner) checkForStaleModelInstances(ctx context.Context, newSession *ty
 			GiB(int64(newSessionMemory)), GiB(requiredMemoryFreed), stales, allModels,
 		))
 	}
+	if requiredInstancesFreed > 0 {
+		r.addSchedulingDecision(fmt.Sprintf(
+			"uh-oh, we are still over the limit of %d model instances by %d; stales=%+v, allModels=%+v",
+			r.Options.MaxInstances, requiredInstancesFreed, stales, allModels,
+		))
+	}
 	return nil
 }
 
+// the active model instances in the order scheduling looks at them - the
+// least memory first and then by ID - so the same instances always lead to
+// the same decisions whatever order the map hands them back in
+func (r *Runner) sortedModelInstances() []ModelInstance {
+	instances := []ModelInstance{}
+	r.activeModelInstances.Range(func(key string, modelInstance ModelInstance) bool {
+		instances = append(instances, modelInstance)
+		return true
+	})
+	sort.Slice(instances, func(i, j int) bool {
+		memoryI := instances[i].Model().GetMemoryRequirements(instances[i].Filter().Mode)
+		memoryJ := instances[j].Model().GetMemoryRequirements(instances[j].Filter().Mode)
+		if memoryI != memoryJ {
+			return memoryI < memoryJ
+		}
+		return instances[i].ID() < instances[j].ID()
+	})
+	return instances
+}
+
+// stale instances don't count towards the limit because we kill them to
+// make room when a new session comes along
+func (r *Runner) atInstanceLimit() bool {
+	if r.Options.MaxInstances <= 0 {
+		return false
+	}
+
+	instances := 0
+	r.activeModelInstances.Range(func(key string, modelInstance ModelInstance) bool {
+		if !modelInstance.Stale() {
+			instances++
+		}
+		return true
+	})
+
+	return instances >= r.Options.MaxInstances
+}
+
 func (r *Runner) getNextWarmupSession() (*types.Session, error) {
 	if len(r.warmupSessions) == 0 {
 		return nil, nil
@@ -400,12 +639,7 @@ func (r *Runner) getNextWarmupSession() (*types.Session, error) {
 func (r *Runner) getNextGlobalSession(ctx context.Context) (*types.Session, error) {
 	freeMemory := r.getHypotheticalFreeMemory()
 
-	// only run one for dev mode
-	if r.Options.MaxModelInstances > 0 && r.activeModelInstances.Size() >= r.Options.MaxModelInstances {
-		return nil, nil
-	}
-
-	if freeMemory < int64(r.lowestMemoryRequirement) {
+	if freeMemory == 0 || freeMemory < r.lowestMemoryRequirement {
 		// we don't have enough memory to run anything
 		// so we just wait for more memory to become available
 		return nil, nil
@@ -429,15 +663,9 @@ func (r *Runner) getNextGlobalSession(ctx context.Context) (*types.Session, erro
 	if !r.Options.AllowMultipleCopies {
 		// if we are not allowed to run multiple copies of the same model
 		// then we need to tell the api what we are currently running
-		r.activeModelInstances.Range(func(key string, modelInstance ModelInstance) bool {
-			queryParams.Add("reject", fmt.Sprintf(
-				"%s:%s:%s",
-				modelInstance.Filter().ModelName,
-				modelInstance.Filter().Mode,
-				modelInstance.Filter().LoraDir,
-			))
-			return true
-		})
+		for _, modelInstance := range r.sortedModelInstances() {
+			queryParams.Add("reject", rejectEntry(modelInstance.Filter()))
+		}
 	}
 
 	if r.Options.FilterModelName != "" {
@@ -472,10 +700,50 @@ func (r *Runner) readInitialWorkerSession(instanceID string) (*types.Session, er
 	if !ok {
 		return nil, fmt.Errorf("instance not found: %s", instanceID)
 	}
-	if modelInstance.NextSession() == nil {
+	session := modelInstance.NextSession()
+	if session == nil {
 		return nil, fmt.Errorf("no session found")
 	}
-	return modelInstance.NextSession(), nil
+	return session, nil
+}
+
+// model instances that parse their own process output and can ask the
+// process to stop generating for a session
+type stoppableModelInstance interface {
+	StoppedSession() *types.RunnerTaskStop
+	// returns false if the instance isn't running the session
+	StopSession(sessionID string) bool
+}
+
+// the session has been cancelled on the api, have whichever instance is
+// generating for it stop - anything it sends back is dropped anyway
+func (r *Runner) stopSession(sessionID string) {
+	r.activeModelInstances.Range(func(key string, modelInstance ModelInstance) bool {
+		stoppable, ok := modelInstance.(stoppableModelInstance)
+		if !ok || !stoppable.StopSession(sessionID) {
+			return true
+		}
+		log.Info().
+			Str("session_id", sessionID).
+			Str("instance_id", key).
+			Msg("🟠 stopping cancelled session")
+		return false
+	})
+}
+
+func (r *Runner) readStoppedSession(instanceID string) (*types.RunnerTaskStop, error) {
+	if instanceID == "" {
+		return nil, fmt.Errorf("instanceid is required")
+	}
+	modelInstance, ok := r.activeModelInstances.Load(instanceID)
+	if !ok {
+		return nil, fmt.Errorf("instance not found: %s", instanceID)
+	}
+	stoppable, ok := modelInstance.(stoppableModelInstance)
+	if !ok {
+		return &types.RunnerTaskStop{}, nil
+	}
+	return stoppable.StoppedSession(), nil
 }
 
 // we have popped the next session from the master API
@@ -505,11 +773,17 @@ func (r *Runner) createModelInstance(ctx context.Context, initialSession *types.
 					queryParams.Add("model_name", string(modelInstance.Filter().ModelName))
 					queryParams.Add("mode", string(modelInstance.Filter().Mode))
 					queryParams.Add("lora_dir", string(modelInstance.Filter().LoraDir))
+					if modelInstance.Filter().Env != "" {
+						queryParams.Add("env", modelInstance.Filter().Env)
+					}
 
 					nextSession, err := r.getNextApiSession(ctx, queryParams)
 					if err != nil {
 						return nil, err
 					}
+					if nextSession != nil {
+						r.addSessionAssignment(nextSession)
+					}
 					return nextSession, nil
 				},
 				RunnerOptions: r.Options,
@@ -535,7 +809,9 @@ func (r *Runner) createModelInstance(ctx context.Context, initialSession *types.
 				ResponseHandler: func(res *types.RunnerTaskResponse) error {
 					return r.handleWorkerResponse(res)
 				},
-				RunnerOptions: r.Options,
+				RunnerOptions:  r.Options,
+				SessionLogs:    r.sessionLogs,
+				PrepareLimiter: r.prepareLimiter,
 			},
 		)
 		if err != nil {
@@ -545,9 +821,11 @@ func (r *Runner) createModelInstance(ctx context.Context, initialSession *types.
 	}
 
 	// belt and braces in remote case and reject jobs that won't fit in local case
-	modelMem := float32(modelInstance.Model().GetMemoryRequirements(initialSession.Mode)) / 1024 / 1024 / 1024
-	freeMem := float32(r.getFreeMemory()) / 1024 / 1024 / 1024
-	if modelMem > freeMem && initialSession.Owner != "warmup-user" {
+	modelMemory := modelInstance.Model().GetMemoryRequirements(initialSession.Mode)
+	freeMemory := r.getFreeMemory()
+	modelMem := GiB(int64(modelMemory))
+	freeMem := GiB(int64(freeMemory))
+	if modelMemory > freeMemory && initialSession.Owner != "warmup-user" {
 		// refuse to start or record the model instance, it will just get GC'd at this point
 		return fmt.Errorf("cannot fit model requiring gpu memory %.2f into available gpu memory %.2f", modelMem, freeMem)
 	}
@@ -556,6 +834,7 @@ func (r *Runner) createModelInstance(ctx context.Context, initialSession *types.
 		Msgf("🔵 runner started model instance: %s", modelInstance.ID())
 
 	r.activeModelInstances.Store(modelInstance.ID(), modelInstance)
+	r.addSessionAssignment(initialSession)
 
 	// THERE IS NOT A RACE HERE (so Kai please stop thinking there is)
 	// the files are dowloading at the same time as the python process is booting
@@ -596,15 +875,14 @@ func (r *Runner) popNextTask(ctx context.Context, instanceID string) (*types.Run
 
 	var session *types.Session
 
-	if modelInstance.NextSession() != nil {
+	if nextSession := modelInstance.TakeNextSession(); nextSession != nil {
 		// if there is a session in the nextSession cache then we return it immediately
-		log.Debug().Msgf("🟣🟣 loading modelInstance.nextSession %+v", modelInstance.NextSession())
-		session = modelInstance.NextSession()
-		modelInstance.SetNextSession(nil)
-	} else if modelInstance.GetQueuedSession() != nil {
+		log.Debug().Msgf("🟣🟣 loading modelInstance.nextSession %+v", nextSession)
+		session = nextSession
+	} else if queuedSession := modelInstance.GetQueuedSession(); queuedSession != nil {
 		// if there is a session in the queuedSession cache then we are waiting for
 		// a task to complete before we want to actually run the session
-		log.Debug().Msgf("🟡🟡 waiting modelInstance.queuedSession %+v", modelInstance.GetQueuedSession())
+		log.Debug().Msgf("🟡🟡 waiting modelInstance.queuedSession %+v", queuedSession)
 	} else {
 		// ask the upstream api server if there is another task
 		// if there is - then assign it to the queuedSession
@@ -615,6 +893,10 @@ func (r *Runner) popNextTask(ctx context.Context, instanceID string) (*types.Run
 			queryParams.Add("model_name", string(modelInstance.Filter().ModelName))
 			queryParams.Add("mode", string(modelInstance.Filter().Mode))
 			queryParams.Add("lora_dir", string(modelInstance.Filter().LoraDir))
+			// only sessions this process has the env for
+			if modelInstance.Filter().Env != "" {
+				queryParams.Add("env", modelInstance.Filter().Env)
+			}
 
 			apiSession, err := r.getNextApiSession(ctx, queryParams)
 			if err != nil {
@@ -622,6 +904,7 @@ func (r *Runner) popNextTask(ctx context.Context, instanceID string) (*types.Run
 			}
 
 			if apiSession != nil {
+				r.addSessionAssignment(apiSession)
 				go modelInstance.QueueSession(apiSession, false)
 			}
 		}
@@ -641,7 +924,32 @@ func (r *Runner) popNextTask(ctx context.Context, instanceID string) (*types.Run
 	return task, nil
 }
 
+// stop taking new sessions (including the next session for a model that
+// is already running) but let the ones we have finish - for draining the
+// box before maintenance
+func (r *Runner) Cordon() {
+	if !r.cordoned.Swap(true) {
+		r.addSchedulingDecision("Cordoned - not taking any new sessions")
+		log.Info().Msgf("🟠 runner %s cordoned", r.Options.ID)
+	}
+}
+
+func (r *Runner) Uncordon() {
+	if r.cordoned.Swap(false) {
+		r.addSchedulingDecision("Uncordoned - taking new sessions again")
+		log.Info().Msgf("🟠 runner %s uncordoned", r.Options.ID)
+	}
+}
+
+func (r *Runner) Cordoned() bool {
+	return r.cordoned.Load()
+}
+
+// every new session comes through here so a cordoned runner never asks
 func (r *Runner) getNextApiSession(ctx context.Context, queryParams url.Values) (*types.Session, error) {
+	if r.Cordoned() {
+		return nil, nil
+	}
 
 	parsedURL, err := url.Parse(system.URL(r.httpClientOptions, system.GetApiPath(fmt.Sprintf("/runner/%s/nextsession", r.Options.ID))))
 	if err != nil {
@@ -649,7 +957,11 @@ func (r *Runner) getNextApiSession(ctx context.Context, queryParams url.Values)
 	}
 	parsedURL.RawQuery = queryParams.Encode()
 
-	req, err := retryablehttp.NewRequest("GET", parsedURL.String(), nil)
+	// this covers the retries too
+	ctx, cancel := requestContext(ctx, requestTimeout(r.Options))
+	defer cancel()
+
+	req, err := retryablehttp.NewRequestWithContext(ctx, "GET", parsedURL.String(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -708,25 +1020,45 @@ func (r *Runner) getUsedMemoryByNonStale() uint64 {
 	return memoryUsed
 }
 
-func (r *Runner) getFreeMemory() int64 {
-	return int64(r.Options.MemoryBytes) - int64(r.getUsedMemory())
+// all memory is counted in bytes - if the instances we are running add up to
+// more than the runner has (e.g. a warmup session squeezed in) we have
+// nothing free rather than a negative amount that wraps around when compared
+// with a model's requirements
+func memoryLeft(total, used uint64) uint64 {
+	if used >= total {
+		return 0
+	}
+	return total - used
+}
+
+func (r *Runner) getFreeMemory() uint64 {
+	return memoryLeft(r.Options.MemoryBytes, r.getUsedMemory())
 }
 
-func (r *Runner) getHypotheticalFreeMemory() int64 {
-	return int64(r.Options.MemoryBytes) - int64(r.getUsedMemoryByNonStale())
+func (r *Runner) getHypotheticalFreeMemory() uint64 {
+	return memoryLeft(r.Options.MemoryBytes, r.getUsedMemoryByNonStale())
 }
 
 func (r *Runner) handleWorkerResponse(res *types.RunnerTaskResponse) error {
 	// Ignore warmup sessions
-	if res.SessionID == types.WarmupTextSessionID || res.SessionID == types.WarmupImageSessionID {
+	if isWarmupSessionID(res.SessionID) {
 		return nil
 	}
 
+	r.responseSequences.stamp(res)
+
 	switch res.Type {
 	case types.WorkerTaskResponseTypeResult:
 		// if it's a full result then we just post it to the api
-		log.Info().Msgf("🟠 Sending task response %s %+v", res.SessionID, res)
-		return r.postWorkerResponseToApi(res)
+		err := r.postWorkerResponseToApi(res)
+		if err != nil {
+			return err
+		}
+		// the api has the result so there is nothing to give back if we restart
+		if err := r.sessionAssignments.remove(res.SessionID); err != nil {
+			log.Error().Str("session_id", res.SessionID).Msgf("error removing session assignment: %s", err.Error())
+		}
+		return nil
 	case types.WorkerTaskResponseTypeProgress, types.WorkerTaskResponseTypeStream:
 		// streaming updates it's a websocket event
 		return r.sendWorkerResponseToWebsocket(res)
@@ -766,20 +1098,56 @@ func (r *Runner) postWorkerResponseToApi(res *types.RunnerTaskResponse) error {
 	return nil
 }
 
+func (r *Runner) addSessionAssignment(session *types.Session) {
+	if err := r.sessionAssignments.add(session); err != nil {
+		log.Error().Str("session_id", session.ID).Msgf("error saving session assignment: %s", err.Error())
+	}
+}
+
+// hand the sessions we were working on before a restart back to the api so
+// they go back on the queue - anything we can't reach the api about is kept
+// and we try again next time around the task loop
+func (r *Runner) releaseOrphanedSessions() {
+	for _, assignment := range r.sessionAssignments.getOrphaned() {
+		release, err := system.PostRequest[*types.RunnerSessionRelease, *types.RunnerSessionRelease](
+			r.httpClientOptions,
+			system.GetApiPath(fmt.Sprintf("/runner/%s/session/%s/release", r.Options.ID, assignment.SessionID)),
+			&types.RunnerSessionRelease{InteractionID: assignment.InteractionID},
+		)
+		if err != nil {
+			log.Error().Str("session_id", assignment.SessionID).Msgf("error releasing session from before restart: %s", err.Error())
+			continue
+		}
+
+		// the files we were preparing are no use now
+		if assignment.Folder != "" {
+			if err := os.RemoveAll(assignment.Folder); err != nil {
+				log.Error().Str("session_id", assignment.SessionID).Msgf("error removing session files: %s", err.Error())
+			}
+		}
+		if err := r.sessionAssignments.remove(assignment.SessionID); err != nil {
+			log.Error().Str("session_id", assignment.SessionID).Msgf("error removing session assignment: %s", err.Error())
+		}
+
+		r.addSchedulingDecision(fmt.Sprintf("released session %s from before restart (requeued=%t)", assignment.SessionID, release != nil && release.Requeued))
+	}
+}
+
 func (r *Runner) getState() (*types.RunnerState, error) {
 	modelInstances := []*types.ModelInstanceState{}
-	r.activeModelInstances.Range(func(key string, modelInstance ModelInstance) bool {
+	activeModelInstances := r.sortedModelInstances()
+	for _, modelInstance := range activeModelInstances {
 		state, err := modelInstance.GetState()
 		if err != nil {
 			log.Error().Msgf("error getting state for model instance %s (%s): %s", modelInstance.ID(), modelInstance.Filter().ModelName, err.Error())
-			return false
+			break
 		}
 		modelInstances = append(modelInstances, state)
-		return true
-	})
-	if len(modelInstances) != r.activeModelInstances.Size() {
+	}
+	if len(modelInstances) != len(activeModelInstances) {
 		return nil, fmt.Errorf("error getting state, incorrect model instance count")
 	}
+	prepareQueueDepth, preparing := r.prepareLimiter.depth()
 	return &types.RunnerState{
 		ID:                  r.Options.ID,
 		Created:             time.Now(),
@@ -788,6 +1156,11 @@ func (r *Runner) getState() (*types.RunnerState, error) {
 		Labels:              r.Options.Labels,
 		ModelInstances:      modelInstances,
 		SchedulingDecisions: r.schedulingDecisions,
+		InstanceCount:       len(modelInstances),
+		MaxInstances:        r.Options.MaxInstances,
+		Cordoned:            r.Cordoned(),
+		PrepareQueueDepth:   prepareQueueDepth,
+		PreparingSessions:   preparing,
 	}, nil
 }
 
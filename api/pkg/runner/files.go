@@ -3,7 +3,12 @@ package runner
 import (
 	"archive/tar"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -12,7 +17,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/helixml/helix/api/pkg/filestore"
@@ -22,56 +30,175 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// where the model processes write the files they hand back: the cog wrapper
+// keeps training results and final images under /tmp/helix/results/<session>
+// and streams previews from the temp dir
+func DefaultResultsFolders() []string {
+	return []string{"/tmp/helix/results", path.Join(os.TempDir(), "helix-previews")}
+}
+
+// where a session's files are downloaded to while we prepare it
+func sessionDownloadFolder(sessionID string) string {
+	return path.Join(os.TempDir(), "helix", "downloads", sessionID)
+}
+
 type SessionFileHandler struct {
-	folder         string
-	sessionID      string
-	downloadFile   func(sessionID string, remotePath string, localPath string) error
-	downloadFolder func(sessionID string, remotePath string, localPath string) error
+	folder    string
+	sessionID string
+	// the downloads are cancelled along with this (the model instance's context)
+	ctx            context.Context
+	downloadFile   func(ctx context.Context, sessionID string, remotePath string, localPath string) error
+	downloadFolder func(ctx context.Context, sessionID string, remotePath string, localPath string) error
 }
 
 func (handler *SessionFileHandler) GetFolder() string {
 	return handler.folder
 }
 
+// the remote path comes from the session so it's checked before we ask for
+// it and the local path has to be in the session's folder
 func (handler *SessionFileHandler) DownloadFile(remotePath string, localPath string) error {
-	return handler.downloadFile(handler.sessionID, remotePath, localPath)
+	remotePath, localPath, err := handler.checkPaths(remotePath, localPath)
+	if err != nil {
+		return err
+	}
+	return handler.downloadFile(handler.ctx, handler.sessionID, remotePath, localPath)
 }
 
 func (handler *SessionFileHandler) DownloadFolder(remotePath string, localPath string) error {
-	return handler.downloadFolder(handler.sessionID, remotePath, localPath)
+	remotePath, localPath, err := handler.checkPaths(remotePath, localPath)
+	if err != nil {
+		return err
+	}
+	return handler.downloadFolder(handler.ctx, handler.sessionID, remotePath, localPath)
+}
+
+func (handler *SessionFileHandler) checkPaths(remotePath string, localPath string) (string, string, error) {
+	remotePath, err := cleanFilestorePath(remotePath)
+	if err != nil {
+		return "", "", err
+	}
+	localPath, err = system.ResolvePathInside(handler.folder, localPath)
+	if err != nil {
+		return "", "", err
+	}
+	return remotePath, localPath, nil
+}
+
+// filestore paths from sessions are relative to the session's owner and must
+// not climb out of it with ".."
+func cleanFilestorePath(remotePath string) (string, error) {
+	if remotePath == "" {
+		return "", fmt.Errorf("empty filestore path")
+	}
+	for _, part := range strings.Split(filepath.ToSlash(remotePath), "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: filestore path %s", system.ErrPathEscapesRoot, remotePath)
+		}
+	}
+	return path.Clean(remotePath), nil
+}
+
+// the cleaned local path if it is inside one of the roots
+func resolveLocalPathIn(roots []string, localPath string) (string, error) {
+	for _, root := range roots {
+		resolved, err := system.ResolvePathInside(root, localPath)
+		if err == nil {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s is not inside any of %s", system.ErrPathEscapesRoot, localPath, strings.Join(roots, ", "))
 }
 
 // Compile-time interface check:
 var _ model.ModelSessionFileManager = (*SessionFileHandler)(nil)
 
+const (
+	// partial downloads are resumed between attempts
+	downloadFileAttempts = 3
+	// files are downloaded to this path first so a half downloaded file
+	// never looks like the real thing
+	partialDownloadSuffix = ".part"
+)
+
+// the api's filestore can't give us urls to use directly
+var errPresignNotSupported = errors.New("filestore does not support presigned urls")
+
 type FileHandler struct {
 	runnerID          string
 	httpClientOptions system.ClientOptions
 	eventHandler      func(res *types.RunnerTaskResponse)
+	// we only upload results from inside these folders
+	resultsFolders []string
+	// set once the api has told us to send everything through it
+	presignNotSupported atomic.Bool
+	// how long a request to the api can take, or a file transfer can go
+	// without moving any bytes, before we give up on it (zero for no limit)
+	requestTimeout time.Duration
 }
 
 func NewFileHandler(
 	runnerID string,
 	clientOptions system.ClientOptions,
 	eventHandler func(res *types.RunnerTaskResponse),
+	resultsFolders []string,
+	requestTimeout time.Duration,
 ) *FileHandler {
 	return &FileHandler{
 		runnerID:          runnerID,
 		httpClientOptions: clientOptions,
 		eventHandler:      eventHandler,
+		resultsFolders:    resultsFolders,
+		requestTimeout:    requestTimeout,
+	}
+}
+
+// the files and lora dir in a result come from the model process, make sure
+// they are results and not something else on the runner before we upload
+func (handler *FileHandler) checkResultPaths(res *types.RunnerTaskResponse) error {
+	for i, file := range res.Files {
+		resolved, err := resolveLocalPathIn(handler.resultsFolders, file)
+		if err != nil {
+			return err
+		}
+		res.Files[i] = resolved
+	}
+	if res.LoraDir != "" {
+		resolved, err := resolveLocalPathIn(handler.resultsFolders, res.LoraDir)
+		if err != nil {
+			return err
+		}
+		res.LoraDir = resolved
+	}
+	// this ends up in the filestore path of the lora dir
+	if strings.ContainsAny(res.InteractionID, `/\`) || res.InteractionID == ".." {
+		return fmt.Errorf("%w: interaction id %s", system.ErrPathEscapesRoot, res.InteractionID)
 	}
+	return nil
 }
 
-func (handler *FileHandler) uploadWorkerResponse(res *types.RunnerTaskResponse) (*types.RunnerTaskResponse, error) {
-	log.Info().
+func (handler *FileHandler) uploadWorkerResponse(ctx context.Context, res *types.RunnerTaskResponse) (*types.RunnerTaskResponse, error) {
+	log.Debug().
 		Msgf("🟢 upload worker response: %+v", res)
 
+	err := handler.checkResultPaths(res)
+	if err != nil {
+		return nil, err
+	}
+
+	// the api checks these on upload and we pass them on so they end up
+	// in the interaction metadata
+	checksums := map[string]string{}
+
 	if len(res.Files) > 0 {
-		uploadedFiles, err := handler.uploadFiles(res.SessionID, res.Files, types.FILESTORE_RESULTS_DIR)
+		uploadedFiles, fileChecksums, err := handler.uploadFiles(ctx, res.SessionID, res.Files, types.FILESTORE_RESULTS_DIR)
 		if err != nil {
 			return nil, err
 		}
 		res.Files = uploadedFiles
+		for filePath, checksum := range fileChecksums {
+			checksums[filePath] = checksum
+		}
 	}
 
 	if res.LoraDir != "" {
@@ -81,20 +208,52 @@ func (handler *FileHandler) uploadWorkerResponse(res *types.RunnerTaskResponse)
 		// we keep a history of re-trainings and can always go back to a previous step
 		// (because the previous lora dir is still there)
 		// the api server will "hoist" this folder to the session.LoraDir which is the "live" LoraDir
-		uploadedLoraDir, err := handler.uploadFolder(res.SessionID, res.LoraDir, path.Join(types.FILESTORE_LORA_DIR, res.InteractionID))
+		uploadedLoraDir, folderChecksums, err := handler.uploadFolder(ctx, res.SessionID, res.LoraDir, path.Join(types.FILESTORE_LORA_DIR, res.InteractionID))
 		if err != nil {
 			return nil, err
 		}
 		res.LoraDir = uploadedLoraDir
+		for filePath, checksum := range folderChecksums {
+			checksums[filePath] = checksum
+		}
+	}
+
+	if len(checksums) > 0 {
+		res.Checksums = checksums
 	}
 
-	log.Info().
+	log.Debug().
 		Msgf("🟢 worker response uploaded: %+v", res)
 
 	return res, nil
 }
 
-func (handler *FileHandler) downloadFile(sessionID string, remotePath string, localPath string) error {
+// the previews that come with progress go to their own folder - they are
+// only written for us to upload so they are removed once that's done
+func (handler *FileHandler) uploadPreviewResponse(ctx context.Context, res *types.RunnerTaskResponse) (*types.RunnerTaskResponse, error) {
+	err := handler.checkResultPaths(res)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, file := range res.Files {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				log.Warn().Err(err).Str("session_id", res.SessionID).Msgf("failed to remove preview %s", file)
+			}
+		}
+	}()
+
+	uploadedFiles, _, err := handler.uploadFiles(ctx, res.SessionID, res.Files, types.FILESTORE_PREVIEWS_DIR)
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded := *res
+	uploaded.Files = uploadedFiles
+	return &uploaded, nil
+}
+
+func (handler *FileHandler) downloadFile(ctx context.Context, sessionID string, remotePath string, localPath string) error {
 	if err := os.MkdirAll(path.Dir(localPath), os.ModePerm); err != nil {
 		return fmt.Errorf("failed to create folder: %w", err)
 	}
@@ -102,6 +261,19 @@ func (handler *FileHandler) downloadFile(sessionID string, remotePath string, lo
 		return nil
 	}
 
+	transfers, err := handler.presignDownload(ctx, sessionID, remotePath)
+	if err == nil {
+		if len(transfers.Files) != 1 {
+			return fmt.Errorf("expected one file to download for %s but got %d", remotePath, len(transfers.Files))
+		}
+		log.Debug().
+			Msgf("🔵 runner downloading interaction file from filestore: %s", remotePath)
+		return handler.downloadWithRetries(ctx, transfers.Files[0].URL, "", localPath)
+	}
+	if !errors.Is(err, errPresignNotSupported) {
+		log.Warn().Err(err).Msgf("🟠 runner can't download %s from filestore, trying the api", remotePath)
+	}
+
 	url := system.URL(handler.httpClientOptions, system.GetApiPath(fmt.Sprintf("/runner/%s/session/%s/download/file", handler.runnerID, sessionID)))
 	urlValues := urllib.Values{}
 	urlValues.Add("path", remotePath)
@@ -111,40 +283,194 @@ func (handler *FileHandler) downloadFile(sessionID string, remotePath string, lo
 	log.Debug().
 		Msgf("🔵 runner downloading interaction file: %s", fullURL)
 
-	req, err := http.NewRequest("GET", fullURL, nil)
+	return handler.downloadWithRetries(ctx, fullURL, handler.httpClientOptions.Token, localPath)
+}
+
+// the token is only sent to the api - presigned urls carry their own auth
+func (handler *FileHandler) downloadWithRetries(ctx context.Context, fullURL string, token string, localPath string) error {
+	// files in a folder can be nested below it
+	err := os.MkdirAll(filepath.Dir(localPath), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create folder for %s: %w", localPath, err)
+	}
+
+	for attempt := 1; attempt <= downloadFileAttempts; attempt++ {
+		err = handler.resumeDownload(ctx, fullURL, token, localPath)
+		if err == nil {
+			log.Debug().
+				Msgf("🔵 runner downloaded interaction file: %s", localPath)
+			return nil
+		}
+		log.Warn().
+			Err(err).
+			Int("attempt", attempt).
+			Msgf("🟠 runner interaction file download failed: %s", localPath)
+		// the model instance has been stopped
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return err
+}
+
+// downloads into a .part file next to localPath picking up from wherever a
+// previous attempt got to and only moves it into place once it is complete
+func (handler *FileHandler) resumeDownload(ctx context.Context, fullURL string, token string, localPath string) error {
+	partPath := localPath + partialDownloadSuffix
+
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	transfer, cancel := newTransferTimeout(ctx, handler.requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(transfer.ctx, "GET", fullURL, nil)
 	if err != nil {
 		return err
 	}
-	system.AddAutheaders(req, handler.httpClientOptions.Token)
+	system.AddAutheaders(req, token)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
+		return transfer.wrapError(err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	totalSize := int64(-1)
+	flags := os.O_CREATE | os.O_WRONLY
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// either we asked for the whole file or the server can't do ranges
+		flags |= os.O_TRUNC
+		totalSize = resp.ContentLength
+	case http.StatusPartialContent:
+		start, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			discardPartialDownload(partPath)
+			return fmt.Errorf("unexpected content range '%s' resuming %s from %d", resp.Header.Get("Content-Range"), fullURL, offset)
+		}
+		flags |= os.O_APPEND
+		totalSize = total
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the partial file is at least as big as the remote file so it's either
+		// already complete (we died before renaming it) or it's junk
+		_, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err == nil && total == offset {
+			return finishDownload(partPath, localPath, resp.Header.Get("Digest"))
+		}
+		discardPartialDownload(partPath)
+		return fmt.Errorf("partial download of %s is bigger than the remote file", fullURL)
+	default:
 		return fmt.Errorf("unexpected status code for file download: %d %s", resp.StatusCode, fullURL)
 	}
 
-	file, err := os.Create(localPath)
+	file, err := os.OpenFile(partPath, flags, 0644)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	_, err = io.Copy(file, resp.Body)
+	// if this fails we keep what we have got so the next attempt can resume
+	_, err = io.Copy(file, transfer.reader(resp.Body))
+	closeErr := file.Close()
 	if err != nil {
-		return err
+		return transfer.wrapError(err)
+	}
+	if closeErr != nil {
+		return closeErr
 	}
 
-	log.Debug().
-		Msgf("🔵 runner downloaded interaction file: %s -> %s", remotePath, localPath)
+	if totalSize >= 0 {
+		info, err := os.Stat(partPath)
+		if err != nil {
+			return err
+		}
+		if info.Size() != totalSize {
+			discardPartialDownload(partPath)
+			return fmt.Errorf("downloaded %d bytes of %s but expected %d", info.Size(), fullURL, totalSize)
+		}
+	}
 
-	return nil
+	return finishDownload(partPath, localPath, resp.Header.Get("Digest"))
+}
+
+// check the checksum if the server gave us one and move the file into place
+func finishDownload(partPath string, localPath string, digest string) error {
+	expected := parseSHA256Digest(digest)
+	if expected != "" {
+		actual, err := system.FileSHA256(partPath)
+		if err != nil {
+			return err
+		}
+		if base64.StdEncoding.EncodeToString(actual) != expected {
+			discardPartialDownload(partPath)
+			return fmt.Errorf("checksum mismatch for %s", localPath)
+		}
+	}
+	return os.Rename(partPath, localPath)
+}
+
+func discardPartialDownload(partPath string) {
+	if err := os.Remove(partPath); err != nil && !os.IsNotExist(err) {
+		log.Error().Msgf("error removing partial download %s: %s", partPath, err.Error())
+	}
+}
+
+// parses "bytes 100-199/200" or "bytes */200" returning the first byte and
+// the total size (-1 for either if the server didn't tell us)
+func parseContentRange(contentRange string) (int64, int64, error) {
+	rangeSpec, found := strings.CutPrefix(contentRange, "bytes ")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid content range: %s", contentRange)
+	}
+	byteRange, totalString, found := strings.Cut(rangeSpec, "/")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid content range: %s", contentRange)
+	}
+
+	start := int64(-1)
+	if byteRange != "*" {
+		startString, _, found := strings.Cut(byteRange, "-")
+		if !found {
+			return 0, 0, fmt.Errorf("invalid content range: %s", contentRange)
+		}
+		parsed, err := strconv.ParseInt(startString, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid content range: %s", contentRange)
+		}
+		start = parsed
+	}
+
+	total := int64(-1)
+	if totalString != "*" {
+		parsed, err := strconv.ParseInt(totalString, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid content range: %s", contentRange)
+		}
+		total = parsed
+	}
+
+	return start, total, nil
 }
 
-func (handler *FileHandler) downloadFolder(sessionID string, remotePath string, localPath string) error {
+// picks the sha-256 value out of a "Digest: sha-256=<base64>" header
+func parseSHA256Digest(digest string) string {
+	for _, part := range strings.Split(digest, ",") {
+		algorithm, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if found && strings.EqualFold(algorithm, "sha-256") {
+			return value
+		}
+	}
+	return ""
+}
+
+func (handler *FileHandler) downloadFolder(ctx context.Context, sessionID string, remotePath string, localPath string) error {
 	// if the folder already exists, then assume we have already downloaded everything
 	if _, err := os.Stat(localPath); err == nil {
 		log.Debug().Msgf("🟠 runner already downloaded folder: %s %s", sessionID, localPath)
@@ -154,6 +480,30 @@ func (handler *FileHandler) downloadFolder(sessionID string, remotePath string,
 	if err := os.MkdirAll(localPath, os.ModePerm); err != nil {
 		return fmt.Errorf("failed to create folder: %w", err)
 	}
+
+	transfers, err := handler.presignDownload(ctx, sessionID, remotePath)
+	if err == nil {
+		log.Debug().
+			Msgf("🔵 runner downloading folder from filestore: %s %s", sessionID, remotePath)
+		for _, file := range transfers.Files {
+			var filePath string
+			filePath, err = system.ResolvePathInside(localPath, filepath.Join(localPath, filepath.FromSlash(file.Name)))
+			if err == nil {
+				err = handler.downloadWithRetries(ctx, file.URL, "", filePath)
+			}
+			if err != nil {
+				// otherwise next time we'd think we already have it
+				os.RemoveAll(localPath)
+				return err
+			}
+		}
+		log.Debug().Msgf("🟠 runner downloaded folder: %s %s", sessionID, localPath)
+		return nil
+	}
+	if !errors.Is(err, errPresignNotSupported) {
+		log.Warn().Err(err).Msgf("🟠 runner can't download %s from filestore, trying the api", remotePath)
+	}
+
 	url := system.URL(handler.httpClientOptions, system.GetApiPath(fmt.Sprintf("/runner/%s/session/%s/download/folder", handler.runnerID, sessionID)))
 	urlValues := urllib.Values{}
 	urlValues.Add("path", remotePath)
@@ -162,7 +512,10 @@ func (handler *FileHandler) downloadFolder(sessionID string, remotePath string,
 	log.Debug().
 		Msgf("🔵 runner downloading folder: %s %s", sessionID, remotePath)
 
-	req, err := http.NewRequest("GET", fullURL, nil)
+	transfer, cancel := newTransferTimeout(ctx, handler.requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(transfer.ctx, "GET", fullURL, nil)
 	if err != nil {
 		return err
 	}
@@ -170,7 +523,7 @@ func (handler *FileHandler) downloadFolder(sessionID string, remotePath string,
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
+		return transfer.wrapError(err)
 	}
 	defer resp.Body.Close()
 
@@ -178,9 +531,9 @@ func (handler *FileHandler) downloadFolder(sessionID string, remotePath string,
 		return fmt.Errorf("unexpected status code for file download: %d %s", resp.StatusCode, fullURL)
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(transfer.reader(resp.Body))
 	if err != nil {
-		return err
+		return transfer.wrapError(err)
 	}
 
 	var buffer bytes.Buffer
@@ -201,38 +554,218 @@ func (handler *FileHandler) downloadFolder(sessionID string, remotePath string,
 	return nil
 }
 
-func (handler *FileHandler) uploadFiles(sessionID string, localFiles []string, remoteFolder string) ([]string, error) {
+// asks the api for urls to move files straight to or from the filestore
+func (handler *FileHandler) presign(ctx context.Context, direction string, sessionID string, remotePath string, body interface{}) (*types.RunnerFileTransfers, error) {
+	if handler.presignNotSupported.Load() {
+		return nil, errPresignNotSupported
+	}
+
+	url := system.URL(handler.httpClientOptions, system.GetApiPath(fmt.Sprintf("/runner/%s/session/%s/presign/%s", handler.runnerID, sessionID, direction)))
+	urlValues := urllib.Values{}
+	urlValues.Add("path", remotePath)
+	fullURL := fmt.Sprintf("%s?%s", url, urlValues.Encode())
+
+	method := "GET"
+	var reqBody io.Reader
+	if body != nil {
+		bodyJSON, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		method = "POST"
+		reqBody = bytes.NewReader(bodyJSON)
+	}
+
+	ctx, cancel := requestContext(ctx, handler.requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, fullURL, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	system.AddAutheaders(req, handler.httpClientOptions.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotImplemented {
+		handler.presignNotSupported.Store(true)
+		return nil, errPresignNotSupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		bts, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code: %d (%s)", resp.StatusCode, string(bts))
+	}
+
+	transfers := &types.RunnerFileTransfers{}
+	err = json.NewDecoder(resp.Body).Decode(transfers)
+	if err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+func (handler *FileHandler) presignDownload(ctx context.Context, sessionID string, remotePath string) (*types.RunnerFileTransfers, error) {
+	return handler.presign(ctx, "download", sessionID, remotePath, nil)
+}
+
+// files are named relative to the remote folder and must have checksums
+func (handler *FileHandler) presignUpload(ctx context.Context, sessionID string, remoteFolder string, files []*types.RunnerFileTransfer) (*types.RunnerFileTransfers, error) {
+	transfers, err := handler.presign(ctx, "upload", sessionID, remoteFolder, files)
+	if err != nil {
+		return nil, err
+	}
+	if len(transfers.Files) != len(files) {
+		return nil, fmt.Errorf("asked to upload %d files but got %d urls", len(files), len(transfers.Files))
+	}
+	return transfers, nil
+}
+
+// uploads a file to a presigned url, the filestore checks the checksum
+func (handler *FileHandler) putFile(ctx context.Context, transfer *types.RunnerFileTransfer, localPath string, wrap func(io.Reader) io.Reader) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader = file
+	if wrap != nil {
+		body = wrap(file)
+	}
+
+	transferTimeout, cancel := newTransferTimeout(ctx, handler.requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(transferTimeout.ctx, "PUT", transfer.URL, transferTimeout.reader(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	if info.Size() == 0 {
+		req.Body = http.NoBody
+	}
+	for name, value := range transfer.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return transferTimeout.wrapError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bts, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code uploading %s: %d (%s)", localPath, resp.StatusCode, string(bts))
+	}
+	return nil
+}
+
+func (handler *FileHandler) uploadFilesDirect(ctx context.Context, sessionID string, localFiles []string, remoteFolder string) ([]string, map[string]string, error) {
+	files := []*types.RunnerFileTransfer{}
+	for _, localFile := range localFiles {
+		checksum, err := system.FileSHA256(localFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, &types.RunnerFileTransfer{
+			Name:     path.Base(localFile),
+			Checksum: hex.EncodeToString(checksum),
+		})
+	}
+
+	transfers, err := handler.presignUpload(ctx, sessionID, remoteFolder, files)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Debug().Msgf("🟠 Uploading task files to filestore %s %+v", sessionID, localFiles)
+
+	uploadedFiles := []string{}
+	checksums := map[string]string{}
+	for i, transfer := range transfers.Files {
+		err = handler.putFile(ctx, transfer, localFiles[i], nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		uploadedFiles = append(uploadedFiles, transfer.Path)
+		checksums[transfer.Path] = files[i].Checksum
+	}
+
+	return uploadedFiles, checksums, nil
+}
+
+// returns the uploaded paths along with the sha256 of each one keyed by that path
+func (handler *FileHandler) uploadFiles(ctx context.Context, sessionID string, localFiles []string, remoteFolder string) ([]string, map[string]string, error) {
+	uploadedFiles, uploadedChecksums, err := handler.uploadFilesDirect(ctx, sessionID, localFiles, remoteFolder)
+	if err == nil {
+		return uploadedFiles, uploadedChecksums, nil
+	}
+	if !errors.Is(err, errPresignNotSupported) {
+		log.Warn().Err(err).Msgf("🟠 runner can't upload files to filestore, trying the api: %s", sessionID)
+	}
+
 	// create a new multipart form
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
 
 	log.Debug().Msgf("🟠 Uploading task files %s %+v", sessionID, localFiles)
 
+	// the server only sees the base name of each file
+	checksums := map[string]string{}
+	localChecksums := []string{}
+
 	// loop over each file and add it to the form
 	for _, filepath := range localFiles {
 		file, err := os.Open(filepath)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		defer file.Close()
 
 		// create a new form field for the file
 		part, err := writer.CreateFormFile("files", filepath)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		// copy the file contents into the form field
-		_, err = io.Copy(part, file)
+		// copy the file contents into the form field hashing as we go
+		hash := sha256.New()
+		_, err = io.Copy(io.MultiWriter(part, hash), file)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+
+		checksum := hex.EncodeToString(hash.Sum(nil))
+		checksums[path.Base(filepath)] = checksum
+		localChecksums = append(localChecksums, checksum)
+	}
+
+	checksumsJSON, err := json.Marshal(checksums)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = writer.WriteField(system.UploadChecksumsField, string(checksumsJSON))
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// close the multipart form
-	err := writer.Close()
+	err = writer.Close()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	url := system.URL(handler.httpClientOptions, system.GetApiPath(fmt.Sprintf("/runner/%s/session/%s/upload/files", handler.runnerID, sessionID)))
@@ -242,11 +775,16 @@ func (handler *FileHandler) uploadFiles(sessionID string, localFiles []string, r
 
 	log.Debug().Msgf("🟠 upload files %s", fullURL)
 
+	transfer, cancel := newTransferTimeout(ctx, handler.requestTimeout)
+	defer cancel()
+
 	// create a new POST request with the multipart form as the body
-	req, err := http.NewRequest("POST", fullURL, body)
+	req, err := http.NewRequestWithContext(transfer.ctx, "POST", fullURL, transfer.reader(body))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	// wrapping the form hides its size from the http client
+	req.ContentLength = int64(body.Len())
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	system.AddAutheaders(req, handler.httpClientOptions.Token)
 
@@ -254,7 +792,7 @@ func (handler *FileHandler) uploadFiles(sessionID string, localFiles []string, r
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, transfer.wrapError(err)
 	}
 	defer resp.Body.Close()
 
@@ -262,31 +800,36 @@ func (handler *FileHandler) uploadFiles(sessionID string, localFiles []string, r
 	if resp.StatusCode != http.StatusOK {
 		bts, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+			return nil, nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 		}
 
-		return nil, fmt.Errorf("unexpected status code: %d (%s)", resp.StatusCode, string(bts))
+		return nil, nil, fmt.Errorf("unexpected status code: %d (%s)", resp.StatusCode, string(bts))
 	}
 
 	var data []filestore.FileStoreItem
 	resultBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// parse body as json into result
 	err = json.Unmarshal(resultBody, &data)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	mappedFiles := []string{}
+	mappedChecksums := map[string]string{}
 
-	for _, fileItem := range data {
+	// the server returns the files in the order we sent them
+	for i, fileItem := range data {
 		mappedFiles = append(mappedFiles, fileItem.Path)
+		if i < len(localChecksums) {
+			mappedChecksums[fileItem.Path] = localChecksums[i]
+		}
 	}
 
-	return mappedFiles, nil
+	return mappedFiles, mappedChecksums, nil
 }
 
 type ProgressReader struct {
@@ -308,58 +851,132 @@ func (pr *ProgressReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
-func (handler *FileHandler) uploadFolder(sessionID string, localPath string, remoteFolder string) (string, error) {
+// lets the user know how the upload of their fine tuned files is going
+func (handler *FileHandler) uploadProgress(sessionID string, totalSize uint64) func(int, uint64) {
+	handler.eventHandler(&types.RunnerTaskResponse{
+		Type:      types.WorkerTaskResponseTypeProgress,
+		SessionID: sessionID,
+		Progress:  1,
+		Status:    "uploading fine tuned files...",
+	})
+	return func(percent int, bytesRead uint64) {
+		handler.eventHandler(&types.RunnerTaskResponse{
+			Type:      types.WorkerTaskResponseTypeProgress,
+			SessionID: sessionID,
+			Progress:  percent,
+			Status:    fmt.Sprintf("uploaded %s of %s", humanize.Bytes(bytesRead), humanize.Bytes(totalSize)),
+		})
+	}
+}
+
+// each file in the folder goes straight to the filestore so there is no tar
+func (handler *FileHandler) uploadFolderDirect(ctx context.Context, sessionID string, localPath string, remoteFolder string) (string, map[string]string, error) {
+	if handler.presignNotSupported.Load() {
+		return "", nil, errPresignNotSupported
+	}
+
+	files := []*types.RunnerFileTransfer{}
+	localFiles := []string{}
+	var totalSize uint64
+	err := filepath.Walk(localPath, func(file string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(localPath, file)
+		if err != nil {
+			return err
+		}
+		checksum, err := system.FileSHA256(file)
+		if err != nil {
+			return err
+		}
+		files = append(files, &types.RunnerFileTransfer{
+			Name:     filepath.ToSlash(name),
+			Checksum: hex.EncodeToString(checksum),
+		})
+		localFiles = append(localFiles, file)
+		totalSize += uint64(fi.Size())
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	transfers, err := handler.presignUpload(ctx, sessionID, remoteFolder, files)
+	if err != nil {
+		return "", nil, err
+	}
+
+	log.Debug().Msgf("🟠 upload task folder to filestore %s %s", sessionID, transfers.Path)
+
+	// one progress reader per file but counting towards the whole folder
+	progress := &ProgressReader{
+		totalSize:      totalSize,
+		emitPercentage: handler.uploadProgress(sessionID, totalSize),
+	}
+	checksums := map[string]string{}
+	for i, transfer := range transfers.Files {
+		err = handler.putFile(ctx, transfer, localFiles[i], func(r io.Reader) io.Reader {
+			progress.reader = r
+			return progress
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		checksums[transfer.Path] = files[i].Checksum
+	}
+
+	return transfers.Path, checksums, nil
+}
+
+// returns the uploaded folder path along with the sha256 of each file in it
+// keyed by the file's uploaded path
+func (handler *FileHandler) uploadFolder(ctx context.Context, sessionID string, localPath string, remoteFolder string) (string, map[string]string, error) {
 	log.Debug().Msgf("🟠 Uploading task folder %s %+v", sessionID, localPath)
 
 	dirInfo, err := os.Stat(localPath)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	if !dirInfo.IsDir() {
-		return "", fmt.Errorf("not a directory: %s", localPath)
+		return "", nil, fmt.Errorf("not a directory: %s", localPath)
+	}
+
+	uploadedFolder, uploadedChecksums, err := handler.uploadFolderDirect(ctx, sessionID, localPath, remoteFolder)
+	if err == nil {
+		return uploadedFolder, uploadedChecksums, nil
+	}
+	if !errors.Is(err, errPresignNotSupported) {
+		log.Warn().Err(err).Msgf("🟠 runner can't upload folder to filestore, trying the api: %s", sessionID)
 	}
 
 	// Create a .tar file from the directory
-	tarFilePath, err := createTar(localPath)
+	tarFilePath, tarChecksums, err := createTar(localPath)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	file, err := os.Open(tarFilePath)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	defer file.Close()
 
 	// Get the file size for progress calculation
 	fileInfo, err := file.Stat()
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	handler.eventHandler(&types.RunnerTaskResponse{
-		Type:      types.WorkerTaskResponseTypeProgress,
-		SessionID: sessionID,
-		Progress:  1,
-		Status:    "uploading fine tuned files...",
-	})
-
 	totalSize := uint64(fileInfo.Size())
 
 	progressReader := &ProgressReader{
-		reader:    file,
-		totalSize: totalSize,
-		bytesRead: 0,
-		percent:   0,
-		emitPercentage: func(percent int, bytesRead uint64) {
-			handler.eventHandler(&types.RunnerTaskResponse{
-				Type:      types.WorkerTaskResponseTypeProgress,
-				SessionID: sessionID,
-				Progress:  percent,
-				Status:    fmt.Sprintf("uploaded %s of %s", humanize.Bytes(bytesRead), humanize.Bytes(totalSize)),
-			})
-		},
+		reader:         file,
+		totalSize:      totalSize,
+		bytesRead:      0,
+		percent:        0,
+		emitPercentage: handler.uploadProgress(sessionID, totalSize),
 	}
 
 	url := system.URL(handler.httpClientOptions, system.GetApiPath(fmt.Sprintf("/runner/%s/session/%s/upload/folder", handler.runnerID, sessionID)))
@@ -369,9 +986,12 @@ func (handler *FileHandler) uploadFolder(sessionID string, localPath string, rem
 
 	log.Debug().Msgf("🟠 upload task folder %s", fullURL)
 
-	req, err := http.NewRequest("POST", fullURL, progressReader)
+	transfer, cancel := newTransferTimeout(ctx, handler.requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(transfer.ctx, "POST", fullURL, transfer.reader(progressReader))
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	system.AddAutheaders(req, handler.httpClientOptions.Token)
 
@@ -379,40 +999,51 @@ func (handler *FileHandler) uploadFolder(sessionID string, localPath string, rem
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return "", nil, transfer.wrapError(err)
 	}
 	defer resp.Body.Close()
 
 	// handle the response
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		bts, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		return "", nil, fmt.Errorf("unexpected status code: %d (%s)", resp.StatusCode, string(bts))
 	}
 
 	var data filestore.FileStoreItem
 	resultBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	// parse body as json into result
 	err = json.Unmarshal(resultBody, &data)
 	if err != nil {
-		return "", err
+		return "", nil, err
+	}
+
+	checksums := map[string]string{}
+	for name, checksum := range tarChecksums {
+		checksums[path.Join(data.Path, name)] = checksum
 	}
 
-	return data.Path, nil
+	return data.Path, checksums, nil
 }
 
 // createTar takes a directory path and creates a .tar file from it.
-// It returns the path of the created .tar file and any error encountered.
-func createTar(dirPath string) (string, error) {
+// It returns the path of the created .tar file, the sha256 of each file in it
+// keyed by its name in the tar and any error encountered.
+// The sha256 is also put in each file's PAX header so the api can check it.
+func createTar(dirPath string) (string, map[string]string, error) {
 	// Define the .tar file name (it will be in the same directory as the input folder)
 	tarFilePath := dirPath + ".tar"
 
 	// Create the .tar file
 	tarFile, err := os.Create(tarFilePath)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	defer tarFile.Close()
 
@@ -420,6 +1051,8 @@ func createTar(dirPath string) (string, error) {
 	tw := tar.NewWriter(tarFile)
 	defer tw.Close()
 
+	checksums := map[string]string{}
+
 	// Walk through every file in the folder
 	err = filepath.Walk(dirPath, func(file string, fi os.FileInfo, err error) error {
 		if err != nil {
@@ -436,6 +1069,17 @@ func createTar(dirPath string) (string, error) {
 		// This is to ensure that the path in the tar file is relative and not absolute.
 		header.Name = strings.TrimPrefix(strings.Replace(file, dirPath, "", -1), string(filepath.Separator))
 
+		if !fi.IsDir() {
+			checksum, err := system.FileSHA256(file)
+			if err != nil {
+				return err
+			}
+			checksums[header.Name] = hex.EncodeToString(checksum)
+			header.PAXRecords = map[string]string{
+				system.TarChecksumPAXRecord: checksums[header.Name],
+			}
+		}
+
 		// Write the header to the tarball archive
 		if err := tw.WriteHeader(header); err != nil {
 			return err
@@ -457,8 +1101,8 @@ func createTar(dirPath string) (string, error) {
 	})
 
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return tarFilePath, nil
+	return tarFilePath, checksums, nil
 }
@@ -0,0 +1,386 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newTestFileHandler(t *testing.T, content []byte, withDigest bool) (*FileHandler, *[]string) {
+	ranges := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// everything goes through the api
+		if strings.Contains(r.URL.Path, "/presign/") {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		if withDigest {
+			sum := sha256.Sum256(content)
+			w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		}
+		http.ServeContent(w, r, "file.txt", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+
+	return NewFileHandler("runner-1", system.ClientOptions{Host: server.URL}, nil, []string{os.TempDir()}, 0), &ranges
+}
+
+func TestDownloadFile(t *testing.T) {
+	content := []byte("hello world, this is a file")
+	handler, ranges := newTestFileHandler(t, content, true)
+
+	localPath := filepath.Join(t.TempDir(), "file.txt")
+	err := handler.downloadFile(context.Background(), "session-1", "file.txt", localPath)
+	require.NoError(t, err)
+
+	downloaded, err := os.ReadFile(localPath)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+	assert.Equal(t, []string{""}, *ranges)
+
+	_, err = os.Stat(localPath + partialDownloadSuffix)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadFile_ResumesPartial(t *testing.T) {
+	content := []byte("hello world, this is a file")
+	handler, ranges := newTestFileHandler(t, content, true)
+
+	localPath := filepath.Join(t.TempDir(), "file.txt")
+	err := os.WriteFile(localPath+partialDownloadSuffix, content[:10], 0644)
+	require.NoError(t, err)
+
+	err = handler.downloadFile(context.Background(), "session-1", "file.txt", localPath)
+	require.NoError(t, err)
+
+	downloaded, err := os.ReadFile(localPath)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+	assert.Equal(t, []string{"bytes=10-"}, *ranges)
+}
+
+func TestDownloadFile_CompletePartial(t *testing.T) {
+	content := []byte("hello world, this is a file")
+	handler, _ := newTestFileHandler(t, content, false)
+
+	// we got the whole file last time but died before renaming it
+	localPath := filepath.Join(t.TempDir(), "file.txt")
+	err := os.WriteFile(localPath+partialDownloadSuffix, content, 0644)
+	require.NoError(t, err)
+
+	err = handler.downloadFile(context.Background(), "session-1", "file.txt", localPath)
+	require.NoError(t, err)
+
+	downloaded, err := os.ReadFile(localPath)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+}
+
+func TestDownloadFile_DiscardsCorruptPartial(t *testing.T) {
+	content := []byte("hello world, this is a file")
+	handler, ranges := newTestFileHandler(t, content, true)
+
+	// the first 10 bytes don't match so the checksum fails and we start again
+	localPath := filepath.Join(t.TempDir(), "file.txt")
+	err := os.WriteFile(localPath+partialDownloadSuffix, []byte("corrupted!"), 0644)
+	require.NoError(t, err)
+
+	err = handler.downloadFile(context.Background(), "session-1", "file.txt", localPath)
+	require.NoError(t, err)
+
+	downloaded, err := os.ReadFile(localPath)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+	assert.Equal(t, []string{"bytes=10-", ""}, *ranges)
+}
+
+// an api that hands out urls to a fake bucket which checks the checksums
+func newTestDirectFileHandler(t *testing.T) (*FileHandler, map[string][]byte) {
+	var mu sync.Mutex
+	bucket := map[string][]byte{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/presign/download"):
+			transfers := &types.RunnerFileTransfers{Path: r.URL.Query().Get("path")}
+			for key := range bucket {
+				if name, found := strings.CutPrefix(key, transfers.Path+"/"); found {
+					transfers.Files = append(transfers.Files, &types.RunnerFileTransfer{Name: name, URL: server.URL + "/bucket/" + key})
+				}
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(transfers))
+		case strings.HasSuffix(r.URL.Path, "/presign/upload"):
+			files := []*types.RunnerFileTransfer{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&files))
+			folder := "sessions/session-1/" + r.URL.Query().Get("path")
+			for _, file := range files {
+				file.Path = folder + "/" + file.Name
+				file.URL = server.URL + "/bucket/" + file.Path
+				file.Headers = map[string]string{"X-Checksum": file.Checksum}
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(&types.RunnerFileTransfers{Path: folder, Files: files}))
+		case r.Method == "PUT":
+			// presigned urls carry their own auth
+			assert.Empty(t, r.Header.Get("Authorization"))
+			content, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			sum := sha256.Sum256(content)
+			if hex.EncodeToString(sum[:]) != r.Header.Get("X-Checksum") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			bucket[strings.TrimPrefix(r.URL.Path, "/bucket/")] = content
+		default:
+			content, ok := bucket[strings.TrimPrefix(r.URL.Path, "/bucket/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return NewFileHandler("runner-1", system.ClientOptions{Host: server.URL}, func(res *types.RunnerTaskResponse) {}, []string{os.TempDir()}, 0), bucket
+}
+
+func TestFileHandler_DirectTransfers(t *testing.T) {
+	handler, bucket := newTestDirectFileHandler(t)
+
+	localFolder := filepath.Join(t.TempDir(), "lora")
+	require.NoError(t, os.MkdirAll(filepath.Join(localFolder, "weights"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(localFolder, "adapter_config.json"), []byte("{}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(localFolder, "weights", "adapter_model.bin"), []byte("weights"), 0644))
+
+	uploadedFolder, checksums, err := handler.uploadFolder(context.Background(), "session-1", localFolder, "lora/interaction-1")
+	require.NoError(t, err)
+	assert.Equal(t, "sessions/session-1/lora/interaction-1", uploadedFolder)
+	assert.Equal(t, []byte("weights"), bucket["sessions/session-1/lora/interaction-1/weights/adapter_model.bin"])
+	assert.Len(t, checksums, 2)
+	assert.Contains(t, checksums, "sessions/session-1/lora/interaction-1/adapter_config.json")
+
+	// no tar was made because nothing went through the api
+	_, err = os.Stat(localFolder + ".tar")
+	assert.True(t, os.IsNotExist(err))
+
+	downloadFolder := filepath.Join(t.TempDir(), "download")
+	require.NoError(t, handler.downloadFolder(context.Background(), "session-1", uploadedFolder, downloadFolder))
+	downloaded, err := os.ReadFile(filepath.Join(downloadFolder, "weights", "adapter_model.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("weights"), downloaded)
+
+	uploadedFiles, _, err := handler.uploadFiles(context.Background(), "session-1", []string{filepath.Join(localFolder, "adapter_config.json")}, "results")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sessions/session-1/results/adapter_config.json"}, uploadedFiles)
+}
+
+func TestFileHandler_UploadPreviewResponse(t *testing.T) {
+	handler, bucket := newTestDirectFileHandler(t)
+
+	preview := filepath.Join(t.TempDir(), "preview_session-1_010.png")
+	require.NoError(t, os.WriteFile(preview, []byte("preview"), 0644))
+
+	res := &types.RunnerTaskResponse{
+		Type:      types.WorkerTaskResponseTypeProgress,
+		SessionID: "session-1",
+		Progress:  15,
+		Files:     []string{preview},
+	}
+	uploaded, err := handler.uploadPreviewResponse(context.Background(), res)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sessions/session-1/previews/preview_session-1_010.png"}, uploaded.Files)
+	assert.Equal(t, 15, uploaded.Progress)
+	assert.Equal(t, []byte("preview"), bucket["sessions/session-1/previews/preview_session-1_010.png"])
+
+	// it was only there for us to upload
+	_, err = os.Stat(preview)
+	assert.True(t, os.IsNotExist(err))
+
+	// and we don't touch anything outside the results folders
+	_, err = handler.uploadPreviewResponse(context.Background(), &types.RunnerTaskResponse{
+		Type:      types.WorkerTaskResponseTypeProgress,
+		SessionID: "session-1",
+		Files:     []string{"/etc/passwd"},
+	})
+	require.Error(t, err)
+}
+
+func TestParseContentRange(t *testing.T) {
+	start, total, err := parseContentRange("bytes 100-199/200")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), start)
+	assert.Equal(t, int64(200), total)
+
+	start, total, err = parseContentRange("bytes */200")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), start)
+	assert.Equal(t, int64(200), total)
+
+	_, _, err = parseContentRange("items 1-2/3")
+	assert.Error(t, err)
+}
+
+func TestCreateTar_Checksums(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "lora")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "nested"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "adapter.bin"), []byte("weights"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nested", "config.json"), []byte("{}"), 0644))
+
+	tarPath, checksums, err := createTar(dir)
+	require.NoError(t, err)
+
+	weightsSum := sha256.Sum256([]byte("weights"))
+	configSum := sha256.Sum256([]byte("{}"))
+	assert.Equal(t, map[string]string{
+		"adapter.bin":        hex.EncodeToString(weightsSum[:]),
+		"nested/config.json": hex.EncodeToString(configSum[:]),
+	}, checksums)
+
+	tarFile, err := os.Open(tarPath)
+	require.NoError(t, err)
+	defer tarFile.Close()
+
+	// every file carries its checksum so the api can verify it
+	tarReader := tar.NewReader(tarFile)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		assert.Equal(t, checksums[header.Name], header.PAXRecords[system.TarChecksumPAXRecord])
+	}
+}
+
+func TestCleanFilestorePath(t *testing.T) {
+	cleaned, err := cleanFilestorePath("sessions/session-1/./lora/")
+	require.NoError(t, err)
+	assert.Equal(t, "sessions/session-1/lora", cleaned)
+
+	for _, remotePath := range []string{"", "../other-user", "sessions/../../etc"} {
+		_, err := cleanFilestorePath(remotePath)
+		assert.Error(t, err, remotePath)
+	}
+}
+
+func TestSessionFileHandler_RejectsEscapes(t *testing.T) {
+	folder := t.TempDir()
+	downloaded := []string{}
+	handler := &SessionFileHandler{
+		folder:    folder,
+		sessionID: "session-1",
+		ctx:       context.Background(),
+		downloadFile: func(ctx context.Context, sessionID string, remotePath string, localPath string) error {
+			downloaded = append(downloaded, remotePath)
+			return nil
+		},
+	}
+
+	require.NoError(t, handler.DownloadFile("dev/users/user-1/file.txt", filepath.Join(folder, "file.txt")))
+
+	err := handler.DownloadFile("dev/users/user-1/file.txt", filepath.Join(folder, "..", "file.txt"))
+	assert.ErrorIs(t, err, system.ErrPathEscapesRoot)
+	err = handler.DownloadFile("dev/users/user-1/../user-2/file.txt", filepath.Join(folder, "file.txt"))
+	assert.ErrorIs(t, err, system.ErrPathEscapesRoot)
+
+	assert.Equal(t, []string{"dev/users/user-1/file.txt"}, downloaded)
+}
+
+func TestCheckResultPaths(t *testing.T) {
+	resultsFolder := t.TempDir()
+	handler := NewFileHandler("runner-1", system.ClientOptions{}, nil, []string{resultsFolder}, 0)
+
+	res := &types.RunnerTaskResponse{
+		InteractionID: "interaction-1",
+		Files:         []string{filepath.Join(resultsFolder, "images", "..", "image.png")},
+		LoraDir:       filepath.Join(resultsFolder, "lora"),
+	}
+	require.NoError(t, handler.checkResultPaths(res))
+	assert.Equal(t, []string{filepath.Join(resultsFolder, "image.png")}, res.Files)
+
+	for name, res := range map[string]*types.RunnerTaskResponse{
+		"file":           {InteractionID: "interaction-1", Files: []string{"/etc/passwd"}},
+		"climbing_file":  {InteractionID: "interaction-1", Files: []string{filepath.Join(resultsFolder, "..", "secret")}},
+		"lora_dir":       {InteractionID: "interaction-1", LoraDir: "/root/.ssh"},
+		"interaction_id": {InteractionID: "../../other-session"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, handler.checkResultPaths(res), system.ErrPathEscapesRoot)
+		})
+	}
+}
+
+func TestDownloadFolder_RejectsEscapingNames(t *testing.T) {
+	handler, bucket := newTestDirectFileHandler(t)
+	bucket["sessions/session-1/lora/adapter_config.json"] = []byte("{}")
+	bucket["sessions/session-1/lora/../../evil"] = []byte("evil")
+
+	parent := t.TempDir()
+	localFolder := filepath.Join(parent, "download")
+	err := handler.downloadFolder(context.Background(), "session-1", "sessions/session-1/lora", localFolder)
+	assert.ErrorIs(t, err, system.ErrPathEscapesRoot)
+
+	_, err = os.Stat(filepath.Join(parent, "..", "evil"))
+	assert.True(t, os.IsNotExist(err))
+	// removed so we don't think it was downloaded
+	_, err = os.Stat(localFolder)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExpandTarBuffer_RejectsEscapingEntries(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../evil", Mode: 0644, Size: 4, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("evil"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	parent := t.TempDir()
+	err = system.ExpandTarBuffer(&buf, filepath.Join(parent, "folder"))
+	assert.ErrorIs(t, err, system.ErrPathEscapesRoot)
+
+	_, err = os.Stat(filepath.Join(parent, "evil"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCheckResultPaths_DefaultFolders(t *testing.T) {
+	handler := NewFileHandler("runner-1", system.ClientOptions{}, nil, DefaultResultsFolders(), 0)
+
+	require.NoError(t, handler.checkResultPaths(&types.RunnerTaskResponse{
+		InteractionID: "interaction-1",
+		Files:         []string{"/tmp/helix/results/session-1/images/image.png"},
+		LoraDir:       "/tmp/helix/results/session-1/final_tensors",
+	}))
+
+	// the rest of the temp dir is not ours to upload
+	err := handler.checkResultPaths(&types.RunnerTaskResponse{
+		InteractionID: "interaction-1",
+		Files:         []string{filepath.Join(os.TempDir(), "other.png")},
+	})
+	assert.ErrorIs(t, err, system.ErrPathEscapesRoot)
+}
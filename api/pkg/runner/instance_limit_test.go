@@ -0,0 +1,145 @@
+package runner
+
+import (
+	"context"
+	"testing"
+
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/model"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// just enough of a model instance to count towards the limit
+type stubModelInstance struct {
+	ModelInstance
+	id     string
+	stale  bool
+	memory uint64
+	filter types.SessionFilter
+}
+
+func (m *stubModelInstance) ID() string {
+	return m.id
+}
+
+func (m *stubModelInstance) Stale() bool {
+	return m.stale
+}
+
+func (m *stubModelInstance) Model() model.Model {
+	return &sizedModel{memory: m.memory}
+}
+
+func (m *stubModelInstance) Filter() types.SessionFilter {
+	return m.filter
+}
+
+type sizedModel struct {
+	fakeAxolotlModel
+	memory uint64
+}
+
+func (m *sizedModel) GetMemoryRequirements(mode types.SessionMode) uint64 {
+	return m.memory
+}
+
+func (m *stubModelInstance) GetState() (*types.ModelInstanceState, error) {
+	return &types.ModelInstanceState{ID: m.id}, nil
+}
+
+func newInstanceLimitRunner(maxInstances int, instances ...*stubModelInstance) *Runner {
+	r := &Runner{
+		Options:              RunnerOptions{ID: "runner-1", MaxInstances: maxInstances},
+		activeModelInstances: xsync.NewMapOf[string, ModelInstance](),
+	}
+	for _, instance := range instances {
+		r.activeModelInstances.Store(instance.id, instance)
+	}
+	return r
+}
+
+func TestAtInstanceLimit(t *testing.T) {
+	busy := &stubModelInstance{id: "busy"}
+	stale := &stubModelInstance{id: "stale", stale: true}
+
+	// no limit
+	assert.False(t, newInstanceLimitRunner(0, busy, stale).atInstanceLimit())
+
+	assert.True(t, newInstanceLimitRunner(1, busy).atInstanceLimit())
+	assert.False(t, newInstanceLimitRunner(2, busy).atInstanceLimit())
+
+	// the stale instance can be killed to make room
+	assert.False(t, newInstanceLimitRunner(2, busy, stale).atInstanceLimit())
+	assert.True(t, newInstanceLimitRunner(1, busy, stale).atInstanceLimit())
+}
+
+func TestGetState_InstanceCount(t *testing.T) {
+	r := newInstanceLimitRunner(3, &stubModelInstance{id: "a"}, &stubModelInstance{id: "b", stale: true})
+
+	state, err := r.getState()
+	require.NoError(t, err)
+	assert.Equal(t, 2, state.InstanceCount)
+	assert.Equal(t, 3, state.MaxInstances)
+}
+
+func TestGetFreeMemory_Overcommitted(t *testing.T) {
+	r := newInstanceLimitRunner(0,
+		&stubModelInstance{id: "busy", memory: 16},
+		&stubModelInstance{id: "stale", memory: 16, stale: true},
+	)
+	r.Options.MemoryBytes = 24
+	r.lowestMemoryRequirement = 4
+
+	// 32 bytes in use out of 24 used to come out as -8 and wrap around to a
+	// huge amount when compared with what a model needs
+	assert.Equal(t, uint64(0), r.getFreeMemory())
+	assert.Equal(t, uint64(8), r.getHypotheticalFreeMemory())
+
+	state, err := r.getState()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), state.FreeMemory)
+
+	r.activeModelInstances.Store("other", &stubModelInstance{id: "other", memory: 16})
+	assert.Equal(t, uint64(0), r.getHypotheticalFreeMemory())
+
+	// nothing free so we don't even ask the api for a session
+	session, err := r.getNextGlobalSession(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, session)
+}
+
+func TestCordon(t *testing.T) {
+	r := newInstanceLimitRunner(0, &stubModelInstance{id: "busy", memory: 8})
+	r.Options.MemoryBytes = 24
+	r.Options.SchedulingDecisionBufferSize = 10
+	r.lowestMemoryRequirement = 4
+	assignments, err := newSessionAssignments("")
+	require.NoError(t, err)
+	r.sessionAssignments = assignments
+
+	r.Cordon()
+	assert.True(t, r.Cordoned())
+
+	state, err := r.getState()
+	require.NoError(t, err)
+	assert.True(t, state.Cordoned)
+
+	// there is room but we don't ask the api (which doesn't exist here)
+	session, err := r.getNextGlobalSession(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, session)
+	require.NoError(t, r.taskLoop(context.Background()))
+
+	// the running instance is left alone to finish
+	_, ok := r.activeModelInstances.Load("busy")
+	assert.True(t, ok)
+
+	r.Uncordon()
+	assert.False(t, r.Cordoned())
+	state, err = r.getState()
+	require.NoError(t, err)
+	assert.False(t, state.Cordoned)
+}
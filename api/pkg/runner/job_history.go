@@ -0,0 +1,73 @@
+package runner
+
+import (
+	"sync"
+	"time"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// the jobs a model instance has run, newest first - this is only the last
+// few for the dashboard, the api keeps every job it hears about in the store
+type jobHistory struct {
+	mu   sync.Mutex
+	size int
+	jobs []*types.SessionSummary
+	now  func() time.Time
+}
+
+func newJobHistory(size int) *jobHistory {
+	return &jobHistory{
+		size: size,
+		jobs: []*types.SessionSummary{},
+		now:  time.Now,
+	}
+}
+
+// the job is waiting for its result until finish is called
+func (h *jobHistory) add(summary *types.SessionSummary) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	summary.State = types.InteractionStateWaiting
+
+	// put the job at the start of the array
+	h.jobs = append([]*types.SessionSummary{summary}, h.jobs...)
+	if len(h.jobs) > h.size {
+		h.jobs = h.jobs[:h.size]
+	}
+}
+
+// record how the session's job ended once we have its result, error results
+// don't always have an interaction ID so we go by the session
+func (h *jobHistory) finish(res *types.RunnerTaskResponse) {
+	if res.Type != types.WorkerTaskResponseTypeResult {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for idx, job := range h.jobs {
+		if job.SessionID != res.SessionID || job.State != types.InteractionStateWaiting {
+			continue
+		}
+		// the summaries are handed out by list so we swap in a copy
+		// rather than change one that might be being sent to the api
+		finished := *job
+		finished.Completed = h.now()
+		finished.State = types.InteractionStateComplete
+		if res.Error != "" {
+			finished.State = types.InteractionStateError
+			finished.Error = res.Error
+		}
+		h.jobs[idx] = &finished
+		return
+	}
+}
+
+func (h *jobHistory) list() []*types.SessionSummary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*types.SessionSummary{}, h.jobs...)
+}
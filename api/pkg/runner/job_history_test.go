@@ -0,0 +1,49 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestJobHistory(t *testing.T) {
+	now := time.Unix(1000, 0)
+	history := newJobHistory(2)
+	history.now = func() time.Time { return now }
+
+	history.add(&types.SessionSummary{SessionID: "a", InteractionID: "a-1"})
+	history.add(&types.SessionSummary{SessionID: "b", InteractionID: "b-1"})
+
+	jobs := history.list()
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "b", jobs[0].SessionID)
+	assert.Equal(t, types.InteractionStateWaiting, jobs[0].State)
+
+	// streamed chunks don't finish the job
+	history.finish(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeStream, SessionID: "a"})
+	history.finish(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: "a"})
+	history.finish(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: "b", Error: "out of memory"})
+
+	// what was handed out before isn't changed under the caller
+	assert.Equal(t, types.InteractionStateWaiting, jobs[0].State)
+
+	jobs = history.list()
+	assert.Equal(t, types.InteractionStateError, jobs[0].State)
+	assert.Equal(t, "out of memory", jobs[0].Error)
+	assert.Equal(t, now, jobs[0].Completed)
+	assert.Equal(t, types.InteractionStateComplete, jobs[1].State)
+
+	// a second interaction on the same session is a new job
+	history.add(&types.SessionSummary{SessionID: "a", InteractionID: "a-2"})
+	history.finish(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: "a"})
+
+	jobs = history.list()
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "a-2", jobs[0].InteractionID)
+	assert.Equal(t, types.InteractionStateComplete, jobs[0].State)
+	assert.Equal(t, "b-1", jobs[1].InteractionID)
+}
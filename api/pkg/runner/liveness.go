@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// the longest we wait between checks on a model process - we check more often
+// than this if the liveness timeout is short
+const maxLivenessCheckInterval = 10 * time.Second
+
+// an io.Writer that remembers when a model process last wrote anything
+// so we can tell a process that is busy from one that is wedged
+type activityWriter struct {
+	last atomic.Int64
+}
+
+func newActivityWriter() *activityWriter {
+	w := &activityWriter{}
+	w.touch()
+	return w
+}
+
+func (w *activityWriter) Write(p []byte) (int, error) {
+	w.touch()
+	return len(p), nil
+}
+
+// also called when there is activity that doesn't come through stdout/stderr
+// e.g. a task response or a new session being assigned
+func (w *activityWriter) touch() {
+	w.last.Store(time.Now().UnixNano())
+}
+
+func (w *activityWriter) silence() time.Duration {
+	return time.Since(time.Unix(0, w.last.Load()))
+}
+
+// keeps an eye on a model process until ctx is done and calls onHung (once)
+// if it has been working on a session (isBusy) but has been silent for
+// longer than timeout - onHung is expected to kill the process
+// this is not the idle timeout, a process with nothing to do is left alone
+func watchLiveness(
+	ctx context.Context,
+	activity *activityWriter,
+	timeout time.Duration,
+	isBusy func() bool,
+	onHung func(silence time.Duration),
+) {
+	if timeout <= 0 {
+		return
+	}
+
+	interval := timeout / 10
+	if interval > maxLivenessCheckInterval {
+		interval = maxLivenessCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !isBusy() {
+				// don't count the time spent waiting for work
+				activity.touch()
+				continue
+			}
+			if silence := activity.silence(); silence > timeout {
+				onHung(silence)
+				return
+			}
+		}
+	}
+}
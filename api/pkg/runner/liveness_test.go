@@ -0,0 +1,76 @@
+package runner
+
+import (
+	"context"
+	"os/exec"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchLiveness_KillsSilentProcess(t *testing.T) {
+	// a process that is busy with a session but never writes anything
+	cmd := exec.Command("sleep", "60")
+	activity := newActivityWriter()
+	cmd.Stdout = activity
+	cmd.Stderr = activity
+	require.NoError(t, cmd.Start())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var silence atomic.Int64
+	go watchLiveness(ctx, activity, 200*time.Millisecond, func() bool { return true }, func(s time.Duration) {
+		silence.Store(int64(s))
+		_ = cmd.Process.Kill()
+	})
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- cmd.Wait() }()
+
+	select {
+	case err := <-waitErr:
+		assert.Error(t, err)
+		assert.Greater(t, time.Duration(silence.Load()), 200*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		_ = cmd.Process.Kill()
+		t.Fatal("hung process was not killed")
+	}
+}
+
+func TestWatchLiveness_LeavesChattyProcess(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "for i in 1 2 3 4 5 6 7 8 9 10; do echo working; sleep 0.05; done")
+	activity := newActivityWriter()
+	cmd.Stdout = activity
+	require.NoError(t, cmd.Start())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var hung atomic.Bool
+	go watchLiveness(ctx, activity, 300*time.Millisecond, func() bool { return true }, func(time.Duration) {
+		hung.Store(true)
+		_ = cmd.Process.Kill()
+	})
+
+	require.NoError(t, cmd.Wait())
+	assert.False(t, hung.Load())
+}
+
+func TestWatchLiveness_IgnoresIdleProcess(t *testing.T) {
+	activity := newActivityWriter()
+	activity.last.Store(time.Now().Add(-time.Hour).UnixNano())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// nothing is assigned so the silence doesn't matter
+	var hung atomic.Bool
+	watchLiveness(ctx, activity, 50*time.Millisecond, func() bool { return false }, func(time.Duration) {
+		hung.Store(true)
+	})
+	assert.False(t, hung.Load())
+}
@@ -17,7 +17,9 @@ type ModelInstance interface {
 	Start(session *types.Session) error
 
 	NextSession() *types.Session
-	SetNextSession(session *types.Session)
+	// returns the next session and clears it in one go so it can only be
+	// handed to the process once
+	TakeNextSession() *types.Session
 
 	QueueSession(session *types.Session, isInitialSession bool)
 	GetQueuedSession() *types.Session
@@ -4,8 +4,8 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -26,7 +26,6 @@ import (
 
 	"github.com/jmorganca/ollama/api"
 	"github.com/jmorganca/ollama/format"
-	openai "github.com/lukemarsden/go-openai2"
 	"github.com/rs/zerolog/log"
 )
 
@@ -59,9 +58,12 @@ func NewOllamaModelInstance(ctx context.Context, cfg *ModelInstanceConfig) (*Oll
 			LoraDir:   cfg.InitialSession.LoraDir,
 			Type:      cfg.InitialSession.Type,
 		},
-		runnerOptions: cfg.RunnerOptions,
-		jobHistory:    []*types.SessionSummary{},
-		lastActivity:  time.Now(),
+		runnerOptions:  cfg.RunnerOptions,
+		jobHistory:     newJobHistory(cfg.RunnerOptions.JobHistoryBufferSize),
+		lastActivity:   time.Now(),
+		activeSessions: newActiveSessions(),
+		timings:        newSessionTimings(),
+		prompts:        newSessionPrompts(cfg.RunnerOptions),
 	}
 
 	return i, nil
@@ -79,9 +81,6 @@ type OllamaModelInstance struct {
 
 	workCh chan *types.Session
 
-	// client is the model client
-	client *openai.Client
-
 	ollamaClient *ollamaClient
 
 	// Streaming response handler
@@ -102,16 +101,26 @@ type OllamaModelInstance struct {
 	// trying to start this model's python process
 	initialSession *types.Session
 
-	// the session currently running on this model
-	currentSession *types.Session
+	// the sessions currently running on this model - inference sessions
+	// can run side by side if MaxConcurrentSessions allows it
+	activeSessions *activeSessions
+
+	// guards lastActivity which is updated by the work
+	// loop and the sessions it runs while the runner reads the state
+	mu sync.Mutex
 
 	// the timestamp of when this model instance either completed a job
 	// or a new job was pulled and allocated
 	// we use this timestamp to cleanup non-active model instances
 	lastActivity time.Time
 
-	// a history of the session IDs
-	jobHistory []*types.SessionSummary
+	// the last few jobs this instance has run and how they ended
+	jobHistory *jobHistory
+
+	// when the model got to each stage of the sessions it is running
+	timings *sessionTimings
+	// the messages each session sent to ollama if we're recording prompts
+	prompts *sessionPrompts
 }
 
 func (i *OllamaModelInstance) Start(session *types.Session) error {
@@ -128,11 +137,6 @@ func (i *OllamaModelInstance) Start(session *types.Session) error {
 		return fmt.Errorf("error getting free port: %s", err.Error())
 	}
 
-	config := openai.DefaultConfig("ollama")
-	config.BaseURL = fmt.Sprintf("http://localhost:%d/v1", port)
-
-	i.client = openai.NewClientWithConfig(config)
-
 	cmd := exec.CommandContext(i.ctx, ollamaPath, "serve")
 	// Getting base env (HOME, etc)
 	cmd.Env = append(cmd.Env,
@@ -150,9 +154,9 @@ func (i *OllamaModelInstance) Start(session *types.Session) error {
 
 	cmd.Stdout = os.Stdout
 
-	// this buffer is so we can keep the last 10kb of stderr so if
+	// this buffer is so we can keep the end of stderr so if
 	// there is an error we can send it to the api
-	stderrBuf := system.NewLimitedBuffer(1024 * 10)
+	stderrBuf := newStderrBuffer(i.runnerOptions)
 
 	stderrWriters := []io.Writer{os.Stderr, stderrBuf}
 
@@ -182,8 +186,8 @@ func (i *OllamaModelInstance) Start(session *types.Session) error {
 			log.Error().Msgf("Ollama model instance exited with error: %s", err.Error())
 
 			errMsg := string(stderrBuf.Bytes())
-			if i.currentSession != nil {
-				i.errorSession(i.currentSession, fmt.Errorf("%s from cmd - %s", err.Error(), errMsg))
+			for _, session := range i.activeSessions.list() {
+				i.errorSession(session, &processExitError{Err: err, Stderr: errMsg})
 			}
 
 			return
@@ -237,7 +241,7 @@ WAIT:
 			err = i.ollamaClient.Pull(i.ctx, &api.PullRequest{
 				Model: modelName,
 			}, func(progress api.ProgressResponse) error {
-				log.Info().Msgf("🟢 Pulling model %s (%d/%d)", modelName, progress.Completed, progress.Total)
+				log.Debug().Msgf("🟢 Pulling model %s (%d/%d)", modelName, progress.Completed, progress.Total)
 				return nil
 			})
 
@@ -255,6 +259,9 @@ WAIT:
 		return fmt.Errorf("error pulling model: %s", err.Error())
 	}
 
+	maxSessions := i.maxConcurrentSessions()
+	slots := make(chan struct{}, maxSessions)
+
 	go func() {
 		for {
 			select {
@@ -262,25 +269,31 @@ WAIT:
 				log.Info().Msgf("🟢 stopping Ollama model instance")
 				return
 			case session := <-i.workCh:
-				log.Info().Str("session_id", session.ID).Msg("🟢 processing interaction")
+				// wait for one of the running sessions to finish if we are full
+				select {
+				case <-i.ctx.Done():
+					return
+				case slots <- struct{}{}:
+				}
 
-				i.currentSession = session
-				i.lastActivity = time.Now()
+				i.activeSessions.add(session)
+				i.touch()
 
-				err = i.processInteraction(session)
-				if err != nil {
-					log.Error().
-						Str("session_id", session.ID).
-						Err(err).
-						Msg("error processing interaction")
-				} else {
-					log.Info().
-						Str("session_id", session.ID).
-						Msg("🟢 interaction processed")
+				go func(session *types.Session) {
+					defer func() {
+						i.activeSessions.remove(session.ID)
+						<-slots
+					}()
+					i.runSession(session)
+				}(session)
+			default:
+				// don't take work from the api that we can't start yet
+				// another runner might be able to run it straight away
+				if i.activeSessions.count() >= maxSessions {
+					time.Sleep(300 * time.Millisecond)
+					continue
 				}
 
-				i.currentSession = nil
-			default:
 				// Get next session
 				session, err := i.getNextSession()
 				if err != nil {
@@ -305,6 +318,36 @@ WAIT:
 	return nil
 }
 
+// finetuning is never run side by side, only inference can be
+func (i *OllamaModelInstance) maxConcurrentSessions() int {
+	maxSessions := i.runnerOptions.Config.Runtimes.Ollama.MaxConcurrentSessions
+	if maxSessions < 1 || i.filter.Mode != types.SessionModeInference {
+		return 1
+	}
+	return maxSessions
+}
+
+func (i *OllamaModelInstance) runSession(session *types.Session) {
+	requestID := getSessionRequestID(session)
+	log.Info().Str("session_id", session.ID).Str("request_id", requestID).Msg("🟢 processing interaction")
+	i.timings.start(session.ID)
+
+	err := i.processInteraction(session)
+	if err != nil {
+		log.Error().
+			Str("session_id", session.ID).
+			Str("request_id", requestID).
+			Err(err).
+			Msg("error processing interaction")
+		return
+	}
+
+	log.Info().
+		Str("session_id", session.ID).
+		Str("request_id", requestID).
+		Msg("🟢 interaction processed")
+}
+
 func (i *OllamaModelInstance) Stop() error {
 	if i.currentCommand == nil {
 		return fmt.Errorf("no Ollama process to stop")
@@ -327,9 +370,18 @@ func (i *OllamaModelInstance) Filter() types.SessionFilter {
 }
 
 func (i *OllamaModelInstance) Stale() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	return time.Since(i.lastActivity) > i.runnerOptions.Config.Runtimes.Ollama.InstanceTTL
 }
 
+// mark the instance as active so it doesn't get cleaned up
+func (i *OllamaModelInstance) touch() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.lastActivity = time.Now()
+}
+
 func (i *OllamaModelInstance) Model() model.Model {
 	return i.model
 }
@@ -339,22 +391,28 @@ func (i *OllamaModelInstance) GetState() (*types.ModelInstanceState, error) {
 		return nil, fmt.Errorf("no initial session")
 	}
 
-	var (
-		sessionSummary *types.SessionSummary
-		err            error
-	)
+	var sessionSummary *types.SessionSummary
+	activeSummaries := []*types.SessionSummary{}
 
-	if i.currentSession != nil {
-		sessionSummary, err = data.GetSessionSummary(i.currentSession)
+	for _, session := range i.activeSessions.list() {
+		summary, err := data.GetSessionSummary(session)
 		if err != nil {
 			return nil, err
 		}
+		if sessionSummary == nil {
+			sessionSummary = summary
+		}
+		activeSummaries = append(activeSummaries, summary)
 	}
 
+	i.mu.Lock()
+	lastActivity := i.lastActivity
+	i.mu.Unlock()
+
 	stale := false
-	if i.lastActivity.IsZero() {
+	if lastActivity.IsZero() {
 		stale = false
-	} else if time.Since(i.lastActivity) > i.runnerOptions.Config.Runtimes.Ollama.InstanceTTL {
+	} else if time.Since(lastActivity) > i.runnerOptions.Config.Runtimes.Ollama.InstanceTTL {
 		stale = true
 	}
 
@@ -365,9 +423,10 @@ func (i *OllamaModelInstance) GetState() (*types.ModelInstanceState, error) {
 		LoraDir:          i.initialSession.LoraDir,
 		InitialSessionID: i.initialSession.ID,
 		CurrentSession:   sessionSummary,
-		JobHistory:       i.jobHistory,
+		ActiveSessions:   activeSummaries,
+		JobHistory:       i.jobHistory.list(),
 		Timeout:          int(i.runnerOptions.Config.Runtimes.Ollama.InstanceTTL.Seconds()),
-		LastActivity:     int(i.lastActivity.Unix()),
+		LastActivity:     int(lastActivity.Unix()),
 		Stale:            stale,
 		MemoryUsage:      i.model.GetMemoryRequirements(i.initialSession.Mode),
 	}, nil
@@ -383,8 +442,9 @@ func (i *OllamaModelInstance) AssignSessionTask(ctx context.Context, session *ty
 	return &types.RunnerTask{}, nil
 }
 
-func (i *OllamaModelInstance) SetNextSession(session *types.Session) {
+func (i *OllamaModelInstance) TakeNextSession() *types.Session {
 	// No-op for ollama instance, only used in runner server
+	return nil
 }
 
 func (i *OllamaModelInstance) QueueSession(session *types.Session, isInitialSession bool) {
@@ -401,7 +461,7 @@ func (i *OllamaModelInstance) QueueSession(session *types.Session, isInitialSess
 }
 
 func (i *OllamaModelInstance) processInteraction(session *types.Session) error {
-	var messages []openai.ChatCompletionMessage
+	var messages []api.Message
 
 	// Adjust length
 	var interactions []*types.Interaction
@@ -418,8 +478,8 @@ func (i *OllamaModelInstance) processInteraction(session *types.Session) error {
 	}
 
 	if session.Metadata.SystemPrompt != "" {
-		messages = append(messages, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleUser,
+		messages = append(messages, api.Message{
+			Role:    "user",
 			Content: session.Metadata.SystemPrompt,
 		})
 	}
@@ -427,75 +487,151 @@ func (i *OllamaModelInstance) processInteraction(session *types.Session) error {
 	for _, interaction := range interactions {
 		switch interaction.Creator {
 		case types.CreatorTypeUser:
-			messages = append(messages, openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleUser,
+			messages = append(messages, api.Message{
+				Role:    "user",
 				Content: interaction.Message,
+				Images:  i.loadModelImages(session.ID, interaction),
 			})
 		case types.CreatorTypeSystem:
-			messages = append(messages, openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleAssistant,
+			messages = append(messages, api.Message{
+				Role:    "assistant",
 				Content: interaction.Message,
 			})
 		}
 	}
 
-	// Adding current message
-	stream, err := i.client.CreateChatCompletionStream(context.Background(), openai.ChatCompletionRequest{
+	i.recordPrompt(session.ID, messages)
+
+	// we use ollama's own chat API rather than the OpenAI compatible one
+	// as only this tells us how many tokens were used
+	var buf string
+	var usage *types.Usage
+	err := i.ollamaClient.Chat(i.ctx, &api.ChatRequest{
 		Model:    string(session.ModelName),
-		Stream:   true,
 		Messages: messages,
+		Options:  getOllamaOptions(session.Metadata.Sampling),
+	}, func(response api.ChatResponse) error {
+		if response.Message.Content != "" {
+			buf += response.Message.Content
+			i.responseProcessor(session, response.Message.Content, false, nil)
+		}
+		if response.Done {
+			usage = &types.Usage{
+				PromptTokens:     response.PromptEvalCount,
+				CompletionTokens: response.EvalCount,
+				TotalTokens:      response.PromptEvalCount + response.EvalCount,
+			}
+		}
+		return nil
 	})
 	if err != nil {
+		log.Error().Err(err).Msg("stream error")
+		i.errorSession(session, err)
 		return fmt.Errorf("failed to get response from inference API: %w", err)
 	}
 
-	defer stream.Close()
+	log.Info().Msg("stream finished")
+	// Signal the end of the stream
+	i.emitStreamDone(session)
+	// Send the last message containing full output
+	i.responseProcessor(session, buf, true, usage)
+	return nil
+}
 
-	var buf string
+// the sampling params that are set as ollama options, anything left out is
+// up to the model
+func getOllamaOptions(sampling types.SamplingParams) map[string]interface{} {
+	options := map[string]interface{}{}
+	if sampling.Temperature != nil {
+		options["temperature"] = *sampling.Temperature
+	}
+	if sampling.TopP != nil {
+		options["top_p"] = *sampling.TopP
+	}
+	if sampling.MaxTokens != 0 {
+		options["num_predict"] = sampling.MaxTokens
+	}
+	if len(sampling.Stop) > 0 {
+		options["stop"] = sampling.Stop
+	}
+	return options
+}
 
-	for {
-		response, err := stream.Recv()
-		if errors.Is(err, io.EOF) {
-			log.Info().Msg("stream finished")
-			// Signal the end of the stream
-			i.emitStreamDone(session)
-			// Send the last message containing full output
-			i.responseProcessor(session, buf, true)
-			return nil
+// the images the model can load itself, asset pointers like
+// file-service://file-28uHss2LgJ8HUEEVAnXa70Tg refer to files in the
+// client's own file service which we can't get at so they are left out
+func getModelImages(sessionID string, interaction *types.Interaction) []string {
+	images := []string{}
+	for _, image := range interaction.Images {
+		if strings.HasPrefix(image, "data:") || strings.HasPrefix(image, "http://") || strings.HasPrefix(image, "https://") {
+			images = append(images, image)
+			continue
 		}
+		log.Warn().
+			Str("session_id", sessionID).
+			Str("interaction_id", interaction.ID).
+			Str("image", image).
+			Msg("leaving out an image the model can't load")
+	}
+	return images
+}
 
+// the biggest image we download for a vision model
+const maxModelImageSize = 20 * format.MegaByte
+
+// ollama wants the image itself rather than a URL to it
+func (i *OllamaModelInstance) loadModelImages(sessionID string, interaction *types.Interaction) []api.ImageData {
+	var images []api.ImageData
+	for _, image := range getModelImages(sessionID, interaction) {
+		imageData, err := loadModelImage(i.ctx, image)
 		if err != nil {
-			log.Error().Err(err).Msg("stream error")
-			i.errorSession(session, err)
-			return err
+			log.Warn().Err(err).
+				Str("session_id", sessionID).
+				Str("interaction_id", interaction.ID).
+				Msg("leaving out an image we couldn't load")
+			continue
 		}
+		images = append(images, imageData)
+	}
+	return images
+}
 
-		buf += response.Choices[0].Delta.Content
+func loadModelImage(ctx context.Context, image string) (api.ImageData, error) {
+	if strings.HasPrefix(image, "data:") {
+		// data:image/png;base64,iVBORw0KGgo=
+		_, encoded, ok := strings.Cut(image, ",")
+		if !ok {
+			return nil, fmt.Errorf("invalid data URL")
+		}
+		return base64.StdEncoding.DecodeString(encoded)
+	}
 
-		i.responseProcessor(session, response.Choices[0].Delta.Content, false)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, image, nil)
+	if err != nil {
+		return nil, err
 	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d downloading %s", resp.StatusCode, image)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxModelImageSize))
 }
 
-func (i *OllamaModelInstance) responseProcessor(session *types.Session, content string, done bool) {
+func (i *OllamaModelInstance) responseProcessor(session *types.Session, content string, done bool, usage *types.Usage) {
 	if session == nil {
 		log.Error().Msgf("no current session")
 		return
 	}
 
-	var err error
-
-	systemInteraction, err := data.GetSystemInteraction(session)
-	if err != nil {
-		log.Error().Msgf("error getting system interaction: %s", err.Error())
-		return
-	}
-
 	resp := &types.RunnerTaskResponse{
-		SessionID:     session.ID,
-		InteractionID: systemInteraction.ID,
-		Owner:         session.Owner,
-		Done:          done,
-		Message:       content,
+		SessionID: session.ID,
+		Done:      done,
+		Message:   content,
+		Usage:     usage,
 	}
 
 	if done {
@@ -504,27 +640,61 @@ func (i *OllamaModelInstance) responseProcessor(session *types.Session, content
 		resp.Type = types.WorkerTaskResponseTypeStream
 	}
 
-	err = i.responseHandler(resp)
-	if err != nil {
-		log.Error().Msgf("error writing event: %s", err.Error())
-		return
-	}
+	i.taskResponseHandler(resp)
 }
 
 func (i *OllamaModelInstance) emitStreamDone(session *types.Session) {
-	err := i.responseHandler(&types.RunnerTaskResponse{
+	i.taskResponseHandler(&types.RunnerTaskResponse{
 		Type:      types.WorkerTaskResponseTypeStream,
 		SessionID: session.ID,
-		Owner:     session.Owner,
 		Message:   "",
 		Done:      true,
 	})
+}
+
+// several sessions can be running at once so we find the one this
+// response is for by its ID and fill in the details from it
+func (i *OllamaModelInstance) taskResponseHandler(taskResponse *types.RunnerTaskResponse) {
+	session := i.activeSessions.get(taskResponse.SessionID)
+	if session == nil {
+		log.Error().Msgf("session is not running on this instance: %s", taskResponse.SessionID)
+		return
+	}
+
+	systemInteraction, err := data.GetSystemInteraction(session)
+	if err != nil {
+		log.Error().Msgf("error getting system interaction: %s", err.Error())
+		return
+	}
+
+	taskResponse.InteractionID = systemInteraction.ID
+	taskResponse.Owner = session.Owner
+	i.touch()
+	i.timings.stamp(taskResponse)
+	i.prompts.attach(taskResponse)
+	i.jobHistory.finish(taskResponse)
+
+	err = i.responseHandler(taskResponse)
 	if err != nil {
 		log.Error().Msgf("error writing event: %s", err.Error())
 		return
 	}
 }
 
+// there's no single prompt string with ollama, it's given the chat
+// messages and applies the model's template itself
+func (i *OllamaModelInstance) recordPrompt(sessionID string, messages []api.Message) {
+	if i.prompts == nil {
+		return
+	}
+	prompt, err := json.Marshal(messages)
+	if err != nil {
+		log.Error().Str("session_id", sessionID).Msgf("error encoding prompt: %s", err.Error())
+		return
+	}
+	i.prompts.record(sessionID, string(prompt))
+}
+
 func (i *OllamaModelInstance) GetQueuedSession() *types.Session {
 	return nil
 }
@@ -539,22 +709,23 @@ func (i *OllamaModelInstance) addJobToHistory(session *types.Session) error {
 		return err
 	}
 
-	// put the job at the start of the array
-	i.jobHistory = append([]*types.SessionSummary{summary}, i.jobHistory...)
-	if len(i.jobHistory) > i.runnerOptions.JobHistoryBufferSize {
-		i.jobHistory = i.jobHistory[:len(i.jobHistory)-1]
-	}
-
+	i.jobHistory.add(summary)
 	return nil
 }
 
 func (i *OllamaModelInstance) errorSession(session *types.Session, err error) {
-	apiUpdateErr := i.responseHandler(&types.RunnerTaskResponse{
-		Type:      types.WorkerTaskResponseTypeResult,
-		SessionID: session.ID,
-		Owner:     session.Owner,
-		Error:     err.Error(),
-	})
+	res := &types.RunnerTaskResponse{
+		Type:        types.WorkerTaskResponseTypeResult,
+		SessionID:   session.ID,
+		Owner:       session.Owner,
+		Error:       err.Error(),
+		ErrorDetail: getErrorDetail(err),
+		ErrorCode:   getErrorCode(err),
+	}
+	i.timings.stamp(res)
+	i.prompts.attach(res)
+	i.jobHistory.finish(res)
+	apiUpdateErr := i.responseHandler(res)
 
 	if apiUpdateErr != nil {
 		log.Error().Msgf("Error reporting error to api: %v\n", apiUpdateErr.Error())
@@ -599,6 +770,17 @@ func (c *ollamaClient) Pull(ctx context.Context, req *api.PullRequest, fn api.Pu
 	})
 }
 
+func (c *ollamaClient) Chat(ctx context.Context, req *api.ChatRequest, fn api.ChatResponseFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/chat", req, func(bts []byte) error {
+		var resp api.ChatResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
 const maxBufferSize = 512 * format.KiloByte
 
 func (c *ollamaClient) stream(ctx context.Context, method, path string, data any, fn func([]byte) error) error {
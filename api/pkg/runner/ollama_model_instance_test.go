@@ -0,0 +1,152 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jmorganca/ollama/api"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/config"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newTestOllamaSession(id string) *types.Session {
+	return &types.Session{
+		ID:    id,
+		Owner: "owner-" + id,
+		Interactions: []*types.Interaction{
+			{ID: id + "-user", Creator: types.CreatorTypeUser},
+			{ID: id + "-system", Creator: types.CreatorTypeSystem},
+		},
+	}
+}
+
+func TestOllamaModelInstance_RoutesResponsesToSessions(t *testing.T) {
+	responses := []*types.RunnerTaskResponse{}
+	instance := &OllamaModelInstance{
+		activeSessions: newActiveSessions(),
+		timings:        newSessionTimings(),
+		jobHistory:     newJobHistory(5),
+		responseHandler: func(res *types.RunnerTaskResponse) error {
+			responses = append(responses, res)
+			return nil
+		},
+	}
+
+	instance.activeSessions.add(newTestOllamaSession("a"))
+	instance.activeSessions.add(newTestOllamaSession("b"))
+
+	instance.taskResponseHandler(&types.RunnerTaskResponse{SessionID: "b", Message: "from b"})
+	instance.taskResponseHandler(&types.RunnerTaskResponse{SessionID: "a", Message: "from a"})
+	// not running here so it's dropped
+	instance.taskResponseHandler(&types.RunnerTaskResponse{SessionID: "c", Message: "from c"})
+
+	require.Len(t, responses, 2)
+	assert.Equal(t, "b-system", responses[0].InteractionID)
+	assert.Equal(t, "owner-b", responses[0].Owner)
+	assert.Equal(t, "a-system", responses[1].InteractionID)
+	assert.Equal(t, "owner-a", responses[1].Owner)
+
+	instance.activeSessions.remove("a")
+	instance.taskResponseHandler(&types.RunnerTaskResponse{SessionID: "a", Message: "late"})
+	assert.Len(t, responses, 2)
+	assert.Equal(t, []*types.Session{instance.activeSessions.get("b")}, instance.activeSessions.list())
+}
+
+func TestOllamaModelInstance_MaxConcurrentSessions(t *testing.T) {
+	runnerConfig := &config.RunnerConfig{}
+	runnerConfig.Runtimes.Ollama.MaxConcurrentSessions = 4
+
+	instance := &OllamaModelInstance{
+		runnerOptions: RunnerOptions{Config: runnerConfig},
+		filter:        types.SessionFilter{Mode: types.SessionModeInference},
+	}
+	assert.Equal(t, 4, instance.maxConcurrentSessions())
+
+	// fine tuning is always one at a time
+	instance.filter.Mode = types.SessionModeFinetune
+	assert.Equal(t, 1, instance.maxConcurrentSessions())
+
+	instance.filter.Mode = types.SessionModeInference
+	runnerConfig.Runtimes.Ollama.MaxConcurrentSessions = 0
+	assert.Equal(t, 1, instance.maxConcurrentSessions())
+}
+
+func TestGetModelImages(t *testing.T) {
+	interaction := &types.Interaction{
+		ID: "user-1",
+		Images: []string{
+			"file-service://file-28uHss2LgJ8HUEEVAnXa70Tg",
+			"https://example.com/cat.png",
+			"data:image/png;base64,iVBORw0KGgo=",
+		},
+	}
+	assert.Equal(t, []string{"https://example.com/cat.png", "data:image/png;base64,iVBORw0KGgo="}, getModelImages("session-1", interaction))
+
+	// nothing the model can load means a plain text message
+	interaction.Images = []string{"file-service://file-28uHss2LgJ8HUEEVAnXa70Tg"}
+	assert.Empty(t, getModelImages("session-1", interaction))
+}
+
+func TestOllamaModelInstance_ProcessInteractionUsage(t *testing.T) {
+	var req api.ChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/chat", r.URL.Path)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"hello"},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":" there"},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":12,"eval_count":34}`)
+	}))
+	defer server.Close()
+
+	client, err := newOllamaClient(strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+
+	var results []*types.RunnerTaskResponse
+	instance := &OllamaModelInstance{
+		ctx:            context.Background(),
+		ollamaClient:   client,
+		activeSessions: newActiveSessions(),
+		timings:        newSessionTimings(),
+		jobHistory:     newJobHistory(5),
+		responseHandler: func(res *types.RunnerTaskResponse) error {
+			if res.Type == types.WorkerTaskResponseTypeResult {
+				results = append(results, res)
+			}
+			return nil
+		},
+	}
+
+	session := newTestOllamaSession("a")
+	session.Interactions[0].Message = "hi"
+	instance.activeSessions.add(session)
+
+	require.NoError(t, instance.processInteraction(session))
+
+	require.Len(t, req.Messages, 2)
+	assert.Equal(t, "hi", req.Messages[0].Content)
+
+	require.Len(t, results, 1)
+	assert.Equal(t, "hello there", results[0].Message)
+	assert.Equal(t, &types.Usage{PromptTokens: 12, CompletionTokens: 34, TotalTokens: 46}, results[0].Usage)
+}
+
+func TestGetOllamaOptions(t *testing.T) {
+	assert.Empty(t, getOllamaOptions(types.SamplingParams{}))
+
+	// zero is a temperature the user can ask for
+	temperature := float32(0)
+	assert.Equal(t, map[string]interface{}{
+		"temperature": float32(0),
+		"num_predict": 100,
+		"stop":        []string{"\n"},
+	}, getOllamaOptions(types.SamplingParams{Temperature: &temperature, MaxTokens: 100, Stop: []string{"\n"}}))
+}
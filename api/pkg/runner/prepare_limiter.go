@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"context"
+	"sync"
+)
+
+// caps how many sessions download their files at once across all of the
+// runner's model instances so a burst of sessions with big datasets doesn't
+// swamp the disk and network - the rest wait until a slot frees up
+type prepareLimiter struct {
+	// nil when there is no limit
+	slots chan struct{}
+
+	mu      sync.Mutex
+	waiting int
+	active  int
+}
+
+// zero (or less) means no limit
+func newPrepareLimiter(max int) *prepareLimiter {
+	limiter := &prepareLimiter{}
+	if max > 0 {
+		limiter.slots = make(chan struct{}, max)
+	}
+	return limiter
+}
+
+// wait for a slot to prepare a session in, the release func must be called
+// once the session's files are downloaded
+func (l *prepareLimiter) acquire(ctx context.Context) (func(), error) {
+	l.mu.Lock()
+	l.waiting++
+	l.mu.Unlock()
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			l.mu.Lock()
+			l.waiting--
+			l.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+
+	l.mu.Lock()
+	l.waiting--
+	l.active++
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			l.active--
+			l.mu.Unlock()
+			if l.slots != nil {
+				<-l.slots
+			}
+		})
+	}, nil
+}
+
+// how many sessions are waiting to prepare and how many are preparing - a
+// runner that was built without a limiter has neither
+func (l *prepareLimiter) depth() (int, int) {
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waiting, l.active
+}
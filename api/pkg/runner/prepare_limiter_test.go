@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepareLimiter(t *testing.T) {
+	limiter := newPrepareLimiter(1)
+
+	release, err := limiter.acquire(context.Background())
+	require.NoError(t, err)
+
+	acquired := make(chan func())
+	go func() {
+		next, err := limiter.acquire(context.Background())
+		assert.NoError(t, err)
+		acquired <- next
+	}()
+
+	assert.Eventually(t, func() bool {
+		waiting, active := limiter.depth()
+		return waiting == 1 && active == 1
+	}, time.Second, time.Millisecond)
+
+	select {
+	case <-acquired:
+		t.Fatal("second prepare started before the first finished")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	release()
+	// releasing twice doesn't free a slot that isn't ours
+	release()
+
+	next := <-acquired
+	waiting, active := limiter.depth()
+	assert.Equal(t, 0, waiting)
+	assert.Equal(t, 1, active)
+
+	// giving up on the wait takes the session out of the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = limiter.acquire(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	waiting, _ = limiter.depth()
+	assert.Equal(t, 0, waiting)
+
+	next()
+	_, active = limiter.depth()
+	assert.Equal(t, 0, active)
+}
+
+func TestPrepareLimiter_NoLimit(t *testing.T) {
+	limiter := newPrepareLimiter(0)
+
+	for i := 0; i < 10; i++ {
+		_, err := limiter.acquire(context.Background())
+		require.NoError(t, err)
+	}
+	waiting, active := limiter.depth()
+	assert.Equal(t, 0, waiting)
+	assert.Equal(t, 10, active)
+}
+
+func TestPrepareLimiter_NilDepth(t *testing.T) {
+	var limiter *prepareLimiter
+	waiting, active := limiter.depth()
+	assert.Equal(t, 0, waiting)
+	assert.Equal(t, 0, active)
+}
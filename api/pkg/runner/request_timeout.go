@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// what a transfer's context is cancelled with when it stops moving
+var errRequestStalled = errors.New("request stalled")
+
+func requestTimeout(options RunnerOptions) time.Duration {
+	return time.Duration(options.RequestTimeoutSeconds) * time.Second
+}
+
+// a context for one request to the api that is cancelled along with ctx (so
+// stopping a model instance stops its requests) or once it has taken longer
+// than timeout - zero means only ctx cancels it
+func requestContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// uploading or downloading a big file can rightly take a lot longer than
+// the request timeout so rather than a deadline a transfer is cancelled
+// once no bytes have moved either way for timeout
+type transferTimeout struct {
+	timeout time.Duration
+	timer   *time.Timer
+	ctx     context.Context
+}
+
+// cancel must be called once the transfer is done with
+func newTransferTimeout(ctx context.Context, timeout time.Duration) (*transferTimeout, context.CancelFunc) {
+	transferCtx, cancel := context.WithCancelCause(ctx)
+	transfer := &transferTimeout{
+		timeout: timeout,
+		ctx:     transferCtx,
+	}
+	if timeout <= 0 {
+		return transfer, func() { cancel(nil) }
+	}
+	transfer.timer = time.AfterFunc(timeout, func() {
+		cancel(errRequestStalled)
+	})
+	return transfer, func() {
+		transfer.timer.Stop()
+		cancel(nil)
+	}
+}
+
+func (t *transferTimeout) touch() {
+	if t.timer != nil {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+// every read from r (a request or response body) puts off the timeout
+func (t *transferTimeout) reader(r io.Reader) io.Reader {
+	return &transferReader{reader: r, transfer: t}
+}
+
+// the http client hands back the cause of the cancellation, add how long
+// we waited to it
+func (t *transferTimeout) wrapError(err error) error {
+	if errors.Is(err, errRequestStalled) {
+		return fmt.Errorf("nothing transferred for %s: %w", t.timeout, err)
+	}
+	return err
+}
+
+type transferReader struct {
+	reader   io.Reader
+	transfer *transferTimeout
+}
+
+func (r *transferReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.transfer.touch()
+	}
+	return n, err
+}
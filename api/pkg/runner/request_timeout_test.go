@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/system"
+)
+
+// an api that sends the start of a file and then hangs until the runner
+// gives up on the request
+func newStallingServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	requests := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/presign/") {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		requests.Add(1)
+		w.Header().Set("Content-Length", "100")
+		_, _ = w.Write([]byte("hello"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestDownloadFile_StalledServer(t *testing.T) {
+	server, requests := newStallingServer(t)
+	handler := NewFileHandler("runner-1", system.ClientOptions{Host: server.URL}, nil, []string{os.TempDir()}, 100*time.Millisecond)
+
+	localPath := filepath.Join(t.TempDir(), "file.txt")
+	err := handler.downloadFile(context.Background(), "session-1", "file.txt", localPath)
+	assert.ErrorIs(t, err, errRequestStalled)
+	assert.Equal(t, int32(downloadFileAttempts), requests.Load())
+
+	_, err = os.Stat(localPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDownloadFile_SlowServer(t *testing.T) {
+	content := []byte("a file that takes a while")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/presign/") {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		w.Header().Set("Content-Length", "25")
+		// takes longer than the timeout altogether but never stops moving
+		for i := 0; i < len(content); i += 5 {
+			time.Sleep(30 * time.Millisecond)
+			_, _ = w.Write(content[i : i+5])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	handler := NewFileHandler("runner-1", system.ClientOptions{Host: server.URL}, nil, []string{os.TempDir()}, 100*time.Millisecond)
+
+	localPath := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, handler.downloadFile(context.Background(), "session-1", "file.txt", localPath))
+
+	downloaded, err := os.ReadFile(localPath)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+}
+
+func TestDownloadFile_CancelledWithInstance(t *testing.T) {
+	server, requests := newStallingServer(t)
+	// no timeout so only stopping the instance gets us out
+	handler := NewFileHandler("runner-1", system.ClientOptions{Host: server.URL}, nil, []string{os.TempDir()}, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err := handler.downloadFile(ctx, "session-1", "file.txt", filepath.Join(t.TempDir(), "file.txt"))
+	assert.ErrorIs(t, err, context.Canceled)
+	// and we don't keep trying
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestUploadFiles_StalledServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/presign/") {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		// the server only notices the runner hanging up once the body is read
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	handler := NewFileHandler("runner-1", system.ClientOptions{Host: server.URL}, nil, []string{os.TempDir()}, 100*time.Millisecond)
+
+	localFile := filepath.Join(t.TempDir(), "result.txt")
+	require.NoError(t, os.WriteFile(localFile, []byte("result"), 0644))
+
+	_, _, err := handler.uploadFiles(context.Background(), "session-1", []string{localFile}, "results")
+	assert.ErrorIs(t, err, errRequestStalled)
+}
+
+func TestGetNextApiSession_StalledServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	r := &Runner{
+		Options:           RunnerOptions{ID: "runner-1", RequestTimeoutSeconds: 1},
+		httpClientOptions: system.ClientOptions{Host: server.URL},
+	}
+
+	start := time.Now()
+	_, err := r.getNextApiSession(context.Background(), url.Values{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,144 @@
+package runner
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/helixml/helix/api/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+// what we do when the response queue is full
+type ResponseDropPolicy string
+
+const (
+	// wait for the api to catch up (which stalls the model process output)
+	ResponseDropPolicyBlock ResponseDropPolicy = "block"
+	// throw away the oldest streaming update that hasn't been sent yet - the
+	// final result has the whole message in it so nothing is lost in the end
+	ResponseDropPolicyDropOldest ResponseDropPolicy = "drop-oldest"
+)
+
+const DefaultResponseBufferSize = 256
+
+func ValidateResponseDropPolicy(policy string) (ResponseDropPolicy, error) {
+	switch ResponseDropPolicy(policy) {
+	case ResponseDropPolicyBlock, ResponseDropPolicyDropOldest:
+		return ResponseDropPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("invalid response drop policy: %s (must be %s or %s)", policy, ResponseDropPolicyBlock, ResponseDropPolicyDropOldest)
+	}
+}
+
+// sits between the text stream processors and the response handler so that
+// a slow api or websocket doesn't block us reading the model process output
+// responses are sent in order by a single goroutine
+type responseQueue struct {
+	handler func(res *types.RunnerTaskResponse) error
+	size    int
+	policy  ResponseDropPolicy
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	items   []*types.RunnerTaskResponse
+	closed  bool
+	dropped int
+	// closed once everything has been sent after close()
+	done chan struct{}
+}
+
+func newResponseQueue(size int, policy ResponseDropPolicy, handler func(res *types.RunnerTaskResponse) error) *responseQueue {
+	if size < 1 {
+		size = 1
+	}
+	q := &responseQueue{
+		handler: handler,
+		size:    size,
+		policy:  policy,
+		done:    make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+// only streaming updates can be dropped, never a result
+func isPartialResponse(res *types.RunnerTaskResponse) bool {
+	return res.Type != types.WorkerTaskResponseTypeResult && !res.Done
+}
+
+func (q *responseQueue) push(res *types.RunnerTaskResponse) {
+	q.mu.Lock()
+
+	if q.closed {
+		// nothing is reading the queue any more so send it ourselves
+		q.mu.Unlock()
+		q.send(res)
+		return
+	}
+
+	for len(q.items) >= q.size {
+		if q.policy == ResponseDropPolicyDropOldest && q.dropOldestPartial() {
+			break
+		}
+		q.cond.Wait()
+	}
+
+	q.items = append(q.items, res)
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// must be called with the lock held
+func (q *responseQueue) dropOldestPartial() bool {
+	for index, item := range q.items {
+		if !isPartialResponse(item) {
+			continue
+		}
+		q.items = append(q.items[:index], q.items[index+1:]...)
+		q.dropped++
+		if q.dropped == 1 || q.dropped%100 == 0 {
+			log.Warn().
+				Str("session_id", item.SessionID).
+				Int("dropped", q.dropped).
+				Msg("response handler is slow, dropping streaming updates")
+		}
+		return true
+	}
+	return false
+}
+
+func (q *responseQueue) run() {
+	defer close(q.done)
+	for {
+		q.mu.Lock()
+		for len(q.items) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.items) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		res := q.items[0]
+		q.items = q.items[1:]
+		q.cond.Broadcast()
+		q.mu.Unlock()
+
+		q.send(res)
+	}
+}
+
+func (q *responseQueue) send(res *types.RunnerTaskResponse) {
+	if err := q.handler(res); err != nil {
+		log.Error().Msgf("error writing event: %s", err.Error())
+	}
+}
+
+// stop taking responses and wait for the ones already queued to be sent
+func (q *responseQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	<-q.done
+}
@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// a response handler that can't keep up until it's released
+type slowResponseHandler struct {
+	mu        sync.Mutex
+	responses []*types.RunnerTaskResponse
+	release   chan struct{}
+}
+
+func (h *slowResponseHandler) handle(res *types.RunnerTaskResponse) error {
+	<-h.release
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.responses = append(h.responses, res)
+	return nil
+}
+
+func (h *slowResponseHandler) messages() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	messages := []string{}
+	for _, res := range h.responses {
+		messages = append(messages, res.Message)
+	}
+	return messages
+}
+
+func streamResponse(message string) *types.RunnerTaskResponse {
+	return &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeStream, Message: message}
+}
+
+func TestResponseQueue_DropOldestKeepsResult(t *testing.T) {
+	handler := &slowResponseHandler{release: make(chan struct{})}
+	queue := newResponseQueue(2, ResponseDropPolicyDropOldest, handler.handle)
+
+	pushed := make(chan struct{})
+	go func() {
+		// the first one is taken by the sender which is stuck in the handler
+		queue.push(streamResponse("a"))
+		time.Sleep(10 * time.Millisecond)
+		for _, message := range []string{"b", "c", "d", "e"} {
+			queue.push(streamResponse(message))
+		}
+		queue.push(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, Message: "result"})
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("push blocked even though we can drop streaming updates")
+	}
+
+	close(handler.release)
+	queue.close()
+
+	assert.Equal(t, []string{"a", "e", "result"}, handler.messages())
+}
+
+func TestResponseQueue_Block(t *testing.T) {
+	handler := &slowResponseHandler{release: make(chan struct{})}
+	queue := newResponseQueue(1, ResponseDropPolicyBlock, handler.handle)
+
+	pushed := make(chan struct{})
+	go func() {
+		for _, message := range []string{"a", "b", "c", "d"} {
+			queue.push(streamResponse(message))
+		}
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("push should wait for the handler when blocking")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(handler.release)
+	<-pushed
+	queue.close()
+
+	assert.Equal(t, []string{"a", "b", "c", "d"}, handler.messages())
+}
+
+func TestResponseQueue_SendsDirectlyWhenClosed(t *testing.T) {
+	handler := &slowResponseHandler{release: make(chan struct{})}
+	close(handler.release)
+	queue := newResponseQueue(1, ResponseDropPolicyDropOldest, handler.handle)
+	queue.close()
+
+	queue.push(streamResponse("late"))
+	assert.Equal(t, []string{"late"}, handler.messages())
+}
+
+func TestValidateResponseDropPolicy(t *testing.T) {
+	policy, err := ValidateResponseDropPolicy("block")
+	require.NoError(t, err)
+	assert.Equal(t, ResponseDropPolicyBlock, policy)
+
+	_, err = ValidateResponseDropPolicy("drop-newest")
+	assert.Error(t, err)
+}
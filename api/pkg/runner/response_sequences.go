@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"sync"
+
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+type responseSequence struct {
+	interactionID string
+	attempt       string
+	last          uint64
+}
+
+// numbers the responses we send for each session so the api can throw away
+// the duplicates our http client retries make and streamed chunks that
+// overtake each other - every run of an interaction is a new attempt and
+// the numbers start again
+type responseSequences struct {
+	mu       sync.Mutex
+	sessions map[string]*responseSequence
+}
+
+func newResponseSequences() *responseSequences {
+	return &responseSequences{
+		sessions: map[string]*responseSequence{},
+	}
+}
+
+// give the response the next number of its session's attempt - a response
+// we have already numbered (e.g. one being sent again) keeps its number
+func (s *responseSequences) stamp(res *types.RunnerTaskResponse) {
+	if res.Sequence != 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sequence, ok := s.sessions[res.SessionID]
+	// errors can be reported before we know which interaction we were on
+	if !ok || (res.InteractionID != "" && sequence.interactionID != "" && res.InteractionID != sequence.interactionID) {
+		sequence = &responseSequence{
+			interactionID: res.InteractionID,
+			attempt:       system.GenerateUUID(),
+		}
+		s.sessions[res.SessionID] = sequence
+	}
+	if sequence.interactionID == "" {
+		sequence.interactionID = res.InteractionID
+	}
+
+	sequence.last++
+	res.Attempt = sequence.attempt
+	res.Sequence = sequence.last
+
+	// the result is the last we send for the attempt
+	if res.Type == types.WorkerTaskResponseTypeResult {
+		delete(s.sessions, res.SessionID)
+	}
+}
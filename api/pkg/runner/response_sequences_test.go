@@ -0,0 +1,39 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestResponseSequences(t *testing.T) {
+	sequences := newResponseSequences()
+
+	first := &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeStream, SessionID: "session-1", InteractionID: "interaction-1"}
+	sequences.stamp(first)
+	assert.Equal(t, uint64(1), first.Sequence)
+	assert.NotEmpty(t, first.Attempt)
+
+	// sending a response again keeps its number
+	sequences.stamp(first)
+	assert.Equal(t, uint64(1), first.Sequence)
+
+	result := &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: "session-1", InteractionID: "interaction-1"}
+	sequences.stamp(result)
+	assert.Equal(t, uint64(2), result.Sequence)
+	assert.Equal(t, first.Attempt, result.Attempt)
+
+	// the next interaction is a new attempt
+	next := &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeStream, SessionID: "session-1", InteractionID: "interaction-2"}
+	sequences.stamp(next)
+	assert.Equal(t, uint64(1), next.Sequence)
+	assert.NotEqual(t, first.Attempt, next.Attempt)
+
+	// errors without an interaction ID belong to the attempt in progress
+	failed := &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: "session-1", Error: "boom"}
+	sequences.stamp(failed)
+	assert.Equal(t, uint64(2), failed.Sequence)
+	assert.Equal(t, next.Attempt, failed.Attempt)
+}
@@ -0,0 +1,38 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func instanceIDs(instances []ModelInstance) []string {
+	ids := []string{}
+	for _, instance := range instances {
+		ids = append(ids, instance.ID())
+	}
+	return ids
+}
+
+func TestSortedModelInstances(t *testing.T) {
+	r := newInstanceLimitRunner(0,
+		&stubModelInstance{id: "d", memory: 24},
+		&stubModelInstance{id: "c", memory: 8},
+		&stubModelInstance{id: "a", memory: 24},
+		&stubModelInstance{id: "b", memory: 8},
+	)
+
+	// the least memory first then by ID, every time
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, []string{"b", "c", "a", "d"}, instanceIDs(r.sortedModelInstances()))
+	}
+
+	state, err := r.getState()
+	require.NoError(t, err)
+	ids := []string{}
+	for _, instance := range state.ModelInstances {
+		ids = append(ids, instance.ID)
+	}
+	assert.Equal(t, []string{"b", "c", "a", "d"}, ids)
+}
@@ -2,8 +2,11 @@ package runner
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -47,6 +50,12 @@ func (runnerServer *RunnerServer) ListenAndServe(ctx context.Context, cm *system
 		SilenceErrors: true,
 	})).Methods("GET")
 
+	// the python code polls this while it is generating so that it can stop
+	// early if the output has hit one of the session's stop sequences
+	subrouter.HandleFunc("/worker/task/{instanceid}/stop", system.DefaultWrapperWithConfig(runnerServer.getWorkerTaskStop, system.WrapperConfig{
+		SilenceErrors: true,
+	})).Methods("GET")
+
 	// used by the Python code to know that a session has finished preparing and is ready to pull from the
 	// queue - this won't actually pull the session from the queue (in the form of a task i.e. getNextTask)
 	// but it gives the python code a chance to wait for Lora weights to download before loading them
@@ -55,6 +64,14 @@ func (runnerServer *RunnerServer) ListenAndServe(ctx context.Context, cm *system
 		SilenceErrors: true,
 	})).Methods("GET")
 
+	// the tail of a session's model process output - for debugging a failed interaction
+	subrouter.HandleFunc("/sessions/{sessionid}/logs", system.Wrapper(runnerServer.getSessionLogs)).Methods("GET")
+
+	// drain the runner before maintenance - it stops taking new sessions but
+	// finishes the ones it has
+	subrouter.HandleFunc("/cordon", system.Wrapper(runnerServer.cordon)).Methods("POST")
+	subrouter.HandleFunc("/uncordon", system.Wrapper(runnerServer.uncordon)).Methods("POST")
+
 	srv := &http.Server{
 		Addr:              fmt.Sprintf("%s:%d", runnerServer.Options.Host, runnerServer.Options.Port),
 		WriteTimeout:      time.Minute * 15,
@@ -78,6 +95,14 @@ func (runnerServer *RunnerServer) getWorkerTask(res http.ResponseWriter, req *ht
 	return runnerServer.Controller.popNextTask(req.Context(), vars["instanceid"])
 }
 
+func (runnerServer *RunnerServer) getWorkerTaskStop(res http.ResponseWriter, req *http.Request) (*types.RunnerTaskStop, error) {
+	vars := mux.Vars(req)
+	if vars["instanceid"] == "" {
+		return nil, fmt.Errorf("instanceid is required")
+	}
+	return runnerServer.Controller.readStoppedSession(vars["instanceid"])
+}
+
 func (runnerServer *RunnerServer) readInitialWorkerSession(res http.ResponseWriter, req *http.Request) (*types.Session, error) {
 	vars := mux.Vars(req)
 	if vars["instanceid"] == "" {
@@ -85,3 +110,60 @@ func (runnerServer *RunnerServer) readInitialWorkerSession(res http.ResponseWrit
 	}
 	return runnerServer.Controller.readInitialWorkerSession(vars["instanceid"])
 }
+
+func (runnerServer *RunnerServer) cordon(res http.ResponseWriter, req *http.Request) (*types.RunnerState, *system.HTTPError) {
+	runnerServer.Controller.Cordon()
+	return runnerServer.getState()
+}
+
+func (runnerServer *RunnerServer) uncordon(res http.ResponseWriter, req *http.Request) (*types.RunnerState, *system.HTTPError) {
+	runnerServer.Controller.Uncordon()
+	return runnerServer.getState()
+}
+
+func (runnerServer *RunnerServer) getState() (*types.RunnerState, *system.HTTPError) {
+	state, err := runnerServer.Controller.getState()
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+	return state, nil
+}
+
+// how many lines of a session log we return if the request doesn't say
+const defaultSessionLogLines = 100
+
+func (runnerServer *RunnerServer) getSessionLogs(res http.ResponseWriter, req *http.Request) (*types.SessionLogs, *system.HTTPError) {
+	sessionLogs := runnerServer.Controller.sessionLogs
+	if sessionLogs == nil {
+		return nil, system.NewHTTPError404("session logs are not enabled on this runner")
+	}
+
+	sessionID := mux.Vars(req)["sessionid"]
+
+	lines := defaultSessionLogLines
+	if value := req.URL.Query().Get("lines"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return nil, system.NewHTTPError400("lines must be a positive number")
+		}
+		lines = parsed
+	}
+
+	path, err := sessionLogs.Path(sessionID)
+	if err != nil {
+		return nil, system.NewHTTPError400(err.Error())
+	}
+	tail, err := sessionLogs.Tail(sessionID, lines)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, system.NewHTTPError404(fmt.Sprintf("no logs found for session %s", sessionID))
+	}
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	return &types.SessionLogs{
+		SessionID: sessionID,
+		LogFile:   path,
+		Lines:     tail,
+	}, nil
+}
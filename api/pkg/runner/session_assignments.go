@@ -0,0 +1,137 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// a session the api has handed to us that we have not sent a result for yet
+type sessionAssignment struct {
+	SessionID     string            `json:"session_id"`
+	InteractionID string            `json:"interaction_id"`
+	ModelName     types.ModelName   `json:"model_name"`
+	Mode          types.SessionMode `json:"mode"`
+	// where the session's files are downloaded to while it is prepared
+	Folder  string    `json:"folder"`
+	Created time.Time `json:"created"`
+}
+
+// the sessions we are preparing or running written to a file so that if
+// the runner restarts we can give them back to the api rather than the
+// user's job waiting forever for a runner that has forgotten about it
+type sessionAssignments struct {
+	// empty if we are not keeping track
+	path string
+
+	mu          sync.Mutex
+	assignments map[string]*sessionAssignment
+	// the assignments that were in the file when we started - nothing is
+	// working on these anymore so they need giving back
+	orphaned []*sessionAssignment
+}
+
+func newSessionAssignments(path string) (*sessionAssignments, error) {
+	assignments := &sessionAssignments{
+		path:        path,
+		assignments: map[string]*sessionAssignment{},
+	}
+	if path == "" {
+		return assignments, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating session assignments dir: %w", err)
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return assignments, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading session assignments: %w", err)
+	}
+	var saved []*sessionAssignment
+	if err := json.Unmarshal(content, &saved); err != nil {
+		return nil, fmt.Errorf("error reading session assignments %s: %w", path, err)
+	}
+	for _, assignment := range saved {
+		assignments.assignments[assignment.SessionID] = assignment
+		assignments.orphaned = append(assignments.orphaned, assignment)
+	}
+	return assignments, nil
+}
+
+func (a *sessionAssignments) add(session *types.Session) error {
+	if a.path == "" || isWarmupSessionID(session.ID) {
+		return nil
+	}
+	interactionID := ""
+	if systemInteraction, err := data.GetSystemInteraction(session); err == nil {
+		interactionID = systemInteraction.ID
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.assignments[session.ID] = &sessionAssignment{
+		SessionID:     session.ID,
+		InteractionID: interactionID,
+		ModelName:     session.ModelName,
+		Mode:          session.Mode,
+		Folder:        sessionDownloadFolder(session.ID),
+		Created:       time.Now(),
+	}
+	return a.save()
+}
+
+func (a *sessionAssignments) remove(sessionID string) error {
+	if a.path == "" {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.assignments[sessionID]; !ok {
+		return nil
+	}
+	delete(a.assignments, sessionID)
+	for i, assignment := range a.orphaned {
+		if assignment.SessionID == sessionID {
+			a.orphaned = append(a.orphaned[:i], a.orphaned[i+1:]...)
+			break
+		}
+	}
+	return a.save()
+}
+
+// the assignments left over from before we restarted
+func (a *sessionAssignments) getOrphaned() []*sessionAssignment {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]*sessionAssignment{}, a.orphaned...)
+}
+
+// must be called with the lock held - we write a temp file and rename it
+// so a crash half way through doesn't leave us with a file we can't read
+func (a *sessionAssignments) save() error {
+	saved := make([]*sessionAssignment, 0, len(a.assignments))
+	for _, assignment := range a.assignments {
+		saved = append(saved, assignment)
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Created.Before(saved[j].Created) })
+	content, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	tmpPath := a.path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return fmt.Errorf("error writing session assignments: %w", err)
+	}
+	if err := os.Rename(tmpPath, a.path); err != nil {
+		return fmt.Errorf("error writing session assignments: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestSessionAssignments_SurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "sessions.json")
+
+	assignments, err := newSessionAssignments(path)
+	require.NoError(t, err)
+	assert.Empty(t, assignments.getOrphaned())
+
+	require.NoError(t, assignments.add(newTestOllamaSession("a")))
+	require.NoError(t, assignments.add(newTestOllamaSession("b")))
+	// nobody is waiting on a warmup session
+	require.NoError(t, assignments.add(newWarmupSession(types.Model_Ollama_Mistral7b)))
+	// we sent the result for this one
+	require.NoError(t, assignments.remove("b"))
+
+	restarted, err := newSessionAssignments(path)
+	require.NoError(t, err)
+	orphaned := restarted.getOrphaned()
+	require.Len(t, orphaned, 1)
+	assert.Equal(t, "a", orphaned[0].SessionID)
+	assert.Equal(t, "a-system", orphaned[0].InteractionID)
+	assert.Equal(t, sessionDownloadFolder("a"), orphaned[0].Folder)
+
+	require.NoError(t, restarted.remove("a"))
+	assert.Empty(t, restarted.getOrphaned())
+
+	restarted, err = newSessionAssignments(path)
+	require.NoError(t, err)
+	assert.Empty(t, restarted.getOrphaned())
+}
+
+func TestSessionAssignments_Disabled(t *testing.T) {
+	assignments, err := newSessionAssignments("")
+	require.NoError(t, err)
+	require.NoError(t, assignments.add(newTestOllamaSession("a")))
+	assert.Empty(t, assignments.getOrphaned())
+}
+
+func TestRunner_ReleaseOrphanedSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	before, err := newSessionAssignments(path)
+	require.NoError(t, err)
+	require.NoError(t, before.add(newTestOllamaSession("a")))
+	require.NoError(t, before.add(newTestOllamaSession("b")))
+
+	folder := sessionDownloadFolder("a")
+	require.NoError(t, os.MkdirAll(folder, 0755))
+	t.Cleanup(func() { os.RemoveAll(folder) })
+
+	released := map[string]string{}
+	apiUp := false
+	api := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if !apiUp {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var release types.RunnerSessionRelease
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&release))
+		released[req.URL.Path] = release.InteractionID
+		release.Requeued = true
+		require.NoError(t, json.NewEncoder(res).Encode(release))
+	}))
+	defer api.Close()
+
+	assignments, err := newSessionAssignments(path)
+	require.NoError(t, err)
+	r := &Runner{
+		Options:            RunnerOptions{ID: "runner-1"},
+		httpClientOptions:  system.ClientOptions{Host: api.URL},
+		sessionAssignments: assignments,
+	}
+
+	// we hang on to them until the api has them back
+	r.releaseOrphanedSessions()
+	assert.Len(t, assignments.getOrphaned(), 2)
+
+	apiUp = true
+	r.releaseOrphanedSessions()
+	assert.Empty(t, assignments.getOrphaned())
+	assert.Equal(t, map[string]string{
+		"/api/v1/runner/runner-1/session/a/release": "a-system",
+		"/api/v1/runner/runner-1/session/b/release": "b-system",
+	}, released)
+
+	_, err = os.Stat(folder)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,269 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	_ io.WriteCloser = &sessionLogWriter{}
+)
+
+// where the output of model processes is kept (one log file per session)
+// so we can see what a process printed while it was running a given session
+// long after it has scrolled out of the runner's own logs
+type SessionLogs struct {
+	dir string
+	// how big a session log can get before it is rotated
+	maxSize int64
+	// how many rotated files we keep for each session as well as the live one
+	maxBackups int
+	// session logs that have not been written to for this long are deleted
+	retention time.Duration
+}
+
+func NewSessionLogs(dir string, maxSize int64, maxBackups int, retention time.Duration) (*SessionLogs, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("session log max size must be greater than 0")
+	}
+	if maxBackups < 0 {
+		return nil, fmt.Errorf("session log max backups cannot be negative")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating session log dir: %w", err)
+	}
+	return &SessionLogs{
+		dir:        dir,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		retention:  retention,
+	}, nil
+}
+
+// the live log file for a session - rotated files have .1, .2 etc on the end
+func (l *SessionLogs) Path(sessionID string) (string, error) {
+	if sessionID == "" || sessionID != filepath.Base(sessionID) || strings.HasPrefix(sessionID, ".") {
+		return "", fmt.Errorf("invalid session id: %q", sessionID)
+	}
+	return filepath.Join(l.dir, sessionID+".log"), nil
+}
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// the last n lines that were logged for the session, oldest first
+// we go back through the rotated files if the live one doesn't have enough
+func (l *SessionLogs) Tail(sessionID string, n int) ([]string, error) {
+	path, err := l.Path(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []string{}
+	found := false
+	for backup := 0; backup <= l.maxBackups && len(lines) < n; backup++ {
+		filePath := path
+		if backup > 0 {
+			filePath = backupPath(path, backup)
+		}
+		fileLines, err := readLines(filePath)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		lines = append(fileLines, lines...)
+	}
+	if !found {
+		return nil, os.ErrNotExist
+	}
+
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+func readLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// delete the logs of sessions that have not been written to within the
+// retention period - returns how many files were removed
+func (l *SessionLogs) Cleanup(now time.Time) (int, error) {
+	if l.retention <= 0 {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.Contains(entry.Name(), ".log") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) <= l.retention {
+			continue
+		}
+		if err := os.Remove(filepath.Join(l.dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// an io.Writer for the output of a single model process - a process runs
+// one session at a time so we switch the file we write to as sessions are
+// assigned and anything written between sessions is dropped
+type sessionLogWriter struct {
+	logs *SessionLogs
+
+	mu        sync.Mutex
+	sessionID string
+	path      string
+	file      *os.File
+	size      int64
+}
+
+func (l *SessionLogs) NewWriter() *sessionLogWriter {
+	return &sessionLogWriter{logs: l}
+}
+
+// start writing to the given session's log (an empty id stops writing)
+// and return the path of the log file
+func (w *sessionLogWriter) SetSession(sessionID string) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if sessionID == w.sessionID {
+		return w.path, nil
+	}
+	w.closeFile()
+	w.sessionID = ""
+	w.path = ""
+
+	if sessionID == "" {
+		return "", nil
+	}
+
+	path, err := w.logs.Path(sessionID)
+	if err != nil {
+		return "", err
+	}
+	if err := w.openFile(path); err != nil {
+		return "", err
+	}
+	w.sessionID = sessionID
+	w.path = path
+	return path, nil
+}
+
+func (w *sessionLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return len(p), nil
+	}
+
+	if w.size > 0 && w.size+int64(len(p)) > w.logs.maxSize {
+		if err := w.rotate(); err != nil {
+			// never break the process output because of the log file
+			log.Error().Msgf("error rotating session log %s: %s", w.path, err.Error())
+			return len(p), nil
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err != nil {
+		log.Error().Msgf("error writing session log %s: %s", w.path, err.Error())
+	}
+	return len(p), nil
+}
+
+func (w *sessionLogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closeFile()
+	w.sessionID = ""
+	w.path = ""
+	return nil
+}
+
+func (w *sessionLogWriter) openFile(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+func (w *sessionLogWriter) closeFile() {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	w.size = 0
+}
+
+// shift session.log.1 -> session.log.2 and so on, dropping the oldest
+// then move the live file to .1 and start a new one
+func (w *sessionLogWriter) rotate() error {
+	w.closeFile()
+
+	if w.logs.maxBackups == 0 {
+		if err := os.Truncate(w.path, 0); err != nil {
+			return err
+		}
+		return w.openFile(w.path)
+	}
+
+	for n := w.logs.maxBackups - 1; n >= 1; n-- {
+		err := os.Rename(backupPath(w.path, n), backupPath(w.path, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(w.path, backupPath(w.path, 1)); err != nil {
+		return err
+	}
+	return w.openFile(w.path)
+}
@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionLogWriter_SwitchesSessions(t *testing.T) {
+	logs, err := NewSessionLogs(t.TempDir(), 1024, 2, time.Hour)
+	require.NoError(t, err)
+
+	writer := logs.NewWriter()
+	defer writer.Close()
+
+	// nothing is running so this is dropped
+	_, err = writer.Write([]byte("booting\n"))
+	require.NoError(t, err)
+
+	path, err := writer.SetSession("session-1")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(logs.dir, "session-1.log"), path)
+	_, _ = writer.Write([]byte("one\ntwo\n"))
+
+	_, err = writer.SetSession("session-2")
+	require.NoError(t, err)
+	_, _ = writer.Write([]byte("three\n"))
+
+	lines, err := logs.Tail("session-1", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"one", "two"}, lines)
+
+	lines, err = logs.Tail("session-2", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"three"}, lines)
+
+	_, err = logs.Tail("session-3", 10)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSessionLogWriter_Rotates(t *testing.T) {
+	logs, err := NewSessionLogs(t.TempDir(), 12, 2, time.Hour)
+	require.NoError(t, err)
+
+	writer := logs.NewWriter()
+	defer writer.Close()
+	_, err = writer.SetSession("session-1")
+	require.NoError(t, err)
+
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n", "line 5\n"} {
+		_, err = writer.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	// each file only fits one line and we keep two old ones so line 1 and 2 are gone
+	path, err := logs.Path("session-1")
+	require.NoError(t, err)
+	_, err = os.Stat(backupPath(path, 3))
+	assert.True(t, os.IsNotExist(err))
+
+	lines, err := logs.Tail("session-1", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"line 3", "line 4", "line 5"}, lines)
+
+	lines, err = logs.Tail("session-1", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"line 4", "line 5"}, lines)
+}
+
+func TestSessionLogs_Cleanup(t *testing.T) {
+	logs, err := NewSessionLogs(t.TempDir(), 1024, 2, time.Hour)
+	require.NoError(t, err)
+
+	oldPath, _ := logs.Path("old-session")
+	newPath, _ := logs.Path("new-session")
+	require.NoError(t, os.WriteFile(oldPath, []byte("old\n"), 0644))
+	require.NoError(t, os.WriteFile(backupPath(oldPath, 1), []byte("older\n"), 0644))
+	require.NoError(t, os.WriteFile(newPath, []byte("new\n"), 0644))
+
+	twoHoursAgo := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(oldPath, twoHoursAgo, twoHoursAgo))
+	require.NoError(t, os.Chtimes(backupPath(oldPath, 1), twoHoursAgo, twoHoursAgo))
+
+	removed, err := logs.Cleanup(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	_, err = os.Stat(oldPath)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(newPath)
+	assert.NoError(t, err)
+}
+
+func TestSessionLogs_InvalidSessionID(t *testing.T) {
+	logs, err := NewSessionLogs(t.TempDir(), 1024, 2, time.Hour)
+	require.NoError(t, err)
+
+	for _, sessionID := range []string{"", "../etc/passwd", "a/b", ".hidden"} {
+		_, err := logs.Path(sessionID)
+		assert.Error(t, err, sessionID)
+	}
+}
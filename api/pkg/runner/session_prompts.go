@@ -0,0 +1,48 @@
+package runner
+
+import (
+	"sync"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// the prompt each session's model was handed (system prompt, history and
+// all) kept until the session's result carries it to the api - nil unless
+// the runner is recording prompts, the methods do nothing then
+type sessionPrompts struct {
+	mu      sync.Mutex
+	prompts map[string]string
+}
+
+func newSessionPrompts(options RunnerOptions) *sessionPrompts {
+	if !options.RecordPrompts {
+		return nil
+	}
+	return &sessionPrompts{
+		prompts: map[string]string{},
+	}
+}
+
+func (p *sessionPrompts) record(sessionID, prompt string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prompts[sessionID] = prompt
+}
+
+// fill in the prompt on the session's result, the last we hear of it
+func (p *sessionPrompts) attach(res *types.RunnerTaskResponse) {
+	if p == nil || res.Type != types.WorkerTaskResponseTypeResult {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	prompt, ok := p.prompts[res.SessionID]
+	if !ok {
+		return
+	}
+	res.Prompt = prompt
+	delete(p.prompts, res.SessionID)
+}
@@ -0,0 +1,38 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestSessionPrompts(t *testing.T) {
+	prompts := newSessionPrompts(RunnerOptions{RecordPrompts: true})
+	prompts.record("a", "[INST] hello [/INST]")
+
+	// streamed output doesn't carry it
+	res := &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeStream, SessionID: "a"}
+	prompts.attach(res)
+	assert.Empty(t, res.Prompt)
+
+	res = &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: "a"}
+	prompts.attach(res)
+	assert.Equal(t, "[INST] hello [/INST]", res.Prompt)
+
+	// the result is the end of the session
+	res = &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: "a"}
+	prompts.attach(res)
+	assert.Empty(t, res.Prompt)
+}
+
+func TestSessionPrompts_NotRecording(t *testing.T) {
+	prompts := newSessionPrompts(RunnerOptions{})
+	assert.Nil(t, prompts)
+
+	prompts.record("a", "[INST] hello [/INST]")
+	res := &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: "a"}
+	prompts.attach(res)
+	assert.Empty(t, res.Prompt)
+}
@@ -0,0 +1,57 @@
+package runner
+
+import (
+	"sync"
+	"time"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// when the model got to each stage of the sessions a model instance is
+// running, the result for a session carries them to the api
+type sessionTimings struct {
+	mu      sync.Mutex
+	timings map[string]*types.InteractionTimings
+	now     func() time.Time
+}
+
+func newSessionTimings() *sessionTimings {
+	return &sessionTimings{
+		timings: map[string]*types.InteractionTimings{},
+		now:     time.Now,
+	}
+}
+
+// the model has been handed the session
+func (t *sessionTimings) start(sessionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timings[sessionID] = &types.InteractionTimings{Started: t.now()}
+}
+
+// note the first output of a session and fill in the timings on its result
+// (which is the last we hear of it)
+func (t *sessionTimings) stamp(res *types.RunnerTaskResponse) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timings, ok := t.timings[res.SessionID]
+	if !ok {
+		return
+	}
+
+	switch res.Type {
+	case types.WorkerTaskResponseTypeStream:
+		if res.Message != "" && timings.FirstToken.IsZero() {
+			timings.FirstToken = t.now()
+		}
+	case types.WorkerTaskResponseTypeResult:
+		generationStarted := timings.FirstToken
+		if generationStarted.IsZero() {
+			generationStarted = timings.Started
+		}
+		timings.Generation = types.Duration(t.now().Sub(generationStarted))
+		res.Timings = timings
+		delete(t.timings, res.SessionID)
+	}
+}
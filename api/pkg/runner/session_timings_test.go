@@ -0,0 +1,48 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestSessionTimings(t *testing.T) {
+	now := time.Unix(1000, 0)
+	timings := newSessionTimings()
+	timings.now = func() time.Time { return now }
+
+	timings.start("a")
+	timings.start("b")
+
+	// empty chunks (like the stream done marker) aren't output
+	now = now.Add(time.Second)
+	timings.stamp(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeStream, SessionID: "a"})
+	now = now.Add(time.Second)
+	timings.stamp(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeStream, SessionID: "a", Message: "hello"})
+	now = now.Add(time.Second)
+	timings.stamp(&types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeStream, SessionID: "a", Message: " world"})
+
+	now = now.Add(5 * time.Second)
+	res := &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: "a"}
+	timings.stamp(res)
+	require.NotNil(t, res.Timings)
+	assert.Equal(t, time.Unix(1000, 0), res.Timings.Started)
+	assert.Equal(t, time.Unix(1002, 0), res.Timings.FirstToken)
+	assert.Equal(t, types.Duration(6*time.Second), res.Timings.Generation)
+
+	// the result is the end of the session
+	res = &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: "a"}
+	timings.stamp(res)
+	assert.Nil(t, res.Timings)
+
+	// a model that doesn't stream is generating from the start
+	res = &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeResult, SessionID: "b"}
+	timings.stamp(res)
+	require.NotNil(t, res.Timings)
+	assert.True(t, res.Timings.FirstToken.IsZero())
+	assert.Equal(t, types.Duration(8*time.Second), res.Timings.Generation)
+}
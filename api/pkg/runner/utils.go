@@ -1,9 +1,16 @@
 package runner
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/system"
 	"github.com/helixml/helix/api/pkg/types"
+	"github.com/rs/zerolog/log"
 )
 
 func getLastInteractionID(session *types.Session) (string, error) {
@@ -17,9 +24,150 @@ func getLastInteractionID(session *types.Session) (string, error) {
 	return interaction.ID, nil
 }
 
+// the ID of the api request that created the interaction we are about to run
+// so our logs can be matched up with the api access log
+func getSessionRequestID(session *types.Session) string {
+	interaction, err := data.GetUserInteraction(session)
+	if err != nil || interaction == nil {
+		return ""
+	}
+	return interaction.RequestID
+}
+
 func modelInstanceMatchesSession(modelInstance ModelInstance, session *types.Session) bool {
 	return modelInstance.Filter().Mode == session.Mode &&
 		modelInstance.Filter().Type == session.Type &&
 		(modelInstance.Filter().LoraDir == session.LoraDir ||
 			(modelInstance.Filter().LoraDir == types.LORA_DIR_NONE && session.LoraDir == ""))
 }
+
+// what we tell the api we are already running so it doesn't hand us a
+// session that one of our model processes would have picked up itself
+func rejectEntry(filter types.SessionFilter) string {
+	mode := string(filter.Mode)
+	if filter.Env != "" {
+		mode += types.REJECT_ENV_SEPARATOR + filter.Env
+	}
+	return fmt.Sprintf("%s:%s:%s", filter.ModelName, mode, filter.LoraDir)
+}
+
+// the session's env vars go after the ones the model sets up - the api
+// checks the names when the session is created but we only pass on ones
+// with the prefix in any case so a session can't clobber PATH and the like
+func addSessionEnv(processEnv []string, env types.SessionEnv) []string {
+	if len(env) == 0 {
+		return processEnv
+	}
+	if processEnv == nil {
+		// an empty Env means the process gets ours so keep it that way
+		processEnv = os.Environ()
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !types.ValidSessionEnvName(name) {
+			log.Warn().Msgf("🟠 ignoring session env var %s", name)
+			continue
+		}
+		processEnv = append(processEnv, name+"="+env[name])
+	}
+	return processEnv
+}
+
+// the values of session env vars are kept out of the logs
+func redactSessionEnv(processEnv []string) []string {
+	redacted := make([]string, 0, len(processEnv))
+	for _, entry := range processEnv {
+		name, _, _ := strings.Cut(entry, "=")
+		if strings.HasPrefix(name, types.SESSION_ENV_PREFIX) {
+			entry = name + "=[redacted]"
+		}
+		redacted = append(redacted, entry)
+	}
+	return redacted
+}
+
+// how much of a model process's stderr we keep if RunnerOptions doesn't say
+const DefaultStderrBufferSize = 1024 * 10
+
+func newStderrBuffer(options RunnerOptions) *system.LimitedBuffer {
+	size := options.StderrBufferSize
+	if size <= 0 {
+		size = DefaultStderrBufferSize
+	}
+	return system.NewLimitedBuffer(size)
+}
+
+// a model process exited with an error - Stderr is the end of what it wrote
+// which is where the python traceback (and so the actual cause) will be
+type processExitError struct {
+	Err    error
+	Stderr string
+}
+
+// the full stderr is too much for a one line error so we use the last line
+// which for a python traceback is the exception that was raised
+func (e *processExitError) Error() string {
+	lines := strings.Split(strings.TrimSpace(e.Stderr), "\n")
+	summary := strings.TrimSpace(lines[len(lines)-1])
+	if summary == "" {
+		return fmt.Sprintf("%s from cmd", e.Err.Error())
+	}
+	return fmt.Sprintf("%s from cmd - %s", e.Err.Error(), summary)
+}
+
+func (e *processExitError) Unwrap() error {
+	return e.Err
+}
+
+// the stderr to attach to the task response if err came from a crashed process
+func getErrorDetail(err error) string {
+	var exitErr *processExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Stderr
+	}
+	return ""
+}
+
+// what the model runtimes write when they run out of memory - torch,
+// the CUDA runtime, cuBLAS, llama.cpp and the CPU allocator
+var outOfMemorySignatures = []string{
+	"cuda out of memory",
+	"outofmemoryerror",
+	"cuda error: out of memory",
+	"cublas_status_alloc_failed",
+	"cudaerrormemoryallocation",
+	"failed to allocate cuda",
+	"defaultcpuallocator: can't allocate memory",
+}
+
+// looks for a known out of memory message in the error and (if the process
+// crashed) its stderr, anything else is a generic model error
+func getErrorCode(err error) types.RunnerErrorCode {
+	if isOutOfMemory(err.Error()) || isOutOfMemory(getErrorDetail(err)) {
+		return types.RunnerErrorCodeOutOfMemory
+	}
+	return types.RunnerErrorCodeModelError
+}
+
+func isOutOfMemory(output string) bool {
+	output = strings.ToLower(output)
+	for _, signature := range outOfMemorySignatures {
+		if strings.Contains(output, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// the session's own limit or ours, whichever is smaller
+func checkSessionFileSize(session *types.Session, runnerLimit int64) error {
+	limit := session.Metadata.MaxFileSize
+	if runnerLimit > 0 && (limit <= 0 || runnerLimit < limit) {
+		limit = runnerLimit
+	}
+	return data.CheckSessionFileSize(data.GetSessionFileSize(session.Interactions), limit)
+}
@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestProcessExitError(t *testing.T) {
+	stderr := "Traceback (most recent call last):\n  File \"train.py\", line 10, in <module>\n    main()\ntorch.cuda.OutOfMemoryError: CUDA out of memory\n"
+	err := &processExitError{Err: errors.New("exit status 1"), Stderr: stderr}
+
+	assert.Equal(t, "exit status 1 from cmd - torch.cuda.OutOfMemoryError: CUDA out of memory", err.Error())
+	assert.Equal(t, stderr, getErrorDetail(err))
+	assert.Equal(t, stderr, getErrorDetail(fmt.Errorf("wrapped: %w", err)))
+
+	err = &processExitError{Err: errors.New("exit status 1")}
+	assert.Equal(t, "exit status 1 from cmd", err.Error())
+
+	assert.Empty(t, getErrorDetail(errors.New("something else")))
+}
+
+func TestGetErrorCode(t *testing.T) {
+	oom := &processExitError{
+		Err:    errors.New("exit status 1"),
+		Stderr: "Traceback (most recent call last):\n  File \"train.py\", line 10, in <module>\n    main()\ntorch.cuda.OutOfMemoryError: CUDA out of memory. Tried to allocate 2.00 GiB\n",
+	}
+	assert.Equal(t, types.RunnerErrorCodeOutOfMemory, getErrorCode(oom))
+	assert.Equal(t, types.RunnerErrorCodeOutOfMemory, getErrorCode(fmt.Errorf("wrapped: %w", oom)))
+
+	// the signature is somewhere other than the last line
+	oom = &processExitError{
+		Err:    errors.New("exit status 1"),
+		Stderr: "RuntimeError: CUDA error: out of memory\nCUDA kernel errors might be asynchronously reported\n",
+	}
+	assert.Equal(t, types.RunnerErrorCodeOutOfMemory, getErrorCode(oom))
+
+	// ollama and the python process only give us a message
+	assert.Equal(t, types.RunnerErrorCodeOutOfMemory, getErrorCode(errors.New("CUBLAS_STATUS_ALLOC_FAILED when calling cublasCreate")))
+
+	crash := &processExitError{
+		Err:    errors.New("exit status 1"),
+		Stderr: "Traceback (most recent call last):\nKeyError: 'prompt'\n",
+	}
+	assert.Equal(t, types.RunnerErrorCodeModelError, getErrorCode(crash))
+	assert.Equal(t, types.RunnerErrorCodeModelError, getErrorCode(errors.New("model process produced no output for 5m0s and was killed")))
+}
+
+func TestNewStderrBuffer(t *testing.T) {
+	buf := newStderrBuffer(RunnerOptions{StderrBufferSize: 4})
+	_, _ = buf.Write([]byte("abcdef"))
+	assert.Equal(t, "cdef", string(buf.Bytes()))
+
+	buf = newStderrBuffer(RunnerOptions{})
+	_, _ = buf.Write(make([]byte, DefaultStderrBufferSize+1))
+	assert.Len(t, buf.Bytes(), DefaultStderrBufferSize)
+}
+
+func TestCheckSessionFileSize(t *testing.T) {
+	session := &types.Session{
+		Interactions: []*types.Interaction{
+			{Creator: types.CreatorTypeUser, FileSizes: map[string]int64{"a.jsonl": 600, "b.jsonl": 600}},
+			{Creator: types.CreatorTypeSystem},
+		},
+	}
+
+	assert.NoError(t, checkSessionFileSize(session, 0))
+	assert.NoError(t, checkSessionFileSize(session, 2000))
+
+	err := checkSessionFileSize(session, 1000)
+	assert.ErrorIs(t, err, data.ErrDatasetTooLarge)
+	assert.Contains(t, err.Error(), "1200 bytes")
+
+	// the smaller of the session's and the runner's limit wins
+	session.Metadata.MaxFileSize = 1000
+	assert.ErrorIs(t, checkSessionFileSize(session, 0), data.ErrDatasetTooLarge)
+	assert.ErrorIs(t, checkSessionFileSize(session, 5000), data.ErrDatasetTooLarge)
+	session.Metadata.MaxFileSize = 5000
+	assert.NoError(t, checkSessionFileSize(session, 0))
+}
+
+func TestAddSessionEnv(t *testing.T) {
+	processEnv := []string{"PATH=/usr/bin", "APP_MODE=inference"}
+
+	assert.Equal(t, processEnv, addSessionEnv(processEnv, nil))
+
+	env := addSessionEnv(processEnv, types.SessionEnv{
+		"HELIX_SESSION_SEED": "42",
+		"HELIX_SESSION_FLAG": "on",
+		// the api won't let these through but they aren't passed on if they get here
+		"PATH": "/tmp/evil",
+	})
+	assert.Equal(t, []string{"PATH=/usr/bin", "APP_MODE=inference", "HELIX_SESSION_FLAG=on", "HELIX_SESSION_SEED=42"}, env)
+
+	assert.Equal(t,
+		[]string{"PATH=/usr/bin", "APP_MODE=inference", "HELIX_SESSION_FLAG=[redacted]", "HELIX_SESSION_SEED=[redacted]"},
+		redactSessionEnv(env),
+	)
+}
+
+func TestRejectEntry(t *testing.T) {
+	filter := types.SessionFilter{
+		ModelName: types.Model_Axolotl_Mistral7b,
+		Mode:      types.SessionModeInference,
+		LoraDir:   types.LORA_DIR_NONE,
+	}
+	assert.Equal(t, "mistralai/Mistral-7B-Instruct-v0.1:inference:none", rejectEntry(filter))
+
+	filter.Env = "0123456789abcdef"
+	assert.Equal(t, "mistralai/Mistral-7B-Instruct-v0.1:inference@0123456789abcdef:none", rejectEntry(filter))
+}
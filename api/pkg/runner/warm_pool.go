@@ -0,0 +1,154 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/helixml/helix/api/pkg/model"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// how many idle instances of each model we keep loaded so that a session for
+// one of them can start straight away rather than waiting for a download
+type warmPool map[types.ModelName]int
+
+// the pool is configured as model name => count
+func parseWarmPool(options RunnerOptions) (warmPool, error) {
+	pool := warmPool{}
+	for name, value := range options.WarmPool {
+		modelName, err := types.ValidateModelName(name, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid warm pool model: %w", err)
+		}
+		if options.FilterModelName != "" && options.FilterModelName != name {
+			return nil, fmt.Errorf("warm pool model %s does not match the runner model filter %s", name, options.FilterModelName)
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("invalid warm pool count for %s: %s", name, value)
+		}
+		// the api won't give us sessions for a model we already have
+		// running so there is no point keeping more than one of them
+		if count > 1 && !options.AllowMultipleCopies {
+			count = 1
+		}
+		if count > 0 {
+			pool[modelName] = count
+		}
+	}
+	return pool, nil
+}
+
+func isWarmupSessionID(sessionID string) bool {
+	return sessionID == types.WarmupTextSessionID || sessionID == types.WarmupImageSessionID
+}
+
+// a session that gets a model loaded without anyone waiting on the result
+func newWarmupSession(modelName types.ModelName) *types.Session {
+	id := types.WarmupTextSessionID
+	sessionType := types.SessionTypeText
+	if modelName == types.Model_Axolotl_SDXL {
+		id = types.WarmupImageSessionID
+		sessionType = types.SessionTypeImage
+	}
+	now := time.Now()
+	return &types.Session{
+		ID:        id,
+		Name:      id,
+		Created:   now,
+		Updated:   now,
+		Mode:      types.SessionModeInference,
+		Type:      sessionType,
+		ModelName: modelName,
+		Interactions: []*types.Interaction{
+			{
+				ID:       "warmup-user",
+				Created:  now,
+				Creator:  types.CreatorTypeUser,
+				Message:  "a new runner is born",
+				Finished: true,
+			},
+			{
+				ID:      "warmup-system",
+				Created: now,
+				Creator: types.CreatorTypeSystem,
+			},
+		},
+		Owner:     "warmup-user",
+		OwnerType: types.OwnerTypeUser,
+	}
+}
+
+// a warm instance is a base model inference instance that is not running
+// a real session - i.e. it could pick one up right now (or as soon as it
+// has finished booting)
+func isWarmInstance(state *types.ModelInstanceState) bool {
+	if state.Mode != types.SessionModeInference {
+		return false
+	}
+	if state.LoraDir != "" && state.LoraDir != types.LORA_DIR_NONE {
+		return false
+	}
+	return state.CurrentSession == nil || isWarmupSessionID(state.CurrentSession.SessionID)
+}
+
+// the same check for an instance that hasn't reported any state yet
+func isWarmFilter(filter types.SessionFilter) bool {
+	return filter.Mode == types.SessionModeInference && (filter.LoraDir == "" || filter.LoraDir == types.LORA_DIR_NONE)
+}
+
+// start a warm instance for the first model in the pool that is short of
+// one if there is memory for it - we never evict anything to make room so
+// real sessions always come first and warm instances still go stale and
+// get evicted like any other instance when the memory is needed
+func (r *Runner) fillWarmPool(ctx context.Context) error {
+	if len(r.warmPool) == 0 {
+		return nil
+	}
+	if r.Options.MaxInstances > 0 && r.activeModelInstances.Size() >= r.Options.MaxInstances {
+		return nil
+	}
+
+	warm := map[types.ModelName]int{}
+	r.activeModelInstances.Range(func(key string, modelInstance ModelInstance) bool {
+		state, err := modelInstance.GetState()
+		if err != nil {
+			// it's still starting up so it has no state yet, count it by the
+			// filter it was started with or we would keep starting more
+			if isWarmFilter(modelInstance.Filter()) {
+				warm[modelInstance.Filter().ModelName]++
+			}
+			return true
+		}
+		if isWarmInstance(state) {
+			warm[state.ModelName]++
+		}
+		return true
+	})
+
+	modelNames := make([]types.ModelName, 0, len(r.warmPool))
+	for modelName := range r.warmPool {
+		modelNames = append(modelNames, modelName)
+	}
+	sort.Slice(modelNames, func(i, j int) bool { return modelNames[i] < modelNames[j] })
+
+	for _, modelName := range modelNames {
+		want := r.warmPool[modelName]
+		if warm[modelName] >= want {
+			continue
+		}
+		aiModel, err := model.GetModel(modelName)
+		if err != nil {
+			return err
+		}
+		if aiModel.GetMemoryRequirements(types.SessionModeInference) > r.getFreeMemory() {
+			continue
+		}
+		r.addSchedulingDecision(fmt.Sprintf("starting warm instance of %s (%d of %d)", modelName, warm[modelName]+1, want))
+		return r.createModelInstance(ctx, newWarmupSession(modelName))
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestParseWarmPool(t *testing.T) {
+	pool, err := parseWarmPool(RunnerOptions{
+		AllowMultipleCopies: true,
+		WarmPool: map[string]string{
+			types.Model_Ollama_Mistral7b.String(): "2",
+			types.Model_Axolotl_SDXL.String():     "0",
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, warmPool{types.Model_Ollama_Mistral7b: 2}, pool)
+
+	// only one copy of a model is any use if we can't run more
+	pool, err = parseWarmPool(RunnerOptions{
+		WarmPool: map[string]string{types.Model_Ollama_Mistral7b.String(): "3"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, warmPool{types.Model_Ollama_Mistral7b: 1}, pool)
+
+	_, err = parseWarmPool(RunnerOptions{WarmPool: map[string]string{"not-a-model": "1"}})
+	assert.Error(t, err)
+
+	_, err = parseWarmPool(RunnerOptions{WarmPool: map[string]string{types.Model_Ollama_Mistral7b.String(): "lots"}})
+	assert.Error(t, err)
+
+	_, err = parseWarmPool(RunnerOptions{
+		FilterModelName: types.Model_Ollama_Phi3.String(),
+		WarmPool:        map[string]string{types.Model_Ollama_Mistral7b.String(): "1"},
+	})
+	assert.Error(t, err)
+}
+
+func TestIsWarmInstance(t *testing.T) {
+	state := &types.ModelInstanceState{
+		ModelName: types.Model_Ollama_Mistral7b,
+		Mode:      types.SessionModeInference,
+	}
+	assert.True(t, isWarmInstance(state))
+
+	// still booting with its warmup session
+	state.CurrentSession = &types.SessionSummary{SessionID: types.WarmupTextSessionID}
+	assert.True(t, isWarmInstance(state))
+
+	// picked up a real session so it needs replacing
+	state.CurrentSession = &types.SessionSummary{SessionID: "session-1"}
+	assert.False(t, isWarmInstance(state))
+
+	state.CurrentSession = nil
+	state.LoraDir = "users/abc/lora"
+	assert.False(t, isWarmInstance(state))
+
+	state.LoraDir = ""
+	state.Mode = types.SessionModeFinetune
+	assert.False(t, isWarmInstance(state))
+}
+
+func TestNewWarmupSession(t *testing.T) {
+	session := newWarmupSession(types.Model_Axolotl_SDXL)
+	assert.Equal(t, types.WarmupImageSessionID, session.ID)
+	assert.Equal(t, types.SessionTypeImage, session.Type)
+	assert.True(t, isWarmupSessionID(session.ID))
+
+	session = newWarmupSession(types.Model_Ollama_Mistral7b)
+	assert.Equal(t, types.WarmupTextSessionID, session.ID)
+	assert.Equal(t, types.SessionTypeText, session.Type)
+	assert.Equal(t, types.SessionModeInference, session.Mode)
+	assert.Equal(t, types.CreatorTypeSystem, session.Interactions[len(session.Interactions)-1].Creator)
+}
+
+// an instance that hasn't reported any state yet because its process is
+// still starting
+type startingModelInstance struct {
+	ModelInstance
+	id     string
+	filter types.SessionFilter
+}
+
+func (m *startingModelInstance) ID() string {
+	return m.id
+}
+
+func (m *startingModelInstance) Filter() types.SessionFilter {
+	return m.filter
+}
+
+func (m *startingModelInstance) GetState() (*types.ModelInstanceState, error) {
+	return nil, errors.New("no initial session")
+}
+
+func TestFillWarmPool_CountsStartingInstances(t *testing.T) {
+	r := &Runner{
+		Options:              RunnerOptions{ID: "runner-1"},
+		activeModelInstances: xsync.NewMapOf[string, ModelInstance](),
+		warmPool:             warmPool{types.Model_Ollama_Mistral7b: 1},
+	}
+	r.activeModelInstances.Store("starting", &startingModelInstance{
+		id: "starting",
+		filter: types.SessionFilter{
+			ModelName: types.Model_Ollama_Mistral7b,
+			Mode:      types.SessionModeInference,
+			LoraDir:   types.LORA_DIR_NONE,
+		},
+	})
+
+	require.NoError(t, r.fillWarmPool(context.Background()))
+	assert.Equal(t, 1, r.activeModelInstances.Size())
+	assert.Empty(t, r.schedulingDecisions)
+}
+
+func TestIsWarmFilter(t *testing.T) {
+	assert.True(t, isWarmFilter(types.SessionFilter{Mode: types.SessionModeInference}))
+	assert.False(t, isWarmFilter(types.SessionFilter{Mode: types.SessionModeInference, LoraDir: "users/abc/lora"}))
+	assert.False(t, isWarmFilter(types.SessionFilter{Mode: types.SessionModeFinetune}))
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/helixml/helix/api/pkg/system"
+)
+
+// the auth middleware runs further down the chain with its own copy of the
+// request so it fills this in for us to read once the request is done
+type accessLogEntry struct {
+	owner string
+}
+
+type accessLogEntryContextKey struct{}
+
+func setAccessLogOwner(ctx context.Context, owner string) {
+	entry, ok := ctx.Value(accessLogEntryContextKey{}).(*accessLogEntry)
+	if ok {
+		entry.owner = owner
+	}
+}
+
+// assigns every request an ID (honoring one sent by the client) that is put
+// into the context and the response headers and then writes an access log
+// line once the request has been handled
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(system.RequestIDHeader)
+		if !system.IsValidRequestID(requestID) {
+			requestID = system.GenerateRequestID()
+		}
+		w.Header().Set(system.RequestIDHeader, requestID)
+
+		entry := &accessLogEntry{}
+		ctx := system.SetRequestID(r.Context(), requestID)
+		ctx = context.WithValue(ctx, accessLogEntryContextKey{}, entry)
+		// anything using log.Ctx(ctx) will now include the request ID
+		ctx = log.With().Str("request_id", requestID).Logger().WithContext(ctx)
+
+		lrw := NewLoggingResponseWriter(w)
+		next.ServeHTTP(lrw, r.WithContext(ctx))
+
+		event := accessLogEvent(r)
+		event.
+			Str("request_id", requestID).
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Int("status", lrw.statusCode).
+			Dur("duration", time.Since(start)).
+			Str("owner", entry.owner).
+			Msg("request")
+	})
+}
+
+// runners and health probes poll the api constantly so we only log their
+// requests at debug level - otherwise they drown out everything else
+func accessLogEvent(r *http.Request) *zerolog.Event {
+	if strings.HasPrefix(r.URL.Path, API_PREFIX+"/runner/") || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+		return log.Debug()
+	}
+	return log.Info()
+}
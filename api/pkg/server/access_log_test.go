@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func Test_accessLogMiddleware_HonorsInboundRequestID(t *testing.T) {
+	var seenRequestID string
+	handler := accessLogMiddleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		seenRequestID = system.GetRequestID(req.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil)
+	req.Header.Set(system.RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, "abc-123", seenRequestID)
+	assert.Equal(t, "abc-123", rec.Header().Get(system.RequestIDHeader))
+}
+
+func Test_accessLogMiddleware_ReplacesInvalidRequestID(t *testing.T) {
+	var seenRequestID string
+	handler := accessLogMiddleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		seenRequestID = system.GetRequestID(req.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil)
+	req.Header.Set(system.RequestIDHeader, "bad id\nwith newline")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	assert.True(t, strings.HasPrefix(seenRequestID, system.RequestPrefix))
+	assert.Equal(t, seenRequestID, rec.Header().Get(system.RequestIDHeader))
+}
+
+func Test_accessLogMiddleware_RecordsOwner(t *testing.T) {
+	var entry *accessLogEntry
+	handler := accessLogMiddleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		entry = req.Context().Value(accessLogEntryContextKey{}).(*accessLogEntry)
+		// the auth middleware replaces the request so we only see the owner
+		// through the shared entry
+		setRequestUser(req.Context(), types.UserData{ID: "user_id"})
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil))
+
+	assert.Equal(t, "user_id", entry.owner)
+}
+
+func Test_errorResponseIncludesRequestID(t *testing.T) {
+	handler := accessLogMiddleware(http.HandlerFunc(system.Wrapper(func(res http.ResponseWriter, req *http.Request) (any, *system.HTTPError) {
+		return nil, system.NewHTTPError404("not found")
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/sessions/missing", nil)
+	req.Header.Set(system.RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "not found (request id: abc-123)\n", rec.Body.String())
+}
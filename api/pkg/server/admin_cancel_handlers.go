@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// cancelOwnerSessions godoc
+// @Summary Cancel everything an owner is running
+// @Description Cancel all of an owner's queued and running sessions, including text fine tunes that are preparing their data. Runners are told to stop the sessions they have picked up. For stopping runaway automation in an incident, admins only.
+// @Tags    admin
+
+// @Success 200 {object} types.OwnerCancelResult
+// @Param owner query string true "Owner ID"
+// @Param owner_type query string false "Owner type, defaults to user"
+// @Router /api/v1/admin/cancel [post]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) cancelOwnerSessions(res http.ResponseWriter, req *http.Request) (*types.OwnerCancelResult, *system.HTTPError) {
+	reqContext := apiServer.getRequestContext(req)
+	if !reqContext.Admin {
+		return nil, system.NewHTTPError403("only admins can cancel an owner's sessions")
+	}
+
+	owner := req.URL.Query().Get("owner")
+	if owner == "" {
+		return nil, system.NewHTTPError400("owner is required")
+	}
+
+	ownerType := types.OwnerType(req.URL.Query().Get("owner_type"))
+	if ownerType == "" {
+		ownerType = types.OwnerTypeUser
+	}
+	if ownerType != types.OwnerTypeUser {
+		return nil, system.NewHTTPError400("invalid owner_type '" + string(ownerType) + "'")
+	}
+
+	result, err := apiServer.Controller.CancelOwnerSessions(reqContext.Ctx, ownerType, owner)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	return result, nil
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newAdminCancelRequest(userID, query string) *http.Request {
+	req := httptest.NewRequest("POST", "/api/v1/admin/cancel?"+query, nil)
+	return req.WithContext(setRequestUser(req.Context(), types.UserData{ID: userID}))
+}
+
+func Test_cancelOwnerSessions_NotAdmin(t *testing.T) {
+	apiServer := &HelixAPIServer{adminAuth: newAdminAuth([]string{"admin_id"})}
+
+	_, httpErr := apiServer.cancelOwnerSessions(httptest.NewRecorder(), newAdminCancelRequest("user_id", "owner=user_id"))
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusForbidden, httpErr.StatusCode)
+}
+
+func Test_cancelOwnerSessions_BadRequest(t *testing.T) {
+	apiServer := &HelixAPIServer{adminAuth: newAdminAuth([]string{"admin_id"})}
+
+	for _, query := range []string{"", "owner_type=user", "owner=user_id&owner_type=team"} {
+		_, httpErr := apiServer.cancelOwnerSessions(httptest.NewRecorder(), newAdminCancelRequest("admin_id", query))
+		require.NotNil(t, httpErr, query)
+		assert.Equal(t, http.StatusBadRequest, httpErr.StatusCode, query)
+	}
+}
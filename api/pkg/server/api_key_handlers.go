@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// createAPIKey godoc
+// @Summary Create an API key
+// @Description Make a key to send as a bearer token instead of logging in. The key is only in this response, just a hash of it is kept so it can't be shown again.
+// @Tags    api-keys
+
+// @Success 200 {object} types.ApiKey
+// @Failure 409 {object} system.HTTPError "There is already a key with this name"
+// @Param request    body types.ApiKeyRequest true "The name of the key"
+// @Router /api/v1/api-keys [post]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) createAPIKey(res http.ResponseWriter, req *http.Request) (*types.ApiKey, *system.HTTPError) {
+	var keyReq types.ApiKeyRequest
+	err := json.NewDecoder(req.Body).Decode(&keyReq)
+	if err != nil {
+		return nil, system.NewHTTPError400("failed to decode request body, error: %s", err)
+	}
+	err = keyReq.Validate()
+	if err != nil {
+		return nil, system.NewHTTPError400(err.Error())
+	}
+
+	apiKey, err := apiServer.Controller.CreateAPIKey(apiServer.getRequestContext(req), keyReq.Name)
+	if errors.Is(err, store.ErrAlreadyExists) {
+		return nil, system.NewHTTPError409("there is already an API key named '" + keyReq.Name + "'")
+	}
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+	return apiKey, nil
+}
+
+// getAPIKeys godoc
+// @Summary List API keys
+// @Description The names of the keys of the logged in user, oldest first. The keys themselves aren't included. A user with no keys gets an empty list, a default key is no longer made for them.
+// @Tags    api-keys
+
+// @Success 200 {array} types.ApiKey
+// @Router /api/v1/api-keys [get]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) getAPIKeys(res http.ResponseWriter, req *http.Request) ([]*types.ApiKey, error) {
+	return apiServer.Controller.GetAPIKeys(apiServer.getRequestContext(req))
+}
+
+// deleteAPIKey godoc
+// @Summary Revoke an API key
+// @Description Requests made with the key are refused straight away.
+// @Tags    api-keys
+
+// @Success 200
+// @Param name path string true "API key name"
+// @Router /api/v1/api-keys/{name} [delete]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) deleteAPIKey(res http.ResponseWriter, req *http.Request) (string, *system.HTTPError) {
+	err := apiServer.Controller.DeleteAPIKey(apiServer.getRequestContext(req), mux.Vars(req)["name"])
+	if errors.Is(err, store.ErrNotFound) {
+		return "", system.NewHTTPError404("API key not found")
+	}
+	if err != nil {
+		return "", system.NewHTTPError500(err.Error())
+	}
+	return "", nil
+}
+
+func (apiServer *HelixAPIServer) checkAPIKey(res http.ResponseWriter, req *http.Request) (*types.ApiKey, error) {
+	apiKey := req.URL.Query().Get("key")
+	return apiServer.Controller.CheckAPIKey(apiServer.getRequestContext(req).Ctx, apiKey)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/controller"
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newAPIKeyTestServer(t *testing.T) (*HelixAPIServer, *store.MockStore) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	return &HelixAPIServer{
+		Store:      mockStore,
+		adminAuth:  newAdminAuth(nil),
+		Controller: &controller.Controller{Options: controller.ControllerOptions{Store: mockStore}},
+	}, mockStore
+}
+
+func newAPIKeyRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	return req.WithContext(setRequestUser(req.Context(), types.UserData{ID: "owner_id"}))
+}
+
+var apiKeyOwner = store.OwnerQuery{Owner: "owner_id", OwnerType: types.OwnerTypeUser}
+
+func Test_createAPIKey(t *testing.T) {
+	apiServer, mockStore := newAPIKeyTestServer(t)
+	mockStore.EXPECT().CreateAPIKey(gomock.Any(), apiKeyOwner, "ci").Return(&types.ApiKey{
+		Owner:     "owner_id",
+		OwnerType: types.OwnerTypeUser,
+		Key:       "hl-secret",
+		Name:      "ci",
+	}, nil)
+
+	apiKey, httpErr := apiServer.createAPIKey(httptest.NewRecorder(), newAPIKeyRequest("POST", "/api/v1/api-keys", `{"name": "ci"}`))
+	require.Nil(t, httpErr)
+	assert.Equal(t, "hl-secret", apiKey.Key)
+}
+
+func Test_createAPIKey_InvalidName(t *testing.T) {
+	apiServer, _ := newAPIKeyTestServer(t)
+
+	_, httpErr := apiServer.createAPIKey(httptest.NewRecorder(), newAPIKeyRequest("POST", "/api/v1/api-keys", `{"name": "my key"}`))
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusBadRequest, httpErr.StatusCode)
+}
+
+func Test_createAPIKey_NameTaken(t *testing.T) {
+	apiServer, mockStore := newAPIKeyTestServer(t)
+	mockStore.EXPECT().CreateAPIKey(gomock.Any(), apiKeyOwner, "ci").Return(nil, store.ErrAlreadyExists)
+
+	_, httpErr := apiServer.createAPIKey(httptest.NewRecorder(), newAPIKeyRequest("POST", "/api/v1/api-keys", `{"name": "ci"}`))
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusConflict, httpErr.StatusCode)
+}
+
+func Test_getAPIKeys_None(t *testing.T) {
+	apiServer, mockStore := newAPIKeyTestServer(t)
+	mockStore.EXPECT().GetAPIKeys(gomock.Any(), apiKeyOwner).Return(nil, nil)
+
+	apiKeys, err := apiServer.getAPIKeys(httptest.NewRecorder(), newAPIKeyRequest("GET", "/api/v1/api-keys", ""))
+	require.NoError(t, err)
+	// an empty list rather than null, and no key is made behind the user's back
+	assert.NotNil(t, apiKeys)
+	assert.Empty(t, apiKeys)
+}
+
+func Test_deleteAPIKey_NotFound(t *testing.T) {
+	apiServer, mockStore := newAPIKeyTestServer(t)
+	// the store only looks at the caller's own keys
+	mockStore.EXPECT().DeleteAPIKey(gomock.Any(), apiKeyOwner, "someone-elses").Return(store.ErrNotFound)
+
+	req := newAPIKeyRequest("DELETE", "/api/v1/api-keys/someone-elses", "")
+	req = mux.SetURLVars(req, map[string]string{"name": "someone-elses"})
+
+	_, httpErr := apiServer.deleteAPIKey(httptest.NewRecorder(), req)
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusNotFound, httpErr.StatusCode)
+}
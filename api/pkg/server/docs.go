@@ -117,6 +117,18 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Work out the changes to the actions without saving the tool",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Save even if actions are taken away from sessions the tool is bound to",
+                        "name": "force",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -271,10 +283,14 @@ const docTemplate = `{
         "types.MessageContentType": {
             "type": "string",
             "enum": [
-                "text"
+                "text",
+                "multimodal_text",
+                "image"
             ],
             "x-enum-varnames": [
-                "MessageContentTypeText"
+                "MessageContentTypeText",
+                "MessageContentTypeMultimodalText",
+                "MessageContentTypeImage"
             ]
         },
         "types.OpenAIMessage": {
@@ -348,11 +364,18 @@ const docTemplate = `{
         "types.SessionChatRequest": {
             "type": "object",
             "properties": {
+                "env": {
+                    "description": "env vars for the model process, the names must start with\nHELIX_SESSION_ - only applicable when starting a new session",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
                 "lora_dir": {
                     "type": "string"
                 },
                 "messages": {
-                    "description": "Initial messages",
+                    "description": "Initial messages, can be left out when starting a new session with a system prompt",
                     "type": "array",
                     "items": {
                         "$ref": "#/definitions/types.Message"
@@ -379,7 +402,7 @@ const docTemplate = `{
                     "type": "boolean"
                 },
                 "tools": {
-                    "description": "Available tools to use in the session",
+                    "description": "IDs or names of the caller's tools to bind to the session, only applicable when starting a new session",
                     "type": "array",
                     "items": {
                         "type": "string"
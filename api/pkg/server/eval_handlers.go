@@ -0,0 +1,278 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/helixml/helix/api/pkg/controller"
+	"github.com/helixml/helix/api/pkg/model"
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+const (
+	// the most prompts one batch request can run, bigger eval suites can be
+	// split over several requests with the same eval run ID
+	maxEvalBatchPrompts = 1000
+	// how many prompts of a batch run at once unless the request says
+	// otherwise, the runners still decide how many actually get a GPU
+	defaultEvalBatchConcurrency = 4
+	maxEvalBatchConcurrency     = 32
+)
+
+// scoreSessionUser godoc
+// @Summary Rate a session
+// @Description Set the user's own score for a session, from "0.0" to "1.0". An empty score clears the rating.
+// @Tags    evals
+
+// @Success 200 {object} types.Session
+// @Param request    body types.SessionEvalScore true "Score and reason"
+// @Param id path string true "Session ID"
+// @Router /api/v1/sessions/{id}/eval/user [put]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) scoreSessionUser(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
+	return apiServer.scoreSession(req, types.EvalScoreTypeUser)
+}
+
+// scoreSessionManual godoc
+// @Summary Review a session
+// @Description Set the reviewer's score for a session, from "0.0" to "1.0". An empty score clears the rating. Admin only.
+// @Tags    evals
+
+// @Success 200 {object} types.Session
+// @Param request    body types.SessionEvalScore true "Score and reason"
+// @Param id path string true "Session ID"
+// @Router /api/v1/sessions/{id}/eval/manual [put]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) scoreSessionManual(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
+	return apiServer.scoreSession(req, types.EvalScoreTypeManual)
+}
+
+func (apiServer *HelixAPIServer) scoreSession(req *http.Request, scoreType types.EvalScoreType) (*types.Session, *system.HTTPError) {
+	session, httpError := apiServer.sessionLoader(req, true)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	var score types.SessionEvalScore
+	err := json.NewDecoder(req.Body).Decode(&score)
+	if err != nil {
+		return nil, system.NewHTTPError400(err.Error())
+	}
+
+	err = types.ValidateEvalScore(score.Score)
+	if err != nil {
+		return nil, system.NewHTTPError400(err.Error())
+	}
+
+	return system.DefaultController(apiServer.Controller.ScoreSession(req.Context(), session, scoreType, score))
+}
+
+// scoreSessionAutomatic godoc
+// @Summary Automatically score a session
+// @Description Ask the eval judge model how well the session answers its original user prompts and save the result as the automatic score. Admin only.
+// @Tags    evals
+
+// @Success 200 {object} types.Session
+// @Failure 501 {object} system.HTTPError "There is no eval judge model configured"
+// @Param id path string true "Session ID"
+// @Router /api/v1/sessions/{id}/eval/automatic [post]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) scoreSessionAutomatic(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
+	session, httpError := apiServer.sessionLoader(req, true)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	if len(session.Metadata.EvalOriginalUserPrompts) == 0 {
+		return nil, system.NewHTTPError400("session %s has no original user prompts to score against", session.ID)
+	}
+
+	result, err := apiServer.Controller.AutomaticallyScoreSession(req.Context(), session)
+	if errors.Is(err, controller.ErrEvalsNotEnabled) {
+		return nil, &system.HTTPError{StatusCode: http.StatusNotImplemented, Message: err.Error()}
+	}
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+	return result, nil
+}
+
+// getEvalRunStats godoc
+// @Summary Eval run stats
+// @Description The number of sessions in an eval run with the count, mean and distribution of each kind of score. Unrated sessions are not counted. Admin only.
+// @Tags    evals
+
+// @Success 200 {object} types.EvalRunStats
+// @Param id path string true "Eval run ID"
+// @Router /api/v1/eval_runs/{id}/stats [get]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) getEvalRunStats(res http.ResponseWriter, req *http.Request) (*types.EvalRunStats, *system.HTTPError) {
+	evalRunID := mux.Vars(req)["id"]
+	if evalRunID == "" {
+		return nil, system.NewHTTPError400("eval run id is required")
+	}
+	return system.DefaultController(apiServer.Controller.GetEvalRunStats(req.Context(), evalRunID))
+}
+
+// runEvalBatch godoc
+// @Summary Run a batch of eval prompts
+// @Description Run each prompt in its own inference session of one eval run and wait for all the answers. Results are in the same order as the prompts, a prompt that fails has an error instead of a response and doesn't fail the rest. Admin only.
+// @Tags    evals
+
+// @Success 200 {object} types.EvalBatchResponse
+// @Param request    body types.EvalBatchRequest true "Model, prompts and an optional system prompt and eval run ID"
+// @Router /api/v1/eval_runs/batch [post]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) runEvalBatch(res http.ResponseWriter, req *http.Request) (*types.EvalBatchResponse, *system.HTTPError) {
+	var batchReq types.EvalBatchRequest
+	err := json.NewDecoder(io.LimitReader(req.Body, 10*MEGABYTE)).Decode(&batchReq)
+	if err != nil {
+		return nil, system.NewHTTPError400("invalid request body: " + err.Error())
+	}
+
+	httpError := validateEvalBatchRequest(&batchReq)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	userContext := apiServer.getRequestContext(req)
+	status, err := apiServer.Controller.GetStatus(userContext)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	if batchReq.EvalRunID == "" {
+		batchReq.EvalRunID = system.GenerateUUID()
+	}
+
+	results := make([]*types.EvalBatchResult, len(batchReq.Prompts))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchReq.Concurrency)
+	for i, prompt := range batchReq.Prompts {
+		select {
+		case sem <- struct{}{}:
+		case <-req.Context().Done():
+			results[i] = &types.EvalBatchResult{Error: req.Context().Err().Error()}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, prompt string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = apiServer.runEvalBatchPrompt(userContext, &batchReq, status.Config.StripeSubscriptionActive, prompt)
+		}(i, prompt)
+	}
+	wg.Wait()
+
+	return &types.EvalBatchResponse{
+		EvalRunID: batchReq.EvalRunID,
+		Results:   results,
+	}, nil
+}
+
+// check the request and fill in the defaults - the model is resolved to
+// its full name like chat sessions do
+func validateEvalBatchRequest(batchReq *types.EvalBatchRequest) *system.HTTPError {
+	if len(batchReq.Prompts) == 0 {
+		return system.NewHTTPError400("prompts must not be empty")
+	}
+	if len(batchReq.Prompts) > maxEvalBatchPrompts {
+		return system.NewHTTPError400("a batch can have at most %d prompts, got %d", maxEvalBatchPrompts, len(batchReq.Prompts))
+	}
+
+	if batchReq.Concurrency < 0 || batchReq.Concurrency > maxEvalBatchConcurrency {
+		return system.NewHTTPError400("concurrency must be between 0 and %d", maxEvalBatchConcurrency)
+	}
+	if batchReq.Concurrency == 0 {
+		batchReq.Concurrency = defaultEvalBatchConcurrency
+	}
+
+	if batchReq.Model == "" {
+		batchReq.Model = model.DefaultModelAlias
+	}
+	err := model.ValidateSessionRequest(types.SessionModeInference, types.SessionTypeText, types.ModelName(batchReq.Model))
+	if errors.Is(err, model.ErrUnknownModel) {
+		return system.NewHTTPError400(err.Error()).WithCode(system.HTTPErrorCodeModelNotFound)
+	}
+	if err != nil {
+		return system.NewHTTPError400(err.Error())
+	}
+	modelName, err := model.ResolveModelName(batchReq.Model)
+	if err != nil {
+		return system.NewHTTPError400(err.Error())
+	}
+	batchReq.Model = string(modelName)
+
+	err = batchReq.SamplingParams.Validate()
+	if err != nil {
+		return system.NewHTTPError400(err.Error())
+	}
+	err = batchReq.SamplingParams.ValidateRuntime(modelName.InferenceRuntime())
+	if err != nil {
+		return system.NewHTTPError400(err.Error())
+	}
+
+	return nil
+}
+
+func (apiServer *HelixAPIServer) runEvalBatchPrompt(userContext types.RequestContext, batchReq *types.EvalBatchRequest, priority bool, prompt string) *types.EvalBatchResult {
+	sessionID := system.GenerateSessionID()
+	now := time.Now()
+
+	newSession := types.CreateSessionRequest{
+		SessionID:    sessionID,
+		SessionMode:  types.SessionModeInference,
+		SessionType:  types.SessionTypeText,
+		SystemPrompt: batchReq.SystemPrompt,
+		ModelName:    types.ModelName(batchReq.Model),
+		Owner:        userContext.Owner,
+		OwnerType:    userContext.OwnerType,
+		UserInteractions: []*types.Interaction{
+			{
+				ID:             system.GenerateUUID(),
+				Created:        now,
+				Updated:        now,
+				Scheduled:      now,
+				Completed:      now,
+				Creator:        types.CreatorTypeUser,
+				Mode:           types.SessionModeInference,
+				Message:        prompt,
+				Files:          []string{},
+				State:          types.InteractionStateComplete,
+				Finished:       true,
+				Metadata:       map[string]string{},
+				DataPrepChunks: map[string][]types.DataPrepChunk{},
+			},
+		},
+		Priority:                priority,
+		Sampling:                batchReq.SamplingParams,
+		EvalRunID:               batchReq.EvalRunID,
+		EvalOriginalUserPrompts: []string{prompt},
+	}
+
+	interaction, err := apiServer.runSessionToCompletion(userContext.Ctx, userContext.Owner, &startSessionConfig{
+		sessionID: sessionID,
+		modelName: batchReq.Model,
+		start: func() error {
+			_, err := apiServer.Controller.CreateSession(userContext, newSession)
+			return err
+		},
+	})
+	if err != nil {
+		return &types.EvalBatchResult{SessionID: sessionID, Error: err.Error()}
+	}
+	if interaction.State == types.InteractionStateError {
+		return &types.EvalBatchResult{SessionID: sessionID, Error: fmt.Sprintf("session failed: %s", interaction.Error)}
+	}
+
+	return &types.EvalBatchResult{SessionID: sessionID, Response: interaction.Message}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/config"
+	"github.com/helixml/helix/api/pkg/controller"
+	"github.com/helixml/helix/api/pkg/dataprep/text"
+	"github.com/helixml/helix/api/pkg/filestore"
+	"github.com/helixml/helix/api/pkg/janitor"
+	"github.com/helixml/helix/api/pkg/pubsub"
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newEvalTestServer(t *testing.T, session *types.Session) (*HelixAPIServer, *store.MockStore) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	mockStore.EXPECT().GetSession(gomock.Any(), session.ID).Return(session, nil).AnyTimes()
+
+	return &HelixAPIServer{
+		Store:      mockStore,
+		adminAuth:  newAdminAuth([]string{"admin_id"}),
+		Controller: &controller.Controller{Options: controller.ControllerOptions{Store: mockStore}},
+	}, mockStore
+}
+
+// a controller made the way the server makes one so the sessions the
+// handlers create can go on the queue
+func newTestController(t *testing.T, cfg *config.ServerConfig, st store.Store) *controller.Controller {
+	c, err := controller.NewController(context.Background(), controller.ControllerOptions{
+		Config:    cfg,
+		Store:     st,
+		Janitor:   janitor.NewJanitor(janitor.JanitorOptions{}),
+		Filestore: filestore.NewFileSystemStorage(t.TempDir(), "", ""),
+		DataPrepTextFactory: func(session *types.Session) (text.DataPrepTextQuestionGenerator, *text.DataPrepTextSplitter, error) {
+			return nil, nil, nil
+		},
+	})
+	require.NoError(t, err)
+
+	// nobody is connected to hear about queue positions, we drain our own
+	// reference so a test can swap the field without racing us
+	events := make(chan *types.WebsocketEvent)
+	c.UserWebsocketEventChanWriter = events
+	go func() {
+		for range events {
+		}
+	}()
+
+	return c
+}
+
+func newEvalRequest(method string, userID string, body string) *http.Request {
+	req := httptest.NewRequest(method, "/api/v1/sessions/session_id/eval", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "session_id"})
+	return req.WithContext(setRequestUser(req.Context(), types.UserData{ID: userID}))
+}
+
+func Test_scoreSessionUser(t *testing.T) {
+	session := &types.Session{ID: "session_id", Owner: "owner_id", OwnerType: types.OwnerTypeUser}
+	apiServer, mockStore := newEvalTestServer(t, session)
+
+	mockStore.EXPECT().UpdateSession(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, session types.Session) (*types.Session, error) {
+		return &session, nil
+	})
+
+	updated, httpErr := apiServer.scoreSessionUser(httptest.NewRecorder(), newEvalRequest("PUT", "owner_id", `{"score": "0.0", "reason": "wrong answer"}`))
+	require.Nil(t, httpErr)
+	assert.Equal(t, "0.0", updated.Metadata.EvalUserScore)
+	assert.Equal(t, "wrong answer", updated.Metadata.EvalUserReason)
+
+	_, httpErr = apiServer.scoreSessionUser(httptest.NewRecorder(), newEvalRequest("PUT", "owner_id", `{"score": "1.5"}`))
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusBadRequest, httpErr.StatusCode)
+
+	_, httpErr = apiServer.scoreSessionUser(httptest.NewRecorder(), newEvalRequest("PUT", "other_id", `{"score": "1.0"}`))
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusForbidden, httpErr.StatusCode)
+}
+
+func Test_scoreSessionAutomatic_NotEnabled(t *testing.T) {
+	session := &types.Session{
+		ID:        "session_id",
+		Owner:     "owner_id",
+		OwnerType: types.OwnerTypeUser,
+		Metadata:  types.SessionMetadata{EvalOriginalUserPrompts: []string{"hello"}},
+	}
+	apiServer, _ := newEvalTestServer(t, session)
+
+	_, httpErr := apiServer.scoreSessionAutomatic(httptest.NewRecorder(), newEvalRequest("POST", "admin_id", ""))
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusNotImplemented, httpErr.StatusCode)
+}
+
+func Test_getEvalRunStats(t *testing.T) {
+	apiServer, mockStore := newEvalTestServer(t, &types.Session{ID: "session_id"})
+
+	mockStore.EXPECT().ListEvalRunSessions(gomock.Any(), "run-1").Return([]*types.Session{
+		{Metadata: types.SessionMetadata{EvalRunId: "run-1", EvalManualScore: "1.0"}},
+		{Metadata: types.SessionMetadata{EvalRunId: "run-1"}},
+	}, nil)
+
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/api/v1/eval_runs/run-1/stats", nil), map[string]string{"id": "run-1"})
+	stats, httpErr := apiServer.getEvalRunStats(httptest.NewRecorder(), req)
+	require.Nil(t, httpErr)
+	assert.Equal(t, 2, stats.Sessions)
+	assert.Equal(t, 1, stats.Manual.Count)
+	assert.Equal(t, 0, stats.User.Count)
+}
+
+func Test_runEvalBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	ps, err := pubsub.New()
+	require.NoError(t, err)
+
+	cfg := &config.ServerConfig{}
+	cfg.Tools.Enabled = false
+
+	apiServer := &HelixAPIServer{
+		pubsub:     ps,
+		Controller: newTestController(t, cfg, mockStore),
+		adminAuth:  newAdminAuth([]string{"admin_id"}),
+	}
+
+	mockStore.EXPECT().GetUserMeta(gomock.Any(), "admin_id").Return(&types.UserMeta{}, nil)
+
+	var (
+		mu       sync.Mutex
+		sessions []types.Session
+	)
+	mockStore.EXPECT().CreateSession(gomock.Any(), gomock.Any()).Times(3).
+		DoAndReturn(func(ctx context.Context, session types.Session) (*types.Session, error) {
+			mu.Lock()
+			sessions = append(sessions, session)
+			mu.Unlock()
+
+			prompt := session.Interactions[0].Message
+			result := &types.Interaction{State: types.InteractionStateComplete, Message: "answer to " + prompt}
+			if prompt == "broken" {
+				result = &types.Interaction{State: types.InteractionStateError, Error: "out of memory"}
+			}
+			bts, err := json.Marshal(&types.WebsocketEvent{
+				Type:    "session_update",
+				Session: &types.Session{ID: session.ID, Interactions: []*types.Interaction{result}},
+			})
+			require.NoError(t, err)
+
+			time.AfterFunc(50*time.Millisecond, func() {
+				_ = ps.Publish(context.Background(), pubsub.GetSessionQueue("admin_id", session.ID), bts)
+			})
+
+			return &session, nil
+		})
+
+	req := httptest.NewRequest("POST", "/api/v1/eval_runs/batch", strings.NewReader(`{
+		"eval_run_id": "run-1",
+		"model": "mistralai/Mistral-7B-Instruct-v0.1",
+		"system": "Answer briefly.",
+		"prompts": ["one", "broken", "three"],
+		"concurrency": 2
+	}`))
+	req = req.WithContext(setRequestUser(req.Context(), types.UserData{ID: "admin_id"}))
+
+	resp, httpErr := apiServer.runEvalBatch(httptest.NewRecorder(), req)
+	require.Nil(t, httpErr)
+	assert.Equal(t, "run-1", resp.EvalRunID)
+
+	// the results line up with the prompts and a failed prompt doesn't
+	// fail the batch
+	require.Len(t, resp.Results, 3)
+	assert.Equal(t, "answer to one", resp.Results[0].Response)
+	assert.Equal(t, "session failed: out of memory", resp.Results[1].Error)
+	assert.Empty(t, resp.Results[1].Response)
+	assert.Equal(t, "answer to three", resp.Results[2].Response)
+
+	require.Len(t, sessions, 3)
+	for _, session := range sessions {
+		assert.Equal(t, "run-1", session.Metadata.EvalRunId)
+		assert.Equal(t, []string{session.Interactions[0].Message}, session.Metadata.EvalOriginalUserPrompts)
+		assert.Equal(t, "Answer briefly.", session.Metadata.SystemPrompt)
+		assert.Equal(t, "admin_id", session.Owner)
+	}
+}
+
+func Test_validateEvalBatchRequest(t *testing.T) {
+	batchReq := &types.EvalBatchRequest{Prompts: []string{"hello"}}
+	require.Nil(t, validateEvalBatchRequest(batchReq))
+	assert.Equal(t, defaultEvalBatchConcurrency, batchReq.Concurrency)
+	assert.NotEmpty(t, batchReq.Model)
+
+	httpErr := validateEvalBatchRequest(&types.EvalBatchRequest{})
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusBadRequest, httpErr.StatusCode)
+
+	httpErr = validateEvalBatchRequest(&types.EvalBatchRequest{Prompts: []string{"hello"}, Concurrency: maxEvalBatchConcurrency + 1})
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusBadRequest, httpErr.StatusCode)
+
+	httpErr = validateEvalBatchRequest(&types.EvalBatchRequest{Prompts: []string{"hello"}, Model: "no-such-model"})
+	require.NotNil(t, httpErr)
+	assert.Equal(t, system.HTTPErrorCodeModelNotFound, httpErr.Code)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// setInteractionFeedback godoc
+// @Summary Rate an answer
+// @Description Give a thumbs up or down with an optional comment on one of the model's finished answers in a session. An empty rating clears it. This is feedback from users and separate from the eval scores.
+// @Tags    sessions
+
+// @Success 200 {object} types.Session
+// @Param request    body types.InteractionFeedbackRequest true "Rating and comment"
+// @Param id path string true "Session ID"
+// @Param iid path string true "Interaction ID"
+// @Router /api/v1/sessions/{id}/interactions/{iid}/feedback [post]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) setInteractionFeedback(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
+	session, httpError := apiServer.sessionLoader(req, true)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	interaction, err := data.GetInteraction(session, mux.Vars(req)["iid"])
+	if err != nil {
+		return nil, system.NewHTTPError404(err.Error())
+	}
+	if interaction.Creator != types.CreatorTypeSystem {
+		return nil, system.NewHTTPError400("interaction %s is not an answer from the model", interaction.ID)
+	}
+	if !interaction.Finished {
+		return nil, system.NewHTTPError400("interaction %s has not finished", interaction.ID)
+	}
+
+	var feedbackReq types.InteractionFeedbackRequest
+	err = json.NewDecoder(io.LimitReader(req.Body, MEGABYTE)).Decode(&feedbackReq)
+	if err != nil {
+		return nil, system.NewHTTPError400("failed to decode request body, error: %s", err)
+	}
+
+	err = types.ValidateFeedbackRating(feedbackReq.Rating)
+	if err != nil {
+		return nil, system.NewHTTPError400(err.Error())
+	}
+
+	return system.DefaultController(apiServer.Controller.SetInteractionFeedback(apiServer.getRequestContext(req), session, interaction.ID, feedbackReq))
+}
+
+// getSessionFeedbackStats godoc
+// @Summary Session feedback
+// @Description How many of the session's answers were rated up or down and how many ratings have a comment.
+// @Tags    sessions
+
+// @Success 200 {object} types.SessionFeedbackStats
+// @Param id path string true "Session ID"
+// @Router /api/v1/sessions/{id}/feedback [get]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) getSessionFeedbackStats(res http.ResponseWriter, req *http.Request) (*types.SessionFeedbackStats, *system.HTTPError) {
+	session, httpError := apiServer.sessionLoader(req, false)
+	if httpError != nil {
+		return nil, httpError
+	}
+	return apiServer.Controller.GetSessionFeedbackStats(session), nil
+}
+
+// getFeedbackStats godoc
+// @Summary Feedback stats
+// @Description The feedback on the answers of the user's sessions, in total and for each model. Compare time windows to spot a model getting worse.
+// @Tags    sessions
+
+// @Success 200 {object} types.FeedbackStats
+// @Param model query string false "Only sessions with this model"
+// @Param after query string false "Only feedback given after this time (RFC3339)"
+// @Param before query string false "Only feedback given before this time (RFC3339)"
+// @Router /api/v1/feedback/stats [get]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) getFeedbackStats(res http.ResponseWriter, req *http.Request) (*types.FeedbackStats, *system.HTTPError) {
+	reqContext := apiServer.getRequestContext(req)
+	query := store.ListFeedbackSessionsQuery{
+		Owner:     reqContext.Owner,
+		OwnerType: reqContext.OwnerType,
+	}
+	return apiServer.feedbackStats(req, query)
+}
+
+// getAllFeedbackStats godoc
+// @Summary Feedback stats for everyone
+// @Description The feedback on the answers of every user's sessions, or just one owner's, in total and for each model. Admin only.
+// @Tags    sessions
+
+// @Success 200 {object} types.FeedbackStats
+// @Param owner query string false "Only this owner's sessions"
+// @Param owner_type query string false "Owner type, defaults to user"
+// @Param model query string false "Only sessions with this model"
+// @Param after query string false "Only feedback given after this time (RFC3339)"
+// @Param before query string false "Only feedback given before this time (RFC3339)"
+// @Router /api/v1/admin/feedback/stats [get]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) getAllFeedbackStats(res http.ResponseWriter, req *http.Request) (*types.FeedbackStats, *system.HTTPError) {
+	query := store.ListFeedbackSessionsQuery{
+		Owner: req.URL.Query().Get("owner"),
+	}
+	if query.Owner != "" {
+		query.OwnerType = types.OwnerType(req.URL.Query().Get("owner_type"))
+		if query.OwnerType == "" {
+			query.OwnerType = types.OwnerTypeUser
+		}
+		if query.OwnerType != types.OwnerTypeUser {
+			return nil, system.NewHTTPError400("invalid owner_type '" + string(query.OwnerType) + "'")
+		}
+	}
+	return apiServer.feedbackStats(req, query)
+}
+
+func (apiServer *HelixAPIServer) feedbackStats(req *http.Request, query store.ListFeedbackSessionsQuery) (*types.FeedbackStats, *system.HTTPError) {
+	query.ModelName = types.ModelName(req.URL.Query().Get("model"))
+
+	httpErr := parseTimeWindowParams(req, &query.After, &query.Before)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	return system.DefaultController(apiServer.Controller.GetFeedbackStats(req.Context(), query))
+}
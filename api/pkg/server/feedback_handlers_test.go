@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newFeedbackRequest(userID string, interactionID string, body string) *http.Request {
+	req := httptest.NewRequest("POST", "/api/v1/sessions/session_id/interactions/"+interactionID+"/feedback", strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "session_id", "iid": interactionID})
+	return req.WithContext(setRequestUser(req.Context(), types.UserData{ID: userID}))
+}
+
+func Test_setInteractionFeedback(t *testing.T) {
+	session := newEditInteractionSession(types.InteractionStateComplete)
+	session.Interactions[1].Finished = true
+	apiServer, mockStore := newEvalTestServer(t, session)
+
+	mockStore.EXPECT().UpdateSession(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, session types.Session) (*types.Session, error) {
+		return &session, nil
+	}).Times(2)
+
+	updated, httpErr := apiServer.setInteractionFeedback(httptest.NewRecorder(), newFeedbackRequest("owner_id", "system-1", `{"rating": "down", "comment": " wrong "}`))
+	require.Nil(t, httpErr)
+	feedback := updated.Interactions[1].Feedback
+	require.NotNil(t, feedback)
+	assert.Equal(t, types.FeedbackRatingDown, feedback.Rating)
+	assert.Equal(t, "wrong", feedback.Comment)
+	assert.Equal(t, "owner_id", feedback.Rater)
+	assert.False(t, feedback.Created.IsZero())
+
+	updated, httpErr = apiServer.setInteractionFeedback(httptest.NewRecorder(), newFeedbackRequest("owner_id", "system-1", `{"rating": ""}`))
+	require.Nil(t, httpErr)
+	assert.Nil(t, updated.Interactions[1].Feedback)
+}
+
+func Test_setInteractionFeedback_Invalid(t *testing.T) {
+	session := newEditInteractionSession(types.InteractionStateWaiting)
+	apiServer, _ := newEvalTestServer(t, session)
+
+	tests := []struct {
+		name          string
+		userID        string
+		interactionID string
+		body          string
+		status        int
+	}{
+		{"not the owner", "other_id", "system-1", `{"rating": "up"}`, http.StatusForbidden},
+		{"unknown interaction", "owner_id", "missing", `{"rating": "up"}`, http.StatusNotFound},
+		{"user interaction", "owner_id", "user-1", `{"rating": "up"}`, http.StatusBadRequest},
+		{"not finished", "owner_id", "system-1", `{"rating": "up"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, httpErr := apiServer.setInteractionFeedback(httptest.NewRecorder(), newFeedbackRequest(tt.userID, tt.interactionID, tt.body))
+			require.NotNil(t, httpErr)
+			assert.Equal(t, tt.status, httpErr.StatusCode)
+		})
+	}
+
+	session.Interactions[1].Finished = true
+	_, httpErr := apiServer.setInteractionFeedback(httptest.NewRecorder(), newFeedbackRequest("owner_id", "system-1", `{"rating": "meh"}`))
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusBadRequest, httpErr.StatusCode)
+}
+
+func Test_getFeedbackStats(t *testing.T) {
+	apiServer, mockStore := newEvalTestServer(t, &types.Session{ID: "session_id"})
+
+	mockStore.EXPECT().ListFeedbackSessions(gomock.Any(), store.ListFeedbackSessionsQuery{
+		Owner:     "owner_id",
+		OwnerType: types.OwnerTypeUser,
+		ModelName: types.Model_Ollama_Llama3_8b,
+	}).Return([]*types.Session{
+		{
+			ModelName: types.Model_Ollama_Llama3_8b,
+			Interactions: []*types.Interaction{
+				{Creator: types.CreatorTypeSystem, Feedback: &types.InteractionFeedback{Rating: types.FeedbackRatingDown}},
+			},
+		},
+	}, nil)
+
+	req := httptest.NewRequest("GET", "/api/v1/feedback/stats?model="+string(types.Model_Ollama_Llama3_8b), nil)
+	req = req.WithContext(setRequestUser(req.Context(), types.UserData{ID: "owner_id"}))
+	stats, httpErr := apiServer.getFeedbackStats(httptest.NewRecorder(), req)
+	require.Nil(t, httpErr)
+	assert.Equal(t, 1, stats.Sessions)
+	assert.Equal(t, types.FeedbackCounts{Down: 1}, stats.Models[types.Model_Ollama_Llama3_8b])
+
+	req = httptest.NewRequest("GET", "/api/v1/feedback/stats?after=yesterday", nil)
+	req = req.WithContext(setRequestUser(req.Context(), types.UserData{ID: "owner_id"}))
+	_, httpErr = apiServer.getFeedbackStats(httptest.NewRecorder(), req)
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusBadRequest, httpErr.StatusCode)
+}
@@ -4,8 +4,10 @@ import (
 	"archive/tar"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -18,6 +20,7 @@ import (
 	"github.com/helixml/helix/api/pkg/controller"
 	"github.com/helixml/helix/api/pkg/data"
 	"github.com/helixml/helix/api/pkg/filestore"
+	"github.com/helixml/helix/api/pkg/model"
 	"github.com/helixml/helix/api/pkg/store"
 	"github.com/helixml/helix/api/pkg/system"
 	"github.com/helixml/helix/api/pkg/types"
@@ -48,6 +51,12 @@ func (apiServer *HelixAPIServer) sessionLoaderWithID(req *http.Request, id strin
 	if !canSee {
 		return nil, system.NewHTTPError403(fmt.Sprintf("access denied for session id %s", id))
 	}
+
+	// shared sessions are readable by anyone so keep a record of who looked
+	if !writeMode && !apiServer.canEditSession(reqContext, session) {
+		logSharedSessionAccess(req, session, reqContext.Owner)
+	}
+
 	return session, nil
 }
 
@@ -56,7 +65,18 @@ func (apiServer *HelixAPIServer) sessionLoader(req *http.Request, writeMode bool
 }
 
 func (apiServer *HelixAPIServer) getSession(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
-	return apiServer.sessionLoader(req, false)
+	session, httpError := apiServer.sessionLoader(req, false)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	tools, err := apiServer.Store.ListSessionTools(req.Context(), session.ID)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+	session.Tools = tools
+
+	return session, nil
 }
 
 func (apiServer *HelixAPIServer) getSessionSummary(res http.ResponseWriter, req *http.Request) (*types.SessionSummary, *system.HTTPError) {
@@ -64,10 +84,15 @@ func (apiServer *HelixAPIServer) getSessionSummary(res http.ResponseWriter, req
 	if err != nil {
 		return nil, err
 	}
-	return system.DefaultController(data.GetSessionSummary(session))
+	summary, summaryErr := data.GetSessionSummary(session)
+	if summaryErr != nil {
+		return nil, system.NewHTTPError500(summaryErr.Error())
+	}
+	apiServer.Controller.AddQueuePositions([]*types.SessionSummary{summary})
+	return summary, nil
 }
 
-func (apiServer *HelixAPIServer) getSessions(res http.ResponseWriter, req *http.Request) (*types.SessionsList, error) {
+func (apiServer *HelixAPIServer) getSessions(res http.ResponseWriter, req *http.Request) (*types.SessionsList, *system.HTTPError) {
 	reqContext := apiServer.getRequestContext(req)
 	query := store.GetSessionsQuery{}
 	query.Owner = reqContext.Owner
@@ -90,25 +115,32 @@ func (apiServer *HelixAPIServer) getSessions(res http.ResponseWriter, req *http.
 
 	query.Offset = offset
 	query.Limit = limit
+	query.Search = strings.TrimSpace(req.URL.Query().Get("search"))
+
+	query.Tags, query.TagsMatchAll, err = getSessionsTagsFilter(req)
+	if err != nil {
+		return nil, system.NewHTTPError400(err.Error())
+	}
 
 	sessions, err := apiServer.Store.GetSessions(reqContext.Ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, system.NewHTTPError500(err.Error())
 	}
 
 	counter, err := apiServer.Store.GetSessionsCounter(reqContext.Ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, system.NewHTTPError500(err.Error())
 	}
 
 	sessionSummaries := []*types.SessionSummary{}
 	for _, session := range sessions {
 		summary, err := data.GetSessionSummary(session)
 		if err != nil {
-			return nil, err
+			return nil, system.NewHTTPError500(err.Error())
 		}
 		sessionSummaries = append(sessionSummaries, summary)
 	}
+	apiServer.Controller.AddQueuePositions(sessionSummaries)
 
 	return &types.SessionsList{
 		Sessions: sessionSummaries,
@@ -116,25 +148,43 @@ func (apiServer *HelixAPIServer) getSessions(res http.ResponseWriter, req *http.
 	}, nil
 }
 
-func (apiServer *HelixAPIServer) createSession(res http.ResponseWriter, req *http.Request) (*types.Session, error) {
-	reqContext := apiServer.getRequestContext(req)
-
-	// now upload any files that were included
-	err := req.ParseMultipartForm(10 << 20)
+// export the conversation in a session as OpenAI messages (the default)
+// or as a ShareGPT conversation with ?format=sharegpt
+func (apiServer *HelixAPIServer) exportSession(res http.ResponseWriter, req *http.Request) (any, *system.HTTPError) {
+	format, err := types.ValidateSessionExportFormat(req.URL.Query().Get("format"), true)
 	if err != nil {
-		return nil, err
+		return nil, system.NewHTTPError400(err.Error())
 	}
 
-	sessionMode, err := types.ValidateSessionMode(req.FormValue("mode"), false)
-	if err != nil {
-		return nil, err
+	session, httpError := apiServer.sessionLoader(req, false)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	return sessionExport(session, format), nil
+}
+
+func sessionExport(session *types.Session, format types.SessionExportFormat) any {
+	switch format {
+	case types.SessionExportFormatShareGPT:
+		return data.SessionToShareGPTExport(session)
+	default:
+		return data.SessionToOpenAIExport(session)
 	}
+}
+
+func (apiServer *HelixAPIServer) createSession(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
+	reqContext := apiServer.getRequestContext(req)
 
-	sessionType, err := types.ValidateSessionType(req.FormValue("type"), false)
+	// now upload any files that were included
+	err := req.ParseMultipartForm(10 << 20)
 	if err != nil {
-		return nil, err
+		return nil, system.NewHTTPError400(err.Error())
 	}
 
+	sessionMode := types.SessionMode(req.FormValue("mode"))
+	sessionType := types.SessionType(req.FormValue("type"))
+
 	var modelName types.ModelName
 	switch sessionType {
 	case types.SessionTypeText:
@@ -143,21 +193,41 @@ func (apiServer *HelixAPIServer) createSession(res http.ResponseWriter, req *htt
 		modelName = types.Model_Axolotl_SDXL
 	}
 
-	sessionID := system.GenerateUUID()
+	err = model.ValidateSessionRequest(sessionMode, sessionType, modelName)
+	if err != nil {
+		return nil, system.NewHTTPError400(err.Error())
+	}
 
-	// the user interaction is the request from the user
-	userInteraction, err := apiServer.getUserInteractionFromForm(req, sessionID, sessionMode, "")
+	dataPrepChunkSize, dataPrepConcurrency, err := getDataPrepSettingsFromForm(req)
 	if err != nil {
-		return nil, err
+		return nil, system.NewHTTPError400(err.Error())
 	}
-	if userInteraction == nil {
-		return nil, fmt.Errorf("no interaction found")
+	err = apiServer.Controller.ValidateDataPrepSettings(dataPrepChunkSize, dataPrepConcurrency)
+	if err != nil {
+		return nil, system.NewHTTPError400(err.Error())
 	}
 
+	sessionID := system.GenerateUUID()
+
 	userContext := apiServer.getRequestContext(req)
 	status, err := apiServer.Controller.GetStatus(userContext)
 	if err != nil {
-		return nil, err
+		return nil, system.NewHTTPError(err)
+	}
+
+	maxFileSize := apiServer.Controller.GetMaxSessionFileSize(status.Config)
+	httpError := checkUploadFileSize(req, nil, maxFileSize)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	// the user interaction is the request from the user
+	userInteraction, err := apiServer.getUserInteractionFromForm(req, sessionID, sessionMode, "")
+	if err != nil {
+		return nil, system.NewHTTPError(err)
+	}
+	if userInteraction == nil {
+		return nil, system.NewHTTPError400("no interaction found")
 	}
 	sessionData, err := apiServer.Controller.CreateSession(userContext, types.CreateSessionRequest{
 		SessionID:               sessionID,
@@ -169,16 +239,50 @@ func (apiServer *HelixAPIServer) createSession(res http.ResponseWriter, req *htt
 		UserInteractions:        []*types.Interaction{userInteraction},
 		Priority:                status.Config.StripeSubscriptionActive,
 		ManuallyReviewQuestions: req.FormValue("manuallyReviewQuestions") == "yes",
+		Sensitive:               req.FormValue("sensitive") == "yes",
+		SpanDocuments:           req.FormValue("spanDocuments") == "yes",
+		DataPrepChunkSize:       dataPrepChunkSize,
+		DataPrepConcurrency:     dataPrepConcurrency,
 		ParentSession:           req.FormValue("parent_session"),
+		MaxFileSize:             maxFileSize,
 	})
+	if httpError := sessionQuotaHTTPError(err); httpError != nil {
+		return nil, httpError
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("failed to start session")
-		return nil, err
+		return nil, system.NewHTTPError(err)
 	}
 
 	return sessionData, nil
 }
 
+// the data prep chunk size and concurrency a text fine tune asks for, zero
+// if it leaves them to the server
+func getDataPrepSettingsFromForm(req *http.Request) (int, int, error) {
+	chunkSize, err := getFormInt(req, "dataPrepChunkSize")
+	if err != nil {
+		return 0, 0, err
+	}
+	concurrency, err := getFormInt(req, "dataPrepConcurrency")
+	if err != nil {
+		return 0, 0, err
+	}
+	return chunkSize, concurrency, nil
+}
+
+func getFormInt(req *http.Request, field string) (int, error) {
+	value := req.FormValue(field)
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s'", field, value)
+	}
+	return parsed, nil
+}
+
 func (apiServer *HelixAPIServer) updateSession(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
 	session, httpError := apiServer.sessionLoader(req, true)
 	if httpError != nil {
@@ -191,6 +295,15 @@ func (apiServer *HelixAPIServer) updateSession(res http.ResponseWriter, req *htt
 		return nil, system.NewHTTPError400(err.Error())
 	}
 
+	maxFileSize, err := apiServer.getMaxSessionFileSize(req.Context(), session.Owner)
+	if err != nil {
+		return nil, system.NewHTTPError(err)
+	}
+	httpError = checkUploadFileSize(req, session.Interactions, maxFileSize)
+	if httpError != nil {
+		return nil, httpError
+	}
+
 	userInteraction, err := apiServer.getUserInteractionFromForm(req, session.ID, session.Mode, "")
 	if err != nil {
 		return nil, system.NewHTTPError(err)
@@ -204,6 +317,9 @@ func (apiServer *HelixAPIServer) updateSession(res http.ResponseWriter, req *htt
 		UserInteraction: userInteraction,
 		SessionMode:     session.Mode,
 	})
+	if httpError := sessionQuotaHTTPError(err); httpError != nil {
+		return nil, httpError
+	}
 	if err != nil {
 		return nil, system.NewHTTPError500("failed to update session: %s", err)
 	}
@@ -227,6 +343,13 @@ func (apiServer *HelixAPIServer) updateSessionConfig(res http.ResponseWriter, re
 		return nil, system.NewHTTPError400(err.Error())
 	}
 
+	if data.WebhookURL != "" {
+		err = types.ValidateWebhookURL(data.WebhookURL)
+		if err != nil {
+			return nil, system.NewHTTPError400(err.Error())
+		}
+	}
+
 	result, err := apiServer.Controller.UpdateSessionMetadata(reqContext.Ctx, session, data)
 	if err != nil {
 		return nil, system.NewHTTPError(err)
@@ -348,11 +471,21 @@ func (apiServer *HelixAPIServer) runnerSessionDownloadFile(res http.ResponseWrit
 		if err != nil {
 			return err
 		}
+		if closer, ok := stream.(io.Closer); ok {
+			defer closer.Close()
+		}
 
 		// Set the appropriate mime-type headers
 		res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
 		res.Header().Set("Content-Type", http.DetectContentType([]byte(filename)))
 
+		// if we can seek then we can serve range requests which lets
+		// runners resume big downloads that got cut off
+		if seeker, ok := stream.(io.ReadSeeker); ok {
+			http.ServeContent(res, req, filename, time.Time{}, seeker)
+			return nil
+		}
+
 		// Write the file to the http.ResponseWriter
 		_, err = io.Copy(res, stream)
 		if err != nil {
@@ -432,6 +565,26 @@ func (apiServer *HelixAPIServer) runnerSessionUploadFiles(res http.ResponseWrite
 	result := []filestore.FileStoreItem{}
 	files := req.MultipartForm.File["files"]
 
+	// check everything arrived intact before we upload any of it
+	checksums := map[string]string{}
+	if checksumsJSON := req.MultipartForm.Value[system.UploadChecksumsField]; len(checksumsJSON) > 0 {
+		err = json.Unmarshal([]byte(checksumsJSON[0]), &checksums)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checksums: %s", err.Error())
+		}
+	}
+
+	for _, fileHeader := range files {
+		expected, ok := checksums[fileHeader.Filename]
+		if !ok {
+			continue
+		}
+		err = verifyUploadedFileChecksum(fileHeader, expected)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	for _, fileHeader := range files {
 		// Handle non-tar files as before
 		file, err := fileHeader.Open()
@@ -450,6 +603,23 @@ func (apiServer *HelixAPIServer) runnerSessionUploadFiles(res http.ResponseWrite
 	return result, nil
 }
 
+func verifyUploadedFileChecksum(fileHeader *multipart.FileHeader, expected string) error {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return fmt.Errorf("unable to open file")
+	}
+	defer file.Close()
+
+	actual, err := system.ReaderSHA256(file)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", fileHeader.Filename, expected, actual)
+	}
+	return nil
+}
+
 func (apiServer *HelixAPIServer) runnerSessionUploadFolder(res http.ResponseWriter, req *http.Request) (*filestore.FileStoreItem, error) {
 	vars := mux.Vars(req)
 	sessionid := vars["sessionid"]
@@ -482,6 +652,20 @@ func (apiServer *HelixAPIServer) runnerSessionUploadFolder(res http.ResponseWrit
 				return nil, fmt.Errorf("error reading file inside tar: %s", err)
 			}
 
+			if expected, ok := header.PAXRecords[system.TarChecksumPAXRecord]; ok {
+				actual, err := system.ReaderSHA256(bytes.NewReader(buffer.Bytes()))
+				if err != nil {
+					return nil, err
+				}
+				if actual != expected {
+					// don't leave half a folder behind for anything to pick up
+					if err := apiServer.Controller.FilestoreDelete(ownerContext, uploadFolder); err != nil {
+						log.Error().Msgf("error removing corrupt upload %s: %s", uploadFolder, err.Error())
+					}
+					return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", header.Name, expected, actual)
+				}
+			}
+
 			// Create a virtual file from the buffer to upload
 			vFile := bytes.NewReader(buffer.Bytes())
 			_, err := apiServer.Controller.FilestoreUploadFile(ownerContext, filepath.Join(uploadFolder, header.Name), vFile)
@@ -499,6 +683,57 @@ func (apiServer *HelixAPIServer) runnerSessionUploadFolder(res http.ResponseWrit
 	return &finalFolder, nil
 }
 
+// urls for the runner to download a session file or folder straight from
+// the filestore - 501 if it can't do that and the runner should use the
+// download endpoints instead
+func (apiServer *HelixAPIServer) runnerSessionPresignDownload(res http.ResponseWriter, req *http.Request) (*types.RunnerFileTransfers, *system.HTTPError) {
+	vars := mux.Vars(req)
+	filePath, ownerContext, err := apiServer.convertFilestorePath(req.Context(), vars["sessionid"], req.URL.Query().Get("path"))
+	if err != nil {
+		return nil, system.NewHTTPError404(err.Error())
+	}
+	return presignResult(apiServer.Controller.FilestorePresignDownload(ownerContext, filePath))
+}
+
+// urls for the runner to upload files into a session folder straight to the
+// filestore - the request body is the files with their checksums which the
+// filestore will check
+func (apiServer *HelixAPIServer) runnerSessionPresignUpload(res http.ResponseWriter, req *http.Request) (*types.RunnerFileTransfers, *system.HTTPError) {
+	vars := mux.Vars(req)
+	session, err := apiServer.Store.GetSession(req.Context(), vars["sessionid"])
+	if err != nil {
+		return nil, system.NewHTTPError404(err.Error())
+	}
+
+	files := []*types.RunnerFileTransfer{}
+	err = json.NewDecoder(req.Body).Decode(&files)
+	if err != nil {
+		return nil, system.NewHTTPError400(err.Error())
+	}
+	for _, file := range files {
+		if file.Name == "" || filepath.IsAbs(file.Name) || strings.HasPrefix(filepath.Clean(file.Name), "..") {
+			return nil, system.NewHTTPError400("invalid file name: %s", file.Name)
+		}
+	}
+
+	ownerContext := types.OwnerContext{
+		Owner:     session.Owner,
+		OwnerType: session.OwnerType,
+	}
+	uploadFolder := filepath.Join(controller.GetSessionFolder(session.ID), req.URL.Query().Get("path"))
+	return presignResult(apiServer.Controller.FilestorePresignUpload(ownerContext, uploadFolder, files))
+}
+
+func presignResult(transfers *types.RunnerFileTransfers, err error) (*types.RunnerFileTransfers, *system.HTTPError) {
+	if errors.Is(err, controller.ErrFilestorePresignNotSupported) {
+		return nil, &system.HTTPError{StatusCode: http.StatusNotImplemented, Message: err.Error()}
+	}
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+	return transfers, nil
+}
+
 func (apiServer *HelixAPIServer) restartSession(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
 	session, err := apiServer.sessionLoader(req, true)
 	if err != nil {
@@ -518,6 +753,32 @@ func (apiServer *HelixAPIServer) retryTextFinetune(res http.ResponseWriter, req
 	return session, nil
 }
 
+// stop generating questions for a text fine tune straight away, the
+// questions generated so far are deleted unless keep_questions is set
+func (apiServer *HelixAPIServer) cancelTextFinetune(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
+	session, httpError := apiServer.sessionLoader(req, true)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	var cancelReq types.DataPrepCancelRequest
+	if req.ContentLength != 0 {
+		err := json.NewDecoder(req.Body).Decode(&cancelReq)
+		if err != nil {
+			return nil, system.NewHTTPError400("failed to decode request body, error: %s", err)
+		}
+	}
+
+	cancelled, err := apiServer.Controller.CancelDataPrep(req.Context(), session, cancelReq.KeepQuestions)
+	if errors.Is(err, controller.ErrNoDataPrep) {
+		return nil, system.NewHTTPError409(err.Error())
+	}
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+	return cancelled, nil
+}
+
 func (apiServer *HelixAPIServer) cloneFinetuneInteraction(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
 	vars := mux.Vars(req)
 	reqContext := apiServer.getRequestContext(req)
@@ -562,6 +823,47 @@ func (apiServer *HelixAPIServer) cloneFinetuneInteraction(res http.ResponseWrite
 	}))
 }
 
+// fork a session into the caller's account so they can take the conversation
+// in a different direction - the interactions are copied up to and including
+// the ?interaction= query param (or all of them if it's not given)
+// anyone who can see the session (e.g. because it's shared) can clone it
+func (apiServer *HelixAPIServer) cloneSession(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
+	reqContext := apiServer.getRequestContext(req)
+
+	session, httpError := apiServer.sessionLoader(req, false)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	if len(session.Interactions) == 0 {
+		return nil, system.NewHTTPError400(fmt.Sprintf("session %s has no interactions to clone", session.ID))
+	}
+
+	interactionID := req.URL.Query().Get("interaction")
+	if interactionID == "" {
+		interactionID = session.Interactions[len(session.Interactions)-1].ID
+	}
+
+	interaction, err := data.GetInteraction(session, interactionID)
+	if err != nil {
+		return nil, system.NewHTTPError404(err.Error())
+	}
+
+	// if we own the session then we don't need to copy all files
+	copyAllFiles := true
+	if apiServer.doesOwnSession(reqContext, session) {
+		copyAllFiles = false
+	}
+
+	return system.DefaultController(apiServer.Controller.CloneUntilInteraction(reqContext, session, controller.CloneUntilInteractionRequest{
+		InteractionID: interaction.ID,
+		Mode:          types.CloneInteractionModeAll,
+		CopyAllFiles:  copyAllFiles,
+		// the clone belongs to whoever made it so it starts off private
+		Private: true,
+	}))
+}
+
 func (apiServer *HelixAPIServer) finetuneAddDocuments(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
 	session, httpError := apiServer.sessionLoader(req, true)
 	if httpError != nil {
@@ -576,6 +878,18 @@ func (apiServer *HelixAPIServer) finetuneAddDocuments(res http.ResponseWriter, r
 		return nil, system.NewHTTPError400(err.Error())
 	}
 
+	maxFileSize, err := apiServer.getMaxSessionFileSize(req.Context(), session.Owner)
+	if err != nil {
+		return nil, system.NewHTTPError(err)
+	}
+	httpError = checkUploadFileSize(req, session.Interactions, maxFileSize)
+	if httpError != nil {
+		return nil, httpError
+	}
+	// the runner checks against the limit the session has so keep it
+	// up to date with the owner's (e.g. if they have subscribed since)
+	session.Metadata.MaxFileSize = maxFileSize
+
 	// the user interaction is the request from the user
 	newUserInteraction, err := apiServer.getUserInteractionFromForm(req, session.ID, types.SessionModeFinetune, interactionID)
 	if err != nil {
@@ -588,7 +902,7 @@ func (apiServer *HelixAPIServer) finetuneAddDocuments(res http.ResponseWriter, r
 	// this means we are adding the files to an existing interaction
 	// rather than appending new interactions
 	if interactionID != "" {
-		return system.DefaultController(apiServer.Controller.AddDocumentsToInteraction(req.Context(), session, newUserInteraction.Files))
+		return system.DefaultController(apiServer.Controller.AddDocumentsToInteraction(req.Context(), session, newUserInteraction.Files, newUserInteraction.FileSizes))
 	} else {
 		return system.DefaultController(apiServer.Controller.AddDocumentsToSession(req.Context(), session, newUserInteraction))
 	}
@@ -696,7 +1010,178 @@ func (apiServer *HelixAPIServer) deleteSession(res http.ResponseWriter, req *htt
 		return nil, httpError
 	}
 	reqContext := apiServer.getRequestContext(req)
-	return system.DefaultController(apiServer.Store.DeleteSession(reqContext.Ctx, session.ID))
+	return system.DefaultController(apiServer.Controller.DeleteSession(reqContext.Ctx, session.ID))
+}
+
+func (apiServer *HelixAPIServer) deleteSessions(res http.ResponseWriter, req *http.Request) (*types.SessionsDeleteResponse, *system.HTTPError) {
+	reqContext := apiServer.getRequestContext(req)
+
+	deleteReq := types.SessionsDeleteRequest{}
+	err := json.NewDecoder(req.Body).Decode(&deleteReq)
+	if err != nil {
+		return nil, system.NewHTTPError400(err.Error())
+	}
+
+	// don't let an empty request wipe out every session
+	if len(deleteReq.IDs) == 0 && deleteReq.Mode == "" && deleteReq.OlderThan == 0 {
+		return nil, system.NewHTTPError400("either ids, mode or older_than must be specified")
+	}
+
+	if deleteReq.Mode != "" {
+		_, err = types.ValidateSessionMode(string(deleteReq.Mode), false)
+		if err != nil {
+			return nil, system.NewHTTPError400(err.Error())
+		}
+	}
+
+	return system.DefaultController(apiServer.Controller.DeleteSessions(reqContext, deleteReq))
+}
+
+// admin is required by the auth middleware - the sessions a runner has
+// worked on (optionally ?after=&before= as RFC3339) so a misbehaving runner
+// can be audited even after it has restarted and lost its job history
+func (apiServer *HelixAPIServer) listRunnerSessions(res http.ResponseWriter, req *http.Request) (*types.RunnerSessionsList, *system.HTTPError) {
+	query := store.ListRunnerSessionsQuery{
+		RunnerID: mux.Vars(req)["runnerid"],
+		Limit:    100,
+	}
+
+	httpErr := parseRunnerHistoryParams(req, &query.After, &query.Before, &query.Limit)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	sessions, err := apiServer.Store.ListRunnerSessions(req.Context(), query)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	result := &types.RunnerSessionsList{Sessions: []*types.RunnerSessionSummary{}}
+	for _, session := range sessions {
+		interactions := data.FilterRunnerInteractions(session.Interactions, query.RunnerID, query.After, query.Before)
+		if len(interactions) == 0 {
+			continue
+		}
+		summary, err := data.GetSessionSummary(session)
+		if err != nil {
+			return nil, system.NewHTTPError500(err.Error())
+		}
+		runnerSummary := &types.RunnerSessionSummary{SessionSummary: summary}
+		for _, interaction := range interactions {
+			runnerSummary.InteractionIDs = append(runnerSummary.InteractionIDs, interaction.ID)
+		}
+		result.Sessions = append(result.Sessions, runnerSummary)
+	}
+	return result, nil
+}
+
+// admin is required by the auth middleware - the jobs a runner's model
+// instances have run (optionally ?model_instance_id= and ?after=&before= as
+// RFC3339 on when they started), kept after the runner has restarted
+func (apiServer *HelixAPIServer) listRunnerJobs(res http.ResponseWriter, req *http.Request) (*types.ModelInstanceJobsList, *system.HTTPError) {
+	query := store.ListModelInstanceJobsQuery{
+		RunnerID:        mux.Vars(req)["runnerid"],
+		ModelInstanceID: req.URL.Query().Get("model_instance_id"),
+		Limit:           100,
+	}
+
+	httpErr := parseRunnerHistoryParams(req, &query.After, &query.Before, &query.Limit)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	jobs, err := apiServer.Store.ListModelInstanceJobs(req.Context(), query)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	return &types.ModelInstanceJobsList{Jobs: jobs}, nil
+}
+
+// the ?after=&before= window and ?limit= of the runner history endpoints,
+// anything not given is left as it is
+func parseRunnerHistoryParams(req *http.Request, after, before *time.Time, limit *int) *system.HTTPError {
+	httpErr := parseTimeWindowParams(req, after, before)
+	if httpErr != nil {
+		return httpErr
+	}
+
+	if limitStr := req.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 {
+			return system.NewHTTPError400("invalid limit: %s", limitStr)
+		}
+		*limit = parsed
+	}
+
+	return nil
+}
+
+// the ?after=&before= window as RFC3339, anything not given is left as it is
+func parseTimeWindowParams(req *http.Request, after, before *time.Time) *system.HTTPError {
+	for param, value := range map[string]*time.Time{"after": after, "before": before} {
+		raw := req.URL.Query().Get(param)
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return system.NewHTTPError400("invalid %s time, must be RFC3339: %s", param, raw)
+		}
+		*value = parsed
+	}
+	return nil
+}
+
+// what a runner is already running as model_name:mode:lora_dir, the model
+// name can have a :tag and the mode an @env on the end
+func parseRejectEntry(rejectPair string) (types.SessionFilterModel, error) {
+	triple := strings.Split(rejectPair, ":")
+	var rejectModelName types.ModelName
+	var rejectModeAndEnv string
+	var rejectLoraDir string
+	var err error
+	if len(triple) == 4 {
+		rejectModelName, err = types.ValidateModelName(triple[0]+":"+triple[1], false)
+		if err != nil {
+			return types.SessionFilterModel{}, err
+		}
+		rejectModeAndEnv = triple[2]
+		rejectLoraDir = triple[3]
+	} else if len(triple) == 3 {
+		rejectModelName, err = types.ValidateModelName(triple[0], false)
+		if err != nil {
+			return types.SessionFilterModel{}, err
+		}
+		rejectModeAndEnv = triple[1]
+		rejectLoraDir = triple[2]
+	} else {
+		return types.SessionFilterModel{}, fmt.Errorf("invalid reject pair: %s", rejectPair)
+	}
+	rejectMode, rejectEnv, _ := strings.Cut(rejectModeAndEnv, types.REJECT_ENV_SEPARATOR)
+	rejectModelMode, err := types.ValidateSessionMode(rejectMode, false)
+	if err != nil {
+		return types.SessionFilterModel{}, err
+	}
+	return types.SessionFilterModel{
+		ModelName: rejectModelName,
+		Mode:      rejectModelMode,
+		LoraDir:   rejectLoraDir,
+		Env:       rejectEnv,
+	}, nil
+}
+
+// the bytes of memory a runner says it has free - older runners could report
+// a negative amount when they had overcommitted which we treat as none
+func parseFreeMemory(memoryString string) (uint64, error) {
+	memory, err := strconv.ParseUint(memoryString, 10, 64)
+	if err == nil {
+		return memory, nil
+	}
+	if signed, signedErr := strconv.ParseInt(memoryString, 10, 64); signedErr == nil && signed < 0 {
+		return 0, nil
+	}
+	return 0, fmt.Errorf("invalid memory %q: %w", memoryString, err)
 }
 
 func (apiServer *HelixAPIServer) getNextRunnerSession(res http.ResponseWriter, req *http.Request) (*types.Session, error) {
@@ -722,56 +1207,30 @@ func (apiServer *HelixAPIServer) getNextRunnerSession(res http.ResponseWriter, r
 	}
 
 	loraDir := req.URL.Query().Get("lora_dir")
+	env := req.URL.Query().Get("env")
 
-	memory := uint64(0)
+	var memory *uint64
 	memoryString := req.URL.Query().Get("memory")
 	if memoryString != "" {
-		memory, err = strconv.ParseUint(memoryString, 10, 64)
+		freeMemory, err := parseFreeMemory(memoryString)
 		if err != nil {
 			return nil, err
 		}
+		memory = &freeMemory
 	}
 
 	// there are multiple entries for this param all of the format:
-	// model_name:mode
+	// model_name:mode:lora_dir
 	reject := []types.SessionFilterModel{}
 	rejectPairs, ok := req.URL.Query()["reject"]
 
 	if ok && len(rejectPairs) > 0 {
 		for _, rejectPair := range rejectPairs {
-			triple := strings.Split(rejectPair, ":")
-			var rejectModelName types.ModelName
-			var rejectModelMode types.SessionMode
-			var rejectLoraDir string
-			var err error
-			if len(triple) == 4 {
-				rejectModelName, err = types.ValidateModelName(triple[0]+":"+triple[1], false)
-				if err != nil {
-					return nil, err
-				}
-				rejectModelMode, err = types.ValidateSessionMode(triple[2], false)
-				if err != nil {
-					return nil, err
-				}
-				rejectLoraDir = triple[3]
-			} else if len(triple) == 3 {
-				rejectModelName, err = types.ValidateModelName(triple[0], false)
-				if err != nil {
-					return nil, err
-				}
-				rejectModelMode, err = types.ValidateSessionMode(triple[1], false)
-				if err != nil {
-					return nil, err
-				}
-				rejectLoraDir = triple[2]
-			} else {
-				return nil, fmt.Errorf("invalid reject pair: %s", rejectPair)
+			rejectEntry, err := parseRejectEntry(rejectPair)
+			if err != nil {
+				return nil, err
 			}
-			reject = append(reject, types.SessionFilterModel{
-				ModelName: rejectModelName,
-				Mode:      rejectModelMode,
-				LoraDir:   rejectLoraDir,
-			})
+			reject = append(reject, rejectEntry)
 		}
 	}
 
@@ -792,6 +1251,7 @@ func (apiServer *HelixAPIServer) getNextRunnerSession(res http.ResponseWriter, r
 		Memory:    memory,
 		Reject:    reject,
 		LoraDir:   loraDir,
+		Env:       env,
 		Older:     types.Duration(olderDuration),
 	}
 
@@ -822,6 +1282,28 @@ func (apiServer *HelixAPIServer) handleRunnerResponse(res http.ResponseWriter, r
 	return resp, nil
 }
 
+// a runner giving back a session it can't finish (e.g. it restarted part
+// way through) so it goes back on the queue
+func (apiServer *HelixAPIServer) releaseRunnerSession(res http.ResponseWriter, req *http.Request) (*types.RunnerSessionRelease, error) {
+	vars := mux.Vars(req)
+	if vars["sessionid"] == "" {
+		return nil, fmt.Errorf("cannot release session without session id")
+	}
+
+	release := &types.RunnerSessionRelease{}
+	err := json.NewDecoder(req.Body).Decode(release)
+	if err != nil {
+		return nil, err
+	}
+
+	release.Requeued, err = apiServer.Controller.ReleaseSession(req.Context(), vars["sessionid"], release.InteractionID)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", vars["sessionid"]).Str("runner_id", vars["runnerid"]).Msg("failed to release runner session")
+		return nil, err
+	}
+	return release, nil
+}
+
 func (apiServer *HelixAPIServer) handleRunnerMetrics(res http.ResponseWriter, req *http.Request) (*types.RunnerState, error) {
 	runnerState := &types.RunnerState{}
 	err := json.NewDecoder(req.Body).Decode(runnerState)
@@ -836,39 +1318,40 @@ func (apiServer *HelixAPIServer) handleRunnerMetrics(res http.ResponseWriter, re
 	return runnerState, nil
 }
 
-func (apiServer *HelixAPIServer) createAPIKey(res http.ResponseWriter, req *http.Request) (string, error) {
-	name := req.URL.Query().Get("name")
-	apiKey, err := apiServer.Controller.CreateAPIKey(apiServer.getRequestContext(req), name)
-	if err != nil {
-		return "", err
-	}
-	return apiKey, nil
+// the secret is included so the owner can verify the payloads we send
+func (apiServer *HelixAPIServer) getWebhookConfig(res http.ResponseWriter, req *http.Request) (*types.WebhookConfig, error) {
+	return apiServer.Controller.GetWebhookConfig(apiServer.getRequestContext(req))
 }
 
-func (apiServer *HelixAPIServer) getAPIKeys(res http.ResponseWriter, req *http.Request) ([]*types.ApiKey, error) {
-	apiKeys, err := apiServer.Controller.GetAPIKeys(apiServer.getRequestContext(req))
+func (apiServer *HelixAPIServer) updateWebhookConfig(res http.ResponseWriter, req *http.Request) (*types.WebhookConfig, *system.HTTPError) {
+	update := types.WebhookConfigUpdate{}
+	err := json.NewDecoder(req.Body).Decode(&update)
 	if err != nil {
-		return nil, err
+		return nil, system.NewHTTPError400(err.Error())
 	}
-	return apiKeys, nil
+	if update.URL != "" {
+		err = types.ValidateWebhookURL(update.URL)
+		if err != nil {
+			return nil, system.NewHTTPError400(err.Error())
+		}
+	}
+	return system.DefaultController(apiServer.Controller.UpdateWebhookConfig(apiServer.getRequestContext(req), update))
 }
 
-func (apiServer *HelixAPIServer) deleteAPIKey(res http.ResponseWriter, req *http.Request) (string, error) {
-	apiKey := req.URL.Query().Get("key")
-	err := apiServer.Controller.DeleteAPIKey(apiServer.getRequestContext(req), apiKey)
+func (apiServer *HelixAPIServer) estimateDataPrepCost(res http.ResponseWriter, req *http.Request) (*types.DataPrepCostEstimate, *system.HTTPError) {
+	estimateReq := types.DataPrepCostEstimateRequest{}
+	err := json.NewDecoder(req.Body).Decode(&estimateReq)
 	if err != nil {
-		return "", err
+		return nil, system.NewHTTPError400(err.Error())
 	}
-	return "", nil
-}
-
-func (apiServer *HelixAPIServer) checkAPIKey(res http.ResponseWriter, req *http.Request) (*types.ApiKey, error) {
-	apiKey := req.URL.Query().Get("key")
-	key, err := apiServer.Controller.CheckAPIKey(apiServer.getRequestContext(req).Ctx, apiKey)
+	if len(estimateReq.Texts) == 0 {
+		return nil, system.NewHTTPError400("at least one text is required")
+	}
+	err = apiServer.Controller.ValidateDataPrepSettings(estimateReq.ChunkSize, 0)
 	if err != nil {
-		return nil, err
+		return nil, system.NewHTTPError400(err.Error())
 	}
-	return key, nil
+	return system.DefaultController(apiServer.Controller.EstimateDataPrepCost(apiServer.getRequestContext(req), estimateReq))
 }
 
 func (apiServer *HelixAPIServer) subscriptionCreate(res http.ResponseWriter, req *http.Request) (string, error) {
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// how long each readiness check gets before we call it unavailable - probes
+// normally give up after a second or so themselves
+const readinessCheckTimeout = 2 * time.Second
+
+const (
+	healthStatusOK          = "ok"
+	healthStatusUnavailable = "unavailable"
+)
+
+// healthz godoc
+// @Summary Liveness probe
+// @Description Returns 200 as long as the api process is up, it doesn't check anything else.
+// @Tags    health
+// @Success 200 {object} types.HealthStatus
+// @Router /healthz [get]
+func (apiServer *HelixAPIServer) healthz(rw http.ResponseWriter, req *http.Request) {
+	writeHealthStatus(rw, &types.HealthStatus{Status: healthStatusOK})
+}
+
+// readyz godoc
+// @Summary Readiness probe
+// @Description Returns 200 if the database and keycloak can be reached and 503 (with the failing checks) if not.
+// @Tags    health
+// @Success 200 {object} types.HealthStatus
+// @Failure 503 {object} types.HealthStatus
+// @Router /readyz [get]
+func (apiServer *HelixAPIServer) readyz(rw http.ResponseWriter, req *http.Request) {
+	status := runReadinessChecks(req.Context(), map[string]func(ctx context.Context) error{
+		"store":    apiServer.Store.Ping,
+		"keycloak": apiServer.pingKeycloak,
+	})
+	writeHealthStatus(rw, status)
+}
+
+// run the checks at the same time, each with its own timeout
+func runReadinessChecks(ctx context.Context, checks map[string]func(ctx context.Context) error) *types.HealthStatus {
+	status := &types.HealthStatus{
+		Status: healthStatusOK,
+		Checks: map[string]string{},
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) error) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+			defer cancel()
+			err := check(checkCtx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Warn().Err(err).Str("check", name).Msg("readiness check failed")
+				status.Status = healthStatusUnavailable
+				status.Checks[name] = err.Error()
+				return
+			}
+			status.Checks[name] = healthStatusOK
+		}(name, check)
+	}
+	wg.Wait()
+
+	return status
+}
+
+// the realm endpoint is public so it tells us keycloak is up and serving
+// our realm without needing a token
+func (apiServer *HelixAPIServer) pingKeycloak(ctx context.Context) error {
+	realmURL := fmt.Sprintf("%s/realms/%s", strings.TrimSuffix(apiServer.Options.KeyCloakURL, "/"), REALM)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realmURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("keycloak returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func writeHealthStatus(rw http.ResponseWriter, status *types.HealthStatus) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	if status.Status != healthStatusOK {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(rw).Encode(status)
+	if err != nil {
+		log.Error().Err(err).Msg("error writing health status")
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestReadyz(t *testing.T) {
+	keycloakUp := true
+	keycloakServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/auth/realms/"+REALM, req.URL.Path)
+		if !keycloakUp {
+			res.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer keycloakServer.Close()
+
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+
+	apiServer := &HelixAPIServer{
+		Options: ServerOptions{KeyCloakURL: keycloakServer.URL + "/auth/"},
+		Store:   mockStore,
+	}
+
+	readyz := func() (int, *types.HealthStatus) {
+		rec := httptest.NewRecorder()
+		apiServer.readyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+		status := &types.HealthStatus{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), status))
+		return rec.Code, status
+	}
+
+	mockStore.EXPECT().Ping(gomock.Any()).Return(nil)
+	code, status := readyz()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, &types.HealthStatus{Status: "ok", Checks: map[string]string{"store": "ok", "keycloak": "ok"}}, status)
+
+	keycloakUp = false
+	mockStore.EXPECT().Ping(gomock.Any()).Return(fmt.Errorf("connection refused"))
+	code, status = readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, &types.HealthStatus{Status: "unavailable", Checks: map[string]string{
+		"store":    "connection refused",
+		"keycloak": "keycloak returned 502",
+	}}, status)
+}
+
+func TestHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&HelixAPIServer{}).healthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status": "ok"}`, rec.Body.String())
+}
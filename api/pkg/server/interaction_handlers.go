@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/helixml/helix/api/pkg/controller"
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// editInteraction godoc
+// @Summary Edit a message and regenerate
+// @Description Change the message of an earlier user interaction, remove every interaction after it and answer the edited message again. Set keep_history to copy the conversation as it was into a new session first.
+// @Tags    sessions
+
+// @Success 200 {object} types.Session
+// @Failure 409 {object} system.HTTPError "The session is still generating a response"
+// @Param request    body types.EditInteractionRequest true "The new message"
+// @Param id path string true "Session ID"
+// @Param iid path string true "Interaction ID"
+// @Router /api/v1/sessions/{id}/interactions/{iid} [put]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) editInteraction(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
+	session, httpError := apiServer.sessionLoader(req, true)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	interaction, err := data.GetInteraction(session, mux.Vars(req)["iid"])
+	if err != nil {
+		return nil, system.NewHTTPError404(err.Error())
+	}
+	if interaction.Creator != types.CreatorTypeUser {
+		return nil, system.NewHTTPError400("interaction %s is not a user interaction", interaction.ID)
+	}
+	if interaction.Mode != types.SessionModeInference {
+		return nil, system.NewHTTPError400("only inference interactions can be edited")
+	}
+
+	var editReq types.EditInteractionRequest
+	err = json.NewDecoder(req.Body).Decode(&editReq)
+	if err != nil {
+		return nil, system.NewHTTPError400("failed to decode request body, error: %s", err)
+	}
+	if strings.TrimSpace(editReq.Message) == "" {
+		return nil, system.NewHTTPError400("message is required")
+	}
+
+	edited, err := apiServer.Controller.EditInteraction(apiServer.getRequestContext(req), session, interaction.ID, editReq)
+	if errors.Is(err, controller.ErrSessionBusy) {
+		return nil, system.NewHTTPError409(err.Error())
+	}
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+	return edited, nil
+}
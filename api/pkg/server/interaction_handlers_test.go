@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newEditInteractionRequest(userID string, interactionID string, body string) *http.Request {
+	req := httptest.NewRequest("PUT", "/api/v1/sessions/session_id/interactions/"+interactionID, strings.NewReader(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "session_id", "iid": interactionID})
+	return req.WithContext(setRequestUser(req.Context(), types.UserData{ID: userID}))
+}
+
+func newEditInteractionSession(lastState types.InteractionState) *types.Session {
+	return &types.Session{
+		ID:        "session_id",
+		Owner:     "owner_id",
+		OwnerType: types.OwnerTypeUser,
+		Mode:      types.SessionModeInference,
+		Interactions: []*types.Interaction{
+			{ID: "user-1", Creator: types.CreatorTypeUser, Mode: types.SessionModeInference, Message: "hello"},
+			{ID: "system-1", Creator: types.CreatorTypeSystem, Mode: types.SessionModeInference, Message: "hi", State: lastState},
+		},
+	}
+}
+
+func Test_editInteraction_Invalid(t *testing.T) {
+	apiServer, _ := newEvalTestServer(t, newEditInteractionSession(types.InteractionStateComplete))
+
+	tests := []struct {
+		name          string
+		userID        string
+		interactionID string
+		body          string
+		status        int
+	}{
+		{
+			name:          "not_owner",
+			userID:        "other_id",
+			interactionID: "user-1",
+			body:          `{"message": "good morning"}`,
+			status:        http.StatusForbidden,
+		},
+		{
+			name:          "unknown_interaction",
+			userID:        "owner_id",
+			interactionID: "user-2",
+			body:          `{"message": "good morning"}`,
+			status:        http.StatusNotFound,
+		},
+		{
+			name:          "system_interaction",
+			userID:        "owner_id",
+			interactionID: "system-1",
+			body:          `{"message": "good morning"}`,
+			status:        http.StatusBadRequest,
+		},
+		{
+			name:          "empty_message",
+			userID:        "owner_id",
+			interactionID: "user-1",
+			body:          `{"message": "  "}`,
+			status:        http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, httpErr := apiServer.editInteraction(httptest.NewRecorder(), newEditInteractionRequest(tt.userID, tt.interactionID, tt.body))
+			require.NotNil(t, httpErr)
+			assert.Equal(t, tt.status, httpErr.StatusCode)
+		})
+	}
+}
+
+func Test_editInteraction_StillGenerating(t *testing.T) {
+	apiServer, _ := newEvalTestServer(t, newEditInteractionSession(types.InteractionStateWaiting))
+
+	// nothing is saved while the last answer is still on its way
+	_, httpErr := apiServer.editInteraction(httptest.NewRecorder(), newEditInteractionRequest("owner_id", "user-1", `{"message": "good morning"}`))
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusConflict, httpErr.StatusCode)
+}
@@ -154,6 +154,7 @@ func getUserIdFromJWT(tok *jwt.Token) string {
 }
 
 func setRequestUser(ctx context.Context, user types.UserData) context.Context {
+	setAccessLogOwner(ctx, user.ID)
 	ctx = context.WithValue(ctx, "userid", user.ID)
 	ctx = context.WithValue(ctx, "email", user.Email)
 	ctx = context.WithValue(ctx, "fullname", user.FullName)
@@ -216,6 +217,10 @@ func (auth *keyCloakMiddleware) apiKeyAuth(f http.HandlerFunc) http.HandlerFunc
 			http.Error(rw, err.Error(), http.StatusUnauthorized)
 			return
 		}
+		if maybeOwner == nil {
+			http.Error(rw, "an API key is required, send it as a bearer token in the Authorization header", http.StatusUnauthorized)
+			return
+		}
 		// successful api_key auth
 		req = req.WithContext(setRequestUser(req.Context(), types.UserData{
 			ID: maybeOwner.Owner,
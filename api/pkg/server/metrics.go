@@ -0,0 +1,197 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+type metricType string
+
+const (
+	metricTypeGauge     metricType = "gauge"
+	metricTypeCounter   metricType = "counter"
+	metricTypeHistogram metricType = "histogram"
+)
+
+type metricDesc struct {
+	name string
+	help string
+	typ  metricType
+}
+
+// the metrics we export, these are only ever declared here
+var (
+	metricRunnerTotalMemory = metricDesc{
+		name: "helix_runner_total_memory_bytes",
+		help: "Total GPU memory reported by the runner.",
+		typ:  metricTypeGauge,
+	}
+	metricRunnerFreeMemory = metricDesc{
+		name: "helix_runner_free_memory_bytes",
+		help: "Free GPU memory reported by the runner.",
+		typ:  metricTypeGauge,
+	}
+	metricRunnerModelInstances = metricDesc{
+		name: "helix_runner_model_instances",
+		help: "Number of model instances running on the runner.",
+		typ:  metricTypeGauge,
+	}
+	metricRunnerMaxModelInstances = metricDesc{
+		name: "helix_runner_max_model_instances",
+		help: "Most model instances the runner will run at once, 0 means no limit.",
+		typ:  metricTypeGauge,
+	}
+	metricModelInstanceLastActivityAge = metricDesc{
+		name: "helix_model_instance_last_activity_age_seconds",
+		help: "Seconds since the model instance last started or finished a job.",
+		typ:  metricTypeGauge,
+	}
+	metricSessionQueueDepth = metricDesc{
+		name: "helix_session_queue_depth",
+		help: "Number of sessions waiting for a runner.",
+		typ:  metricTypeGauge,
+	}
+	metricSessionsTotal = metricDesc{
+		name: "helix_sessions_total",
+		help: "Number of interactions that finished, by model, mode and state.",
+		typ:  metricTypeCounter,
+	}
+	metricInteractionStageDuration = metricDesc{
+		name: "helix_interaction_stage_duration_seconds",
+		help: "Seconds interactions spent queued, loading the model, waiting for the first token and generating, by model and mode.",
+		typ:  metricTypeHistogram,
+	}
+)
+
+type metricLabel struct {
+	name  string
+	value string
+}
+
+// a prometheus text format writer, it builds the whole response up front from
+// a snapshot of the controller so concurrent scrapes never see partial state
+type metricsWriter struct {
+	sb strings.Builder
+}
+
+func (w *metricsWriter) header(desc metricDesc) {
+	fmt.Fprintf(&w.sb, "# HELP %s %s\n", desc.name, desc.help)
+	fmt.Fprintf(&w.sb, "# TYPE %s %s\n", desc.name, desc.typ)
+}
+
+func (w *metricsWriter) sample(desc metricDesc, value float64, labels ...metricLabel) {
+	w.namedSample(desc.name, value, labels...)
+}
+
+// the _bucket, _sum and _count series of a histogram, buckets must be
+// cumulative
+func (w *metricsWriter) histogram(desc metricDesc, buckets []types.HistogramBucket, sum float64, count uint64, labels ...metricLabel) {
+	for _, bucket := range buckets {
+		le := metricLabel{"le", strconv.FormatFloat(bucket.UpperBound, 'g', -1, 64)}
+		w.namedSample(desc.name+"_bucket", float64(bucket.Count), append(labels[:len(labels):len(labels)], le)...)
+	}
+	w.namedSample(desc.name+"_bucket", float64(count), append(labels[:len(labels):len(labels)], metricLabel{"le", "+Inf"})...)
+	w.namedSample(desc.name+"_sum", sum, labels...)
+	w.namedSample(desc.name+"_count", float64(count), labels...)
+}
+
+func (w *metricsWriter) namedSample(name string, value float64, labels ...metricLabel) {
+	w.sb.WriteString(name)
+	if len(labels) > 0 {
+		parts := make([]string, 0, len(labels))
+		for _, label := range labels {
+			parts = append(parts, fmt.Sprintf("%s=\"%s\"", label.name, escapeLabelValue(label.value)))
+		}
+		w.sb.WriteString("{" + strings.Join(parts, ",") + "}")
+	}
+	fmt.Fprintf(&w.sb, " %g\n", value)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func writeMetrics(out io.Writer, metrics *types.ControllerMetrics, now time.Time) error {
+	w := &metricsWriter{}
+
+	w.header(metricRunnerTotalMemory)
+	for _, runner := range metrics.Runners {
+		w.sample(metricRunnerTotalMemory, float64(runner.TotalMemory), metricLabel{"runner_id", runner.ID})
+	}
+
+	w.header(metricRunnerFreeMemory)
+	for _, runner := range metrics.Runners {
+		w.sample(metricRunnerFreeMemory, float64(runner.FreeMemory), metricLabel{"runner_id", runner.ID})
+	}
+
+	w.header(metricRunnerModelInstances)
+	for _, runner := range metrics.Runners {
+		w.sample(metricRunnerModelInstances, float64(runner.InstanceCount), metricLabel{"runner_id", runner.ID})
+	}
+
+	w.header(metricRunnerMaxModelInstances)
+	for _, runner := range metrics.Runners {
+		w.sample(metricRunnerMaxModelInstances, float64(runner.MaxInstances), metricLabel{"runner_id", runner.ID})
+	}
+
+	w.header(metricModelInstanceLastActivityAge)
+	for _, runner := range metrics.Runners {
+		for _, modelInstance := range runner.ModelInstances {
+			age := now.Sub(time.Unix(int64(modelInstance.LastActivity), 0)).Seconds()
+			w.sample(metricModelInstanceLastActivityAge, age,
+				metricLabel{"runner_id", runner.ID},
+				metricLabel{"model_instance_id", modelInstance.ID},
+				metricLabel{"model_name", string(modelInstance.ModelName)},
+				metricLabel{"mode", string(modelInstance.Mode)},
+			)
+		}
+	}
+
+	w.header(metricSessionQueueDepth)
+	w.sample(metricSessionQueueDepth, float64(metrics.QueueDepth))
+
+	w.header(metricSessionsTotal)
+	for _, outcome := range metrics.SessionOutcomes {
+		w.sample(metricSessionsTotal, float64(outcome.Count),
+			metricLabel{"model_name", string(outcome.ModelName)},
+			metricLabel{"mode", string(outcome.Mode)},
+			metricLabel{"state", string(outcome.State)},
+		)
+	}
+
+	w.header(metricInteractionStageDuration)
+	for _, stage := range metrics.StageDurations {
+		w.histogram(metricInteractionStageDuration, stage.Buckets, stage.Sum, stage.Count,
+			metricLabel{"model_name", string(stage.ModelName)},
+			metricLabel{"mode", string(stage.Mode)},
+			metricLabel{"stage", string(stage.Stage)},
+		)
+	}
+
+	_, err := io.WriteString(out, w.sb.String())
+	return err
+}
+
+// metrics godoc
+// @Summary Prometheus metrics
+// @Description Runner, model instance, queue and interaction latency metrics in the prometheus text format.
+// @Tags    metrics
+
+// @Success 200
+// @Router /metrics [get]
+func (apiServer *HelixAPIServer) metrics(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	err := writeMetrics(res, apiServer.Controller.GetMetrics(), time.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("error writing metrics")
+	}
+}
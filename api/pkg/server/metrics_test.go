@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	var buf bytes.Buffer
+	err := writeMetrics(&buf, &types.ControllerMetrics{
+		Runners: []*types.RunnerState{
+			{
+				ID:            "runner-1",
+				TotalMemory:   24,
+				FreeMemory:    8,
+				InstanceCount: 1,
+				MaxInstances:  2,
+				ModelInstances: []*types.ModelInstanceState{
+					{
+						ID:           "instance-1",
+						ModelName:    types.Model_Axolotl_Mistral7b,
+						Mode:         types.SessionModeInference,
+						LastActivity: 940,
+					},
+				},
+			},
+		},
+		QueueDepth: 3,
+		SessionOutcomes: []*types.SessionOutcomeCount{
+			{
+				ModelName: types.Model_Axolotl_Mistral7b,
+				Mode:      types.SessionModeInference,
+				State:     types.InteractionStateError,
+				Count:     2,
+			},
+		},
+		StageDurations: []*types.StageDurationCount{
+			{
+				ModelName: types.Model_Axolotl_Mistral7b,
+				Mode:      types.SessionModeInference,
+				Stage:     types.InteractionStageFirstToken,
+				Buckets: []types.HistogramBucket{
+					{UpperBound: 0.5, Count: 1},
+					{UpperBound: 1, Count: 3},
+				},
+				Sum:   4.5,
+				Count: 4,
+			},
+		},
+	}, now)
+	require.NoError(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, "# TYPE helix_sessions_total counter\n")
+	assert.Contains(t, out, `helix_runner_total_memory_bytes{runner_id="runner-1"} 24`+"\n")
+	assert.Contains(t, out, `helix_runner_free_memory_bytes{runner_id="runner-1"} 8`+"\n")
+	assert.Contains(t, out, `helix_runner_model_instances{runner_id="runner-1"} 1`+"\n")
+	assert.Contains(t, out, `helix_runner_max_model_instances{runner_id="runner-1"} 2`+"\n")
+	assert.Contains(t, out, `helix_model_instance_last_activity_age_seconds{runner_id="runner-1",model_instance_id="instance-1",model_name="mistralai/Mistral-7B-Instruct-v0.1",mode="inference"} 60`+"\n")
+	assert.Contains(t, out, "helix_session_queue_depth 3\n")
+	assert.Contains(t, out, `helix_sessions_total{model_name="mistralai/Mistral-7B-Instruct-v0.1",mode="inference",state="error"} 2`+"\n")
+
+	assert.Contains(t, out, "# TYPE helix_interaction_stage_duration_seconds histogram\n")
+	labels := `model_name="mistralai/Mistral-7B-Instruct-v0.1",mode="inference",stage="first_token"`
+	assert.Contains(t, out, `helix_interaction_stage_duration_seconds_bucket{`+labels+`,le="0.5"} 1`+"\n")
+	assert.Contains(t, out, `helix_interaction_stage_duration_seconds_bucket{`+labels+`,le="1"} 3`+"\n")
+	assert.Contains(t, out, `helix_interaction_stage_duration_seconds_bucket{`+labels+`,le="+Inf"} 4`+"\n")
+	assert.Contains(t, out, `helix_interaction_stage_duration_seconds_sum{`+labels+`} 4.5`+"\n")
+	assert.Contains(t, out, `helix_interaction_stage_duration_seconds_count{`+labels+`} 4`+"\n")
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	assert.Equal(t, `a\\b\"c\nd`, escapeLabelValue("a\\b\"c\nd"))
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/helixml/helix/api/pkg/model"
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// listModels godoc
+// @Summary List models
+// @Description List the models sessions can be started with, along with their aliases, memory requirements and what they can do. Pass capability to only list the models that have it.
+// @Tags    models
+
+// @Success 200 {array} types.ModelInfo
+// @Param capability query string false "Only list models with this capability: streaming, vision or finetune"
+// @Router /api/v1/models [get]
+// @Security BearerAuth
+func (s *HelixAPIServer) listModels(rw http.ResponseWriter, r *http.Request) ([]*types.ModelInfo, *system.HTTPError) {
+	capability, err := types.ValidateModelCapability(r.URL.Query().Get("capability"), true)
+	if err != nil {
+		return nil, system.NewHTTPError400(err.Error())
+	}
+
+	models, err := model.ListModels(capability)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	return models, nil
+}
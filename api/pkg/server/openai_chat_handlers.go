@@ -1,16 +1,19 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/helixml/helix/api/pkg/pubsub"
-	"github.com/helixml/helix/api/pkg/system"
 	"github.com/helixml/helix/api/pkg/types"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -22,6 +25,9 @@ const (
 
 // https://platform.openai.com/docs/api-reference/chat/create
 // POST https://app.tryhelix.ai//v1/chat/completions
+// the request is turned into a session chat request so OpenAI clients get
+// the same sessions as everyone else, fields we don't support (n, top_p,
+// tools etc.) are ignored
 func (apiServer *HelixAPIServer) createChatCompletion(res http.ResponseWriter, req *http.Request) {
 	body, err := io.ReadAll(io.LimitReader(req.Body, 10*MEGABYTE))
 	if err != nil {
@@ -36,83 +42,93 @@ func (apiServer *HelixAPIServer) createChatCompletion(res http.ResponseWriter, r
 		return
 	}
 
-	userContext := apiServer.getRequestContext(req)
-
-	status, err := apiServer.Controller.GetStatus(userContext)
+	startReq, err := chatCompletionToSessionChatRequest(&chatCompletionRequest)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		http.Error(res, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	sessionID := system.GenerateUUID()
-
-	sessionMode := types.SessionModeInference
+	apiServer.startChatSession(res, req, startReq)
+}
 
-	var interactions []*types.Interaction
+func chatCompletionToSessionChatRequest(chatCompletionRequest *types.ChatCompletionRequest) (*types.SessionChatRequest, error) {
+	startReq := &types.SessionChatRequest{
+		Stream:         chatCompletionRequest.Stream,
+		Mode:           types.SessionModeInference,
+		Type:           types.SessionTypeText,
+		Model:          chatCompletionRequest.Model,
+		SamplingParams: chatCompletionRequest.SamplingParams,
+	}
 
 	for _, m := range chatCompletionRequest.Messages {
-		// Validating roles
 		switch m.Role {
 		case "user", "system", "assistant":
 			// OK
+		case "tool", "function":
+			// we don't do function calling so the results of any are no use to us
+			continue
 		default:
-			http.Error(res, "invalid role, available roles: 'user', 'system', 'assistant'", http.StatusBadRequest)
-			return
+			return nil, fmt.Errorf("invalid role '%s', available roles: 'user', 'system', 'assistant'", m.Role)
 		}
 
-		var creator types.CreatorType
-		switch m.Role {
-		case "user":
-			creator = types.CreatorTypeUser
-		case "system":
-			creator = types.CreatorTypeSystem
-		}
-
-		interaction := &types.Interaction{
-			ID:             system.GenerateUUID(),
-			Created:        time.Now(),
-			Updated:        time.Now(),
-			Scheduled:      time.Now(),
-			Completed:      time.Now(),
-			Creator:        creator,
-			Mode:           sessionMode,
-			Message:        m.Content,
-			Files:          []string{},
-			State:          types.InteractionStateComplete,
-			Finished:       true,
-			Metadata:       map[string]string{},
-			DataPrepChunks: map[string][]types.DataPrepChunk{},
-		}
+		startReq.Messages = append(startReq.Messages, &types.Message{
+			Role:    types.CreatorType(m.Role),
+			Content: chatCompletionMessageContent(m),
+		})
+	}
 
-		interactions = append(interactions, interaction)
+	if len(startReq.Messages) == 0 {
+		return nil, fmt.Errorf("messages must not be empty")
 	}
 
-	newSession := types.CreateSessionRequest{
-		SessionID:        sessionID,
-		SessionMode:      sessionMode,
-		SessionType:      types.SessionTypeText,
-		ModelName:        types.ModelName(chatCompletionRequest.Model),
-		Owner:            userContext.Owner,
-		OwnerType:        userContext.OwnerType,
-		UserInteractions: interactions,
-		Priority:         status.Config.StripeSubscriptionActive,
+	return startReq, nil
+}
+
+// plain messages have a string, multimodal ones a list of text and image
+// parts - image URLs become image asset pointers
+func chatCompletionMessageContent(m types.ChatCompletionMessage) types.MessageContent {
+	if len(m.MultiContent) == 0 {
+		return types.MessageContent{
+			ContentType: types.MessageContentTypeText,
+			Parts:       []any{m.Content},
+		}
 	}
 
-	startReq := &startSessionConfig{
-		sessionID: sessionID,
-		modelName: chatCompletionRequest.Model,
-		start: func() error {
-			_, err := apiServer.Controller.CreateSession(userContext, newSession)
-			return err
-		},
+	var (
+		parts     []any
+		hasImages bool
+	)
+	for _, part := range m.MultiContent {
+		switch part.Type {
+		case types.ChatMessagePartTypeText:
+			parts = append(parts, part.Text)
+		case types.ChatMessagePartTypeImageURL:
+			if part.ImageURL == nil || part.ImageURL.URL == "" {
+				continue
+			}
+			hasImages = true
+			parts = append(parts, map[string]any{
+				"content_type":  types.MessageContentPartTypeImageAssetPointer,
+				"asset_pointer": part.ImageURL.URL,
+			})
+		}
 	}
 
-	if chatCompletionRequest.Stream {
-		apiServer.handleStreamingResponse(res, req, userContext, startReq)
-		return
+	if !hasImages {
+		texts := make([]string, 0, len(parts))
+		for _, part := range parts {
+			texts = append(texts, part.(string))
+		}
+		return types.MessageContent{
+			ContentType: types.MessageContentTypeText,
+			Parts:       []any{strings.Join(texts, "\n")},
+		}
 	}
 
-	apiServer.handleBlockingResponse(res, req, userContext, startReq)
+	return types.MessageContent{
+		ContentType: types.MessageContentTypeMultimodalText,
+		Parts:       parts,
+	}
 }
 
 type startSessionConfig struct {
@@ -122,16 +138,64 @@ type startSessionConfig struct {
 }
 
 func (apiServer *HelixAPIServer) handleStreamingResponse(res http.ResponseWriter, req *http.Request, userContext types.RequestContext, startReq *startSessionConfig) {
-	// Set chunking headers
-	res.Header().Set("Cache-Control", "no-cache")
-	res.Header().Set("Connection", "keep-alive")
-	res.Header().Set("Transfer-Encoding", "chunked")
-	res.Header().Set("Content-Type", "text/event-stream")
-
 	logger := log.With().Str("session_id", startReq.sessionID).Logger()
 
 	doneCh := make(chan struct{})
 
+	// streamed chunks come from the runner over the websocket while the
+	// final result comes through the api as a session update so they can
+	// race - we remember what we've sent to catch up from the result and
+	// ignore anything that turns up once we've finished
+	var (
+		mu       sync.Mutex
+		finished bool
+		streamed strings.Builder
+	)
+
+	// must be called with mu held
+	finish := func(errMessage string) error {
+		if finished {
+			return nil
+		}
+		finished = true
+		defer close(doneCh)
+		return writeStreamEnd(res, startReq, errMessage)
+	}
+
+	// must be called with mu held
+	write := func(message string) error {
+		if finished || message == "" {
+			return nil
+		}
+		chunk, err := json.Marshal(createChatCompletionChunk(startReq.sessionID, string(startReq.modelName), message))
+		if err != nil {
+			return fmt.Errorf("error marshalling chunk: %w", err)
+		}
+		streamed.WriteString(message)
+		return writeChunk(res, chunk)
+	}
+
+	// finish once the interaction is complete (or has failed), sending
+	// whatever the stream hasn't got to yet
+	// must be called with mu held
+	finishInteraction := func(interaction *types.Interaction) error {
+		switch {
+		case interaction.State == types.InteractionStateError:
+			logger.Debug().Msgf("session errored: %s", interaction.Error)
+			return finish(interaction.Error)
+		case interaction.State == types.InteractionStateComplete && interaction.Creator == types.CreatorTypeSystem && interaction.Finished:
+			logger.Debug().Msgf("session finished")
+			if rest, ok := strings.CutPrefix(interaction.Message, streamed.String()); ok {
+				err := write(rest)
+				if err != nil {
+					return err
+				}
+			}
+			return finish("")
+		}
+		return nil
+	}
+
 	sub, err := apiServer.pubsub.Subscribe(req.Context(), pubsub.GetSessionQueue(userContext.Owner, startReq.sessionID), func(payload []byte) error {
 		var event types.WebsocketEvent
 		err := json.Unmarshal(payload, &event)
@@ -139,26 +203,20 @@ func (apiServer *HelixAPIServer) handleStreamingResponse(res http.ResponseWriter
 			return fmt.Errorf("error unmarshalling websocket event '%s': %w", string(payload), err)
 		}
 
-		// If we get a worker task response with done=true, we need to send a final chunk
-		if event.WorkerTaskResponse != nil && event.WorkerTaskResponse.Done {
-			logger.Debug().Msgf("session finished")
-
-			lastChunk := createChatCompletionChunk(startReq.sessionID, string(startReq.modelName), "")
-			lastChunk.Choices[0].FinishReason = "stop"
+		mu.Lock()
+		defer mu.Unlock()
 
-			respData, err := json.Marshal(lastChunk)
-			if err != nil {
-				return fmt.Errorf("error marshalling websocket event '%+v': %w", event, err)
-			}
+		// not every runtime sends a done chunk and errors (or cancellations)
+		// only ever show up as a session update
+		if event.Type == types.WebsocketEventSessionUpdate && event.Session != nil && len(event.Session.Interactions) > 0 {
+			return finishInteraction(event.Session.Interactions[len(event.Session.Interactions)-1])
+		}
 
-			err = writeChunk(res, respData)
-			if err != nil {
-				return err
+		if event.Type == types.WebsocketEventStreamDelta && event.StreamDelta != nil {
+			if event.StreamDelta.Done && event.StreamDelta.Interaction != nil {
+				return finishInteraction(event.StreamDelta.Interaction)
 			}
-
-			// Close connection
-			close(doneCh)
-			return nil
+			return write(event.StreamDelta.Delta)
 		}
 
 		// Nothing to do
@@ -166,23 +224,32 @@ func (apiServer *HelixAPIServer) handleStreamingResponse(res http.ResponseWriter
 			return nil
 		}
 
-		// Write chunk
-		chunk, err := json.Marshal(createChatCompletionChunk(startReq.sessionID, string(startReq.modelName), event.WorkerTaskResponse.Message))
-		if err != nil {
-			return fmt.Errorf("error marshalling websocket event '%+v': %w", event, err)
-		}
-
-		err = writeChunk(res, chunk)
-		if err != nil {
-			return err
+		// If we get a worker task response with done=true, we need to send a final chunk
+		if event.WorkerTaskResponse.Done {
+			logger.Debug().Msgf("session finished")
+			return finish("")
 		}
 
-		return nil
+		// progress updates don't have any text for us
+		return write(event.WorkerTaskResponse.Message)
 	})
 	if err != nil {
-		system.NewHTTPError500("failed to subscribe to session updates: %s", err)
+		http.Error(res, fmt.Sprintf("failed to subscribe to session updates: %s", err), http.StatusInternalServerError)
 		return
 	}
+	defer func() {
+		// the response writer can't be used once we've returned
+		mu.Lock()
+		finished = true
+		mu.Unlock()
+		_ = sub.Unsubscribe()
+	}()
+
+	// Set chunking headers
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("Transfer-Encoding", "chunked")
+	res.Header().Set("Content-Type", "text/event-stream")
 
 	// Write first chunk where we present the user with the first message
 	// from the assistant
@@ -191,13 +258,13 @@ func (apiServer *HelixAPIServer) handleStreamingResponse(res http.ResponseWriter
 
 	respData, err := json.Marshal(firstChunk)
 	if err != nil {
-		system.NewHTTPError500("error marshalling websocket event '%+v': %s", firstChunk, err)
+		http.Error(res, fmt.Sprintf("error marshalling first chunk: %s", err), http.StatusInternalServerError)
 		return
 	}
 
 	err = writeChunk(res, respData)
 	if err != nil {
-		system.NewHTTPError500("error writing chunk '%s': %s", string(respData), err)
+		logger.Warn().Err(err).Msg("client went away before the session started")
 		return
 	}
 
@@ -205,20 +272,81 @@ func (apiServer *HelixAPIServer) handleStreamingResponse(res http.ResponseWriter
 	// we can have race-conditions on very fast responses
 	// from the runner
 	err = startReq.start()
-	// _, err = apiServer.Controller.CreateSession(userContext, *session)
 	if err != nil {
-		system.NewHTTPError500("failed to start session: %s", err)
+		// we've already said 200 so the error has to go in the stream
+		mu.Lock()
+		_ = finish(fmt.Sprintf("failed to start session: %s", err))
+		mu.Unlock()
 		return
 	}
 
 	select {
 	case <-doneCh:
-		_ = sub.Unsubscribe()
-		return
 	case <-req.Context().Done():
-		_ = sub.Unsubscribe()
-		return
+		apiServer.cancelAbandonedSession(logger, startReq)
+	case <-apiServer.shutdownCh:
+		// the server is going away, give the stream a bounded amount
+		// of time to finish before we drop it
+		select {
+		case <-doneCh:
+		case <-req.Context().Done():
+			apiServer.cancelAbandonedSession(logger, startReq)
+		case <-time.After(streamingGracePeriod):
+			logger.Warn().Msg("server shutting down, closing unfinished stream")
+		}
+	}
+}
+
+// the client disconnected before the answer was done so stop the session
+// rather than have it keep a runner busy for nobody
+func (apiServer *HelixAPIServer) cancelAbandonedSession(logger zerolog.Logger, startReq *startSessionConfig) {
+	logger.Info().Msg("client disconnected, cancelling session")
+
+	// the request context is already cancelled
+	err := apiServer.Controller.CancelSession(context.Background(), startReq.sessionID)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to cancel session")
+	}
+}
+
+type streamError struct {
+	Error streamErrorDetail `json:"error"`
+}
+
+type streamErrorDetail struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+// the last chunk has the finish reason (or an error if it went wrong) and
+// then the stream is closed with [DONE] like the OpenAI API does
+func writeStreamEnd(w io.Writer, startReq *startSessionConfig, errMessage string) error {
+	var (
+		lastChunk []byte
+		err       error
+	)
+	if errMessage != "" {
+		lastChunk, err = json.Marshal(streamError{
+			Error: streamErrorDetail{
+				Message: errMessage,
+				Type:    "server_error",
+			},
+		})
+	} else {
+		chunk := createChatCompletionChunk(startReq.sessionID, string(startReq.modelName), "")
+		chunk.Choices[0].FinishReason = "stop"
+		lastChunk, err = json.Marshal(chunk)
 	}
+	if err != nil {
+		return fmt.Errorf("error marshalling last chunk: %w", err)
+	}
+
+	err = writeChunk(w, lastChunk)
+	if err != nil {
+		return err
+	}
+
+	return writeChunk(w, []byte("[DONE]"))
 }
 
 // Ref: https://platform.openai.com/docs/api-reference/chat/streaming
@@ -260,13 +388,61 @@ func writeChunk(w io.Writer, chunk []byte) error {
 func (apiServer *HelixAPIServer) handleBlockingResponse(res http.ResponseWriter, req *http.Request, userContext types.RequestContext, startReq *startSessionConfig) {
 	res.Header().Set("Content-Type", "application/json")
 
+	interaction, err := apiServer.runSessionToCompletion(req.Context(), userContext.Owner, startReq)
+	if req.Context().Err() != nil {
+		// the client has gone, there is nobody to answer
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var result []types.Choice
+
+	if interaction.State == types.InteractionStateError {
+		http.Error(res, interaction.Error, http.StatusInternalServerError)
+		return
+	}
+
+	result = append(result, types.Choice{
+		Message: &types.OpenAIMessage{
+			Role:    "assistant",
+			Content: interaction.Message,
+		},
+		FinishReason: "stop",
+	})
+
+	resp := &types.OpenAIResponse{
+		ID:      startReq.sessionID,
+		Created: int(time.Now().Unix()),
+		Model:   string(startReq.modelName), // we have to return what the user sent here, due to OpenAI spec.
+		Choices: result,
+		Object:  "chat.completion",
+		Usage: types.OpenAIUsage{
+			// TODO: calculate
+			PromptTokens:     0,
+			CompletionTokens: 0,
+			TotalTokens:      0,
+		},
+	}
+
+	err = json.NewEncoder(res).Encode(resp)
+	if err != nil {
+		log.Err(err).Msg("error writing response")
+	}
+}
+
+// start the session and wait for the model to finish its last interaction,
+// which might have errored - the session is cancelled if ctx is done first
+func (apiServer *HelixAPIServer) runSessionToCompletion(ctx context.Context, owner string, startReq *startSessionConfig) (*types.Interaction, error) {
 	doneCh := make(chan struct{})
 
 	var updatedSession *types.Session
 
 	// Wait for the results from the session update. Last event will have the interaction with the full
 	// response from the model.
-	sub, err := apiServer.pubsub.Subscribe(req.Context(), pubsub.GetSessionQueue(userContext.Owner, startReq.sessionID), func(payload []byte) error {
+	sub, err := apiServer.pubsub.Subscribe(ctx, pubsub.GetSessionQueue(owner, startReq.sessionID), func(payload []byte) error {
 		var event types.WebsocketEvent
 		err := json.Unmarshal(payload, &event)
 		if err != nil {
@@ -277,7 +453,8 @@ func (apiServer *HelixAPIServer) handleBlockingResponse(res http.ResponseWriter,
 			return nil
 		}
 
-		if event.Session.Interactions[len(event.Session.Interactions)-1].State == types.InteractionStateComplete {
+		state := event.Session.Interactions[len(event.Session.Interactions)-1].State
+		if state == types.InteractionStateComplete || state == types.InteractionStateError {
 			// We are done
 			updatedSession = event.Session
 			close(doneCh)
@@ -288,8 +465,7 @@ func (apiServer *HelixAPIServer) handleBlockingResponse(res http.ResponseWriter,
 		return nil
 	})
 	if err != nil {
-		system.NewHTTPError500("failed to subscribe to session updates: %s", err)
-		return
+		return nil, fmt.Errorf("failed to subscribe to session updates: %s", err)
 	}
 
 	// After subscription, start the session, otherwise
@@ -297,58 +473,28 @@ func (apiServer *HelixAPIServer) handleBlockingResponse(res http.ResponseWriter,
 	// from the runner
 	err = startReq.start()
 	if err != nil {
-		system.NewHTTPError500("failed to start session: %s", err)
-		return
+		sub.Unsubscribe()
+		return nil, fmt.Errorf("failed to start session: %s", err)
 	}
 
 	select {
 	case <-doneCh:
 		sub.Unsubscribe()
 		// Continue with response
-	case <-req.Context().Done():
+	case <-ctx.Done():
 		sub.Unsubscribe()
-		return
+		apiServer.cancelAbandonedSession(log.With().Str("session_id", startReq.sessionID).Logger(), startReq)
+		return nil, ctx.Err()
 	}
 
 	if updatedSession == nil {
-		http.Error(res, "session update not received", http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("session update not received")
 	}
 
-	if updatedSession.Interactions == nil || len(updatedSession.Interactions) == 0 {
-		http.Error(res, "session update does not contain any interactions", http.StatusInternalServerError)
-		return
+	if len(updatedSession.Interactions) == 0 {
+		return nil, fmt.Errorf("session update does not contain any interactions")
 	}
 
-	var result []types.Choice
-
 	// Take the last interaction
-	interaction := updatedSession.Interactions[len(updatedSession.Interactions)-1]
-
-	result = append(result, types.Choice{
-		Message: &types.OpenAIMessage{
-			Role:    "assistant",
-			Content: interaction.Message,
-		},
-		FinishReason: "stop",
-	})
-
-	resp := &types.OpenAIResponse{
-		ID:      startReq.sessionID,
-		Created: int(time.Now().Unix()),
-		Model:   string(startReq.modelName), // we have to return what the user sent here, due to OpenAI spec.
-		Choices: result,
-		Object:  "chat.completion",
-		Usage: types.OpenAIUsage{
-			// TODO: calculate
-			PromptTokens:     0,
-			CompletionTokens: 0,
-			TotalTokens:      0,
-		},
-	}
-
-	err = json.NewEncoder(res).Encode(resp)
-	if err != nil {
-		log.Err(err).Msg("error writing response")
-	}
+	return updatedSession.Interactions[len(updatedSession.Interactions)-1], nil
 }
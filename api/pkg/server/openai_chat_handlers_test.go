@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -16,9 +17,9 @@ import (
 
 	"github.com/helixml/helix/api/pkg/config"
 	"github.com/helixml/helix/api/pkg/controller"
-	"github.com/helixml/helix/api/pkg/janitor"
 	"github.com/helixml/helix/api/pkg/pubsub"
 	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/system"
 	"github.com/helixml/helix/api/pkg/types"
 )
 
@@ -58,15 +59,10 @@ func (suite *OpenAIChatSuite) SetupTest() {
 	cfg.Tools.Enabled = false
 
 	suite.server = &HelixAPIServer{
-		pubsub: suite.pubsub,
-		Controller: &controller.Controller{
-			Options: controller.ControllerOptions{
-				Config:  cfg,
-				Store:   suite.store,
-				Janitor: janitor.NewJanitor(janitor.JanitorOptions{}),
-			},
-		},
-		adminAuth: &adminAuth{},
+		pubsub:     suite.pubsub,
+		Store:      suite.store,
+		Controller: newTestController(suite.T(), cfg, suite.store),
+		adminAuth:  &adminAuth{},
 	}
 }
 
@@ -306,3 +302,358 @@ func (suite *OpenAIChatSuite) TestChatCompletions_Streaming() {
 	suite.True(startFound, "start chunk not found")
 	suite.True(stopFound, "stop chunk not found")
 }
+
+// reads the data lines out of an event stream
+func readStreamEvents(body *bytes.Buffer) []string {
+	var events []string
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data: ") {
+			events = append(events, strings.TrimPrefix(line, "data: "))
+		}
+	}
+	return events
+}
+
+func (suite *OpenAIChatSuite) startStreamingSession(ctx context.Context, events func(sessionID string) []*types.WebsocketEvent) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/api/v1/sessions/chat", bytes.NewBufferString(`{
+		"model": "mistralai/Mistral-7B-Instruct-v0.1",
+		"stream": true,
+		"messages": [
+			{
+				"role": "user",
+				"content": {"content_type": "text", "parts": ["tell me about oceans!"]}
+			}
+		]
+	}`))
+	suite.NoError(err)
+
+	req = req.WithContext(ctx)
+
+	rec := httptest.NewRecorder()
+
+	suite.store.EXPECT().GetUserMeta(gomock.Any(), "user_id").Return(&types.UserMeta{}, nil)
+
+	suite.store.EXPECT().CreateSession(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, session types.Session) (*types.Session, error) {
+			time.AfterFunc(100*time.Millisecond, func() {
+				for _, event := range events(session.ID) {
+					bts, err := json.Marshal(event)
+					suite.NoError(err)
+
+					suite.pubsub.Publish(context.Background(), pubsub.GetSessionQueue("user_id", session.ID), bts)
+				}
+			})
+
+			return &session, nil
+		})
+
+	suite.server.startSessionHandler(rec, req)
+
+	return rec
+}
+
+func (suite *OpenAIChatSuite) TestSessionChat_Streaming_CatchesUpFromResult() {
+	rec := suite.startStreamingSession(suite.authCtx, func(sessionID string) []*types.WebsocketEvent {
+		return []*types.WebsocketEvent{
+			{
+				Type:               types.WebsocketEventWorkerTaskResponse,
+				WorkerTaskResponse: &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeStream, Message: "The ocean"},
+			},
+			// the result got to the api before the rest of the stream
+			{
+				Type: types.WebsocketEventSessionUpdate,
+				Session: &types.Session{
+					ID: sessionID,
+					Interactions: []*types.Interaction{
+						{Creator: types.CreatorTypeUser, State: types.InteractionStateComplete, Finished: true},
+						{Creator: types.CreatorTypeSystem, State: types.InteractionStateComplete, Finished: true, Message: "The ocean is big"},
+					},
+				},
+			},
+			{
+				Type:               types.WebsocketEventWorkerTaskResponse,
+				WorkerTaskResponse: &types.RunnerTaskResponse{Type: types.WorkerTaskResponseTypeStream, Message: " is big"},
+			},
+		}
+	})
+
+	suite.Equal(http.StatusOK, rec.Code)
+	suite.Equal("text/event-stream", rec.Header().Get("Content-Type"))
+
+	events := readStreamEvents(rec.Body)
+	suite.Require().Len(events, 5)
+	suite.Equal("[DONE]", events[4])
+
+	var content string
+	for _, event := range events[:4] {
+		var chunk types.OpenAIResponse
+		suite.NoError(json.Unmarshal([]byte(event), &chunk))
+		content += chunk.Choices[0].Delta.Content
+	}
+	suite.Equal("The ocean is big", content)
+
+	var lastChunk types.OpenAIResponse
+	suite.NoError(json.Unmarshal([]byte(events[3]), &lastChunk))
+	suite.Equal("stop", lastChunk.Choices[0].FinishReason)
+}
+
+func (suite *OpenAIChatSuite) TestSessionChat_Streaming_Deltas() {
+	rec := suite.startStreamingSession(suite.authCtx, func(sessionID string) []*types.WebsocketEvent {
+		delta := func(text string) *types.WebsocketEvent {
+			return &types.WebsocketEvent{
+				Type:        types.WebsocketEventStreamDelta,
+				SessionID:   sessionID,
+				StreamDelta: &types.StreamDelta{InteractionID: "system-1", Delta: text},
+			}
+		}
+		return []*types.WebsocketEvent{
+			delta("The ocean"),
+			delta(" is"),
+			// the last delta has the whole interaction so we catch up from it
+			{
+				Type:      types.WebsocketEventStreamDelta,
+				SessionID: sessionID,
+				StreamDelta: &types.StreamDelta{
+					InteractionID: "system-1",
+					Done:          true,
+					Interaction:   &types.Interaction{ID: "system-1", Creator: types.CreatorTypeSystem, State: types.InteractionStateComplete, Finished: true, Message: "The ocean is big"},
+				},
+			},
+		}
+	})
+
+	suite.Equal(http.StatusOK, rec.Code)
+
+	// the role, the two deltas, what they missed, the stop and [DONE]
+	events := readStreamEvents(rec.Body)
+	suite.Require().Len(events, 6)
+	suite.Equal("[DONE]", events[5])
+
+	var content string
+	for _, event := range events[:5] {
+		var chunk types.OpenAIResponse
+		suite.NoError(json.Unmarshal([]byte(event), &chunk))
+		content += chunk.Choices[0].Delta.Content
+	}
+	suite.Equal("The ocean is big", content)
+}
+
+func (suite *OpenAIChatSuite) TestSessionChat_Streaming_Error() {
+	rec := suite.startStreamingSession(suite.authCtx, func(sessionID string) []*types.WebsocketEvent {
+		return []*types.WebsocketEvent{
+			{
+				Type: types.WebsocketEventSessionUpdate,
+				Session: &types.Session{
+					ID: sessionID,
+					Interactions: []*types.Interaction{
+						{Creator: types.CreatorTypeUser, State: types.InteractionStateComplete, Finished: true},
+						{Creator: types.CreatorTypeSystem, State: types.InteractionStateError, Finished: true, Error: "out of memory"},
+					},
+				},
+			},
+		}
+	})
+
+	events := readStreamEvents(rec.Body)
+	suite.Require().Len(events, 3)
+	suite.Contains(events[1], "out of memory")
+	suite.Equal("[DONE]", events[2])
+}
+
+func (suite *OpenAIChatSuite) TestSessionChat_Streaming_DisconnectCancelsSession() {
+	ctx, cancel := context.WithCancel(suite.authCtx)
+	defer cancel()
+
+	suite.store.EXPECT().GetSession(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, id string) (*types.Session, error) {
+			return &types.Session{
+				ID:    id,
+				Owner: "user_id",
+				Interactions: []*types.Interaction{
+					{ID: "user-1", Creator: types.CreatorTypeUser, State: types.InteractionStateComplete, Finished: true},
+					{ID: "system-1", Creator: types.CreatorTypeSystem, State: types.InteractionStateWaiting},
+				},
+			}, nil
+		})
+
+	var cancelled *types.Session
+	suite.store.EXPECT().UpdateSession(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, session types.Session) (*types.Session, error) {
+			cancelled = &session
+			return &session, nil
+		})
+
+	var sessionID string
+	rec := suite.startStreamingSession(ctx, func(id string) []*types.WebsocketEvent {
+		sessionID = id
+		// the client gives up before anything comes back
+		cancel()
+		return nil
+	})
+
+	suite.Equal(http.StatusOK, rec.Code)
+
+	suite.Require().NotNil(cancelled)
+	suite.Equal(sessionID, cancelled.ID)
+	suite.Equal(types.InteractionStateError, cancelled.Interactions[1].State)
+	suite.Equal(controller.ErrSessionCancelled.Error(), cancelled.Interactions[1].Error)
+	suite.True(cancelled.Interactions[1].Finished)
+}
+
+func (suite *OpenAIChatSuite) TestChatCompletionToSessionChatRequest() {
+	var chatCompletionRequest types.ChatCompletionRequest
+	suite.NoError(json.Unmarshal([]byte(`{
+		"model": "llama3:instruct",
+		"temperature": 0.2,
+		"top_p": 0.5,
+		"max_tokens": 64,
+		"stop": ["\n\n"],
+		"n": 3,
+		"tools": [{"type": "function", "function": {"name": "get_weather"}}],
+		"messages": [
+			{"role": "system", "content": "You are a helpful assistant."},
+			{"role": "user", "content": [
+				{"type": "text", "text": "what is in this picture?"},
+				{"type": "image_url", "image_url": {"url": "https://example.com/cat.png"}}
+			]},
+			{"role": "assistant", "content": "a cat"},
+			{"role": "tool", "content": "{}"},
+			{"role": "user", "content": [{"type": "text", "text": "what colour"}, {"type": "text", "text": "is it?"}]}
+		]
+	}`), &chatCompletionRequest))
+
+	startReq, err := chatCompletionToSessionChatRequest(&chatCompletionRequest)
+	suite.Require().NoError(err)
+
+	suite.Equal(string(types.Model_Ollama_Llama3_8b), startReq.Model)
+	temperature, topP := float32(0.2), float32(0.5)
+	suite.Equal(types.SamplingParams{Temperature: &temperature, TopP: &topP, MaxTokens: 64, Stop: []string{"\n\n"}}, startReq.SamplingParams)
+	suite.Equal(types.SessionModeInference, startReq.Mode)
+	suite.Equal(types.SessionTypeText, startReq.Type)
+
+	// the tool message is dropped
+	interactions, err := messagesToInteractions(startReq.Messages)
+	suite.Require().NoError(err)
+	suite.Require().Len(interactions, 4)
+
+	suite.Equal(types.CreatorTypeSystem, interactions[0].Creator)
+	suite.Equal("what is in this picture?", interactions[1].Message)
+	suite.Equal([]string{"https://example.com/cat.png"}, interactions[1].Images)
+	suite.Equal(types.CreatorTypeAssistant, interactions[2].Creator)
+	suite.Equal("what colour\nis it?", interactions[3].Message)
+
+	_, err = chatCompletionToSessionChatRequest(&types.ChatCompletionRequest{
+		Messages: []types.ChatCompletionMessage{{Role: "narrator", Content: "once upon a time"}},
+	})
+	suite.Error(err)
+}
+
+func (suite *OpenAIChatSuite) TestChatCompletions_RequiresAPIKey() {
+	suite.server.keyCloakMiddleware = &keyCloakMiddleware{store: suite.store}
+
+	req, err := http.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{}`))
+	suite.NoError(err)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.keyCloakMiddleware.apiKeyAuth(suite.server.createChatCompletion)(rec, req)
+
+	suite.Equal(http.StatusUnauthorized, rec.Code)
+}
+
+func (suite *OpenAIChatSuite) TestSessionChat_SystemPromptTemplate() {
+	newRequest := func(systemPrompt string) *http.Request {
+		body, err := json.Marshal(types.SessionChatRequest{
+			Model:        string(types.Model_Ollama_Mistral7b),
+			SystemPrompt: systemPrompt,
+			Messages: []*types.Message{{
+				Role:    types.CreatorTypeUser,
+				Content: types.MessageContent{ContentType: types.MessageContentTypeText, Parts: []any{"hello"}},
+			}},
+		})
+		suite.NoError(err)
+		req, err := http.NewRequest("POST", "/api/v1/sessions/chat", bytes.NewBuffer(body))
+		suite.NoError(err)
+		return req.WithContext(suite.authCtx)
+	}
+
+	suite.store.EXPECT().GetUserMeta(gomock.Any(), "user_id").Return(&types.UserMeta{}, nil).Times(2)
+
+	suite.store.EXPECT().CreateSession(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, session types.Session) (*types.Session, error) {
+			suite.Equal("You are talking to Foo Bar ("+suite.userID+") in "+session.ID, session.Metadata.SystemPrompt)
+			return nil, fmt.Errorf("stop here")
+		})
+
+	rec := httptest.NewRecorder()
+	suite.server.startSessionHandler(rec, newRequest("You are talking to {{ .User.FullName }} ({{ .User.ID }}) in {{ .Session.ID }}"))
+	suite.Equal(http.StatusInternalServerError, rec.Code)
+
+	// an unknown variable is a bad request and no session is created
+	rec = httptest.NewRecorder()
+	suite.server.startSessionHandler(rec, newRequest("You are talking to {{ .User.Nickname }}"))
+	suite.Equal(http.StatusBadRequest, rec.Code)
+	suite.Contains(rec.Body.String(), "invalid system prompt template")
+}
+
+func (suite *OpenAIChatSuite) TestSessionChat_Tools() {
+	newRequest := func(tools ...string) *http.Request {
+		body, err := json.Marshal(types.SessionChatRequest{
+			Model: string(types.Model_Ollama_Mistral7b),
+			Tools: tools,
+			Messages: []*types.Message{{
+				Role:    types.CreatorTypeUser,
+				Content: types.MessageContent{ContentType: types.MessageContentTypeText, Parts: []any{"hello"}},
+			}},
+		})
+		suite.NoError(err)
+		req, err := http.NewRequest("POST", "/api/v1/sessions/chat", bytes.NewBuffer(body))
+		suite.NoError(err)
+		return req.WithContext(suite.authCtx)
+	}
+
+	ownTool := &types.Tool{ID: "tool_own", Name: "weather", Owner: suite.userID, OwnerType: types.OwnerTypeUser}
+	otherTool := &types.Tool{ID: "tool_other", Name: "payroll", Owner: "other_user", OwnerType: types.OwnerTypeUser}
+
+	suite.store.EXPECT().GetUserMeta(gomock.Any(), "user_id").Return(&types.UserMeta{}, nil).Times(3)
+
+	// by ID and by name, the tool is only bound once
+	suite.store.EXPECT().GetTool(gomock.Any(), "tool_own").Return(ownTool, nil)
+	suite.store.EXPECT().GetTool(gomock.Any(), "weather").Return(nil, store.ErrNotFound)
+	suite.store.EXPECT().ListTools(gomock.Any(), &store.ListToolsQuery{
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}).Return([]*types.Tool{ownTool}, nil)
+	suite.store.EXPECT().CreateSessionToolBinding(gomock.Any(), gomock.Any(), "tool_own").Return(nil)
+	suite.store.EXPECT().CreateSession(gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("stop here"))
+
+	rec := httptest.NewRecorder()
+	suite.server.startSessionHandler(rec, newRequest("tool_own", "weather"))
+	suite.Equal(http.StatusInternalServerError, rec.Code)
+
+	// someone else's tool is forbidden and nothing is bound
+	suite.store.EXPECT().GetTool(gomock.Any(), "tool_other").Return(otherTool, nil)
+
+	rec = httptest.NewRecorder()
+	suite.server.startSessionHandler(rec, newRequest("tool_other"))
+	suite.Equal(http.StatusForbidden, rec.Code)
+
+	var errResp system.HTTPErrorResponse
+	suite.NoError(json.Unmarshal(rec.Body.Bytes(), &errResp))
+	suite.Equal(system.HTTPErrorCodeToolForbidden, errResp.Error.Code)
+	suite.Equal(map[string]any{"tool_id": "tool_other"}, errResp.Error.Details)
+
+	// as is one that doesn't exist, with its own error
+	suite.store.EXPECT().GetTool(gomock.Any(), "missing").Return(nil, store.ErrNotFound)
+	suite.store.EXPECT().ListTools(gomock.Any(), gomock.Any()).Return([]*types.Tool{ownTool}, nil)
+
+	rec = httptest.NewRecorder()
+	suite.server.startSessionHandler(rec, newRequest("missing"))
+	suite.Equal(http.StatusBadRequest, rec.Code)
+
+	suite.NoError(json.Unmarshal(rec.Body.Bytes(), &errResp))
+	suite.Equal(system.HTTPErrorCodeToolNotFound, errResp.Error.Code)
+	suite.Equal(map[string]any{"tool_id": "missing"}, errResp.Error.Details)
+}
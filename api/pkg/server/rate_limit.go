@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// how often we look for buckets nobody has used in a while
+const rateLimitGCInterval = time.Minute
+
+type RateLimitOptions struct {
+	// how many requests an owner can make per minute once they have
+	// used up their burst - zero means no limit
+	RequestsPerMinute int
+	// how many requests an owner can make in one go
+	Burst int
+}
+
+// a token bucket per owner - each request takes a token and tokens
+// refill at RequestsPerMinute up to Burst
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	mtx     sync.Mutex
+	limits  map[types.OwnerType]RateLimitOptions
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+func newRateLimiter(limits map[types.OwnerType]RateLimitOptions) *rateLimiter {
+	return &rateLimiter{
+		limits:  limits,
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+func (limits RateLimitOptions) ratePerSecond() float64 {
+	return float64(limits.RequestsPerMinute) / 60
+}
+
+func (limits RateLimitOptions) burst() float64 {
+	if limits.Burst < 1 {
+		return 1
+	}
+	return float64(limits.Burst)
+}
+
+// takes a token from the owner's bucket - if there are none left we
+// return how long until the next one is available
+func (l *rateLimiter) allow(ownerType types.OwnerType, owner string) (bool, time.Duration) {
+	limits, ok := l.limits[ownerType]
+	if !ok || limits.RequestsPerMinute <= 0 {
+		return true, 0
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	key := fmt.Sprintf("%s:%s", ownerType, owner)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{
+			tokens:   limits.burst(),
+			lastSeen: now,
+		}
+		l.buckets[key] = bucket
+	}
+
+	elapsed := now.Sub(bucket.lastSeen).Seconds()
+	bucket.tokens = math.Min(limits.burst(), bucket.tokens+elapsed*limits.ratePerSecond())
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		wait := (1 - bucket.tokens) / limits.ratePerSecond()
+		return false, time.Duration(wait * float64(time.Second))
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// a bucket that would have refilled by now is the same as no bucket
+// so we can drop it and keep memory down
+func (l *rateLimiter) gc() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	for key, bucket := range l.buckets {
+		idle := now.Sub(bucket.lastSeen)
+		if idle < rateLimitGCInterval {
+			continue
+		}
+		full := true
+		for _, limits := range l.limits {
+			if limits.RequestsPerMinute > 0 && bucket.tokens+idle.Seconds()*limits.ratePerSecond() < limits.burst() {
+				full = false
+			}
+		}
+		if full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *rateLimiter) startGC(ctx context.Context) {
+	ticker := time.NewTicker(rateLimitGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.gc()
+		}
+	}
+}
+
+// wraps the endpoints that kick off inference or data prep so a single
+// owner cannot starve everyone else - admins are never limited
+func (apiServer *HelixAPIServer) rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if apiServer.rateLimiter == nil {
+			next(res, req)
+			return
+		}
+
+		reqContext := apiServer.getRequestContext(req)
+		if reqContext.Admin {
+			next(res, req)
+			return
+		}
+
+		allowed, retryAfter := apiServer.rateLimiter.allow(reqContext.OwnerType, reqContext.Owner)
+		if !allowed {
+			log.Ctx(req.Context()).Warn().
+				Str("owner", reqContext.Owner).
+				Str("path", req.URL.Path).
+				Msg("rate limit exceeded")
+			res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(res, "rate limit exceeded, please try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		next(res, req)
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newTestRateLimiter(now *time.Time) *rateLimiter {
+	limiter := newRateLimiter(map[types.OwnerType]RateLimitOptions{
+		types.OwnerTypeUser: {
+			RequestsPerMinute: 60,
+			Burst:             2,
+		},
+	})
+	limiter.now = func() time.Time { return *now }
+	return limiter
+}
+
+func Test_rateLimiter_Burst(t *testing.T) {
+	now := time.Now()
+	limiter := newTestRateLimiter(&now)
+
+	allowed, _ := limiter.allow(types.OwnerTypeUser, "user_id")
+	assert.True(t, allowed)
+	allowed, _ = limiter.allow(types.OwnerTypeUser, "user_id")
+	assert.True(t, allowed)
+
+	allowed, retryAfter := limiter.allow(types.OwnerTypeUser, "user_id")
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, retryAfter)
+
+	// other owners have their own bucket
+	allowed, _ = limiter.allow(types.OwnerTypeUser, "other_user_id")
+	assert.True(t, allowed)
+}
+
+func Test_rateLimiter_Refill(t *testing.T) {
+	now := time.Now()
+	limiter := newTestRateLimiter(&now)
+
+	limiter.allow(types.OwnerTypeUser, "user_id")
+	limiter.allow(types.OwnerTypeUser, "user_id")
+	allowed, _ := limiter.allow(types.OwnerTypeUser, "user_id")
+	assert.False(t, allowed)
+
+	now = now.Add(time.Second)
+
+	allowed, _ = limiter.allow(types.OwnerTypeUser, "user_id")
+	assert.True(t, allowed)
+}
+
+func Test_rateLimiter_NoLimitConfigured(t *testing.T) {
+	limiter := newRateLimiter(map[types.OwnerType]RateLimitOptions{})
+
+	for i := 0; i < 100; i++ {
+		allowed, _ := limiter.allow(types.OwnerTypeUser, "user_id")
+		assert.True(t, allowed)
+	}
+	assert.Empty(t, limiter.buckets)
+}
+
+func Test_rateLimiter_GC(t *testing.T) {
+	now := time.Now()
+	limiter := newTestRateLimiter(&now)
+
+	limiter.allow(types.OwnerTypeUser, "user_id")
+	limiter.allow(types.OwnerTypeUser, "user_id")
+
+	limiter.gc()
+	assert.Len(t, limiter.buckets, 1)
+
+	now = now.Add(rateLimitGCInterval)
+
+	limiter.gc()
+	assert.Empty(t, limiter.buckets)
+}
+
+func Test_rateLimit_Middleware(t *testing.T) {
+	now := time.Now()
+	apiServer := &HelixAPIServer{
+		adminAuth:   newAdminAuth([]string{"admin_id"}),
+		rateLimiter: newTestRateLimiter(&now),
+	}
+	handler := apiServer.rateLimit(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	})
+
+	makeRequest := func(userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/sessions/chat", nil)
+		req = req.WithContext(setRequestUser(req.Context(), types.UserData{ID: userID}))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, makeRequest("user_id").Code)
+	assert.Equal(t, http.StatusOK, makeRequest("user_id").Code)
+
+	rec := makeRequest("user_id")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	// admins are never limited
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, makeRequest("admin_id").Code)
+	}
+}
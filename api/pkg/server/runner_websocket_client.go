@@ -85,7 +85,11 @@ func ConnectRunnerWebSocketClient(
 					if closed {
 						return
 					}
-					log.Error().Msgf("Read error: %s\nReconnecting in 2 seconds...", err)
+					if websocket.IsCloseError(err, websocket.CloseGoingAway) {
+						log.Info().Msgf("Control plane is restarting\nReconnecting in 2 seconds...")
+					} else {
+						log.Error().Msgf("Read error: %s\nReconnecting in 2 seconds...", err)
+					}
 					time.Sleep(2 * time.Second)
 					finished <- true
 					ConnectRunnerWebSocketClient(url, websocketEventChan, ctx)
@@ -28,6 +28,7 @@ func StartRunnerWebSocketServer(
 	path string,
 	websocketEventChan chan *types.WebsocketEvent,
 	authHandler AuthenticateRequest,
+	websockets *websocketTracker,
 ) {
 	var mutex = &sync.Mutex{}
 
@@ -66,6 +67,11 @@ func StartRunnerWebSocketServer(
 		defer conn.Close()
 		defer removeConnection(conn)
 
+		// nothing is written to runners, they are only tracked so shutdown
+		// can close them with a going away frame
+		tracked := websockets.add(conn)
+		defer websockets.remove(tracked)
+
 		// extract the runner ID from the query parameter
 		runnerID := r.URL.Query().Get("runnerid")
 		addConnection(conn, runnerID)
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
@@ -19,10 +20,19 @@ import (
 	"github.com/helixml/helix/api/pkg/store"
 	"github.com/helixml/helix/api/pkg/stripe"
 	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
 )
 
 const API_PREFIX = "/api/v1"
 
+const (
+	// how long we give in-flight requests to drain once shutdown has started
+	shutdownTimeout = 30 * time.Second
+	// how long a streaming session response can keep writing once shutdown
+	// has started - this must be shorter than shutdownTimeout
+	streamingGracePeriod = 20 * time.Second
+)
+
 type ServerOptions struct {
 	URL           string
 	Host          string
@@ -44,6 +54,15 @@ type ServerOptions struct {
 	// (this is so helix nodes can see files)
 	// later, we might add a token to the URLs
 	LocalFilestorePath string
+	// the origins that can make cross origin requests to the api
+	// if this is empty (or includes '*') then any origin is allowed
+	AllowedOrigins []string
+	// how many inference and data prep requests each type of owner can make
+	RateLimits map[types.OwnerType]RateLimitOptions
+	// how long we remember an Idempotency-Key sent when creating something
+	IdempotencyKeyTTL time.Duration
+	// the level and format of the api's logs
+	Logging system.LoggingOptions
 }
 
 type HelixAPIServer struct {
@@ -58,7 +77,13 @@ type HelixAPIServer struct {
 	keyCloakMiddleware *keyCloakMiddleware
 	pubsub             pubsub.PubSub
 	// planner            tools.Planner
-	router *mux.Router
+	router      *mux.Router
+	rateLimiter *rateLimiter
+	// closed when the server starts shutting down so long-lived
+	// handlers (e.g. streaming responses) can wrap up
+	shutdownCh chan struct{}
+	// the open websockets, sent a going away frame on shutdown
+	websockets websocketTracker
 }
 
 func NewServer(
@@ -109,6 +134,8 @@ func NewServer(
 		keycloak:           keycloak,
 		keyCloakMiddleware: newMiddleware(keycloak, options, store),
 		pubsub:             ps,
+		shutdownCh:         make(chan struct{}),
+		rateLimiter:        newRateLimiter(options.RateLimits),
 	}, nil
 }
 
@@ -131,17 +158,67 @@ func (apiServer *HelixAPIServer) ListenAndServe(ctx context.Context, cm *system.
 		"/ws/runner",
 		apiServer.Controller.RunnerWebsocketEventChanReader,
 		apiServer.runnerAuth.isRequestAuthenticated,
+		&apiServer.websockets,
 	)
 
+	go apiServer.rateLimiter.startGC(ctx)
+	go apiServer.startIdempotencyKeyGC(ctx)
+
 	srv := &http.Server{
 		Addr:              fmt.Sprintf("%s:%d", apiServer.Options.Host, apiServer.Options.Port),
 		WriteTimeout:      time.Minute * 15,
 		ReadTimeout:       time.Minute * 15,
 		ReadHeaderTimeout: time.Minute * 15,
 		IdleTimeout:       time.Minute * 60,
-		Handler:           apiServer.router,
+		Handler:           accessLogMiddleware(apiServer.corsMiddleware(apiServer.router)),
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		apiServer.shutdown(srv)
+	}()
+
+	// make sure the process waits for connections to drain before exiting
+	cm.RegisterCallbackWithContext(func(ctx context.Context) error {
+		select {
+		case <-shutdownDone:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	err = srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
-	return srv.ListenAndServe()
+	return nil
+}
+
+// shutdown stops accepting new connections and waits for in-flight requests
+// to finish, forcefully closing anything still open after shutdownTimeout
+func (apiServer *HelixAPIServer) shutdown(srv *http.Server) {
+	log.Info().Msg("shutting down api server, draining connections")
+
+	close(apiServer.shutdownCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// websockets are hijacked so srv.Shutdown won't wait for them, tell the
+	// clients we are going away first so they reconnect rather than error
+	apiServer.drainWebsockets(ctx)
+
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to gracefully shut down api server, closing remaining connections")
+		_ = srv.Close()
+		return
+	}
+
+	log.Info().Msg("api server shut down")
 }
 
 func (apiServer *HelixAPIServer) registerRoutes(ctx context.Context) (*mux.Router, error) {
@@ -150,7 +227,6 @@ func (apiServer *HelixAPIServer) registerRoutes(ctx context.Context) (*mux.Route
 	if err != nil {
 		return nil, err
 	}
-	// router.Use(apiServer.corsMiddleware)
 	router.Use(errorLoggingMiddleware)
 
 	subrouter := router.PathPrefix(API_PREFIX).Subrouter()
@@ -189,7 +265,11 @@ func (apiServer *HelixAPIServer) registerRoutes(ctx context.Context) (*mux.Route
 	// the stripe library handles http management
 	subrouter.HandleFunc("/stripe/webhook", apiServer.subscriptionWebhook).Methods("POST")
 
+	// not authenticated either, the signed token in the link is the access
+	subrouter.HandleFunc("/share/{token}", system.Wrapper(apiServer.getSharedSession)).Methods("GET")
+
 	authRouter.HandleFunc("/status", system.DefaultWrapper(apiServer.status)).Methods("GET")
+	authRouter.HandleFunc("/quotas/sessions", system.Wrapper(apiServer.getSessionQuota)).Methods("GET")
 
 	// the auth here is handled because we prefix the user path based on the auth context
 	// e.g. /sessions/123 becomes /users/456/sessions/123
@@ -205,11 +285,16 @@ func (apiServer *HelixAPIServer) registerRoutes(ctx context.Context) (*mux.Route
 	authRouter.HandleFunc("/subscription/new", system.DefaultWrapper(apiServer.subscriptionCreate)).Methods("POST")
 	authRouter.HandleFunc("/subscription/manage", system.DefaultWrapper(apiServer.subscriptionManage)).Methods("POST")
 
-	authRouter.HandleFunc("/api_keys", system.DefaultWrapper(apiServer.createAPIKey)).Methods("POST")
-	authRouter.HandleFunc("/api_keys", system.DefaultWrapper(apiServer.getAPIKeys)).Methods("GET")
-	authRouter.HandleFunc("/api_keys", system.DefaultWrapper(apiServer.deleteAPIKey)).Methods("DELETE")
+	authRouter.HandleFunc("/api-keys", system.Wrapper(apiServer.createAPIKey)).Methods("POST")
+	authRouter.HandleFunc("/api-keys", system.DefaultWrapper(apiServer.getAPIKeys)).Methods("GET")
+	authRouter.HandleFunc("/api-keys/{name}", system.Wrapper(apiServer.deleteAPIKey)).Methods("DELETE")
+	authRouter.HandleFunc("/api-keys/check", system.DefaultWrapper(apiServer.checkAPIKey)).Methods("GET")
+	// Deprecated: the old name of /api-keys/check, kept for existing clients
 	authRouter.HandleFunc("/api_keys/check", system.DefaultWrapper(apiServer.checkAPIKey)).Methods("GET")
 
+	authRouter.HandleFunc("/webhook", system.DefaultWrapper(apiServer.getWebhookConfig)).Methods("GET")
+	authRouter.HandleFunc("/webhook", system.Wrapper(apiServer.updateWebhookConfig)).Methods("PUT")
+
 	if apiServer.Options.LocalFilestorePath != "" {
 		// disable directory listings
 		fileServer := http.FileServer(neuteredFileSystem{http.Dir(apiServer.Options.LocalFilestorePath)})
@@ -254,45 +339,85 @@ func (apiServer *HelixAPIServer) registerRoutes(ctx context.Context) (*mux.Route
 			})))
 	}
 
+	// prometheus metrics are for operators, so they use the runner token
+	router.Handle("/metrics", apiServer.runnerAuth.middleware(http.HandlerFunc(apiServer.metrics))).Methods("GET")
+
 	// OpenAI API compatible routes
-	router.HandleFunc("/v1/chat/completions", apiServer.keyCloakMiddleware.apiKeyAuth(apiServer.createChatCompletion)).Methods("POST")
+	router.HandleFunc("/v1/chat/completions", apiServer.keyCloakMiddleware.apiKeyAuth(apiServer.rateLimit(apiServer.createChatCompletion))).Methods("POST")
 
-	authRouter.HandleFunc("/sessions", system.DefaultWrapper(apiServer.getSessions)).Methods("GET")
-	authRouter.HandleFunc("/sessions", system.DefaultWrapper(apiServer.createSession)).Methods("POST")
+	authRouter.HandleFunc("/sessions", system.Wrapper(apiServer.getSessions)).Methods("GET")
+	authRouter.HandleFunc("/sessions", apiServer.rateLimit(system.Wrapper(apiServer.createSession))).Methods("POST")
 
 	// api/v1beta/sessions is the new route for creating sessions
-	authRouter.HandleFunc("/sessions/chat", apiServer.startSessionHandler).Methods("POST")
+	authRouter.HandleFunc("/sessions/chat", apiServer.rateLimit(apiServer.startSessionHandler)).Methods("POST")
+	authRouter.HandleFunc("/sessions/delete", system.Wrapper(apiServer.deleteSessions)).Methods("POST")
 
 	maybeAuthRouter.HandleFunc("/sessions/{id}", system.Wrapper(apiServer.getSession)).Methods("GET")
 	maybeAuthRouter.HandleFunc("/sessions/{id}/summary", system.Wrapper(apiServer.getSessionSummary)).Methods("GET")
-	authRouter.HandleFunc("/sessions/{id}", system.Wrapper(apiServer.updateSession)).Methods("PUT")
+	maybeAuthRouter.HandleFunc("/sessions/{id}/export", system.Wrapper(apiServer.exportSession)).Methods("GET")
+	authRouter.HandleFunc("/sessions/{id}", apiServer.rateLimit(system.Wrapper(apiServer.updateSession))).Methods("PUT")
 	authRouter.HandleFunc("/sessions/{id}", system.Wrapper(apiServer.deleteSession)).Methods("DELETE")
 	authRouter.HandleFunc("/sessions/{id}/restart", system.Wrapper(apiServer.restartSession)).Methods("PUT")
+	authRouter.HandleFunc("/sessions/{id}/clone", system.Wrapper(apiServer.cloneSession)).Methods("POST")
+	authRouter.HandleFunc("/sessions/{id}/interactions/{iid}", apiServer.rateLimit(system.Wrapper(apiServer.editInteraction))).Methods("PUT")
+	authRouter.HandleFunc("/sessions/{id}/interactions/{iid}/feedback", system.Wrapper(apiServer.setInteractionFeedback)).Methods("POST")
+	authRouter.HandleFunc("/sessions/{id}/feedback", system.Wrapper(apiServer.getSessionFeedbackStats)).Methods("GET")
+	authRouter.HandleFunc("/feedback/stats", system.Wrapper(apiServer.getFeedbackStats)).Methods("GET")
+	authRouter.HandleFunc("/sessions/{id}/tools", system.Wrapper(apiServer.addSessionTool)).Methods("POST")
+	authRouter.HandleFunc("/sessions/{id}/tools/{toolId}", system.Wrapper(apiServer.deleteSessionTool)).Methods("DELETE")
+	authRouter.HandleFunc("/sessions/{id}/tags", system.Wrapper(apiServer.addSessionTags)).Methods("POST")
+	authRouter.HandleFunc("/sessions/{id}/tags/{tag}", system.Wrapper(apiServer.deleteSessionTag)).Methods("DELETE")
+	authRouter.HandleFunc("/sessions/{id}/share_links", system.Wrapper(apiServer.createSessionShareLink)).Methods("POST")
+	authRouter.HandleFunc("/sessions/{id}/share_links", system.Wrapper(apiServer.listSessionShareLinks)).Methods("GET")
+	authRouter.HandleFunc("/sessions/{id}/share_links/{linkId}", system.Wrapper(apiServer.revokeSessionShareLink)).Methods("DELETE")
+	authRouter.HandleFunc("/session_tags", system.Wrapper(apiServer.listSessionTags)).Methods("GET")
 	authRouter.HandleFunc("/sessions/{id}/config", system.Wrapper(apiServer.updateSessionConfig)).Methods("PUT")
 
 	authRouter.HandleFunc("/sessions/{id}/meta", system.Wrapper(apiServer.updateSessionMeta)).Methods("PUT")
-	authRouter.HandleFunc("/sessions/{id}/finetune/start", system.Wrapper(apiServer.startSessionFinetune)).Methods("POST")
+	authRouter.HandleFunc("/sessions/{id}/eval/user", system.Wrapper(apiServer.scoreSessionUser)).Methods("PUT")
+	authRouter.HandleFunc("/sessions/{id}/finetune/start", apiServer.rateLimit(system.Wrapper(apiServer.startSessionFinetune))).Methods("POST")
 	authRouter.HandleFunc("/sessions/{id}/finetune/documents", system.Wrapper(apiServer.finetuneAddDocuments)).Methods("PUT")
 	authRouter.HandleFunc("/sessions/{id}/finetune/clone/{interaction}/{mode}", system.Wrapper(apiServer.cloneFinetuneInteraction)).Methods("POST")
-	authRouter.HandleFunc("/sessions/{id}/finetune/text/retry", system.Wrapper(apiServer.retryTextFinetune)).Methods("PUT")
+	authRouter.HandleFunc("/finetune/text/estimate", system.Wrapper(apiServer.estimateDataPrepCost)).Methods("POST")
+	authRouter.HandleFunc("/sessions/{id}/finetune/text/retry", apiServer.rateLimit(system.Wrapper(apiServer.retryTextFinetune))).Methods("PUT")
+	authRouter.HandleFunc("/sessions/{id}/finetune/text/cancel", system.Wrapper(apiServer.cancelTextFinetune)).Methods("POST")
 	maybeAuthRouter.HandleFunc("/sessions/{id}/finetune/text/conversations/{interaction}", system.Wrapper(apiServer.getSessionFinetuneConversation)).Methods("GET")
 	authRouter.HandleFunc("/sessions/{id}/finetune/text/conversations/{interaction}", system.Wrapper(apiServer.setSessionFinetuneConversation)).Methods("PUT")
 
+	authRouter.HandleFunc("/models", system.Wrapper(apiServer.listModels)).Methods("GET")
+
 	authRouter.HandleFunc("/tools", system.Wrapper(apiServer.listTools)).Methods("GET")
 	authRouter.HandleFunc("/tools", system.Wrapper(apiServer.createTool)).Methods("POST")
+	authRouter.HandleFunc("/tools/{id}", system.Wrapper(apiServer.getTool)).Methods("GET")
 	authRouter.HandleFunc("/tools/{id}", system.Wrapper(apiServer.updateTool)).Methods("PUT")
 	authRouter.HandleFunc("/tools/{id}", system.Wrapper(apiServer.deleteTool)).Methods("DELETE")
 
 	adminRouter.HandleFunc("/dashboard", system.DefaultWrapper(apiServer.dashboard)).Methods("GET")
+	adminRouter.HandleFunc("/runners/{runnerid}/sessions", system.Wrapper(apiServer.listRunnerSessions)).Methods("GET")
+	adminRouter.HandleFunc("/runners/{runnerid}/jobs", system.Wrapper(apiServer.listRunnerJobs)).Methods("GET")
+	adminRouter.HandleFunc("/sessions/{id}/eval/manual", system.Wrapper(apiServer.scoreSessionManual)).Methods("PUT")
+	adminRouter.HandleFunc("/sessions/{id}/eval/automatic", system.Wrapper(apiServer.scoreSessionAutomatic)).Methods("POST")
+	adminRouter.HandleFunc("/eval_runs/{id}/stats", system.Wrapper(apiServer.getEvalRunStats)).Methods("GET")
+	adminRouter.HandleFunc("/eval_runs/batch", system.Wrapper(apiServer.runEvalBatch)).Methods("POST")
+	adminRouter.HandleFunc("/admin/cancel", system.Wrapper(apiServer.cancelOwnerSessions)).Methods("POST")
+	adminRouter.HandleFunc("/admin/feedback/stats", system.Wrapper(apiServer.getAllFeedbackStats)).Methods("GET")
 
 	// all these routes are secured via runner tokens
 	runnerRouter.HandleFunc("/runner/{runnerid}/nextsession", system.DefaultWrapper(apiServer.getNextRunnerSession)).Methods("GET")
 	runnerRouter.HandleFunc("/runner/{runnerid}/response", system.DefaultWrapper(apiServer.handleRunnerResponse)).Methods("POST")
 	runnerRouter.HandleFunc("/runner/{runnerid}/state", system.DefaultWrapper(apiServer.handleRunnerMetrics)).Methods("POST")
+	runnerRouter.HandleFunc("/runner/{runnerid}/session/{sessionid}/release", system.DefaultWrapper(apiServer.releaseRunnerSession)).Methods("POST")
 	runnerRouter.HandleFunc("/runner/{runnerid}/session/{sessionid}/download/file", apiServer.runnerSessionDownloadFile).Methods("GET")
 	runnerRouter.HandleFunc("/runner/{runnerid}/session/{sessionid}/download/folder", apiServer.runnerSessionDownloadFolder).Methods("GET")
 	runnerRouter.HandleFunc("/runner/{runnerid}/session/{sessionid}/upload/files", system.DefaultWrapper(apiServer.runnerSessionUploadFiles)).Methods("POST")
 	runnerRouter.HandleFunc("/runner/{runnerid}/session/{sessionid}/upload/folder", system.DefaultWrapper(apiServer.runnerSessionUploadFolder)).Methods("POST")
+	runnerRouter.HandleFunc("/runner/{runnerid}/session/{sessionid}/presign/download", system.Wrapper(apiServer.runnerSessionPresignDownload)).Methods("GET")
+	runnerRouter.HandleFunc("/runner/{runnerid}/session/{sessionid}/presign/upload", system.Wrapper(apiServer.runnerSessionPresignUpload)).Methods("POST")
+
+	// probes for load balancers and orchestrators, these sit outside of the
+	// api prefix and don't need any auth
+	router.HandleFunc("/healthz", apiServer.healthz).Methods("GET")
+	router.HandleFunc("/readyz", apiServer.readyz).Methods("GET")
 
 	// Authentication route
 	apiServer.registerKeycloakHandler(router)
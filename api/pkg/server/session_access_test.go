@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newSessionAccessTestServer(t *testing.T, session *types.Session) *HelixAPIServer {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	mockStore.EXPECT().GetSession(gomock.Any(), session.ID).Return(session, nil).AnyTimes()
+
+	return &HelixAPIServer{
+		Store:     mockStore,
+		adminAuth: newAdminAuth([]string{"admin_id"}),
+	}
+}
+
+func newSessionAccessRequest(userID string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/sessions/session_id", nil)
+	return req.WithContext(setRequestUser(req.Context(), types.UserData{ID: userID}))
+}
+
+func Test_sessionLoader_SharedSessionReadableByOthers(t *testing.T) {
+	session := &types.Session{
+		ID:        "session_id",
+		Owner:     "owner_id",
+		OwnerType: types.OwnerTypeUser,
+		Metadata:  types.SessionMetadata{Shared: true},
+	}
+	apiServer := newSessionAccessTestServer(t, session)
+
+	for _, userID := range []string{"owner_id", "other_id", ""} {
+		loaded, httpErr := apiServer.sessionLoaderWithID(newSessionAccessRequest(userID), session.ID, false)
+		require.Nil(t, httpErr, "user %q", userID)
+		assert.Equal(t, session, loaded)
+	}
+
+	// sharing never lets anyone else change the session
+	_, httpErr := apiServer.sessionLoaderWithID(newSessionAccessRequest("other_id"), session.ID, true)
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusForbidden, httpErr.StatusCode)
+
+	_, httpErr = apiServer.sessionLoaderWithID(newSessionAccessRequest("owner_id"), session.ID, true)
+	assert.Nil(t, httpErr)
+}
+
+func Test_sessionLoader_PrivateSession(t *testing.T) {
+	session := &types.Session{
+		ID:        "session_id",
+		Owner:     "owner_id",
+		OwnerType: types.OwnerTypeUser,
+	}
+	apiServer := newSessionAccessTestServer(t, session)
+
+	_, httpErr := apiServer.sessionLoaderWithID(newSessionAccessRequest("other_id"), session.ID, false)
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusForbidden, httpErr.StatusCode)
+
+	_, httpErr = apiServer.sessionLoaderWithID(newSessionAccessRequest("admin_id"), session.ID, true)
+	assert.Nil(t, httpErr)
+}
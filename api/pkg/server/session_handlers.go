@@ -2,11 +2,15 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/model"
 	"github.com/helixml/helix/api/pkg/system"
 	"github.com/helixml/helix/api/pkg/types"
 )
@@ -14,6 +18,7 @@ import (
 // startSessionHandler godoc
 // @Summary Start new text completion session
 // @Description Start new text completion session. Can be used to start or continue a session with the Helix API.
+// @Description With stream set the response is a text/event-stream of OpenAI style chunks ending with data: [DONE], disconnecting cancels the session.
 // @Tags    chat
 
 // @Success 200 {object} types.OpenAIResponse
@@ -29,8 +34,10 @@ func (s *HelixAPIServer) startSessionHandler(rw http.ResponseWriter, req *http.R
 		return
 	}
 
-	if len(startReq.Messages) == 0 {
-		http.Error(rw, "messages must not be empty", http.StatusBadRequest)
+	// a new session can be started with only a system prompt to see how the
+	// model opens the conversation
+	if len(startReq.Messages) == 0 && (startReq.SessionID != "" || startReq.SystemPrompt == "") {
+		http.Error(rw, "messages must not be empty unless a new session is started with a system prompt", http.StatusBadRequest)
 		return
 	}
 
@@ -40,6 +47,12 @@ func (s *HelixAPIServer) startSessionHandler(rw http.ResponseWriter, req *http.R
 		return
 	}
 
+	s.startChatSession(rw, req, &startReq)
+}
+
+// startChatSession starts (or continues) a session from a chat request and
+// writes the answer back, either streamed or in one go depending on the request
+func (s *HelixAPIServer) startChatSession(rw http.ResponseWriter, req *http.Request, startReq *types.SessionChatRequest) {
 	userContext := s.getRequestContext(req)
 
 	status, err := s.Controller.GetStatus(userContext)
@@ -48,8 +61,16 @@ func (s *HelixAPIServer) startSessionHandler(rw http.ResponseWriter, req *http.R
 		return
 	}
 
+	// the session is started once we're listening for its updates, by then
+	// it's too late to answer with a 429
+	httpError := s.checkSessionQuota(userContext)
+	if httpError != nil {
+		system.WriteHTTPError(rw, req, httpError)
+		return
+	}
+
 	if startReq.Model == "" {
-		startReq.Model = string(types.Model_Axolotl_Mistral7b)
+		startReq.Model = model.DefaultModelAlias
 	}
 
 	// Default to text
@@ -57,6 +78,56 @@ func (s *HelixAPIServer) startSessionHandler(rw http.ResponseWriter, req *http.R
 		startReq.Type = types.SessionTypeText
 	}
 
+	// chat sessions are always inference, a mode is only there to be checked
+	if startReq.Mode == "" {
+		startReq.Mode = types.SessionModeInference
+	}
+	if startReq.Mode != types.SessionModeInference {
+		http.Error(rw, fmt.Sprintf("invalid session mode %q, chat sessions only support %s", startReq.Mode, types.SessionModeInference), http.StatusBadRequest)
+		return
+	}
+
+	err = model.ValidateSessionRequest(startReq.Mode, startReq.Type, types.ModelName(startReq.Model))
+	if errors.Is(err, model.ErrUnknownModel) {
+		system.WriteHTTPError(rw, req, system.NewHTTPError400(err.Error()).WithCode(system.HTTPErrorCodeModelNotFound))
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// sessions always store the full model name, an alias might point
+	// somewhere else by the time the runner picks it up
+	modelName, err := model.ResolveModelName(startReq.Model)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	startReq.Model = string(modelName)
+
+	err = startReq.SamplingParams.Validate()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = startReq.SamplingParams.ValidateRuntime(modelName.InferenceRuntime())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = startReq.Env.Validate()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// ollama runs every session in the one server process
+	if len(startReq.Env) > 0 && modelName.InferenceRuntime() == types.InferenceRuntimeOllama {
+		http.Error(rw, fmt.Sprintf("env vars are not supported for model %s", modelName), http.StatusBadRequest)
+		return
+	}
+
 	var cfg *startSessionConfig
 
 	if startReq.SessionID == "" {
@@ -66,29 +137,72 @@ func (s *HelixAPIServer) startSessionHandler(rw http.ResponseWriter, req *http.R
 			return
 		}
 
+		tools, httpError := s.getSessionChatTools(req, userContext, startReq.Tools)
+		if httpError != nil {
+			system.WriteHTTPError(rw, req, httpError)
+			return
+		}
+
 		sessionID := system.GenerateSessionID()
+
+		now := time.Now()
+		systemPrompt, err := data.RenderSystemPrompt(startReq.SystemPrompt, types.SystemPromptValues{
+			User: types.SystemPromptUser{
+				ID:       userContext.Owner,
+				Email:    userContext.Email,
+				FullName: userContext.FullName,
+			},
+			Now:  now,
+			Date: now.Format("2006-01-02"),
+			Session: types.SystemPromptSession{
+				ID:        sessionID,
+				ModelName: types.ModelName(startReq.Model),
+				Mode:      types.SessionModeInference,
+				Type:      startReq.Type,
+			},
+		})
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		newSession := types.CreateSessionRequest{
 			SessionID:        sessionID,
 			SessionMode:      types.SessionModeInference,
 			SessionType:      startReq.Type,
-			SystemPrompt:     startReq.SystemPrompt,
+			SystemPrompt:     systemPrompt,
 			ModelName:        types.ModelName(startReq.Model),
 			Owner:            userContext.Owner,
 			OwnerType:        userContext.OwnerType,
 			UserInteractions: interactions,
 			Priority:         status.Config.StripeSubscriptionActive,
+			Sampling:         startReq.SamplingParams,
+			Env:              startReq.Env,
 		}
 
 		cfg = &startSessionConfig{
 			sessionID: sessionID,
 			modelName: startReq.Model,
 			start: func() error {
+				// bound before the session exists so the planner never
+				// sees it without them
+				for _, tool := range tools {
+					err := s.Store.CreateSessionToolBinding(req.Context(), sessionID, tool.ID)
+					if err != nil {
+						return fmt.Errorf("failed to add tool %s to session: %w", tool.ID, err)
+					}
+				}
 				_, err := s.Controller.CreateSession(userContext, newSession)
 				return err
 			},
 		}
 	} else {
 		// Existing session
+		if len(startReq.Tools) > 0 {
+			http.Error(rw, fmt.Sprintf("tools can only be set when starting a session, add them to session %s with POST /api/v1/sessions/%s/tools", startReq.SessionID, startReq.SessionID), http.StatusBadRequest)
+			return
+		}
+
 		interactions, err := messagesToInteractions(startReq.Messages)
 		if err != nil {
 			http.Error(rw, err.Error(), http.StatusBadRequest)
@@ -146,17 +260,9 @@ func messagesToInteractions(messages []*types.Message) ([]*types.Interaction, er
 
 		}
 
-		if len(m.Content.Parts) != 1 {
-			return nil, fmt.Errorf("invalid message content, should only contain 1 entry and it should be a string")
-
-		}
-
-		switch m.Content.Parts[0].(type) {
-		case string:
-			// OK
-		default:
-			return nil, fmt.Errorf("invalid message content %v", m.Content.Parts[0])
-
+		message, images, err := parseMessageContent(m.Content)
+		if err != nil {
+			return nil, err
 		}
 
 		var creator types.CreatorType
@@ -177,8 +283,9 @@ func messagesToInteractions(messages []*types.Message) ([]*types.Interaction, er
 			Completed:      time.Now(),
 			Creator:        creator,
 			Mode:           types.SessionModeInference,
-			Message:        m.Content.Parts[0].(string),
+			Message:        message,
 			Files:          []string{},
+			Images:         images,
 			State:          types.InteractionStateComplete,
 			Finished:       true,
 			Metadata:       map[string]string{},
@@ -190,3 +297,75 @@ func messagesToInteractions(messages []*types.Message) ([]*types.Interaction, er
 
 	return interactions, nil
 }
+
+// multimodal messages can mix strings with image asset pointer objects and
+// every other content type is a single string part - the strings are joined into
+// the message and the asset pointers are returned as the images
+func parseMessageContent(content types.MessageContent) (string, []string, error) {
+	switch content.ContentType {
+	case types.MessageContentTypeMultimodalText, types.MessageContentTypeImage:
+		var (
+			texts  []string
+			images []string
+		)
+
+		for _, part := range content.Parts {
+			switch p := part.(type) {
+			case string:
+				texts = append(texts, p)
+			case map[string]any:
+				image, err := parseImageAssetPointer(p)
+				if err != nil {
+					return "", nil, err
+				}
+				images = append(images, image.AssetPointer)
+			default:
+				return "", nil, fmt.Errorf("invalid message content %v", part)
+			}
+		}
+
+		if len(images) == 0 {
+			return "", nil, fmt.Errorf("invalid message content, %s content should contain at least 1 image", content.ContentType)
+		}
+
+		return strings.Join(texts, "\n"), images, nil
+	default:
+		// anything else is taken as text like it always was
+		return parseTextMessageContent(content)
+	}
+}
+
+func parseTextMessageContent(content types.MessageContent) (string, []string, error) {
+	if len(content.Parts) != 1 {
+		return "", nil, fmt.Errorf("invalid message content, should only contain 1 entry and it should be a string")
+	}
+
+	message, ok := content.Parts[0].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("invalid message content %v", content.Parts[0])
+	}
+
+	return message, nil, nil
+}
+
+func parseImageAssetPointer(part map[string]any) (*types.ImageAssetPointer, error) {
+	bts, err := json.Marshal(part)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message content %v: %w", part, err)
+	}
+
+	var image types.ImageAssetPointer
+	err = json.Unmarshal(bts, &image)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message content %v: %w", part, err)
+	}
+
+	if image.ContentType != types.MessageContentPartTypeImageAssetPointer {
+		return nil, fmt.Errorf("invalid message content part type '%s', expected '%s'", image.ContentType, types.MessageContentPartTypeImageAssetPointer)
+	}
+	if image.AssetPointer == "" {
+		return nil, fmt.Errorf("invalid message content, image is missing asset_pointer")
+	}
+
+	return &image, nil
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func Test_messagesToInteractions_Text(t *testing.T) {
+	interactions, err := messagesToInteractions([]*types.Message{
+		{
+			Role: types.CreatorTypeUser,
+			Content: types.MessageContent{
+				ContentType: types.MessageContentTypeText,
+				Parts:       []any{"hello"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, interactions, 1)
+
+	assert.Equal(t, "hello", interactions[0].Message)
+	assert.Empty(t, interactions[0].Images)
+}
+
+func Test_messagesToInteractions_Multimodal(t *testing.T) {
+	var message types.Message
+	err := json.Unmarshal([]byte(`{
+		"role": "user",
+		"content": {
+			"content_type": "multimodal_text",
+			"parts": [
+				{
+					"content_type": "image_asset_pointer",
+					"asset_pointer": "file-service://file-28uHss2LgJ8HUEEVAnXa70Tg",
+					"size_bytes": 185427,
+					"width": 2048,
+					"height": 1020,
+					"fovea": null,
+					"metadata": null
+				},
+				"what is in the image?"
+			]
+		}
+	}`), &message)
+	require.NoError(t, err)
+
+	interactions, err := messagesToInteractions([]*types.Message{&message})
+	require.NoError(t, err)
+	require.Len(t, interactions, 1)
+
+	assert.Equal(t, "what is in the image?", interactions[0].Message)
+	assert.Equal(t, []string{"file-service://file-28uHss2LgJ8HUEEVAnXa70Tg"}, interactions[0].Images)
+}
+
+func Test_messagesToInteractions_OtherContentType(t *testing.T) {
+	// content types we don't know about are still taken as text
+	interactions, err := messagesToInteractions([]*types.Message{
+		{Role: types.CreatorTypeUser, Content: types.MessageContent{ContentType: "code", Parts: []any{"print(1)"}}},
+	})
+	require.NoError(t, err)
+	require.Len(t, interactions, 1)
+	assert.Equal(t, "print(1)", interactions[0].Message)
+	assert.Empty(t, interactions[0].Images)
+}
+
+func Test_messagesToInteractions_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content types.MessageContent
+	}{
+		{
+			name:    "text with multiple parts",
+			content: types.MessageContent{ContentType: types.MessageContentTypeText, Parts: []any{"a", "b"}},
+		},
+		{
+			name:    "multimodal without an image",
+			content: types.MessageContent{ContentType: types.MessageContentTypeMultimodalText, Parts: []any{"a"}},
+		},
+		{
+			name: "image missing asset pointer",
+			content: types.MessageContent{ContentType: types.MessageContentTypeMultimodalText, Parts: []any{
+				map[string]any{"content_type": "image_asset_pointer"},
+			}},
+		},
+		{
+			name:    "other content type with an object",
+			content: types.MessageContent{ContentType: "code", Parts: []any{map[string]any{"a": "b"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := messagesToInteractions([]*types.Message{
+				{Role: types.CreatorTypeUser, Content: tt.content},
+			})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_listRunnerSessions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	apiServer := &HelixAPIServer{Store: mockStore}
+
+	now := time.Now()
+	session := func(id string, runners ...string) *types.Session {
+		s := &types.Session{ID: id, Mode: types.SessionModeInference}
+		for i, runner := range runners {
+			s.Interactions = append(s.Interactions,
+				&types.Interaction{ID: id + "-user", Creator: types.CreatorTypeUser, Message: "hello"},
+				&types.Interaction{
+					ID:        id + "-system-" + runner,
+					Creator:   types.CreatorTypeSystem,
+					Runner:    runner,
+					Scheduled: now.Add(time.Duration(i) * time.Hour),
+				},
+			)
+		}
+		return s
+	}
+
+	listRunnerSessions := func(url string) (*types.RunnerSessionsList, error) {
+		req := mux.SetURLVars(httptest.NewRequest("GET", url, nil), map[string]string{"runnerid": "runner-1"})
+		result, httpErr := apiServer.listRunnerSessions(httptest.NewRecorder(), req)
+		if httpErr != nil {
+			return nil, httpErr
+		}
+		return result, nil
+	}
+
+	mockStore.EXPECT().ListRunnerSessions(gomock.Any(), store.ListRunnerSessionsQuery{
+		RunnerID: "runner-1",
+		Limit:    100,
+	}).Return([]*types.Session{
+		session("a", "runner-1"),
+		session("b", "runner-2", "runner-1"),
+	}, nil)
+
+	result, err := listRunnerSessions("/api/v1/runners/runner-1/sessions")
+	require.NoError(t, err)
+	require.Len(t, result.Sessions, 2)
+	assert.Equal(t, "a", result.Sessions[0].SessionID)
+	assert.Equal(t, []string{"a-system-runner-1"}, result.Sessions[0].InteractionIDs)
+	assert.Equal(t, "b", result.Sessions[1].SessionID)
+	assert.Equal(t, []string{"b-system-runner-1"}, result.Sessions[1].InteractionIDs)
+
+	// the store narrows it down by session time, we do the rest
+	after := now.Add(30 * time.Minute).UTC().Truncate(time.Second)
+	mockStore.EXPECT().ListRunnerSessions(gomock.Any(), store.ListRunnerSessionsQuery{
+		RunnerID: "runner-1",
+		After:    after,
+		Limit:    10,
+	}).Return([]*types.Session{
+		session("a", "runner-1"),
+		session("b", "runner-2", "runner-1"),
+	}, nil)
+
+	result, err = listRunnerSessions("/api/v1/runners/runner-1/sessions?limit=10&after=" + after.Format(time.RFC3339))
+	require.NoError(t, err)
+	require.Len(t, result.Sessions, 1)
+	assert.Equal(t, "b", result.Sessions[0].SessionID)
+
+	_, err = listRunnerSessions("/api/v1/runners/runner-1/sessions?before=yesterday")
+	assert.Error(t, err)
+}
+
+func Test_listRunnerJobs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockStore := store.NewMockStore(ctrl)
+	apiServer := &HelixAPIServer{Store: mockStore}
+
+	listRunnerJobs := func(url string) (*types.ModelInstanceJobsList, error) {
+		req := mux.SetURLVars(httptest.NewRequest("GET", url, nil), map[string]string{"runnerid": "runner-1"})
+		result, httpErr := apiServer.listRunnerJobs(httptest.NewRecorder(), req)
+		if httpErr != nil {
+			return nil, httpErr
+		}
+		return result, nil
+	}
+
+	before := time.Now().UTC().Truncate(time.Second)
+	jobs := []*types.ModelInstanceJob{{RunnerID: "runner-1", ModelInstanceID: "instance-1", InteractionID: "a"}}
+	mockStore.EXPECT().ListModelInstanceJobs(gomock.Any(), store.ListModelInstanceJobsQuery{
+		RunnerID:        "runner-1",
+		ModelInstanceID: "instance-1",
+		Before:          before,
+		Limit:           100,
+	}).Return(jobs, nil)
+
+	result, err := listRunnerJobs("/api/v1/runners/runner-1/jobs?model_instance_id=instance-1&before=" + before.Format(time.RFC3339))
+	require.NoError(t, err)
+	assert.Equal(t, jobs, result.Jobs)
+
+	_, err = listRunnerJobs("/api/v1/runners/runner-1/jobs?limit=0")
+	assert.Error(t, err)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/helixml/helix/api/pkg/controller"
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// getSessionQuota godoc
+// @Summary Session quota usage
+// @Description How many sessions you have running or queued and how many you can have at once, a limit of 0 means there is no limit. Sessions are refused with a 429 once the limit is reached.
+// @Tags    sessions
+
+// @Success 200 {object} types.SessionQuotaUsage
+// @Router /api/v1/quotas/sessions [get]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) getSessionQuota(res http.ResponseWriter, req *http.Request) (*types.SessionQuotaUsage, *system.HTTPError) {
+	return system.DefaultController(apiServer.Controller.GetSessionQuotaUsage(apiServer.getRequestContext(req)))
+}
+
+// a 429 with the owner's usage if err is because they are at their session
+// quota, nil otherwise
+func sessionQuotaHTTPError(err error) *system.HTTPError {
+	var quotaErr *controller.SessionQuotaError
+	if !errors.As(err, &quotaErr) {
+		return nil
+	}
+	return (&system.HTTPError{
+		StatusCode: http.StatusTooManyRequests,
+		Message:    quotaErr.Error(),
+	}).WithCode(system.HTTPErrorCodeSessionQuotaExceeded).WithDetails(quotaErr.Usage)
+}
+
+// refuse to start a session up front if the owner is already at their
+// quota - creating the session checks again so this is only so handlers
+// that start sessions asynchronously can answer with a 429
+func (apiServer *HelixAPIServer) checkSessionQuota(userContext types.RequestContext) *system.HTTPError {
+	usage, err := apiServer.Controller.GetSessionQuotaUsage(userContext)
+	if err != nil {
+		return system.NewHTTPError500(err.Error())
+	}
+	if usage.Limit > 0 && usage.Active >= usage.Limit {
+		return sessionQuotaHTTPError(&controller.SessionQuotaError{Usage: *usage})
+	}
+	return nil
+}
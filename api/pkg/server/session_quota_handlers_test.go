@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/controller"
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func Test_sessionQuotaHTTPError(t *testing.T) {
+	assert.Nil(t, sessionQuotaHTTPError(nil))
+	assert.Nil(t, sessionQuotaHTTPError(fmt.Errorf("database is down")))
+
+	usage := types.SessionQuotaUsage{OwnerType: types.OwnerTypeUser, Owner: "user-1", Active: 2, Limit: 2}
+	httpErr := sessionQuotaHTTPError(fmt.Errorf("failed to start session: %w", &controller.SessionQuotaError{Usage: usage}))
+	require.NotNil(t, httpErr)
+
+	rec := httptest.NewRecorder()
+	system.WriteHTTPError(rec, httptest.NewRequest("POST", "/api/v1/sessions", nil), httpErr)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	var resp struct {
+		Error struct {
+			Code    system.HTTPErrorCode    `json:"code"`
+			Message string                  `json:"message"`
+			Details types.SessionQuotaUsage `json:"details"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, system.HTTPErrorCodeSessionQuotaExceeded, resp.Error.Code)
+	assert.Contains(t, resp.Error.Message, "2 of 2 sessions")
+	assert.Equal(t, usage, resp.Error.Details)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/helixml/helix/api/pkg/controller"
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// createSessionShareLink godoc
+// @Summary Share a session with a link
+// @Description Make a link that lets anyone read the session without an account until it expires (a day unless expires_in says otherwise, at most 30 days). The token goes in /api/v1/share/{token}.
+// @Tags    sessions
+
+// @Success 200 {object} types.SessionShareLink
+// @Failure 404 {object} system.HTTPError "Share links are not enabled on this server"
+// @Param request    body types.SessionShareLinkRequest false "How long the link works for"
+// @Param id path string true "Session ID"
+// @Router /api/v1/sessions/{id}/share_links [post]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) createSessionShareLink(res http.ResponseWriter, req *http.Request) (*types.SessionShareLink, *system.HTTPError) {
+	session, httpError := apiServer.sessionLoader(req, true)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	var linkReq types.SessionShareLinkRequest
+	if req.ContentLength != 0 {
+		err := json.NewDecoder(req.Body).Decode(&linkReq)
+		if err != nil {
+			return nil, system.NewHTTPError400("failed to decode request body, error: %s", err)
+		}
+	}
+	expiresIn := time.Duration(linkReq.ExpiresIn) * time.Second
+	if expiresIn < 0 || expiresIn > controller.MaxShareLinkExpiry {
+		return nil, system.NewHTTPError400("expires_in must be between 0 and %d seconds", int(controller.MaxShareLinkExpiry.Seconds()))
+	}
+
+	link, err := apiServer.Controller.CreateSessionShareLink(req.Context(), session, expiresIn)
+	if errors.Is(err, controller.ErrShareLinksDisabled) {
+		return nil, system.NewHTTPError404(err.Error())
+	}
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+	return link, nil
+}
+
+// listSessionShareLinks godoc
+// @Summary List the share links of a session
+// @Description The links made for a session, newest first. Expired links are listed until they are revoked.
+// @Tags    sessions
+
+// @Success 200 {array} types.SessionShareLink
+// @Param id path string true "Session ID"
+// @Router /api/v1/sessions/{id}/share_links [get]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) listSessionShareLinks(res http.ResponseWriter, req *http.Request) ([]*types.SessionShareLink, *system.HTTPError) {
+	session, httpError := apiServer.sessionLoader(req, true)
+	if httpError != nil {
+		return nil, httpError
+	}
+	return system.DefaultController(apiServer.Controller.ListSessionShareLinks(req.Context(), session))
+}
+
+// revokeSessionShareLink godoc
+// @Summary Revoke a share link
+// @Description The link's token stops working straight away.
+// @Tags    sessions
+
+// @Success 200 {object} types.SessionShareLink
+// @Param id path string true "Session ID"
+// @Param linkId path string true "Share link ID"
+// @Router /api/v1/sessions/{id}/share_links/{linkId} [delete]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) revokeSessionShareLink(res http.ResponseWriter, req *http.Request) (*types.SessionShareLink, *system.HTTPError) {
+	session, httpError := apiServer.sessionLoader(req, true)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	link, err := apiServer.Controller.RevokeSessionShareLink(req.Context(), session, mux.Vars(req)["linkId"])
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, system.NewHTTPError404("share link not found")
+	}
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+	return link, nil
+}
+
+// getSharedSession godoc
+// @Summary Read a shared session
+// @Description Export the conversation of the session a share link is for as OpenAI messages (the default) or as a ShareGPT conversation with ?format=sharegpt. No account is needed.
+// @Tags    sessions
+
+// @Success 200 {object} types.OpenAISessionExport
+// @Failure 403 {object} system.HTTPError "The link is invalid, expired or revoked"
+// @Param token path string true "Share link token"
+// @Router /api/v1/share/{token} [get]
+func (apiServer *HelixAPIServer) getSharedSession(res http.ResponseWriter, req *http.Request) (any, *system.HTTPError) {
+	format, err := types.ValidateSessionExportFormat(req.URL.Query().Get("format"), true)
+	if err != nil {
+		return nil, system.NewHTTPError400(err.Error())
+	}
+
+	session, err := apiServer.Controller.GetSharedSession(req.Context(), mux.Vars(req)["token"])
+	if errors.Is(err, controller.ErrInvalidShareLink) {
+		return nil, system.NewHTTPError403(err.Error())
+	}
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	return sessionExport(session, format), nil
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func Test_createSessionShareLink_TooLong(t *testing.T) {
+	apiServer, _ := newEvalTestServer(t, &types.Session{ID: "session_id", Owner: "owner_id", OwnerType: types.OwnerTypeUser})
+
+	req := httptest.NewRequest("POST", "/api/v1/sessions/session_id/share_links", strings.NewReader(`{"expires_in": 31536000}`))
+	req = mux.SetURLVars(req, map[string]string{"id": "session_id"})
+	req = req.WithContext(setRequestUser(req.Context(), types.UserData{ID: "owner_id"}))
+
+	_, httpErr := apiServer.createSessionShareLink(httptest.NewRecorder(), req)
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusBadRequest, httpErr.StatusCode)
+}
+
+func Test_getSharedSession_InvalidToken(t *testing.T) {
+	apiServer, _ := newEvalTestServer(t, &types.Session{ID: "session_id"})
+
+	// no user, anyone can try a link
+	req := httptest.NewRequest("GET", "/api/v1/share/abc.123.def", nil)
+	req = mux.SetURLVars(req, map[string]string{"token": "abc.123.def"})
+
+	_, httpErr := apiServer.getSharedSession(httptest.NewRecorder(), req)
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusForbidden, httpErr.StatusCode)
+}
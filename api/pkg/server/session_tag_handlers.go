@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/helixml/helix/api/pkg/data"
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// addSessionTags godoc
+// @Summary Tag a session
+// @Description Add tags to a session. Tags are trimmed and lowercased, tags the session already has are ignored.
+// @Tags    sessions
+
+// @Success 200 {object} types.Session
+// @Param request    body types.SessionTagsRequest true "The tags to add"
+// @Param id path string true "Session ID"
+// @Router /api/v1/sessions/{id}/tags [post]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) addSessionTags(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
+	session, httpError := apiServer.sessionLoader(req, true)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	var tagsReq types.SessionTagsRequest
+	err := json.NewDecoder(req.Body).Decode(&tagsReq)
+	if err != nil {
+		return nil, system.NewHTTPError400("failed to decode request body, error: %s", err)
+	}
+
+	tags, err := data.AddSessionTags(session, tagsReq.Tags)
+	if err != nil {
+		return nil, system.NewHTTPError400(err.Error())
+	}
+
+	return apiServer.updateSessionTags(req, session, tags)
+}
+
+// deleteSessionTag godoc
+// @Summary Untag a session
+// @Description Remove a tag from a session.
+// @Tags    sessions
+
+// @Success 200 {object} types.Session
+// @Param id path string true "Session ID"
+// @Param tag path string true "Tag"
+// @Router /api/v1/sessions/{id}/tags/{tag} [delete]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) deleteSessionTag(res http.ResponseWriter, req *http.Request) (*types.Session, *system.HTTPError) {
+	session, httpError := apiServer.sessionLoader(req, true)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	return apiServer.updateSessionTags(req, session, data.RemoveSessionTag(session, mux.Vars(req)["tag"]))
+}
+
+func (apiServer *HelixAPIServer) updateSessionTags(req *http.Request, session *types.Session, tags []string) (*types.Session, *system.HTTPError) {
+	updated, err := apiServer.Store.UpdateSessionTags(req.Context(), session.ID, tags)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+	return updated, nil
+}
+
+// listSessionTags godoc
+// @Summary List session tags
+// @Description Every tag used on the user's sessions.
+// @Tags    sessions
+
+// @Success 200 {array} string
+// @Router /api/v1/session_tags [get]
+// @Security BearerAuth
+func (apiServer *HelixAPIServer) listSessionTags(res http.ResponseWriter, req *http.Request) ([]string, *system.HTTPError) {
+	reqContext := apiServer.getRequestContext(req)
+
+	tags, err := apiServer.Store.ListSessionTags(req.Context(), store.OwnerQuery{
+		Owner:     reqContext.Owner,
+		OwnerType: reqContext.OwnerType,
+	})
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+	return tags, nil
+}
+
+// the tags to filter the session list by, ?tags=a,b (or ?tags=a&tags=b)
+// matches sessions with any of them and adding &tags_match=all matches
+// sessions with every one of them
+func getSessionsTagsFilter(req *http.Request) ([]string, bool, error) {
+	var tags []string
+	for _, value := range req.URL.Query()["tags"] {
+		tags = append(tags, strings.Split(value, ",")...)
+	}
+
+	normalized, err := data.NormalizeSessionTags(tags)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch match := req.URL.Query().Get("tags_match"); match {
+	case "", "any":
+		return normalized, false, nil
+	case "all":
+		return normalized, true, nil
+	default:
+		return nil, false, fmt.Errorf("tags_match must be 'any' or 'all', not '%s'", match)
+	}
+}
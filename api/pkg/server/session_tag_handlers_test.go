@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func newSessionTagsRequest(method, userID string, vars map[string]string, body string) *http.Request {
+	req := httptest.NewRequest(method, "/api/v1/sessions/session_id/tags", strings.NewReader(body))
+	req = mux.SetURLVars(req, vars)
+	return req.WithContext(setRequestUser(req.Context(), types.UserData{ID: userID}))
+}
+
+func returnTaggedSession(session *types.Session) func(context.Context, string, []string) (*types.Session, error) {
+	return func(ctx context.Context, id string, tags []string) (*types.Session, error) {
+		updated := *session
+		updated.Tags = tags
+		return &updated, nil
+	}
+}
+
+func Test_addSessionTags(t *testing.T) {
+	session := &types.Session{ID: "session_id", Owner: "owner_id", OwnerType: types.OwnerTypeUser, Tags: types.SessionTags{"research"}}
+	apiServer, mockStore := newEvalTestServer(t, session)
+
+	mockStore.EXPECT().UpdateSessionTags(gomock.Any(), "session_id", []string{"research", "project x"}).
+		DoAndReturn(returnTaggedSession(session))
+
+	updated, httpErr := apiServer.addSessionTags(httptest.NewRecorder(),
+		newSessionTagsRequest("POST", "owner_id", map[string]string{"id": "session_id"}, `{"tags": [" Project X", "RESEARCH"]}`))
+	require.Nil(t, httpErr)
+	assert.Equal(t, types.SessionTags{"research", "project x"}, updated.Tags)
+
+	_, httpErr = apiServer.addSessionTags(httptest.NewRecorder(),
+		newSessionTagsRequest("POST", "owner_id", map[string]string{"id": "session_id"}, `{"tags": ["a,b"]}`))
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusBadRequest, httpErr.StatusCode)
+
+	// tags are the owner's
+	_, httpErr = apiServer.addSessionTags(httptest.NewRecorder(),
+		newSessionTagsRequest("POST", "other_id", map[string]string{"id": "session_id"}, `{"tags": ["mine"]}`))
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusForbidden, httpErr.StatusCode)
+}
+
+func Test_deleteSessionTag(t *testing.T) {
+	session := &types.Session{ID: "session_id", Owner: "owner_id", OwnerType: types.OwnerTypeUser, Tags: types.SessionTags{"research", "project x"}}
+	apiServer, mockStore := newEvalTestServer(t, session)
+
+	mockStore.EXPECT().UpdateSessionTags(gomock.Any(), "session_id", []string{"research"}).
+		DoAndReturn(returnTaggedSession(session))
+
+	updated, httpErr := apiServer.deleteSessionTag(httptest.NewRecorder(),
+		newSessionTagsRequest("DELETE", "owner_id", map[string]string{"id": "session_id", "tag": "Project X"}, ""))
+	require.Nil(t, httpErr)
+	assert.Equal(t, types.SessionTags{"research"}, updated.Tags)
+}
+
+func Test_listSessionTags(t *testing.T) {
+	apiServer, mockStore := newEvalTestServer(t, &types.Session{ID: "session_id"})
+
+	mockStore.EXPECT().ListSessionTags(gomock.Any(), store.OwnerQuery{Owner: "owner_id", OwnerType: types.OwnerTypeUser}).
+		Return([]string{"project x", "research"}, nil)
+
+	tags, httpErr := apiServer.listSessionTags(httptest.NewRecorder(), newSessionTagsRequest("GET", "owner_id", nil, ""))
+	require.Nil(t, httpErr)
+	assert.Equal(t, []string{"project x", "research"}, tags)
+}
+
+func Test_getSessions_TagsFilter(t *testing.T) {
+	apiServer, mockStore := newEvalTestServer(t, &types.Session{ID: "session_id"})
+
+	query := store.GetSessionsQuery{
+		Owner:        "owner_id",
+		OwnerType:    types.OwnerTypeUser,
+		Tags:         []string{"project x", "research"},
+		TagsMatchAll: true,
+	}
+	mockStore.EXPECT().GetSessions(gomock.Any(), query).Return([]*types.Session{}, nil)
+	mockStore.EXPECT().GetSessionsCounter(gomock.Any(), query).Return(&types.Counter{}, nil)
+
+	req := httptest.NewRequest("GET", "/api/v1/sessions?tags=Project%20X,research&tags=RESEARCH&tags_match=all", nil)
+	req = req.WithContext(setRequestUser(req.Context(), types.UserData{ID: "owner_id"}))
+
+	_, httpErr := apiServer.getSessions(httptest.NewRecorder(), req)
+	require.Nil(t, httpErr)
+
+	req = httptest.NewRequest("GET", "/api/v1/sessions?tags=research&tags_match=some", nil)
+	req = req.WithContext(setRequestUser(req.Context(), types.UserData{ID: "owner_id"}))
+
+	_, httpErr = apiServer.getSessions(httptest.NewRecorder(), req)
+	require.NotNil(t, httpErr)
+	assert.Equal(t, http.StatusBadRequest, httpErr.StatusCode)
+}
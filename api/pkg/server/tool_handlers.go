@@ -2,15 +2,22 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/helixml/helix/api/pkg/store"
 	"github.com/helixml/helix/api/pkg/system"
 	"github.com/helixml/helix/api/pkg/tools"
 	"github.com/helixml/helix/api/pkg/types"
+	"github.com/rs/zerolog/log"
 )
 
 // listTools godoc
@@ -32,29 +39,141 @@ func (s *HelixAPIServer) listTools(rw http.ResponseWriter, r *http.Request) ([]*
 		return nil, system.NewHTTPError500(err.Error())
 	}
 
+	err = s.addToolUsage(r.Context(), tools...)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
 	return tools, nil
 }
 
+// getTool godoc
+// @Summary Get a tool
+// @Description Get one of your tools along with how many times the LLMs have run each of its actions.
+// @Tags    tools
+
+// @Success 200 {object} types.Tool
+// @Param id path string true "Tool ID"
+// @Router /api/v1/tools/{id} [get]
+// @Security BearerAuth
+func (s *HelixAPIServer) getTool(rw http.ResponseWriter, r *http.Request) (*types.Tool, *system.HTTPError) {
+	tool, httpError := s.getOwnedTool(r, getID(r))
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	err := s.addToolUsage(r.Context(), tool)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	return tool, nil
+}
+
+// fill in how often each tool's actions have been run, the total count and
+// last used time of a tool cover all of its actions
+func (s *HelixAPIServer) addToolUsage(ctx context.Context, tools ...*types.Tool) error {
+	toolIDs := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		toolIDs = append(toolIDs, tool.ID)
+	}
+
+	usage, err := s.Store.ListToolUsage(ctx, toolIDs)
+	if err != nil {
+		return err
+	}
+
+	byTool := map[string][]*types.ToolActionUsage{}
+	for _, actionUsage := range usage {
+		byTool[actionUsage.ToolID] = append(byTool[actionUsage.ToolID], actionUsage)
+	}
+
+	for _, tool := range tools {
+		tool.ActionUsage = byTool[tool.ID]
+		tool.UsageCount = 0
+		tool.LastUsed = nil
+		for _, actionUsage := range tool.ActionUsage {
+			tool.UsageCount += actionUsage.Count
+			if tool.LastUsed == nil || actionUsage.LastUsed.After(*tool.LastUsed) {
+				lastUsed := actionUsage.LastUsed
+				tool.LastUsed = &lastUsed
+			}
+		}
+	}
+
+	return nil
+}
+
 // createTool godoc
 // @Summary Create new tool
 // @Description Create new tool. Tools are used by the LLMs to interact with external systems.
 // @Tags    tools
 
 // @Success 200 {object} types.Tool
+// @Failure 409 {object} system.HTTPError "The Idempotency-Key was already used with a different request body"
 // @Param request    body types.Tool true "Request body with tool configuration. For API schemas, it can be base64 encoded.")
+// @Param Idempotency-Key header string false "Retrying with the same key returns the tool created the first time rather than a new one"
 // @Router /api/v1/tools [post]
 // @Security BearerAuth
 func (s *HelixAPIServer) createTool(rw http.ResponseWriter, r *http.Request) (*types.Tool, *system.HTTPError) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 10*MEGABYTE))
+	if err != nil {
+		return nil, system.NewHTTPError400("failed to read request body, error: %s", err)
+	}
+
 	var tool types.Tool
-	err := json.NewDecoder(r.Body).Decode(&tool)
+	err = json.Unmarshal(body, &tool)
 	if err != nil {
 		return nil, system.NewHTTPError400("failed to decode request body, error: %s", err)
 	}
 
 	userContext := s.getRequestContext(r)
 
+	idempotencyKey := r.Header.Get(idempotencyKeyHeader)
+	if idempotencyKey == "" {
+		return s.createOwnedTool(r.Context(), userContext, &tool)
+	}
+
+	key := &types.IdempotencyKey{
+		Key:         idempotencyKey,
+		Owner:       userContext.Owner,
+		OwnerType:   userContext.OwnerType,
+		Scope:       types.IdempotencyKeyScopeCreateTool,
+		RequestHash: hashRequestBody(body),
+		Expires:     time.Now().Add(s.idempotencyKeyTTL()),
+	}
+	existing, httpErr := s.reserveIdempotencyKey(r.Context(), key)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
+	created, httpErr := s.createOwnedTool(r.Context(), userContext, &tool)
+	if httpErr != nil {
+		// nothing was created so let a retry with the key have another go
+		err = s.Store.DeleteIdempotencyKey(r.Context(), key)
+		if err != nil {
+			log.Ctx(r.Context()).Error().Err(err).Msg("failed to release idempotency key")
+		}
+		return nil, httpErr
+	}
+
+	// the tool is there now so don't fail the request, a retry will
+	// just get told that the name is taken
+	key.ResourceID = created.ID
+	_, err = s.Store.UpdateIdempotencyKey(r.Context(), key)
+	if err != nil {
+		log.Ctx(r.Context()).Error().Err(err).Str("tool_id", created.ID).Msg("failed to save idempotency key")
+	}
+
+	return created, nil
+}
+
+func (s *HelixAPIServer) createOwnedTool(ctx context.Context, userContext types.RequestContext, tool *types.Tool) (*types.Tool, *system.HTTPError) {
 	// Getting existing tools for the user
-	existingTools, err := s.Store.ListTools(r.Context(), &store.ListToolsQuery{
+	existingTools, err := s.Store.ListTools(ctx, &store.ListToolsQuery{
 		Owner:     userContext.Owner,
 		OwnerType: userContext.OwnerType,
 	})
@@ -65,7 +184,7 @@ func (s *HelixAPIServer) createTool(rw http.ResponseWriter, r *http.Request) (*t
 	tool.Owner = userContext.Owner
 	tool.OwnerType = userContext.OwnerType
 
-	err = s.validateTool(&tool)
+	err = s.validateTool(tool)
 	if err != nil {
 		return nil, system.NewHTTPError400(err.Error())
 	}
@@ -73,12 +192,12 @@ func (s *HelixAPIServer) createTool(rw http.ResponseWriter, r *http.Request) (*t
 	// Checking if the tool already exists
 	for _, t := range existingTools {
 		if t.Name == tool.Name {
-			return nil, system.NewHTTPError400("tool (%s) with name %s already exists", t.ID, tool.Name)
+			return nil, system.NewHTTPError400("tool (%s) with name %s already exists", t.ID, tool.Name).WithCode(system.HTTPErrorCodeToolNameConflict)
 		}
 	}
 
 	// Creating the tool
-	created, err := s.Store.CreateTool(r.Context(), &tool)
+	created, err := s.Store.CreateTool(ctx, tool)
 	if err != nil {
 		return nil, system.NewHTTPError500(err.Error())
 	}
@@ -86,19 +205,109 @@ func (s *HelixAPIServer) createTool(rw http.ResponseWriter, r *http.Request) (*t
 	return created, nil
 }
 
+const idempotencyKeyHeader = "Idempotency-Key"
+
+const defaultIdempotencyKeyTTL = 24 * time.Hour
+
+// how often the expired idempotency keys are cleared out
+const idempotencyKeyGCInterval = time.Hour
+
+func hashRequestBody(body []byte) string {
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:])
+}
+
+func (s *HelixAPIServer) idempotencyKeyTTL() time.Duration {
+	if s.Options.IdempotencyKeyTTL <= 0 {
+		return defaultIdempotencyKeyTTL
+	}
+	return s.Options.IdempotencyKeyTTL
+}
+
+// claims the key before the tool is created so two requests racing with the
+// same key can't both create one - if an earlier request with the key
+// already created the tool we return that instead
+func (s *HelixAPIServer) reserveIdempotencyKey(ctx context.Context, key *types.IdempotencyKey) (*types.Tool, *system.HTTPError) {
+	_, err := s.Store.CreateIdempotencyKey(ctx, key)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, store.ErrAlreadyExists) {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	existingKey, err := s.Store.GetIdempotencyKey(ctx, &store.GetIdempotencyKeyQuery{
+		Key:       key.Key,
+		Owner:     key.Owner,
+		OwnerType: key.OwnerType,
+		Scope:     key.Scope,
+	})
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			// it expired or was released since we tried to reserve it
+			return nil, system.NewHTTPError409(fmt.Sprintf("idempotency key %s is being used by another request, retry the request", key.Key)).WithCode(system.HTTPErrorCodeIdempotencyConflict)
+		}
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	if existingKey.RequestHash != key.RequestHash {
+		return nil, system.NewHTTPError409(fmt.Sprintf("idempotency key %s was already used with a different request", key.Key)).WithCode(system.HTTPErrorCodeIdempotencyConflict)
+	}
+
+	if existingKey.ResourceID == "" {
+		return nil, system.NewHTTPError409(fmt.Sprintf("idempotency key %s is being used by another request, retry the request", key.Key)).WithCode(system.HTTPErrorCodeIdempotencyConflict)
+	}
+
+	tool, err := s.Store.GetTool(ctx, existingKey.ResourceID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	if err == nil && tool.Owner == key.Owner && tool.OwnerType == key.OwnerType {
+		return tool, nil
+	}
+
+	// the tool has since been deleted so the key is ours to create a new one
+	existingKey.ResourceID = ""
+	_, err = s.Store.UpdateIdempotencyKey(ctx, existingKey)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+	return nil, nil
+}
+
+// keeps the idempotency keys table from growing forever
+func (s *HelixAPIServer) startIdempotencyKeyGC(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyKeyGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.Store.DeleteExpiredIdempotencyKeys(ctx, time.Now())
+			if err != nil {
+				log.Ctx(ctx).Warn().Err(err).Msg("failed to delete expired idempotency keys")
+			}
+		}
+	}
+}
+
 // updateTool godoc
 // @Summary Update an existing tool
-// @Description Update existing tool
+// @Description Update existing tool. The response has the actions the new schema added, removed and renamed.
+// @Description Removing or renaming actions of a tool that sessions are bound to is refused with a 409 unless force is set.
 // @Tags    tools
 
 // @Success 200 {object} types.Tool
 // @Param request    body types.Tool true "Request body with tool configuration. For API schemas, it can be base64 encoded.")
 // @Param id path string true "Tool ID"
+// @Param dry_run query bool false "Work out the changes to the actions without saving the tool"
+// @Param force query bool false "Save even if actions are taken away from sessions the tool is bound to"
 // @Router /api/v1/tools/{id} [put]
 // @Security BearerAuth
 func (s *HelixAPIServer) updateTool(rw http.ResponseWriter, r *http.Request) (*types.Tool, *system.HTTPError) {
-	userContext := s.getRequestContext(r)
-
 	var tool types.Tool
 	err := json.NewDecoder(r.Body).Decode(&tool)
 	if err != nil {
@@ -115,27 +324,72 @@ func (s *HelixAPIServer) updateTool(rw http.ResponseWriter, r *http.Request) (*t
 	}
 
 	// Getting existing tool
-	existing, err := s.Store.GetTool(r.Context(), id)
+	existing, httpError := s.getOwnedTool(r, id)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	// Getting existing tools for the user
+	existingTools, err := s.Store.ListTools(r.Context(), &store.ListToolsQuery{
+		Owner:     existing.Owner,
+		OwnerType: existing.OwnerType,
+	})
 	if err != nil {
-		if errors.Is(err, store.ErrNotFound) {
-			return nil, system.NewHTTPError404(store.ErrNotFound.Error())
-		}
 		return nil, system.NewHTTPError500(err.Error())
 	}
 
-	if existing.Owner != userContext.Owner {
-		return nil, system.NewHTTPError404(store.ErrNotFound.Error())
+	// Checking if another tool already has this name
+	for _, t := range existingTools {
+		if t.ID == tool.ID {
+			continue
+		}
+		if t.Name == tool.Name {
+			return nil, system.NewHTTPError400("tool (%s) with name %s already exists, cannot rename tool (%s)", t.ID, tool.Name, tool.ID).WithCode(system.HTTPErrorCodeToolNameConflict)
+		}
 	}
 
 	tool.Owner = existing.Owner
 	tool.OwnerType = existing.OwnerType
 
+	var previousActions, updatedActions []*types.ToolApiAction
+	if existing.Config.API != nil {
+		previousActions = existing.Config.API.Actions
+	}
+	if tool.Config.API != nil {
+		updatedActions = tool.Config.API.Actions
+	}
+	diff := tools.DiffActions(previousActions, updatedActions)
+
+	// unfinished sessions the tool is bound to might be relying on an
+	// action that has gone, they have to be forced through
+	if diff.Breaking() && r.URL.Query().Get("force") != "true" {
+		sessions, err := s.Store.CountToolSessions(r.Context(), id)
+		if err != nil {
+			return nil, system.NewHTTPError500(err.Error())
+		}
+		if sessions > 0 {
+			return nil, system.NewHTTPError409(fmt.Sprintf("tool (%s) is bound to %d unfinished sessions and the update removes or renames actions they may use, set force=true to update it anyway", id, sessions)).
+				WithCode(system.HTTPErrorCodeToolActionsInUse).
+				WithDetails(types.ToolActionsInUseDetails{
+					ActionsDiff: diff,
+					Sessions:    sessions,
+				})
+		}
+	}
+
+	if r.URL.Query().Get("dry_run") == "true" {
+		tool.ActionsDiff = diff
+		return &tool, nil
+	}
+
 	// Updating the tool
 	updated, err := s.Store.UpdateTool(r.Context(), &tool)
 	if err != nil {
 		return nil, system.NewHTTPError500(err.Error())
 	}
 
+	updated.ActionsDiff = diff
+
 	return updated, nil
 }
 
@@ -147,10 +401,6 @@ func (s *HelixAPIServer) validateTool(tool *types.Tool) error {
 			return system.NewHTTPError400("API config is required for API tools")
 		}
 
-		if tool.Config.API.URL == "" {
-			return system.NewHTTPError400("API URL is required for API tools")
-		}
-
 		if tool.Config.API.Schema == "" {
 			return system.NewHTTPError400("API schema is required for API tools")
 		}
@@ -170,6 +420,21 @@ func (s *HelixAPIServer) validateTool(tool *types.Tool) error {
 			return system.NewHTTPError400("no actions found in the schema, please check the documentation for required fields (operationId, summary or description)")
 		}
 
+		err = tools.ValidateActions(actions)
+		if err != nil {
+			return system.NewHTTPError400(err.Error())
+		}
+
+		// Actions are re-parsed from the schema each time, keep any
+		// per-action timeouts that were set on the request
+		for _, action := range actions {
+			for _, existing := range tool.Config.API.Actions {
+				if existing != nil && existing.Name == action.Name {
+					action.Timeout = existing.Timeout
+				}
+			}
+		}
+
 		tool.Config.API.Actions = actions
 
 		_, err = s.Controller.Options.Planner.ValidateAndDefault(context.Background(), tool)
@@ -194,11 +459,159 @@ func (s *HelixAPIServer) validateTool(tool *types.Tool) error {
 // @Router /api/v1/tools/{id} [delete]
 // @Security BearerAuth
 func (s *HelixAPIServer) deleteTool(rw http.ResponseWriter, r *http.Request) (*types.Tool, *system.HTTPError) {
-	userContext := s.getRequestContext(r)
-
 	id := getID(r)
 
-	existing, err := s.Store.GetTool(r.Context(), id)
+	existing, httpError := s.getOwnedTool(r, id)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	err := s.Store.DeleteTool(r.Context(), id)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	return existing, nil
+}
+
+// addSessionTool godoc
+// @Summary Add a tool to a session
+// @Description Bind a tool to the session. Once a session has tools bound to it only those tools are used. Adding a tool that is already bound does nothing.
+// @Tags    tools
+
+// @Success 200 {array} types.Tool
+// @Param id path string true "Session ID"
+// @Param request    body types.SessionToolRequest true "The tool to add."
+// @Router /api/v1/sessions/{id}/tools [post]
+// @Security BearerAuth
+func (s *HelixAPIServer) addSessionTool(rw http.ResponseWriter, r *http.Request) ([]*types.Tool, *system.HTTPError) {
+	session, httpError := s.sessionLoader(r, true)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	var toolReq types.SessionToolRequest
+	err := json.NewDecoder(r.Body).Decode(&toolReq)
+	if err != nil {
+		return nil, system.NewHTTPError400("failed to decode request body, error: %s", err)
+	}
+
+	if toolReq.ToolID == "" {
+		return nil, system.NewHTTPError400("tool_id is required")
+	}
+
+	_, httpError = s.getOwnedTool(r, toolReq.ToolID)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	err = s.Store.CreateSessionToolBinding(r.Context(), session.ID, toolReq.ToolID)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	return s.listSessionTools(r, session.ID)
+}
+
+// deleteSessionTool godoc
+// @Summary Remove a tool from a session
+// @Description Unbind a tool from the session.
+// @Tags    tools
+
+// @Success 200 {array} types.Tool
+// @Param id path string true "Session ID"
+// @Param toolId path string true "Tool ID"
+// @Router /api/v1/sessions/{id}/tools/{toolId} [delete]
+// @Security BearerAuth
+func (s *HelixAPIServer) deleteSessionTool(rw http.ResponseWriter, r *http.Request) ([]*types.Tool, *system.HTTPError) {
+	session, httpError := s.sessionLoader(r, true)
+	if httpError != nil {
+		return nil, httpError
+	}
+
+	toolID := mux.Vars(r)["toolId"]
+
+	err := s.Store.DeleteSessionToolBinding(r.Context(), session.ID, toolID)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+
+	return s.listSessionTools(r, session.ID)
+}
+
+func (s *HelixAPIServer) listSessionTools(r *http.Request, sessionID string) ([]*types.Tool, *system.HTTPError) {
+	tools, err := s.Store.ListSessionTools(r.Context(), sessionID)
+	if err != nil {
+		return nil, system.NewHTTPError500(err.Error())
+	}
+	return tools, nil
+}
+
+// the tools a chat request asks for by ID or name - asking for a tool that
+// doesn't exist is a bad request and asking for someone else's is forbidden
+// so nobody can run another tenant's tool with its credentials
+func (s *HelixAPIServer) getSessionChatTools(r *http.Request, userContext types.RequestContext, refs []string) ([]*types.Tool, *system.HTTPError) {
+	var (
+		tools  []*types.Tool
+		owned  []*types.Tool
+		listed bool
+		seen   = map[string]bool{}
+	)
+
+	for _, ref := range refs {
+		tool, err := s.Store.GetTool(r.Context(), ref)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return nil, system.NewHTTPError500(err.Error())
+		}
+
+		// names are only looked up among the caller's own tools
+		if tool == nil {
+			if !listed {
+				owned, err = s.Store.ListTools(r.Context(), &store.ListToolsQuery{
+					Owner:     userContext.Owner,
+					OwnerType: userContext.OwnerType,
+				})
+				if err != nil {
+					return nil, system.NewHTTPError500(err.Error())
+				}
+				listed = true
+			}
+			for _, t := range owned {
+				if t.Name == ref {
+					tool = t
+					break
+				}
+			}
+		}
+
+		if tool == nil {
+			return nil, system.NewHTTPError400("tool '%s' not found", ref).
+				WithCode(system.HTTPErrorCodeToolNotFound).
+				WithDetails(types.ToolErrorDetails{ToolID: ref})
+		}
+
+		if !isToolOwner(userContext, tool) {
+			return nil, system.NewHTTPError403(fmt.Sprintf("tool '%s' does not belong to you", tool.ID)).
+				WithCode(system.HTTPErrorCodeToolForbidden).
+				WithDetails(types.ToolErrorDetails{ToolID: tool.ID})
+		}
+
+		if seen[tool.ID] {
+			continue
+		}
+		seen[tool.ID] = true
+		tools = append(tools, tool)
+	}
+
+	return tools, nil
+}
+
+// tools that belong to someone else are reported as not found
+// so we don't leak their existence
+func (s *HelixAPIServer) getOwnedTool(r *http.Request, id string) (*types.Tool, *system.HTTPError) {
+	userContext := s.getRequestContext(r)
+
+	tool, err := s.Store.GetTool(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
 			return nil, system.NewHTTPError404(store.ErrNotFound.Error())
@@ -206,14 +619,15 @@ func (s *HelixAPIServer) deleteTool(rw http.ResponseWriter, r *http.Request) (*t
 		return nil, system.NewHTTPError500(err.Error())
 	}
 
-	if existing.Owner != userContext.Owner {
+	if !isToolOwner(userContext, tool) {
 		return nil, system.NewHTTPError404(store.ErrNotFound.Error())
 	}
 
-	err = s.Store.DeleteTool(r.Context(), id)
-	if err != nil {
-		return nil, system.NewHTTPError500(err.Error())
-	}
+	return tool, nil
+}
 
-	return existing, nil
+// the same owner ID can own tools as different owner types (a user and an
+// org for example) so both have to match
+func isToolOwner(userContext types.RequestContext, tool *types.Tool) bool {
+	return tool.Owner == userContext.Owner && tool.OwnerType == userContext.OwnerType
 }
@@ -8,12 +8,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/helixml/helix/api/pkg/controller"
 	"github.com/helixml/helix/api/pkg/janitor"
 	"github.com/helixml/helix/api/pkg/pubsub"
 	"github.com/helixml/helix/api/pkg/store"
+	"github.com/helixml/helix/api/pkg/system"
 	"github.com/helixml/helix/api/pkg/tools"
 	"github.com/helixml/helix/api/pkg/types"
 	"github.com/stretchr/testify/suite"
@@ -96,6 +98,12 @@ func (suite *ToolsTestSuite) TestListTools() {
 		OwnerType: types.OwnerTypeUser,
 	}).Return(tools, nil)
 
+	lastUsed := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.store.EXPECT().ListToolUsage(gomock.Any(), []string{"tool_1", "tool_2"}).Return([]*types.ToolActionUsage{
+		{ToolID: "tool_1", Action: "listPets", Count: 3, LastUsed: lastUsed.Add(-time.Hour)},
+		{ToolID: "tool_1", Action: "getPet", Count: 2, LastUsed: lastUsed},
+	}, nil)
+
 	req, err := http.NewRequest("GET", "/api/v1/tools", http.NoBody)
 	suite.NoError(err)
 
@@ -111,7 +119,51 @@ func (suite *ToolsTestSuite) TestListTools() {
 
 	var resp []*types.Tool
 	suite.NoError(json.NewDecoder(rec.Body).Decode(&resp))
-	suite.Equal(tools, resp)
+	suite.Require().Len(resp, 2)
+	suite.Equal(int64(5), resp[0].UsageCount)
+	suite.Require().NotNil(resp[0].LastUsed)
+	suite.True(lastUsed.Equal(*resp[0].LastUsed))
+	suite.Len(resp[0].ActionUsage, 2)
+
+	// never used
+	suite.Equal(int64(0), resp[1].UsageCount)
+	suite.Nil(resp[1].LastUsed)
+	suite.Empty(resp[1].ActionUsage)
+}
+
+func (suite *ToolsTestSuite) TestGetTool() {
+	suite.store.EXPECT().GetTool(gomock.Any(), "tool_1").Return(&types.Tool{
+		ID:        "tool_1",
+		Name:      "tool_1_name",
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+	suite.store.EXPECT().ListToolUsage(gomock.Any(), []string{"tool_1"}).Return([]*types.ToolActionUsage{
+		{ToolID: "tool_1", Action: "listPets", Count: 1, LastUsed: time.Now()},
+	}, nil)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, suite.authenticatedRequest("GET", "/api/v1/tools/tool_1", nil))
+
+	suite.Require().Equal(http.StatusOK, rec.Code)
+
+	var resp types.Tool
+	suite.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+	suite.Equal("tool_1", resp.ID)
+	suite.Equal(int64(1), resp.UsageCount)
+	suite.Require().Len(resp.ActionUsage, 1)
+	suite.Equal("listPets", resp.ActionUsage[0].Action)
+}
+
+func (suite *ToolsTestSuite) TestGetTool_OtherOwnerType() {
+	suite.store.EXPECT().GetTool(gomock.Any(), "tool_org").Return(suite.otherOwnerTypeTool(), nil)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, suite.authenticatedRequest("GET", "/api/v1/tools/tool_org", nil))
+
+	suite.Require().Equal(http.StatusNotFound, rec.Code)
 }
 
 func (suite *ToolsTestSuite) TestCreateTool() {
@@ -173,6 +225,551 @@ func (suite *ToolsTestSuite) TestCreateTool() {
 
 }
 
+func (suite *ToolsTestSuite) createToolRequest(idempotencyKey string) (*http.Request, string) {
+	bts, err := json.Marshal(&types.Tool{
+		Name:        "tool_1_name",
+		Description: "tool_1_description",
+		ToolType:    types.ToolTypeAPI,
+		Config: types.ToolConfig{
+			API: &types.ToolApiConfig{
+				URL:    "http://example.com",
+				Schema: base64.StdEncoding.EncodeToString([]byte(petStoreApiSpec)),
+			},
+		},
+	})
+	suite.NoError(err)
+
+	req, err := http.NewRequest("POST", "/api/v1/tools", bytes.NewBuffer(bts))
+	suite.NoError(err)
+
+	req.Header.Set("Authorization", "Bearer hl-API_KEY")
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+
+	return req.WithContext(suite.authCtx), hashRequestBody(bts)
+}
+
+func (suite *ToolsTestSuite) TestCreateTool_IdempotencyKeySaved() {
+	suite.store.EXPECT().CheckAPIKey(gomock.Any(), "hl-API_KEY").Return(&types.ApiKey{
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	req, requestHash := suite.createToolRequest("key_1")
+
+	// the key is reserved before the tool is created
+	reserve := suite.store.EXPECT().CreateIdempotencyKey(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, key *types.IdempotencyKey) (*types.IdempotencyKey, error) {
+			suite.Equal("key_1", key.Key)
+			suite.Equal(suite.userID, key.Owner)
+			suite.Equal(types.IdempotencyKeyScopeCreateTool, key.Scope)
+			suite.Equal(requestHash, key.RequestHash)
+			suite.Empty(key.ResourceID)
+			suite.True(key.Expires.After(time.Now()))
+			return key, nil
+		})
+
+	suite.store.EXPECT().ListTools(gomock.Any(), gomock.Any()).Return([]*types.Tool{}, nil).After(reserve)
+
+	create := suite.store.EXPECT().CreateTool(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, tool *types.Tool) (*types.Tool, error) {
+			tool.ID = "tool_1"
+			return tool, nil
+		}).After(reserve)
+
+	suite.store.EXPECT().UpdateIdempotencyKey(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, key *types.IdempotencyKey) (*types.IdempotencyKey, error) {
+			suite.Equal("key_1", key.Key)
+			suite.Equal("tool_1", key.ResourceID)
+			return key, nil
+		}).After(create)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, req)
+
+	suite.Require().Equal(http.StatusOK, rec.Code)
+}
+
+func (suite *ToolsTestSuite) TestCreateTool_IdempotencyKeyReleasedOnFailure() {
+	suite.store.EXPECT().CheckAPIKey(gomock.Any(), "hl-API_KEY").Return(&types.ApiKey{
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	req, _ := suite.createToolRequest("key_1")
+
+	suite.store.EXPECT().CreateIdempotencyKey(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, key *types.IdempotencyKey) (*types.IdempotencyKey, error) {
+			return key, nil
+		})
+	suite.store.EXPECT().ListTools(gomock.Any(), gomock.Any()).Return([]*types.Tool{
+		{ID: "tool_2", Name: "tool_1_name"},
+	}, nil)
+
+	// so a retry isn't told it's still in progress
+	suite.store.EXPECT().DeleteIdempotencyKey(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, key *types.IdempotencyKey) error {
+			suite.Equal("key_1", key.Key)
+			return nil
+		})
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, req)
+
+	suite.Require().Equal(http.StatusBadRequest, rec.Code)
+}
+
+func (suite *ToolsTestSuite) TestCreateTool_IdempotencyKeyReplay() {
+	suite.store.EXPECT().CheckAPIKey(gomock.Any(), "hl-API_KEY").Return(&types.ApiKey{
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	req, requestHash := suite.createToolRequest("key_1")
+
+	suite.store.EXPECT().CreateIdempotencyKey(gomock.Any(), gomock.Any()).Return(nil, store.ErrAlreadyExists)
+
+	query := &store.GetIdempotencyKeyQuery{
+		Key:       "key_1",
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+		Scope:     types.IdempotencyKeyScopeCreateTool,
+	}
+	suite.store.EXPECT().GetIdempotencyKey(gomock.Any(), query).Return(&types.IdempotencyKey{
+		Key:         "key_1",
+		RequestHash: requestHash,
+		ResourceID:  "tool_1",
+	}, nil)
+
+	// the tool is not created again
+	suite.store.EXPECT().GetTool(gomock.Any(), "tool_1").Return(&types.Tool{
+		ID:        "tool_1",
+		Name:      "tool_1_name",
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, req)
+
+	suite.Require().Equal(http.StatusOK, rec.Code)
+
+	var resp *types.Tool
+	suite.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+	suite.Equal("tool_1", resp.ID)
+}
+
+func (suite *ToolsTestSuite) TestCreateTool_IdempotencyKeyInProgress() {
+	suite.store.EXPECT().CheckAPIKey(gomock.Any(), "hl-API_KEY").Return(&types.ApiKey{
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	req, requestHash := suite.createToolRequest("key_1")
+
+	// the first request has reserved the key but not created the tool yet
+	suite.store.EXPECT().CreateIdempotencyKey(gomock.Any(), gomock.Any()).Return(nil, store.ErrAlreadyExists)
+	suite.store.EXPECT().GetIdempotencyKey(gomock.Any(), gomock.Any()).Return(&types.IdempotencyKey{
+		Key:         "key_1",
+		RequestHash: requestHash,
+	}, nil)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, req)
+
+	suite.Require().Equal(http.StatusConflict, rec.Code)
+	suite.Contains(rec.Body.String(), "idempotency key key_1 is being used by another request")
+}
+
+func (suite *ToolsTestSuite) TestCreateTool_IdempotencyKeyConflict() {
+	suite.store.EXPECT().CheckAPIKey(gomock.Any(), "hl-API_KEY").Return(&types.ApiKey{
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	suite.store.EXPECT().CreateIdempotencyKey(gomock.Any(), gomock.Any()).Return(nil, store.ErrAlreadyExists)
+	suite.store.EXPECT().GetIdempotencyKey(gomock.Any(), gomock.Any()).Return(&types.IdempotencyKey{
+		Key:         "key_1",
+		RequestHash: "some_other_request",
+		ResourceID:  "tool_1",
+	}, nil)
+
+	req, _ := suite.createToolRequest("key_1")
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, req)
+
+	suite.Require().Equal(http.StatusConflict, rec.Code)
+	suite.Contains(rec.Body.String(), "idempotency key key_1 was already used")
+}
+
+func (suite *ToolsTestSuite) TestUpdateTool_NameCollision() {
+	suite.store.EXPECT().CheckAPIKey(gomock.Any(), "hl-API_KEY").Return(&types.ApiKey{
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	suite.store.EXPECT().GetTool(gomock.Any(), "tool_2").Return(&types.Tool{
+		ID:        "tool_2",
+		Name:      "tool_2_name",
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	suite.store.EXPECT().ListTools(gomock.Any(), &store.ListToolsQuery{
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}).Return([]*types.Tool{
+		{
+			ID:   "tool_1",
+			Name: "tool_1_name",
+		},
+		{
+			ID:   "tool_2",
+			Name: "tool_2_name",
+		},
+	}, nil)
+
+	bts, err := json.Marshal(&types.Tool{
+		Name:        "tool_1_name",
+		Description: "tool_2_description",
+		ToolType:    types.ToolTypeAPI,
+		Config: types.ToolConfig{
+			API: &types.ToolApiConfig{
+				URL:    "http://example.com",
+				Schema: base64.StdEncoding.EncodeToString([]byte(petStoreApiSpec)),
+			},
+		},
+	})
+	suite.NoError(err)
+
+	req, err := http.NewRequest("PUT", "/api/v1/tools/tool_2", bytes.NewBuffer(bts))
+	suite.NoError(err)
+
+	req.Header.Set("Authorization", "Bearer hl-API_KEY")
+
+	req = req.WithContext(suite.authCtx)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, req)
+
+	suite.Require().Equal(http.StatusBadRequest, rec.Code)
+	suite.Equal("application/json", rec.Header().Get("Content-Type"))
+
+	var errResp system.HTTPErrorResponse
+	suite.Require().NoError(json.NewDecoder(rec.Body).Decode(&errResp))
+	suite.Equal(system.HTTPErrorCodeToolNameConflict, errResp.Error.Code)
+	suite.Contains(errResp.Error.Message, "tool (tool_1) with name tool_1_name already exists")
+	suite.Contains(errResp.Error.Message, "tool_2")
+}
+
+func (suite *ToolsTestSuite) TestAddSessionTool() {
+	suite.store.EXPECT().CheckAPIKey(gomock.Any(), "hl-API_KEY").Return(&types.ApiKey{
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	suite.store.EXPECT().GetSession(gomock.Any(), "session_1").Return(&types.Session{
+		ID:        "session_1",
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	tool := &types.Tool{
+		ID:        "tool_1",
+		Name:      "tool_1_name",
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}
+
+	suite.store.EXPECT().GetTool(gomock.Any(), "tool_1").Return(tool, nil)
+	suite.store.EXPECT().CreateSessionToolBinding(gomock.Any(), "session_1", "tool_1").Return(nil)
+	suite.store.EXPECT().ListSessionTools(gomock.Any(), "session_1").Return([]*types.Tool{tool}, nil)
+
+	bts, err := json.Marshal(&types.SessionToolRequest{ToolID: "tool_1"})
+	suite.NoError(err)
+
+	req, err := http.NewRequest("POST", "/api/v1/sessions/session_1/tools", bytes.NewBuffer(bts))
+	suite.NoError(err)
+
+	req.Header.Set("Authorization", "Bearer hl-API_KEY")
+
+	req = req.WithContext(suite.authCtx)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, req)
+
+	suite.Require().Equal(http.StatusOK, rec.Code)
+
+	var resp []*types.Tool
+	suite.NoError(json.NewDecoder(rec.Body).Decode(&resp))
+	suite.Equal([]*types.Tool{tool}, resp)
+}
+
+func (suite *ToolsTestSuite) TestAddSessionTool_NotOwned() {
+	suite.store.EXPECT().CheckAPIKey(gomock.Any(), "hl-API_KEY").Return(&types.ApiKey{
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	suite.store.EXPECT().GetSession(gomock.Any(), "session_1").Return(&types.Session{
+		ID:        "session_1",
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	suite.store.EXPECT().GetTool(gomock.Any(), "tool_1").Return(&types.Tool{
+		ID:        "tool_1",
+		Owner:     "someone_else",
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	bts, err := json.Marshal(&types.SessionToolRequest{ToolID: "tool_1"})
+	suite.NoError(err)
+
+	req, err := http.NewRequest("POST", "/api/v1/sessions/session_1/tools", bytes.NewBuffer(bts))
+	suite.NoError(err)
+
+	req.Header.Set("Authorization", "Bearer hl-API_KEY")
+
+	req = req.WithContext(suite.authCtx)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, req)
+
+	suite.Require().Equal(http.StatusNotFound, rec.Code)
+}
+
+// an org that happens to have the same ID as the user, with a tool of the
+// same name, must stay out of the user's reach
+const otherOwnerType = types.OwnerType("org")
+
+func (suite *ToolsTestSuite) otherOwnerTypeTool() *types.Tool {
+	return &types.Tool{
+		ID:        "tool_org",
+		Name:      "tool_1_name",
+		Owner:     suite.userID,
+		OwnerType: otherOwnerType,
+		ToolType:  types.ToolTypeAPI,
+		Config: types.ToolConfig{
+			API: &types.ToolApiConfig{
+				URL:    "http://example.com",
+				Schema: petStoreApiSpec,
+			},
+		},
+	}
+}
+
+func (suite *ToolsTestSuite) authenticatedRequest(method, url string, body []byte) *http.Request {
+	suite.store.EXPECT().CheckAPIKey(gomock.Any(), "hl-API_KEY").Return(&types.ApiKey{
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+	suite.NoError(err)
+
+	req.Header.Set("Authorization", "Bearer hl-API_KEY")
+
+	return req.WithContext(suite.authCtx)
+}
+
+func (suite *ToolsTestSuite) TestUpdateTool_OtherOwnerType() {
+	suite.store.EXPECT().GetTool(gomock.Any(), "tool_org").Return(suite.otherOwnerTypeTool(), nil)
+
+	bts, err := json.Marshal(&types.Tool{
+		Name:     "tool_1_name",
+		ToolType: types.ToolTypeAPI,
+		Config: types.ToolConfig{
+			API: &types.ToolApiConfig{
+				URL:    "http://example.com",
+				Schema: base64.StdEncoding.EncodeToString([]byte(petStoreApiSpec)),
+			},
+		},
+	})
+	suite.NoError(err)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, suite.authenticatedRequest("PUT", "/api/v1/tools/tool_org", bts))
+
+	suite.Require().Equal(http.StatusNotFound, rec.Code)
+}
+
+func (suite *ToolsTestSuite) TestDeleteTool_OtherOwnerType() {
+	suite.store.EXPECT().GetTool(gomock.Any(), "tool_org").Return(suite.otherOwnerTypeTool(), nil)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, suite.authenticatedRequest("DELETE", "/api/v1/tools/tool_org", nil))
+
+	suite.Require().Equal(http.StatusNotFound, rec.Code)
+}
+
+func (suite *ToolsTestSuite) TestAddSessionTool_OtherOwnerType() {
+	suite.store.EXPECT().GetSession(gomock.Any(), "session_1").Return(&types.Session{
+		ID:        "session_1",
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}, nil)
+	suite.store.EXPECT().GetTool(gomock.Any(), "tool_org").Return(suite.otherOwnerTypeTool(), nil)
+
+	bts, err := json.Marshal(&types.SessionToolRequest{ToolID: "tool_org"})
+	suite.NoError(err)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, suite.authenticatedRequest("POST", "/api/v1/sessions/session_1/tools", bts))
+
+	suite.Require().Equal(http.StatusNotFound, rec.Code)
+}
+
+func (suite *ToolsTestSuite) TestCreateTool_SameNameAsOtherOwnerType() {
+	// only the user's own tools are checked for the name, the org's tool
+	// of the same name is never looked at
+	suite.store.EXPECT().ListTools(gomock.Any(), &store.ListToolsQuery{
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}).Return([]*types.Tool{}, nil)
+	suite.store.EXPECT().CreateTool(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, tool *types.Tool) (*types.Tool, error) {
+			suite.Equal(suite.userID, tool.Owner)
+			suite.Equal(types.OwnerTypeUser, tool.OwnerType)
+			tool.ID = "tool_user"
+			return tool, nil
+		})
+
+	bts, err := json.Marshal(&types.Tool{
+		Name:     suite.otherOwnerTypeTool().Name,
+		ToolType: types.ToolTypeAPI,
+		Config: types.ToolConfig{
+			API: &types.ToolApiConfig{
+				URL:    "http://example.com",
+				Schema: base64.StdEncoding.EncodeToString([]byte(petStoreApiSpec)),
+			},
+		},
+	})
+	suite.NoError(err)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, suite.authenticatedRequest("POST", "/api/v1/tools", bts))
+
+	suite.Require().Equal(http.StatusOK, rec.Code)
+}
+
+// the pet store spec renames getPet to showPetById, removes deletePet and
+// adds createPets
+func (suite *ToolsTestSuite) expectToolWithOldActions() {
+	suite.store.EXPECT().GetTool(gomock.Any(), "tool_1").Return(&types.Tool{
+		ID:        "tool_1",
+		Name:      "tool_1_name",
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+		ToolType:  types.ToolTypeAPI,
+		Config: types.ToolConfig{
+			API: &types.ToolApiConfig{
+				Actions: []*types.ToolApiAction{
+					{Name: "listPets", Method: "get", Path: "/pets"},
+					{Name: "getPet", Method: "get", Path: "/pets/{petId}"},
+					{Name: "deletePet", Method: "delete", Path: "/pets/{petId}"},
+				},
+			},
+		},
+	}, nil)
+
+	suite.store.EXPECT().ListTools(gomock.Any(), &store.ListToolsQuery{
+		Owner:     suite.userID,
+		OwnerType: types.OwnerTypeUser,
+	}).Return([]*types.Tool{{ID: "tool_1", Name: "tool_1_name"}}, nil)
+}
+
+func (suite *ToolsTestSuite) updatePetStoreToolRequest(query string) *http.Request {
+	bts, err := json.Marshal(&types.Tool{
+		Name:     "tool_1_name",
+		ToolType: types.ToolTypeAPI,
+		Config: types.ToolConfig{
+			API: &types.ToolApiConfig{
+				URL:    "http://example.com",
+				Schema: base64.StdEncoding.EncodeToString([]byte(petStoreApiSpec)),
+			},
+		},
+	})
+	suite.NoError(err)
+
+	return suite.authenticatedRequest("PUT", "/api/v1/tools/tool_1"+query, bts)
+}
+
+func (suite *ToolsTestSuite) assertPetStoreActionsDiff(diff *types.ToolActionsDiff) {
+	suite.Require().NotNil(diff)
+	suite.Equal([]string{"createPets"}, diff.Added)
+	suite.Equal([]string{"deletePet"}, diff.Removed)
+	suite.Equal([]*types.ToolActionRename{{From: "getPet", To: "showPetById"}}, diff.Renamed)
+}
+
+func (suite *ToolsTestSuite) TestUpdateTool_ActionsInUse() {
+	suite.expectToolWithOldActions()
+	suite.store.EXPECT().CountToolSessions(gomock.Any(), "tool_1").Return(int64(2), nil)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, suite.updatePetStoreToolRequest(""))
+
+	suite.Require().Equal(http.StatusConflict, rec.Code)
+
+	var errResp struct {
+		Error struct {
+			Code    system.HTTPErrorCode          `json:"code"`
+			Details types.ToolActionsInUseDetails `json:"details"`
+		} `json:"error"`
+	}
+	suite.Require().NoError(json.NewDecoder(rec.Body).Decode(&errResp))
+	suite.Equal(system.HTTPErrorCodeToolActionsInUse, errResp.Error.Code)
+	suite.Equal(int64(2), errResp.Error.Details.Sessions)
+	suite.assertPetStoreActionsDiff(errResp.Error.Details.ActionsDiff)
+}
+
+func (suite *ToolsTestSuite) TestUpdateTool_Force() {
+	suite.expectToolWithOldActions()
+	suite.store.EXPECT().UpdateTool(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, tool *types.Tool) (*types.Tool, error) {
+			return tool, nil
+		})
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, suite.updatePetStoreToolRequest("?force=true"))
+
+	suite.Require().Equal(http.StatusOK, rec.Code)
+
+	var resp *types.Tool
+	suite.Require().NoError(json.NewDecoder(rec.Body).Decode(&resp))
+	suite.assertPetStoreActionsDiff(resp.ActionsDiff)
+}
+
+func (suite *ToolsTestSuite) TestUpdateTool_DryRun() {
+	suite.expectToolWithOldActions()
+	// not bound to any sessions, the tool still isn't saved
+	suite.store.EXPECT().CountToolSessions(gomock.Any(), "tool_1").Return(int64(0), nil)
+
+	rec := httptest.NewRecorder()
+
+	suite.server.router.ServeHTTP(rec, suite.updatePetStoreToolRequest("?dry_run=true"))
+
+	suite.Require().Equal(http.StatusOK, rec.Code)
+
+	var resp *types.Tool
+	suite.Require().NoError(json.NewDecoder(rec.Body).Decode(&resp))
+	suite.assertPetStoreActionsDiff(resp.ActionsDiff)
+}
+
 const petStoreApiSpec = `openapi: "3.0.0"
 info:
   version: 1.0.0
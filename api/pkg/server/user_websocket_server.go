@@ -3,12 +3,14 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"sync"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/helixml/helix/api/pkg/pubsub"
+	"github.com/helixml/helix/api/pkg/types"
 	"github.com/rs/zerolog/log"
 )
 
@@ -44,18 +46,7 @@ func (apiServer *HelixAPIServer) startUserWebSocketServer(
 		for {
 			select {
 			case event := <-apiServer.Controller.UserWebsocketEventChanWriter:
-				log.Trace().Msgf("User websocket event: %+v", event)
-				message, err := json.Marshal(event)
-				if err != nil {
-					log.Error().Msgf("Error marshalling session update: %s", err.Error())
-					continue
-				}
-
-				err = apiServer.pubsub.Publish(ctx, pubsub.GetSessionQueue(event.Owner, event.SessionID), message)
-				if err != nil {
-					log.Error().Msgf("Error publishing session update: %s", err.Error())
-				}
-
+				apiServer.publishUserEvent(ctx, event)
 			case <-ctx.Done():
 				return
 			}
@@ -86,8 +77,12 @@ func (apiServer *HelixAPIServer) startUserWebSocketServer(
 
 		defer conn.Close()
 
+		tracked := apiServer.websockets.add(conn)
+		defer apiServer.websockets.remove(tracked)
+
 		sub, err := apiServer.pubsub.Subscribe(r.Context(), pubsub.GetSessionQueue(userID, sessionID), func(payload []byte) error {
-			if err := conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+			err := tracked.writeMessage(websocket.TextMessage, payload)
+			if err != nil && !errors.Is(err, errWebsocketClosing) {
 				log.Error().Msgf("Error writing to websocket: %s", err.Error())
 			}
 			return nil
@@ -120,3 +115,47 @@ func (apiServer *HelixAPIServer) startUserWebSocketServer(
 		}
 	})
 }
+
+func (apiServer *HelixAPIServer) publishUserEvent(ctx context.Context, event *types.WebsocketEvent) {
+	log.Trace().Msgf("User websocket event: %+v", event)
+	message, err := json.Marshal(event)
+	if err != nil {
+		log.Error().Msgf("Error marshalling session update: %s", err.Error())
+		return
+	}
+
+	err = apiServer.pubsub.Publish(ctx, pubsub.GetSessionQueue(event.Owner, event.SessionID), message)
+	if err != nil {
+		log.Error().Msgf("Error publishing session update: %s", err.Error())
+	}
+}
+
+// flushUserEvents publishes the events still queued up for the user
+// websockets so they go out before the connections are closed
+func (apiServer *HelixAPIServer) flushUserEvents(ctx context.Context) {
+queued:
+	for {
+		select {
+		case event := <-apiServer.Controller.UserWebsocketEventChanWriter:
+			apiServer.publishUserEvent(ctx, event)
+		default:
+			break queued
+		}
+	}
+
+	err := apiServer.pubsub.Flush(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to flush session updates")
+	}
+}
+
+// drainWebsockets closes the websockets with a going away frame once any
+// pending events have been sent, http.Server.Shutdown doesn't wait for them
+// as they are hijacked connections
+func (apiServer *HelixAPIServer) drainWebsockets(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, websocketDrainTimeout)
+	defer cancel()
+
+	apiServer.flushUserEvents(ctx)
+	apiServer.websockets.drain(ctx)
+}
@@ -12,14 +12,64 @@ import (
 	"time"
 
 	"github.com/helixml/helix/api/pkg/controller"
+	"github.com/helixml/helix/api/pkg/data"
 	"github.com/helixml/helix/api/pkg/system"
 	"github.com/helixml/helix/api/pkg/types"
 	"github.com/rs/zerolog/log"
 )
 
+// if no origins are configured (or one of them is "*") then any origin can call
+// the api but without credentials - otherwise we only echo back the origins
+// we know about and let them send credentials
 func (apiServer *HelixAPIServer) corsMiddleware(next http.Handler) http.Handler {
+	allowAll := false
+	allowedOrigins := map[string]bool{}
+	// the origins come from a comma separated list so allow for
+	// "a.com, b.com" and trailing commas
+	for _, origin := range apiServer.Options.AllowedOrigins {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		if origin == "*" {
+			allowAll = true
+		}
+		allowedOrigins[origin] = true
+	}
+	if len(allowedOrigins) == 0 {
+		allowAll = true
+	}
+
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		res.Header().Set("Access-Control-Allow-Origin", "*")
+		origin := req.Header.Get("Origin")
+		allowed := false
+
+		if allowAll {
+			res.Header().Set("Access-Control-Allow-Origin", "*")
+			allowed = true
+		} else {
+			// the response depends on the origin so caches must not share it
+			res.Header().Add("Vary", "Origin")
+			if origin != "" && allowedOrigins[origin] {
+				res.Header().Set("Access-Control-Allow-Origin", origin)
+				res.Header().Set("Access-Control-Allow-Credentials", "true")
+				allowed = true
+			}
+		}
+
+		// answer preflight requests here as the router would 405 them
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed {
+				res.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+				if headers := req.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					res.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+				res.Header().Set("Access-Control-Max-Age", "600")
+			}
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+
 		next.ServeHTTP(res, req)
 	})
 }
@@ -59,6 +109,21 @@ func (apiServer *HelixAPIServer) canSeeSession(reqContext types.RequestContext,
 	return false
 }
 
+// someone other than the owner (or an admin) has read a session because it
+// is shared - an empty viewer means they were not logged in
+func logSharedSessionAccess(req *http.Request, session *types.Session, viewer string) {
+	if viewer == "" {
+		viewer = "anonymous"
+	}
+	log.Ctx(req.Context()).Info().
+		Str("session_id", session.ID).
+		Str("owner", session.Owner).
+		Str("viewer", viewer).
+		Str("method", req.Method).
+		Str("path", req.URL.Path).
+		Msg("shared session accessed")
+}
+
 func (apiServer *HelixAPIServer) canEditSession(reqContext types.RequestContext, session *types.Session) bool {
 	if session.OwnerType == reqContext.OwnerType && session.Owner == reqContext.Owner {
 		return true
@@ -93,6 +158,13 @@ func (lrw *LoggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error)
 	return hijacker.Hijack()
 }
 
+// Flush lets streaming handlers push data through the wrapper.
+func (lrw *LoggingResponseWriter) Flush() {
+	if flusher, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func errorLoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Wrap the ResponseWriter
@@ -101,12 +173,13 @@ func errorLoggingMiddleware(next http.Handler) http.Handler {
 		// Call the next handler, which can be another middleware in the chain, or the final handler.
 		next.ServeHTTP(lrw, r)
 
+		requestID := system.GetRequestID(r.Context())
 		switch lrw.statusCode {
 		case http.StatusForbidden:
-			log.Warn().Msgf("unauthorized - method: %s, path: %s, status: %d\n", r.Method, r.URL.Path, lrw.statusCode)
+			log.Warn().Str("request_id", requestID).Msgf("unauthorized - method: %s, path: %s, status: %d\n", r.Method, r.URL.Path, lrw.statusCode)
 		default:
 			if lrw.statusCode >= 400 {
-				log.Error().Msgf("method: %s, path: %s, status: %d\n", r.Method, r.URL.Path, lrw.statusCode)
+				log.Error().Str("request_id", requestID).Msgf("method: %s, path: %s, status: %d\n", r.Method, r.URL.Path, lrw.statusCode)
 			}
 		}
 
@@ -140,6 +213,7 @@ func (apiServer *HelixAPIServer) getUserInteractionFromForm(
 	inputPath := controller.GetInteractionInputsFolder(sessionID, interactionID)
 
 	metadata := map[string]string{}
+	fileSizes := map[string]int64{}
 
 	if okFiles {
 		for _, fileHeader := range files {
@@ -158,6 +232,7 @@ func (apiServer *HelixAPIServer) getUserInteractionFromForm(
 			}
 			log.Debug().Msgf("success uploading file: %s", imageItem.Path)
 			filePaths = append(filePaths, imageItem.Path)
+			fileSizes[imageItem.Path] = fileHeader.Size
 
 			// let's see if there is a single form field named after the filename
 			// this is for labelling images for fine tuning
@@ -178,6 +253,7 @@ func (apiServer *HelixAPIServer) getUserInteractionFromForm(
 				}
 				log.Debug().Msgf("success uploading file: %s", fileHeader.Filename)
 				filePaths = append(filePaths, labelItem.Path)
+				fileSizes[labelItem.Path] = int64(len(label))
 			}
 		}
 		log.Debug().Msgf("success uploading files")
@@ -193,6 +269,7 @@ func (apiServer *HelixAPIServer) getUserInteractionFromForm(
 		Mode:           sessionMode,
 		Message:        message,
 		Files:          filePaths,
+		FileSizes:      fileSizes,
 		State:          types.InteractionStateComplete,
 		Finished:       true,
 		Metadata:       metadata,
@@ -200,6 +277,31 @@ func (apiServer *HelixAPIServer) getUserInteractionFromForm(
 	}, nil
 }
 
+// the limit on the total size of the files of the owner's sessions
+func (apiServer *HelixAPIServer) getMaxSessionFileSize(ctx context.Context, owner string) (int64, error) {
+	status, err := apiServer.Controller.GetStatus(types.RequestContext{Ctx: ctx, Owner: owner})
+	if err != nil {
+		return 0, err
+	}
+	return apiServer.Controller.GetMaxSessionFileSize(status.Config), nil
+}
+
+// check the files being uploaded along with the ones the session already
+// has fit within the limit before we put any of them in the filestore
+func checkUploadFileSize(req *http.Request, existing []*types.Interaction, limit int64) *system.HTTPError {
+	var size int64
+	if req.MultipartForm != nil {
+		for _, fileHeader := range req.MultipartForm.File["files"] {
+			size += fileHeader.Size
+		}
+	}
+	err := data.CheckSessionFileSize(data.GetSessionFileSize(existing)+size, limit)
+	if err != nil {
+		return &system.HTTPError{StatusCode: http.StatusRequestEntityTooLarge, Message: err.Error()}
+	}
+	return nil
+}
+
 func (apiServer *HelixAPIServer) convertFilestorePath(ctx context.Context, sessionID string, filePath string) (string, types.OwnerContext, error) {
 	session, err := apiServer.Store.GetSession(ctx, sessionID)
 	if err != nil {
@@ -262,7 +364,11 @@ func (apiServer *HelixAPIServer) isFilestoreRouteAuthorized(req *http.Request) (
 		if err != nil {
 			return false, err
 		}
-		if session.Metadata.Shared {
+		if session != nil && session.Metadata.Shared {
+			reqContext := apiServer.getRequestContext(req)
+			if !apiServer.canEditSession(reqContext, session) {
+				logSharedSessionAccess(req, session, reqContext.Owner)
+			}
 			return true, nil
 		}
 	}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func corsTestHandler(allowedOrigins []string) http.Handler {
+	apiServer := &HelixAPIServer{
+		Options: ServerOptions{
+			AllowedOrigins: allowedOrigins,
+		},
+	}
+	return apiServer.corsMiddleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+}
+
+func Test_corsMiddleware_NoOriginsConfigured(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil)
+	req.Header.Set("Origin", "https://example.com")
+	rec := httptest.NewRecorder()
+
+	corsTestHandler(nil).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func Test_corsMiddleware_AllowedOrigin(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+
+	corsTestHandler([]string{"https://app.example.com/"}).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "Origin", rec.Header().Get("Vary"))
+}
+
+func Test_corsMiddleware_DisallowedOrigin(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec := httptest.NewRecorder()
+
+	corsTestHandler([]string{"https://app.example.com"}).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func Test_corsMiddleware_TrimsOrigins(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/sessions", nil)
+	req.Header.Set("Origin", "https://other.example.com")
+	rec := httptest.NewRecorder()
+
+	corsTestHandler([]string{"https://app.example.com", " https://other.example.com/ ", ""}).ServeHTTP(rec, req)
+
+	assert.Equal(t, "https://other.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+
+	// nothing but empty entries is the same as none configured
+	rec = httptest.NewRecorder()
+	corsTestHandler([]string{" ", ""}).ServeHTTP(rec, req)
+
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func Test_corsMiddleware_Preflight(t *testing.T) {
+	req := httptest.NewRequest(http.MethodOptions, "/api/v1/sessions", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "Authorization, Content-Type")
+	rec := httptest.NewRecorder()
+
+	corsTestHandler([]string{"https://app.example.com"}).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, rec.Header().Get("Access-Control-Allow-Methods"), http.MethodPost)
+	assert.Equal(t, "Authorization, Content-Type", rec.Header().Get("Access-Control-Allow-Headers"))
+}
+
+func newUploadRequest(t *testing.T, files map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, content := range files {
+		part, err := writer.CreateFormFile("files", name)
+		require.NoError(t, err)
+		_, err = part.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/sessions", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	require.NoError(t, req.ParseMultipartForm(10<<20))
+	return req
+}
+
+func Test_checkUploadFileSize(t *testing.T) {
+	req := newUploadRequest(t, map[string]string{"a.txt": "0123456789", "b.txt": "0123456789"})
+	existing := []*types.Interaction{
+		{Creator: types.CreatorTypeUser, FileSizes: map[string]int64{"old.txt": 5}},
+	}
+
+	assert.Nil(t, checkUploadFileSize(req, existing, 0))
+	assert.Nil(t, checkUploadFileSize(req, existing, 25))
+
+	httpError := checkUploadFileSize(req, existing, 24)
+	require.NotNil(t, httpError)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, httpError.StatusCode)
+	assert.Contains(t, httpError.Message, "25 bytes")
+
+	// nothing uploaded only counts what is already there
+	assert.Nil(t, checkUploadFileSize(httptest.NewRequest(http.MethodPut, "/api/v1/sessions/123", nil), existing, 5))
+}
+
+func Test_parseFreeMemory(t *testing.T) {
+	memory, err := parseFreeMemory("1024")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1024), memory)
+
+	// an overcommitted older runner has nothing free
+	memory, err = parseFreeMemory("-2048")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), memory)
+
+	_, err = parseFreeMemory("lots")
+	assert.Error(t, err)
+}
+
+func Test_parseRejectEntry(t *testing.T) {
+	entry, err := parseRejectEntry("mistralai/Mistral-7B-Instruct-v0.1:inference:none")
+	require.NoError(t, err)
+	assert.Equal(t, types.SessionFilterModel{
+		ModelName: types.Model_Axolotl_Mistral7b,
+		Mode:      types.SessionModeInference,
+		LoraDir:   types.LORA_DIR_NONE,
+	}, entry)
+
+	// a model process started with env vars
+	entry, err = parseRejectEntry("mistralai/Mistral-7B-Instruct-v0.1:inference@0123456789abcdef:none")
+	require.NoError(t, err)
+	assert.Equal(t, types.SessionModeInference, entry.Mode)
+	assert.Equal(t, "0123456789abcdef", entry.Env)
+
+	// ollama model names have a tag
+	entry, err = parseRejectEntry(string(types.Model_Ollama_Mistral7b) + ":inference:")
+	require.NoError(t, err)
+	assert.Equal(t, types.Model_Ollama_Mistral7b, entry.ModelName)
+	assert.Empty(t, entry.Env)
+
+	_, err = parseRejectEntry("mistralai/Mistral-7B-Instruct-v0.1:inference")
+	assert.Error(t, err)
+	_, err = parseRejectEntry("mistralai/Mistral-7B-Instruct-v0.1:sleeping@abc:none")
+	assert.Error(t, err)
+}
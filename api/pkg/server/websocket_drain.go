@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// sent as the reason of the going away close frame so clients can tell a
+	// planned restart apart from a dropped connection and reconnect quietly
+	websocketCloseReasonRestart = "server_restart"
+	// how long clients get to answer our close frame before we hang up on them,
+	// this has to leave room in shutdownTimeout for the rest of the shutdown
+	websocketDrainTimeout = 5 * time.Second
+)
+
+var errWebsocketClosing = errors.New("websocket is closing")
+
+// trackedWebsocket serialises the writes to a connection, gorilla only
+// allows one concurrent writer and both the event fan out and the drain
+// write to it
+type trackedWebsocket struct {
+	conn    *websocket.Conn
+	mu      sync.Mutex
+	closing bool
+	// closed once the handler has stopped reading from the connection
+	done chan struct{}
+}
+
+func (c *trackedWebsocket) writeMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return errWebsocketClosing
+	}
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// goAway tells the client we are restarting, nothing more is written to the
+// connection after this
+func (c *trackedWebsocket) goAway() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return nil
+	}
+	c.closing = true
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, websocketCloseReasonRestart)
+	return c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+}
+
+// websocketTracker keeps the open websockets so shutdown can close them
+// properly, http.Server.Shutdown doesn't know about hijacked connections
+type websocketTracker struct {
+	mu       sync.Mutex
+	conns    map[*trackedWebsocket]struct{}
+	draining bool
+}
+
+// add starts tracking a connection, once we are draining the connection is
+// told to go away straight away
+func (t *websocketTracker) add(conn *websocket.Conn) *trackedWebsocket {
+	c := &trackedWebsocket{conn: conn, done: make(chan struct{})}
+
+	t.mu.Lock()
+	draining := t.draining
+	if !draining {
+		if t.conns == nil {
+			t.conns = map[*trackedWebsocket]struct{}{}
+		}
+		t.conns[c] = struct{}{}
+	}
+	t.mu.Unlock()
+
+	if draining {
+		_ = c.goAway()
+	}
+	return c
+}
+
+func (t *websocketTracker) remove(c *trackedWebsocket) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, c)
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+}
+
+// drain sends every connection a going away close frame and waits for the
+// clients to close their end, anything still open when ctx is done is closed
+// from our side
+func (t *websocketTracker) drain(ctx context.Context) {
+	t.mu.Lock()
+	t.draining = true
+	conns := make([]*trackedWebsocket, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mu.Unlock()
+
+	if len(conns) == 0 {
+		return
+	}
+
+	log.Info().Int("connections", len(conns)).Msg("closing websockets")
+
+	for _, c := range conns {
+		err := c.goAway()
+		if err != nil {
+			log.Debug().Err(err).Msg("failed to send websocket close frame")
+		}
+	}
+
+	for _, c := range conns {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			// the read loop will notice and clean up
+			_ = c.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a websocket server that tracks its connections like the user and runner
+// websocket handlers do
+func newTrackedWebsocketServer(t *testing.T, tracker *websocketTracker) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := userWebsocketUpgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		tracked := tracker.add(conn)
+		defer tracker.remove(tracked)
+
+		_ = tracked.writeMessage(websocket.TextMessage, []byte("hello"))
+
+		for {
+			_, _, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dialTrackedWebsocket(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(message))
+
+	return conn
+}
+
+func requireGoingAway(t *testing.T, conn *websocket.Conn) {
+	_, _, err := conn.ReadMessage()
+	closeErr, ok := err.(*websocket.CloseError)
+	require.True(t, ok, "expected a close error, got %v", err)
+	assert.Equal(t, websocket.CloseGoingAway, closeErr.Code)
+	assert.Equal(t, websocketCloseReasonRestart, closeErr.Text)
+}
+
+func TestWebsocketTracker_Drain(t *testing.T) {
+	tracker := &websocketTracker{}
+	url := newTrackedWebsocketServer(t, tracker)
+
+	first := dialTrackedWebsocket(t, url)
+	second := dialTrackedWebsocket(t, url)
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		tracker.drain(context.Background())
+	}()
+
+	// reading the close frame makes the client answer it, which lets the
+	// drain finish without hitting its deadline
+	requireGoingAway(t, first)
+	requireGoingAway(t, second)
+
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not finish after the clients closed")
+	}
+
+	tracker.mu.Lock()
+	assert.Empty(t, tracker.conns)
+	tracker.mu.Unlock()
+
+	// connections that arrive while draining are told to go away
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	requireGoingAway(t, conn)
+}
+
+func TestWebsocketTracker_DrainDeadline(t *testing.T) {
+	tracker := &websocketTracker{}
+	url := newTrackedWebsocketServer(t, tracker)
+
+	// the client never reads so it never answers the close frame
+	dialTrackedWebsocket(t, url)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	tracker.drain(ctx)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS session_interactions_idx;
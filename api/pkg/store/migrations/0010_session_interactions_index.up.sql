@@ -0,0 +1,3 @@
+-- lets us find the sessions a runner has worked on by matching on the
+-- runner field of the interactions
+CREATE INDEX IF NOT EXISTS session_interactions_idx ON session USING gin ((interactions::jsonb) jsonb_path_ops);
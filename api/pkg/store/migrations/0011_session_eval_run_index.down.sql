@@ -0,0 +1 @@
+DROP INDEX IF EXISTS session_eval_run_id_idx;
@@ -0,0 +1,2 @@
+-- lets us aggregate the scores of the sessions in an eval run
+CREATE INDEX IF NOT EXISTS session_eval_run_id_idx ON session ((config->>'eval_run_id'));
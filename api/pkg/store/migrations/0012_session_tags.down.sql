@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS session_tags_idx;
+ALTER TABLE session DROP COLUMN IF EXISTS tags;
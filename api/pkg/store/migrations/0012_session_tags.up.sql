@@ -0,0 +1,3 @@
+ALTER TABLE session ADD COLUMN IF NOT EXISTS tags jsonb NOT NULL DEFAULT '[]';
+-- lets us filter the session list by tag
+CREATE INDEX IF NOT EXISTS session_tags_idx ON session USING gin (tags jsonb_path_ops);
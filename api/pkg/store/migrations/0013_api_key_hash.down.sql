@@ -0,0 +1,8 @@
+-- the keys can't be got back from their hashes so they all have to be made
+-- again
+DROP INDEX IF EXISTS api_key_owner_name_idx;
+DELETE FROM api_key;
+ALTER TABLE api_key DROP COLUMN IF EXISTS created;
+ALTER TABLE api_key DROP CONSTRAINT IF EXISTS api_key_pkey;
+ALTER TABLE api_key DROP COLUMN IF EXISTS key_hash;
+ALTER TABLE api_key ADD COLUMN IF NOT EXISTS key varchar(255) PRIMARY KEY;
@@ -0,0 +1,15 @@
+-- only a sha256 of each key is kept, the key itself is shown once when it
+-- is created
+ALTER TABLE api_key ADD COLUMN IF NOT EXISTS key_hash varchar(64);
+UPDATE api_key SET key_hash = encode(sha256(convert_to(key, 'UTF8')), 'hex') WHERE key_hash IS NULL;
+ALTER TABLE api_key DROP CONSTRAINT IF EXISTS api_key_pkey;
+ALTER TABLE api_key DROP COLUMN IF EXISTS key;
+ALTER TABLE api_key ADD PRIMARY KEY (key_hash);
+ALTER TABLE api_key ADD COLUMN IF NOT EXISTS created timestamp NOT NULL DEFAULT current_timestamp;
+-- keys are revoked by name so an owner's names have to be unique, tell
+-- apart any that aren't with the start of their hash
+UPDATE api_key SET name = name || '-' || left(key_hash, 8)
+WHERE (owner, owner_type, name) IN (
+  SELECT owner, owner_type, name FROM api_key GROUP BY owner, owner_type, name HAVING count(*) > 1
+);
+CREATE UNIQUE INDEX IF NOT EXISTS api_key_owner_name_idx ON api_key (owner, owner_type, name);
@@ -3,8 +3,10 @@ package store
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"embed"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	reflect "reflect"
@@ -87,6 +89,10 @@ func (s *PostgresStore) autoMigrate() error {
 	err := s.gdb.WithContext(context.Background()).AutoMigrate(
 		&types.Tool{},
 		&types.SessionToolBinding{},
+		&types.IdempotencyKey{},
+		&types.SessionShareLink{},
+		&types.ToolActionUsage{},
+		&types.ModelInstanceJob{},
 	)
 	if err != nil {
 		return err
@@ -96,6 +102,10 @@ func (s *PostgresStore) autoMigrate() error {
 		log.Err(err).Msg("failed to add DB FK")
 	}
 
+	if err := createFK(s.gdb, types.ToolActionUsage{}, types.Tool{}, "tool_id", "id", "CASCADE", "CASCADE"); err != nil {
+		log.Err(err).Msg("failed to add DB FK")
+	}
+
 	return nil
 }
 
@@ -497,32 +507,44 @@ func (d *PostgresStore) UpdateSessionMeta(
 	return d.GetSession(ctx, data.ID)
 }
 
-func (d *PostgresStore) CreateAPIKey(ctx context.Context, owner OwnerQuery, name string) (string, error) {
+// the key is handed back in full this once, only its hash is stored
+func (d *PostgresStore) CreateAPIKey(ctx context.Context, owner OwnerQuery, name string) (*types.ApiKey, error) {
 	// Generate a new API key
 	key, err := generateAPIKey()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Insert the new API key into the database
 	sqlStatement := `
-insert into api_key (owner, owner_type, key, name)
+insert into api_key (owner, owner_type, key_hash, name)
 values ($1, $2, $3, $4)
-returning key
+on conflict (owner, owner_type, name) do nothing
+returning created
 `
-	var id string
-	err = d.pgDb.QueryRow(
+	apiKey := types.ApiKey{
+		Owner:     owner.Owner,
+		OwnerType: owner.OwnerType,
+		Key:       key,
+		Name:      name,
+	}
+	err = d.pgDb.QueryRowContext(
+		ctx,
 		sqlStatement,
 		owner.Owner,
 		owner.OwnerType,
-		key,
+		hashAPIKey(key),
 		name,
-	).Scan(&id)
+	).Scan(&apiKey.Created)
 	if err != nil {
-		return "", err
+		if err == sql.ErrNoRows {
+			// the owner already has a key with this name
+			return nil, ErrAlreadyExists
+		}
+		return nil, err
 	}
 
-	return id, nil
+	return &apiKey, nil
 }
 
 func generateAPIKey() (string, error) {
@@ -534,19 +556,29 @@ func generateAPIKey() (string, error) {
 	return types.API_KEY_PREIX + base64.URLEncoding.EncodeToString(key), nil
 }
 
+// keys are 32 random bytes so there's nothing to gain from salting them
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
 func (d *PostgresStore) GetAPIKeys(ctx context.Context, query OwnerQuery) ([]*types.ApiKey, error) {
 	var apiKeys []*types.ApiKey
 	sqlStatement := `
 select
-	key,
+	name,
 	owner,
-	owner_type	
+	owner_type,
+	created
 from
 	api_key
 where
 	owner = $1 and owner_type = $2
+order by
+	created
 `
-	rows, err := d.pgDb.Query(
+	rows, err := d.pgDb.QueryContext(
+		ctx,
 		sqlStatement,
 		query.Owner,
 		query.OwnerType,
@@ -558,9 +590,10 @@ where
 	for rows.Next() {
 		var apiKey types.ApiKey
 		err := rows.Scan(
-			&apiKey.Key,
+			&apiKey.Name,
 			&apiKey.Owner,
 			&apiKey.OwnerType,
+			&apiKey.Created,
 		)
 		if err != nil {
 			return nil, err
@@ -574,31 +607,44 @@ where
 	return apiKeys, nil
 }
 
-func (d *PostgresStore) DeleteAPIKey(ctx context.Context, apiKey types.ApiKey) error {
+func (d *PostgresStore) DeleteAPIKey(ctx context.Context, owner OwnerQuery, name string) error {
 	sqlStatement := `
-delete from api_key where key = $1 and owner = $2 and owner_type = $3
+delete from api_key where owner = $1 and owner_type = $2 and name = $3
 `
-	_, err := d.pgDb.Exec(
+	result, err := d.pgDb.ExecContext(
+		ctx,
 		sqlStatement,
-		apiKey.Key,
-		apiKey.Owner,
-		apiKey.OwnerType,
+		owner.Owner,
+		owner.OwnerType,
+		name,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
+// looked up on every request authenticated with a key so a deleted key
+// stops working straight away
 func (d *PostgresStore) CheckAPIKey(ctx context.Context, apiKey string) (*types.ApiKey, error) {
 	var key types.ApiKey
 	sqlStatement := `
 select
-	key, owner, owner_type
+	name, owner, owner_type, created
 from
 	api_key
 where
-	key = $1
+	key_hash = $1
 `
-	row := d.pgDb.QueryRow(sqlStatement, apiKey)
-	err := row.Scan(&key.Key, &key.Owner, &key.OwnerType)
+	row := d.pgDb.QueryRowContext(ctx, sqlStatement, hashAPIKey(apiKey))
+	err := row.Scan(&key.Name, &key.Owner, &key.OwnerType, &key.Created)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// not an error, but not a valid api key either
@@ -612,6 +658,10 @@ where
 // Compile-time interface check:
 var _ Store = (*PostgresStore)(nil)
 
+func (d *PostgresStore) Ping(ctx context.Context) error {
+	return d.pgDb.PingContext(ctx)
+}
+
 func (d *PostgresStore) MigrateUp() error {
 	migrations, err := d.GetMigrations()
 	if err != nil {
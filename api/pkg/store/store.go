@@ -22,8 +22,44 @@ type GetSessionsQuery struct {
 	Owner         string          `json:"owner"`
 	OwnerType     types.OwnerType `json:"owner_type"`
 	ParentSession string          `json:"parent_session"`
-	Offset        int             `json:"offset"`
-	Limit         int             `json:"limit"`
+	// case-insensitive match against the session name and interaction messages
+	Search string `json:"search"`
+	// normalized tags, sessions need any one of them unless TagsMatchAll
+	// is set and then they need every one
+	Tags         []string `json:"tags"`
+	TagsMatchAll bool     `json:"tags_match_all"`
+	Offset       int      `json:"offset"`
+	Limit        int      `json:"limit"`
+}
+
+type ListRunnerSessionsQuery struct {
+	RunnerID string `json:"runner_id"`
+	// only sessions with an interaction scheduled in this window
+	// (either can be zero for no bound)
+	After  time.Time `json:"after"`
+	Before time.Time `json:"before"`
+	Limit  int       `json:"limit"`
+}
+
+type ListFeedbackSessionsQuery struct {
+	// leave the owner empty for the sessions of every owner
+	Owner     string          `json:"owner"`
+	OwnerType types.OwnerType `json:"owner_type"`
+	ModelName types.ModelName `json:"model_name"`
+	// only sessions that could have had feedback in this window - giving
+	// feedback updates the session (either can be zero for no bound)
+	After  time.Time `json:"after"`
+	Before time.Time `json:"before"`
+}
+
+type ListModelInstanceJobsQuery struct {
+	RunnerID string `json:"runner_id"`
+	// only this model instance's jobs if set
+	ModelInstanceID string `json:"model_instance_id"`
+	// only jobs started in this window (either can be zero for no bound)
+	After  time.Time `json:"after"`
+	Before time.Time `json:"before"`
+	Limit  int       `json:"limit"`
 }
 
 type GetBotsQuery struct {
@@ -36,6 +72,13 @@ type ListToolsQuery struct {
 	OwnerType types.OwnerType `json:"owner_type"`
 }
 
+type GetIdempotencyKeyQuery struct {
+	Key       string          `json:"key"`
+	Owner     string          `json:"owner"`
+	OwnerType types.OwnerType `json:"owner_type"`
+	Scope     string          `json:"scope"`
+}
+
 //go:generate mockgen -source $GOFILE -destination store_mocks.go -package $GOPACKAGE
 
 type Store interface {
@@ -47,6 +90,16 @@ type Store interface {
 	UpdateSession(ctx context.Context, session types.Session) (*types.Session, error)
 	UpdateSessionMeta(ctx context.Context, data types.SessionMetaUpdate) (*types.Session, error)
 	DeleteSession(ctx context.Context, id string) (*types.Session, error)
+	// the sessions with an interaction that was run by the given runner
+	ListRunnerSessions(ctx context.Context, query ListRunnerSessionsQuery) ([]*types.Session, error)
+	// the sessions that were created as part of the given eval run
+	ListEvalRunSessions(ctx context.Context, evalRunID string) ([]*types.Session, error)
+	// the sessions with an interaction the user has given feedback on
+	ListFeedbackSessions(ctx context.Context, query ListFeedbackSessionsQuery) ([]*types.Session, error)
+	// replace the tags of a session, nothing else about it is changed
+	UpdateSessionTags(ctx context.Context, id string, tags []string) (*types.Session, error)
+	// every tag used on the owner's sessions
+	ListSessionTags(ctx context.Context, query OwnerQuery) ([]string, error)
 
 	// bots
 	GetBot(ctx context.Context, id string) (*types.Bot, error)
@@ -62,9 +115,9 @@ type Store interface {
 	EnsureUserMeta(ctx context.Context, UserMeta types.UserMeta) (*types.UserMeta, error)
 
 	// api keys
-	CreateAPIKey(ctx context.Context, owner OwnerQuery, name string) (string, error)
+	CreateAPIKey(ctx context.Context, owner OwnerQuery, name string) (*types.ApiKey, error)
 	GetAPIKeys(ctx context.Context, query OwnerQuery) ([]*types.ApiKey, error)
-	DeleteAPIKey(ctx context.Context, apiKey types.ApiKey) error
+	DeleteAPIKey(ctx context.Context, owner OwnerQuery, name string) error
 	CheckAPIKey(ctx context.Context, apiKey string) (*types.ApiKey, error)
 
 	CreateTool(ctx context.Context, tool *types.Tool) (*types.Tool, error)
@@ -73,12 +126,38 @@ type Store interface {
 	ListTools(ctx context.Context, q *ListToolsQuery) ([]*types.Tool, error)
 	DeleteTool(ctx context.Context, id string) error
 
+	// tool usage
+	IncrementToolUsage(ctx context.Context, toolID, action string, used time.Time) error
+	ListToolUsage(ctx context.Context, toolIDs []string) ([]*types.ToolActionUsage, error)
+
 	CreateSessionToolBinding(ctx context.Context, sessionID, toolID string) error
 	ListSessionTools(ctx context.Context, sessionID string) ([]*types.Tool, error)
 	DeleteSessionToolBinding(ctx context.Context, sessionID, toolID string) error
+	CountToolSessions(ctx context.Context, toolID string) (int64, error)
+
+	// share links
+	CreateSessionShareLink(ctx context.Context, link *types.SessionShareLink) (*types.SessionShareLink, error)
+	GetSessionShareLink(ctx context.Context, id string) (*types.SessionShareLink, error)
+	ListSessionShareLinks(ctx context.Context, sessionID string) ([]*types.SessionShareLink, error)
+	DeleteSessionShareLink(ctx context.Context, id string) error
+
+	// idempotency keys
+	GetIdempotencyKey(ctx context.Context, q *GetIdempotencyKeyQuery) (*types.IdempotencyKey, error)
+	CreateIdempotencyKey(ctx context.Context, key *types.IdempotencyKey) (*types.IdempotencyKey, error)
+	UpdateIdempotencyKey(ctx context.Context, key *types.IdempotencyKey) (*types.IdempotencyKey, error)
+	DeleteIdempotencyKey(ctx context.Context, key *types.IdempotencyKey) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) error
+
+	// model instance job history
+	UpsertModelInstanceJob(ctx context.Context, job *types.ModelInstanceJob) error
+	ListModelInstanceJobs(ctx context.Context, query ListModelInstanceJobsQuery) ([]*types.ModelInstanceJob, error)
+
+	// check we can still reach the database
+	Ping(ctx context.Context) error
 }
 
 var ErrNotFound = errors.New("not found")
+var ErrAlreadyExists = errors.New("already exists")
 
 type StoreOptions struct {
 	Host        string
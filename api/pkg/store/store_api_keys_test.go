@@ -0,0 +1,50 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func (suite *PostgresStoreTestSuite) Test_APIKeys() {
+	owner := OwnerQuery{Owner: "test-" + system.GenerateUUID(), OwnerType: types.OwnerTypeUser}
+
+	created, err := suite.db.CreateAPIKey(suite.ctx, owner, "ci")
+	suite.Require().NoError(err)
+	suite.True(strings.HasPrefix(created.Key, types.API_KEY_PREIX))
+	suite.False(created.Created.IsZero())
+
+	_, err = suite.db.CreateAPIKey(suite.ctx, owner, "ci")
+	suite.ErrorIs(err, ErrAlreadyExists)
+
+	// only the hash is stored
+	var stored string
+	err = suite.db.pgDb.QueryRow(`select key_hash from api_key where owner = $1`, owner.Owner).Scan(&stored)
+	suite.Require().NoError(err)
+	suite.Equal(hashAPIKey(created.Key), stored)
+
+	checked, err := suite.db.CheckAPIKey(suite.ctx, created.Key)
+	suite.Require().NoError(err)
+	suite.Require().NotNil(checked)
+	suite.Equal(owner.Owner, checked.Owner)
+	suite.Equal("ci", checked.Name)
+	suite.Empty(checked.Key)
+
+	keys, err := suite.db.GetAPIKeys(suite.ctx, owner)
+	suite.Require().NoError(err)
+	suite.Require().Len(keys, 1)
+	suite.Equal("ci", keys[0].Name)
+	suite.Empty(keys[0].Key)
+
+	// someone else can't revoke it
+	err = suite.db.DeleteAPIKey(suite.ctx, OwnerQuery{Owner: "someone-else", OwnerType: types.OwnerTypeUser}, "ci")
+	suite.ErrorIs(err, ErrNotFound)
+
+	err = suite.db.DeleteAPIKey(suite.ctx, owner, "ci")
+	suite.NoError(err)
+
+	checked, err = suite.db.CheckAPIKey(suite.ctx, created.Key)
+	suite.NoError(err)
+	suite.Nil(checked)
+}
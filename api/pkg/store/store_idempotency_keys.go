@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/helixml/helix/api/pkg/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// expired keys are treated as if they don't exist
+func (s *PostgresStore) GetIdempotencyKey(ctx context.Context, q *GetIdempotencyKeyQuery) (*types.IdempotencyKey, error) {
+	var key types.IdempotencyKey
+	err := s.gdb.WithContext(ctx).
+		Where("key = ? AND owner = ? AND owner_type = ? AND scope = ?", q.Key, q.Owner, q.OwnerType, q.Scope).
+		Where("expires > ?", time.Now()).
+		First(&key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// reserves the key - an expired key that is still there is replaced but if
+// the key is live we return ErrAlreadyExists so two requests racing with the
+// same key can't both go ahead
+func (s *PostgresStore) CreateIdempotencyKey(ctx context.Context, key *types.IdempotencyKey) (*types.IdempotencyKey, error) {
+	if key.Key == "" {
+		return nil, fmt.Errorf("key not specified")
+	}
+
+	if key.Owner == "" {
+		return nil, fmt.Errorf("owner not specified")
+	}
+
+	if key.Expires.IsZero() {
+		return nil, fmt.Errorf("expiry not specified")
+	}
+
+	key.Created = time.Now()
+
+	result := s.gdb.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}, {Name: "owner"}, {Name: "owner_type"}, {Name: "scope"}},
+		DoUpdates: clause.AssignmentColumns([]string{"request_hash", "resource_id", "created", "expires"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "idempotency_keys.expires <= ?", Vars: []interface{}{key.Created}},
+		}},
+	}).Create(key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrAlreadyExists
+	}
+	return key, nil
+}
+
+// records what the request that reserved the key created
+func (s *PostgresStore) UpdateIdempotencyKey(ctx context.Context, key *types.IdempotencyKey) (*types.IdempotencyKey, error) {
+	err := s.gdb.WithContext(ctx).Model(&types.IdempotencyKey{}).
+		Where("key = ? AND owner = ? AND owner_type = ? AND scope = ?", key.Key, key.Owner, key.OwnerType, key.Scope).
+		Update("resource_id", key.ResourceID).Error
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *PostgresStore) DeleteIdempotencyKey(ctx context.Context, key *types.IdempotencyKey) error {
+	return s.gdb.WithContext(ctx).
+		Where("key = ? AND owner = ? AND owner_type = ? AND scope = ?", key.Key, key.Owner, key.OwnerType, key.Scope).
+		Delete(&types.IdempotencyKey{}).Error
+}
+
+func (s *PostgresStore) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) error {
+	return s.gdb.WithContext(ctx).Where("expires <= ?", now).Delete(&types.IdempotencyKey{}).Error
+}
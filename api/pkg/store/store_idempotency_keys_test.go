@@ -0,0 +1,59 @@
+package store
+
+import (
+	"time"
+
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func (suite *PostgresStoreTestSuite) Test_IdempotencyKeys() {
+	newKey := func(expires time.Time) *types.IdempotencyKey {
+		return &types.IdempotencyKey{
+			Key:         "key_1",
+			Owner:       "test-" + system.GenerateUUID(),
+			OwnerType:   types.OwnerTypeUser,
+			Scope:       types.IdempotencyKeyScopeCreateTool,
+			RequestHash: "hash",
+			Expires:     expires,
+		}
+	}
+
+	key := newKey(time.Now().Add(time.Hour))
+	_, err := suite.db.CreateIdempotencyKey(suite.ctx, key)
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() {
+		suite.NoError(suite.db.DeleteIdempotencyKey(suite.ctx, key))
+	})
+
+	// a live key can't be reserved twice
+	again := *key
+	_, err = suite.db.CreateIdempotencyKey(suite.ctx, &again)
+	suite.ErrorIs(err, ErrAlreadyExists)
+
+	key.ResourceID = "tool_1"
+	_, err = suite.db.UpdateIdempotencyKey(suite.ctx, key)
+	suite.Require().NoError(err)
+
+	query := &GetIdempotencyKeyQuery{Key: key.Key, Owner: key.Owner, OwnerType: key.OwnerType, Scope: key.Scope}
+	found, err := suite.db.GetIdempotencyKey(suite.ctx, query)
+	suite.Require().NoError(err)
+	suite.Equal("tool_1", found.ResourceID)
+
+	suite.NoError(suite.db.DeleteIdempotencyKey(suite.ctx, key))
+	_, err = suite.db.GetIdempotencyKey(suite.ctx, query)
+	suite.ErrorIs(err, ErrNotFound)
+
+	// an expired key is replaced
+	expired := newKey(time.Now().Add(-time.Hour))
+	_, err = suite.db.CreateIdempotencyKey(suite.ctx, expired)
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() {
+		suite.NoError(suite.db.DeleteIdempotencyKey(suite.ctx, expired))
+	})
+
+	replacement := *expired
+	replacement.Expires = time.Now().Add(time.Hour)
+	_, err = suite.db.CreateIdempotencyKey(suite.ctx, &replacement)
+	suite.NoError(err)
+}
@@ -7,6 +7,7 @@ package store
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	types "github.com/helixml/helix/api/pkg/types"
@@ -50,11 +51,26 @@ func (mr *MockStoreMockRecorder) CheckAPIKey(ctx, apiKey interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckAPIKey", reflect.TypeOf((*MockStore)(nil).CheckAPIKey), ctx, apiKey)
 }
 
+// CountToolSessions mocks base method.
+func (m *MockStore) CountToolSessions(ctx context.Context, toolID string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountToolSessions", ctx, toolID)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountToolSessions indicates an expected call of CountToolSessions.
+func (mr *MockStoreMockRecorder) CountToolSessions(ctx, toolID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountToolSessions", reflect.TypeOf((*MockStore)(nil).CountToolSessions), ctx, toolID)
+}
+
 // CreateAPIKey mocks base method.
-func (m *MockStore) CreateAPIKey(ctx context.Context, owner OwnerQuery, name string) (string, error) {
+func (m *MockStore) CreateAPIKey(ctx context.Context, owner OwnerQuery, name string) (*types.ApiKey, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "CreateAPIKey", ctx, owner, name)
-	ret0, _ := ret[0].(string)
+	ret0, _ := ret[0].(*types.ApiKey)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -80,6 +96,21 @@ func (mr *MockStoreMockRecorder) CreateBot(ctx, Bot interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBot", reflect.TypeOf((*MockStore)(nil).CreateBot), ctx, Bot)
 }
 
+// CreateIdempotencyKey mocks base method.
+func (m *MockStore) CreateIdempotencyKey(ctx context.Context, key *types.IdempotencyKey) (*types.IdempotencyKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateIdempotencyKey", ctx, key)
+	ret0, _ := ret[0].(*types.IdempotencyKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateIdempotencyKey indicates an expected call of CreateIdempotencyKey.
+func (mr *MockStoreMockRecorder) CreateIdempotencyKey(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateIdempotencyKey", reflect.TypeOf((*MockStore)(nil).CreateIdempotencyKey), ctx, key)
+}
+
 // CreateSession mocks base method.
 func (m *MockStore) CreateSession(ctx context.Context, session types.Session) (*types.Session, error) {
 	m.ctrl.T.Helper()
@@ -95,6 +126,21 @@ func (mr *MockStoreMockRecorder) CreateSession(ctx, session interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSession", reflect.TypeOf((*MockStore)(nil).CreateSession), ctx, session)
 }
 
+// CreateSessionShareLink mocks base method.
+func (m *MockStore) CreateSessionShareLink(ctx context.Context, link *types.SessionShareLink) (*types.SessionShareLink, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSessionShareLink", ctx, link)
+	ret0, _ := ret[0].(*types.SessionShareLink)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateSessionShareLink indicates an expected call of CreateSessionShareLink.
+func (mr *MockStoreMockRecorder) CreateSessionShareLink(ctx, link interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSessionShareLink", reflect.TypeOf((*MockStore)(nil).CreateSessionShareLink), ctx, link)
+}
+
 // CreateSessionToolBinding mocks base method.
 func (m *MockStore) CreateSessionToolBinding(ctx context.Context, sessionID, toolID string) error {
 	m.ctrl.T.Helper()
@@ -140,17 +186,17 @@ func (mr *MockStoreMockRecorder) CreateUserMeta(ctx, UserMeta interface{}) *gomo
 }
 
 // DeleteAPIKey mocks base method.
-func (m *MockStore) DeleteAPIKey(ctx context.Context, apiKey types.ApiKey) error {
+func (m *MockStore) DeleteAPIKey(ctx context.Context, owner OwnerQuery, name string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteAPIKey", ctx, apiKey)
+	ret := m.ctrl.Call(m, "DeleteAPIKey", ctx, owner, name)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteAPIKey indicates an expected call of DeleteAPIKey.
-func (mr *MockStoreMockRecorder) DeleteAPIKey(ctx, apiKey interface{}) *gomock.Call {
+func (mr *MockStoreMockRecorder) DeleteAPIKey(ctx, owner, name interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAPIKey", reflect.TypeOf((*MockStore)(nil).DeleteAPIKey), ctx, apiKey)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAPIKey", reflect.TypeOf((*MockStore)(nil).DeleteAPIKey), ctx, owner, name)
 }
 
 // DeleteBot mocks base method.
@@ -168,6 +214,34 @@ func (mr *MockStoreMockRecorder) DeleteBot(ctx, id interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBot", reflect.TypeOf((*MockStore)(nil).DeleteBot), ctx, id)
 }
 
+// DeleteIdempotencyKey mocks base method.
+func (m *MockStore) DeleteIdempotencyKey(ctx context.Context, key *types.IdempotencyKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteIdempotencyKey", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteIdempotencyKey indicates an expected call of DeleteIdempotencyKey.
+func (mr *MockStoreMockRecorder) DeleteIdempotencyKey(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteIdempotencyKey", reflect.TypeOf((*MockStore)(nil).DeleteIdempotencyKey), ctx, key)
+}
+
+// DeleteExpiredIdempotencyKeys mocks base method.
+func (m *MockStore) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredIdempotencyKeys", ctx, now)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteExpiredIdempotencyKeys indicates an expected call of DeleteExpiredIdempotencyKeys.
+func (mr *MockStoreMockRecorder) DeleteExpiredIdempotencyKeys(ctx, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredIdempotencyKeys", reflect.TypeOf((*MockStore)(nil).DeleteExpiredIdempotencyKeys), ctx, now)
+}
+
 // DeleteSession mocks base method.
 func (m *MockStore) DeleteSession(ctx context.Context, id string) (*types.Session, error) {
 	m.ctrl.T.Helper()
@@ -183,6 +257,20 @@ func (mr *MockStoreMockRecorder) DeleteSession(ctx, id interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSession", reflect.TypeOf((*MockStore)(nil).DeleteSession), ctx, id)
 }
 
+// DeleteSessionShareLink mocks base method.
+func (m *MockStore) DeleteSessionShareLink(ctx context.Context, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteSessionShareLink", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteSessionShareLink indicates an expected call of DeleteSessionShareLink.
+func (mr *MockStoreMockRecorder) DeleteSessionShareLink(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSessionShareLink", reflect.TypeOf((*MockStore)(nil).DeleteSessionShareLink), ctx, id)
+}
+
 // DeleteSessionToolBinding mocks base method.
 func (m *MockStore) DeleteSessionToolBinding(ctx context.Context, sessionID, toolID string) error {
 	m.ctrl.T.Helper()
@@ -271,6 +359,21 @@ func (mr *MockStoreMockRecorder) GetBots(ctx, query interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBots", reflect.TypeOf((*MockStore)(nil).GetBots), ctx, query)
 }
 
+// GetIdempotencyKey mocks base method.
+func (m *MockStore) GetIdempotencyKey(ctx context.Context, q *GetIdempotencyKeyQuery) (*types.IdempotencyKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetIdempotencyKey", ctx, q)
+	ret0, _ := ret[0].(*types.IdempotencyKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIdempotencyKey indicates an expected call of GetIdempotencyKey.
+func (mr *MockStoreMockRecorder) GetIdempotencyKey(ctx, q interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIdempotencyKey", reflect.TypeOf((*MockStore)(nil).GetIdempotencyKey), ctx, q)
+}
+
 // GetSession mocks base method.
 func (m *MockStore) GetSession(ctx context.Context, id string) (*types.Session, error) {
 	m.ctrl.T.Helper()
@@ -286,6 +389,21 @@ func (mr *MockStoreMockRecorder) GetSession(ctx, id interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSession", reflect.TypeOf((*MockStore)(nil).GetSession), ctx, id)
 }
 
+// GetSessionShareLink mocks base method.
+func (m *MockStore) GetSessionShareLink(ctx context.Context, id string) (*types.SessionShareLink, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSessionShareLink", ctx, id)
+	ret0, _ := ret[0].(*types.SessionShareLink)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSessionShareLink indicates an expected call of GetSessionShareLink.
+func (mr *MockStoreMockRecorder) GetSessionShareLink(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSessionShareLink", reflect.TypeOf((*MockStore)(nil).GetSessionShareLink), ctx, id)
+}
+
 // GetSessions mocks base method.
 func (m *MockStore) GetSessions(ctx context.Context, query GetSessionsQuery) ([]*types.Session, error) {
 	m.ctrl.T.Helper()
@@ -346,6 +464,65 @@ func (mr *MockStoreMockRecorder) GetUserMeta(ctx, id interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserMeta", reflect.TypeOf((*MockStore)(nil).GetUserMeta), ctx, id)
 }
 
+// IncrementToolUsage mocks base method.
+func (m *MockStore) IncrementToolUsage(ctx context.Context, toolID, action string, used time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IncrementToolUsage", ctx, toolID, action, used)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// IncrementToolUsage indicates an expected call of IncrementToolUsage.
+func (mr *MockStoreMockRecorder) IncrementToolUsage(ctx, toolID, action, used interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrementToolUsage", reflect.TypeOf((*MockStore)(nil).IncrementToolUsage), ctx, toolID, action, used)
+}
+
+// ListModelInstanceJobs mocks base method.
+func (m *MockStore) ListModelInstanceJobs(ctx context.Context, query ListModelInstanceJobsQuery) ([]*types.ModelInstanceJob, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListModelInstanceJobs", ctx, query)
+	ret0, _ := ret[0].([]*types.ModelInstanceJob)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListModelInstanceJobs indicates an expected call of ListModelInstanceJobs.
+func (mr *MockStoreMockRecorder) ListModelInstanceJobs(ctx, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListModelInstanceJobs", reflect.TypeOf((*MockStore)(nil).ListModelInstanceJobs), ctx, query)
+}
+
+// ListSessionShareLinks mocks base method.
+func (m *MockStore) ListSessionShareLinks(ctx context.Context, sessionID string) ([]*types.SessionShareLink, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSessionShareLinks", ctx, sessionID)
+	ret0, _ := ret[0].([]*types.SessionShareLink)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSessionShareLinks indicates an expected call of ListSessionShareLinks.
+func (mr *MockStoreMockRecorder) ListSessionShareLinks(ctx, sessionID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSessionShareLinks", reflect.TypeOf((*MockStore)(nil).ListSessionShareLinks), ctx, sessionID)
+}
+
+// ListSessionTags mocks base method.
+func (m *MockStore) ListSessionTags(ctx context.Context, query OwnerQuery) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSessionTags", ctx, query)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSessionTags indicates an expected call of ListSessionTags.
+func (mr *MockStoreMockRecorder) ListSessionTags(ctx, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSessionTags", reflect.TypeOf((*MockStore)(nil).ListSessionTags), ctx, query)
+}
+
 // ListSessionTools mocks base method.
 func (m *MockStore) ListSessionTools(ctx context.Context, sessionID string) ([]*types.Tool, error) {
 	m.ctrl.T.Helper()
@@ -361,6 +538,21 @@ func (mr *MockStoreMockRecorder) ListSessionTools(ctx, sessionID interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSessionTools", reflect.TypeOf((*MockStore)(nil).ListSessionTools), ctx, sessionID)
 }
 
+// ListToolUsage mocks base method.
+func (m *MockStore) ListToolUsage(ctx context.Context, toolIDs []string) ([]*types.ToolActionUsage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListToolUsage", ctx, toolIDs)
+	ret0, _ := ret[0].([]*types.ToolActionUsage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListToolUsage indicates an expected call of ListToolUsage.
+func (mr *MockStoreMockRecorder) ListToolUsage(ctx, toolIDs interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListToolUsage", reflect.TypeOf((*MockStore)(nil).ListToolUsage), ctx, toolIDs)
+}
+
 // ListTools mocks base method.
 func (m *MockStore) ListTools(ctx context.Context, q *ListToolsQuery) ([]*types.Tool, error) {
 	m.ctrl.T.Helper()
@@ -376,6 +568,65 @@ func (mr *MockStoreMockRecorder) ListTools(ctx, q interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTools", reflect.TypeOf((*MockStore)(nil).ListTools), ctx, q)
 }
 
+// ListEvalRunSessions mocks base method.
+func (m *MockStore) ListEvalRunSessions(ctx context.Context, evalRunID string) ([]*types.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListEvalRunSessions", ctx, evalRunID)
+	ret0, _ := ret[0].([]*types.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListEvalRunSessions indicates an expected call of ListEvalRunSessions.
+func (mr *MockStoreMockRecorder) ListEvalRunSessions(ctx, evalRunID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListEvalRunSessions", reflect.TypeOf((*MockStore)(nil).ListEvalRunSessions), ctx, evalRunID)
+}
+
+// ListFeedbackSessions mocks base method.
+func (m *MockStore) ListFeedbackSessions(ctx context.Context, query ListFeedbackSessionsQuery) ([]*types.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListFeedbackSessions", ctx, query)
+	ret0, _ := ret[0].([]*types.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListFeedbackSessions indicates an expected call of ListFeedbackSessions.
+func (mr *MockStoreMockRecorder) ListFeedbackSessions(ctx, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListFeedbackSessions", reflect.TypeOf((*MockStore)(nil).ListFeedbackSessions), ctx, query)
+}
+
+// ListRunnerSessions mocks base method.
+func (m *MockStore) ListRunnerSessions(ctx context.Context, query ListRunnerSessionsQuery) ([]*types.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRunnerSessions", ctx, query)
+	ret0, _ := ret[0].([]*types.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListRunnerSessions indicates an expected call of ListRunnerSessions.
+func (mr *MockStoreMockRecorder) ListRunnerSessions(ctx, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRunnerSessions", reflect.TypeOf((*MockStore)(nil).ListRunnerSessions), ctx, query)
+}
+
+// Ping mocks base method.
+func (m *MockStore) Ping(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping.
+func (mr *MockStoreMockRecorder) Ping(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockStore)(nil).Ping), ctx)
+}
+
 // UpdateBot mocks base method.
 func (m *MockStore) UpdateBot(ctx context.Context, Bot types.Bot) (*types.Bot, error) {
 	m.ctrl.T.Helper()
@@ -391,6 +642,21 @@ func (mr *MockStoreMockRecorder) UpdateBot(ctx, Bot interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBot", reflect.TypeOf((*MockStore)(nil).UpdateBot), ctx, Bot)
 }
 
+// UpdateIdempotencyKey mocks base method.
+func (m *MockStore) UpdateIdempotencyKey(ctx context.Context, key *types.IdempotencyKey) (*types.IdempotencyKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateIdempotencyKey", ctx, key)
+	ret0, _ := ret[0].(*types.IdempotencyKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateIdempotencyKey indicates an expected call of UpdateIdempotencyKey.
+func (mr *MockStoreMockRecorder) UpdateIdempotencyKey(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateIdempotencyKey", reflect.TypeOf((*MockStore)(nil).UpdateIdempotencyKey), ctx, key)
+}
+
 // UpdateSession mocks base method.
 func (m *MockStore) UpdateSession(ctx context.Context, session types.Session) (*types.Session, error) {
 	m.ctrl.T.Helper()
@@ -421,6 +687,21 @@ func (mr *MockStoreMockRecorder) UpdateSessionMeta(ctx, data interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateSessionMeta", reflect.TypeOf((*MockStore)(nil).UpdateSessionMeta), ctx, data)
 }
 
+// UpdateSessionTags mocks base method.
+func (m *MockStore) UpdateSessionTags(ctx context.Context, id string, tags []string) (*types.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateSessionTags", ctx, id, tags)
+	ret0, _ := ret[0].(*types.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateSessionTags indicates an expected call of UpdateSessionTags.
+func (mr *MockStoreMockRecorder) UpdateSessionTags(ctx, id, tags interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateSessionTags", reflect.TypeOf((*MockStore)(nil).UpdateSessionTags), ctx, id, tags)
+}
+
 // UpdateTool mocks base method.
 func (m *MockStore) UpdateTool(ctx context.Context, tool *types.Tool) (*types.Tool, error) {
 	m.ctrl.T.Helper()
@@ -450,3 +731,17 @@ func (mr *MockStoreMockRecorder) UpdateUserMeta(ctx, UserMeta interface{}) *gomo
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserMeta", reflect.TypeOf((*MockStore)(nil).UpdateUserMeta), ctx, UserMeta)
 }
+
+// UpsertModelInstanceJob mocks base method.
+func (m *MockStore) UpsertModelInstanceJob(ctx context.Context, job *types.ModelInstanceJob) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertModelInstanceJob", ctx, job)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpsertModelInstanceJob indicates an expected call of UpsertModelInstanceJob.
+func (mr *MockStoreMockRecorder) UpsertModelInstanceJob(ctx, job interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertModelInstanceJob", reflect.TypeOf((*MockStore)(nil).UpsertModelInstanceJob), ctx, job)
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/helixml/helix/api/pkg/types"
+	"gorm.io/gorm/clause"
+)
+
+// create the job or update it with what the runner has told us since, the
+// runner keeps reporting a job for as long as it is in its history
+func (s *PostgresStore) UpsertModelInstanceJob(ctx context.Context, job *types.ModelInstanceJob) error {
+	if job.RunnerID == "" {
+		return fmt.Errorf("runner id not specified")
+	}
+
+	if job.ModelInstanceID == "" {
+		return fmt.Errorf("model instance id not specified")
+	}
+
+	if job.InteractionID == "" {
+		return fmt.Errorf("interaction id not specified")
+	}
+
+	return s.gdb.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "runner_id"}, {Name: "model_instance_id"}, {Name: "interaction_id"}},
+		UpdateAll: true,
+	}).Create(job).Error
+}
+
+// the jobs a runner has run, newest first
+func (s *PostgresStore) ListModelInstanceJobs(ctx context.Context, query ListModelInstanceJobsQuery) ([]*types.ModelInstanceJob, error) {
+	if query.RunnerID == "" {
+		return nil, fmt.Errorf("runner id cannot be empty")
+	}
+
+	q := s.gdb.WithContext(ctx).Where("runner_id = ?", query.RunnerID)
+
+	if query.ModelInstanceID != "" {
+		q = q.Where("model_instance_id = ?", query.ModelInstanceID)
+	}
+	if !query.After.IsZero() {
+		q = q.Where("started >= ?", query.After)
+	}
+	if !query.Before.IsZero() {
+		q = q.Where("started <= ?", query.Before)
+	}
+
+	q = q.Order("started DESC")
+
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+
+	jobs := []*types.ModelInstanceJob{}
+	err := q.Find(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/helixml/helix/api/pkg/types"
+	"gorm.io/gorm"
+)
+
+func (s *PostgresStore) CreateSessionShareLink(ctx context.Context, link *types.SessionShareLink) (*types.SessionShareLink, error) {
+	if link.ID == "" {
+		return nil, fmt.Errorf("id not specified")
+	}
+
+	if link.SessionID == "" {
+		return nil, fmt.Errorf("session id not specified")
+	}
+
+	if link.Expires.IsZero() {
+		return nil, fmt.Errorf("expiry not specified")
+	}
+
+	link.Created = time.Now()
+
+	err := s.gdb.WithContext(ctx).Create(link).Error
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (s *PostgresStore) GetSessionShareLink(ctx context.Context, id string) (*types.SessionShareLink, error) {
+	var link types.SessionShareLink
+	err := s.gdb.WithContext(ctx).Where("id = ?", id).First(&link).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &link, nil
+}
+
+// newest first, expired links are included until they are revoked
+func (s *PostgresStore) ListSessionShareLinks(ctx context.Context, sessionID string) ([]*types.SessionShareLink, error) {
+	var links []*types.SessionShareLink
+	err := s.gdb.WithContext(ctx).
+		Where("session_id = ?", sessionID).
+		Order("created DESC").
+		Find(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (s *PostgresStore) DeleteSessionShareLink(ctx context.Context, id string) error {
+	return s.gdb.WithContext(ctx).Delete(&types.SessionShareLink{ID: id}).Error
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"time"
+
+	"github.com/helixml/helix/api/pkg/system"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func (suite *PostgresStoreTestSuite) Test_SessionShareLinks() {
+	sessionID := "session-test" + system.GenerateUUID()
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	first, err := suite.db.CreateSessionShareLink(suite.ctx, &types.SessionShareLink{
+		ID:        system.GenerateUUID(),
+		SessionID: sessionID,
+		Owner:     "owner",
+		OwnerType: types.OwnerTypeUser,
+		Expires:   expires,
+	})
+	suite.NoError(err)
+
+	second, err := suite.db.CreateSessionShareLink(suite.ctx, &types.SessionShareLink{
+		ID:        system.GenerateUUID(),
+		SessionID: sessionID,
+		Owner:     "owner",
+		OwnerType: types.OwnerTypeUser,
+		Expires:   expires,
+	})
+	suite.NoError(err)
+
+	link, err := suite.db.GetSessionShareLink(suite.ctx, first.ID)
+	suite.NoError(err)
+	suite.Equal(sessionID, link.SessionID)
+	suite.Equal(expires.Unix(), link.Expires.Unix())
+
+	links, err := suite.db.ListSessionShareLinks(suite.ctx, sessionID)
+	suite.NoError(err)
+	suite.Require().Len(links, 2)
+	suite.Equal(second.ID, links[0].ID)
+
+	err = suite.db.DeleteSessionShareLink(suite.ctx, first.ID)
+	suite.NoError(err)
+
+	_, err = suite.db.GetSessionShareLink(suite.ctx, first.ID)
+	suite.ErrorIs(err, ErrNotFound)
+
+	suite.T().Cleanup(func() {
+		_ = suite.db.DeleteSessionShareLink(suite.ctx, second.ID)
+	})
+}
+
+func (suite *PostgresStoreTestSuite) Test_DeleteSession_RemovesShareLinks() {
+	session, err := suite.db.CreateSession(suite.ctx, types.Session{
+		ID:    system.GenerateSessionID(),
+		Owner: "test-" + system.GenerateUUID(),
+	})
+	suite.NoError(err)
+
+	link, err := suite.db.CreateSessionShareLink(suite.ctx, &types.SessionShareLink{
+		ID:        system.GenerateUUID(),
+		SessionID: session.ID,
+		Owner:     session.Owner,
+		Expires:   time.Now().Add(time.Hour),
+	})
+	suite.NoError(err)
+
+	_, err = suite.db.DeleteSession(suite.ctx, session.ID)
+	suite.NoError(err)
+
+	_, err = suite.db.GetSessionShareLink(suite.ctx, link.ID)
+	suite.ErrorIs(err, ErrNotFound)
+}
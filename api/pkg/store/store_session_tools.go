@@ -2,9 +2,11 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/helixml/helix/api/pkg/types"
+	"gorm.io/gorm/clause"
 )
 
 func (s *PostgresStore) CreateSessionToolBinding(ctx context.Context, sessionID, toolID string) error {
@@ -16,7 +18,8 @@ func (s *PostgresStore) CreateSessionToolBinding(ctx context.Context, sessionID,
 		return fmt.Errorf("tool id not specified")
 	}
 
-	err := s.gdb.WithContext(ctx).Create(&types.SessionToolBinding{
+	// binding a tool that's already bound is a no-op
+	err := s.gdb.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&types.SessionToolBinding{
 		SessionID: sessionID,
 		ToolID:    toolID,
 	}).Error
@@ -52,3 +55,25 @@ func (s *PostgresStore) DeleteSessionToolBinding(ctx context.Context, sessionID,
 
 	return nil
 }
+
+// how many sessions the tool is bound to that are still going - a session
+// is still going while any of its interactions is unfinished, finished ones
+// won't call the tool again
+func (s *PostgresStore) CountToolSessions(ctx context.Context, toolID string) (int64, error) {
+	unfinishedInteraction, err := json.Marshal([]map[string]bool{{"finished": false}})
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err = s.gdb.WithContext(ctx).Model(&types.SessionToolBinding{}).
+		Joins("JOIN sessions ON sessions.id = session_tool_bindings.session_id").
+		Where("session_tool_bindings.tool_id = ?", toolID).
+		Where("sessions.interactions::jsonb @> ?::jsonb", string(unfinishedInteraction)).
+		Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
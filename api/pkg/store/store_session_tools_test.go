@@ -49,3 +49,88 @@ func (suite *PostgresStoreTestSuite) Test_ListSessionTools() {
 		suite.NoError(err)
 	})
 }
+
+func (suite *PostgresStoreTestSuite) Test_DeleteSession_RemovesToolBindings() {
+	ownerID := "test-" + system.GenerateUUID()
+
+	createdTool, err := suite.db.CreateTool(suite.ctx, &types.Tool{
+		Name:      "test",
+		Owner:     ownerID,
+		OwnerType: types.OwnerTypeUser,
+		Config: types.ToolConfig{
+			API: &types.ToolApiConfig{
+				URL:    "http://test.com",
+				Schema: "123",
+			},
+		},
+	})
+	suite.NoError(err)
+
+	suite.T().Cleanup(func() {
+		err := suite.db.DeleteTool(suite.ctx, createdTool.ID)
+		suite.NoError(err)
+	})
+
+	session, err := suite.db.CreateSession(suite.ctx, types.Session{
+		ID:    system.GenerateSessionID(),
+		Owner: ownerID,
+	})
+	suite.NoError(err)
+
+	err = suite.db.CreateSessionToolBinding(suite.ctx, session.ID, createdTool.ID)
+	suite.NoError(err)
+
+	_, err = suite.db.DeleteSession(suite.ctx, session.ID)
+	suite.NoError(err)
+
+	tools, err := suite.db.ListSessionTools(suite.ctx, session.ID)
+	suite.NoError(err)
+	suite.Empty(tools)
+}
+
+func (suite *PostgresStoreTestSuite) Test_CountToolSessions_OnlyUnfinished() {
+	ownerID := "test-" + system.GenerateUUID()
+
+	createdTool, err := suite.db.CreateTool(suite.ctx, &types.Tool{
+		Name:      "test",
+		Owner:     ownerID,
+		OwnerType: types.OwnerTypeUser,
+		Config: types.ToolConfig{
+			API: &types.ToolApiConfig{
+				URL:    "http://test.com",
+				Schema: "123",
+			},
+		},
+	})
+	suite.NoError(err)
+
+	suite.T().Cleanup(func() {
+		err := suite.db.DeleteTool(suite.ctx, createdTool.ID)
+		suite.NoError(err)
+	})
+
+	for _, finished := range []bool{true, false} {
+		session, err := suite.db.CreateSession(suite.ctx, types.Session{
+			ID:    system.GenerateSessionID(),
+			Owner: ownerID,
+			Interactions: []*types.Interaction{
+				{ID: system.GenerateUUID(), Creator: types.CreatorTypeUser, Finished: true},
+				{ID: system.GenerateUUID(), Creator: types.CreatorTypeSystem, Finished: finished},
+			},
+		})
+		suite.NoError(err)
+
+		err = suite.db.CreateSessionToolBinding(suite.ctx, session.ID, createdTool.ID)
+		suite.NoError(err)
+
+		suite.T().Cleanup(func() {
+			_, err := suite.db.DeleteSession(suite.ctx, session.ID)
+			suite.NoError(err)
+		})
+	}
+
+	// bound to both but only one is still going
+	count, err := suite.db.CountToolSessions(suite.ctx, createdTool.ID)
+	suite.NoError(err)
+	suite.Equal(int64(1), count)
+}
@@ -2,8 +2,10 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/helixml/helix/api/pkg/system"
@@ -26,11 +28,66 @@ func getSessionsQuery(query GetSessionsQuery) (*types.Session, []interface{}) {
 	return session, fields
 }
 
+// match the search against the session name or the message of any interaction
+// sessions without interactions have a json null rather than an empty array
+// so we swap that out before expanding it
+func searchSessions(q *gorm.DB, search string) *gorm.DB {
+	if search == "" {
+		return q
+	}
+
+	pattern := "%" + escapeLike(search) + "%"
+
+	return q.Where(`name ILIKE ? OR EXISTS (
+		SELECT 1 FROM jsonb_array_elements(
+			CASE WHEN jsonb_typeof(interactions) = 'array' THEN interactions ELSE '[]'::jsonb END
+		) AS interaction
+		WHERE interaction->>'message' ILIKE ?
+	)`, pattern, pattern)
+}
+
+// containment queries so they can use the index from migration 0012
+func filterSessionsByTags(q *gorm.DB, tags []string, matchAll bool) (*gorm.DB, error) {
+	if len(tags) == 0 {
+		return q, nil
+	}
+
+	if matchAll {
+		all, err := json.Marshal(tags)
+		if err != nil {
+			return nil, err
+		}
+		return q.Where("tags @> ?::jsonb", string(all)), nil
+	}
+
+	conditions := make([]string, 0, len(tags))
+	args := make([]interface{}, 0, len(tags))
+	for _, tag := range tags {
+		one, err := json.Marshal([]string{tag})
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, "tags @> ?::jsonb")
+		args = append(args, string(one))
+	}
+	return q.Where("("+strings.Join(conditions, " OR ")+")", args...), nil
+}
+
+// escape the LIKE wildcards so the search is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
 func (s *PostgresStore) GetSessions(ctx context.Context, query GetSessionsQuery) ([]*types.Session, error) {
 
 	whereQuery, fields := getSessionsQuery(query)
 
 	q := s.gdb.WithContext(ctx).Model(&types.Session{}).Where(whereQuery, fields...)
+	q = searchSessions(q, query.Search)
+	q, err := filterSessionsByTags(q, query.Tags, query.TagsMatchAll)
+	if err != nil {
+		return nil, err
+	}
 
 	q = q.Order("created DESC")
 
@@ -43,7 +100,7 @@ func (s *PostgresStore) GetSessions(ctx context.Context, query GetSessionsQuery)
 	}
 
 	var sessions []*types.Session
-	err := q.Find(&sessions).Error
+	err = q.Find(&sessions).Error
 	if err != nil {
 		return nil, err
 	}
@@ -55,9 +112,14 @@ func (s *PostgresStore) GetSessionsCounter(ctx context.Context, query GetSession
 	whereQuery, fields := getSessionsQuery(query)
 
 	q := s.gdb.WithContext(ctx).Model(&types.Session{}).Where(whereQuery, fields...)
+	q = searchSessions(q, query.Search)
+	q, err := filterSessionsByTags(q, query.Tags, query.TagsMatchAll)
+	if err != nil {
+		return nil, err
+	}
 
 	var counter int64
-	err := q.Count(&counter).Error
+	err = q.Count(&counter).Error
 	if err != nil {
 		return nil, err
 	}
@@ -67,6 +129,128 @@ func (s *PostgresStore) GetSessionsCounter(ctx context.Context, query GetSession
 	}, nil
 }
 
+// the interactions are matched with a containment query which can use the
+// index from migration 0010 - the time window is narrowed down here using
+// the session timestamps and then exactly by the caller using the
+// interaction timestamps
+func (s *PostgresStore) ListRunnerSessions(ctx context.Context, query ListRunnerSessionsQuery) ([]*types.Session, error) {
+	if query.RunnerID == "" {
+		return nil, fmt.Errorf("runner id cannot be empty")
+	}
+
+	runnerInteraction, err := json.Marshal([]map[string]string{{"runner": query.RunnerID}})
+	if err != nil {
+		return nil, err
+	}
+
+	q := s.gdb.WithContext(ctx).Model(&types.Session{}).Where("interactions::jsonb @> ?::jsonb", string(runnerInteraction))
+
+	// an interaction can't be scheduled after the session was last updated
+	// or before it was created
+	if !query.After.IsZero() {
+		q = q.Where("updated >= ?", query.After)
+	}
+	if !query.Before.IsZero() {
+		q = q.Where("created <= ?", query.Before)
+	}
+
+	q = q.Order("updated DESC")
+
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+
+	var sessions []*types.Session
+	err = q.Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// uses the expression index from migration 0011
+func (s *PostgresStore) ListEvalRunSessions(ctx context.Context, evalRunID string) ([]*types.Session, error) {
+	if evalRunID == "" {
+		return nil, fmt.Errorf("eval run id cannot be empty")
+	}
+
+	var sessions []*types.Session
+	err := s.gdb.WithContext(ctx).
+		Model(&types.Session{}).
+		Where("config->>'eval_run_id' = ?", evalRunID).
+		Order("created ASC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (s *PostgresStore) ListFeedbackSessions(ctx context.Context, query ListFeedbackSessionsQuery) ([]*types.Session, error) {
+	upInteraction, err := json.Marshal([]map[string]interface{}{{"feedback": map[string]types.FeedbackRating{"rating": types.FeedbackRatingUp}}})
+	if err != nil {
+		return nil, err
+	}
+	downInteraction, err := json.Marshal([]map[string]interface{}{{"feedback": map[string]types.FeedbackRating{"rating": types.FeedbackRatingDown}}})
+	if err != nil {
+		return nil, err
+	}
+
+	q := s.gdb.WithContext(ctx).Model(&types.Session{}).
+		Where("(interactions::jsonb @> ?::jsonb OR interactions::jsonb @> ?::jsonb)", string(upInteraction), string(downInteraction))
+
+	if query.Owner != "" {
+		q = q.Where("owner = ? AND owner_type = ?", query.Owner, query.OwnerType)
+	}
+	if query.ModelName != "" {
+		q = q.Where("model_name = ?", query.ModelName)
+	}
+	if !query.After.IsZero() {
+		q = q.Where("updated >= ?", query.After)
+	}
+	if !query.Before.IsZero() {
+		q = q.Where("created <= ?", query.Before)
+	}
+
+	var sessions []*types.Session
+	err = q.Order("created ASC").Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// the tags column is read only on the session so this is the only place
+// it gets written
+func (s *PostgresStore) UpdateSessionTags(ctx context.Context, id string, tags []string) (*types.Session, error) {
+	if id == "" {
+		return nil, fmt.Errorf("id not specified")
+	}
+
+	err := s.gdb.WithContext(ctx).Exec("UPDATE session SET tags = ? WHERE id = ?", types.SessionTags(tags), id).Error
+	if err != nil {
+		return nil, err
+	}
+	return s.GetSession(ctx, id)
+}
+
+func (s *PostgresStore) ListSessionTags(ctx context.Context, query OwnerQuery) ([]string, error) {
+	tags := []string{}
+	err := s.gdb.WithContext(ctx).Raw(`
+		SELECT DISTINCT jsonb_array_elements_text(tags) AS tag
+		FROM session
+		WHERE owner = ? AND owner_type = ?
+		ORDER BY tag
+	`, query.Owner, query.OwnerType).Scan(&tags).Error
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
 func (s *PostgresStore) CreateSession(ctx context.Context, session types.Session) (*types.Session, error) {
 	if session.ID == "" {
 		session.ID = system.GenerateSessionID()
@@ -119,9 +303,22 @@ func (s *PostgresStore) DeleteSession(ctx context.Context, sessionID string) (*t
 		return nil, err
 	}
 
-	err = s.gdb.WithContext(ctx).Delete(&types.Session{
-		ID: sessionID,
-	}).Error
+	// the tool bindings and share links are meaningless without the session
+	err = s.gdb.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("session_id = ?", sessionID).Delete(&types.SessionToolBinding{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("session_id = ?", sessionID).Delete(&types.SessionShareLink{}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(&types.Session{
+			ID: sessionID,
+		}).Error
+	})
 	if err != nil {
 		return nil, err
 	}
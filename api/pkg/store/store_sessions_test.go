@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/helixml/helix/api/pkg/system"
@@ -156,3 +157,128 @@ func (suite *PostgresStoreTestSuite) TestPostgresStore_DeleteSession() {
 	// Assert that the deleted session matches the original session
 	suite.Equal(session.ID, deletedSession.ID)
 }
+
+func (suite *PostgresStoreTestSuite) TestPostgresStore_GetSessions_Search() {
+	owner := "user_" + system.GenerateUUID()
+	marker := system.GenerateUUID()
+
+	byName := types.Session{
+		ID:      system.GenerateSessionID(),
+		Name:    "Holiday " + marker + " plans",
+		Owner:   owner,
+		Created: time.Now(),
+		Updated: time.Now(),
+		Interactions: []*types.Interaction{
+			{ID: "id-1", Creator: types.CreatorTypeUser, Message: "hello"},
+		},
+	}
+	byMessage := types.Session{
+		ID:      system.GenerateSessionID(),
+		Name:    "unrelated",
+		Owner:   owner,
+		Created: time.Now(),
+		Updated: time.Now(),
+		Interactions: []*types.Interaction{
+			{ID: "id-1", Creator: types.CreatorTypeUser, Message: "what about " + strings.ToUpper(marker) + "?"},
+		},
+	}
+	otherOwner := types.Session{
+		ID:      system.GenerateSessionID(),
+		Name:    marker,
+		Owner:   "user_" + system.GenerateUUID(),
+		Created: time.Now(),
+		Updated: time.Now(),
+	}
+
+	for _, session := range []types.Session{byName, byMessage, otherOwner} {
+		_, err := suite.db.CreateSession(context.Background(), session)
+		suite.NoError(err)
+
+		id := session.ID
+		suite.T().Cleanup(func() {
+			_, _ = suite.db.DeleteSession(context.Background(), id)
+		})
+	}
+
+	query := GetSessionsQuery{Owner: owner, Search: marker}
+
+	sessions, err := suite.db.GetSessions(context.Background(), query)
+	suite.NoError(err)
+	suite.Len(sessions, 2)
+
+	counter, err := suite.db.GetSessionsCounter(context.Background(), query)
+	suite.NoError(err)
+	suite.Equal(int64(2), counter.Count)
+
+	// wildcards are matched literally
+	sessions, err = suite.db.GetSessions(context.Background(), GetSessionsQuery{Owner: owner, Search: "%"})
+	suite.NoError(err)
+	suite.Len(sessions, 0)
+}
+
+func (suite *PostgresStoreTestSuite) TestPostgresStore_SessionTags() {
+	owner := "user_" + system.GenerateUUID()
+
+	newSession := func(tags ...string) string {
+		session, err := suite.db.CreateSession(context.Background(), types.Session{
+			ID:        system.GenerateSessionID(),
+			Owner:     owner,
+			OwnerType: types.OwnerTypeUser,
+			Created:   time.Now(),
+			Updated:   time.Now(),
+		})
+		suite.Require().NoError(err)
+		suite.T().Cleanup(func() {
+			_, _ = suite.db.DeleteSession(context.Background(), session.ID)
+		})
+
+		if len(tags) > 0 {
+			updated, err := suite.db.UpdateSessionTags(context.Background(), session.ID, tags)
+			suite.Require().NoError(err)
+			suite.Equal(types.SessionTags(tags), updated.Tags)
+		}
+		return session.ID
+	}
+
+	both := newSession("project x", "research")
+	research := newSession("research")
+	untagged := newSession()
+
+	// saving the whole session leaves the tags alone
+	session, err := suite.db.GetSession(context.Background(), research)
+	suite.Require().NoError(err)
+	session.Tags = nil
+	session.Name = "renamed"
+	updated, err := suite.db.UpdateSession(context.Background(), *session)
+	suite.Require().NoError(err)
+	suite.Equal(types.SessionTags{"research"}, updated.Tags)
+
+	ids := func(query GetSessionsQuery) []string {
+		query.Owner = owner
+		query.OwnerType = types.OwnerTypeUser
+		sessions, err := suite.db.GetSessions(context.Background(), query)
+		suite.Require().NoError(err)
+		result := []string{}
+		for _, session := range sessions {
+			result = append(result, session.ID)
+		}
+		return result
+	}
+
+	suite.ElementsMatch([]string{both, research, untagged}, ids(GetSessionsQuery{}))
+	suite.ElementsMatch([]string{both, research}, ids(GetSessionsQuery{Tags: []string{"research", "project x"}}))
+	suite.ElementsMatch([]string{both}, ids(GetSessionsQuery{Tags: []string{"research", "project x"}, TagsMatchAll: true}))
+
+	counter, err := suite.db.GetSessionsCounter(context.Background(), GetSessionsQuery{Owner: owner, OwnerType: types.OwnerTypeUser, Tags: []string{"project x"}})
+	suite.NoError(err)
+	suite.Equal(int64(1), counter.Count)
+
+	tags, err := suite.db.ListSessionTags(context.Background(), OwnerQuery{Owner: owner, OwnerType: types.OwnerTypeUser})
+	suite.NoError(err)
+	suite.Equal([]string{"project x", "research"}, tags)
+
+	// tags are scoped to the owner
+	tags, err = suite.db.ListSessionTags(context.Background(), OwnerQuery{Owner: "user_" + system.GenerateUUID(), OwnerType: types.OwnerTypeUser})
+	suite.NoError(err)
+	suite.Empty(tags)
+}
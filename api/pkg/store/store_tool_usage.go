@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/helixml/helix/api/pkg/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// count one run of a tool's action, the row is made the first time the
+// action is used
+func (s *PostgresStore) IncrementToolUsage(ctx context.Context, toolID, action string, used time.Time) error {
+	if toolID == "" {
+		return fmt.Errorf("tool id not specified")
+	}
+
+	if action == "" {
+		return fmt.Errorf("action not specified")
+	}
+
+	return s.gdb.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "tool_id"}, {Name: "action"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"count": gorm.Expr("tool_action_usages.count + 1"),
+			// increments can be written out of order
+			"last_used": gorm.Expr("GREATEST(tool_action_usages.last_used, EXCLUDED.last_used)"),
+		}),
+	}).Create(&types.ToolActionUsage{
+		ToolID:   toolID,
+		Action:   action,
+		Count:    1,
+		LastUsed: used,
+	}).Error
+}
+
+// the usage of every action of the tools, actions that have never been run
+// have no usage
+func (s *PostgresStore) ListToolUsage(ctx context.Context, toolIDs []string) ([]*types.ToolActionUsage, error) {
+	usage := []*types.ToolActionUsage{}
+	if len(toolIDs) == 0 {
+		return usage, nil
+	}
+
+	err := s.gdb.WithContext(ctx).
+		Where("tool_id IN ?", toolIDs).
+		Order("tool_id, action").
+		Find(&usage).Error
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
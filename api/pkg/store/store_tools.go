@@ -64,12 +64,12 @@ func (s *PostgresStore) GetTool(ctx context.Context, id string) (*types.Tool, er
 	return &tool, nil
 }
 
+// both the owner and the owner type must match, gorm would skip either one
+// if we queried with a struct and it was empty which would list other
+// owners' tools
 func (s *PostgresStore) ListTools(ctx context.Context, q *ListToolsQuery) ([]*types.Tool, error) {
 	var tools []*types.Tool
-	err := s.gdb.WithContext(ctx).Where(&types.Tool{
-		Owner:     q.Owner,
-		OwnerType: q.OwnerType,
-	}).Find(&tools).Error
+	err := s.gdb.WithContext(ctx).Where("owner = ? AND owner_type = ?", q.Owner, q.OwnerType).Find(&tools).Error
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"time"
+
 	"github.com/helixml/helix/api/pkg/system"
 	"github.com/helixml/helix/api/pkg/types"
 )
@@ -154,3 +156,83 @@ func (suite *PostgresStoreTestSuite) TestDeleteTool() {
 	suite.NoError(err)
 	suite.Equal(0, len(tools))
 }
+
+func (suite *PostgresStoreTestSuite) Test_ListTools_ScopedByOwnerAndOwnerType() {
+	ownerID := "test-" + system.GenerateUUID()
+
+	newTool := func(ownerType types.OwnerType) *types.Tool {
+		tool, err := suite.db.CreateTool(suite.ctx, &types.Tool{
+			Name:      "same-name",
+			Owner:     ownerID,
+			OwnerType: ownerType,
+			ToolType:  types.ToolTypeAPI,
+			Config: types.ToolConfig{
+				API: &types.ToolApiConfig{
+					URL:    "http://test.com",
+					Schema: "123",
+				},
+			},
+		})
+		suite.Require().NoError(err)
+		suite.T().Cleanup(func() {
+			suite.NoError(suite.db.DeleteTool(suite.ctx, tool.ID))
+		})
+		return tool
+	}
+
+	userTool := newTool(types.OwnerTypeUser)
+	orgTool := newTool(types.OwnerType("org"))
+
+	tools, err := suite.db.ListTools(suite.ctx, &ListToolsQuery{Owner: ownerID, OwnerType: types.OwnerTypeUser})
+	suite.NoError(err)
+	suite.Require().Len(tools, 1)
+	suite.Equal(userTool.ID, tools[0].ID)
+
+	tools, err = suite.db.ListTools(suite.ctx, &ListToolsQuery{Owner: ownerID, OwnerType: types.OwnerType("org")})
+	suite.NoError(err)
+	suite.Require().Len(tools, 1)
+	suite.Equal(orgTool.ID, tools[0].ID)
+
+	// an empty owner type doesn't mean any owner type
+	tools, err = suite.db.ListTools(suite.ctx, &ListToolsQuery{Owner: ownerID})
+	suite.NoError(err)
+	suite.Empty(tools)
+}
+
+func (suite *PostgresStoreTestSuite) Test_ToolUsage() {
+	tool, err := suite.db.CreateTool(suite.ctx, &types.Tool{
+		Name:      "usage",
+		Owner:     "test-" + system.GenerateUUID(),
+		OwnerType: types.OwnerTypeUser,
+		ToolType:  types.ToolTypeAPI,
+		Config: types.ToolConfig{
+			API: &types.ToolApiConfig{
+				URL:    "http://test.com",
+				Schema: "123",
+			},
+		},
+	})
+	suite.Require().NoError(err)
+
+	used := time.Now().Truncate(time.Second)
+	suite.NoError(suite.db.IncrementToolUsage(suite.ctx, tool.ID, "listPets", used))
+	suite.NoError(suite.db.IncrementToolUsage(suite.ctx, tool.ID, "listPets", used.Add(time.Minute)))
+	// written late, doesn't move last used back
+	suite.NoError(suite.db.IncrementToolUsage(suite.ctx, tool.ID, "listPets", used.Add(-time.Minute)))
+	suite.NoError(suite.db.IncrementToolUsage(suite.ctx, tool.ID, "getPet", used))
+
+	usage, err := suite.db.ListToolUsage(suite.ctx, []string{tool.ID})
+	suite.NoError(err)
+	suite.Require().Len(usage, 2)
+	suite.Equal("getPet", usage[0].Action)
+	suite.Equal(int64(1), usage[0].Count)
+	suite.Equal("listPets", usage[1].Action)
+	suite.Equal(int64(3), usage[1].Count)
+	suite.True(used.Add(time.Minute).Equal(usage[1].LastUsed))
+
+	// the usage goes with the tool
+	suite.NoError(suite.db.DeleteTool(suite.ctx, tool.ID))
+	usage, err = suite.db.ListToolUsage(suite.ctx, []string{tool.ID})
+	suite.NoError(err)
+	suite.Empty(usage)
+}
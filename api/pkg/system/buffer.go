@@ -25,6 +25,13 @@ func (b *LimitedBuffer) Write(p []byte) (n int, err error) {
 	defer b.mu.Unlock()
 
 	lenP := len(p)
+
+	// A single write bigger than the limit only keeps its own tail.
+	if lenP >= b.limit {
+		b.buf = append(b.buf[:0], p[lenP-b.limit:]...)
+		return lenP, nil
+	}
+
 	newLen := len(b.buf) + lenP
 
 	// If new length exceeds limit, discard the earliest bytes.
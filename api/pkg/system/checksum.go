@@ -0,0 +1,42 @@
+package system
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+const (
+	// the multipart form field runners put a JSON map of filename -> sha256 in
+	// when they upload result files
+	UploadChecksumsField = "checksums"
+	// the PAX record runners put each file's sha256 in when they upload a
+	// folder as a tar stream
+	TarChecksumPAXRecord = "HELIX.sha256"
+)
+
+func FileSHA256(filePath string) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// the hex encoded sha256 of everything read from the reader
+func ReaderSHA256(reader io.Reader) (string, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, reader)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -3,11 +3,29 @@ package system
 import (
 	"archive/tar"
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// a path from a tar file, a session or a model process that would take us
+// outside of the folder it belongs in
+var ErrPathEscapesRoot = errors.New("path escapes its root folder")
+
+// the cleaned path if it is root or somewhere inside it
+func ResolvePathInside(root string, localPath string) (string, error) {
+	root = filepath.Clean(root)
+	localPath = filepath.Clean(localPath)
+	rel, err := filepath.Rel(root, localPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s is not inside %s", ErrPathEscapesRoot, localPath, root)
+	}
+	return localPath, nil
+}
+
 func WriteFile(path string, data []byte) error {
 	file, err := os.Create(path)
 	if err != nil {
@@ -104,7 +122,10 @@ func ExpandTarBuffer(buf *bytes.Buffer, localPath string) error {
 		}
 
 		// Prepare file path and create directories if needed
-		target := filepath.Join(localPath, header.Name)
+		target, err := ResolvePathInside(localPath, filepath.Join(localPath, header.Name))
+		if err != nil {
+			return err
+		}
 		dir, _ := filepath.Split(target)
 		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
 			return err
@@ -42,16 +42,81 @@ func WSURL(options ClientOptions, path string) string {
 	}
 }
 
+// a stable machine readable reason for an error that clients can branch on
+// instead of matching the message - once a code is in use it must not change
+type HTTPErrorCode string
+
+const (
+	HTTPErrorCodeToolNameConflict     HTTPErrorCode = "tool_name_conflict"
+	HTTPErrorCodeIdempotencyConflict  HTTPErrorCode = "idempotency_key_conflict"
+	HTTPErrorCodeModelNotFound        HTTPErrorCode = "model_not_found"
+	HTTPErrorCodeSessionQuotaExceeded HTTPErrorCode = "session_quota_exceeded"
+	HTTPErrorCodeToolNotFound         HTTPErrorCode = "tool_not_found"
+	HTTPErrorCodeToolForbidden        HTTPErrorCode = "tool_forbidden"
+	HTTPErrorCodeToolActionsInUse     HTTPErrorCode = "tool_actions_in_use"
+)
+
 type HTTPError struct {
 	StatusCode int
 	Message    string
-	Req        *http.Request
+	// errors without a code are written as a plain text message
+	Code HTTPErrorCode
+	// anything else the client needs to act on the error, only sent
+	// along with a code
+	Details any
+	Req     *http.Request
 }
 
 func (e *HTTPError) Error() string {
 	return e.Message
 }
 
+func (e *HTTPError) WithCode(code HTTPErrorCode) *HTTPError {
+	e.Code = code
+	return e
+}
+
+func (e *HTTPError) WithDetails(details any) *HTTPError {
+	e.Details = details
+	return e
+}
+
+// how an error with a code is written to the client
+type HTTPErrorResponse struct {
+	Error HTTPErrorBody `json:"error"`
+}
+
+type HTTPErrorBody struct {
+	Code    HTTPErrorCode `json:"code"`
+	Message string        `json:"message"`
+	Details any           `json:"details,omitempty"`
+}
+
+// for handlers that write their own response rather than using Wrapper
+func WriteHTTPError(res http.ResponseWriter, req *http.Request, err *HTTPError) {
+	statusCode := err.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusInternalServerError
+	}
+	if err.Code == "" {
+		http.Error(res, withRequestID(err.Error(), req), statusCode)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(statusCode)
+	encodeErr := json.NewEncoder(res).Encode(HTTPErrorResponse{
+		Error: HTTPErrorBody{
+			Code:    err.Code,
+			Message: withRequestID(err.Error(), req),
+			Details: err.Details,
+		},
+	})
+	if encodeErr != nil {
+		log.Ctx(req.Context()).Error().Msgf("error for json encoding: %s", encodeErr.Error())
+	}
+}
+
 func NewHTTPError(err error) *HTTPError {
 	return &HTTPError{
 		StatusCode: http.StatusInternalServerError,
@@ -87,6 +152,13 @@ func NewHTTPError404(message string) *HTTPError {
 	}
 }
 
+func NewHTTPError409(message string) *HTTPError {
+	return &HTTPError{
+		StatusCode: http.StatusConflict,
+		Message:    message,
+	}
+}
+
 func NewHTTPError500(tmpl string, format ...interface{}) *HTTPError {
 	return &HTTPError{
 		StatusCode: http.StatusInternalServerError,
@@ -133,13 +205,9 @@ func WrapperWithConfig[T any](handler httpWrapper[T], config WrapperConfig) func
 				HTTP_ERROR_HANDLER(err, req)
 			}
 			if !config.SilenceErrors {
-				log.Error().Msgf("error for route: %s", err.Error())
-			}
-			statusCode := err.StatusCode
-			if statusCode == 0 {
-				statusCode = http.StatusInternalServerError
+				log.Error().Str("request_id", GetRequestID(req.Context())).Msgf("error for route: %s", err.Error())
 			}
-			http.Error(res, err.Error(), statusCode)
+			WriteHTTPError(res, req, err)
 			return
 		} else {
 			res.Header().Set("Content-Type", "application/json")
@@ -179,9 +247,9 @@ func DefaultWrapperWithConfig[T any](handler defaultWrapper[T], config WrapperCo
 				ERROR_HANDLER(err, req)
 			}
 			if !config.SilenceErrors {
-				log.Error().Msgf("error for route: %s", err.Error())
+				log.Error().Str("request_id", GetRequestID(req.Context())).Msgf("error for route: %s", err.Error())
 			}
-			http.Error(res, err.Error(), http.StatusInternalServerError)
+			http.Error(res, withRequestID(err.Error(), req), http.StatusInternalServerError)
 			return
 		} else {
 			res.Header().Set("Content-Type", "application/json")
@@ -2,35 +2,60 @@ package system
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"time"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
-func SetupLogging() {
-	output := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
-	logLevelString := os.Getenv("LOG_LEVEL")
-	if logLevelString == "" {
-		logLevelString = "info"
+type LogFormat string
+
+const (
+	// human readable and coloured, for running locally
+	LogFormatConsole LogFormat = "console"
+	// one JSON object per line, for log collectors
+	LogFormatJSON LogFormat = "json"
+)
+
+type LoggingOptions struct {
+	// trace, debug, info, warn, error or none
+	Level  string
+	Format LogFormat
+}
+
+func parseLogLevel(level string) (zerolog.Level, error) {
+	if level == "none" {
+		return zerolog.Disabled, nil
 	}
-	logLevel := zerolog.InfoLevel
-	if logLevelString == "none" {
-		logLevel = zerolog.NoLevel
+	parsed, err := zerolog.ParseLevel(level)
+	if err != nil || parsed == zerolog.NoLevel {
+		return zerolog.NoLevel, fmt.Errorf("unknown log level '%s', must be one of trace, debug, info, warn, error or none", level)
 	}
-	parsedLogLevel, err := zerolog.ParseLevel(logLevelString)
-	if err == nil {
-		logLevel = parsedLogLevel
+	return parsed, nil
+}
+
+// SetupLogging points the global logger at stdout with the given level and
+// format, everything should log through it rather than print
+func SetupLogging(options LoggingOptions) error {
+	level, err := parseLogLevel(options.Level)
+	if err != nil {
+		return err
+	}
+
+	var output io.Writer
+	switch options.Format {
+	case LogFormatConsole:
+		output = zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
+	case LogFormatJSON:
+		output = os.Stdout
+	default:
+		return fmt.Errorf("unknown log format '%s', must be %s or %s", options.Format, LogFormatConsole, LogFormatJSON)
 	}
 
 	// edit to change the level of the stack we report
 	// zerolog.CallerSkipFrameCount = 3 // Skip 3 frames (this function, log.Output, log.Logger)
-	log.Logger = log.Output(output).With().Caller().Logger().Level(logLevel)
-}
-
-func Spew(things []interface{}) {
-	fmt.Printf(" --------------------------------------\n")
-	spew.Dump(things)
+	log.Logger = zerolog.New(output).With().Timestamp().Caller().Logger().Level(level)
+	return nil
 }
@@ -2,7 +2,9 @@ package system
 
 import (
 	"math/rand"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 var adjectives = []string{
@@ -42,3 +44,16 @@ func GenerateAmusingName() string {
 	number := rand.Intn(900) + 100 // generates a random 3 digit number
 	return adj + "-" + noun + "-" + strconv.Itoa(number)
 }
+
+// whether the name looks like one we generated rather than one the user gave
+func IsAmusingName(name string) bool {
+	parts := strings.Split(name, "-")
+	if len(parts) != 3 {
+		return false
+	}
+	number, err := strconv.Atoi(parts[2])
+	if err != nil || number < 100 || number > 999 {
+		return false
+	}
+	return slices.Contains(adjectives, parts[0]) && slices.Contains(nouns, parts[1])
+}
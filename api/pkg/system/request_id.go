@@ -0,0 +1,56 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// the header we read an inbound request ID from and echo it back on
+const RequestIDHeader = "X-Request-ID"
+
+// inbound request IDs longer than this are ignored and we make our own
+const maxRequestIDLength = 128
+
+type requestIDContextKey struct{}
+
+func SetRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// returns an empty string if the context did not come from a http request
+func GetRequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+// we only honor inbound request IDs that are safe to put in log lines
+// and headers - anything else gets replaced with a generated one
+func IsValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		switch {
+		case c >= 'a' && c <= 'z':
+		case c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// appended to error messages so users can quote it when asking for help
+func withRequestID(message string, req *http.Request) string {
+	requestID := GetRequestID(req.Context())
+	if requestID == "" {
+		return message
+	}
+	return fmt.Sprintf("%s (request id: %s)", message, requestID)
+}
@@ -11,6 +11,7 @@ import (
 const (
 	ToolPrefix    = "tool_"
 	SessionPrefix = "ses_"
+	RequestPrefix = "req_"
 )
 
 func GenerateUUID() string {
@@ -28,3 +29,7 @@ func GenerateToolID() string {
 func GenerateSessionID() string {
 	return fmt.Sprintf("%s%s", SessionPrefix, newID())
 }
+
+func GenerateRequestID() string {
+	return fmt.Sprintf("%s%s", RequestPrefix, newID())
+}
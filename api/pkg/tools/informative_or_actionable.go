@@ -81,7 +81,7 @@ func (c *ChainStrategy) IsActionable(ctx context.Context, tools []*types.Tool, h
 		openai.ChatCompletionRequest{
 			Stream:    false,
 			MaxTokens: 100,
-			Model:     c.cfg.Tools.Model,
+			Model:     c.model,
 			Messages:  messages,
 		},
 	)
@@ -29,36 +29,26 @@ type ChainStrategy struct {
 	cfg        *config.ServerConfig
 	apiClient  openai.Client
 	httpClient *http.Client
+	// the model the tools provider is asked to use
+	model string
 }
 
 func NewChainStrategy(cfg *config.ServerConfig) (*ChainStrategy, error) {
 	var apiClient openai.Client
 
-	switch cfg.Tools.Provider {
-	case config.ProviderOpenAI:
-		if cfg.Providers.OpenAI.APIKey == "" {
-			return nil, errors.New("OpenAI API key (OPENAI_API_KEY) is required")
-		}
-
-		log.Info().Msg("using OpenAI provider for tools")
-
-		apiClient = openai.New(
-			cfg.Providers.OpenAI.APIKey,
-			cfg.Providers.OpenAI.BaseURL)
-	case config.ProviderTogetherAI:
-		if cfg.Providers.TogetherAI.APIKey == "" {
-			return nil, errors.New("TogetherAI API key (TOGETHER_API_KEY) is required")
-		}
-
+	llm, err := cfg.ResolveLLM(cfg.Tools.Provider, cfg.Tools.Model)
+	switch {
+	case errors.Is(err, config.ErrUnknownProvider):
+		log.Warn().Msg("no tools provider configured")
+	case err != nil:
+		return nil, err
+	default:
 		log.Info().
-			Str("base_url", cfg.Providers.TogetherAI.BaseURL).
-			Msg("using TogetherAI provider for tools")
+			Str("base_url", llm.BaseURL).
+			Str("model", llm.Model).
+			Msg("using LLM for tools")
 
-		apiClient = openai.New(
-			cfg.Providers.TogetherAI.APIKey,
-			cfg.Providers.TogetherAI.BaseURL)
-	default:
-		log.Warn().Msg("no tools provider configured")
+		apiClient = openai.New(llm.APIKey, llm.BaseURL)
 	}
 
 	retryClient := retryablehttp.NewClient()
@@ -94,6 +84,7 @@ func NewChainStrategy(cfg *config.ServerConfig) (*ChainStrategy, error) {
 	return &ChainStrategy{
 		cfg:        cfg,
 		apiClient:  apiClient,
+		model:      llm.Model,
 		httpClient: retryClient.StandardClient(),
 	}, nil
 }
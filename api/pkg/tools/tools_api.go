@@ -3,15 +3,18 @@ package tools
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/helixml/helix/api/pkg/types"
 
 	openai "github.com/lukemarsden/go-openai2"
+	"github.com/rs/zerolog/log"
 )
 
 func (c *ChainStrategy) prepareRequest(ctx context.Context, tool *types.Tool, action string, params map[string]string) (*http.Request, error) {
@@ -23,17 +26,23 @@ func (c *ChainStrategy) prepareRequest(ctx context.Context, tool *types.Tool, ac
 	}
 
 	// Based on the operationId get the path and method
-	var path, method string
+	var path, method, serverURL string
 
 	queryParams := make(map[string]bool)
 	pathParams := make(map[string]bool)
 
 	for p, pathItem := range schema.Paths.Map() {
 		for m, operation := range pathItem.Operations() {
-			if operation.OperationID == action {
+			if getActionName(m, p, operation) == action {
 				path = p
 				method = m
 
+				servers, specLevel := declaredServers(schema, pathItem, operation)
+				serverURL, err = resolveServerURL(tool.Config.API, servers, specLevel)
+				if err != nil {
+					return nil, fmt.Errorf("failed to find a server for action %s: %w", action, err)
+				}
+
 				for _, param := range operation.Parameters {
 
 					switch param.Value.In {
@@ -54,7 +63,7 @@ func (c *ChainStrategy) prepareRequest(ctx context.Context, tool *types.Tool, ac
 	}
 
 	// Prepare request
-	req, err := http.NewRequestWithContext(ctx, method, tool.Config.API.URL+path, nil)
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(serverURL, "/")+path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -95,7 +104,55 @@ func (c *ChainStrategy) prepareRequest(ctx context.Context, tool *types.Tool, ac
 	return req, nil
 }
 
-func (c *ChainStrategy) getAPIRequestParameters(ctx context.Context, tool *types.Tool, history []*types.Interaction, currentMessage, action string) (map[string]string, error) {
+const redactedValue = "REDACTED"
+
+// headers that carry credentials whatever the tool is
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+}
+
+// describeRequest records the request we are about to send for debugging,
+// anything the tool config adds (headers and query) is how the tool
+// authenticates so its values are redacted along with the usual suspects
+func describeRequest(tool *types.Tool, req *http.Request) *types.ToolActionRequest {
+	configuredHeaders := make(map[string]bool)
+	for k := range tool.Config.API.Headers {
+		configuredHeaders[http.CanonicalHeaderKey(k)] = true
+	}
+
+	headers := make(map[string]string)
+	for k, v := range req.Header {
+		if sensitiveHeaders[k] || configuredHeaders[k] {
+			headers[k] = redactedValue
+			continue
+		}
+		headers[k] = strings.Join(v, ", ")
+	}
+
+	u := *req.URL
+	q := u.Query()
+	for k := range tool.Config.API.Query {
+		if q.Has(k) {
+			q.Set(k, redactedValue)
+		}
+	}
+	u.RawQuery = q.Encode()
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redactedValue)
+	}
+
+	return &types.ToolActionRequest{
+		Method:  req.Method,
+		URL:     u.String(),
+		Headers: headers,
+	}
+}
+
+// the parameters come back as the LLM gave them, they are only turned into
+// strings for the URL so a templated body keeps their types
+func (c *ChainStrategy) getAPIRequestParameters(ctx context.Context, tool *types.Tool, history []*types.Interaction, currentMessage, action string) (map[string]interface{}, error) {
 	systemPrompt, err := c.getApiSystemPrompt(tool)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare system prompt: %w", err)
@@ -111,35 +168,83 @@ func (c *ChainStrategy) getAPIRequestParameters(ctx context.Context, tool *types
 		userPrompt,
 	}
 
-	resp, err := c.apiClient.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Stream:    false,
-			MaxTokens: 100,
-			Model:     c.cfg.Tools.Model,
-			Messages:  messages,
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get response from inference API: %w", err)
+	for attempt := 1; ; attempt++ {
+		resp, err := c.apiClient.CreateChatCompletion(
+			ctx,
+			openai.ChatCompletionRequest{
+				Stream:    false,
+				MaxTokens: 100,
+				Model:     c.model,
+				Messages:  messages,
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get response from inference API: %w", err)
+		}
+
+		if len(resp.Choices) == 0 {
+			return nil, fmt.Errorf("no response from inference API")
+		}
+
+		content := resp.Choices[0].Message.Content
+
+		params, err := decodeParams(content)
+		if err != nil {
+			return nil, err
+		}
+
+		err = validateParams(tool, action, params)
+
+		var validationErr *ParameterValidationError
+		if errors.As(err, &validationErr) && attempt < maxParamsAttempts {
+			log.Info().
+				Str("tool", tool.Name).
+				Str("action", action).
+				Int("attempt", attempt).
+				Err(err).
+				Msg("API request parameters are not valid, asking again")
+
+			// let the LLM see what it said and what was wrong with it
+			messages = append(messages,
+				openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleAssistant,
+					Content: content,
+				},
+				openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleUser,
+					Content: validationErr.feedback(),
+				},
+			)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return params, nil
 	}
+}
 
-	// var params map[string]string
-	params, err := unmarshalParams(resp.Choices[0].Message.Content)
+func unmarshalParams(data string) (map[string]string, error) {
+	params, err := decodeParams(data)
 	if err != nil {
 		return nil, err
 	}
 
-	return params, nil
+	return stringifyParams(params), nil
 }
 
-func unmarshalParams(data string) (map[string]string, error) {
-	var initial map[string]interface{}
-	err := json.Unmarshal([]byte(data), &initial)
+func decodeParams(data string) (map[string]interface{}, error) {
+	var params map[string]interface{}
+	err := json.Unmarshal([]byte(data), &params)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response from inference API: %w (%s)", err, data)
 	}
 
+	return params, nil
+}
+
+func stringifyParams(initial map[string]interface{}) map[string]string {
 	params := make(map[string]string)
 
 	for k, v := range initial {
@@ -147,7 +252,7 @@ func unmarshalParams(data string) (map[string]string, error) {
 		params[k] = fmt.Sprintf("%v", v)
 	}
 
-	return params, nil
+	return params
 }
 
 func (c *ChainStrategy) getApiSystemPrompt(tool *types.Tool) (openai.ChatCompletionMessage, error) {
@@ -169,16 +274,29 @@ func (c *ChainStrategy) getApiUserPrompt(tool *types.Tool, history []*types.Inte
 		return openai.ChatCompletionMessage{}, err
 	}
 
+	// with a body template the LLM fills in its placeholders rather than
+	// the request body in the schema
+	bodyTmpl, err := actionBodyTemplate(tool, action)
+	if err != nil {
+		return openai.ChatCompletionMessage{}, err
+	}
+	var bodyParameters []string
+	if bodyTmpl != nil {
+		bodyParameters = bodyTmpl.placeholders
+	}
+
 	// Render template
 	var sb strings.Builder
 	err = tmpl.Execute(&sb, struct {
-		Schema       string
-		Message      string
-		Interactions []*types.Interaction
+		Schema         string
+		Message        string
+		Interactions   []*types.Interaction
+		BodyParameters []string
 	}{
-		Schema:       jsonSpec,
-		Message:      currentMessage,
-		Interactions: history,
+		Schema:         jsonSpec,
+		Message:        currentMessage,
+		Interactions:   history,
+		BodyParameters: bodyParameters,
 	})
 
 	if err != nil {
@@ -230,7 +348,9 @@ Conversation so far:
 {{ $interaction.Creator }}: ({{ $interaction.Message }})
 {{ end }}
 user: ({{ .Message }})
-
+{{ if .BodyParameters }}
+The request body is made for you, instead of the body in the schema include these parameters in the JSON object: {{ range $index, $name := .BodyParameters }}{{ if $index }}, {{ end }}{{ $name }}{{ end }}
+{{ end }}
 
 Based on the information provided, construct a valid JSON object. In cases where user input does not contain information for a query, DO NOT add that specific query parameter to the output. If a user doesn't provide a required parameter, use sensible defaults for required params, and leave optional params.
 `
@@ -257,7 +377,7 @@ func filterOpenAPISchema(tool *types.Tool, operationId string) (string, error) {
 
 	for path, pathItem := range schema.Paths.Map() {
 		for method, operation := range pathItem.Operations() {
-			if operation.OperationID == operationId {
+			if getActionName(method, path, operation) == operationId {
 				// filtered.addOperation(path, method, operation)
 				filtered.AddOperation(path, method, operation)
 
@@ -316,18 +436,47 @@ func GetActionsFromSchema(spec string) ([]*types.ToolApiAction, error) {
 				description = operation.Description
 			}
 
-			if operation.OperationID == "" {
-				return nil, fmt.Errorf("operationId is missing for all %s %s", method, path)
-			}
-
-			actions = append(actions, &types.ToolApiAction{
-				Name:        operation.OperationID,
+			action := &types.ToolApiAction{
+				Name:        getActionName(method, path, operation),
 				Description: description,
 				Path:        path,
 				Method:      method,
-			})
+
+				ResponseTransform: getResponseTransform(operation),
+				BodyTemplate:      getBodyTemplate(operation),
+			}
+			if servers, specLevel := declaredServers(schema, pathItem, operation); !specLevel {
+				action.Servers = servers
+			}
+
+			actions = append(actions, action)
 		}
 	}
 
 	return actions, nil
 }
+
+// getActionName returns the operationId of the operation, falling back to a
+// name derived from the method and path when it's not set
+func getActionName(method, path string, operation *openapi3.Operation) string {
+	if operation.OperationID != "" {
+		return operation.OperationID
+	}
+
+	var sb strings.Builder
+	for _, r := range strings.ToLower(method + "-" + path) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			sb.WriteRune(r)
+		case !strings.HasSuffix(sb.String(), "-"):
+			sb.WriteRune('-')
+		}
+	}
+
+	name := strings.Trim(sb.String(), "-")
+	if len(name) > maxActionNameLength {
+		name = strings.TrimRight(name[:maxActionNameLength], "-")
+	}
+
+	return name
+}
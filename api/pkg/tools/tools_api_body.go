@@ -0,0 +1,179 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// the operation extension that sets an action's BodyTemplate
+const bodyTemplateExtension = "x-helix-body-template"
+
+// {{name}} in a string of a body template
+var bodyTemplatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_.-]*)\s*\}\}`)
+
+// a body template is the JSON body of the request with {{name}} placeholders
+// in its strings that are filled in from the parameters the LLM gives us:
+//
+//	{"data": {"type": "ticket", "attributes": {"title": "{{title}}", "count": "{{count}}"}}}
+//
+// a string that is nothing but a placeholder is replaced by the parameter
+// as it is (so count stays a number), anywhere else the parameter is
+// written into the string
+type bodyTemplate struct {
+	body         any
+	placeholders []string
+}
+
+func parseBodyTemplate(text string) (*bodyTemplate, error) {
+	var body any
+	err := json.Unmarshal([]byte(text), &body)
+	if err != nil {
+		return nil, fmt.Errorf("body template is not JSON: %w", err)
+	}
+
+	seen := map[string]bool{}
+	walkTemplateStrings(body, func(s string) {
+		for _, match := range bodyTemplatePlaceholder.FindAllStringSubmatch(s, -1) {
+			seen[match[1]] = true
+		}
+	})
+
+	placeholders := make([]string, 0, len(seen))
+	for name := range seen {
+		placeholders = append(placeholders, name)
+	}
+	sort.Strings(placeholders)
+
+	return &bodyTemplate{body: body, placeholders: placeholders}, nil
+}
+
+func walkTemplateStrings(value any, fn func(string)) {
+	switch v := value.(type) {
+	case string:
+		fn(v)
+	case []any:
+		for _, item := range v {
+			walkTemplateStrings(item, fn)
+		}
+	case map[string]any:
+		for _, item := range v {
+			walkTemplateStrings(item, fn)
+		}
+	}
+}
+
+// the placeholders the parameters don't have a value for
+func (t *bodyTemplate) missing(params map[string]any) []string {
+	var missing []string
+	for _, name := range t.placeholders {
+		if value, ok := params[name]; !ok || value == nil {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+func (t *bodyTemplate) render(params map[string]any) ([]byte, error) {
+	if missing := t.missing(params); len(missing) > 0 {
+		return nil, fmt.Errorf("body template parameters missing: %s", strings.Join(missing, ", "))
+	}
+	return json.Marshal(fillTemplate(t.body, params))
+}
+
+func fillTemplate(value any, params map[string]any) any {
+	switch v := value.(type) {
+	case string:
+		if match := bodyTemplatePlaceholder.FindStringSubmatch(v); match != nil && match[0] == v {
+			return params[match[1]]
+		}
+		return bodyTemplatePlaceholder.ReplaceAllStringFunc(v, func(placeholder string) string {
+			name := bodyTemplatePlaceholder.FindStringSubmatch(placeholder)[1]
+			if s, ok := params[name].(string); ok {
+				return s
+			}
+			return fmt.Sprintf("%v", params[name])
+		})
+	case []any:
+		filled := make([]any, len(v))
+		for i, item := range v {
+			filled[i] = fillTemplate(item, params)
+		}
+		return filled
+	case map[string]any:
+		filled := make(map[string]any, len(v))
+		for key, item := range v {
+			filled[key] = fillTemplate(item, params)
+		}
+		return filled
+	default:
+		return v
+	}
+}
+
+// the body template set on the operation, if any - it can be written as
+// JSON in a string or as the object itself
+func getBodyTemplate(operation *openapi3.Operation) string {
+	value, ok := operation.Extensions[bodyTemplateExtension]
+	if !ok {
+		return ""
+	}
+	if text, ok := value.(string); ok {
+		return text
+	}
+	bts, err := json.Marshal(value)
+	if err != nil {
+		// left for ValidateActions to complain about
+		return fmt.Sprint(value)
+	}
+	return string(bts)
+}
+
+// the body template of the action, GET requests don't have a body so any
+// template on them is ignored
+func actionBodyTemplate(tool *types.Tool, action string) (*bodyTemplate, error) {
+	for _, ac := range tool.Config.API.Actions {
+		if ac.Name != action {
+			continue
+		}
+		if ac.BodyTemplate == "" || strings.EqualFold(ac.Method, http.MethodGet) {
+			return nil, nil
+		}
+		return parseBodyTemplate(ac.BodyTemplate)
+	}
+	return nil, nil
+}
+
+// send the action's body template filled in with the parameters as the
+// request body, nothing is sent for actions without one
+func setRequestBody(req *http.Request, tool *types.Tool, action string, params map[string]any) error {
+	bodyTmpl, err := actionBodyTemplate(tool, action)
+	if err != nil {
+		return err
+	}
+	if bodyTmpl == nil {
+		return nil
+	}
+
+	body, err := bodyTmpl.render(params)
+	if err != nil {
+		return err
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	return nil
+}
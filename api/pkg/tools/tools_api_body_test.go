@@ -0,0 +1,141 @@
+package tools
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/config"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+const bodyTemplateApiSpec = `openapi: "3.0.0"
+info:
+  version: 1.0.0
+  title: Tickets
+servers:
+  - url: https://tickets.example.com
+paths:
+  /tickets:
+    post:
+      operationId: createTicket
+      x-helix-body-template: '{"data": {"type": "ticket", "attributes": {"title": "{{title}}", "priority": "{{priority}}", "summary": "{{title}} ({{priority}})"}}}'
+      requestBody:
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [data]
+      responses:
+        '201':
+          description: The ticket
+    get:
+      operationId: listTickets
+      x-helix-body-template:
+        query: "{{title}}"
+      responses:
+        '200':
+          description: The tickets
+`
+
+func newBodyTemplateTool(t *testing.T) *types.Tool {
+	actions, err := GetActionsFromSchema(bodyTemplateApiSpec)
+	require.NoError(t, err)
+
+	return &types.Tool{
+		Name:     "tickets",
+		ToolType: types.ToolTypeAPI,
+		Config: types.ToolConfig{
+			API: &types.ToolApiConfig{
+				Schema:  bodyTemplateApiSpec,
+				Actions: actions,
+			},
+		},
+	}
+}
+
+func Test_GetActionsFromSchema_BodyTemplate(t *testing.T) {
+	tool := newBodyTemplateTool(t)
+
+	templates := map[string]string{}
+	for _, action := range tool.Config.API.Actions {
+		templates[action.Name] = action.BodyTemplate
+	}
+
+	assert.Contains(t, templates["createTicket"], `"title": "{{title}}"`)
+	// written as an object rather than a string
+	assert.JSONEq(t, `{"query": "{{title}}"}`, templates["listTickets"])
+}
+
+func Test_bodyTemplate_render(t *testing.T) {
+	bodyTmpl, err := parseBodyTemplate(`{"items": ["{{name}}", 1], "count": "{{ count }}", "label": "{{name}} x{{count}}", "fixed": true}`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"count", "name"}, bodyTmpl.placeholders)
+
+	body, err := bodyTmpl.render(map[string]any{"name": "rex", "count": float64(2), "unused": "x"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"items": ["rex", 1], "count": 2, "label": "rex x2", "fixed": true}`, string(body))
+
+	_, err = bodyTmpl.render(map[string]any{"name": "rex"})
+	assert.EqualError(t, err, "body template parameters missing: count")
+
+	_, err = parseBodyTemplate(`{"title": {{title}}}`)
+	assert.Error(t, err)
+}
+
+func Test_setRequestBody(t *testing.T) {
+	tool := newBodyTemplateTool(t)
+	strategy := &ChainStrategy{}
+	params := map[string]any{"title": "Printer on fire", "priority": float64(1)}
+
+	req, err := strategy.prepareRequest(context.Background(), tool, "createTicket", stringifyParams(params))
+	require.NoError(t, err)
+	require.NoError(t, setRequestBody(req, tool, "createTicket", params))
+
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"data": {"type": "ticket", "attributes": {"title": "Printer on fire", "priority": 1, "summary": "Printer on fire (1)"}}}`, string(body))
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, int64(len(body)), req.ContentLength)
+
+	// GET requests don't get a body
+	req, err = strategy.prepareRequest(context.Background(), tool, "listTickets", stringifyParams(params))
+	require.NoError(t, err)
+	require.NoError(t, setRequestBody(req, tool, "listTickets", params))
+	assert.Nil(t, req.Body)
+}
+
+func Test_validateParams_BodyTemplate(t *testing.T) {
+	tool := newBodyTemplateTool(t)
+
+	// the template's placeholders are checked rather than the body schema
+	err := validateParams(tool, "createTicket", map[string]any{"title": "Printer on fire", "priority": 1})
+	require.NoError(t, err)
+
+	err = validateParams(tool, "createTicket", map[string]any{"title": "Printer on fire"})
+	var validationErr *ParameterValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []ParameterProblem{{Name: "priority", In: "body", Problem: "is required"}}, validationErr.Problems)
+
+	// and ignored on GET
+	require.NoError(t, validateParams(tool, "listTickets", map[string]any{}))
+}
+
+func Test_getAPIRequestParameters_BodyTemplate(t *testing.T) {
+	client := &fakeParamsClient{answers: []string{
+		`{"title": "Printer on fire"}`,
+		`{"title": "Printer on fire", "priority": 1}`,
+	}}
+	strategy := &ChainStrategy{cfg: &config.ServerConfig{}, apiClient: client}
+
+	params, err := strategy.getAPIRequestParameters(context.Background(), newBodyTemplateTool(t), nil, "the printer is on fire", "createTicket")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"title": "Printer on fire", "priority": float64(1)}, params)
+
+	require.Len(t, client.requests, 2)
+	assert.Contains(t, client.requests[0].Messages[1].Content, "include these parameters in the JSON object: priority, title")
+	assert.Contains(t, client.requests[1].Messages[3].Content, `"name":"priority","in":"body"`)
+}
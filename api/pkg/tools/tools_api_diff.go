@@ -0,0 +1,66 @@
+package tools
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// DiffActions works out what replacing a tool's actions with the ones parsed
+// from its new schema does - an old action whose method and path now has a
+// different name is renamed rather than removed and added
+func DiffActions(previous, updated []*types.ToolApiAction) *types.ToolActionsDiff {
+	diff := &types.ToolActionsDiff{
+		Added:   []string{},
+		Removed: []string{},
+		Renamed: []*types.ToolActionRename{},
+	}
+
+	previousNames := map[string]bool{}
+	for _, action := range previous {
+		previousNames[action.Name] = true
+	}
+
+	updatedNames := map[string]bool{}
+	// the new actions that aren't one of the old names, by method and path
+	newByOperation := map[string]string{}
+	for _, action := range updated {
+		updatedNames[action.Name] = true
+		if !previousNames[action.Name] {
+			newByOperation[actionOperation(action)] = action.Name
+		}
+	}
+
+	renamedTo := map[string]bool{}
+	for _, action := range previous {
+		if updatedNames[action.Name] {
+			continue
+		}
+		name, ok := newByOperation[actionOperation(action)]
+		if ok && !renamedTo[name] {
+			renamedTo[name] = true
+			diff.Renamed = append(diff.Renamed, &types.ToolActionRename{From: action.Name, To: name})
+			continue
+		}
+		diff.Removed = append(diff.Removed, action.Name)
+	}
+
+	for _, action := range updated {
+		if !previousNames[action.Name] && !renamedTo[action.Name] {
+			diff.Added = append(diff.Added, action.Name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Renamed, func(i, j int) bool {
+		return diff.Renamed[i].From < diff.Renamed[j].From
+	})
+
+	return diff
+}
+
+func actionOperation(action *types.ToolApiAction) string {
+	return strings.ToUpper(action.Method) + " " + action.Path
+}
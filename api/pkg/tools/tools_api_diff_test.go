@@ -0,0 +1,37 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+func TestDiffActions(t *testing.T) {
+	previous := []*types.ToolApiAction{
+		{Name: "listPets", Method: "GET", Path: "/pets"},
+		{Name: "createPets", Method: "POST", Path: "/pets"},
+		{Name: "showPetById", Method: "GET", Path: "/pets/{petId}"},
+	}
+	updated := []*types.ToolApiAction{
+		{Name: "listPets", Method: "GET", Path: "/pets"},
+		{Name: "getPet", Method: "get", Path: "/pets/{petId}"},
+		{Name: "deletePet", Method: "DELETE", Path: "/pets/{petId}"},
+	}
+
+	diff := DiffActions(previous, updated)
+	assert.Equal(t, []string{"deletePet"}, diff.Added)
+	assert.Equal(t, []string{"createPets"}, diff.Removed)
+	assert.Equal(t, []*types.ToolActionRename{{From: "showPetById", To: "getPet"}}, diff.Renamed)
+	assert.True(t, diff.Breaking())
+
+	// adding actions doesn't take anything away
+	diff = DiffActions(updated[:1], updated)
+	assert.Equal(t, []string{"deletePet", "getPet"}, diff.Added)
+	assert.Empty(t, diff.Removed)
+	assert.Empty(t, diff.Renamed)
+	assert.False(t, diff.Breaking())
+
+	assert.True(t, DiffActions(previous, previous).Empty())
+}
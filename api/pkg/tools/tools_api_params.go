@@ -0,0 +1,212 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// how many times we ask the LLM for the parameters of an action before
+// giving up, every retry tells it what was wrong with the last answer
+const maxParamsAttempts = 3
+
+// ParameterProblem is one thing wrong with the parameters the LLM came up
+// with for an action
+type ParameterProblem struct {
+	Name    string `json:"name"`
+	In      string `json:"in"` // path, query or body
+	Problem string `json:"problem"`
+}
+
+// ParameterValidationError is sent back to the LLM as JSON so that it can
+// fix the parameters before we call the API
+type ParameterValidationError struct {
+	Action   string             `json:"action"`
+	Problems []ParameterProblem `json:"problems"`
+}
+
+func (e *ParameterValidationError) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		problems = append(problems, fmt.Sprintf("%s parameter '%s' %s", p.In, p.Name, p.Problem))
+	}
+	return fmt.Sprintf("invalid parameters for action %s: %s", e.Action, strings.Join(problems, "; "))
+}
+
+func (e *ParameterValidationError) feedback() string {
+	bts, err := json.Marshal(e)
+	if err != nil {
+		return e.Error()
+	}
+	return fmt.Sprintf("These parameters are not valid for the API: %s\nFix the problems and respond again with only the JSON object of parameters.", string(bts))
+}
+
+func getOperation(schema *openapi3.T, action string) *openapi3.Operation {
+	for path, pathItem := range schema.Paths.Map() {
+		for method, operation := range pathItem.Operations() {
+			if getActionName(method, path, operation) == action {
+				return operation
+			}
+		}
+	}
+	return nil
+}
+
+// validateParams checks the parameters the LLM produced against the path and
+// query parameters and the JSON request body of the operation: that the
+// required ones are there and that the values have the right type and are
+// one of the allowed values. Anything the tool config already sets isn't
+// expected from the LLM.
+func validateParams(tool *types.Tool, action string, params map[string]interface{}) error {
+	loader := openapi3.NewLoader()
+
+	schema, err := loader.LoadFromData([]byte(tool.Config.API.Schema))
+	if err != nil {
+		return fmt.Errorf("failed to load openapi spec: %w", err)
+	}
+
+	operation := getOperation(schema, action)
+	if operation == nil {
+		return fmt.Errorf("failed to find operation for action %s", action)
+	}
+
+	var problems []ParameterProblem
+
+	for _, param := range operation.Parameters {
+		if param.Value == nil {
+			continue
+		}
+		p := param.Value
+
+		switch p.In {
+		case openapi3.ParameterInPath, openapi3.ParameterInQuery:
+		default:
+			// headers and cookies come from the tool config
+			continue
+		}
+
+		if _, ok := tool.Config.API.Query[p.Name]; ok && p.In == openapi3.ParameterInQuery {
+			continue
+		}
+
+		value, ok := params[p.Name]
+		if !ok || value == nil {
+			// path parameters are always required whatever the schema says
+			if p.Required || p.In == openapi3.ParameterInPath {
+				problems = append(problems, ParameterProblem{Name: p.Name, In: p.In, Problem: "is required"})
+			}
+			continue
+		}
+
+		if p.Schema == nil || p.Schema.Value == nil {
+			continue
+		}
+
+		if problem := validateParamValue(p.Schema.Value, value); problem != "" {
+			problems = append(problems, ParameterProblem{Name: p.Name, In: p.In, Problem: problem})
+		}
+	}
+
+	bodyTmpl, err := actionBodyTemplate(tool, action)
+	if err != nil {
+		return err
+	}
+	if bodyTmpl != nil {
+		// the body is the template so its schema isn't what the LLM fills in
+		for _, name := range bodyTmpl.missing(params) {
+			problems = append(problems, ParameterProblem{Name: name, In: "body", Problem: "is required"})
+		}
+	} else {
+		problems = append(problems, validateBodyParams(operation, params)...)
+	}
+
+	if len(problems) > 0 {
+		return &ParameterValidationError{
+			Action:   action,
+			Problems: problems,
+		}
+	}
+
+	return nil
+}
+
+// path and query parameters end up as strings in the URL so we don't mind
+// whether the LLM sent "10" or 10 as long as the value itself is right
+func validateParamValue(schema *openapi3.Schema, value interface{}) string {
+	err := schema.VisitJSON(value)
+	if err == nil {
+		return ""
+	}
+
+	var alternative interface{}
+	if s, ok := value.(string); ok {
+		if json.Unmarshal([]byte(s), &alternative) != nil {
+			alternative = nil
+		}
+	} else {
+		alternative = fmt.Sprintf("%v", value)
+	}
+
+	if alternative != nil && schema.VisitJSON(alternative) == nil {
+		return ""
+	}
+
+	return schemaProblem(err)
+}
+
+// the LLM gives us the properties of the request body alongside the other
+// parameters, the body goes over the wire as JSON so the types must match
+func validateBodyParams(operation *openapi3.Operation, params map[string]interface{}) []ParameterProblem {
+	if operation.RequestBody == nil || operation.RequestBody.Value == nil {
+		return nil
+	}
+
+	content := operation.RequestBody.Value.Content.Get("application/json")
+	if content == nil || content.Schema == nil || content.Schema.Value == nil {
+		return nil
+	}
+	body := content.Schema.Value
+
+	var problems []ParameterProblem
+
+	for _, name := range body.Required {
+		if value, ok := params[name]; !ok || value == nil {
+			problems = append(problems, ParameterProblem{Name: name, In: "body", Problem: "is required"})
+		}
+	}
+
+	names := make([]string, 0, len(body.Properties))
+	for name := range body.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property := body.Properties[name]
+		value, ok := params[name]
+		if !ok || value == nil || property == nil || property.Value == nil {
+			continue
+		}
+
+		if err := property.Value.VisitJSON(value); err != nil {
+			problems = append(problems, ParameterProblem{Name: name, In: "body", Problem: schemaProblem(err)})
+		}
+	}
+
+	return problems
+}
+
+// the full schema error dumps the schema and the value, the reason is all
+// the LLM needs
+func schemaProblem(err error) string {
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) && schemaErr.Reason != "" {
+		return schemaErr.Reason
+	}
+	return err.Error()
+}
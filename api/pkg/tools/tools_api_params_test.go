@@ -0,0 +1,225 @@
+package tools
+
+import (
+	"context"
+	"testing"
+
+	openai "github.com/lukemarsden/go-openai2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/config"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+const paramsApiSpec = `openapi: "3.0.0"
+info:
+  version: 1.0.0
+  title: Pet orders
+paths:
+  /pets/{petId}/orders:
+    get:
+      operationId: listOrders
+      parameters:
+        - name: petId
+          in: path
+          required: true
+          schema:
+            type: integer
+        - name: status
+          in: query
+          required: true
+          schema:
+            type: string
+            enum: [placed, approved, delivered]
+        - name: limit
+          in: query
+          schema:
+            type: integer
+            maximum: 100
+        - name: appid
+          in: query
+          required: true
+          schema:
+            type: string
+      responses:
+        '200':
+          description: The orders
+    post:
+      operationId: createOrder
+      parameters:
+        - name: petId
+          in: path
+          required: true
+          schema:
+            type: integer
+      requestBody:
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [quantity]
+              properties:
+                quantity:
+                  type: integer
+                complete:
+                  type: boolean
+      responses:
+        '201':
+          description: The order
+`
+
+func newParamsTool() *types.Tool {
+	return &types.Tool{
+		Name:     "orders",
+		ToolType: types.ToolTypeAPI,
+		Config: types.ToolConfig{
+			API: &types.ToolApiConfig{
+				URL:    "https://example.com",
+				Schema: paramsApiSpec,
+				// set by the tool so the LLM doesn't need to
+				Query: map[string]string{"appid": "app123"},
+			},
+		},
+	}
+}
+
+func Test_validateParams(t *testing.T) {
+	tool := newParamsTool()
+
+	tests := []struct {
+		name     string
+		action   string
+		params   string
+		problems []ParameterProblem
+	}{
+		{
+			name:   "valid",
+			action: "listOrders",
+			params: `{"petId": 12, "status": "placed", "limit": 10}`,
+		},
+		{
+			name:   "valid_strings_for_numbers",
+			action: "listOrders",
+			params: `{"petId": "12", "status": "placed", "limit": "10"}`,
+		},
+		{
+			name:   "missing_required",
+			action: "listOrders",
+			params: `{"limit": 10}`,
+			problems: []ParameterProblem{
+				{Name: "petId", In: "path", Problem: "is required"},
+				{Name: "status", In: "query", Problem: "is required"},
+			},
+		},
+		{
+			name:   "valid_body",
+			action: "createOrder",
+			params: `{"petId": 12, "quantity": 2, "complete": false}`,
+		},
+		{
+			name:   "missing_body_property",
+			action: "createOrder",
+			params: `{"petId": 12, "complete": false}`,
+			problems: []ParameterProblem{
+				{Name: "quantity", In: "body", Problem: "is required"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := decodeParams(tt.params)
+			require.NoError(t, err)
+
+			err = validateParams(tool, tt.action, params)
+
+			if tt.problems == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			var validationErr *ParameterValidationError
+			require.ErrorAs(t, err, &validationErr)
+			assert.Equal(t, tt.action, validationErr.Action)
+			assert.Equal(t, tt.problems, validationErr.Problems)
+		})
+	}
+}
+
+func Test_validateParams_WrongValues(t *testing.T) {
+	params, err := decodeParams(`{"petId": "rex", "status": "lost", "limit": 500}`)
+	require.NoError(t, err)
+
+	err = validateParams(newParamsTool(), "listOrders", params)
+
+	var validationErr *ParameterValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Len(t, validationErr.Problems, 3)
+	for _, problem := range validationErr.Problems {
+		assert.NotEmpty(t, problem.Problem)
+		// just the reason, not the whole schema
+		assert.NotContains(t, problem.Problem, "Schema:")
+	}
+}
+
+func Test_validateParams_BodyType(t *testing.T) {
+	params, err := decodeParams(`{"petId": 12, "quantity": "2"}`)
+	require.NoError(t, err)
+
+	// the body is sent as JSON so a string is not an integer
+	err = validateParams(newParamsTool(), "createOrder", params)
+
+	var validationErr *ParameterValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Len(t, validationErr.Problems, 1)
+	assert.Equal(t, "quantity", validationErr.Problems[0].Name)
+	assert.Equal(t, "body", validationErr.Problems[0].In)
+}
+
+type fakeParamsClient struct {
+	answers  []string
+	requests []openai.ChatCompletionRequest
+}
+
+func (f *fakeParamsClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	f.requests = append(f.requests, request)
+	answer := f.answers[0]
+	if len(f.answers) > 1 {
+		f.answers = f.answers[1:]
+	}
+	return openai.ChatCompletionResponse{
+		Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: answer}}},
+	}, nil
+}
+
+func Test_getAPIRequestParameters_SelfCorrects(t *testing.T) {
+	client := &fakeParamsClient{answers: []string{
+		`{"petId": 12, "status": "lost"}`,
+		`{"petId": 12, "status": "placed"}`,
+	}}
+	strategy := &ChainStrategy{cfg: &config.ServerConfig{}, apiClient: client}
+
+	params, err := strategy.getAPIRequestParameters(context.Background(), newParamsTool(), nil, "orders placed for pet 12", "listOrders")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"petId": float64(12), "status": "placed"}, params)
+
+	require.Len(t, client.requests, 2)
+	retry := client.requests[1].Messages
+	require.Len(t, retry, 4)
+	assert.Equal(t, openai.ChatMessageRoleAssistant, retry[2].Role)
+	assert.Equal(t, `{"petId": 12, "status": "lost"}`, retry[2].Content)
+	assert.Contains(t, retry[3].Content, `"name":"status","in":"query"`)
+}
+
+func Test_getAPIRequestParameters_GivesUp(t *testing.T) {
+	client := &fakeParamsClient{answers: []string{`{"status": "placed"}`}}
+	strategy := &ChainStrategy{cfg: &config.ServerConfig{}, apiClient: client}
+
+	_, err := strategy.getAPIRequestParameters(context.Background(), newParamsTool(), nil, "orders placed", "listOrders")
+
+	var validationErr *ParameterValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "invalid parameters for action listOrders: path parameter 'petId' is required", err.Error())
+	assert.Len(t, client.requests, maxParamsAttempts)
+}
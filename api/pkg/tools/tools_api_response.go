@@ -10,7 +10,7 @@ import (
 	openai "github.com/lukemarsden/go-openai2"
 )
 
-func (c *ChainStrategy) interpretResponse(ctx context.Context, tool *types.Tool, currentMessage string, resp *http.Response) (*RunActionResponse, error) {
+func (c *ChainStrategy) interpretResponse(ctx context.Context, tool *types.Tool, action, currentMessage string, resp *http.Response) (*RunActionResponse, error) {
 	bts, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
@@ -20,10 +20,12 @@ func (c *ChainStrategy) interpretResponse(ctx context.Context, tool *types.Tool,
 		return c.handleErrorResponse(ctx, tool, resp.StatusCode, bts)
 	}
 
-	return c.handleSuccessResponse(ctx, tool, currentMessage, resp.StatusCode, bts)
+	return c.handleSuccessResponse(ctx, tool, action, currentMessage, resp.StatusCode, bts)
 }
 
-func (c *ChainStrategy) handleSuccessResponse(ctx context.Context, tool *types.Tool, currentMessage string, statusCode int, body []byte) (*RunActionResponse, error) {
+// the model is given the response after the action's transform, the raw
+// message keeps all of it
+func (c *ChainStrategy) handleSuccessResponse(ctx context.Context, tool *types.Tool, action, currentMessage string, statusCode int, body []byte) (*RunActionResponse, error) {
 	messages := []openai.ChatCompletionMessage{
 		{
 			Role:    openai.ChatMessageRoleSystem,
@@ -35,7 +37,7 @@ func (c *ChainStrategy) handleSuccessResponse(ctx context.Context, tool *types.T
 		},
 		{
 			Role:    openai.ChatMessageRoleUser,
-			Content: fmt.Sprintf("Here is the response from the apis:\n%s", string(body)),
+			Content: fmt.Sprintf("Here is the response from the apis:\n%s", responseForModel(tool, action, body)),
 		},
 		{
 			Role:    openai.ChatMessageRoleUser,
@@ -48,7 +50,7 @@ func (c *ChainStrategy) handleSuccessResponse(ctx context.Context, tool *types.T
 		openai.ChatCompletionRequest{
 			Stream:    false,
 			MaxTokens: 500,
-			Model:     c.cfg.Tools.Model,
+			Model:     c.model,
 			Messages:  messages,
 		},
 	)
@@ -83,7 +85,7 @@ func (c *ChainStrategy) handleErrorResponse(ctx context.Context, tool *types.Too
 		openai.ChatCompletionRequest{
 			Stream:    false,
 			MaxTokens: 100,
-			Model:     c.cfg.Tools.Model,
+			Model:     c.model,
 			Messages:  messages,
 		},
 	)
@@ -2,17 +2,25 @@ package tools
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/helixml/helix/api/pkg/types"
 	"github.com/rs/zerolog/log"
 )
 
+// how long we wait for an API tool to respond when the tool doesn't set a timeout
+const defaultActionTimeout = 30 * time.Second
+
 type RunActionResponse struct {
 	Message    string `json:"message"`     // Interpreted message
 	RawMessage string `json:"raw_message"` // Raw message from the API
 	Error      string `json:"error"`
+	// What we sent to the API (redacted), nil if we never got that far
+	Request *types.ToolActionRequest `json:"request"`
 }
 
 func (c *ChainStrategy) RunAction(ctx context.Context, tool *types.Tool, history []*types.Interaction, currentMessage, action string) (*RunActionResponse, error) {
@@ -61,11 +69,25 @@ func (c *ChainStrategy) runApiAction(ctx context.Context, tool *types.Tool, hist
 
 	started = time.Now()
 
-	req, err := c.prepareRequest(ctx, tool, action, params)
+	timeout := getActionTimeout(tool, action)
+
+	// Only the API call is bound by the timeout, interpreting the
+	// response still uses the parent context
+	reqCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := c.prepareRequest(reqCtx, tool, action, stringifyParams(params))
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare request: %w", err)
 	}
 
+	err = setRequestBody(req, tool, action, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare request body: %w", err)
+	}
+
+	request := describeRequest(tool, req)
+
 	log.Info().
 		Str("tool", tool.Name).
 		Str("action", action).
@@ -77,7 +99,22 @@ func (c *ChainStrategy) runApiAction(ctx context.Context, tool *types.Tool, hist
 	// Make API call
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make api call: %w", err)
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			log.Warn().
+				Str("tool", tool.Name).
+				Str("action", action).
+				Dur("timeout", timeout).
+				Msg("API call timed out")
+
+			resp, err := c.handleTimeout(ctx, tool, action, timeout)
+			if err != nil {
+				return failedActionResponse(request, err), err
+			}
+			resp.Request = request
+			return resp, nil
+		}
+		err = fmt.Errorf("failed to make api call (%s %s): %w", request.Method, request.URL, err)
+		return failedActionResponse(request, err), err
 	}
 
 	log.Info().
@@ -89,5 +126,68 @@ func (c *ChainStrategy) runApiAction(ctx context.Context, tool *types.Tool, hist
 
 	defer resp.Body.Close()
 
-	return c.interpretResponse(ctx, tool, currentMessage, resp)
+	actionResp, err := c.interpretResponse(ctx, tool, action, currentMessage, resp)
+	if err != nil {
+		return failedActionResponse(request, err), err
+	}
+	actionResp.Request = request
+
+	return actionResp, nil
+}
+
+// once the request has been built we hand it back with the error so the
+// failed call can be looked into
+func failedActionResponse(request *types.ToolActionRequest, err error) *RunActionResponse {
+	return &RunActionResponse{
+		Error:   err.Error(),
+		Request: request,
+	}
+}
+
+// getActionTimeout returns the timeout for the action, the action level
+// timeout takes precedence over the tool level one
+func getActionTimeout(tool *types.Tool, action string) time.Duration {
+	for _, ac := range tool.Config.API.Actions {
+		if ac.Name == action && ac.Timeout > 0 {
+			return time.Duration(ac.Timeout)
+		}
+	}
+
+	if tool.Config.API.Timeout > 0 {
+		return time.Duration(tool.Config.API.Timeout)
+	}
+
+	return defaultActionTimeout
+}
+
+type actionTimeoutError struct {
+	Error   string `json:"error"`
+	Action  string `json:"action"`
+	Timeout string `json:"timeout"`
+	Message string `json:"message"`
+}
+
+// handleTimeout turns a timed out API call into an error response
+// that the LLM can explain to the user
+func (c *ChainStrategy) handleTimeout(ctx context.Context, tool *types.Tool, action string, timeout time.Duration) (*RunActionResponse, error) {
+	timeoutErr := actionTimeoutError{
+		Error:   "timeout",
+		Action:  action,
+		Timeout: timeout.String(),
+		Message: fmt.Sprintf("the API did not respond within %s", timeout),
+	}
+
+	body, err := json.Marshal(timeoutErr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal timeout error: %w", err)
+	}
+
+	resp, err := c.handleErrorResponse(ctx, tool, http.StatusGatewayTimeout, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Error = timeoutErr.Message
+
+	return resp, nil
 }
@@ -0,0 +1,99 @@
+package tools
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+// the base URLs an operation can be called on - its own servers win, then
+// its path's and then the spec's top level servers (specLevel is true for
+// those, which is the only level the tool config can override)
+// servers without a URL don't count so a level with only those falls back
+// to the next one
+func declaredServers(schema *openapi3.T, pathItem *openapi3.PathItem, operation *openapi3.Operation) (servers []string, specLevel bool) {
+	if operation.Servers != nil {
+		if urls := serverURLs(*operation.Servers); len(urls) > 0 {
+			return urls, false
+		}
+	}
+	if urls := serverURLs(pathItem.Servers); len(urls) > 0 {
+		return urls, false
+	}
+	return serverURLs(schema.Servers), true
+}
+
+// server URLs with any {variables} filled in with their defaults
+func serverURLs(servers openapi3.Servers) []string {
+	urls := make([]string, 0, len(servers))
+	for _, server := range servers {
+		if server == nil || server.URL == "" {
+			continue
+		}
+		url := server.URL
+		for name, variable := range server.Variables {
+			if variable != nil {
+				url = strings.ReplaceAll(url, "{"+name+"}", variable.Default)
+			}
+		}
+		urls = append(urls, url)
+	}
+	return urls
+}
+
+// pick the base URL to call an operation on:
+//   - servers declared on the operation or its path are used as they are,
+//     the tool URL only picks between them when it is one of them
+//   - otherwise the tool URL overrides the spec's servers
+//   - otherwise the tool picks one of the spec's servers by index (the first
+//     by default)
+func resolveServerURL(config *types.ToolApiConfig, servers []string, specLevel bool) (string, error) {
+	if !specLevel && len(servers) > 0 {
+		for _, server := range servers {
+			if server == config.URL {
+				return server, nil
+			}
+		}
+		return servers[0], nil
+	}
+
+	if config.URL != "" {
+		return config.URL, nil
+	}
+
+	if len(servers) == 0 {
+		return "", fmt.Errorf("no server to call, set a URL on the tool or add servers to the schema")
+	}
+
+	if config.ServerIndex < 0 || config.ServerIndex >= len(servers) {
+		return "", fmt.Errorf("server index %d is out of range, the schema has %d servers", config.ServerIndex, len(servers))
+	}
+
+	return servers[config.ServerIndex], nil
+}
+
+// validateServers makes sure every operation in the schema has a server we
+// can call it on
+func validateServers(config *types.ToolApiConfig, schema *openapi3.T) error {
+	var problems []string
+
+	for path, pathItem := range schema.Paths.Map() {
+		for method, operation := range pathItem.Operations() {
+			servers, specLevel := declaredServers(schema, pathItem, operation)
+			_, err := resolveServerURL(config, servers, specLevel)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s %s: %s", strings.ToUpper(method), path, err.Error()))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid servers: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
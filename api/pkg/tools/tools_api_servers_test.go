@@ -0,0 +1,186 @@
+package tools
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/helixml/helix/api/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const multiServerSpec = `
+openapi: "3.0.0"
+info:
+  version: 1.0.0
+  title: Multi server
+servers:
+  - url: https://{region}.example.com/v1
+    variables:
+      region:
+        default: eu
+  - url: https://staging.example.com/v1
+paths:
+  /pets:
+    get:
+      operationId: listPets
+      summary: List all pets
+  /uploads:
+    servers:
+      - url: https://uploads.example.com
+      - url: https://uploads-backup.example.com
+    post:
+      operationId: createUpload
+      summary: Upload a file
+    get:
+      operationId: listUploads
+      summary: List the uploads
+      servers:
+        - url: https://reads.example.com/
+`
+
+const noServerSpec = `
+openapi: "3.0.0"
+info:
+  version: 1.0.0
+  title: No server
+paths:
+  /pets:
+    get:
+      operationId: listPets
+      summary: List all pets
+`
+
+const emptyPathServerSpec = `
+openapi: "3.0.0"
+info:
+  version: 1.0.0
+  title: Empty path server
+servers:
+  - url: https://api.example.com
+paths:
+  /pets:
+    servers:
+      - url: ""
+    get:
+      operationId: listPets
+      summary: List all pets
+      servers:
+        - url: ""
+`
+
+func Test_GetActionsFromSchema_Servers(t *testing.T) {
+	actions, err := GetActionsFromSchema(multiServerSpec)
+	require.NoError(t, err)
+
+	servers := map[string][]string{}
+	for _, action := range actions {
+		servers[action.Name] = action.Servers
+	}
+
+	// only actions with their own servers list them
+	assert.Nil(t, servers["listPets"])
+	assert.Equal(t, []string{"https://uploads.example.com", "https://uploads-backup.example.com"}, servers["createUpload"])
+	assert.Equal(t, []string{"https://reads.example.com/"}, servers["listUploads"])
+}
+
+func Test_prepareRequest_Servers(t *testing.T) {
+	strategy := &ChainStrategy{}
+
+	tests := []struct {
+		name   string
+		config types.ToolApiConfig
+		action string
+		want   string
+	}{
+		{
+			name:   "first spec server with variables filled in",
+			action: "listPets",
+			want:   "https://eu.example.com/v1/pets",
+		},
+		{
+			name:   "spec server picked by index",
+			config: types.ToolApiConfig{ServerIndex: 1},
+			action: "listPets",
+			want:   "https://staging.example.com/v1/pets",
+		},
+		{
+			name:   "URL overrides the spec servers",
+			config: types.ToolApiConfig{URL: "https://proxy.example.com"},
+			action: "listPets",
+			want:   "https://proxy.example.com/pets",
+		},
+		{
+			name:   "path servers win over the URL",
+			config: types.ToolApiConfig{URL: "https://proxy.example.com"},
+			action: "createUpload",
+			want:   "https://uploads.example.com/uploads",
+		},
+		{
+			name:   "URL picks one of the path servers",
+			config: types.ToolApiConfig{URL: "https://uploads-backup.example.com"},
+			action: "createUpload",
+			want:   "https://uploads-backup.example.com/uploads",
+		},
+		{
+			name:   "operation servers win over the path servers",
+			action: "listUploads",
+			want:   "https://reads.example.com/uploads",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.Schema = multiServerSpec
+			tool := &types.Tool{ToolType: types.ToolTypeAPI, Config: types.ToolConfig{API: &config}}
+
+			req, err := strategy.prepareRequest(context.Background(), tool, tt.action, map[string]string{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, req.URL.String())
+		})
+	}
+}
+
+func Test_validateServers(t *testing.T) {
+	loader := openapi3.NewLoader()
+
+	schema, err := loader.LoadFromData([]byte(noServerSpec))
+	require.NoError(t, err)
+
+	err = validateServers(&types.ToolApiConfig{}, schema)
+	assert.ErrorContains(t, err, "GET /pets: no server to call")
+
+	assert.NoError(t, validateServers(&types.ToolApiConfig{URL: "https://example.com"}, schema))
+
+	schema, err = loader.LoadFromData([]byte(multiServerSpec))
+	require.NoError(t, err)
+
+	assert.NoError(t, validateServers(&types.ToolApiConfig{ServerIndex: 1}, schema))
+
+	// the path level servers don't care about the index
+	err = validateServers(&types.ToolApiConfig{ServerIndex: 2}, schema)
+	assert.EqualError(t, err, "invalid servers: GET /pets: server index 2 is out of range, the schema has 2 servers")
+}
+
+func Test_resolveServerURL_EmptyServers(t *testing.T) {
+	loader := openapi3.NewLoader()
+
+	schema, err := loader.LoadFromData([]byte(emptyPathServerSpec))
+	require.NoError(t, err)
+
+	// servers without a URL fall back to the spec's servers
+	pathItem := schema.Paths.Find("/pets")
+	servers, specLevel := declaredServers(schema, pathItem, pathItem.Get)
+	assert.True(t, specLevel)
+	assert.Equal(t, []string{"https://api.example.com"}, servers)
+
+	url, err := resolveServerURL(&types.ToolApiConfig{}, servers, specLevel)
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.example.com", url)
+
+	// and no servers at all is an error rather than a panic
+	_, err = resolveServerURL(&types.ToolApiConfig{}, nil, false)
+	assert.ErrorContains(t, err, "no server to call")
+}
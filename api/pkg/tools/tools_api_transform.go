@@ -0,0 +1,271 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/helixml/helix/api/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+// the operation extension that sets an action's ResponseTransform
+const responseTransformExtension = "x-helix-response-transform"
+
+// what the model is told when we cut a response short
+const truncatedResponseSuffix = "\n...(response truncated)"
+
+// a step of a response transform
+type transformSegment struct {
+	// a key of an object - more than one picks just those keys out of the
+	// object rather than listing their values like JSONPath does
+	names []string
+	// an index of an array, negative counts back from the end
+	index int
+	// every value of an array or object
+	wildcard bool
+}
+
+// a compiled response transform, the subset of JSONPath we support is:
+//
+//	$                   the whole response
+//	.name or ['name']   a key of an object
+//	[0] or [-1]         an index of an array
+//	.* or [*]           every value of an array or object
+//	['name','url']      just those keys of an object
+//
+// e.g. $.results[*]['title','url'] is the title and url of every result
+type responseTransform struct {
+	segments []transformSegment
+}
+
+func parseResponseTransform(expr string) (*responseTransform, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("response transform %q must start with $", expr)
+	}
+
+	transform := &responseTransform{}
+	rest := expr[1:]
+	for rest != "" {
+		var (
+			segment transformSegment
+			err     error
+		)
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : end+1]
+			rest = rest[end+1:]
+			switch name {
+			case "":
+				return nil, fmt.Errorf("response transform %q has an empty key", expr)
+			case "*":
+				segment.wildcard = true
+			default:
+				segment.names = []string{name}
+			}
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("response transform %q has an unclosed [", expr)
+			}
+			segment, err = parseBracketSegment(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("response transform %q: %w", expr, err)
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("response transform %q: unexpected %q", expr, rest[0])
+		}
+		transform.segments = append(transform.segments, segment)
+	}
+
+	return transform, nil
+}
+
+func parseBracketSegment(inner string) (transformSegment, error) {
+	inner = strings.TrimSpace(inner)
+	if inner == "*" {
+		return transformSegment{wildcard: true}, nil
+	}
+
+	if inner != "" && inner[0] != '\'' && inner[0] != '"' {
+		index, err := strconv.Atoi(inner)
+		if err != nil {
+			return transformSegment{}, fmt.Errorf("invalid index %q", inner)
+		}
+		return transformSegment{index: index}, nil
+	}
+
+	var names []string
+	for _, part := range strings.Split(inner, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) < 2 || (part[0] != '\'' && part[0] != '"') || part[len(part)-1] != part[0] {
+			return transformSegment{}, fmt.Errorf("invalid key %q, keys must be quoted", part)
+		}
+		names = append(names, part[1:len(part)-1])
+	}
+	return transformSegment{names: names}, nil
+}
+
+// whether the transform can pick out more than one value, in which case the
+// result is always a list
+func (t *responseTransform) multiple() bool {
+	for _, segment := range t.segments {
+		if segment.wildcard {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *responseTransform) apply(value any) any {
+	values := []any{value}
+	for _, segment := range t.segments {
+		var next []any
+		for _, value := range values {
+			next = append(next, segment.apply(value)...)
+		}
+		values = next
+	}
+
+	if t.multiple() {
+		if values == nil {
+			return []any{}
+		}
+		return values
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+func (s transformSegment) apply(value any) []any {
+	switch {
+	case s.wildcard:
+		switch v := value.(type) {
+		case []any:
+			return v
+		case map[string]any:
+			// in a stable order so the model sees the same thing each time
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			values := make([]any, 0, len(v))
+			for _, key := range keys {
+				values = append(values, v[key])
+			}
+			return values
+		}
+	case len(s.names) == 1:
+		if v, ok := value.(map[string]any); ok {
+			if field, ok := v[s.names[0]]; ok {
+				return []any{field}
+			}
+		}
+	case len(s.names) > 1:
+		if v, ok := value.(map[string]any); ok {
+			picked := map[string]any{}
+			for _, name := range s.names {
+				if field, ok := v[name]; ok {
+					picked[name] = field
+				}
+			}
+			return []any{picked}
+		}
+	default:
+		if v, ok := value.([]any); ok {
+			index := s.index
+			if index < 0 {
+				index += len(v)
+			}
+			if index >= 0 && index < len(v) {
+				return []any{v[index]}
+			}
+		}
+	}
+	return nil
+}
+
+// the response transform set on the operation, if any
+func getResponseTransform(operation *openapi3.Operation) string {
+	value, ok := operation.Extensions[responseTransformExtension]
+	if !ok {
+		return ""
+	}
+	expr, ok := value.(string)
+	if !ok {
+		// a transform that isn't a string is caught by ValidateActions
+		return fmt.Sprint(value)
+	}
+	return expr
+}
+
+// the part of a successful response the model gets to see - the action's
+// transform picks out what it asks for and then anything longer than the
+// tool's limit is cut short, a response we can't transform (e.g. it isn't
+// JSON) is passed on as it is
+func responseForModel(tool *types.Tool, action string, body []byte) string {
+	response := string(body)
+
+	for _, ac := range tool.Config.API.Actions {
+		if ac.Name != action || ac.ResponseTransform == "" {
+			continue
+		}
+		transformed, err := transformResponse(ac.ResponseTransform, body)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("tool", tool.Name).
+				Str("action", action).
+				Msg("failed to transform API response, passing it on as it is")
+			break
+		}
+		response = transformed
+		break
+	}
+
+	return truncateResponse(response, tool.Config.API.MaxResponseSize)
+}
+
+func transformResponse(expr string, body []byte) (string, error) {
+	transform, err := parseResponseTransform(expr)
+	if err != nil {
+		return "", err
+	}
+
+	var value any
+	err = json.Unmarshal(body, &value)
+	if err != nil {
+		return "", fmt.Errorf("response is not JSON: %w", err)
+	}
+
+	transformed, err := json.Marshal(transform.apply(value))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode transformed response: %w", err)
+	}
+	return string(transformed), nil
+}
+
+// keep to maxSize bytes without splitting a character, zero means no limit
+func truncateResponse(response string, maxSize int) string {
+	if maxSize <= 0 || len(response) <= maxSize {
+		return response
+	}
+	cut := maxSize
+	for cut > 0 && !utf8.RuneStart(response[cut]) {
+		cut--
+	}
+	return response[:cut] + truncatedResponseSuffix
+}
@@ -0,0 +1,116 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/helixml/helix/api/pkg/types"
+)
+
+const searchResponse = `{
+	"total": 2,
+	"debug": {"took_ms": 12},
+	"results": [
+		{"title": "First", "url": "https://example.com/1", "score": 0.9},
+		{"title": "Second", "url": "https://example.com/2", "score": 0.4}
+	]
+}`
+
+func Test_transformResponse(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{expr: "$", want: `{"debug":{"took_ms":12},"results":[{"score":0.9,"title":"First","url":"https://example.com/1"},{"score":0.4,"title":"Second","url":"https://example.com/2"}],"total":2}`},
+		{expr: "$.total", want: `2`},
+		{expr: "$['total']", want: `2`},
+		{expr: "$.results[0].title", want: `"First"`},
+		{expr: "$.results[-1].title", want: `"Second"`},
+		{expr: "$.results[*].title", want: `["First","Second"]`},
+		{expr: "$.results.*.url", want: `["https://example.com/1","https://example.com/2"]`},
+		{expr: "$.results[*]['title','url']", want: `[{"title":"First","url":"https://example.com/1"},{"title":"Second","url":"https://example.com/2"}]`},
+		{expr: "$.missing", want: `null`},
+		{expr: "$.missing[*].title", want: `[]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := transformResponse(tt.expr, []byte(searchResponse))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_parseResponseTransform_Invalid(t *testing.T) {
+	for _, expr := range []string{"results", "$.", "$.results[", "$.results[abc]", "$[title]", "$x"} {
+		_, err := parseResponseTransform(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func Test_responseForModel(t *testing.T) {
+	tool := &types.Tool{
+		Name: "search",
+		Config: types.ToolConfig{API: &types.ToolApiConfig{
+			Actions: []*types.ToolApiAction{
+				{Name: "search", ResponseTransform: "$.results[*].title"},
+				{Name: "raw"},
+			},
+		}},
+	}
+
+	assert.Equal(t, `["First","Second"]`, responseForModel(tool, "search", []byte(searchResponse)))
+	// no transform and no limit is the response as it is
+	assert.Equal(t, searchResponse, responseForModel(tool, "raw", []byte(searchResponse)))
+	// a response that isn't JSON can't be transformed so is passed on
+	assert.Equal(t, "not json", responseForModel(tool, "search", []byte("not json")))
+
+	tool.Config.API.MaxResponseSize = 10
+	assert.Equal(t, `["First","`+truncatedResponseSuffix, responseForModel(tool, "search", []byte(searchResponse)))
+}
+
+func Test_truncateResponse(t *testing.T) {
+	assert.Equal(t, "short", truncateResponse("short", 0))
+	assert.Equal(t, "short", truncateResponse("short", 5))
+	// never split a character
+	assert.Equal(t, "caf"+truncatedResponseSuffix, truncateResponse("café au lait", 4))
+}
+
+const responseTransformSpec = `
+openapi: "3.0.0"
+info:
+  version: 1.0.0
+  title: Search
+servers:
+  - url: https://search.example.com
+paths:
+  /search:
+    get:
+      operationId: search
+      summary: Search the web
+      x-helix-response-transform: "$.results[*]['title','url']"
+  /broken:
+    get:
+      operationId: broken
+      summary: A bad transform
+      x-helix-response-transform: "results"
+`
+
+func Test_GetActionsFromSchema_ResponseTransform(t *testing.T) {
+	actions, err := GetActionsFromSchema(responseTransformSpec)
+	require.NoError(t, err)
+
+	transforms := map[string]string{}
+	for _, action := range actions {
+		transforms[action.Name] = action.ResponseTransform
+	}
+	assert.Equal(t, "$.results[*]['title','url']", transforms["search"])
+
+	err = ValidateActions(actions)
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "GET /broken: invalid x-helix-response-transform"), err.Error())
+}
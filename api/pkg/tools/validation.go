@@ -3,6 +3,8 @@ package tools
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/getkin/kin-openapi/openapi3"
@@ -26,12 +28,13 @@ func (c *ChainStrategy) validateAndDefaultAPI(ctx context.Context, tool *types.T
 		return nil, fmt.Errorf("failed to load OpenAPI spec: %w", err)
 	}
 
-	if tool.Config.API.URL == "" {
-		if len(schema.Servers) > 0 {
-			tool.Config.API.URL = schema.Servers[0].URL
-		} else {
-			return nil, fmt.Errorf("API URL is required for API tools")
-		}
+	// the URL stays empty unless the user overrides the servers so picking
+	// a different server later isn't stuck with the first one
+	tool.Config.API.Servers = serverURLs(schema.Servers)
+
+	err = validateServers(tool.Config.API, schema)
+	if err != nil {
+		return nil, err
 	}
 
 	// Validate that all paths have operation IDs set
@@ -55,9 +58,74 @@ func (c *ChainStrategy) validateOperationIDs(ctx context.Context, tool *types.To
 			}
 
 			if operation.OperationID == "" {
-				operation.OperationID = strings.ToLower(fmt.Sprintf("%s%s", method, strings.ReplaceAll(path, "/", "_")))
+				operation.OperationID = getActionName(method, path, operation)
+			}
+		}
+	}
+
+	return nil
+}
+
+// action names end up in prompts and headers, so we keep them to letters,
+// digits, hyphens and underscores (snake_case operationIds are common),
+// starting and ending with a letter or digit
+const maxActionNameLength = 63
+
+var actionNameRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_-]*[A-Za-z0-9])?$`)
+
+// ValidateActions checks that every action has a non-empty, header-safe name and
+// that no two actions share a name, otherwise the planner can't tell which
+// operation to call
+func ValidateActions(actions []*types.ToolApiAction) error {
+	sorted := make([]*types.ToolApiAction, len(actions))
+	copy(sorted, actions)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Path == sorted[j].Path {
+			return sorted[i].Method < sorted[j].Method
+		}
+		return sorted[i].Path < sorted[j].Path
+	})
+
+	var problems []string
+
+	seen := make(map[string]*types.ToolApiAction)
+
+	for _, action := range sorted {
+		operation := fmt.Sprintf("%s %s", strings.ToUpper(action.Method), action.Path)
+
+		if action.Name == "" {
+			problems = append(problems, fmt.Sprintf("%s: action name is empty", operation))
+			continue
+		}
+
+		if len(action.Name) > maxActionNameLength || !actionNameRegexp.MatchString(action.Name) {
+			problems = append(problems, fmt.Sprintf("%s: action name '%s' must be at most %d letters, digits, hyphens or underscores and start and end with a letter or digit", operation, action.Name, maxActionNameLength))
+		}
+
+		if action.ResponseTransform != "" {
+			_, err := parseResponseTransform(action.ResponseTransform)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid %s: %s", operation, responseTransformExtension, err))
 			}
 		}
+
+		if action.BodyTemplate != "" {
+			_, err := parseBodyTemplate(action.BodyTemplate)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid %s: %s", operation, bodyTemplateExtension, err))
+			}
+		}
+
+		key := strings.ToLower(action.Name)
+		if other, ok := seen[key]; ok {
+			problems = append(problems, fmt.Sprintf("%s: action name '%s' is already used by %s %s", operation, action.Name, strings.ToUpper(other.Method), other.Path))
+			continue
+		}
+		seen[key] = action
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid operations in the schema: %s", strings.Join(problems, "; "))
 	}
 
 	return nil
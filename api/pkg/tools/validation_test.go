@@ -0,0 +1,60 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/helixml/helix/api/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ValidateActions(t *testing.T) {
+	actions, err := GetActionsFromSchema(petStoreApiSpec)
+	require.NoError(t, err)
+
+	assert.NoError(t, ValidateActions(actions))
+}
+
+func Test_ValidateActions_Invalid(t *testing.T) {
+	err := ValidateActions([]*types.ToolApiAction{
+		{Name: "listPets", Method: "get", Path: "/pets"},
+		{Name: "listpets", Method: "get", Path: "/pets/all"},
+		{Name: "", Method: "post", Path: "/pets"},
+		{Name: "show pet", Method: "get", Path: "/pets/{petId}"},
+		{Name: "delete_pet_", Method: "delete", Path: "/pets/{petId}"},
+	})
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "GET /pets/all: action name 'listpets' is already used by GET /pets")
+	assert.Contains(t, err.Error(), "POST /pets: action name is empty")
+	assert.Contains(t, err.Error(), "GET /pets/{petId}: action name 'show pet' must be")
+	assert.Contains(t, err.Error(), "DELETE /pets/{petId}: action name 'delete_pet_' must be")
+}
+
+func Test_ValidateActions_SnakeCase(t *testing.T) {
+	assert.NoError(t, ValidateActions([]*types.ToolApiAction{
+		{Name: "list_pets", Method: "get", Path: "/pets"},
+		{Name: "show_pet_by_id", Method: "get", Path: "/pets/{petId}"},
+	}))
+}
+
+func Test_getActionName_Fallback(t *testing.T) {
+	spec := `openapi: "3.0.0"
+info:
+  version: 1.0.0
+  title: Pets
+paths:
+  /pets/{petId}:
+    get:
+      summary: Info for a specific pet
+      responses:
+        '200':
+          description: A pet
+`
+	actions, err := GetActionsFromSchema(spec)
+	require.NoError(t, err)
+	require.Len(t, actions, 1)
+
+	assert.Equal(t, "get-pets-petid", actions[0].Name)
+	assert.NoError(t, ValidateActions(actions))
+}
@@ -2,6 +2,10 @@ package types
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
 type SessionOriginType string
@@ -91,6 +95,112 @@ func ValidateCloneTextType(cloneTextType string, acceptEmpty bool) (CloneInterac
 	}
 }
 
+type SessionWebhookEvent string
+
+const (
+	SessionWebhookEventCompleted SessionWebhookEvent = "session.completed"
+	SessionWebhookEventErrored   SessionWebhookEvent = "session.errored"
+)
+
+// we can only POST to absolute http(s) URLs outside our own network - a
+// host name is checked again when we connect as it can resolve to anything
+func ValidateWebhookURL(webhookURL string) error {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %s", err.Error())
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("invalid webhook url scheme: %s", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("invalid webhook url: missing host")
+	}
+	hostname := strings.ToLower(parsed.Hostname())
+	if hostname == "localhost" || strings.HasSuffix(hostname, ".localhost") {
+		return fmt.Errorf("invalid webhook url: %s is not allowed", hostname)
+	}
+	if ip := net.ParseIP(hostname); ip != nil && IsInternalIP(ip) {
+		return fmt.Errorf("invalid webhook url: %s is not allowed", hostname)
+	}
+	return nil
+}
+
+// loopback, link local (which has the cloud metadata services), private and
+// unspecified addresses are ours rather than the owner's so webhooks never
+// go to them
+func IsInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified()
+}
+
+type EvalScoreType string
+
+const (
+	EvalScoreTypeUser      EvalScoreType = "user"
+	EvalScoreTypeManual    EvalScoreType = "manual"
+	EvalScoreTypeAutomatic EvalScoreType = "automatic"
+)
+
+// scores are kept as strings so that "" (not rated) is different from "0.0"
+// but anything else has to be a number between 0 and 1
+func ValidateEvalScore(score string) error {
+	if score == "" {
+		return nil
+	}
+	value, err := strconv.ParseFloat(score, 64)
+	if err != nil {
+		return fmt.Errorf("invalid eval score %q: must be a number", score)
+	}
+	if !(value >= 0 && value <= 1) {
+		return fmt.Errorf("invalid eval score %q: must be between 0 and 1", score)
+	}
+	return nil
+}
+
+type FeedbackRating string
+
+const (
+	FeedbackRatingUp   FeedbackRating = "up"
+	FeedbackRatingDown FeedbackRating = "down"
+)
+
+// an empty rating is allowed, it clears the feedback
+func ValidateFeedbackRating(rating FeedbackRating) error {
+	switch rating {
+	case "", FeedbackRatingUp, FeedbackRatingDown:
+		return nil
+	default:
+		return fmt.Errorf("invalid feedback rating %q: must be %q or %q", rating, FeedbackRatingUp, FeedbackRatingDown)
+	}
+}
+
+type SessionExportFormat string
+
+const (
+	SessionExportFormatNone     SessionExportFormat = ""
+	SessionExportFormatOpenAI   SessionExportFormat = "openai"
+	SessionExportFormatShareGPT SessionExportFormat = "sharegpt"
+)
+
+func ValidateSessionExportFormat(format string, acceptEmpty bool) (SessionExportFormat, error) {
+	switch format {
+	case string(SessionExportFormatOpenAI):
+		return SessionExportFormatOpenAI, nil
+	case string(SessionExportFormatShareGPT):
+		return SessionExportFormatShareGPT, nil
+	default:
+		if acceptEmpty && format == string(SessionExportFormatNone) {
+			return SessionExportFormatNone, nil
+		} else {
+			return SessionExportFormatNone, fmt.Errorf("invalid export format: %s", format)
+		}
+	}
+}
+
 type InteractionState string
 
 const (
@@ -99,6 +209,23 @@ const (
 	InteractionStateEditing  InteractionState = "editing"
 	InteractionStateComplete InteractionState = "complete"
 	InteractionStateError    InteractionState = "error"
+	// the runner went away part way through, the message is what it had
+	// streamed by then and Error says why it stopped
+	InteractionStateInterrupted InteractionState = "interrupted"
+)
+
+// the stages an interaction goes through on its way to a result
+type InteractionStage string
+
+const (
+	// waiting in the queue for a runner
+	InteractionStageQueue InteractionStage = "queue"
+	// waiting for the runner to load the model
+	InteractionStageLoad InteractionStage = "load"
+	// waiting for the model's first output
+	InteractionStageFirstToken InteractionStage = "first_token"
+	// the model producing the rest of its output
+	InteractionStageGeneration InteractionStage = "generation"
 )
 
 type OwnerType string
@@ -128,6 +255,25 @@ type WebsocketEventType string
 const (
 	WebsocketEventSessionUpdate      WebsocketEventType = "session_update"
 	WebsocketEventWorkerTaskResponse WebsocketEventType = "worker_task_response"
+	// a single data prep chunk has finished (or failed)
+	WebsocketEventDataPrepChunk WebsocketEventType = "data_prep_chunk"
+	// a queued session has a new position or ETA
+	WebsocketEventQueuePosition WebsocketEventType = "queue_position"
+	// the next bit of text for a streaming interaction
+	WebsocketEventStreamDelta WebsocketEventType = "stream_delta"
+)
+
+// why a model failed on an interaction so the api and the UI can tell a
+// failure that is down to the runner from a real problem with the session
+type RunnerErrorCode string
+
+const (
+	RunnerErrorCodeNone RunnerErrorCode = ""
+	// anything we don't recognise
+	RunnerErrorCodeModelError RunnerErrorCode = "model_error"
+	// the model ran out of GPU memory (or the host did) - this can be
+	// transient or need a runner with more memory
+	RunnerErrorCodeOutOfMemory RunnerErrorCode = "out_of_memory"
 )
 
 type WorkerTaskResponseType string
@@ -159,8 +305,22 @@ const (
 const FILESTORE_RESULTS_DIR = "results"
 const FILESTORE_LORA_DIR = "lora"
 
+// images part way through generating, kept apart from the results
+const FILESTORE_PREVIEWS_DIR = "previews"
+
 const LORA_DIR_NONE = "none"
 
+// session env vars must start with this so they can't clobber the ones the
+// runner and the model process rely on (PATH, the task urls etc)
+const SESSION_ENV_PREFIX = "HELIX_SESSION_"
+
+// a filter asking for sessions that don't set any env vars
+const SESSION_ENV_NONE = "none"
+
+// runners send what they are running as model_name:mode@env:lora_dir
+// reject entries, the env is left off for model processes that don't use it
+const REJECT_ENV_SEPARATOR = "@"
+
 // in the interaction metadata we keep track of which chunks
 // have been turned into questions - we use the following format
 // qa_<filename>
@@ -11,6 +11,8 @@ const (
 
 	Model_Ollama_Mistral7b ModelName = "mistral:7b-instruct"
 	Model_Ollama_Gemma7b   ModelName = "gemma:7b-instruct" // 7030MiB
+	Model_Ollama_Llama3_8b ModelName = "llama3:instruct"   // 6390MiB
+	Model_Ollama_Phi3      ModelName = "phi3:instruct"     // 4096MiB
 )
 
 func (m ModelName) String() string {
@@ -25,7 +27,9 @@ func (m ModelName) InferenceRuntime() InferenceRuntime {
 		return InferenceRuntimeAxolotl
 	case // Ollama
 		Model_Ollama_Mistral7b,
-		Model_Ollama_Gemma7b:
+		Model_Ollama_Gemma7b,
+		Model_Ollama_Llama3_8b,
+		Model_Ollama_Phi3:
 		return InferenceRuntimeOllama
 	// TODO: vllm
 	default:
@@ -41,6 +45,10 @@ func ValidateModelName(modelName string, acceptEmpty bool) (ModelName, error) {
 		return Model_Ollama_Mistral7b, nil
 	case Model_Ollama_Gemma7b:
 		return Model_Ollama_Gemma7b, nil
+	case Model_Ollama_Llama3_8b:
+		return Model_Ollama_Llama3_8b, nil
+	case Model_Ollama_Phi3:
+		return Model_Ollama_Phi3, nil
 	case Model_Axolotl_SDXL:
 		return Model_Axolotl_SDXL, nil
 	default:
@@ -51,3 +59,60 @@ func ValidateModelName(modelName string, acceptEmpty bool) (ModelName, error) {
 		}
 	}
 }
+
+// what a model can be used for, so clients can pick one without knowing the
+// models themselves
+type ModelCapability string
+
+const (
+	// the answer is sent as it is generated
+	ModelCapabilityStreaming ModelCapability = "streaming"
+	// user messages can include images
+	ModelCapabilityVision ModelCapability = "vision"
+	// sessions can fine tune the model on their own data
+	ModelCapabilityFinetune ModelCapability = "finetune"
+)
+
+type ModelCapabilities struct {
+	Streaming bool `json:"streaming"`
+	Vision    bool `json:"vision"`
+	Finetune  bool `json:"finetune"`
+}
+
+func (c ModelCapabilities) Has(capability ModelCapability) bool {
+	switch capability {
+	case ModelCapabilityStreaming:
+		return c.Streaming
+	case ModelCapabilityVision:
+		return c.Vision
+	case ModelCapabilityFinetune:
+		return c.Finetune
+	default:
+		return false
+	}
+}
+
+func ValidateModelCapability(capability string, acceptEmpty bool) (ModelCapability, error) {
+	switch ModelCapability(capability) {
+	case ModelCapabilityStreaming, ModelCapabilityVision, ModelCapabilityFinetune:
+		return ModelCapability(capability), nil
+	default:
+		if acceptEmpty && capability == "" {
+			return "", nil
+		}
+		return "", fmt.Errorf("invalid model capability %q, valid capabilities are: %s, %s, %s", capability, ModelCapabilityStreaming, ModelCapabilityVision, ModelCapabilityFinetune)
+	}
+}
+
+// a model sessions can be started with, as listed by GET /api/v1/models
+type ModelInfo struct {
+	Name    ModelName        `json:"name"`
+	Type    SessionType      `json:"type"`
+	Runtime InferenceRuntime `json:"runtime"`
+	// the aliases that currently point at the model
+	Aliases []string      `json:"aliases"`
+	Modes   []SessionMode `json:"modes"`
+	// bytes of GPU memory the model needs for each of its modes
+	MemoryRequirements map[SessionMode]uint64 `json:"memory_requirements"`
+	Capabilities       ModelCapabilities      `json:"capabilities"`
+}
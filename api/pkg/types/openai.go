@@ -13,14 +13,12 @@ var (
 
 // ChatCompletionRequest represents a request structure for chat completion API.
 type ChatCompletionRequest struct {
-	Model            string                        `json:"model"`
-	Messages         []ChatCompletionMessage       `json:"messages"`
-	MaxTokens        int                           `json:"max_tokens,omitempty"`
-	Temperature      float32                       `json:"temperature,omitempty"`
-	TopP             float32                       `json:"top_p,omitempty"`
+	Model    string                  `json:"model"`
+	Messages []ChatCompletionMessage `json:"messages"`
+	// max_tokens, temperature, top_p and stop go straight onto the session
+	SamplingParams
 	N                int                           `json:"n,omitempty"`
 	Stream           bool                          `json:"stream,omitempty"`
-	Stop             []string                      `json:"stop,omitempty"`
 	PresencePenalty  float32                       `json:"presence_penalty,omitempty"`
 	ResponseFormat   *ChatCompletionResponseFormat `json:"response_format,omitempty"`
 	Seed             *int                          `json:"seed,omitempty"`
@@ -172,3 +170,11 @@ type OpenAIMessage struct {
 	// The message content
 	Content string `json:"content" yaml:"content"`
 }
+
+// a session exported as an OpenAI chat completions messages array
+type OpenAISessionExport struct {
+	SessionID string          `json:"session_id"`
+	Name      string          `json:"name"`
+	Model     string          `json:"model"`
+	Messages  []OpenAIMessage `json:"messages"`
+}
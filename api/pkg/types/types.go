@@ -1,10 +1,16 @@
 package types
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql/driver"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -42,6 +48,76 @@ type Interaction struct {
 	LoraDir        string                     `json:"lora_dir"`
 	DataPrepChunks map[string][]DataPrepChunk `json:"data_prep_chunks"`
 	DataPrepStage  TextDataPrepStage          `json:"data_prep_stage"`
+	// image asset pointers sent along with the message to a vision model
+	Images []string `json:"images,omitempty"`
+	// the size in bytes of each uploaded file keyed by its path so we can
+	// check how big a session's dataset is without going to the filestore
+	FileSizes map[string]int64 `json:"file_sizes,omitempty"`
+	// how many tokens the model used producing this interaction
+	// left at zero for models that don't report it
+	Usage Usage `json:"usage"`
+	// the ID of the api request that created this interaction
+	// so runner logs can be matched up with the access log
+	RequestID string `json:"request_id,omitempty"`
+	// the full output (e.g. a python traceback) behind Error when the
+	// model process crashed - Error itself is kept to a short summary
+	ErrorDetail string `json:"error_detail,omitempty"`
+	// what sort of failure Error is, empty if there wasn't one
+	ErrorCode RunnerErrorCode `json:"error_code,omitempty"`
+	// the path of the log file (on the runner that ran it) with the output
+	// of the model process - fetch it from the runner's session logs api
+	LogFile string `json:"log_file,omitempty"`
+	// the request that was sent to the api when this interaction ran a
+	// tool action, so we can see what went upstream when a tool call fails
+	ToolRequest *ToolActionRequest `json:"tool_request,omitempty"`
+	// when the runner got to each stage of producing this interaction
+	Timings InteractionTimings `json:"timings"`
+	// the runner attempts at this interaction that failed and were retried,
+	// anything else they send is dropped
+	RetriedAttempts []string `json:"retried_attempts,omitempty"`
+	// exactly what the model was given to produce this interaction, only
+	// recorded by runners with RECORD_PROMPTS set and just a hash of it if
+	// the session is sensitive
+	Prompt string `json:"prompt,omitempty"`
+	// what the user thought of this answer, only on system interactions
+	Feedback *InteractionFeedback `json:"feedback,omitempty"`
+}
+
+// filled in by the runner as it runs the task so we can tell the time
+// waiting for the model to load (Scheduled to Started) from the time to the
+// first token and the generation - these come from the runner's clock
+// whereas Created, Scheduled and Completed come from the api's
+type InteractionTimings struct {
+	// the model was loaded and the runner handed it the task
+	Started time.Time `json:"started"`
+	// the model produced its first output, left empty for models that
+	// don't stream (e.g. image models)
+	FirstToken time.Time `json:"first_token"`
+	// from the first output (or Started if there wasn't one) to the result
+	Generation Duration `json:"generation"`
+}
+
+// the durations of each stage, zero for any stage we don't have both ends of
+func (i *Interaction) StageDurations() map[InteractionStage]time.Duration {
+	durations := map[InteractionStage]time.Duration{}
+	add := func(stage InteractionStage, from, to time.Time) {
+		if !from.IsZero() && to.After(from) {
+			durations[stage] = to.Sub(from)
+		}
+	}
+	add(InteractionStageQueue, i.Created, i.Scheduled)
+	add(InteractionStageLoad, i.Scheduled, i.Timings.Started)
+	add(InteractionStageFirstToken, i.Timings.Started, i.Timings.FirstToken)
+	if i.Timings.Generation > 0 {
+		durations[InteractionStageGeneration] = time.Duration(i.Timings.Generation)
+	}
+	return durations
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 type InteractionMessage struct {
@@ -65,8 +141,18 @@ type SessionMetadata struct {
 	DocumentIDs             map[string]string `json:"document_ids"`
 	DocumentGroupID         string            `json:"document_group_id"`
 	ManuallyReviewQuestions bool              `json:"manually_review_questions"`
-	SystemPrompt            string            `json:"system_prompt"`
-	HelixVersion            string            `json:"helix_version"`
+	// the documents hold regulated data so data prep never logs their
+	// content in full
+	Sensitive bool `json:"sensitive"`
+	// data prep also makes questions across the join between each document
+	// and the next, for a manual split across many files
+	SpanDocuments bool `json:"span_documents,omitempty"`
+	// the characters in each data prep chunk and how many chunks are
+	// converted at once, zero for the server's data prep defaults
+	DataPrepChunkSize   int    `json:"data_prep_chunk_size,omitempty"`
+	DataPrepConcurrency int    `json:"data_prep_concurrency,omitempty"`
+	SystemPrompt        string `json:"system_prompt"`
+	HelixVersion        string `json:"helix_version"`
 	// Evals are cool. Scores are strings of floats so we can distinguish ""
 	// (not rated) from "0.0"
 	EvalRunId               string   `json:"eval_run_id"`
@@ -77,6 +163,137 @@ type SessionMetadata struct {
 	EvalAutomaticScore      string   `json:"eval_automatic_score"`
 	EvalAutomaticReason     string   `json:"eval_automatic_reason"`
 	EvalOriginalUserPrompts []string `json:"eval_original_user_prompts"`
+
+	// if set we POST here instead of the owner's webhook URL when
+	// this session completes or errors
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// how inference samples from the model, unset values use the model's defaults
+	Sampling SamplingParams `json:"sampling"`
+	// the most the files of this session can add up to in bytes so the
+	// runner can refuse it before downloading, zero means no limit
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+	// added to the environment of the model process that runs this session
+	Env SessionEnv `json:"env,omitempty"`
+}
+
+// how many sessions an owner has running or queued and how many they can
+type SessionQuotaUsage struct {
+	OwnerType OwnerType `json:"owner_type"`
+	Owner     string    `json:"owner"`
+	Active    int       `json:"active"`
+	// zero means there is no limit
+	Limit int `json:"limit"`
+}
+
+// how many of an owner's sessions were cancelled in each state
+type OwnerCancelResult struct {
+	OwnerType OwnerType `json:"owner_type"`
+	Owner     string    `json:"owner"`
+	// waiting in the queue for a runner
+	Queued int `json:"queued"`
+	// text fine tunes generating their questions
+	PreparingData int `json:"preparing_data"`
+	// picked up by a runner
+	Running int `json:"running"`
+}
+
+// a thumbs up or down from the user on one of the assistant's answers -
+// this is for end users and kept apart from the eval scores of graders
+type InteractionFeedback struct {
+	Rating  FeedbackRating `json:"rating"`
+	Comment string         `json:"comment,omitempty"`
+	// the user that gave the feedback
+	Rater   string    `json:"rater"`
+	Created time.Time `json:"created"`
+}
+
+// feedback for an interaction, an empty rating clears it
+type InteractionFeedbackRequest struct {
+	Rating  FeedbackRating `json:"rating"`
+	Comment string         `json:"comment"`
+}
+
+type FeedbackCounts struct {
+	Up   int `json:"up"`
+	Down int `json:"down"`
+	// how many of the ratings came with a comment
+	Comments int `json:"comments"`
+}
+
+// the feedback on the answers of one session
+type SessionFeedbackStats struct {
+	SessionID string    `json:"session_id"`
+	ModelName ModelName `json:"model_name"`
+	FeedbackCounts
+}
+
+// the feedback on the answers of every session that has any, in total and
+// for each model so a model that starts getting worse ratings stands out
+type FeedbackStats struct {
+	// how many sessions have feedback
+	Sessions int                          `json:"sessions"`
+	Total    FeedbackCounts               `json:"total"`
+	Models   map[ModelName]FeedbackCounts `json:"models"`
+}
+
+// a score for one of the eval fields of a session, an empty score clears it
+type SessionEvalScore struct {
+	Score  string `json:"score"`
+	Reason string `json:"reason"`
+}
+
+type EvalScoreStats struct {
+	// how many sessions have this score set
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	// how many sessions got each score, rounded to one decimal place
+	Distribution map[string]int `json:"distribution"`
+}
+
+// the scores of all the sessions in an eval run
+type EvalRunStats struct {
+	EvalRunID string         `json:"eval_run_id"`
+	Sessions  int            `json:"sessions"`
+	User      EvalScoreStats `json:"user"`
+	Manual    EvalScoreStats `json:"manual"`
+	Automatic EvalScoreStats `json:"automatic"`
+}
+
+// run a list of single prompts through a model as one eval run, each prompt
+// gets its own session
+type EvalBatchRequest struct {
+	// groups the sessions of the batch, one is generated if it's empty -
+	// reuse it to add more prompts to the same run
+	EvalRunID    string   `json:"eval_run_id"`
+	Model        string   `json:"model"`
+	SystemPrompt string   `json:"system"`
+	Prompts      []string `json:"prompts"`
+	// how many of the prompts run at once, zero uses the default
+	Concurrency int `json:"concurrency"`
+	SamplingParams
+}
+
+type EvalBatchResponse struct {
+	EvalRunID string `json:"eval_run_id"`
+	// in the same order as the prompts
+	Results []*EvalBatchResult `json:"results"`
+}
+
+// the answer to one prompt of a batch, or why there isn't one
+type EvalBatchResult struct {
+	SessionID string `json:"session_id,omitempty"`
+	Response  string `json:"response,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// a session a runner has worked on and which of its interactions it ran
+type RunnerSessionSummary struct {
+	*SessionSummary
+	InteractionIDs []string `json:"interaction_ids"`
+}
+
+type RunnerSessionsList struct {
+	Sessions []*RunnerSessionSummary `json:"sessions"`
 }
 
 // the packet we put a list of sessions into so pagination is supported and we know the total amount
@@ -87,16 +304,157 @@ type SessionsList struct {
 	Sessions []*SessionSummary `json:"sessions"`
 }
 
+// delete the given sessions - if no IDs are given then every session
+// belonging to the caller that matches the filter is deleted
+// if both are given the filter narrows down the IDs
+type SessionsDeleteRequest struct {
+	IDs []string `json:"ids"`
+	// only delete sessions in this mode e.g. inference, finetune
+	Mode SessionMode `json:"mode"`
+	// only delete sessions that have not been updated for this long
+	OlderThan Duration `json:"older_than"`
+}
+
+type SessionsDeleteResponse struct {
+	Deleted int `json:"deleted"`
+	// the IDs we were asked to delete but skipped
+	NotFound   int `json:"not_found"`
+	NotOwned   int `json:"not_owned"`
+	NotMatched int `json:"not_matched"`
+	// sessions we tried to delete but got an error for, keyed by session ID
+	Failed map[string]string `json:"failed"`
+}
+
 type SessionChatRequest struct {
 	SessionID    string      `json:"session_id"` // If empty, we will start a new session
 	Stream       bool        `json:"stream"`     // If true, we will stream the response
 	Mode         SessionMode `json:"mode"`       // e.g. inference, finetune
 	Type         SessionType `json:"type"`       // e.g. text, image
 	LoraDir      string      `json:"lora_dir"`
-	SystemPrompt string      `json:"system"`   // System message, only applicable when starting a new session
-	Messages     []*Message  `json:"messages"` // Initial messages
-	Tools        []string    `json:"tools"`    // Available tools to use in the session
+	SystemPrompt string      `json:"system"`   // System message, only applicable when starting a new session, can use {{ .User.FullName }} style template variables
+	Messages     []*Message  `json:"messages"` // Initial messages, can be left out when starting a new session with a system prompt
+	Tools        []string    `json:"tools"`    // IDs or names of the caller's tools to bind to the session, only applicable when starting a new session
 	Model        string      `json:"model"`    // The model to use
+	// temperature, top_p, max_tokens and stop - only applicable when
+	// starting a new session
+	SamplingParams
+	// env vars for the model process, the names must start with
+	// HELIX_SESSION_ - only applicable when starting a new session
+	Env SessionEnv `json:"env,omitempty"`
+}
+
+// how the model samples its output during inference - everything is
+// optional and unset leaves it up to the model, temperature and top_p are
+// pointers so that zero can be asked for
+type SamplingParams struct {
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+const maxStopSequences = 4
+
+func (p SamplingParams) Validate() error {
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2, got %v", *p.Temperature)
+	}
+	if p.TopP != nil && (*p.TopP < 0 || *p.TopP > 1) {
+		return fmt.Errorf("top_p must be between 0 and 1, got %v", *p.TopP)
+	}
+	if p.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative, got %d", p.MaxTokens)
+	}
+	if len(p.Stop) > maxStopSequences {
+		return fmt.Errorf("at most %d stop sequences are allowed, got %d", maxStopSequences, len(p.Stop))
+	}
+	for _, stop := range p.Stop {
+		if stop == "" {
+			return fmt.Errorf("stop sequences must not be empty")
+		}
+	}
+	return nil
+}
+
+// the axolotl runtime only knows about stop sequences, we turn away
+// anything else rather than the model quietly ignoring it
+func (p SamplingParams) ValidateRuntime(runtime InferenceRuntime) error {
+	if runtime != InferenceRuntimeAxolotl {
+		return nil
+	}
+	if p.Temperature != nil || p.TopP != nil || p.MaxTokens != 0 {
+		return fmt.Errorf("temperature, top_p and max_tokens are not supported by the %s runtime, only stop is", runtime)
+	}
+	return nil
+}
+
+// env vars a session sets for its model process (e.g. a seed or a dataset
+// variant) - a model process is started with the env of the session that
+// booted it and only runs other sessions with the same env
+type SessionEnv map[string]string
+
+const maxSessionEnvVars = 16
+
+func (e SessionEnv) Validate() error {
+	if len(e) > maxSessionEnvVars {
+		return fmt.Errorf("at most %d env vars are allowed, got %d", maxSessionEnvVars, len(e))
+	}
+	for name, value := range e {
+		if !ValidSessionEnvName(name) {
+			return fmt.Errorf("invalid env var name '%s', it must start with %s and only have upper case letters, digits and underscores", name, SESSION_ENV_PREFIX)
+		}
+		if bytes.IndexByte([]byte(value), 0) >= 0 {
+			return fmt.Errorf("the value of env var %s must not contain a null byte", name)
+		}
+	}
+	return nil
+}
+
+func ValidSessionEnvName(name string) bool {
+	if len(name) <= len(SESSION_ENV_PREFIX) || name[:len(SESSION_ENV_PREFIX)] != SESSION_ENV_PREFIX {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// identifies the env so runners can ask for sessions that can share their
+// model process without the values ending up in urls and logs - empty if
+// there are no env vars
+func (e SessionEnv) Fingerprint() string {
+	if len(e) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		// the null byte can't be in a name or a value so the pairs can't run together
+		fmt.Fprintf(hash, "%s=%s\x00", name, e[name])
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// only the names so the values stay out of the logs when a session is
+// printed with %v
+func (e SessionEnv) String() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name+"=[redacted]")
+	}
+	sort.Strings(names)
+	return "map[" + strings.Join(names, " ") + "]"
+}
+
+func (e SessionEnv) GoString() string {
+	return e.String()
 }
 
 type Message struct {
@@ -111,9 +469,25 @@ type Message struct {
 type MessageContentType string
 
 const (
-	MessageContentTypeText MessageContentType = "text"
+	MessageContentTypeText           MessageContentType = "text"
+	MessageContentTypeMultimodalText MessageContentType = "multimodal_text"
+	MessageContentTypeImage          MessageContentType = "image"
 )
 
+// the content_type of an image object inside MessageContent.Parts
+const MessageContentPartTypeImageAssetPointer = "image_asset_pointer"
+
+// an image object inside MessageContent.Parts
+type ImageAssetPointer struct {
+	ContentType  string         `json:"content_type"` // image_asset_pointer
+	AssetPointer string         `json:"asset_pointer"`
+	SizeBytes    int            `json:"size_bytes"`
+	Width        int            `json:"width"`
+	Height       int            `json:"height"`
+	Fovea        any            `json:"fovea"`
+	Metadata     map[string]any `json:"metadata"`
+}
+
 type MessageContent struct {
 	ContentType MessageContentType `json:"content_type"` // text, image, multimodal_text
 	// Parts is a list of strings or objects. For example for text, it's a list of strings, for
@@ -164,6 +538,13 @@ type Session struct {
 	Owner string `json:"owner"`
 	// e.g. user, system, org
 	OwnerType OwnerType `json:"owner_type"`
+	// normalized (trimmed and lowercased) labels the owner organizes their
+	// sessions with - read only here so that saving the whole session never
+	// undoes a tag change, they are written by the session tag endpoints
+	Tags SessionTags `json:"tags" gorm:"->;type:jsonb"`
+	// the tools bound to this session, these live in the session tool
+	// bindings table and are only filled in when the session is loaded by the api
+	Tools []*Tool `json:"tools,omitempty" gorm:"-"`
 }
 
 func (s Session) TableName() string {
@@ -216,6 +597,34 @@ func (SessionMetadata) GormDataType() string {
 	return "json"
 }
 
+type SessionTags []string
+
+func (m SessionTags) Value() (driver.Value, error) {
+	// the column is never null
+	if m == nil {
+		m = SessionTags{}
+	}
+	j, err := json.Marshal(m)
+	return j, err
+}
+
+func (t *SessionTags) Scan(src interface{}) error {
+	source, ok := src.([]byte)
+	if !ok {
+		return errors.New("type assertion .([]byte) failed.")
+	}
+	var result SessionTags
+	if err := json.Unmarshal(source, &result); err != nil {
+		return err
+	}
+	*t = result
+	return nil
+}
+
+func (SessionTags) GormDataType() string {
+	return "jsonb"
+}
+
 type BotSessions struct {
 	SessionID string `json:"session_id"`
 	Name      string `json:"name"`
@@ -253,6 +662,9 @@ type SessionFilterModel struct {
 	Mode      SessionMode `json:"mode"`
 	ModelName ModelName   `json:"model_name"`
 	LoraDir   string      `json:"lora_dir"`
+	// the env fingerprint of the model process, "none" if it was started
+	// without env vars and empty if it doesn't use them
+	Env string `json:"env,omitempty"`
 }
 
 type Duration time.Duration
@@ -261,9 +673,14 @@ func (d Duration) MarshalJSON() ([]byte, error) {
 	return json.Marshal(time.Duration(d).String())
 }
 
+// accepts either a string like "30s" or a number of nanoseconds (the same
+// unit as time.Duration) - we always marshal back to the string form
 func (d *Duration) UnmarshalJSON(b []byte) error {
 	var v interface{}
-	if err := json.Unmarshal(b, &v); err != nil {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	// keep large nanosecond values exact rather than going via float64
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
 		return err
 	}
 	switch value := v.(type) {
@@ -274,6 +691,13 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 		}
 		*d = Duration(tmp)
 		return nil
+	case json.Number:
+		nanoseconds, err := value.Int64()
+		if err != nil {
+			return fmt.Errorf("invalid duration %s: numbers must be a whole number of nanoseconds", value)
+		}
+		*d = Duration(nanoseconds)
+		return nil
 	default:
 		return errors.New("invalid duration")
 	}
@@ -289,12 +713,18 @@ type SessionFilter struct {
 	ModelName ModelName `json:"model_name"`
 	// the filestore path to the file being used for finetuning
 	LoraDir string `json:"lora_dir"`
-	// this means "only give me sessions that will fit in this much ram"
-	Memory uint64 `json:"memory"`
-
-	// the list of model name / mode combos that we should skip over
+	// the fingerprint of the session env vars the model process was started
+	// with, none for sessions without any or empty for any env
+	Env string `json:"env"`
+	// this means "only give me sessions that will fit in this many bytes of ram"
+	// nil means the runner didn't ask us to check memory at all - zero means
+	// it has none free so nothing will fit
+	Memory *uint64 `json:"memory"`
+
+	// the list of model name / mode / lora dir combos that we should skip over
 	// normally used by runners that are running multiple types in parallel
-	// who don't want another version of what they are already running
+	// who don't want another version of what they are already running - all
+	// three have to match and an empty (or none) lora dir is the base model
 	Reject []SessionFilterModel `json:"reject"`
 
 	// only accept sessions that were created more than this duration ago
@@ -304,8 +734,35 @@ type SessionFilter struct {
 type ApiKey struct {
 	Owner     string    `json:"owner"`
 	OwnerType OwnerType `json:"owner_type"`
-	Key       string    `json:"key"`
-	Name      string    `json:"name"`
+	// only a hash of the key is stored so this is only filled in when the
+	// key is created
+	Key     string    `json:"key,omitempty"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+}
+
+type ApiKeyRequest struct {
+	// the key is revoked by its name so it has to be unique to the owner
+	Name string `json:"name"`
+}
+
+const maxApiKeyNameLength = 64
+
+// the name ends up in the url that revokes the key so it is kept to
+// letters, digits, dots, dashes and underscores
+func (r ApiKeyRequest) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(r.Name) > maxApiKeyNameLength {
+		return fmt.Errorf("name must be at most %d characters", maxApiKeyNameLength)
+	}
+	for _, c := range r.Name {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '.' && c != '-' && c != '_' {
+			return fmt.Errorf("invalid name '%s', only letters, digits, '.', '-' and '_' are allowed", r.Name)
+		}
+	}
+	return nil
 }
 
 type OwnerContext struct {
@@ -331,6 +788,45 @@ type UserConfig struct {
 	StripeSubscriptionActive bool   `json:"stripe_subscription_active"`
 	StripeCustomerID         string `json:"stripe_customer_id"`
 	StripeSubscriptionID     string `json:"stripe_subscription_id"`
+
+	// where we POST session events for this user's sessions
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// used to sign the webhook payloads so receivers can verify them
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+
+	// overrides the server's limit on the total size of a session's
+	// files for this user, zero means use the server's limit
+	MaxSessionFileSize int64 `json:"max_session_file_size,omitempty"`
+
+	// overrides the server's limit on how many sessions this user can
+	// have running or queued at once, zero means use the server's limit
+	MaxConcurrentSessions int `json:"max_concurrent_sessions,omitempty"`
+}
+
+type WebhookConfig struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+type WebhookConfigUpdate struct {
+	// an empty URL turns webhooks off
+	URL string `json:"url"`
+	// generate a new secret - the old one stops working straight away
+	RotateSecret bool `json:"rotate_secret"`
+}
+
+// what we POST to a webhook URL when a session completes or errors
+// the body is signed with the owner's webhook secret, see X-Helix-Signature
+type SessionWebhookPayload struct {
+	Event     SessionWebhookEvent `json:"event"`
+	SessionID string              `json:"session_id"`
+	Owner     string              `json:"owner"`
+	Mode      SessionMode         `json:"mode"`
+	Type      SessionType         `json:"type"`
+	ModelName ModelName           `json:"model_name"`
+	Status    InteractionState    `json:"status"`
+	Error     string              `json:"error,omitempty"`
+	Timestamp time.Time           `json:"timestamp"`
 }
 
 // this lives in the database
@@ -357,6 +853,14 @@ type UserStatus struct {
 	Config UserConfig `json:"config"`
 }
 
+// what the health and readiness endpoints return
+type HealthStatus struct {
+	// "ok" or "unavailable"
+	Status string `json:"status"`
+	// check name => "ok" or why it failed
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
 type UserDetails struct {
 	ID        string
 	Username  string
@@ -365,6 +869,30 @@ type UserDetails struct {
 	Email     string
 }
 
+// the variables a system prompt can use as a go template, resolved when the
+// session starts e.g. "You are talking to {{ .User.FullName }} on {{ .Date }}"
+type SystemPromptValues struct {
+	User SystemPromptUser
+	// when the session started
+	Now time.Time
+	// Now as 2006-01-02
+	Date    string
+	Session SystemPromptSession
+}
+
+type SystemPromptUser struct {
+	ID       string
+	Email    string
+	FullName string
+}
+
+type SystemPromptSession struct {
+	ID        string
+	ModelName ModelName
+	Mode      SessionMode
+	Type      SessionType
+}
+
 // a single envelope that is broadcast to users
 type WebsocketEvent struct {
 	Type               WebsocketEventType  `json:"type"`
@@ -372,6 +900,38 @@ type WebsocketEvent struct {
 	Owner              string              `json:"owner"`
 	Session            *Session            `json:"session"`
 	WorkerTaskResponse *RunnerTaskResponse `json:"worker_task_response"`
+
+	DataPrepChunk *DataPrepChunkUpdate `json:"data_prep_chunk,omitempty"`
+	QueuePosition *QueuePositionUpdate `json:"queue_position,omitempty"`
+	StreamDelta   *StreamDelta         `json:"stream_delta,omitempty"`
+}
+
+// the text a model has just added to the interaction it is streaming - the
+// last one has done set and the complete interaction so anything that was
+// missed can be caught up
+type StreamDelta struct {
+	InteractionID string       `json:"interaction_id"`
+	Delta         string       `json:"delta,omitempty"`
+	Done          bool         `json:"done,omitempty"`
+	Interaction   *Interaction `json:"interaction,omitempty"`
+}
+
+// sent whenever a queued session moves up the queue (or its ETA changes)
+type QueuePositionUpdate struct {
+	InteractionID string   `json:"interaction_id"`
+	Position      int      `json:"position"`
+	ETA           Duration `json:"eta,omitempty"`
+}
+
+// sent as each chunk is converted so the UI can update the chunk without
+// waiting for the next full session update
+type DataPrepChunkUpdate struct {
+	InteractionID string            `json:"interaction_id"`
+	Filename      string            `json:"filename"`
+	Stage         TextDataPrepStage `json:"stage"`
+	Chunk         DataPrepChunk     `json:"chunk"`
+	Progress      int               `json:"progress"`
+	Status        string            `json:"status"`
 }
 
 // the context of a long running python process
@@ -389,6 +949,15 @@ type RunnerProcessConfig struct {
 	MockRunner        bool
 	MockRunnerError   string
 	MockRunnerDelay   int
+	// the URL to ask if we should stop generating for the current session
+	// e.g. because the output has hit a stop sequence
+	StopTaskURL string `json:"stop_task_url"`
+}
+
+// tells the python process to stop generating for a session
+// (the session ID is empty if there is nothing to stop)
+type RunnerTaskStop struct {
+	SessionID string `json:"session_id"`
 }
 
 // a session will run "tasks" on runners
@@ -401,6 +970,10 @@ type RunnerTask struct {
 	SessionID string `json:"session_id"`
 	// the string that we are calling the prompt that we will feed into the model
 	Prompt string `json:"prompt"`
+	// the images that go with the prompt for vision models
+	Images []string `json:"images,omitempty"`
+	// the api request that created the interaction we are running
+	RequestID string `json:"request_id,omitempty"`
 
 	// the directory that contains the lora training files
 	LoraDir string `json:"lora_dir"`
@@ -408,6 +981,40 @@ type RunnerTask struct {
 	// this is the directory that contains the files used for fine tuning
 	// i.e. it's the user files that will be the input to a finetune session
 	DatasetDir string `json:"dataset_dir"`
+
+	// how to sample from the model for inference tasks, sessions for the
+	// axolotl runtime only ever have stop sequences set
+	SamplingParams
+}
+
+// a runner giving back a session it was handed but never sent a result for
+// e.g. because the runner restarted while it was preparing or running it
+type RunnerSessionRelease struct {
+	// the system interaction the runner was working on - if the session has
+	// moved on since then there is nothing to give back
+	InteractionID string `json:"interaction_id"`
+	// set by the api - true if the session went back on the queue
+	Requeued bool `json:"requeued"`
+}
+
+// a file the runner moves straight to or from the filestore using a short
+// lived url rather than streaming it through the api
+type RunnerFileTransfer struct {
+	// the path relative to the folder being transferred (or the file name)
+	Name string `json:"name"`
+	// the full filestore path
+	Path string `json:"path,omitempty"`
+	URL  string `json:"url,omitempty"`
+	// for uploads, the hex sha256 of the file which the filestore checks
+	Checksum string `json:"checksum,omitempty"`
+	// for uploads, headers that must be sent along with the file
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type RunnerFileTransfers struct {
+	// the full filestore path of the folder the files are in
+	Path  string                `json:"path"`
+	Files []*RunnerFileTransfer `json:"files"`
 }
 
 type RunnerTaskResponse struct {
@@ -428,6 +1035,34 @@ type RunnerTaskResponse struct {
 	LoraDir  string   `json:"lora_dir,omitempty"`
 	Error    string   `json:"error,omitempty"`
 	Done     bool     `json:"done,omitempty"`
+	// only set on the result if the model reported token usage
+	Usage *Usage `json:"usage,omitempty"`
+	// the hex sha256 of each uploaded result file keyed by its filestore path
+	Checksums map[string]string `json:"checksums,omitempty"`
+	// the captured stderr of the model process if it crashed
+	ErrorDetail string `json:"error_detail,omitempty"`
+	// set by the runner along with Error
+	ErrorCode RunnerErrorCode `json:"error_code,omitempty"`
+	// the runner's log file with the model process output for this session
+	LogFile string `json:"log_file,omitempty"`
+	// only set on the result, when the model got to each stage
+	Timings *InteractionTimings `json:"timings,omitempty"`
+	// a new ID each time a runner starts on an interaction and the number
+	// of this response within it (from 1) so the api can drop duplicates
+	// and responses that arrive out of order, runners that don't send them
+	// have every response applied
+	Attempt  string `json:"attempt,omitempty"`
+	Sequence uint64 `json:"sequence,omitempty"`
+	// only set on the result by runners recording prompts, the prompt the
+	// model was given
+	Prompt string `json:"prompt,omitempty"`
+}
+
+// the end of the model process output for a session from a runner's session logs
+type SessionLogs struct {
+	SessionID string   `json:"session_id"`
+	LogFile   string   `json:"log_file"`
+	Lines     []string `json:"lines"`
 }
 
 // this is returned by the api server so that clients can see what
@@ -457,6 +1092,15 @@ type CreateSessionRequest struct {
 	UserInteractions        []*Interaction
 	Priority                bool
 	ManuallyReviewQuestions bool
+	Sensitive               bool
+	SpanDocuments           bool
+	DataPrepChunkSize       int
+	DataPrepConcurrency     int
+	Sampling                SamplingParams
+	MaxFileSize             int64
+	Env                     SessionEnv
+	EvalRunID               string
+	EvalOriginalUserPrompts []string
 }
 
 type UpdateSessionRequest struct {
@@ -483,6 +1127,38 @@ type SessionSummary struct {
 	// this is either the prompt or the summary of the training data
 	Summary  string `json:"summary"`
 	Priority bool   `json:"priority"`
+	// the total tokens used across all of the session's interactions
+	Usage Usage    `json:"usage"`
+	Tags  []string `json:"tags"`
+	// where the session is in the queue (1 is next) while it waits for a
+	// runner and a rough guess at how long until the answer is ready
+	// the guess is left out until we have timed a job for the model and mode
+	QueuePosition int      `json:"queue_position,omitempty"`
+	QueueETA      Duration `json:"queue_eta,omitempty"`
+	// only in a model instance's job history - waiting until the runner has
+	// sent the result and then how the job ended
+	State InteractionState `json:"state,omitempty"`
+	Error string           `json:"error,omitempty"`
+}
+
+// a job a runner ran on one of its model instances, the api keeps these in
+// the store so they outlive the runner's in-memory job history
+type ModelInstanceJob struct {
+	RunnerID        string      `json:"runner_id" gorm:"primaryKey"`
+	ModelInstanceID string      `json:"model_instance_id" gorm:"primaryKey"`
+	InteractionID   string      `json:"interaction_id" gorm:"primaryKey"`
+	SessionID       string      `json:"session_id" gorm:"index"`
+	ModelName       ModelName   `json:"model_name"`
+	Mode            SessionMode `json:"mode"`
+	Started         time.Time   `json:"started" gorm:"index"`
+	// zero until the job has finished
+	Completed time.Time        `json:"completed"`
+	State     InteractionState `json:"state"`
+	Error     string           `json:"error"`
+}
+
+type ModelInstanceJobsList struct {
+	Jobs []*ModelInstanceJob `json:"jobs"`
 }
 
 type ModelInstanceState struct {
@@ -493,7 +1169,10 @@ type ModelInstanceState struct {
 	InitialSessionID string      `json:"initial_session_id"`
 	// this is either the currently running session
 	// or the queued session that will be run next but is currently downloading
-	CurrentSession *SessionSummary   `json:"current_session"`
+	CurrentSession *SessionSummary `json:"current_session"`
+	// every session the instance is running - inference sessions on some
+	// runtimes can run side by side so there can be more than one
+	ActiveSessions []*SessionSummary `json:"active_sessions,omitempty"`
 	JobHistory     []*SessionSummary `json:"job_history"`
 	// how many seconds to wait before calling ourselves stale
 	Timeout int `json:"timeout"`
@@ -512,19 +1191,84 @@ type RunnerState struct {
 	ID      string    `json:"id"`
 	Created time.Time `json:"created"`
 	// the URL that the runner will POST to to get a task
+	// in bytes - free memory is never negative, a runner that has
+	// overcommitted reports zero
 	TotalMemory         uint64                `json:"total_memory"`
-	FreeMemory          int64                 `json:"free_memory"`
+	FreeMemory          uint64                `json:"free_memory"`
 	Labels              map[string]string     `json:"labels"`
 	ModelInstances      []*ModelInstanceState `json:"model_instances"`
 	SchedulingDecisions []string              `json:"scheduling_decisions"`
+	// how many model instances are running and the most the runner will
+	// run at once whatever memory is free (zero means no limit)
+	InstanceCount int `json:"instance_count"`
+	MaxInstances  int `json:"max_instances"`
+	// the runner is draining - it finishes what it has but the scheduler
+	// won't hand it anything new
+	Cordoned bool `json:"cordoned"`
+	// sessions waiting for their turn to download their files and the ones
+	// downloading them now
+	PrepareQueueDepth int `json:"prepare_queue_depth"`
+	PreparingSessions int `json:"preparing_sessions"`
+	// only in the api's answer to a runner reporting its state - the
+	// sessions it is running that have been cancelled so it should stop
+	StopSessions []string `json:"stop_sessions,omitempty"`
 }
 
 type DashboardData struct {
 	SessionQueue              []*SessionSummary           `json:"session_queue"`
 	Runners                   []*RunnerState              `json:"runners"`
 	GlobalSchedulingDecisions []*GlobalSchedulingDecision `json:"global_scheduling_decisions"`
+	// the recent decisions for each queued session keyed by session id
+	// so we can see why a session is not being picked up
+	SessionSchedulingDecisions map[string][]*GlobalSchedulingDecision `json:"session_scheduling_decisions"`
+}
+
+// how many interactions finished in a given state for a model and mode
+type SessionOutcomeCount struct {
+	ModelName ModelName        `json:"model_name"`
+	Mode      SessionMode      `json:"mode"`
+	State     InteractionState `json:"state"`
+	Count     uint64           `json:"count"`
+}
+
+// the numbers the api server exports as prometheus metrics
+type ControllerMetrics struct {
+	Runners         []*RunnerState         `json:"runners"`
+	QueueDepth      int                    `json:"queue_depth"`
+	SessionOutcomes []*SessionOutcomeCount `json:"session_outcomes"`
+	StageDurations  []*StageDurationCount  `json:"stage_durations"`
 }
 
+// a histogram of how long completed interactions spent in a stage
+type StageDurationCount struct {
+	ModelName ModelName        `json:"model_name"`
+	Mode      SessionMode      `json:"mode"`
+	Stage     InteractionStage `json:"stage"`
+	// how many durations were at most each bucket's upper bound (in seconds)
+	Buckets []HistogramBucket `json:"buckets"`
+	// in seconds
+	Sum   float64 `json:"sum"`
+	Count uint64  `json:"count"`
+}
+
+type HistogramBucket struct {
+	UpperBound float64 `json:"upper_bound"`
+	Count      uint64  `json:"count"`
+}
+
+type SchedulingDecisionReason string
+
+const (
+	// the session was handed to the runner
+	SchedulingDecisionReasonAssigned SchedulingDecisionReason = "assigned"
+	// the runner's filter (mode, type, model, lora dir or reject list) didn't match
+	SchedulingDecisionReasonRejectedByFilter SchedulingDecisionReason = "rejected_by_filter"
+	// the runner doesn't have enough free memory for the model
+	SchedulingDecisionReasonInsufficientMemory SchedulingDecisionReason = "insufficient_memory"
+	// the session was updated more recently than the filter's Older threshold
+	SchedulingDecisionReasonTooRecent SchedulingDecisionReason = "too_recent"
+)
+
 type GlobalSchedulingDecision struct {
 	Created       time.Time     `json:"created"`
 	RunnerID      string        `json:"runner_id"`
@@ -533,6 +1277,20 @@ type GlobalSchedulingDecision struct {
 	ModelName     ModelName     `json:"model_name"`
 	Mode          SessionMode   `json:"mode"`
 	Filter        SessionFilter `json:"filter"`
+	// why the runner did or didn't get this session
+	Reason  SchedulingDecisionReason `json:"reason"`
+	Message string                   `json:"message"`
+	// for too_recent decisions, the session had to be updated before this
+	UpdatedBefore time.Time `json:"updated_before,omitempty"`
+	// how many times in a row we've come to the same decision
+	Count int `json:"count"`
+	// how many times the interaction has been re-queued after an error
+	RetryCount int `json:"retry_count"`
+	// where the session was in the queue when it was picked and the
+	// effective time it was ordered by (priority sessions get a head start)
+	Priority      bool      `json:"priority"`
+	QueuePosition int       `json:"queue_position"`
+	QueueTime     time.Time `json:"queue_time"`
 }
 
 // keep track of the state of the data prep
@@ -544,6 +1302,10 @@ type DataPrepChunk struct {
 	PromptName    string `json:"prompt_name"`
 	QuestionCount int    `json:"question_count"`
 	Error         string `json:"error"`
+	// the document the chunk (and so its questions) came from, a chunk
+	// across the join between two documents also has the one before it
+	DocumentID      string `json:"document_id,omitempty"`
+	SpansDocumentID string `json:"spans_document_id,omitempty"`
 }
 
 // the thing we get from the LLM's
@@ -561,6 +1323,32 @@ type DataPrepTextQuestion struct {
 	Conversations []DataPrepTextQuestionPart `json:"conversations"`
 }
 
+// what we think a data prep run will cost before we start it - the token
+// counts are approximate and the cost is zero when we have no price for the model
+type DataPrepCostEstimate struct {
+	Model        string  `json:"model"`
+	Chunks       int     `json:"chunks"`
+	Requests     int     `json:"requests"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+	// false if the model is missing from the price table
+	Priced bool `json:"priced"`
+}
+
+type DataPrepCostEstimateRequest struct {
+	// the plain text of each document we would prepare
+	Texts []string `json:"texts"`
+	// the data prep chunk size the fine tune would use, zero for the default
+	ChunkSize int `json:"chunk_size,omitempty"`
+}
+
+type DataPrepCancelRequest struct {
+	// keep the questions generated before the cancel so they can be edited
+	// and fine tuned on, otherwise they are deleted
+	KeepQuestions bool `json:"keep_questions"`
+}
+
 type Counter struct {
 	Count int64 `json:"count"`
 }
@@ -586,6 +1374,47 @@ type Tool struct {
 	// TODO: tool configuration
 	// such as OpenAPI spec, function code, etc.
 	Config ToolConfig `json:"config" gorm:"jsonb"`
+
+	// how often the planner has run the tool's actions, these are kept in
+	// their own table and filled in when the owner looks at their tools
+	UsageCount  int64              `json:"usage_count" gorm:"-"`
+	LastUsed    *time.Time         `json:"last_used,omitempty" gorm:"-"`
+	ActionUsage []*ToolActionUsage `json:"action_usage,omitempty" gorm:"-"`
+
+	// what an update did to the actions parsed from the schema, only set on
+	// the response to an update
+	ActionsDiff *ToolActionsDiff `json:"actions_diff,omitempty" gorm:"-"`
+}
+
+// the actions added, removed and renamed when a tool's schema is replaced -
+// an action is renamed when its method and path are still there under a
+// different name
+type ToolActionsDiff struct {
+	Added   []string            `json:"added"`
+	Removed []string            `json:"removed"`
+	Renamed []*ToolActionRename `json:"renamed"`
+}
+
+type ToolActionRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (d *ToolActionsDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Renamed) == 0
+}
+
+// sessions that picked one of the old names can no longer run it
+func (d *ToolActionsDiff) Breaking() bool {
+	return len(d.Removed) > 0 || len(d.Renamed) > 0
+}
+
+// how many times the planner has run one of a tool's actions
+type ToolActionUsage struct {
+	ToolID   string    `json:"tool_id" gorm:"primaryKey"`
+	Action   string    `json:"action" gorm:"primaryKey"`
+	Count    int64     `json:"count"`
+	LastUsed time.Time `json:"last_used"`
 }
 
 type ToolConfig struct {
@@ -619,8 +1448,20 @@ type ToolApiConfig struct {
 	Schema  string           `json:"schema"`
 	Actions []*ToolApiAction `json:"actions"` // Read-only, parsed from schema on creation
 
+	// Read-only, the top level servers from the schema
+	Servers []string `json:"servers,omitempty"`
+	// which of the schema's servers to call when the URL isn't overridden
+	ServerIndex int `json:"server_index,omitempty"`
+
 	Headers map[string]string `json:"headers"` // Headers (authentication, etc)
 	Query   map[string]string `json:"query"`   // Query parameters that will be always set
+
+	// how long we wait for the API to respond, defaults to 30s when not set
+	Timeout Duration `json:"timeout"`
+
+	// the most of a successful response in bytes the model is given (after
+	// any response transform), longer ones are cut short - zero means no limit
+	MaxResponseSize int `json:"max_response_size,omitempty"`
 }
 
 // ToolApiConfig is parsed from the OpenAPI spec
@@ -629,9 +1470,97 @@ type ToolApiAction struct {
 	Description string `json:"description"`
 	Method      string `json:"method"`
 	Path        string `json:"path"`
+	// the servers declared on the operation or its path, these are called
+	// instead of the tool's servers
+	Servers []string `json:"servers,omitempty"`
+	// overrides the tool level timeout for this action
+	Timeout Duration `json:"timeout"`
+	// picks the fields of a successful response the model is given, from
+	// the operation's x-helix-response-transform e.g. $.results[*]['title','url'],
+	// the whole response is given when it's empty
+	ResponseTransform string `json:"response_transform,omitempty"`
+	// the JSON body sent with the request with {{param}} placeholders the
+	// LLM fills in, from the operation's x-helix-body-template - ignored on GET
+	BodyTemplate string `json:"body_template,omitempty"`
+}
+
+// the request built for an api tool action - secrets in the headers and
+// query are redacted so this is safe to show to the user
+type ToolActionRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// remembers what a request sent with an Idempotency-Key header created so
+// that a retry of it gets the same thing back rather than a duplicate
+type IdempotencyKey struct {
+	Key       string    `json:"key" gorm:"primaryKey"`
+	Owner     string    `json:"owner" gorm:"primaryKey"`
+	OwnerType OwnerType `json:"owner_type" gorm:"primaryKey"`
+	// what kind of request the key was used for (e.g. create_tool)
+	Scope string `json:"scope" gorm:"primaryKey"`
+	// sha256 of the request body so we can tell a retry from a different
+	// request that is (wrongly) reusing the key
+	RequestHash string `json:"request_hash"`
+	// the ID of whatever the request created
+	ResourceID string    `json:"resource_id"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires" gorm:"index"`
+}
+
+const IdempotencyKeyScopeCreateTool = "create_tool"
+
+// the tags to add to a session, they are normalized before they are saved
+type SessionTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// lets anyone holding the token read one session without an account until
+// the link expires or the owner revokes it (which deletes it)
+type SessionShareLink struct {
+	ID        string    `json:"id" gorm:"primaryKey"`
+	SessionID string    `json:"session_id" gorm:"index"`
+	Owner     string    `json:"owner"`
+	OwnerType OwnerType `json:"owner_type"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+	// signed with the server's secret so it isn't stored, it's worked out
+	// again whenever the owner looks at their links
+	Token string `json:"token" gorm:"-"`
+}
+
+type SessionShareLinkRequest struct {
+	// how long the link works for in seconds, zero for a day
+	ExpiresIn int `json:"expires_in"`
+}
+
+// change an earlier user message and answer it again - everything after it
+// is thrown away unless KeepHistory copies it into a new session first
+type EditInteractionRequest struct {
+	Message     string `json:"message"`
+	KeepHistory bool   `json:"keep_history"`
 }
 
 // SessionToolBinding used to add tools to sessions
+type SessionToolRequest struct {
+	ToolID string `json:"tool_id"`
+}
+
+// sent with a tool_not_found or tool_forbidden error so the client knows
+// which of the tools it asked for to drop
+type ToolErrorDetails struct {
+	ToolID string `json:"tool_id"`
+}
+
+// sent with a tool_actions_in_use error when an update would take actions
+// away from sessions the tool is bound to
+type ToolActionsInUseDetails struct {
+	ActionsDiff *ToolActionsDiff `json:"actions_diff"`
+	// how many unfinished sessions the tool is bound to
+	Sessions int64 `json:"sessions"`
+}
+
 type SessionToolBinding struct {
 	SessionID string `gorm:"primaryKey;index"`
 	ToolID    string `gorm:"primaryKey"`
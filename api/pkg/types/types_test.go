@@ -0,0 +1,180 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuration_UnmarshalJSON(t *testing.T) {
+	var filter SessionFilter
+
+	err := json.Unmarshal([]byte(`{"older": "30s"}`), &filter)
+	require.NoError(t, err)
+	assert.Equal(t, Duration(30*time.Second), filter.Older)
+
+	err = json.Unmarshal([]byte(`{"older": 30000000000}`), &filter)
+	require.NoError(t, err)
+	assert.Equal(t, Duration(30*time.Second), filter.Older)
+
+	// bigger than float64 can hold exactly
+	err = json.Unmarshal([]byte(`{"older": 9007199254740993}`), &filter)
+	require.NoError(t, err)
+	assert.Equal(t, Duration(9007199254740993), filter.Older)
+
+	err = json.Unmarshal([]byte(`{"older": 1.5}`), &filter)
+	assert.Error(t, err)
+
+	err = json.Unmarshal([]byte(`{"older": "soon"}`), &filter)
+	assert.Error(t, err)
+
+	err = json.Unmarshal([]byte(`{"older": true}`), &filter)
+	assert.Error(t, err)
+}
+
+func TestDuration_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal(Duration(90 * time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, `"1m30s"`, string(b))
+
+	var roundTripped Duration
+	require.NoError(t, json.Unmarshal(b, &roundTripped))
+	assert.Equal(t, Duration(90*time.Second), roundTripped)
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	for _, webhookURL := range []string{"https://example.com/hooks", "http://203.0.113.10:8080/hook"} {
+		assert.NoError(t, ValidateWebhookURL(webhookURL), webhookURL)
+	}
+	for _, webhookURL := range []string{
+		"ftp://example.com",
+		"https://",
+		"http://localhost:8080/hook",
+		"http://127.0.0.1/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://[::1]/hook",
+		"http://0.0.0.0/hook",
+	} {
+		assert.Error(t, ValidateWebhookURL(webhookURL), webhookURL)
+	}
+}
+
+func float32Ptr(f float32) *float32 {
+	return &f
+}
+
+func TestSamplingParams_Validate(t *testing.T) {
+	assert.NoError(t, SamplingParams{}.Validate())
+	assert.NoError(t, SamplingParams{Temperature: float32Ptr(0.7), TopP: float32Ptr(0.9), MaxTokens: 256, Stop: []string{"</s>"}}.Validate())
+	assert.NoError(t, SamplingParams{Temperature: float32Ptr(0), TopP: float32Ptr(0)}.Validate())
+
+	assert.Error(t, SamplingParams{Temperature: float32Ptr(2.5)}.Validate())
+	assert.Error(t, SamplingParams{Temperature: float32Ptr(-0.1)}.Validate())
+	assert.Error(t, SamplingParams{TopP: float32Ptr(1.5)}.Validate())
+	assert.Error(t, SamplingParams{MaxTokens: -1}.Validate())
+	assert.Error(t, SamplingParams{Stop: []string{""}}.Validate())
+	assert.Error(t, SamplingParams{Stop: []string{"a", "b", "c", "d", "e"}}.Validate())
+}
+
+func TestSamplingParams_JSON(t *testing.T) {
+	var req SessionChatRequest
+	err := json.Unmarshal([]byte(`{"model": "llama3:instruct", "temperature": 0.5, "top_p": 0.9, "max_tokens": 100, "stop": ["\n"]}`), &req)
+	require.NoError(t, err)
+	assert.Equal(t, SamplingParams{Temperature: float32Ptr(0.5), TopP: float32Ptr(0.9), MaxTokens: 100, Stop: []string{"\n"}}, req.SamplingParams)
+
+	// the python code reads these straight off the task
+	b, err := json.Marshal(RunnerTask{SessionID: "session-1", SamplingParams: req.SamplingParams})
+	require.NoError(t, err)
+	var task map[string]any
+	require.NoError(t, json.Unmarshal(b, &task))
+	assert.Equal(t, 0.5, task["temperature"])
+	assert.Equal(t, float64(100), task["max_tokens"])
+
+	// nothing is sent when unset so the model defaults are used
+	b, err = json.Marshal(RunnerTask{SessionID: "session-1"})
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "temperature")
+	assert.NotContains(t, string(b), "max_tokens")
+
+	// but a temperature of zero is kept
+	require.NoError(t, json.Unmarshal([]byte(`{"temperature": 0}`), &req))
+	require.NotNil(t, req.Temperature)
+	b, err = json.Marshal(RunnerTask{SessionID: "session-1", SamplingParams: req.SamplingParams})
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"temperature":0`)
+}
+
+func TestSamplingParams_ValidateRuntime(t *testing.T) {
+	params := SamplingParams{Temperature: float32Ptr(0.5), MaxTokens: 100, Stop: []string{"\n"}}
+	assert.NoError(t, params.ValidateRuntime(InferenceRuntimeOllama))
+	assert.Error(t, params.ValidateRuntime(InferenceRuntimeAxolotl))
+
+	// axolotl models can stop on a sequence
+	assert.NoError(t, SamplingParams{Stop: []string{"\n"}}.ValidateRuntime(InferenceRuntimeAxolotl))
+}
+
+func TestValidateEvalScore(t *testing.T) {
+	for _, score := range []string{"", "0.0", "1.0", "0.5", "1"} {
+		assert.NoError(t, ValidateEvalScore(score), score)
+	}
+	for _, score := range []string{"good", "-0.1", "1.5", "NaN"} {
+		assert.Error(t, ValidateEvalScore(score), score)
+	}
+}
+
+func TestSessionEnv_Validate(t *testing.T) {
+	assert.NoError(t, SessionEnv{}.Validate())
+	assert.NoError(t, SessionEnv{"HELIX_SESSION_SEED": "42", "HELIX_SESSION_DATASET_V2": ""}.Validate())
+
+	for _, name := range []string{"PATH", "HELIX_SESSION_", "HELIX_SESSION_seed", "HELIX_SESSION_A-B", "HELIX_SESSION_A=B"} {
+		assert.Error(t, SessionEnv{name: "1"}.Validate(), name)
+	}
+	assert.Error(t, SessionEnv{"HELIX_SESSION_SEED": "4\x002"}.Validate())
+
+	tooMany := SessionEnv{}
+	for i := 0; i <= maxSessionEnvVars; i++ {
+		tooMany[fmt.Sprintf("HELIX_SESSION_VAR_%d", i)] = "1"
+	}
+	assert.Error(t, tooMany.Validate())
+}
+
+func TestSessionEnv_Fingerprint(t *testing.T) {
+	assert.Empty(t, SessionEnv{}.Fingerprint())
+
+	env := SessionEnv{"HELIX_SESSION_SEED": "42", "HELIX_SESSION_FLAG": "on"}
+	assert.Len(t, env.Fingerprint(), 16)
+	assert.Equal(t, env.Fingerprint(), SessionEnv{"HELIX_SESSION_FLAG": "on", "HELIX_SESSION_SEED": "42"}.Fingerprint())
+	assert.NotEqual(t, env.Fingerprint(), SessionEnv{"HELIX_SESSION_SEED": "43", "HELIX_SESSION_FLAG": "on"}.Fingerprint())
+	assert.NotEqual(t, env.Fingerprint(), SessionEnv{"HELIX_SESSION_SEED": "42"}.Fingerprint())
+}
+
+func TestSessionEnv_String(t *testing.T) {
+	metadata := SessionMetadata{Env: SessionEnv{"HELIX_SESSION_SEED": "secret-seed"}}
+
+	for _, format := range []string{"%v", "%+v", "%#v"} {
+		logged := fmt.Sprintf(format, metadata)
+		assert.Contains(t, logged, "HELIX_SESSION_SEED=[redacted]", format)
+		assert.NotContains(t, logged, "secret-seed", format)
+	}
+
+	// the api and the runner still see the values
+	b, err := json.Marshal(metadata)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"env":{"HELIX_SESSION_SEED":"secret-seed"}`)
+}
+
+func TestApiKeyRequest_Validate(t *testing.T) {
+	for _, name := range []string{"default", "ci-runner_2", "laptop.home"} {
+		assert.NoError(t, ApiKeyRequest{Name: name}.Validate(), name)
+	}
+	for _, name := range []string{"", "my key", "ci/runner", "%2e%2e", strings.Repeat("a", maxApiKeyNameLength+1)} {
+		assert.Error(t, ApiKeyRequest{Name: name}.Validate(), name)
+	}
+}
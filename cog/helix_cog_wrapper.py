@@ -12,6 +12,9 @@ import shutil
 import tempfile
 from pathlib import Path
 
+import torch
+from PIL import Image
+
 # we get copied into the cog-sdxl folder so assume these modules are available
 # TODO: parse dynamically these entrypoints from any cog yaml
 from train import train
@@ -29,6 +32,86 @@ def create_zip_file(directory, output_file):
                     zipf.write(file_path, os.path.relpath(file_path, directory))
 
 
+# how often (in steps) we send a preview of the image being generated, 0 turns
+# them off - they are a cheap projection of the latents, not a vae decode
+PREVIEW_STEPS = int(os.environ.get("HELIX_PREVIEW_STEPS", "10"))
+PREVIEW_DIR = os.path.join(tempfile.gettempdir(), "helix-previews")
+
+# base_image_refiner runs the refiner as img2img at the pipeline's default
+# strength, so it takes this fraction of the steps again after the base model
+REFINER_STRENGTH = 0.3
+
+# maps the 4 sdxl latent channels onto rgb, good enough for a preview
+SDXL_LATENT_RGB_FACTORS = torch.tensor([
+    [0.3920, 0.4054, 0.4549],
+    [-0.2634, -0.0196, 0.0653],
+    [0.0568, 0.1687, -0.0755],
+    [-0.3112, -0.2359, -0.2076],
+])
+
+
+def latents_to_preview(latents):
+    latent = latents[0].float().cpu()
+    rgb = torch.einsum("chw,cr->hwr", latent, SDXL_LATENT_RGB_FACTORS)
+    rgb = ((rgb + 1) / 2).clamp(0, 1).mul(255).byte().numpy()
+    return Image.fromarray(rgb)
+
+
+class StepReporter:
+    """
+    Tells the runner how far through the diffusion steps we are (the base
+    model and refiner share the steps between them) and every PREVIEW_STEPS
+    writes a preview for it to pass on.
+    """
+    def __init__(self):
+        self.session_id = ""
+        self.total_steps = 0
+        self.step_count = 0
+
+    def start(self, session_id, total_steps):
+        # the runner deletes each preview once it has uploaded it
+        os.makedirs(PREVIEW_DIR, exist_ok=True)
+        self.session_id = session_id
+        self.total_steps = total_steps
+        self.step_count = 0
+
+    def step(self, step, timestep, latents):
+        self.step_count = min(self.step_count + 1, self.total_steps)
+        print(f" [SESSION_PROGRESS]step={self.step_count}/{self.total_steps} ", file=sys.stdout, flush=True)
+        if PREVIEW_STEPS <= 0 or self.step_count % PREVIEW_STEPS != 0 or self.step_count == self.total_steps:
+            return
+        try:
+            preview_path = os.path.join(PREVIEW_DIR, f"preview_{self.session_id}_{self.step_count:03d}.png")
+            latents_to_preview(latents).save(preview_path)
+            print(f" [SESSION_PREVIEW]image={preview_path} ", file=sys.stdout, flush=True)
+        except Exception as e:
+            # a preview is never worth failing the image for
+            print(f"error writing preview: {e}")
+
+
+class ProgressPipeline:
+    """
+    Passes everything through to a diffusers pipeline but has it report
+    each step to the StepReporter.
+    """
+    def __init__(self, pipe, reporter):
+        object.__setattr__(self, "_pipe", pipe)
+        object.__setattr__(self, "_reporter", reporter)
+
+    def __call__(self, *args, **kwargs):
+        kwargs["callback"] = self._reporter.step
+        kwargs["callback_steps"] = 1
+        return self._pipe(*args, **kwargs)
+
+    def __getattr__(self, name):
+        return getattr(self._pipe, name)
+
+    # predict() swaps the scheduler on the pipeline so that has to land on
+    # the real one
+    def __setattr__(self, name, value):
+        setattr(self._pipe, name, value)
+
+
 class CogTrainer:
     """
     A one-shot finetune.
@@ -145,6 +228,10 @@ class CogInference:
         self.predictor = Predictor()
         self.predictor.setup()
 
+        self.reporter = StepReporter()
+        self.predictor.txt2img_pipe = ProgressPipeline(self.predictor.txt2img_pipe, self.reporter)
+        self.predictor.refiner = ProgressPipeline(self.predictor.refiner, self.reporter)
+
 
 
     def run(self):
@@ -209,9 +296,6 @@ class CogInference:
                 time.sleep(0.1)
                 continue
 
-            # TODO: report on generation progress somehow
-            last_seen_progress = 0
-
             task = json.loads(response.content)
             session_id = task["session_id"]
 
@@ -220,6 +304,9 @@ class CogInference:
 
             print(f" [SESSION_START]session_id={session_id} ", file=sys.stdout, flush=True)
 
+            num_inference_steps = 50
+            self.reporter.start(session_id, num_inference_steps + int(num_inference_steps * REFINER_STRENGTH))
+
             # TODO: Seems like you can pass the lora weights as a URL either in
             # setup() or at predict() time. Given the latter, which we use here,
             # we could send LoRA requests to non-LoRA instances of cog-sdxl,
@@ -234,7 +321,7 @@ class CogInference:
                 height=1024,
                 num_outputs=1,
                 scheduler="K_EULER",
-                num_inference_steps=50,
+                num_inference_steps=num_inference_steps,
                 guidance_scale=7.5,
                 prompt_strength=0.8,
                 seed=42,
@@ -248,9 +335,13 @@ class CogInference:
             )
 
             # TODO: rename files per f"image_{session_id}_{timestamp}_{i:03d}.png"
+            # move them out of /tmp into the session's results folder, the
+            # runner only uploads files from its results folders
             timestamp = time.time()
+            results_dir = Path("/tmp/helix/results") / session_id / "images"
+            results_dir.mkdir(parents=True, exist_ok=True)
             for i, ip in enumerate(image_paths):
-                image_paths[i] = ip.rename(ip.parent / f"image_{session_id}_{timestamp:.4f}_{i:03d}.png")
+                image_paths[i] = Path(shutil.move(str(ip), str(results_dir / f"image_{session_id}_{timestamp:.4f}_{i:03d}.png")))
     
             image_paths = [str(path) for path in image_paths]  # Convert paths to strings
 
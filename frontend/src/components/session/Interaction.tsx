@@ -120,6 +120,8 @@ export const Interaction: FC<{
             imageURLs={ imageURLs }
             message={ displayMessage }
             error={ interaction?.error }
+            errorDetail={ interaction?.error_detail }
+            errorCode={ interaction?.error_code }
             isShared={ session.config.shared }
             onRestart={ onRestart }
             isFromSystem={interaction?.creator == SESSION_CREATOR_SYSTEM}
@@ -17,6 +17,8 @@ import useAccount from '../../hooks/useAccount'
 import {
   ISession,
   IServerConfig,
+  IRunnerErrorCode,
+  RUNNER_ERROR_CODE_OUT_OF_MEMORY,
 } from '../../types'
 
 import {
@@ -29,6 +31,9 @@ export const InteractionInference: FC<{
   imageURLs?: string[],
   message?: string,
   error?: string,
+  // the full output of the crashed process behind the error
+  errorDetail?: string,
+  errorCode?: IRunnerErrorCode,
   serverConfig?: IServerConfig,
   session: ISession,
   // if the session is shared then we don't enforce needing an access token to see the files
@@ -39,6 +44,8 @@ export const InteractionInference: FC<{
   imageURLs = [],
   message,
   error,
+  errorDetail,
+  errorCode,
   serverConfig,
   session,
   isShared,
@@ -65,7 +72,11 @@ export const InteractionInference: FC<{
           <Row>
             <Cell grow>
               <Alert severity="error">
-                The system has encountered an error -
+                {
+                  errorCode == RUNNER_ERROR_CODE_OUT_OF_MEMORY ?
+                    'The model ran out of GPU memory, this is usually temporary so retrying may work -' :
+                    'The system has encountered an error -'
+                }
                 <ClickLink
                   sx={{
                     pl: 0.5,
@@ -141,7 +152,7 @@ export const InteractionInference: FC<{
           <TerminalWindow
             open
             title="Error"
-            data={ error }
+            data={ errorDetail ? `${error}\n\n${errorDetail}` : error }
             onClose={ () => {
               setViewingError(false)
             }}
@@ -1,5 +1,6 @@
 import React, { FC } from 'react'
 import Box from '@mui/material/Box'
+import Chip from '@mui/material/Chip'
 import { prettyBytes } from '../../utils/format'
 import Typography from '@mui/material/Typography'
 import LinearProgress from '@mui/material/LinearProgress'
@@ -35,6 +36,13 @@ export const RunnerSummary: FC<{
         <Cell>
           <Typography variant="h6" sx={{mr: 2}}>{ runner.id }</Typography>
         </Cell>
+        {
+          runner.cordoned && (
+            <Cell>
+              <Chip size="small" color="warning" label="cordoned" />
+            </Cell>
+          )
+        }
         <Cell flexGrow={1} />
         <Cell>
           <Typography variant="caption" gutterBottom>{ Object.keys(runner.labels || {}).map(k => `${k}=${runner.labels[k]}`).join(', ') }</Typography>
@@ -62,6 +62,11 @@ export const SchedulingDecisionSummary: FC<{
       <Cell flexGrow={ 1 }>
         <Typography component="div" variant="caption" style={{ whiteSpace: 'nowrap', overflow: 'hidden', textOverflow: 'ellipsis' }}>
           -&gt; { decision.runner_id }
+          {
+            decision.reason && decision.reason != 'assigned' && (
+              <> : { decision.reason }{ decision.count > 1 ? ` (x${decision.count})` : '' } - { decision.message }</>
+            )
+          }
         </Typography>
       </Cell>
       <Cell>
@@ -27,6 +27,7 @@ export interface IAccountContext {
   serverConfig: IServerConfig,
   userConfig: IUserConfig,
   apiKeys: IApiKey[],
+  loadApiKeys: () => Promise<void>,
   mobileMenuOpen: boolean,
   setMobileMenuOpen: (val: boolean) => void,
   onLogin: () => void,
@@ -44,6 +45,7 @@ export const AccountContext = createContext<IAccountContext>({
   },
   userConfig: {},
   apiKeys: [],
+  loadApiKeys: async () => {},
   mobileMenuOpen: false,
   setMobileMenuOpen: () => {},
   onLogin: () => {},
@@ -76,16 +78,13 @@ export const useAccountContext = (): IAccountContext => {
   }, [])
 
   const token = useMemo(() => {
-    if(apiKeys && apiKeys.length > 0) {
-      return apiKeys[0].key
-    } else if(user && user.token) {
+    if(user && user.token) {
       return user.token
     } else {
       return ''
     }
   }, [
     user,
-    apiKeys,
   ])
 
   const loadStatus = useCallback(async () => {
@@ -103,7 +102,7 @@ export const useAccountContext = (): IAccountContext => {
   }, [])
   
   const loadApiKeys = useCallback(async () => {
-    const result = await api.get<IApiKey[]>('/api/v1/api_keys')
+    const result = await api.get<IApiKey[]>('/api/v1/api-keys')
     if(!result) return
     setApiKeys(result)
   }, [])
@@ -208,6 +207,7 @@ export const useAccountContext = (): IAccountContext => {
     setMobileMenuOpen,
     credits,
     apiKeys,
+    loadApiKeys,
     onLogin,
     onLogout,
   }), [
@@ -221,6 +221,7 @@ export const useAccountContext = (): IAccountContext => {
     setMobileMenuOpen,
     credits,
     apiKeys,
+    loadApiKeys,
     onLogin,
     onLogout,
   ])
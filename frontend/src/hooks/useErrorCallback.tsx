@@ -4,8 +4,20 @@ import {
   SnackbarContext,
 } from '../contexts/snackbar'
 
+// errors with a code come back as { error: { code, message } }
+export const extractErrorCode = (error: any): string | undefined => {
+  const data = error?.response?.data
+  if(data && data.error && typeof data.error === 'object') {
+    return data.error.code
+  }
+  return undefined
+}
+
 export const extractErrorMessage = (error: any): string => {
   if(error.response && error.response.data) {
+    if (error.response.data.error && typeof error.response.data.error === 'object') {
+      return error.response.data.error.message as string
+    }
     if (error.response.data.message || error.response.data.error) {
       return (error.response.data.message || error.response.data.error) as string
     }
@@ -3,6 +3,7 @@ import useWebsocket from './useWebsocket'
 
 import {
   WEBSOCKET_EVENT_TYPE_WORKER_TASK_RESPONSE,
+  WEBSOCKET_EVENT_TYPE_STREAM_DELTA,
   WORKER_TASK_RESPONSE_TYPE_PROGRESS,
   WORKER_TASK_RESPONSE_TYPE_STREAM,
   IInteraction,
@@ -36,7 +37,16 @@ export const useLiveInteraction = ({
   useWebsocket(session_id, (parsedData) => {
     if(!session_id) return
     setRecentTimestamp(Date.now())
-    if(parsedData.type == WEBSOCKET_EVENT_TYPE_WORKER_TASK_RESPONSE && parsedData.worker_task_response) {
+    if(parsedData.type == WEBSOCKET_EVENT_TYPE_STREAM_DELTA && parsedData.stream_delta) {
+      const streamDelta = parsedData.stream_delta
+      if(streamDelta.interaction_id != interaction.id) return
+      if(streamDelta.done && streamDelta.interaction) {
+        // the last one has the whole message in case we missed anything
+        setMessage(streamDelta.interaction.message)
+      } else if(streamDelta.delta) {
+        setMessage(m => m + streamDelta.delta)
+      }
+    } else if(parsedData.type == WEBSOCKET_EVENT_TYPE_WORKER_TASK_RESPONSE && parsedData.worker_task_response) {
       const workerResponse = parsedData.worker_task_response
       if(workerResponse.type == WORKER_TASK_RESPONSE_TYPE_STREAM && workerResponse.message) {
         setMessage(m => m + workerResponse.message)
@@ -6,6 +6,15 @@ import {
   IWebsocketEvent,
 } from '../types'
 
+// the api closes websockets with this code and reason when it restarts,
+// reconnecting-websocket brings the connection back on its own
+const WEBSOCKET_CLOSE_GOING_AWAY = 1001
+const WEBSOCKET_CLOSE_REASON_RESTART = 'server_restart'
+
+export const isServerRestart = (ev: { code: number, reason: string }) => {
+  return ev.code == WEBSOCKET_CLOSE_GOING_AWAY && ev.reason == WEBSOCKET_CLOSE_REASON_RESTART
+}
+
 export const useWebsocket = (
   session_id: string,
   handler: {
@@ -26,6 +35,10 @@ export const useWebsocket = (
       if(parsedData.session_id != session_id) return
       handler(parsedData)
     })
+    rws.addEventListener('close', (event) => {
+      if(!isServerRestart(event)) return
+      console.log('[websocket] server restarting, reconnecting')
+    })
     return () => rws.close()
   }, [
     account.token,
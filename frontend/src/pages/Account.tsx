@@ -17,6 +17,8 @@ import ContentCopyIcon from '@mui/icons-material/ContentCopy'
 import DeleteIcon from '@mui/icons-material/Delete'
 import CopyIcon from '@mui/icons-material/CopyAll'
 
+import EditTextWindow from '../components/widgets/EditTextWindow'
+
 import useSnackbar from '../hooks/useSnackbar'
 import useAccount from '../hooks/useAccount'
 import useApi from '../hooks/useApi'
@@ -24,6 +26,10 @@ import useApi from '../hooks/useApi'
 import useTheme from '@mui/material/styles/useTheme'
 import useThemeConfig from '../hooks/useThemeConfig'
 
+import {
+  IApiKey,
+} from '../types'
+
 const Account: FC = () => {
   const account = useAccount()
   const api = useApi()
@@ -36,16 +42,37 @@ const Account: FC = () => {
   const paymentsActive = account.serverConfig.stripe_enabled
   const colSize = paymentsActive ? 6 : 12
 
-  const handleDeleteApiKey = useCallback(async (key: string) => {
-    await api.delete(`/api/v1/api_keys`, {
-      params: {
-        key,
-      }
-    }, {
+  // the key is only ever returned when it's made so we hold on to it here
+  // until the page is left
+  const [newApiKey, setNewApiKey] = useState<IApiKey>()
+  const [creatingApiKey, setCreatingApiKey] = useState(false)
+
+  const handleCreateApiKey = useCallback(async (name: string) => {
+    const result = await api.post<any, IApiKey>(`/api/v1/api-keys`, {
+      name,
+    }, {}, {
       loading: true,
       snackbar: true,
     })
-  }, [])
+    if(!result) return
+    setNewApiKey(result)
+    setCreatingApiKey(false)
+    account.loadApiKeys()
+  }, [
+    account.loadApiKeys,
+  ])
+
+  const handleDeleteApiKey = useCallback(async (name: string) => {
+    await api.delete(`/api/v1/api-keys/${encodeURIComponent(name)}`, {}, {
+      loading: true,
+      snackbar: true,
+    })
+    if(newApiKey && newApiKey.name == name) setNewApiKey(undefined)
+    account.loadApiKeys()
+  }, [
+    newApiKey,
+    account.loadApiKeys,
+  ])
 
   const handleSubscribe = useCallback(async () => {
     const result = await api.post(`/api/v1/subscription/new`, undefined, {}, {
@@ -79,8 +106,7 @@ const Account: FC = () => {
 
   if(!account.user) return null
   if(!account.apiKeys) return null
-  // Get API key
-  const apiKey = account.apiKeys.length > 0 ? account.apiKeys[0].key : ''
+  const apiKey = newApiKey?.key || '<your API key>'
 
   // TODO: replace with 
   // https://www.npmjs.com/package/@readme/httpsnippet
@@ -126,23 +152,37 @@ const Account: FC = () => {
                   <ListItemText 
                     primary={'API keys'} 
                     secondary={`Specify your key as a header 'Authorization: Bearer <token>' with every request`} />
-
+                  <ListItemSecondaryAction>
+                    <Button variant="outlined" size="small" onClick={() => setCreatingApiKey(true)}>
+                      New key
+                    </Button>
+                  </ListItemSecondaryAction>
                 </ListItem>
-                  {account.apiKeys.map((apiKey) => (
-                    <ListItem key={apiKey.key}>
-                      <ListItemText primary={apiKey.name} secondary={apiKey.key} />
-                      <ListItemSecondaryAction>
-                        <CopyToClipboard text={apiKey.key} onCopy={() => snackbar.success('Copied to clipboard')}>
-                          <IconButton edge="end" aria-label="copy" sx={{ mr: 2 }}>
-                            <CopyIcon />
+                  {account.apiKeys.map((apiKey) => {
+                    const key = newApiKey && newApiKey.name == apiKey.name ? newApiKey.key : undefined
+                    return (
+                      <ListItem key={apiKey.name}>
+                        <ListItemText
+                          primary={apiKey.name}
+                          secondary={key ? `${key} - copy it now, it won't be shown again` : `created ${new Date(apiKey.created).toLocaleString()}`}
+                        />
+                        <ListItemSecondaryAction>
+                          {
+                            key && (
+                              <CopyToClipboard text={key} onCopy={() => snackbar.success('Copied to clipboard')}>
+                                <IconButton edge="end" aria-label="copy" sx={{ mr: 2 }}>
+                                  <CopyIcon />
+                                </IconButton>
+                              </CopyToClipboard>
+                            )
+                          }
+                          <IconButton edge="end" aria-label="delete" onClick={() => handleDeleteApiKey(apiKey.name)}>
+                            <DeleteIcon />
                           </IconButton>
-                        </CopyToClipboard>
-                        <IconButton edge="end" aria-label="delete" onClick={() => handleDeleteApiKey(apiKey.key)}>
-                          <DeleteIcon />
-                        </IconButton>
-                      </ListItemSecondaryAction>
-                    </ListItem>
-                  ))}
+                        </ListItemSecondaryAction>
+                      </ListItem>
+                    )
+                  })}
                 </List>
               </Paper>
 
@@ -235,6 +275,17 @@ const Account: FC = () => {
           </Grid>
         </Box>
       </Box>
+      {
+        creatingApiKey && (
+          <EditTextWindow
+            title="New API key"
+            submitTitle="Create"
+            label="A name for the key, e.g. laptop"
+            onCancel={ () => setCreatingApiKey(false) }
+            onSubmit={ handleCreateApiKey }
+          />
+        )
+      }
     </Container>
   )
 }
@@ -189,6 +189,9 @@ const Dashboard: FC = () => {
           {
             data?.runners.map((runner) => {
               const allSessions = runner.model_instances.reduce<ISessionSummary[]>((allSessions, modelInstance) => {
+                if(modelInstance.active_sessions && modelInstance.active_sessions.length > 0) {
+                  return [ ...allSessions, ...modelInstance.active_sessions ]
+                }
                 return modelInstance.current_session ? [ ...allSessions, modelInstance.current_session ] : allSessions
               }, [])
               return allSessions.length > 0 ? (
@@ -214,12 +217,22 @@ const Dashboard: FC = () => {
           }
           {
             data.session_queue.map((session) => {
+              const decisions = data.session_scheduling_decisions?.[session.session_id] || []
               return (
-                <SessionSummary
-                  key={ session.session_id }
-                  session={ session }
-                  onViewSession={ onViewSession }
-                />
+                <React.Fragment key={ session.session_id }>
+                  <SessionSummary
+                    session={ session }
+                    onViewSession={ onViewSession }
+                  />
+                  {
+                    decisions.length > 0 && (
+                      <SchedulingDecisionSummary
+                        decision={ decisions[0] }
+                        onViewSession={ onViewSession }
+                      />
+                    )
+                  }
+                </React.Fragment>
               )
             })
           }
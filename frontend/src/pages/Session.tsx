@@ -49,11 +49,13 @@ import {
   SESSION_TYPE_TEXT,
   SESSION_MODE_FINETUNE,
   WEBSOCKET_EVENT_TYPE_SESSION_UPDATE,
+  WEBSOCKET_EVENT_TYPE_DATA_PREP_CHUNK,
   IShareSessionInstructions,
 } from '../types'
 
 import {
   getSystemInteraction,
+  applyDataPrepChunkUpdate,
 } from '../utils/session'
 
 const Session: FC = () => {
@@ -437,6 +439,9 @@ const Session: FC = () => {
     if(parsedData.type === WEBSOCKET_EVENT_TYPE_SESSION_UPDATE && parsedData.session) {
       const newSession: ISession = parsedData.session
       session.setData(newSession)
+    } else if(parsedData.type === WEBSOCKET_EVENT_TYPE_DATA_PREP_CHUNK && parsedData.data_prep_chunk) {
+      const update = parsedData.data_prep_chunk
+      session.setData(currentSession => currentSession ? applyDataPrepChunkUpdate(currentSession, update) : currentSession)
     }
   })
 
@@ -14,15 +14,26 @@ export type ISessionOriginType = 'user_created' | 'cloned'
 export const SESSION_ORIGIN_TYPE_USER_CREATED: ISessionOriginType = 'user_created'
 export const SESSION_ORIGIN_TYPE_CLONED: ISessionOriginType = 'cloned'
 
-export type IInteractionState = 'waiting' | 'editing' | 'complete' | 'error'
+export type IInteractionState = 'waiting' | 'editing' | 'complete' | 'error' | 'interrupted'
 export const INTERACTION_STATE_WAITING: IInteractionState = 'waiting'
 export const INTERACTION_STATE_EDITING: IInteractionState = 'editing'
 export const INTERACTION_STATE_COMPLETE: IInteractionState = 'complete'
 export const INTERACTION_STATE_ERROR: IInteractionState = 'error'
+export const INTERACTION_STATE_INTERRUPTED: IInteractionState = 'interrupted'
 
-export type IWebSocketEventType = 'session_update' | 'worker_task_response'
+export type IRunnerErrorCode = 'model_error' | 'out_of_memory'
+export const RUNNER_ERROR_CODE_MODEL_ERROR: IRunnerErrorCode = 'model_error'
+export const RUNNER_ERROR_CODE_OUT_OF_MEMORY: IRunnerErrorCode = 'out_of_memory'
+
+export type IFeedbackRating = 'up' | 'down'
+export const FEEDBACK_RATING_UP: IFeedbackRating = 'up'
+export const FEEDBACK_RATING_DOWN: IFeedbackRating = 'down'
+
+export type IWebSocketEventType = 'session_update' | 'worker_task_response' | 'data_prep_chunk' | 'stream_delta'
 export const WEBSOCKET_EVENT_TYPE_SESSION_UPDATE: IWebSocketEventType = 'session_update'
 export const WEBSOCKET_EVENT_TYPE_WORKER_TASK_RESPONSE: IWebSocketEventType = 'worker_task_response'
+export const WEBSOCKET_EVENT_TYPE_DATA_PREP_CHUNK: IWebSocketEventType = 'data_prep_chunk'
+export const WEBSOCKET_EVENT_TYPE_STREAM_DELTA: IWebSocketEventType = 'stream_delta'
 
 export type IWorkerTaskResponseType = 'stream' | 'progress' | 'result'
 export const WORKER_TASK_RESPONSE_TYPE_STREAM: IWorkerTaskResponseType = 'stream'
@@ -69,15 +80,26 @@ export interface IUserConfig {
   stripe_subscription_active?: boolean,
   stripe_customer_id?: string,
   stripe_subscription_id?: string,
+  max_concurrent_sessions?: number,
 }
 
 export type IOwnerType = 'user' | 'system' | 'org';
 
+// a limit of 0 means there is no limit
+export interface ISessionQuotaUsage {
+  owner_type: IOwnerType,
+  owner: string,
+  active: number,
+  limit: number,
+}
+
 export interface IApiKey {
   owner: string;
   owner_type: string;
-  key: string;
+  // only returned when the key is created
+  key?: string;
   name: string;
+  created: string;
 }
 
 export interface IFileStoreBreadcrumb {
@@ -113,12 +135,34 @@ export interface IWorkerTaskResponse {
   status?: string,
   files?: string[],
   error?: string,
+  error_detail?: string,
+  error_code?: IRunnerErrorCode,
 }
 
 export interface IDataPrepChunk {
   index: number,
+  prompt_name?: string,
   question_count: number,
   error: string,
+  document_id?: string,
+  // set on the chunks that run across the join from the document before
+  spans_document_id?: string,
+}
+
+export interface IDataPrepChunkUpdate {
+  interaction_id: string,
+  filename: string,
+  stage: ITextDataPrepStage,
+  chunk: IDataPrepChunk,
+  progress: number,
+  status: string,
+}
+
+export interface IStreamDelta {
+  interaction_id: string,
+  delta?: string,
+  done?: boolean,
+  interaction?: IInteraction,
 }
 
 export interface IDataPrepStats {
@@ -133,6 +177,16 @@ export interface IDataPrepChunkWithFilename extends IDataPrepChunk {
   filename: string,
 }
 
+export interface IDataPrepCostEstimate {
+  model: string,
+  chunks: number,
+  requests: number,
+  input_tokens: number,
+  output_tokens: number,
+  cost_usd: number,
+  priced: boolean,
+}
+
 export interface IInteractionMessage {
   role: string,
   content: string,
@@ -158,6 +212,54 @@ export interface IInteraction {
   lora_dir: string,
   data_prep_chunks: Record<string, IDataPrepChunk[]>,
   data_prep_stage: ITextDataPrepStage,
+  usage?: IUsage,
+  request_id?: string,
+  error_detail?: string,
+  error_code?: IRunnerErrorCode,
+  log_file?: string,
+  tool_request?: IToolActionRequest,
+  timings?: IInteractionTimings,
+  retried_attempts?: string[],
+  prompt?: string,
+  feedback?: IInteractionFeedback,
+}
+
+export interface IInteractionFeedback {
+  rating: IFeedbackRating,
+  comment?: string,
+  rater: string,
+  created: string,
+}
+
+export interface IFeedbackCounts {
+  up: number,
+  down: number,
+  comments: number,
+}
+
+export interface ISessionFeedbackStats extends IFeedbackCounts {
+  session_id: string,
+  model_name: string,
+}
+
+export interface IFeedbackStats {
+  sessions: number,
+  total: IFeedbackCounts,
+  models: Record<string, IFeedbackCounts>,
+}
+
+// started and first_token come from the runner's clock, generation is a
+// duration string e.g. "1.5s"
+export interface IInteractionTimings {
+  started: string,
+  first_token: string,
+  generation: string,
+}
+
+export interface IUsage {
+  prompt_tokens: number,
+  completion_tokens: number,
+  total_tokens: number,
 }
 
 export interface ISessionOrigin {
@@ -175,6 +277,10 @@ export interface ISessionConfig {
   document_ids: Record<string, string>,
   document_group_id: string,
   manually_review_questions: boolean,
+  sensitive?: boolean,
+  span_documents?: boolean,
+  data_prep_chunk_size?: number,
+  data_prep_concurrency?: number,
   system_prompt: string,
   helix_version: string,
   eval_run_id: string,
@@ -185,6 +291,15 @@ export interface ISessionConfig {
   eval_automatic_score: string,
   eval_automatic_reason: string,
   eval_original_user_prompts: string[],
+  sampling?: ISamplingParams,
+  env?: Record<string, string>,
+}
+
+export interface ISamplingParams {
+  temperature?: number,
+  top_p?: number,
+  max_tokens?: number,
+  stop?: string[],
 }
 
 export interface ISession {
@@ -229,6 +344,8 @@ export interface IWebsocketEvent {
   owner: string,
   session?: ISession,
   worker_task_response?: IWorkerTaskResponse,
+  data_prep_chunk?: IDataPrepChunkUpdate,
+  stream_delta?: IStreamDelta,
 }
 
 export interface IServerConfig {
@@ -259,6 +376,7 @@ export interface IModelInstanceState {
   lora_dir: string,
   initial_session_id: string,
   current_session?: ISessionSummary | null,
+  active_sessions?: ISessionSummary[],
   job_history: ISessionSummary[],
   timeout: number,
   last_activity: number,
@@ -266,6 +384,37 @@ export interface IModelInstanceState {
   memory: number,
 }
 
+export type IModelCapability = 'streaming' | 'vision' | 'finetune'
+
+export interface IModelCapabilities {
+  streaming: boolean,
+  vision: boolean,
+  finetune: boolean,
+}
+
+export interface IModelInfo {
+  name: string,
+  type: ISessionType,
+  runtime: string,
+  aliases: string[],
+  modes: string[],
+  memory_requirements: Record<string, number>,
+  capabilities: IModelCapabilities,
+}
+
+export interface IModelInstanceJob {
+  runner_id: string,
+  model_instance_id: string,
+  interaction_id: string,
+  session_id: string,
+  model_name: string,
+  mode: ISessionMode,
+  started: string,
+  completed: string,
+  state: IInteractionState,
+  error: string,
+}
+
 export interface IRunnerState {
   id: string,
   created: string,
@@ -274,12 +423,16 @@ export interface IRunnerState {
   labels: Record<string, string>,
   model_instances: IModelInstanceState[],
   scheduling_decisions: string[],
+  cordoned?: boolean,
+  prepare_queue_depth?: number,
+  preparing_sessions?: number,
 }
 
 export interface ISessionFilterModel {
   mode: ISessionMode,
   model_name?: string,
   lora_dir?: string,
+  env?: string,
 }
 export interface ISessionFilter {
   mode?: ISessionMode | "",
@@ -291,6 +444,8 @@ export interface ISessionFilter {
   older?: string,
 }
 
+export type ISchedulingDecisionReason = 'assigned' | 'rejected_by_filter' | 'insufficient_memory' | 'too_recent'
+
 export interface  IGlobalSchedulingDecision {
   created: string,
   runner_id: string,
@@ -299,12 +454,17 @@ export interface  IGlobalSchedulingDecision {
   filter: ISessionFilter,
   mode: ISessionMode,
   model_name: string,
+  reason: ISchedulingDecisionReason,
+  message: string,
+  updated_before?: string,
+  count: number,
 }
 
 export interface IDashboardData {
   session_queue: ISessionSummary[],
   runners: IRunnerState[],
   global_scheduling_decisions: IGlobalSchedulingDecision[],
+  session_scheduling_decisions: Record<string, IGlobalSchedulingDecision[]>,
 }
 
 export interface ISessionSummary {
@@ -321,6 +481,9 @@ export interface ISessionSummary {
   owner: string,
   lora_dir?: string,
   summary: string,
+  usage?: IUsage,
+  state?: IInteractionState,
+  error?: string,
 }
 
 export interface ISessionMetaUpdate {
@@ -394,14 +557,26 @@ export interface IToolApiAction {
   description: string,
   method: string,
   path: string,
+  servers?: string[],
+  response_transform?: string,
+  body_template?: string,
+}
+
+export interface IToolActionRequest {
+  method: string,
+  url: string,
+  headers: Record<string, string>,
 }
 
 export interface IToolApiConfig {
   url: string,
   schema: string,
   actions: IToolApiAction[],
+  servers?: string[],
+  server_index?: number,
   headers: Record<string, string>,
   query: Record<string, string>,
+  max_response_size?: number,
 }
 
 export interface IToolConfig {
@@ -418,4 +593,16 @@ export interface ITool {
   description: string,
   tool_type: IToolType,
   config: IToolConfig,
+  actions_diff?: IToolActionsDiff,
+}
+
+export interface IToolActionRename {
+  from: string,
+  to: string,
+}
+
+export interface IToolActionsDiff {
+  added: string[],
+  removed: string[],
+  renamed: IToolActionRename[],
 }
\ No newline at end of file
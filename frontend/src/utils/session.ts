@@ -7,7 +7,9 @@ import {
   IInteraction,
   ITextDataPrepStage,
   IModelInstanceState,
+  IDataPrepChunk,
   IDataPrepChunkWithFilename,
+  IDataPrepChunkUpdate,
   IDataPrepStats,
   SESSION_CREATOR_SYSTEM,
   SESSION_MODE_FINETUNE,
@@ -184,6 +186,32 @@ export const getTextDataPrepErrors = (interaction: IInteraction): IDataPrepChunk
   }, [])
 }
 
+// apply a single finished chunk from the websocket to the session
+// so the chunk shows up without waiting for the next session update
+export const applyDataPrepChunkUpdate = (session: ISession, update: IDataPrepChunkUpdate): ISession => {
+  return {
+    ...session,
+    interactions: session.interactions.map(interaction => {
+      if(interaction.id != update.interaction_id) return interaction
+      const chunks = (interaction.data_prep_chunks || {})[update.filename] || []
+      const isSameChunk = (chunk: IDataPrepChunk) => chunk.index == update.chunk.index && (chunk.prompt_name || '') == (update.chunk.prompt_name || '')
+      const newChunks = chunks.find(isSameChunk) ?
+        chunks.map(chunk => isSameChunk(chunk) ? update.chunk : chunk) :
+        chunks.concat([update.chunk])
+      return {
+        ...interaction,
+        data_prep_stage: update.stage,
+        progress: update.progress,
+        status: update.status,
+        data_prep_chunks: {
+          ...interaction.data_prep_chunks,
+          [update.filename]: newChunks,
+        },
+      }
+    }),
+  }
+}
+
 export const getTextDataPrepStats = (interaction: IInteraction): IDataPrepStats => {
   return Object.keys(interaction.data_prep_chunks || {}).reduce((acc: IDataPrepStats, filename: string) => {
     const chunks = interaction.data_prep_chunks[filename] || []
@@ -9,9 +9,22 @@ def print(*args, **kwargs):
     kwargs['flush'] = True
     return builtins.print(*args, **kwargs)
 
+# the runner tells us to stop once the output has hit a stop sequence
+def should_stop(stopTaskURL, session_id):
+    if stopTaskURL == "":
+        return False
+    try:
+        response = requests.get(stopTaskURL)
+    except requests.exceptions.RequestException:
+        return False
+    if response.status_code != 200:
+        return False
+    return json.loads(response.content).get("session_id", "") == session_id
+
 def do_inference():
     getJobURL = os.environ.get("HELIX_NEXT_TASK_URL", None)
     readSessionURL = os.environ.get("HELIX_INITIAL_SESSION_URL", "")
+    stopTaskURL = os.environ.get("HELIX_STOP_TASK_URL", "")
     mockError = os.environ.get("HELIX_MOCK_ERROR", "")
     mockDelay = os.environ.get("HELIX_MOCK_DELAY", "")
 
@@ -73,6 +86,8 @@ def do_inference():
         for i in range(1, 10):
             print(f"hello{i} ")
             time.sleep(0.1)
+            if should_stop(stopTaskURL, session_id):
+                break
         
         print(f"</s>")
         print(f" [SESSION_END]session_id={session_id} ")
@@ -3,6 +3,8 @@ import sys
 import requests
 import time
 import json
+import shutil
+import tempfile
 import builtins
 
 def print(*args, **kwargs):
@@ -68,7 +70,14 @@ def do_inference():
         print(f" [SESSION_START]session_id={session_id} ", file=sys.stdout, flush=True)
 
         for i in range(1, 101):
-          print(f"{i}%|\n")
+          print(f" [SESSION_PROGRESS]step={i}/100 ", file=sys.stdout, flush=True)
+          # the runner deletes previews once they are uploaded so it gets a copy
+          if i == 50:
+            preview_dir = os.path.join(tempfile.gettempdir(), "helix-previews")
+            os.makedirs(preview_dir, exist_ok=True)
+            preview_path = os.path.join(preview_dir, f"preview_{session_id}.png")
+            shutil.copyfile(image_path, preview_path)
+            print(f" [SESSION_PREVIEW]image={preview_path} ", file=sys.stdout, flush=True)
           time.sleep(0.1)
         
         print(f" [SESSION_END_IMAGES]images=[\"{image_path}\"] ", file=sys.stdout, flush=True)